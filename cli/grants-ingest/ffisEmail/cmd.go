@@ -0,0 +1,56 @@
+package ffisEmail
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/testsupport"
+)
+
+type Cmd struct {
+	// Positional arguments
+	SpecFile string `arg:"" name:"spec" type:"existingfile" predictor:"file" help:"JSON file describing the email to generate"`
+
+	// Flags
+	Output string `short:"o" type:"path" predictor:"file" help:"File to write the generated email to (defaults to stdout)"`
+}
+
+func (cmd *Cmd) Help() string {
+	return `
+The provided <spec> is a JSON object whose fields correspond to those of testsupport.FFISEmail,
+for example:
+
+  {"links": [{"url": "https://mcusercontent.com/123456/files/file-01.xlsx"}],
+   "encoding": "quoted-printable", "nesting": 1}
+
+Generated emails are deterministic, so the same spec always produces the same output, which makes
+them suitable for committing as golden test fixtures.`
+}
+
+func (cmd *Cmd) Run(app *kong.Kong, logger *log.Logger) error {
+	specData, err := os.ReadFile(cmd.SpecFile)
+	if err != nil {
+		return log.Errorf(*logger, "Error reading email spec", err)
+	}
+	var spec testsupport.FFISEmail
+	if err := json.Unmarshal(specData, &spec); err != nil {
+		return log.Errorf(*logger, "Error parsing email spec", err)
+	}
+	email, err := spec.Build()
+	if err != nil {
+		return log.Errorf(*logger, "Error generating email", err)
+	}
+
+	if cmd.Output == "" {
+		_, err = app.Stdout.Write(email)
+	} else {
+		err = os.WriteFile(cmd.Output, email, 0644)
+	}
+	if err != nil {
+		return log.Errorf(*logger, "Error writing generated email", err)
+	}
+	log.Debug(*logger, "Generated email", "spec", cmd.SpecFile, "bytes", len(email))
+	return nil
+}
@@ -7,8 +7,11 @@ import (
 	kitLog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/posener/complete"
+	"github.com/usdigitalresponse/grants-ingest/cli/grants-ingest/ffisEmail"
 	"github.com/usdigitalresponse/grants-ingest/cli/grants-ingest/ffisImport"
 	"github.com/usdigitalresponse/grants-ingest/cli/grants-ingest/purgeData"
+	"github.com/usdigitalresponse/grants-ingest/cli/grants-ingest/sqsPoll"
+	"github.com/usdigitalresponse/grants-ingest/cli/grants-ingest/validateEmails"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	"github.com/willabides/kongplete"
 )
@@ -36,8 +39,11 @@ func (g Globals) AfterApply(app *kong.Kong, logger *log.Logger) error {
 type CLI struct {
 	Globals
 
-	FFISImport ffisImport.Cmd `cmd:"ffis-import" help:"Import FFIS spreadsheets to S3."`
-	Purge      purgeData.Cmd  `cmd:"purge" help:"Purge data from various locations."`
+	FFISImport     ffisImport.Cmd     `cmd:"ffis-import" help:"Import FFIS spreadsheets to S3."`
+	FFISEmail      ffisEmail.Cmd      `cmd:"ffis-email" help:"Generate a synthetic FFIS email for testing."`
+	ValidateEmails validateEmails.Cmd `cmd:"validate-emails" help:"Check that stored emails can still be parsed."`
+	SQSPoll        sqsPoll.Cmd        `cmd:"sqs-poll" help:"Deliver SQS messages to a locally-run Lambda function."`
+	Purge          purgeData.Cmd      `cmd:"purge" help:"Purge data from various locations."`
 
 	Completion kongplete.InstallCompletions `cmd:"" help:"Install shell completions"`
 }
@@ -50,6 +56,7 @@ func main() {
 		kong.UsageOnError(),
 		kong.ConfigureHelp(kong.HelpOptions{Compact: true}),
 		kong.Bind(&logger),
+		sqsPoll.Vars,
 	)
 
 	kongplete.Complete(parser,
@@ -0,0 +1,103 @@
+package sqsPoll
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+type Cmd struct {
+	// Positional arguments
+	QueueURL string `arg:"" name:"queue-url" help:"URL of the SQS queue to poll"`
+
+	// Flags
+	InvokeURL         string        `name:"invoke-url" help:"Invocation endpoint of the local Lambda function" default:"${invoke_url}"`
+	EndpointURL       string        `name:"endpoint-url" help:"SQS endpoint URL, e.g. http://localhost:9324 for ElasticMQ (defaults to the AWS SDK's resolution)"`
+	BatchSize         int           `name:"batch-size" help:"Maximum number of messages in each invocation (1 to 10)" default:"1"`
+	MaxMessages       int           `name:"max-messages" help:"Stop after handling this many messages (0 for no limit)" default:"0"`
+	Once              bool          `help:"Stop after a single receive request"`
+	WaitTime          int           `name:"wait-time" help:"Seconds that each receive request waits for messages to arrive (0 to 20)" default:"${wait_time}"`
+	VisibilityTimeout int           `name:"visibility-timeout" help:"Visibility timeout in seconds of received messages (0 for the queue's default)" default:"0"`
+	ShutdownTimeout   time.Duration `name:"shutdown-timeout" help:"How long to wait for the invocation in progress when stopped (0 to wait indefinitely)" default:"30s"`
+}
+
+// Vars provides the defaults that are interpolated into the flags of Cmd.
+var Vars = kong.Vars{
+	"invoke_url": eventHelpers.DefaultFunctionInvokeURL,
+	"wait_time":  fmt.Sprint(eventHelpers.DefaultSQSPollerWaitTime),
+}
+
+func (cmd *Cmd) Help() string {
+	return `
+Messages received from <queue-url> are delivered to a Lambda function that is run locally (e.g. by the
+Lambda Runtime Interface Emulator or "sam local start-lambda"), as the Lambda event source mapping does
+when the function is deployed, which makes it possible to develop SQS consumers against a LocalStack,
+ElasticMQ, or genuine SQS queue without redeploying them. Successfully-handled messages are deleted,
+and messages that failed are left in the queue for redelivery.
+The first interrupt (e.g. Ctrl-C) or SIGTERM stops polling once the invocation in progress (if any)
+is done, or once the shutdown timeout elapses; a second signal exits immediately.
+The command fails when any message failed.`
+}
+
+func (cmd *Cmd) Run(app *kong.Kong, logger *log.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Restore the default behavior of signals once stopping, so that a second signal exits
+		<-ctx.Done()
+		stop()
+	}()
+
+	cfg, err := awsHelpers.GetConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to configure AWS SDK: %w", err)
+	}
+	var sqssvc *sqs.Client
+	if cmd.EndpointURL != "" {
+		sqssvc = sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			//lint:ignore SA1019 we need to update this eventually, but should not block release
+			o.EndpointResolver = sqs.EndpointResolverFromURL(cmd.EndpointURL)
+		})
+	} else if sqssvc, err = awsHelpers.GetSQSClient(ctx); err != nil {
+		return err
+	}
+
+	waitTime := cmd.WaitTime
+	if waitTime == 0 {
+		waitTime = -1 // SQSPoller uses the default wait time when unset
+	}
+	poller := eventHelpers.SQSPoller{
+		Client:            sqssvc,
+		QueueURL:          cmd.QueueURL,
+		Region:            cfg.Region,
+		Handler:           eventHelpers.InvokeFunctionHandler(http.DefaultClient, cmd.InvokeURL),
+		Logger:            *logger,
+		BatchSize:         cmd.BatchSize,
+		WaitTime:          waitTime,
+		VisibilityTimeout: cmd.VisibilityTimeout,
+		MaxMessages:       cmd.MaxMessages,
+		Once:              cmd.Once,
+		ShutdownTimeout:   cmd.ShutdownTimeout,
+	}
+	log.Info(*logger, "Polling queue for messages; interrupt to stop",
+		"queue_url", cmd.QueueURL, "invoke_url", cmd.InvokeURL)
+	stats, err := poller.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return log.Errorf(*logger, "Messages failed", fmt.Errorf(
+			"%d of %d messages failed", stats.Failed, stats.Received))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package validateEmails
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/emailHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+type Cmd struct {
+	// Positional arguments
+	S3Bucket string `arg:"" name:"bucket" help:"S3 bucket in which the emails are stored"`
+
+	// Flags
+	S3Prefix       string `name:"s3-prefix" help:"Key prefix under which the emails are stored" default:"sources/"`
+	S3UsePathStyle bool   `name:"s3-use-path-style" help:"Use path-style addressing for S3 bucket"`
+}
+
+func (cmd *Cmd) Help() string {
+	return `
+Every email stored under the S3 prefix of <bucket> is parsed with the same logic that is used to
+receive new emails, without writing anything, in order to check that a parser change still accepts
+the emails that have already been archived. SES notifications are skipped, and senders are not
+verified, since that depends on the configuration at the time each email was received.
+A summary is printed that lists each email that failed to parse, in which case the command fails.`
+}
+
+func (cmd *Cmd) Run(app *kong.Kong, logger *log.Logger) error {
+	ctx := context.Background()
+	cfg, err := awsHelpers.GetConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to configure AWS SDK: %w", err)
+	}
+	s3svc := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = cmd.S3UsePathStyle })
+
+	summary, err := emailHelpers.BulkValidate(ctx, *logger, s3svc, cmd.S3Bucket, cmd.S3Prefix)
+	if err != nil {
+		return err
+	}
+	summary.Print(app.Stdout)
+	if len(summary.Failures) > 0 {
+		return log.Errorf(*logger, "Stored emails failed to parse", fmt.Errorf(
+			"%d of %d stored emails failed to parse", len(summary.Failures), summary.Objects))
+	}
+	return nil
+}
@@ -3,14 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"github.com/google/uuid"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 
 	"github.com/aws/aws-lambda-go/events"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -18,13 +21,17 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 )
 
 // error constants
 var (
-	ErrDownloadFailed = fmt.Errorf("error downloading file")
+	ErrDownloadFailed     = fmt.Errorf("error downloading file")
+	ErrInvalidDownloadURL = fmt.Errorf("invalid download URL")
+	ErrDownloadTooLarge   = fmt.Errorf("download exceeds maximum size")
 )
 
 type S3UploaderAPI interface {
@@ -37,7 +44,12 @@ type HTTPClientAPI interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func handleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent, s3Uploader S3UploaderAPI, httpClient HTTPClientAPI) error {
+// handleSQSEvent downloads the file referenced by the SQS message to the destination bucket.
+// Messages are skipped without side effects while the download feature is disabled.
+func handleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent, s3Uploader S3UploaderAPI, s3Client S3API, httpClient HTTPClientAPI) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureDownload, len(sqsEvent.Records)) {
+		return nil
+	}
 	msg := sqsEvent.Records[0].Body
 	log.Info(logger, "Received message", "message", msg)
 	var ffisMessage ffis.FFISMessageDownload
@@ -45,33 +57,128 @@ func handleSQSEvent(ctx context.Context, sqsEvent events.SQSEvent, s3Uploader S3
 	if err != nil {
 		return fmt.Errorf("error unmarshalling SQS message: %w", err)
 	}
-	fileStream, err := downloadFile(ctx, ffisMessage, httpClient)
+	downloadURL, err := validateDownloadURL(ffisMessage.DownloadURL)
+	if err != nil {
+		return fmt.Errorf("error parsing SQS message: %w", err)
+	}
+	destinationKey := destinationKeyForSource(ffisMessage.SourceFileKey)
+	if env.DryRun {
+		log.Info(logger, "Dry run enabled; skipping download",
+			"url", ffisMessage.DownloadURL, "sourceKey", ffisMessage.SourceFileKey,
+			"destinationBucket", env.DestinationBucket, "destinationKey", destinationKey)
+		sendMetric("download.dry_run", 1, fmt.Sprintf("host:%s", downloadURL.Hostname()))
+		return nil
+	}
+	cleanupOrphanedTempObjects(ctx, s3Client)
+	fileStream, chain, err := downloadFile(ctx, ffisMessage, httpClient)
 	if err != nil {
 		return fmt.Errorf("error parsing SQS message: %w", err)
 	}
 	defer fileStream.Close()
-	err = writeToS3(ctx, s3Uploader, fileStream, ffisMessage.SourceFileKey)
-	return err
+	err = writeToS3(ctx, s3Uploader, s3Client, fileStream, ffisMessage.SourceFileKey, chain.metadata())
+	if err != nil {
+		return err
+	}
+	log.Info(logger, "Download completed", "url", ffisMessage.DownloadURL,
+		"finalURL", chain.FinalURL, "redirectCount", len(chain.Hops), "redirectChain", chain.String(),
+		"destinationBucket", env.DestinationBucket, "destinationKey", destinationKey)
+	sendMetric("download.completed", 1, fmt.Sprintf("redirects:%d", len(chain.Hops)))
+	return nil
 }
 
-func downloadFile(ctx context.Context, msg ffis.FFISMessageDownload, httpClient HTTPClientAPI) (stream io.ReadCloser, err error) {
-	resp, err := startDownload(ctx, httpClient, msg.DownloadURL)
+// downloadFile starts downloading the file at the message's download URL and returns the
+// response body. When env.MaxDownloadBytes is positive, files that are known to exceed that
+// size (according to a preliminary HEAD request) are refused before downloading, and the
+// returned stream fails once more than env.MaxDownloadBytes have been read from it.
+// The returned redirectChain describes the redirects that were followed to reach the file.
+func downloadFile(ctx context.Context, msg ffis.FFISMessageDownload, httpClient HTTPClientAPI) (stream io.ReadCloser, chain redirectChain, err error) {
+	if env.MaxDownloadBytes > 0 {
+		if err := checkDownloadSize(ctx, httpClient, msg.DownloadURL); err != nil {
+			return nil, chain, fmt.Errorf("error downloading file: %w", err)
+		}
+	}
+	downloadCtx, hops := withRedirectRecorder(ctx)
+	resp, err := startDownload(downloadCtx, httpClient, msg.DownloadURL)
 	if err != nil {
-		return nil, fmt.Errorf("error downloading file: %w", err)
+		return nil, chain, fmt.Errorf("error downloading file: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error downloading file: %w", ErrDownloadFailed)
+		return nil, chain, fmt.Errorf("error downloading file: %w", ErrDownloadFailed)
 	}
-	log.Debug(logger, "Downloaded file", "url", msg.DownloadURL)
+	chain = redirectChain{Hops: *hops, FinalURL: msg.DownloadURL}
+	if resp.Request != nil {
+		chain.FinalURL = resp.Request.URL.String()
+	}
+	log.Debug(logger, "Downloaded file", "url", msg.DownloadURL, "finalURL", chain.FinalURL)
 	sendMetric("source_size", float64(resp.ContentLength))
-	return resp.Body, nil
+	if env.MaxDownloadBytes > 0 {
+		return &maxBytesReadCloser{ReadCloser: resp.Body, remaining: env.MaxDownloadBytes}, chain, nil
+	}
+	return resp.Body, chain, nil
+}
+
+// checkDownloadSize issues a HEAD request for url and returns an error wrapping
+// ErrDownloadTooLarge when the response's Content-Length exceeds env.MaxDownloadBytes.
+// Origins that reject the HEAD request or omit Content-Length are not refused here;
+// their downloads are instead limited while streaming.
+func checkDownloadSize(ctx context.Context, httpClient HTTPClientAPI, url string) error {
+	resp, err := sendRequest(ctx, httpClient, http.MethodHead, url, "download.head")
+	if err != nil {
+		log.Warn(logger, "HEAD request failed; download size will be checked while streaming",
+			"url", url, "error", err)
+		return nil
+	}
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		log.Debug(logger, "Download size is unknown; download size will be checked while streaming",
+			"url", url, "status", resp.StatusCode)
+		return nil
+	}
+	if resp.ContentLength > env.MaxDownloadBytes {
+		sendMetric("download.too_large", 1, "check:head")
+		return fmt.Errorf("%w: Content-Length of %d bytes exceeds limit of %d bytes",
+			ErrDownloadTooLarge, resp.ContentLength, env.MaxDownloadBytes)
+	}
+	return nil
+}
+
+// maxBytesReadCloser wraps a download stream and returns an error wrapping
+// ErrDownloadTooLarge once more than the remaining number of bytes are read.
+type maxBytesReadCloser struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (r *maxBytesReadCloser) Read(p []byte) (int, error) {
+	// Read at most one byte more than remaining in order to detect when the limit is exceeded
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) <= r.remaining {
+		r.remaining -= int64(n)
+		return n, err
+	}
+	n = int(r.remaining)
+	r.remaining = 0
+	sendMetric("download.too_large", 1, "check:stream")
+	return n, fmt.Errorf("%w: read more than %d bytes", ErrDownloadTooLarge, env.MaxDownloadBytes)
 }
 
 // startDownload starts a new download request and returns the response.
 // Failed requests retry until env.MaxDownloadBackoff elapses.
 // Returns a non-nil error if the request either could not be initialized or never succeeded.
 func startDownload(ctx context.Context, httpClient HTTPClientAPI, url string) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	return sendRequest(ctx, httpClient, http.MethodGet, url, "download.start")
+}
+
+// sendRequest sends an HTTP request with the given method to url and returns the response,
+// retrying failed requests until env.MaxDownloadBackoff elapses. Requests that fail because
+// they followed too many redirects are not retried.
+func sendRequest(ctx context.Context, httpClient HTTPClientAPI, method, url, spanName string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -79,27 +186,94 @@ func startDownload(ctx context.Context, httpClient HTTPClientAPI, url string) (r
 	b := backoff.NewExponentialBackOff()
 	b.MaxElapsedTime = env.MaxDownloadBackoff
 	attempt := 1
-	span, spanCtx := tracer.StartSpanFromContext(ctx, "download.start")
+	span, spanCtx := tracing.StartSpanFromContext(ctx, spanName)
 	err = backoff.RetryNotify(func() (err error) {
-		attemptSpan, _ := tracer.StartSpanFromContext(spanCtx, fmt.Sprintf("attempt.%d", attempt))
+		attemptSpan, _ := tracing.StartSpanFromContext(spanCtx, fmt.Sprintf("attempt.%d", attempt))
 		resp, err = httpClient.Do(req)
-		attemptSpan.Finish(tracer.WithError(err))
+		attemptSpan.Finish(tracing.WithError(err))
+		if errors.Is(err, ErrTooManyRedirects) {
+			sendMetric("download.too_many_redirects", 1)
+			return backoff.Permanent(err)
+		}
 		return err
 	}, b, func(error, time.Duration) { attempt++ })
-	span.Finish(tracer.WithError(err))
+	span.Finish(tracing.WithError(err))
 	return resp, err
 }
 
+// validateDownloadURL parses rawURL and returns an error wrapping ErrInvalidDownloadURL
+// unless it is an absolute HTTP(S) URL.
+func validateDownloadURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDownloadURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDownloadURL, rawURL)
+	}
+	return u, nil
+}
+
+// destinationKeyForSource returns the key of the S3 object to which the file downloaded
+// for the email at sourceKey is written.
+func destinationKeyForSource(sourceKey string) string {
+	return strings.Replace(sourceKey, "ffis.org/raw.eml", "ffis.org/download.xlsx", 1)
+}
+
 // writeToS3 writes the contents of fileStr to the S3 bucket provied by the
-// S3UploaderAPI interface.
-func writeToS3(ctx context.Context, s3Uploader S3UploaderAPI, fileStream io.ReadCloser, sourceKey string) error {
-	destinationKey := strings.Replace(sourceKey, "ffis.org/raw.eml", "ffis.org/download.xlsx", 1)
-	log.Info(logger, "Writing to S3", "sourceKey", sourceKey, "destinationBucket", env.DestinationBucket, "destinationKey", destinationKey)
-	_, err := s3Uploader.Upload(ctx, &s3.PutObjectInput{
+// S3UploaderAPI interface, along with the given user-defined object metadata.
+// The contents are first written to a temporary key, which is verified against the size and
+// checksum of fileStream and then promoted to the destination key, so that the destination
+// object is never created from an incomplete download. The temporary object is deleted
+// when verification fails. When the promoted destination object cannot be verified as
+// readable, it exists but is unverified; this is logged rather than returned, since
+// retrying the download would not make the destination object any more readable.
+func writeToS3(ctx context.Context, s3Uploader S3UploaderAPI, s3Client S3API, fileStream io.ReadCloser, sourceKey string, metadata map[string]string) error {
+	destinationKey := destinationKeyForSource(sourceKey)
+	tempKey := tempKeyPrefix + uuid.NewString()
+	logger := log.With(logger, "sourceKey", sourceKey, "destinationBucket", env.DestinationBucket,
+		"destinationKey", destinationKey, "tempKey", tempKey)
+	log.Info(logger, "Writing to S3")
+	metadata, err := fitDownloadMetadata(logger,
+		awsHelpers.WithProcessorVersion(awsHelpers.WithLambdaRequestID(ctx, metadata)))
+	if err != nil {
+		return err
+	}
+	digest := newDigestReader(fileStream)
+	// The upload manager sends a checksum with each part of a multipart upload
+	_, err = s3Uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket:               aws.String(env.DestinationBucket),
-		Key:                  aws.String(destinationKey),
-		Body:                 fileStream,
+		Key:                  aws.String(tempKey),
+		Body:                 digest,
+		Metadata:             metadata,
 		ServerSideEncryption: types.ServerSideEncryptionAes256,
+		ChecksumAlgorithm:    env.S3ChecksumAlgorithm,
 	})
-	return err
+	if err != nil {
+		return awsHelpers.WrapS3ChecksumError(err)
+	}
+
+	if err := verifyUpload(ctx, s3Client, tempKey, digest.n, digest.hash.Sum(nil)); err != nil {
+		sendMetric("download.verification_failed", 1)
+		if _, delErr := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    aws.String(tempKey),
+		}); delErr != nil {
+			log.Warn(logger, "Failed to delete unverified temporary object", "error", delErr)
+		}
+		return err
+	}
+
+	if err := awsHelpers.MoveS3Object(ctx, s3Client, env.DestinationBucket, tempKey, destinationKey); err != nil {
+		if errors.Is(err, awsHelpers.ErrS3ObjectVerificationFailed) {
+			// The temporary object is kept, since it may be the only readable copy of the download
+			sendMetric("download.promotion_verification_failed", 1)
+			log.Warn(logger, "Promoted download exists but could not be verified as readable",
+				"error", err)
+			return nil
+		}
+		return fmt.Errorf("error promoting download to destination key: %w", err)
+	}
+	log.Debug(logger, "Promoted temporary object to destination key")
+	return nil
 }
@@ -3,45 +3,191 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/go-kit/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 )
 
+type mockS3Object struct {
+	content      []byte
+	metadata     map[string]string
+	lastModified time.Time
+}
+
+// MockS3 is an in-memory implementation of both S3UploaderAPI and S3API.
 type MockS3 struct {
-	content       []byte
-	key           string
-	responseError error
+	content           []byte
+	key               string
+	checksumAlgorithm types.ChecksumAlgorithm
+	metadata          map[string]string
+	responseError     error
+	// checksumSHA256, when non-nil, overrides the checksum reported by HeadObject
+	checksumSHA256 *string
+	// getObjectError, when non-nil, is returned by GetObject
+	getObjectError error
+	objects        map[string]mockS3Object
+	deletedKeys    []string
+	// calls counts requests made to any method, whereas uploads only counts uploads
+	calls   int
+	uploads int
 }
 
 func (mockS3 *MockS3) Upload(ctx context.Context,
 	params *s3.PutObjectInput,
 	optFns ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	mockS3.calls++
+	mockS3.uploads++
 	buf := new(bytes.Buffer)
-	buf.ReadFrom(params.Body)
+	if _, err := buf.ReadFrom(params.Body); err != nil {
+		return nil, err
+	}
 	mockS3.content = buf.Bytes()
 	params.Body.Read(mockS3.content)
 	mockS3.key = *params.Key
-	return &s3manager.UploadOutput{}, mockS3.responseError
+	mockS3.checksumAlgorithm = params.ChecksumAlgorithm
+	mockS3.metadata = params.Metadata
+	if mockS3.responseError != nil {
+		return nil, mockS3.responseError
+	}
+	mockS3.putObject(*params.Key, mockS3Object{mockS3.content, params.Metadata, time.Now()})
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (mockS3 *MockS3) putObject(key string, obj mockS3Object) {
+	if mockS3.objects == nil {
+		mockS3.objects = make(map[string]mockS3Object)
+	}
+	mockS3.objects[key] = obj
+}
+
+func (mockS3 *MockS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	mockS3.calls++
+	obj, ok := mockS3.objects[*params.Key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	sum := sha256.Sum256(obj.content)
+	checksum := aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	if mockS3.checksumSHA256 != nil {
+		checksum = mockS3.checksumSHA256
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:  int64(len(obj.content)),
+		ChecksumSHA256: checksum,
+		Metadata:       obj.metadata,
+	}, nil
+}
+
+func (mockS3 *MockS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	mockS3.calls++
+	if mockS3.getObjectError != nil {
+		return nil, mockS3.getObjectError
+	}
+	obj, ok := mockS3.objects[*params.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	content := obj.content
+	var start, end int
+	if _, err := fmt.Sscanf(aws.ToString(params.Range), "bytes=%d-%d", &start, &end); err == nil {
+		if end >= len(content) {
+			end = len(content) - 1
+		}
+		content = content[start : end+1]
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Metadata:      obj.metadata,
+	}, nil
+}
+
+func (mockS3 *MockS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	mockS3.calls++
+	copySource, err := url.PathUnescape(*params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	_, srcKey, _ := strings.Cut(copySource, "/")
+	obj, ok := mockS3.objects[srcKey]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	mockS3.putObject(*params.Key, mockS3Object{obj.content, obj.metadata, time.Now()})
+	mockS3.key = *params.Key
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (mockS3 *MockS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	mockS3.calls++
+	delete(mockS3.objects, *params.Key)
+	mockS3.deletedKeys = append(mockS3.deletedKeys, *params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (mockS3 *MockS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	mockS3.calls++
+	keys := []string{}
+	for key := range mockS3.objects {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	resp := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		resp.Contents = append(resp.Contents, types.Object{
+			Key:          aws.String(key),
+			LastModified: aws.Time(mockS3.objects[key].lastModified),
+		})
+	}
+	return resp, nil
 }
 
 type MockHTTP struct {
-	testContent   []byte
-	responseError error
-	statusCode    int
+	testContent       []byte
+	responseError     error
+	statusCode        int
+	headStatusCode    int
+	headContentLength int64
+	calls             int
+	methods           []string
 }
 
 func (mockHTTP *MockHTTP) Do(req *http.Request) (resp *http.Response, err error) {
+	mockHTTP.calls++
+	mockHTTP.methods = append(mockHTTP.methods, req.Method)
+	if req.Method == http.MethodHead {
+		headStatusCode := http.StatusOK
+		if mockHTTP.headStatusCode != 0 {
+			headStatusCode = mockHTTP.headStatusCode
+		}
+		return &http.Response{
+			Body:          io.NopCloser(bytes.NewReader(nil)),
+			StatusCode:    headStatusCode,
+			ContentLength: mockHTTP.headContentLength,
+		}, nil
+	}
 	bodyReaderClose := io.NopCloser(bytes.NewReader(mockHTTP.testContent))
 	mockStatusCode := http.StatusOK
 	if mockHTTP.statusCode != 0 {
@@ -84,7 +230,7 @@ func TestHandleSQSEvent(t *testing.T) {
 			}
 			mockUploader := &MockS3{responseError: test.s3Error}
 			mockHTTP := &MockHTTP{testContent: []byte("test content"), responseError: test.httpError, statusCode: test.httpStatusCode}
-			err := handleSQSEvent(context.Background(), sqsEvent, mockUploader, mockHTTP)
+			err := handleSQSEvent(context.Background(), sqsEvent, mockUploader, mockUploader, mockHTTP)
 			// check error content
 			if test.httpError == nil && test.s3Error == nil && test.httpStatusCode <= 200 {
 				if err != nil {
@@ -118,6 +264,329 @@ func TestHandleSQSEvent(t *testing.T) {
 	}
 }
 
+func TestHandleSQSEventDryRun(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.DryRun = true
+	t.Cleanup(func() { env.DryRun = false })
+	sentMetrics := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	for _, test := range []struct {
+		name        string
+		message     ffis.FFISMessageDownload
+		expectedErr error
+	}{
+		{name: "valid message",
+			message: ffis.FFISMessageDownload{DownloadURL: "https://www.example.com/data.xlsx", SourceFileKey: "sources/2023/05/01/ffis.org/raw.eml"}},
+		{name: "invalid download URL",
+			message:     ffis.FFISMessageDownload{DownloadURL: "not a url", SourceFileKey: "sources/2023/05/01/ffis.org/raw.eml"},
+			expectedErr: ErrInvalidDownloadURL},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for k := range sentMetrics {
+				delete(sentMetrics, k)
+			}
+			msgJson, _ := json.Marshal(test.message)
+			sqsEvent := events.SQSEvent{Records: []events.SQSMessage{{Body: string(msgJson)}}}
+			mockUploader := &MockS3{}
+			mockHTTP := &MockHTTP{testContent: []byte("test content")}
+			err := handleSQSEvent(context.Background(), sqsEvent, mockUploader, mockUploader, mockHTTP)
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("Expected error %v, got %v", test.expectedErr, err)
+				}
+				if _, ok := sentMetrics["download.dry_run"]; ok {
+					t.Errorf("Expected no download.dry_run metric for an invalid message")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				tags, ok := sentMetrics["download.dry_run"]
+				if !ok {
+					t.Errorf("Expected download.dry_run metric to be sent")
+				} else if len(tags) != 1 || tags[0] != "host:www.example.com" {
+					t.Errorf("Expected download.dry_run metric tags [host:www.example.com], got %v", tags)
+				}
+			}
+			if mockHTTP.calls != 0 {
+				t.Errorf("Expected no HTTP requests, got %d", mockHTTP.calls)
+			}
+			if mockUploader.calls != 0 {
+				t.Errorf("Expected no S3 uploads, got %d", mockUploader.calls)
+			}
+		})
+	}
+}
+
+func TestHandleSQSEventFeatureDisabled(t *testing.T) {
+	logger = log.NewNopLogger()
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureDownload: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	msgJson, _ := json.Marshal(ffis.FFISMessageDownload{
+		DownloadURL:   "https://www.example.com/data.xlsx",
+		SourceFileKey: "sources/2023/05/01/ffis.org/raw.eml",
+	})
+	sqsEvent := events.SQSEvent{Records: []events.SQSMessage{{Body: string(msgJson)}}}
+	mockS3 := &MockS3{}
+	mockHTTP := &MockHTTP{testContent: []byte("test content")}
+	if err := handleSQSEvent(context.Background(), sqsEvent, mockS3, mockS3, mockHTTP); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if mockHTTP.calls != 0 {
+		t.Errorf("Expected no HTTP requests, got %d", mockHTTP.calls)
+	}
+	if mockS3.calls != 0 {
+		t.Errorf("Expected no S3 requests, got %d", mockS3.calls)
+	}
+	if sentMetrics["stage.skipped_disabled"] != 1 {
+		t.Errorf("Expected stage.skipped_disabled metric of 1, got %v", sentMetrics["stage.skipped_disabled"])
+	}
+}
+
+func TestHandleSQSEventMaxDownloadBytes(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.MaxDownloadBackoff = 100 * time.Millisecond
+	env.MaxDownloadBytes = 10
+	t.Cleanup(func() { env.MaxDownloadBytes = 0 })
+	sentMetrics := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	for _, test := range []struct {
+		name              string
+		content           string
+		headStatusCode    int
+		headContentLength int64
+		expectedMethods   []string
+		expectedCheck     string
+	}{
+		{name: "oversized file refused by HEAD",
+			content: strings.Repeat("x", 100), headContentLength: 100,
+			expectedMethods: []string{http.MethodHead}, expectedCheck: "check:head"},
+		{name: "file within limit",
+			content: "small", headContentLength: 5,
+			expectedMethods: []string{http.MethodHead, http.MethodGet}},
+		{name: "missing Content-Length with oversized file",
+			content: strings.Repeat("x", 100), headContentLength: -1,
+			expectedMethods: []string{http.MethodHead, http.MethodGet}, expectedCheck: "check:stream"},
+		{name: "missing Content-Length with file within limit",
+			content: "small", headContentLength: -1,
+			expectedMethods: []string{http.MethodHead, http.MethodGet}},
+		{name: "HEAD rejected with oversized file",
+			content: strings.Repeat("x", 100), headStatusCode: http.StatusMethodNotAllowed,
+			expectedMethods: []string{http.MethodHead, http.MethodGet}, expectedCheck: "check:stream"},
+		{name: "HEAD rejected with file within limit",
+			content: "small", headStatusCode: http.StatusMethodNotAllowed,
+			expectedMethods: []string{http.MethodHead, http.MethodGet}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for k := range sentMetrics {
+				delete(sentMetrics, k)
+			}
+			msgJson, _ := json.Marshal(ffis.FFISMessageDownload{
+				DownloadURL:   "https://www.example.com",
+				SourceFileKey: "sources/2023/05/01/ffis.org/raw.eml",
+			})
+			sqsEvent := events.SQSEvent{Records: []events.SQSMessage{{Body: string(msgJson)}}}
+			mockUploader := &MockS3{}
+			mockHTTP := &MockHTTP{
+				testContent:       []byte(test.content),
+				headStatusCode:    test.headStatusCode,
+				headContentLength: test.headContentLength,
+			}
+			err := handleSQSEvent(context.Background(), sqsEvent, mockUploader, mockUploader, mockHTTP)
+
+			if strings.Join(mockHTTP.methods, ",") != strings.Join(test.expectedMethods, ",") {
+				t.Errorf("Expected HTTP requests %v, got %v", test.expectedMethods, mockHTTP.methods)
+			}
+			tags, sentTooLarge := sentMetrics["download.too_large"]
+			if test.expectedCheck != "" {
+				if !errors.Is(err, ErrDownloadTooLarge) {
+					t.Errorf("Expected error %v, got %v", ErrDownloadTooLarge, err)
+				}
+				if !sentTooLarge || len(tags) != 1 || tags[0] != test.expectedCheck {
+					t.Errorf("Expected download.too_large metric with tags [%s], got %v", test.expectedCheck, tags)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				if sentTooLarge {
+					t.Errorf("Expected no download.too_large metric")
+				}
+				if string(mockUploader.content) != test.content {
+					t.Errorf("Expected %v, got %v", test.content, string(mockUploader.content))
+				}
+			}
+		})
+	}
+}
+
+func TestDestinationKeyForSource(t *testing.T) {
+	expected := "sources/2023/05/01/ffis.org/download.xlsx"
+	if actual := destinationKeyForSource("sources/2023/05/01/ffis.org/raw.eml"); actual != expected {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestWriteToS3Checksum(t *testing.T) {
+	logger = log.NewNopLogger()
+	for _, alg := range []types.ChecksumAlgorithm{types.ChecksumAlgorithmSha256, types.ChecksumAlgorithmCrc32c} {
+		env.S3ChecksumAlgorithm = alg
+		mockUploader := &MockS3{}
+		err := writeToS3(context.Background(), mockUploader, mockUploader,
+			io.NopCloser(strings.NewReader("test content")), "sources/2023/05/01/ffis.org/raw.eml", nil)
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if mockUploader.checksumAlgorithm != alg {
+			t.Errorf("Expected checksum algorithm %v, got %v", alg, mockUploader.checksumAlgorithm)
+		}
+	}
+
+	mockUploader := &MockS3{responseError: &smithy.GenericAPIError{Code: "BadDigest"}}
+	err := writeToS3(context.Background(), mockUploader, mockUploader,
+		io.NopCloser(strings.NewReader("test content")), "sources/2023/05/01/ffis.org/raw.eml", nil)
+	if !errors.Is(err, awsHelpers.ErrS3ChecksumMismatch) {
+		t.Errorf("Expected error %v, got %v", awsHelpers.ErrS3ChecksumMismatch, err)
+	}
+}
+
+func TestWriteToS3FitsMetadata(t *testing.T) {
+	logger = log.NewNopLogger()
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		sentMetrics[strings.Join(append([]string{metric}, tags...), ",")] += value
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	finalURL := "https://example.com/file.xlsx?token=" + strings.Repeat("t", 2500)
+	mockUploader := &MockS3{}
+	err := writeToS3(context.Background(), mockUploader, mockUploader,
+		io.NopCloser(strings.NewReader("test content")), "sources/2023/05/01/ffis.org/raw.eml",
+		map[string]string{"final-url": finalURL, "redirect-count": "0"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if size := awsHelpers.S3MetadataSize(mockUploader.metadata); size > awsHelpers.MaxS3UserMetadataSize {
+		t.Errorf("Expected metadata within S3 limit, got %d bytes", size)
+	}
+	if !strings.HasPrefix(finalURL, mockUploader.metadata["final-url"]) || len(mockUploader.metadata["final-url"]) == 0 {
+		t.Errorf("Expected final-url to be truncated, got %q", mockUploader.metadata["final-url"])
+	}
+	if mockUploader.metadata["redirect-count"] != "0" {
+		t.Errorf("Expected redirect-count to be kept, got %q", mockUploader.metadata["redirect-count"])
+	}
+	if sentMetrics["s3.attribute_budget_exceeded,attribute:metadata,action:truncated"] != 1 {
+		t.Errorf("Expected truncation metric, got %v", sentMetrics)
+	}
+}
+
+func TestWriteToS3PromotesTemporaryObject(t *testing.T) {
+	logger = log.NewNopLogger()
+	sourceKey := "sources/2023/05/01/ffis.org/raw.eml"
+	destinationKey := "sources/2023/05/01/ffis.org/download.xlsx"
+
+	t.Run("verified upload is promoted", func(t *testing.T) {
+		mockS3 := &MockS3{}
+		err := writeToS3(context.Background(), mockS3, mockS3,
+			io.NopCloser(strings.NewReader("test content")), sourceKey, map[string]string{"final-url": "https://example.com"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		obj, ok := mockS3.objects[destinationKey]
+		if !ok {
+			t.Fatalf("Expected object at %s", destinationKey)
+		}
+		if string(obj.content) != "test content" {
+			t.Errorf("Expected %v, got %v", "test content", string(obj.content))
+		}
+		if obj.metadata["final-url"] != "https://example.com" {
+			t.Errorf("Expected promoted object to retain metadata, got %v", obj.metadata)
+		}
+		if len(mockS3.objects) != 1 {
+			t.Errorf("Expected temporary object to be deleted, got objects %v", mockS3.objects)
+		}
+		if len(mockS3.deletedKeys) != 1 || !strings.HasPrefix(mockS3.deletedKeys[0], tempKeyPrefix) {
+			t.Errorf("Expected a temporary object to be deleted, got %v", mockS3.deletedKeys)
+		}
+	})
+
+	t.Run("checksum mismatch is not promoted", func(t *testing.T) {
+		mockS3 := &MockS3{checksumSHA256: aws.String("bm90IHRoZSByaWdodCBjaGVja3N1bQ==")}
+		err := writeToS3(context.Background(), mockS3, mockS3,
+			io.NopCloser(strings.NewReader("test content")), sourceKey, nil)
+		if !errors.Is(err, ErrDownloadVerificationFailed) {
+			t.Errorf("Expected error %v, got %v", ErrDownloadVerificationFailed, err)
+		}
+		if len(mockS3.objects) != 0 {
+			t.Errorf("Expected no objects after failed verification, got %v", mockS3.objects)
+		}
+	})
+}
+
+func TestWriteToS3UnreadablePromotion(t *testing.T) {
+	logger = log.NewNopLogger()
+	sentMetrics := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	// The promoted object's attributes can be read, but its contents cannot
+	mockS3 := &MockS3{getObjectError: &smithy.GenericAPIError{Code: "AccessDenied"}}
+	err := writeToS3(context.Background(), mockS3, mockS3,
+		io.NopCloser(strings.NewReader("test content")), "sources/2023/05/01/ffis.org/raw.eml", nil)
+	if err != nil {
+		t.Errorf("Expected unverified promotion not to fail the download, got %v", err)
+	}
+	if _, ok := sentMetrics["download.promotion_verification_failed"]; !ok {
+		t.Errorf("Expected download.promotion_verification_failed metric, got %v", sentMetrics)
+	}
+	if len(mockS3.deletedKeys) != 0 {
+		t.Errorf("Expected temporary object to be kept, got deleted keys %v", mockS3.deletedKeys)
+	}
+	if len(mockS3.objects) != 2 {
+		t.Errorf("Expected temporary and promoted objects, got objects %v", mockS3.objects)
+	}
+}
+
+func TestCleanupOrphanedTempObjects(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.TempObjectTTL = time.Hour
+	mockS3 := &MockS3{}
+	for i := 0; i < maxOrphanDeletions+2; i++ {
+		mockS3.putObject(fmt.Sprintf("%sorphan%d", tempKeyPrefix, i),
+			mockS3Object{lastModified: time.Now().Add(-2 * time.Hour)})
+	}
+	mockS3.putObject(tempKeyPrefix+"in-progress", mockS3Object{lastModified: time.Now()})
+	mockS3.putObject("sources/2023/05/01/ffis.org/download.xlsx",
+		mockS3Object{lastModified: time.Now().Add(-2 * time.Hour)})
+
+	cleanupOrphanedTempObjects(context.Background(), mockS3)
+
+	if len(mockS3.deletedKeys) != maxOrphanDeletions {
+		t.Errorf("Expected %d deletions, got %v", maxOrphanDeletions, mockS3.deletedKeys)
+	}
+	for _, key := range mockS3.deletedKeys {
+		if !strings.HasPrefix(key, tempKeyPrefix+"orphan") {
+			t.Errorf("Unexpected deletion of %s", key)
+		}
+	}
+	if _, ok := mockS3.objects[tempKeyPrefix+"in-progress"]; !ok {
+		t.Errorf("Expected recent temporary object to be kept")
+	}
+}
+
 func errorContains(actual error, expected error) bool {
 	return strings.Contains(actual.Error(), expected.Error())
 }
@@ -8,7 +8,6 @@ import (
 	"context"
 	"fmt"
 	goLog "log"
-	"net/http"
 	"time"
 
 	ddlambda "github.com/DataDog/datadog-lambda-go"
@@ -17,25 +16,39 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 )
 
 type Environment struct {
-	LogLevel           string        `env:"LOG_LEVEL,default=INFO"`
-	UsePathStyleS3Opt  bool          `env:"S3_USE_PATH_STYLE,default=false"`
-	DestinationBucket  string        `env:"TARGET_BUCKET_NAME,required=true"`
-	MaxDownloadBackoff time.Duration `env:"MAX_DOWNLOAD_BACKOFF,default=20s"`
-	Extras             goenv.EnvSet
+	LogLevel            string                  `env:"LOG_LEVEL,default=INFO"`
+	UsePathStyleS3Opt   bool                    `env:"S3_USE_PATH_STYLE,default=false"`
+	DestinationBucket   string                  `env:"TARGET_BUCKET_NAME,required=true"`
+	MaxDownloadBackoff  time.Duration           `env:"MAX_DOWNLOAD_BACKOFF,default=20s"`
+	MaxDownloadBytes    int64                   `env:"MAX_DOWNLOAD_BYTES,default=0"`
+	MaxRedirects        int                     `env:"MAX_DOWNLOAD_REDIRECTS,default=10"`
+	TempObjectTTL       time.Duration           `env:"TEMP_OBJECT_TTL,default=1h"`
+	SecretsCacheTTL     time.Duration           `env:"SECRETS_CACHE_TTL,default=5m"`
+	TracingProvider     string                  `env:"TRACING_PROVIDER,default=datadog"`
+	S3ChecksumAlgorithm types.ChecksumAlgorithm `env:"S3_CHECKSUM_ALGORITHM,default=SHA256"`
+	DryRun              bool                    `env:"DRY_RUN,default=false"`
+	DisabledFeatures    string                  `env:"DISABLED_FEATURES"`
+	Extras              goenv.EnvSet            `redact:"true"`
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("DownloadFFISSpreadsheet", "source:ffis.org")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	secrets          *awsHelpers.SecretsResolver
+	sendMetric       = ddHelpers.NewMetricSender("DownloadFFISSpreadsheet", "source:ffis.org")
 )
 
 func main() {
@@ -45,22 +58,54 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := awsHelpers.ValidateChecksumAlgorithm(env.S3ChecksumAlgorithm); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	// Resolve any secretsmanager:// references in the environment during cold start
+	initCtx := context.Background()
+	initCfg, err := awsHelpers.GetConfig(initCtx)
+	if err != nil {
+		goLog.Fatalf("could not create AWS SDK config: %v", err)
+	}
+	secrets = awsHelpers.NewSecretsResolver(secretsmanager.NewFromConfig(initCfg), env.SecretsCacheTTL)
+	if err := secrets.ResolveEnvironment(initCtx, &env); err != nil {
+		goLog.Fatalf("error resolving secrets in environment variables: %v", err)
+	}
 
-	log.Info(logger, "Starting DownloadFFISSpreadsheet", "destinationBucket", env.DestinationBucket)
+	log.Info(logger, "Starting DownloadFFISSpreadsheet", "destinationBucket", env.DestinationBucket,
+		"dryRun", env.DryRun)
+	log.Debug(logger, "Loaded configuration", "environment", secrets.Redact(env))
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, sqsEvent events.SQSEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
 		cfg, err := awsHelpers.GetConfig(ctx)
 		if err != nil {
 			return fmt.Errorf("could not create AWS SDK config: %w", err)
 		}
 		awstrace.AppendMiddleware(&cfg)
 		log.Debug(logger, "Starting Lambda")
+		if err := secrets.ResolveEnvironment(ctx, &env); err != nil {
+			return log.Errorf(logger, "could not refresh secrets", err)
+		}
 		s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
 			o.UsePathStyle = env.UsePathStyleS3Opt
 		})
-		httpClient := &http.Client{}
+		httpClient := newHTTPClient()
 		httptrace.WrapClient(httpClient)
-		return handleSQSEvent(ctx, sqsEvent, s3manager.NewUploader(s3Client), httpClient)
+		return handleSQSEvent(ctx, sqsEvent, s3manager.NewUploader(s3Client), s3Client, httpClient)
 	}, nil))
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// redirectChainMetadataBudget is the maximum length of the redirect chain stored in
+// S3 object metadata, which keeps the object's user-defined metadata well below S3's 2 KB limit.
+const redirectChainMetadataBudget = 1024
+
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// redirectHop describes a redirect response that was followed while downloading a file.
+type redirectHop struct {
+	URL        string
+	StatusCode int
+}
+
+func (h redirectHop) String() string {
+	return fmt.Sprintf("%d %s", h.StatusCode, h.URL)
+}
+
+// redirectChain describes the sequence of redirects that led from a download URL to the
+// URL from which the file was actually downloaded.
+type redirectChain struct {
+	Hops     []redirectHop
+	FinalURL string
+}
+
+// String renders the chain as e.g. "301 https://a.example.com -> 302 https://b.example.com ->
+// https://c.example.com/file.xlsx".
+func (c redirectChain) String() string {
+	parts := make([]string, 0, len(c.Hops)+1)
+	for _, hop := range c.Hops {
+		parts = append(parts, hop.String())
+	}
+	return strings.Join(append(parts, c.FinalURL), " -> ")
+}
+
+// metadata returns S3 object metadata that describes the chain. The final URL is always
+// included, whereas intermediate hops are included until redirectChainMetadataBudget
+// is exhausted.
+func (c redirectChain) metadata() map[string]string {
+	m := map[string]string{
+		"final-url":      c.FinalURL,
+		"redirect-count": strconv.Itoa(len(c.Hops)),
+	}
+	if len(c.Hops) == 0 {
+		return m
+	}
+	parts := []string{}
+	size := 0
+	for i, hop := range c.Hops {
+		part := hop.String()
+		if size+len(part)+len(" -> ") > redirectChainMetadataBudget {
+			parts = append(parts, fmt.Sprintf("(%d more)", len(c.Hops)-i))
+			break
+		}
+		parts = append(parts, part)
+		size += len(part) + len(" -> ")
+	}
+	m["redirect-chain"] = strings.Join(parts, " -> ")
+	return m
+}
+
+type redirectRecorderKey struct{}
+
+// withRedirectRecorder returns a context which causes the redirects that are followed by
+// requests made with it to be recorded in the returned slice. Only the redirects followed by
+// the most recent request attempt are retained.
+// Redirects are recorded by HTTP clients that use checkRedirect as their CheckRedirect policy.
+func withRedirectRecorder(ctx context.Context) (context.Context, *[]redirectHop) {
+	hops := &[]redirectHop{}
+	return context.WithValue(ctx, redirectRecorderKey{}, hops), hops
+}
+
+// checkRedirect is an http.Client CheckRedirect policy that records each followed redirect
+// (see withRedirectRecorder) and stops following redirects after env.MaxRedirects,
+// which prevents redirect loops.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if hops, ok := req.Context().Value(redirectRecorderKey{}).(*[]redirectHop); ok {
+		if len(via) == 1 {
+			// The first redirect of a new request attempt
+			*hops = (*hops)[:0]
+		}
+		hop := redirectHop{URL: via[len(via)-1].URL.String()}
+		if req.Response != nil {
+			hop.StatusCode = req.Response.StatusCode
+		}
+		*hops = append(*hops, hop)
+	}
+	if len(via) > env.MaxRedirects {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, env.MaxRedirects)
+	}
+	return nil
+}
+
+// newHTTPClient returns an HTTP client for downloading files, which records redirects.
+func newHTTPClient() *http.Client {
+	return &http.Client{CheckRedirect: checkRedirect}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-kit/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+func newRedirectTestServer(t *testing.T) (*httptest.Server, *int) {
+	t.Helper()
+	loopRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hop1", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/hop1", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hop2", http.StatusFound)
+	})
+	mux.HandleFunc("/hop2", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final.xlsx", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/final.xlsx", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "test content")
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		loopRequests++
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &loopRequests
+}
+
+func TestHandleSQSEventRedirectChain(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.MaxDownloadBackoff = 100 * time.Millisecond
+	env.MaxRedirects = 5
+	sentMetrics := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	server, loopRequests := newRedirectTestServer(t)
+
+	newEvent := func(downloadURL string) events.SQSEvent {
+		msgJson, _ := json.Marshal(ffis.FFISMessageDownload{
+			DownloadURL:   downloadURL,
+			SourceFileKey: "sources/2023/05/01/ffis.org/raw.eml",
+		})
+		return events.SQSEvent{Records: []events.SQSMessage{{Body: string(msgJson)}}}
+	}
+
+	t.Run("three-hop chain", func(t *testing.T) {
+		mockUploader := &MockS3{}
+		err := handleSQSEvent(context.Background(), newEvent(server.URL+"/start"), mockUploader, mockUploader, newHTTPClient())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(mockUploader.content) != "test content" {
+			t.Errorf("Expected %v, got %v", "test content", string(mockUploader.content))
+		}
+		expectedMetadata := map[string]string{
+			"final-url":      server.URL + "/final.xlsx",
+			"redirect-count": "3",
+			"redirect-chain": fmt.Sprintf("301 %[1]s/start -> 302 %[1]s/hop1 -> 307 %[1]s/hop2", server.URL),
+		}
+		for k, v := range expectedMetadata {
+			if mockUploader.metadata[k] != v {
+				t.Errorf("Expected metadata %s to be %q, got %q", k, v, mockUploader.metadata[k])
+			}
+		}
+		if tags := sentMetrics["download.completed"]; len(tags) != 1 || tags[0] != "redirects:3" {
+			t.Errorf("Expected download.completed metric tags [redirects:3], got %v", tags)
+		}
+	})
+
+	t.Run("redirect loop", func(t *testing.T) {
+		mockUploader := &MockS3{}
+		err := handleSQSEvent(context.Background(), newEvent(server.URL+"/loop"), mockUploader, mockUploader, newHTTPClient())
+		if !errors.Is(err, ErrTooManyRedirects) {
+			t.Fatalf("Expected error %v, got %v", ErrTooManyRedirects, err)
+		}
+		if class := eventHelpers.ClassifyError(err); class != ErrTooManyRedirects.Error() {
+			t.Errorf("Expected error class %q, got %q", ErrTooManyRedirects.Error(), class)
+		}
+		if *loopRequests != env.MaxRedirects+1 {
+			t.Errorf("Expected %d requests without retrying, got %d", env.MaxRedirects+1, *loopRequests)
+		}
+		if mockUploader.uploads != 0 {
+			t.Errorf("Expected no S3 uploads, got %d", mockUploader.uploads)
+		}
+		if _, ok := sentMetrics["download.too_many_redirects"]; !ok {
+			t.Errorf("Expected download.too_many_redirects metric to be sent")
+		}
+	})
+}
+
+func TestRedirectChainMetadataBudget(t *testing.T) {
+	chain := redirectChain{FinalURL: "https://example.com/final.xlsx"}
+	for i := 0; i < 50; i++ {
+		chain.Hops = append(chain.Hops, redirectHop{
+			URL:        fmt.Sprintf("https://example.com/%s/%d", strings.Repeat("x", 50), i),
+			StatusCode: http.StatusFound,
+		})
+	}
+	metadata := chain.metadata()
+	if metadata["final-url"] != chain.FinalURL {
+		t.Errorf("Expected final-url %q, got %q", chain.FinalURL, metadata["final-url"])
+	}
+	if metadata["redirect-count"] != "50" {
+		t.Errorf("Expected redirect-count 50, got %q", metadata["redirect-count"])
+	}
+	if len(metadata["redirect-chain"]) > redirectChainMetadataBudget+len(" -> (50 more)") {
+		t.Errorf("Expected redirect-chain within budget, got %d bytes", len(metadata["redirect-chain"]))
+	}
+	if !strings.HasSuffix(metadata["redirect-chain"], "more)") {
+		t.Errorf("Expected truncated redirect-chain to note omitted hops, got %q", metadata["redirect-chain"])
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+const (
+	// tempKeyPrefix is the prefix of the temporary objects to which downloads are written
+	// before they are promoted to their destination key.
+	tempKeyPrefix = "tmp/"
+	// maxOrphanDeletions limits the number of orphaned temporary objects that are deleted
+	// by a single invocation.
+	maxOrphanDeletions = 5
+)
+
+var ErrDownloadVerificationFailed = fmt.Errorf("uploaded download does not match downloaded file")
+
+// downloadMetadataBudget fits the metadata of downloaded files within S3's limits.
+// The final URL is the most useful record of where a file came from, whereas the redirect
+// chain is the first to go (after any unlisted entries).
+var downloadMetadataBudget = awsHelpers.S3AttributeBudget{Priority: []string{
+	"final-url",
+	"redirect-count",
+	awsHelpers.ProcessorVersionMetadataKey,
+	awsHelpers.LambdaRequestIDMetadataKey,
+	"redirect-chain",
+}}
+
+// S3API is the interface for verifying, promoting, and cleaning up temporary download objects.
+type S3API interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// digestReader computes the length and SHA-256 digest of everything read through it.
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+// verifyUpload returns an error wrapping ErrDownloadVerificationFailed when the object at key
+// in env.DestinationBucket does not have the given size and (when available) SHA-256 checksum.
+func verifyUpload(ctx context.Context, s3Client S3API, key string, size int64, sum []byte) error {
+	resp, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(env.DestinationBucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return fmt.Errorf("error verifying uploaded download: %w", err)
+	}
+	if resp.ContentLength != size {
+		return fmt.Errorf("%w: downloaded %d bytes but uploaded object has %d bytes",
+			ErrDownloadVerificationFailed, size, resp.ContentLength)
+	}
+	// The checksum of a multipart upload is a checksum of its parts' checksums (suffixed by the
+	// number of parts), which cannot be compared with the downloaded file's checksum; instead,
+	// S3 verifies the checksum of each part as it is uploaded.
+	checksum := aws.StringValue(resp.ChecksumSHA256)
+	if checksum != "" && !strings.Contains(checksum, "-") {
+		if expected := base64.StdEncoding.EncodeToString(sum); checksum != expected {
+			return fmt.Errorf("%w: expected SHA-256 checksum %s but uploaded object has %s",
+				ErrDownloadVerificationFailed, expected, checksum)
+		}
+	}
+	return nil
+}
+
+// cleanupOrphanedTempObjects deletes temporary objects that were last modified more than
+// env.TempObjectTTL ago, which are left behind when an invocation ends before promoting its
+// download. At most maxOrphanDeletions objects are deleted, so that cleanup never
+// significantly delays the invocation. Failures are logged but otherwise ignored.
+func cleanupOrphanedTempObjects(ctx context.Context, s3Client S3API) {
+	resp, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(env.DestinationBucket),
+		Prefix: aws.String(tempKeyPrefix),
+	})
+	if err != nil {
+		log.Warn(logger, "Failed to list temporary objects for cleanup", "error", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-env.TempObjectTTL)
+	deleted := 0
+	for _, obj := range resp.Contents {
+		if deleted >= maxOrphanDeletions {
+			break
+		}
+		if obj.LastModified == nil || !obj.LastModified.Before(cutoff) {
+			continue
+		}
+		if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    obj.Key,
+		}); err != nil {
+			log.Warn(logger, "Failed to delete orphaned temporary object",
+				"key", aws.StringValue(obj.Key), "error", err)
+			continue
+		}
+		log.Info(logger, "Deleted orphaned temporary object",
+			"key", aws.StringValue(obj.Key), "lastModified", obj.LastModified)
+		deleted++
+	}
+	if deleted > 0 {
+		sendMetric("download.orphans_deleted", float64(deleted))
+	}
+}
+
+// fitDownloadMetadata returns metadata fitted within S3's limits (see downloadMetadataBudget).
+// Each entry that is truncated or dropped is logged at the WARN level and counted by the
+// s3.attribute_budget_exceeded metric, rather than letting the upload fail.
+func fitDownloadMetadata(logger log.Logger, metadata map[string]string) (map[string]string, error) {
+	fitted, changes, err := downloadMetadataBudget.FitMetadata(metadata)
+	for _, change := range changes {
+		sendMetric("s3.attribute_budget_exceeded", 1, "attribute:metadata", "action:"+change.Action)
+		log.Warn(logger, "Download metadata exceeds S3 limits", "key", change.Key,
+			"action", change.Action, "original_bytes", change.OriginalSize)
+	}
+	return fitted, err
+}
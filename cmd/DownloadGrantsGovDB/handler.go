@@ -11,8 +11,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cenkalti/backoff/v4"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 )
 
 // ScheduledEvent represents the invocation event for this Lambda function
@@ -35,7 +37,11 @@ func (e *ScheduledEvent) destinationS3Key() string {
 
 // handleWithConfig is a Lambda function handler that is called with the ScheduledEvent invocation
 // event. When invoked, it streams a Grants.gov database export (zip file) to S3.
+// Events are skipped without side effects while the download feature is disabled.
 func handleWithConfig(cfg aws.Config, ctx context.Context, event ScheduledEvent) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureDownload, 1) {
+		return nil
+	}
 	logger := log.With(logger,
 		"db_date", event.Timestamp.Format("2006-01-02"),
 		"source", event.grantsURL(),
@@ -59,13 +65,17 @@ func handleWithConfig(cfg aws.Config, ctx context.Context, event ScheduledEvent)
 		o.UsePathStyle = env.UsePathStyleS3Opt
 	}))
 	log.Debug(logger, "Streaming remote file to S3")
+	// The upload manager sends a checksum with each part of a multipart upload
 	if _, err := uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket:               aws.String(env.DestinationBucket),
 		Key:                  aws.String(event.destinationS3Key()),
 		Body:                 resp.Body,
+		Metadata:             awsHelpers.WithProcessorVersion(awsHelpers.WithLambdaRequestID(ctx, nil)),
 		ServerSideEncryption: types.ServerSideEncryptionAes256,
+		ChecksumAlgorithm:    env.S3ChecksumAlgorithm,
 	}); err != nil {
-		return log.Errorf(logger, "Error uploading source archive to S3", err)
+		return log.Errorf(logger, "Error uploading source archive to S3",
+			awsHelpers.WrapS3ChecksumError(err))
 	}
 
 	log.Info(logger, "Finished transfering source file to S3")
@@ -84,14 +94,14 @@ func startDownload(ctx context.Context, c *http.Client, url string) (resp *http.
 	b := backoff.NewExponentialBackOff()
 	b.MaxElapsedTime = env.MaxDownloadBackoff
 	attempt := 1
-	span, spanCtx := tracer.StartSpanFromContext(ctx, "download.start")
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "download.start")
 	err = backoff.RetryNotify(func() (err error) {
-		attemptSpan, _ := tracer.StartSpanFromContext(spanCtx, fmt.Sprintf("attempt.%d", attempt))
+		attemptSpan, _ := tracing.StartSpanFromContext(spanCtx, fmt.Sprintf("attempt.%d", attempt))
 		resp, err = c.Do(req)
-		attemptSpan.Finish(tracer.WithError(err))
+		attemptSpan.Finish(tracing.WithError(err))
 		return err
 	}, b, func(error, time.Duration) { attempt++ })
-	span.Finish(tracer.WithError(err))
+	span.Finish(tracing.WithError(err))
 	return resp, err
 }
 
@@ -21,6 +21,7 @@ import (
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 )
 
 func setupLambdaEnvForTesting(t *testing.T) {
@@ -192,6 +193,39 @@ func TestHandleWithConfig(t *testing.T) {
 	}
 }
 
+func TestHandleWithConfigFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureDownload: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	// Every request, whether to S3 or to Grants.gov, fails the test
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		assert.Fail(t, "Unexpected request while disabled", "%s %s", req.Method, req.URL)
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	env.GrantsGovBaseURL = server.URL
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: server.URL}, nil
+			}),
+		),
+	)
+	require.NoError(t, err)
+
+	assert.NoError(t, handleWithConfig(cfg, context.TODO(), ScheduledEvent{time.Now()}))
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
 func TestValidateDownloadResponse(t *testing.T) {
 	t.Run("Response is valid", func(t *testing.T) {
 		assert.NoError(t, validateDownloadResponse(&http.Response{
@@ -16,26 +16,33 @@ import (
 	ddlambda "github.com/DataDog/datadog-lambda-go"
 	goenv "github.com/Netflix/go-env"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 )
 
 type Environment struct {
-	LogLevel           string        `env:"LOG_LEVEL,default=INFO"`
-	DestinationBucket  string        `env:"GRANTS_SOURCE_DATA_BUCKET_NAME,required=true"`
-	GrantsGovBaseURL   string        `env:"GRANTS_GOV_BASE_URL,required=true"`
-	MaxDownloadBackoff time.Duration `env:"MAX_DOWNLOAD_BACKOFF,default=20s"`
-	UsePathStyleS3Opt  bool          `env:"S3_USE_PATH_STYLE,default=false"`
-	Extras             goenv.EnvSet
+	LogLevel            string                  `env:"LOG_LEVEL,default=INFO"`
+	DestinationBucket   string                  `env:"GRANTS_SOURCE_DATA_BUCKET_NAME,required=true"`
+	GrantsGovBaseURL    string                  `env:"GRANTS_GOV_BASE_URL,required=true"`
+	MaxDownloadBackoff  time.Duration           `env:"MAX_DOWNLOAD_BACKOFF,default=20s"`
+	UsePathStyleS3Opt   bool                    `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider     string                  `env:"TRACING_PROVIDER,default=datadog"`
+	S3ChecksumAlgorithm types.ChecksumAlgorithm `env:"S3_CHECKSUM_ALGORITHM,default=SHA256"`
+	DisabledFeatures    string                  `env:"DISABLED_FEATURES"`
+	Extras              goenv.EnvSet
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("DownloadGrantsGovDB", "source:grants.gov")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("DownloadGrantsGovDB", "source:grants.gov")
 )
 
 func main() {
@@ -45,9 +52,25 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := awsHelpers.ValidateChecksumAlgorithm(env.S3ChecksumAlgorithm); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event ScheduledEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
 		cfg, err := awsHelpers.GetConfig(ctx)
 		if err != nil {
 			return fmt.Errorf("could not create AWS SDK config: %w", err)
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"net/mail"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+)
+
+// Origins of the time from which an email's age is measured
+const (
+	emailAgeFromDateHeader = "date_header"
+	emailAgeFromEventTime  = "event_time"
+)
+
+// emailSentAt returns the time at which the (possibly gzip-compressed) email contained in
+// emailBytes was sent, according to its Date header, or else the time of the S3 event that
+// announced it (eventTime), along with the origin of the returned time. Returns false when
+// neither is available.
+func emailSentAt(emailBytes []byte, eventTime time.Time) (time.Time, string, bool) {
+	if r, _, err := awsHelpers.DecompressIfGzipped(bytes.NewReader(emailBytes)); err == nil {
+		if msg, err := mail.ReadMessage(r); err == nil {
+			if date, err := msg.Header.Date(); err == nil {
+				return date, emailAgeFromDateHeader, true
+			}
+		}
+	}
+	if !eventTime.IsZero() {
+		return eventTime, emailAgeFromEventTime, true
+	}
+	return time.Time{}, "", false
+}
+
+// isStaleEmail reports whether the email contained in emailBytes was sent more than
+// env.MaxJobAge ago, in which case the single-use download link that it contains has likely
+// expired. Emails are never stale when env.MaxJobAge is not positive, or when their age cannot
+// be determined. The email's age and the origin of the time from which it was measured are
+// also returned.
+func isStaleEmail(emailBytes []byte, eventTime time.Time) (stale bool, age time.Duration, origin string) {
+	if env.MaxJobAge <= 0 {
+		return false, 0, ""
+	}
+	sentAt, origin, ok := emailSentAt(emailBytes, eventTime)
+	if !ok {
+		return false, 0, ""
+	}
+	age = time.Since(sentAt)
+	return age > env.MaxJobAge, age, origin
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxSQSMessageAttributes is the maximum number of message attributes that SQS accepts
+// for a single message.
+const maxSQSMessageAttributes = 10
+
+var (
+	// Static routing attributes added to every message (see parseMessageAttributes)
+	staticMessageAttributes map[string]string
+	// Names of the attributes that are sent as message attributes before any others
+	priorityMessageAttributes []string
+)
+
+// parseMessageAttributes parses a comma-separated list of name=value pairs.
+// Returns an error if any pair is malformed.
+func parseMessageAttributes(pairs string) (map[string]string, error) {
+	attrs := map[string]string{}
+	for _, pair := range strings.Split(pairs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("malformed message attribute %q: expected name=value", pair)
+		}
+		attrs[name] = value
+	}
+	return attrs, nil
+}
+
+// parseAttributeNames parses a comma-separated list of message attribute names.
+func parseAttributeNames(names string) []string {
+	parsed := []string{}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			parsed = append(parsed, name)
+		}
+	}
+	return parsed
+}
+
+// messageAttributes returns the provenance and routing attributes of the message that enqueues
+// url, which was parsed from the email referenced by record. Static attributes configured by
+// env.MessageAttributes take precedence over provenance attributes of the same name.
+func messageAttributes(ctx context.Context, record events.S3EventRecord, url string) map[string]string {
+	attrs := map[string]string{
+		"SourceBucket":    record.S3.Bucket.Name,
+		"SourceKey":       record.S3.Object.Key,
+		"SourceEventName": record.EventName,
+	}
+	if !record.EventTime.IsZero() {
+		attrs["SourceEventTime"] = record.EventTime.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	if u, err := neturl.Parse(url); err == nil {
+		attrs["DownloadHost"] = u.Host
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		attrs["LambdaRequestID"] = lc.AwsRequestID
+	}
+	for name, value := range staticMessageAttributes {
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// capMessageAttributes selects at most limit attributes to send as SQS message attributes.
+// Attributes named by priority are selected first (in the given order), followed by the
+// remaining attributes in order of name. Attributes with empty values are never selected,
+// since SQS rejects them. The attributes that are not selected are returned as spilled,
+// so that they may be included in the message body instead.
+func capMessageAttributes(attrs map[string]string, priority []string, limit int) (
+	selected map[string]sqsTypes.MessageAttributeValue, spilled map[string]string,
+) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rank := make(map[string]int, len(priority))
+	for i, name := range priority {
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ri, iPriority := rank[names[i]]
+		rj, jPriority := rank[names[j]]
+		if iPriority && jPriority {
+			return ri < rj
+		}
+		return iPriority && !jPriority
+	})
+
+	selected = map[string]sqsTypes.MessageAttributeValue{}
+	for _, name := range names {
+		value := attrs[name]
+		if value == "" {
+			continue
+		}
+		if len(selected) < limit {
+			selected[name] = sqsTypes.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(value),
+			}
+			continue
+		}
+		if spilled == nil {
+			spilled = map[string]string{}
+		}
+		spilled[name] = value
+	}
+	return selected, spilled
+}
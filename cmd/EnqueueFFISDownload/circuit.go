@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+var ErrCircuitOpen = fmt.Errorf("SQS circuit breaker is open")
+
+// circuitBreakerSQS wraps an SQSAPI so that, once threshold consecutive SendMessage calls
+// have failed, all remaining calls fail immediately with ErrCircuitOpen instead of being sent.
+// This allows an invocation to fail quickly during an SQS outage (and rely on redelivery)
+// rather than spending its entire timeout on sends that are bound to fail.
+// The circuit never closes once opened, so a new circuitBreakerSQS should be used for each
+// invocation.
+type circuitBreakerSQS struct {
+	SQSAPI
+	threshold int
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+}
+
+func newCircuitBreakerSQS(client SQSAPI, threshold int) *circuitBreakerSQS {
+	return &circuitBreakerSQS{SQSAPI: client, threshold: threshold}
+}
+
+func (c *circuitBreakerSQS) isOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.consecutiveFailures >= c.threshold
+}
+
+// SendMessage sends the message with the wrapped client unless the circuit is open.
+func (c *circuitBreakerSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if c.isOpen() {
+		return nil, ErrCircuitOpen
+	}
+
+	output, err := c.SQSAPI.SendMessage(ctx, params, optFns...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.consecutiveFailures = 0
+		return output, nil
+	}
+	c.consecutiveFailures++
+	if c.consecutiveFailures == c.threshold {
+		sendMetric("sqs.circuit_opened", 1)
+		log.Warn(logger, "Opening SQS circuit breaker after consecutive send failures",
+			"consecutive_failures", c.consecutiveFailures, "error", err)
+	}
+	return output, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// Pattern configured by env.CompanionReferencePattern (nil when not configured)
+var companionReferencePattern *regexp.Regexp
+
+// parseCompanionReferencePattern compiles the pattern used to locate references to companion
+// objects (e.g. errata) in a digest's plaintext. Returns nil when pattern is empty.
+func parseCompanionReferencePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid companion reference pattern: %w", err)
+	}
+	if re.NumSubexp() > 1 {
+		return nil, fmt.Errorf("companion reference pattern must have at most one capture group")
+	}
+	return re, nil
+}
+
+// companionKeys returns the S3 keys of the companion objects referenced in plaintext, which is
+// the plaintext of the digest email at emailKey. Each reference is the text matched by pattern
+// (or by its capture group, if it has one). References without a "/" are resolved relative to
+// the "directory" of emailKey; other references are used as keys as-is.
+func companionKeys(pattern *regexp.Regexp, plaintext, emailKey string) []string {
+	keys := []string{}
+	seen := map[string]bool{emailKey: true}
+	for _, match := range pattern.FindAllStringSubmatch(plaintext, -1) {
+		ref := strings.TrimSpace(match[len(match)-1])
+		if ref == "" {
+			continue
+		}
+		key := ref
+		if !strings.Contains(ref, "/") {
+			key = path.Join(path.Dir(emailKey), ref)
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// resolveCompanions fetches each of the companion objects referenced by the digest email at
+// emailKey in bucket, returning the keys of the companions that exist. Companions that do not
+// exist are logged and skipped, since a digest remains usable without them; any other failure
+// to fetch a companion is returned as an error.
+func resolveCompanions(ctx context.Context, s3client S3API, bucket, emailKey, plaintext string) ([]string, error) {
+	if companionReferencePattern == nil {
+		return nil, nil
+	}
+	resolved := []string{}
+	for _, key := range companionKeys(companionReferencePattern, plaintext, emailKey) {
+		logger := log.With(logger, "bucket", bucket, "companion_key", key)
+		resp, err := s3client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			var nsk *types.NoSuchKey
+			if errors.As(err, &nsk) {
+				log.Warn(logger, "Skipping missing companion object referenced by email")
+				sendMetric("email.companion_missing", 1)
+				continue
+			}
+			return nil, fmt.Errorf("error fetching companion object %s: %w", key, err)
+		}
+		size, err := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading companion object %s: %w", key, err)
+		}
+		log.Info(logger, "Resolved companion object referenced by email", "size_bytes", size)
+		sendMetric("email.companion_resolved", 1)
+		resolved = append(resolved, key)
+	}
+	return resolved, nil
+}
@@ -3,12 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
+	neturl "net/url"
+	"path"
 	"regexp"
 	"strings"
 
@@ -16,7 +22,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 )
 
@@ -24,6 +35,9 @@ type SQSAPI interface {
 	SendMessage(ctx context.Context,
 		params *sqs.SendMessageInput,
 		optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	GetQueueAttributes(ctx context.Context,
+		params *sqs.GetQueueAttributesInput,
+		optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
 }
 
 type S3API interface {
@@ -34,52 +48,375 @@ type S3API interface {
 
 // error constants
 var (
-	ErrNoMatchesFound = fmt.Errorf("no matches found")
-	ErrMultipleFound  = fmt.Errorf("multiple matches found")
-	ErrNoPlaintext    = fmt.Errorf("no plaintext mime part found")
+	ErrNoMatchesFound      = fmt.Errorf("no matches found")
+	ErrMultipleFound       = fmt.Errorf("multiple matches found")
+	ErrNoPlaintext         = fmt.Errorf("no plaintext mime part found")
+	ErrUnexpectedExtension = fmt.Errorf("download URL does not have an allowed file extension")
+	ErrMimeTooDeep         = fmt.Errorf("MIME parts are nested too deeply")
+	ErrTruncatedDownload   = fmt.Errorf("S3 object is shorter than its content length")
 )
 
+// handleInvocation handles a raw invocation payload (see eventHelpers.InvocationHandler).
+// Events are skipped without side effects while the enqueue_download feature is disabled.
+func handleInvocation(ctx context.Context, payload json.RawMessage, s3client S3API, sqsclient SQSAPI) (interface{}, error) {
+	return eventHelpers.InvocationHandler{
+		Name:             "EnqueueFFISDownload",
+		Feature:          configHelpers.FeatureEnqueueDownload,
+		DisabledFeatures: disabledFeatures,
+		Env:              env,
+		Sources:          func() interface{} { return configuredSources() },
+		Logger:           logger,
+		SendMetric:       sendMetric,
+		HealthCheck: func(ctx context.Context) eventHelpers.HealthReport {
+			return handleHealthCheck(ctx, sqsclient)
+		},
+		HandleRecords: func(ctx context.Context, records []events.S3EventRecord) ([]eventHelpers.RecordResult, error) {
+			return handleRecords(ctx, records, s3client, sqsclient)
+		},
+		HandleEvent: func(ctx context.Context, event events.S3Event) error {
+			return handleS3Event(ctx, event, s3client, sqsclient)
+		},
+	}.Handle(ctx, payload)
+}
+
+// handleHealthCheck verifies that the destination queue of every configured source is reachable
+// and that metrics can be emitted, without processing any events. When sources use different
+// queues, each queue check is named for the first source that uses the queue.
+func handleHealthCheck(ctx context.Context, sqsclient SQSAPI) eventHelpers.HealthReport {
+	checks := []eventHelpers.HealthCheck{}
+	checkedQueues := make(map[string]bool)
+	for _, source := range configuredSources() {
+		if checkedQueues[source.QueueURL] {
+			continue
+		}
+		checkedQueues[source.QueueURL] = true
+		queueURL := source.QueueURL
+		checks = append(checks, eventHelpers.HealthCheck{
+			Name: "sqs.destination_queue:" + source.Name, Required: true,
+			Check: func(ctx context.Context) error {
+				_, err := sqsclient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+					QueueUrl:       aws.String(queueURL),
+					AttributeNames: []sqsTypes.QueueAttributeName{sqsTypes.QueueAttributeNameQueueArn},
+				})
+				return err
+			},
+		})
+	}
+	if len(checks) == 1 {
+		checks[0].Name = "sqs.destination_queue"
+	}
+	checks = append(checks, eventHelpers.HealthCheck{Name: "metrics", Check: func(ctx context.Context) error {
+		sendMetric("healthcheck", 1)
+		return nil
+	}})
+	report := eventHelpers.RunHealthChecks(ctx, eventHelpers.HealthCheckTimeout, checks)
+	if err := report.Err(); err != nil {
+		log.Error(logger, "Health check failed", err)
+		return report
+	}
+	log.Info(logger, "Health check succeeded")
+	return report
+}
+
+// handleS3Event processes every record in the S3 event. When processing fails for any record,
+// the full error detail is logged and the returned error summarizes the invocation's
+// per-record outcomes, which is also posted to the failure notification webhook (if configured).
+// Records for restore events are skipped unless env.ProcessRestoreEvents is enabled
+// (see eventHelpers.FilterProcessableRecords).
 func handleS3Event(ctx context.Context, s3Event events.S3Event, s3client S3API, sqsclient SQSAPI) error {
-	uploadedFile := s3Event.Records[0].S3.Object.Key
-	emailBody, err := getEmailFromS3Event(ctx, s3client, s3Event, uploadedFile)
+	records, skipped := eventHelpers.FilterProcessableRecords(s3Event.Records, env.ProcessRestoreEvents)
+	for _, record := range skipped {
+		log.Info(logger, "Skipping record for S3 event that is not processable",
+			"event_name", record.EventName, "bucket", record.S3.Bucket.Name, "key", record.S3.Object.Key)
+		sendMetric("record.skipped", 1, fmt.Sprintf("event_name:%s", record.EventName))
+	}
+	results, err := handleRecords(ctx, records, s3client, sqsclient)
 	if err != nil {
-		return log.Errorf(logger, "Error reading email from S3", err)
+		log.Error(logger, "Error processing one or more records", err)
+		invocationErr := eventHelpers.NewInvocationError(results, err)
+		eventHelpers.NotifyFailure(ctx, logger, sendMetric, failureNotifier, invocationErr)
+		return invocationErr
+	}
+	return nil
+}
+
+// handleRecords processes every record, failing sends to SQS quickly once
+// env.SQSCircuitBreakerThreshold consecutive sends have failed (when the threshold is positive).
+// When env.RecordTimeout is positive, each record that is not processed within that duration
+// (or before the invocation's deadline, if sooner) fails with eventHelpers.ErrRecordTimeout.
+func handleRecords(ctx context.Context, records []events.S3EventRecord, s3client S3API, sqsclient SQSAPI) ([]eventHelpers.RecordResult, error) {
+	if env.SQSCircuitBreakerThreshold > 0 {
+		sqsclient = newCircuitBreakerSQS(sqsclient, env.SQSCircuitBreakerThreshold)
+	}
+	return eventHelpers.HandleRecords(ctx, records, env.DeterministicOrder, env.RecordTimeout,
+		func(ctx context.Context, i int, record events.S3EventRecord) error {
+			err := processRecord(ctx, record, s3client, sqsclient)
+			if err != nil {
+				tags := []string{}
+				if source, ok := matchSource(configuredSources(), record.S3.Object.Key); ok {
+					tags = append(tags, source.metricTag())
+				}
+				if errors.Is(err, eventHelpers.ErrRecordTimeout) {
+					sendMetric("record.timeout", 1, tags...)
+				}
+				if isBenignError(err) {
+					log.Info(logger, "Record failed with a benign error",
+						"key", record.S3.Object.Key, "error", err)
+					sendMetric("email.benign_failure", 1, tags...)
+				} else {
+					sendMetric("email.failed", 1, tags...)
+				}
+			}
+			return err
+		})
+}
+
+// benignErrorsByName maps the names that may be given by the BENIGN_ERRORS environment variable
+// to the errors they identify.
+var benignErrorsByName = map[string]error{
+	"ErrNoMatchesFound":      ErrNoMatchesFound,
+	"ErrMultipleFound":       ErrMultipleFound,
+	"ErrNoPlaintext":         ErrNoPlaintext,
+	"ErrUnexpectedExtension": ErrUnexpectedExtension,
+}
+
+// parseBenignErrors parses a comma-separated list of error names (see benignErrorsByName).
+// Returns an error if any name is not recognized.
+func parseBenignErrors(names string) ([]error, error) {
+	errs := []error{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		target, ok := benignErrorsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized benign error name %q", name)
+		}
+		errs = append(errs, target)
+	}
+	return errs, nil
+}
+
+// isBenignError returns true when err is (or wraps) an error that is configured as benign.
+// Benign errors are expected for certain emails; they are still returned as failures,
+// but are excluded from the email.failed metric.
+func isBenignError(err error) bool {
+	for _, target := range benignErrors {
+		if errors.Is(err, target) {
+			return true
+		}
 	}
-	defer emailBody.Close()
-	plaintext, err := plaintextMIMEFromEmailBody(emailBody)
+	return false
+}
+
+// processRecord parses the download URL from the email referenced by the S3 event record
+// and enqueues it for download to the queue of the configured source matching the record's key.
+// Records that match no configured source and stale emails are skipped.
+func processRecord(ctx context.Context, record events.S3EventRecord, s3client S3API, sqsclient SQSAPI) (err error) {
+	bucket := record.S3.Bucket.Name
+	uploadedFile := record.S3.Object.Key
+	logger := log.With(logger, "bucket", bucket, "key", uploadedFile,
+		"event_name", record.EventName, "event_version", record.EventVersion)
+
+	// Each phase of processing is traced by a child span of the record span, which is tagged
+	// as timed out when the record timeout of ctx (see eventHelpers.WithRecordTimeout) elapsed
+	recordSpan, ctx := tracing.StartSpanFromContext(ctx, "handle.record")
+	recordSpan.SetTag("source_bucket", bucket)
+	recordSpan.SetTag("source_key", uploadedFile)
+	defer func() {
+		err = eventHelpers.RecordTimeoutError(ctx, err)
+		if errors.Is(err, eventHelpers.ErrRecordTimeout) {
+			recordSpan.SetTag("timed_out", true)
+		}
+		tracing.FinishWithOutcome(recordSpan, err)
+	}()
+
+	// The URL pattern and destination queue are those of the source matching the key
+	source, ok := matchSource(configuredSources(), uploadedFile)
+	if !ok {
+		sendMetric("email.unmatched_source", 1)
+		recordSpan.SetTag("skipped", true)
+		log.Warn(logger, "Skipping email because its key does not match any configured source")
+		return nil
+	}
+	logger = log.With(logger, "source", source.Name)
+	recordSpan.SetTag("source", source.Name)
+
+	fetchSpan, fetchCtx := tracing.StartSpanFromContext(ctx, "email.fetch")
+	emailBytes, err := readEmailFromS3(fetchCtx, s3client, bucket, uploadedFile)
+	fetchSpan.SetTag("bytes", len(emailBytes))
+	tracing.FinishWithOutcome(fetchSpan, err)
 	if err != nil {
-		return log.Errorf(logger, "Missing plaintext mime part from email body", err)
+		return log.Errorf(logger, "Error reading email from S3", err)
+	}
+	// Download links in emails sent more than env.MaxJobAge ago have likely expired
+	if stale, age, origin := isStaleEmail(emailBytes, record.EventTime); stale {
+		sendMetric("download.stale_skipped", 1, source.metricTag())
+		recordSpan.SetTag("skipped", true)
+		recordSpan.SetTag("stale", true)
+		log.Warn(logger, "Skipping email because its download link has likely expired",
+			"email_age", age, "email_age_origin", origin, "max_job_age", env.MaxJobAge)
+		return nil
 	}
-	// Parse the URL from the email body
-	url, err := parseURLFromEmailBody(plaintext)
+
+	parseSpan, _ := tracing.StartSpanFromContext(ctx, "email.parse")
+	plaintext, err := parsePlaintext(logger, parseSpan, emailBytes)
+	tracing.FinishWithOutcome(parseSpan, err)
+	if err != nil && !(env.ExtractPDFAttachments && errors.Is(err, ErrNoPlaintext)) {
+		return err
+	}
+	parseErr := err
+
+	// When env.ExtractPDFAttachments is enabled, PDF attachments are searched for the download
+	// URL if the plaintext is missing or does not contain it
+	matchSpan, _ := tracing.StartSpanFromContext(ctx, "url.match")
+	auditLogger := log.With(auditLogger, "bucket", bucket, "key", uploadedFile)
+	url, err := matchDownloadURL(logger, auditLogger, source, plaintext)
+	if env.ExtractPDFAttachments && errors.Is(err, ErrNoMatchesFound) {
+		if parseErr != nil {
+			err = parseErr
+		}
+		matchSpan.SetTag("pdf_fallback", true)
+		url, err = matchPDFDownloadURL(logger, auditLogger, source, emailBytes, err)
+	}
+	if url != "" {
+		if u, parseErr := neturl.Parse(url); parseErr == nil {
+			matchSpan.SetTag("download_host", u.Host)
+		}
+	}
+	tracing.FinishWithOutcome(matchSpan, err)
 	if err != nil {
-		return log.Errorf(logger, "Download URL could not be located in email plaintext", err)
+		return err
 	}
 
 	log.Info(logger, "Parsed URL from email body", "url", url)
 
+	companionsSpan, companionsCtx := tracing.StartSpanFromContext(ctx, "companions.fetch")
+	companionKeys, err := resolveCompanions(companionsCtx, s3client, bucket, uploadedFile, plaintext)
+	companionsSpan.SetTag("companions", len(companionKeys))
+	tracing.FinishWithOutcome(companionsSpan, err)
+	if err != nil {
+		return log.Errorf(logger, "Error resolving companion objects referenced by email", err)
+	}
+
 	// Enqueue the URL for download
-	err = enqueueURLForDownload(ctx, sqsclient, url, uploadedFile)
+	sendSpan, sendCtx := tracing.StartSpanFromContext(ctx, "message.send")
+	attrs := messageAttributes(ctx, record, url)
+	sendSpan.SetTag("message_attributes", len(attrs))
+	err = enqueueURLForDownload(sendCtx, sqsclient, source, url, uploadedFile, companionKeys, attrs)
+	tracing.FinishWithOutcome(sendSpan, err)
 	if err != nil {
 		return log.Errorf(logger, "Failed to enqueue parsed URL", err)
 	}
 
+	// Once the URL is enqueued, a summary is posted to the post-processing webhook (if configured)
+	notifyPostProcess(ctx, logger, source, uploadedFile, emailBytes, plaintext, url)
 	return nil
 }
 
+// parsePlaintext returns the plaintext part of the (possibly gzip-compressed) email contained
+// in emailBytes, decoding HTML entities when env.DecodeHTMLEntities is enabled.
+// Whether the email was compressed and the size of the plaintext are tagged on span.
+// When the email cannot be parsed (rather than having no plaintext part), the returned error
+// is a *ParseError that identifies the approximate line of the email at which parsing failed.
+func parsePlaintext(logger log.Logger, span tracing.Span, emailBytes []byte) (string, error) {
+	// Archived emails may be gzip-compressed without a Content-Encoding header
+	email, compressed, err := awsHelpers.DecompressIfGzipped(bytes.NewReader(emailBytes))
+	if err != nil {
+		return "", log.Errorf(logger, "Error decompressing gzip-compressed email", err)
+	}
+	span.SetTag("compressed", compressed)
+	if compressed {
+		log.Info(logger, "Decompressing gzip-compressed email")
+	}
+	contents, err := io.ReadAll(email)
+	if err != nil {
+		return "", log.Errorf(logger, "Error reading email contents", err)
+	}
+	reader := &lineReader{contents: contents}
+	plaintext, err := plaintextMIMEFromEmailBody(reader)
+	if err != nil && !errors.Is(err, ErrNoPlaintext) {
+		parseErr := newParseError(contents, reader.offset, err)
+		span.SetTag("parse_error_line", parseErr.Line)
+		return "", log.Errorf(logger, "Failed to parse email body", parseErr,
+			"parse_error_line", parseErr.Line, "parse_error_snippet", parseErr.Snippet)
+	}
+	if err != nil {
+		return "", log.Errorf(logger, "Missing plaintext mime part from email body", err)
+	}
+	if env.DecodeHTMLEntities {
+		plaintext = decodeHTMLEntities(logger, plaintext)
+	}
+	span.SetTag("plaintext_bytes", len(plaintext))
+	return plaintext, nil
+}
+
+// matchDownloadURL returns the download URL of source parsed from plaintext after verifying
+// that it references an allowed file type. When allowed extensions are configured,
+// the outcome of the verification is recorded as an event with auditLogger.
+func matchDownloadURL(logger, auditLogger log.Logger, source Source, plaintext string) (string, error) {
+	url, err := parseURLFromEmailBody(source, plaintext)
+	if err != nil {
+		return "", log.Errorf(logger, "Download URL could not be located in email plaintext", err)
+	}
+	err = checkURLExtension(url, env.AllowedExtensions)
+	if strings.TrimSpace(env.AllowedExtensions) != "" {
+		if err != nil {
+			log.Audit(auditLogger, log.AuditDecisionReject, "allowed_extensions", url,
+				"reason", err.Error(), "allowed_extensions", env.AllowedExtensions)
+		} else {
+			log.Audit(auditLogger, log.AuditDecisionAccept, "allowed_extensions", url,
+				"allowed_extensions", env.AllowedExtensions)
+		}
+	}
+	if err != nil {
+		return url, log.Errorf(logger, "Download URL does not reference an expected file type", err)
+	}
+	return url, nil
+}
+
 func plaintextMIMEFromEmailBody(email io.Reader) (string, error) {
 	msg, err := mail.ReadMessage(email)
 	if err != nil {
 		return "", err
 	}
 	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
-	if mediaType != "multipart/alternative" {
+	if err != nil {
+		return "", err
+	}
+
+	// Some emails are not multipart, in which case the (possibly encoded) message body
+	// is itself the plaintext content
+	if mediaType == "text/plain" {
+		return readDecodedBody(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+	}
+	// Emails with attachments (e.g. PDFs) are multipart/mixed, with the plaintext
+	// typically nested in a multipart/alternative part
+	if mediaType != "multipart/alternative" && mediaType != "multipart/mixed" {
 		return "", fmt.Errorf("expected multipart/alternative, got %s", mediaType)
 	}
-	if err != nil {
+	return plaintextFromMultipart(msg.Body, params["boundary"], 1)
+}
+
+// checkMIMEDepth returns an error wrapping ErrMimeTooDeep when a multipart entity nested at the
+// given depth (where the outermost multipart entity has a depth of 1) exceeds env.MaxMIMEDepth.
+// A MaxMIMEDepth of 0 disables the limit.
+func checkMIMEDepth(depth int) error {
+	if env.MaxMIMEDepth > 0 && depth > env.MaxMIMEDepth {
+		return fmt.Errorf("%w: exceeds maximum depth of %d", ErrMimeTooDeep, env.MaxMIMEDepth)
+	}
+	return nil
+}
+
+// plaintextFromMultipart returns the first text/plain part (that is not an attachment) of the
+// multipart body with the given boundary, searching nested multipart parts.
+// The body is a multipart entity at the given depth, which is limited by checkMIMEDepth.
+func plaintextFromMultipart(body io.Reader, boundary string, depth int) (string, error) {
+	if err := checkMIMEDepth(depth); err != nil {
 		return "", err
 	}
-	mr := multipart.NewReader(msg.Body, params["boundary"])
+	mr := multipart.NewReader(body, boundary)
 	for {
 		p, err := mr.NextPart()
 		if err == io.EOF {
@@ -88,34 +425,126 @@ func plaintextMIMEFromEmailBody(email io.Reader) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		if strings.HasPrefix(p.Header.Get("Content-Type"), "text/plain") {
-			buf := new(bytes.Buffer)
-			_, err := buf.ReadFrom(p)
+		contentType := p.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "multipart/") {
+			_, params, err := mime.ParseMediaType(contentType)
 			if err != nil {
 				return "", err
 			}
-			return buf.String(), nil
+			plaintext, err := plaintextFromMultipart(p, params["boundary"], depth+1)
+			if err != ErrNoPlaintext {
+				return plaintext, err
+			}
+			continue
+		}
+		if strings.HasPrefix(contentType, "text/plain") &&
+			!strings.HasPrefix(p.Header.Get("Content-Disposition"), "attachment") {
+			// Note that multipart.Reader transparently decodes quoted-printable parts
+			// (and removes the header), but other encodings are left to the caller.
+			return readDecodedBody(p, p.Header.Get("Content-Transfer-Encoding"))
 		}
 	}
 
 	return "", ErrNoPlaintext
 }
 
-func parseURLFromEmailBody(plaintext string) (string, error) {
-	patternRegex := regexp.MustCompile(env.URLPattern)
+// readDecodedBody reads all content from r after decoding it according to the given
+// Content-Transfer-Encoding header value. Content with an unrecognized or identity
+// (7bit, 8bit, binary) transfer encoding is read as-is.
+func readDecodedBody(r io.Reader, transferEncoding string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// decodeHTMLEntities replaces HTML character references (e.g. "&amp;" or "&#x2F;") in plaintext
+// with the characters they represent. Some senders mistakenly entity-encode plaintext email
+// parts, which prevents URLs in them from matching the URL pattern of a source. Since plaintext
+// may also contain literal ampersands, decoding is only performed when env.DecodeHTMLEntities
+// is true.
+func decodeHTMLEntities(logger log.Logger, plaintext string) string {
+	decoded := html.UnescapeString(plaintext)
+	if decoded != plaintext {
+		log.Info(logger, "Decoded HTML entities in email plaintext")
+		sendMetric("email.html_entities_decoded", 1)
+	}
+	return decoded
+}
+
+// parseURLFromEmailBody returns the download URL matching the URL pattern of source in plaintext.
+// When multiple URLs match and env.MinURLConfidence is positive, the URL that most clearly
+// references the data file is returned if it is selected with at least that confidence
+// (see selectConfidentURL); otherwise, multiple matches are an error.
+func parseURLFromEmailBody(source Source, plaintext string) (string, error) {
+	patternRegex := regexp.MustCompile(source.URLPattern)
 	matches := patternRegex.FindAllString(plaintext, -1)
 	if len(matches) == 0 {
 		return "", ErrNoMatchesFound
 	} else if len(matches) > 1 {
-		return "", ErrMultipleFound
+		if env.MinURLConfidence <= 0 {
+			return "", ErrMultipleFound
+		}
+		url, confidence, ok := selectConfidentURL(matches, env.MinURLConfidence)
+		logger := log.With(logger, "count_matches", len(matches), "url", url,
+			"confidence", confidence, "min_confidence", env.MinURLConfidence)
+		if !ok {
+			log.Warn(logger, "Multiple URLs matched and none was selected with sufficient confidence")
+			return "", ErrMultipleFound
+		}
+		log.Info(logger, "Selected download URL from multiple matches")
+		sendMetric("email.url_selected_by_confidence", 1, source.metricTag())
+		return url, nil
 	}
 	return matches[0], nil
 }
 
-func enqueueURLForDownload(ctx context.Context, client SQSAPI, url string, fileKey string) error {
+// checkURLExtension verifies that the path of rawURL ends with one of the file extensions in
+// allowedExtensions, which is a comma-separated list such as ".xlsx,.xls". Matching is not
+// case-sensitive and the leading dot of each allowed extension is optional.
+// Any extension is allowed when allowedExtensions is empty.
+func checkURLExtension(rawURL, allowedExtensions string) error {
+	if strings.TrimSpace(allowedExtensions) == "" {
+		return nil
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	for _, allowed := range strings.Split(allowedExtensions, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && ext == "."+strings.TrimPrefix(allowed, ".") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnexpectedExtension, ext)
+}
+
+// enqueueURLForDownload sends a message to download url to the destination queue of source.
+// At most maxSQSMessageAttributes of attrs are sent as message attributes (preferring those
+// named by env.PriorityMessageAttributes); the remainder are included in the message body.
+func enqueueURLForDownload(ctx context.Context, client SQSAPI, source Source, url string, fileKey string, companionKeys []string, attrs map[string]string) error {
+	attributes, spilled := capMessageAttributes(attrs, priorityMessageAttributes, maxSQSMessageAttributes)
+	if len(spilled) > 0 {
+		log.Warn(logger, "Too many message attributes; including the excess in the message body",
+			"count_attributes", len(attributes), "count_spilled", len(spilled))
+		sendMetric("message.attributes_spilled", float64(len(spilled)), source.metricTag())
+	}
+
 	messageObj := ffis.FFISMessageDownload{
 		DownloadURL:   url,
 		SourceFileKey: fileKey,
+		Attributes:    spilled,
+		CompanionKeys: companionKeys,
 	}
 	serializedMessage, err := json.Marshal(messageObj)
 	if err != nil {
@@ -123,8 +552,9 @@ func enqueueURLForDownload(ctx context.Context, client SQSAPI, url string, fileK
 	}
 
 	message := sqs.SendMessageInput{
-		MessageBody: aws.String(string(serializedMessage)),
-		QueueUrl:    aws.String(env.DestinationQueueURL),
+		MessageBody:       aws.String(string(serializedMessage)),
+		MessageAttributes: attributes,
+		QueueUrl:          aws.String(source.QueueURL),
 	}
 
 	output, err := client.SendMessage(ctx, &message)
@@ -136,19 +566,29 @@ func enqueueURLForDownload(ctx context.Context, client SQSAPI, url string, fileK
 	return nil
 }
 
-func getEmailFromS3Event(ctx context.Context, s3client S3API, s3Event events.S3Event, uploadedFileName string) (io.ReadCloser, error) {
-	bucket := s3Event.Records[0].S3.Bucket.Name
-
-	logger := log.With(logger, "bucket", bucket, "key", uploadedFileName)
+// readEmailFromS3 reads the entire contents of the email object at key in bucket.
+// Returns ErrTruncatedDownload when fewer bytes are read than the object's reported
+// content length (if known), since parsing a truncated email may silently lose its links.
+func readEmailFromS3(ctx context.Context, s3client S3API, bucket, key string) ([]byte, error) {
+	logger := log.With(logger, "bucket", bucket, "key", key)
 	log.Debug(logger, "Reading from bucket")
 	// Get the email body
 	resp, err := s3client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(uploadedFileName),
+		Key:    aws.String(key),
 	})
+	if err != nil {
+		return nil, awsHelpers.WrapS3ObjectArchivedError(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	if resp.ContentLength > 0 && int64(len(b)) != resp.ContentLength {
+		return nil, fmt.Errorf("%w: read %d of %d bytes",
+			ErrTruncatedDownload, len(b), resp.ContentLength)
+	}
 	log.Info(logger, "Retrieved new email file")
-	return resp.Body, nil
+	return b, nil
 }
@@ -4,66 +4,156 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/mail"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/testsupport"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type MockS3 struct {
 	content string
+	// Content of other objects, by key; keys not present here are served content
+	objects map[string]string
+	// Keys for which GetObject fails with NoSuchKey
+	missing map[string]bool
+	// Keys for which GetObject blocks until the request's context is done, like a hung read
+	blocking map[string]bool
+	// Error returned by every GetObject request, when set
+	getObjectErr   error
+	getObjectCalls int
+	// Bytes by which the reported content length of every object exceeds its actual length
+	truncatedBytes int64
 }
 
 func (mocks3 *MockS3) GetObject(ctx context.Context,
 	params *s3.GetObjectInput,
 	optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	mocks3.getObjectCalls++
+	if mocks3.getObjectErr != nil {
+		return nil, mocks3.getObjectErr
+	}
+	if mocks3.missing[*params.Key] {
+		return nil, &s3Types.NoSuchKey{}
+	}
+	if mocks3.blocking[*params.Key] {
+		<-ctx.Done()
+		return nil, fmt.Errorf("operation error S3: GetObject, %w", ctx.Err())
+	}
 	contentBytes := []byte(mocks3.content)
+	if content, ok := mocks3.objects[*params.Key]; ok {
+		contentBytes = []byte(content)
+	}
 	return &s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewReader(contentBytes)),
-		ContentLength: int64(len(contentBytes)),
+		ContentLength: int64(len(contentBytes)) + mocks3.truncatedBytes,
 	}, nil
 }
 
-type MockSQS struct {
-	message *string
-}
+// Links used by generated test emails
+var (
+	testDownloadLink         = testsupport.Link{URL: "https://mcusercontent.com/123456/files/file-01.xlsx"}
+	testPreviousDownloadLink = testsupport.Link{
+		Text: "Click here to download the previous competitive grant update",
+		URL:  "https://mcusercontent.com/123456/files/file-02.xlsx",
+	}
+)
 
-func (mocksqs *MockSQS) SendMessage(ctx context.Context,
-	params *sqs.SendMessageInput,
-	optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
-	mocksqs.message = params.MessageBody
-	output := &sqs.SendMessageOutput{
-		MessageId: aws.String("123456789012345678901234567890"),
+// emailContent returns the contents of the named email fixture or, when fixture is empty,
+// the email generated from spec.
+func emailContent(t *testing.T, fixture string, spec testsupport.FFISEmail) []byte {
+	t.Helper()
+	if fixture != "" {
+		content, err := os.ReadFile("./fixtures/" + fixture)
+		require.NoError(t, err)
+		return content
 	}
-	return output, nil
+	content, err := spec.Build()
+	require.NoError(t, err)
+	return content
 }
 
 func TestHandleS3Event(t *testing.T) {
 	logger = log.NewNopLogger()
 	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	longURL := "https://mcusercontent.com/123456/files/" +
+		"FFIS-Competitive-Grant-Update-for-the-week-of-April-24-2023-final-revised.xlsx"
 	var tests = []struct {
-		emailFixture, expectedURL string
-		expectedError             error
+		name string
+		// Fixture emails (including captured emails, which are regression anchors for
+		// real-world formatting) are read from emailFixture; other cases are generated from email
+		emailFixture  string
+		email         testsupport.FFISEmail
+		expectedURL   string
+		expectedError error
 	}{
-		{"good.eml", "https://mcusercontent.com/123456/files/file-01.xlsx", nil},
-		{"missing.eml", "", ErrNoMatchesFound},
-		{"multiple.eml", "", ErrMultipleFound},
-		{"no-plaintext.eml", "", ErrNoPlaintext},
+		{"captured good.eml", "good.eml", testsupport.FFISEmail{},
+			"https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"captured base64-body.eml", "base64-body.eml", testsupport.FFISEmail{},
+			"https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"fixture missing.eml", "missing.eml", testsupport.FFISEmail{}, "", ErrNoMatchesFound},
+		{"fixture multiple.eml", "multiple.eml", testsupport.FFISEmail{}, "", ErrMultipleFound},
+		{"fixture no-plaintext.eml", "no-plaintext.eml", testsupport.FFISEmail{}, "", ErrNoPlaintext},
+		{"single link", "", testsupport.FFISEmail{Links: []testsupport.Link{testDownloadLink}},
+			"https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"no matching link", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{{URL: "https://usdigitalresponse.org"}},
+		}, "", ErrNoMatchesFound},
+		{"multiple links", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink, testPreviousDownloadLink},
+		}, "", ErrMultipleFound},
+		{"no plaintext", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink}, OmitPlainText: true,
+		}, "", ErrNoPlaintext},
+		{"base64 single part", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink}, SinglePart: true, Encoding: testsupport.EncodingBase64,
+		}, "https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"base64 multipart", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink}, Encoding: testsupport.EncodingBase64,
+		}, "https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"quoted-printable soft line breaks", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{{URL: longURL}}, Encoding: testsupport.EncodingQuotedPrintable,
+		}, longURL, nil},
+		{"nested multipart", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink}, Nesting: 2,
+		}, "https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"plaintext attachment", "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink},
+			Attachments: []testsupport.Attachment{{
+				Filename:    "notes.txt",
+				ContentType: "text/plain",
+				Content:     []byte("<https://mcusercontent.com/123456/files/notes.xlsx>"),
+			}},
+		}, "https://mcusercontent.com/123456/files/file-01.xlsx", nil},
 	}
 
 	for _, test := range tests {
-		t.Run(test.emailFixture, func(t *testing.T) {
-			content, err := os.ReadFile("./fixtures/" + test.emailFixture)
-			if err != nil {
-				t.Errorf("Error opening file: %v", err)
-			}
+		t.Run(test.name, func(t *testing.T) {
+			content := emailContent(t, test.emailFixture, test.email)
 			mocks3, mocksqs := getMockClients()
 			mocks3.content = string(content)
 			s3FileKey := "test/email/file.eml"
@@ -83,28 +173,23 @@ func TestHandleS3Event(t *testing.T) {
 				},
 			}
 
-			err = handleS3Event(ctx, s3Event, mocks3, mocksqs)
+			err := handleS3Event(ctx, s3Event, mocks3, mocksqs)
 
 			if test.expectedURL != "" {
-				var message ffis.FFISMessageDownload
 				if err != nil {
 					t.Errorf("Error parsing S3 event: %v", err)
 				}
-				err = json.Unmarshal([]byte(*mocksqs.message), &message)
-				if err != nil {
-					t.Errorf("Error parsing SQS message: %v", err)
-				}
+				sent, message := sentDownloadMessage(t, mocksqs)
 				if message.DownloadURL != test.expectedURL {
 					t.Errorf("Expected message %v, got %v", test.expectedURL, message.DownloadURL)
 				}
 				if message.SourceFileKey != s3FileKey {
 					t.Errorf("Expected message %v, got %v", s3FileKey, message.SourceFileKey)
 				}
+				testsupport.AssertAttribute(t, sent, "SourceKey", s3FileKey)
+				testsupport.AssertAttribute(t, sent, "DownloadHost", "mcusercontent.com")
 			} else {
-				// parse expected bad message
-				if mocksqs.message == nil && test.expectedURL != "" {
-					t.Errorf("Expected message for %s to be empty", test.emailFixture)
-				}
+				assert.Empty(t, mocksqs.Sent(), "No message should be sent for %s", test.name)
 				// error message can be wrapped, so we need to check for the substring
 				if !strings.Contains(err.Error(), test.expectedError.Error()) {
 					t.Errorf("Expected error %v, got %v", test.expectedError, err)
@@ -114,8 +199,1146 @@ func TestHandleS3Event(t *testing.T) {
 	}
 }
 
-func getMockClients() (*MockS3, *MockSQS) {
+func TestHandleInvocationAdminReprocess(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	content, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+
+	t.Run("valid reprocess request", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		resp, err := handleInvocation(context.Background(), json.RawMessage(
+			`{"adminAction": "reprocess", "bucket": "test-bucket", "key": "sources/2023/4/24/raw.eml"}`),
+			mocks3, mocksqs)
+		require.NoError(t, err)
+		adminResp, ok := resp.(eventHelpers.AdminResponse)
+		require.True(t, ok, "Unexpected response type %T", resp)
+		require.Len(t, adminResp.Results, 1)
+		assert.Equal(t, eventHelpers.RecordStatusSucceeded, adminResp.Results[0].Status)
+		assert.Equal(t, "sources/2023/4/24/raw.eml", adminResp.Results[0].Key)
+
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "sources/2023/4/24/raw.eml", message.SourceFileKey)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		_, err := handleInvocation(context.Background(), json.RawMessage(
+			`{"adminAction": "reprocess", "bucket": "test-bucket"}`), mocks3, mocksqs)
+		assert.ErrorIs(t, err, eventHelpers.ErrMissingAdminParameter)
+		assert.Empty(t, mocksqs.Sent())
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		_, err := handleInvocation(context.Background(), json.RawMessage(
+			`{"adminAction": "purge", "bucket": "test-bucket", "key": "some/key"}`), mocks3, mocksqs)
+		assert.ErrorIs(t, err, eventHelpers.ErrUnknownAdminAction)
+		assert.Empty(t, mocksqs.Sent())
+	})
+}
+
+func TestHandleInvocationHealthCheck(t *testing.T) {
+	logger = log.NewNopLogger()
+
+	t.Run("healthy", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		resp, err := handleInvocation(context.Background(), json.RawMessage(`{"healthcheck": true}`), mocks3, mocksqs)
+		require.NoError(t, err)
+		report, ok := resp.(eventHelpers.HealthReport)
+		require.True(t, ok, "Unexpected response type %T", resp)
+		assert.True(t, report.Healthy)
+		assert.Empty(t, mocksqs.Sent())
+	})
+
+	t.Run("destination queue unreachable", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocksqs.FailCall(testsupport.SQSGetQueueAttributes, 1, errors.New("queue does not exist"))
+		_, err := handleInvocation(context.Background(), json.RawMessage(`{"healthcheck": true}`), mocks3, mocksqs)
+		assert.ErrorIs(t, err, eventHelpers.ErrHealthCheckFailed)
+		var healthErr *eventHelpers.HealthCheckError
+		require.ErrorAs(t, err, &healthErr, "The report should be returned to the invoker")
+		report := healthErr.Report
+		assert.False(t, report.Healthy)
+		assert.Equal(t, "sqs.destination_queue", report.Checks[0].Name)
+		assert.Equal(t, eventHelpers.HealthCheckStatusFailed, report.Checks[0].Status)
+		assert.Equal(t, "queue does not exist", report.Checks[0].Error)
+	})
+}
+
+func TestHandleInvocationDescribe(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureEnqueueDownload: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	env.DestinationQueueURL = "https://sqs.example.com/ffis"
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	env.WebhookURL = "https://hooks.example.com/services/s3cr3t-webhook"
+	env.PostProcessWebhookURL = "https://example.com/notify?token=s3cr3t-token"
+	env.Extras = map[string]string{"AWS_SESSION_TOKEN": "s3cr3t-session"}
+
+	mocks3, mocksqs := getMockClients()
+	resp, err := handleInvocation(context.Background(), json.RawMessage(`{"adminAction": "describe"}`), mocks3, mocksqs)
+	require.NoError(t, err)
+	description, ok := resp.(eventHelpers.DescribeResponse)
+	require.True(t, ok, "Unexpected response type %T", resp)
+	assert.Equal(t, "EnqueueFFISDownload", description.Handler)
+	assert.Equal(t, []string{"enqueue_download"}, description.DisabledFeatures)
+	assert.Equal(t, []Source{{
+		Name:       "ffis.org",
+		URLPattern: "https://mcusercontent.com/.+\\.xlsx",
+		QueueURL:   "https://sqs.example.com/ffis",
+	}}, description.Sources)
+	assert.Equal(t, "https://sqs.example.com/ffis", description.Environment["DestinationQueueURL"])
+	assert.Equal(t, awsHelpers.RedactedValue, description.Environment["WebhookURL"])
+	assert.Equal(t, awsHelpers.RedactedValue, description.Environment["PostProcessWebhookURL"])
+	assert.Equal(t, "", description.Environment["SentryDSN"], "Unconfigured secrets should not be redacted")
+	assert.Equal(t, awsHelpers.RedactedValue, description.Environment["Extras"])
+	assert.Empty(t, mocksqs.Sent())
+
+	encoded, err := json.Marshal(resp)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "s3cr3t")
+	assert.Contains(t, string(encoded), `"sources":[{"name":"ffis.org","destinationSubpath":"","urlPattern":`)
+}
+
+func TestHandleInvocationFeatureDisabled(t *testing.T) {
+	logger = log.NewNopLogger()
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureEnqueueDownload: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	content, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+	mocks3, mocksqs := getMockClients()
+	mocks3.content = string(content)
+	payload, err := json.Marshal(events.S3Event{Records: []events.S3EventRecord{
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "test-bucket"},
+			Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "test-bucket"},
+			Object: events.S3Object{Key: "sources/2023/04/25/ffis.org/raw.eml"}}},
+	}})
+	require.NoError(t, err)
+
+	resp, err := handleInvocation(context.Background(), payload, mocks3, mocksqs)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, 0, mocks3.getObjectCalls, "S3 should not be called while disabled")
+	assert.Equal(t, 0, mocksqs.CallCount(testsupport.SQSSendMessage), "SQS should not be called while disabled")
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 2}, sentMetrics)
+}
+
+func TestBenignErrorSuppression(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	content, err := os.ReadFile("./fixtures/missing.eml")
+	require.NoError(t, err)
+
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() {
+		sendMetric = restoreSendMetric
+		benignErrors = nil
+	})
+
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}}}
+
+	t.Run("unsuppressed error counts as failure", func(t *testing.T) {
+		benignErrors, sentMetrics = nil, map[string]float64{}
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		assert.ErrorIs(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs), ErrNoMatchesFound)
+		assert.Equal(t, float64(1), sentMetrics["email.failed"])
+		assert.NotContains(t, sentMetrics, "email.benign_failure")
+	})
+
+	t.Run("suppressed error counts as benign failure", func(t *testing.T) {
+		benignErrors, err = parseBenignErrors("ErrNoMatchesFound, ErrMultipleFound")
+		require.NoError(t, err)
+		sentMetrics = map[string]float64{}
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		assert.ErrorIs(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs), ErrNoMatchesFound)
+		assert.NotContains(t, sentMetrics, "email.failed")
+		assert.Equal(t, float64(1), sentMetrics["email.benign_failure"])
+	})
+}
+
+func TestParseBenignErrors(t *testing.T) {
+	errs, err := parseBenignErrors("")
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	errs, err = parseBenignErrors("ErrNoMatchesFound,ErrNoPlaintext")
+	require.NoError(t, err)
+	assert.Equal(t, []error{ErrNoMatchesFound, ErrNoPlaintext}, errs)
+
+	_, err = parseBenignErrors("ErrNoMatchesFound,ErrSomethingElse")
+	assert.ErrorContains(t, err, "ErrSomethingElse")
+}
+
+func TestCheckURLExtension(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		url               string
+		allowedExtensions string
+		expErr            error
+	}{
+		{"allowed xlsx link", "https://mcusercontent.com/123456/files/file-01.xlsx", ".xlsx", nil},
+		{"disallowed html link", "https://mcusercontent.com/123456/files/file-01.html", ".xlsx", ErrUnexpectedExtension},
+		{"case-insensitive match", "https://mcusercontent.com/123456/files/file-01.XLSX", "xlsx, .xls", nil},
+		{"query string is ignored", "https://mcusercontent.com/files/file-01.xls?dl=1", ".xlsx,.xls", nil},
+		{"missing extension", "https://mcusercontent.com/123456/files/file-01", ".xlsx", ErrUnexpectedExtension},
+		{"no restriction when unconfigured", "https://mcusercontent.com/123456/files/file-01.html", "", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkURLExtension(tt.url, tt.allowedExtensions)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHandleS3EventAllowedExtensions(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.URLPattern = "https://mcusercontent.com/[^\\s>]+"
+	env.AllowedExtensions = ".xlsx"
+	t.Cleanup(func() {
+		env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+		env.AllowedExtensions = ""
+	})
+	content, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}}}
+
+	var auditEvents bytes.Buffer
+	restoreAuditLogger := auditLogger
+	auditLogger = log.NewJSONLogger(&auditEvents)
+	t.Cleanup(func() { auditLogger = restoreAuditLogger })
+	lastAuditEvent := func(t *testing.T) map[string]interface{} {
+		t.Helper()
+		lines := strings.Split(strings.TrimSpace(auditEvents.String()), "\n")
+		event := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &event))
+		return event
+	}
+
+	t.Run("allowed xlsx link is enqueued", func(t *testing.T) {
+		auditEvents.Reset()
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx", message.DownloadURL)
+
+		event := lastAuditEvent(t)
+		assert.Equal(t, "accept", event["decision"])
+		assert.Equal(t, "allowed_extensions", event["rule"])
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx", event["subject"])
+		assert.Equal(t, "sources/2023/04/24/ffis.org/raw.eml", event["key"])
+	})
+
+	t.Run("disallowed html link is rejected", func(t *testing.T) {
+		auditEvents.Reset()
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = strings.ReplaceAll(string(content), "file-01.xlsx", "file-01.html")
+		assert.ErrorIs(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs),
+			ErrUnexpectedExtension)
+		assert.Empty(t, mocksqs.Sent())
+
+		event := lastAuditEvent(t)
+		assert.Equal(t, "reject", event["decision"])
+		assert.Equal(t, "allowed_extensions", event["rule"])
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.html", event["subject"])
+		assert.Contains(t, event["reason"], ErrUnexpectedExtension.Error())
+	})
+}
+
+func TestHandleS3EventDecodeHTMLEntities(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.URLPattern = "https://mcusercontent.com/[^\\s<>]+"
+	env.AllowedExtensions = ""
+	content, err := os.ReadFile("./fixtures/entity-encoded.eml")
+	require.NoError(t, err)
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}}}
+
+	t.Run("encoded URL is not found when decoding is disabled", func(t *testing.T) {
+		env.DecodeHTMLEntities = false
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		assert.ErrorIs(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs), ErrNoMatchesFound)
+		assert.Empty(t, mocksqs.Sent())
+	})
+
+	t.Run("encoded URL is enqueued when decoding is enabled", func(t *testing.T) {
+		env.DecodeHTMLEntities = true
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx?id=abc&e=def", message.DownloadURL)
+	})
+
+	t.Run("entities are decoded only once", func(t *testing.T) {
+		assert.Equal(t, "a=1&amp;b=2 & c", decodeHTMLEntities(logger, "a=1&amp;amp;b=2 & c"))
+	})
+}
+
+func TestHandleS3EventMaxJobAge(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	env.AllowedExtensions = ""
+	env.MaxJobAge = 24 * time.Hour
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}}}
+
+	t.Run("fresh email is enqueued", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(emailContent(t, "", testsupport.FFISEmail{
+			Date:  time.Now().Add(-time.Hour),
+			Links: []testsupport.Link{testDownloadLink},
+		}))
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, testDownloadLink.URL, message.DownloadURL)
+		assert.Zero(t, sentMetrics["download.stale_skipped"])
+	})
+
+	t.Run("stale email is skipped", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(emailContent(t, "", testsupport.FFISEmail{
+			Date:  time.Now().Add(-72 * time.Hour),
+			Links: []testsupport.Link{testDownloadLink},
+		}))
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		assert.Empty(t, mocksqs.Sent())
+		assert.Equal(t, 1.0, sentMetrics["download.stale_skipped"])
+	})
+
+	t.Run("event time is used without a Date header", func(t *testing.T) {
+		eventTime := time.Now().Add(-48 * time.Hour)
+		sentAt, origin, ok := emailSentAt([]byte("not an email"), eventTime)
+		require.True(t, ok)
+		assert.Equal(t, eventTime, sentAt)
+		assert.Equal(t, emailAgeFromEventTime, origin)
+
+		_, _, ok = emailSentAt([]byte("not an email"), time.Time{})
+		assert.False(t, ok)
+	})
+}
+
+func TestHandleS3EventParseErrorContext(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	content, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}}}
+
+	t.Run("broken header", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = strings.Replace(string(content), "From:", "This digest line is broken\nFrom:", 1)
+		err := handleS3Event(context.Background(), s3Event, mocks3, mocksqs)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.InDelta(t, 5, parseErr.Line, 1, "Line should be near the broken header")
+		assert.Contains(t, parseErr.Snippet, "This digest line is broken")
+		assert.NotContains(t, parseErr.Snippet, "ffis@ffis.org", "Addresses should be redacted")
+		assert.Contains(t, parseErr.Snippet, redactedAddress)
+		assert.ErrorContains(t, err, fmt.Sprintf("near line %d", parseErr.Line))
+		assert.Empty(t, mocksqs.Sent())
+	})
+
+	t.Run("broken content type", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = strings.Replace(string(content), "multipart/alternative;", "multipart/alternative;;;", 1)
+		err := handleS3Event(context.Background(), s3Event, mocks3, mocksqs)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Contains(t, parseErr.Snippet, "multipart/alternative;;;")
+	})
+
+	t.Run("missing plaintext is not a parse error", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(emailContent(t, "", testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink}, OmitPlainText: true,
+		}))
+		err := handleS3Event(context.Background(), s3Event, mocks3, mocksqs)
+		assert.ErrorIs(t, err, ErrNoPlaintext)
+		var parseErr *ParseError
+		assert.False(t, errors.As(err, &parseErr))
+	})
+}
+
+func getMockClients() (*MockS3, *testsupport.RecordingSQS) {
 	mocks3 := MockS3{content: "test"}
-	mocksqs := MockSQS{}
-	return &mocks3, &mocksqs
+	return &mocks3, &testsupport.RecordingSQS{}
+}
+
+// sentDownloadMessage returns the single download message that was sent to mocksqs.
+func sentDownloadMessage(t *testing.T, mocksqs *testsupport.RecordingSQS) (testsupport.SentMessage, ffis.FFISMessageDownload) {
+	t.Helper()
+	sent := mocksqs.Sent()
+	require.Len(t, sent, 1, "Expected a single download message")
+	var message ffis.FFISMessageDownload
+	require.NoError(t, json.Unmarshal([]byte(sent[0].Body), &message))
+	return sent[0], message
+}
+
+func TestHandleS3EventMultipleSources(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.SourcesConfig = `[
+		{"destinationSubpath": "ffis.org", "urlPattern": "https://mcusercontent.com/.+\\.xlsx", "queueUrl": "https://sqs.example.com/ffis"},
+		{"name": "state-updates", "destinationSubpath": "state_updates", "urlPattern": "https://mcusercontent.com/.+\\.xlsx", "queueUrl": "https://sqs.example.com/state"}
+	]`
+	var err error
+	sources, err = loadSources(env)
+	require.NoError(t, err)
+	t.Cleanup(func() { sources = nil })
+	sentTags := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentTags[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	goodEmail, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+	missingEmail, err := os.ReadFile("./fixtures/missing.eml")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		key              string
+		expectedSource   string
+		expectedQueueURL string
+	}{
+		{"sources/2023/04/24/ffis.org/raw.eml", "ffis.org", "https://sqs.example.com/ffis"},
+		{"sources/2023/04/24/state_updates/raw.eml", "state-updates", "https://sqs.example.com/state"},
+	} {
+		t.Run(tt.expectedSource, func(t *testing.T) {
+			record := events.S3EventRecord{S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "test-bucket"},
+				Object: events.S3Object{Key: tt.key},
+			}}
+			mocks3, mocksqs := getMockClients()
+			mocks3.content = string(goodEmail)
+			require.NoError(t, handleS3Event(context.Background(),
+				events.S3Event{Records: []events.S3EventRecord{record}}, mocks3, mocksqs))
+			sent := mocksqs.AssertSentToQueue(t, tt.expectedQueueURL, 1)
+			require.Len(t, sent, 1)
+			var message ffis.FFISMessageDownload
+			require.NoError(t, json.Unmarshal([]byte(sent[0].Body), &message))
+			assert.Equal(t, tt.key, message.SourceFileKey)
+
+			mocks3.content = string(missingEmail)
+			assert.ErrorIs(t, handleS3Event(context.Background(),
+				events.S3Event{Records: []events.S3EventRecord{record}}, mocks3, mocksqs), ErrNoMatchesFound)
+			assert.Equal(t, []string{"source:" + tt.expectedSource}, sentTags["email.failed"])
+		})
+	}
+
+	t.Run("unmatched key is skipped", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(goodEmail)
+		require.NoError(t, handleS3Event(context.Background(), events.S3Event{Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "test-bucket"},
+				Object: events.S3Object{Key: "sources/2023/04/24/unconfigured/raw.eml"},
+			},
+		}}}, mocks3, mocksqs))
+		assert.Empty(t, mocksqs.Sent())
+		assert.Contains(t, sentTags, "email.unmatched_source")
+	})
+
+	t.Run("health check covers every queue", func(t *testing.T) {
+		mocks3, mocksqs := getMockClients()
+		resp, err := handleInvocation(context.Background(), json.RawMessage(`{"healthcheck": true}`), mocks3, mocksqs)
+		require.NoError(t, err)
+		report, ok := resp.(eventHelpers.HealthReport)
+		require.True(t, ok, "Unexpected response type %T", resp)
+		names := []string{}
+		for _, check := range report.Checks {
+			names = append(names, check.Name)
+		}
+		assert.ElementsMatch(t, []string{
+			"sqs.destination_queue:ffis.org", "sqs.destination_queue:state-updates", "metrics",
+		}, names)
+	})
+}
+
+func TestSQSCircuitBreaker(t *testing.T) {
+	logger = log.NewNopLogger()
+	sendErr := errors.New("service unavailable")
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		mocksqs := &testsupport.RecordingSQS{}
+		mocksqs.FailAll(sendErr)
+		breaker := newCircuitBreakerSQS(mocksqs, 3)
+		for i := 0; i < 3; i++ {
+			_, err := breaker.SendMessage(context.TODO(), &sqs.SendMessageInput{})
+			assert.ErrorIs(t, err, sendErr)
+		}
+		for i := 0; i < 5; i++ {
+			_, err := breaker.SendMessage(context.TODO(), &sqs.SendMessageInput{})
+			assert.ErrorIs(t, err, ErrCircuitOpen)
+		}
+		assert.Equal(t, 3, mocksqs.CallCount(testsupport.SQSSendMessage), "Sends should stop once the circuit opens")
+	})
+
+	t.Run("successful send resets failures", func(t *testing.T) {
+		mocksqs := &testsupport.RecordingSQS{}
+		mocksqs.FailCall(testsupport.SQSSendMessage, 1, sendErr)
+		mocksqs.FailCall(testsupport.SQSSendMessage, 3, sendErr)
+		breaker := newCircuitBreakerSQS(mocksqs, 2)
+		_, err := breaker.SendMessage(context.TODO(), &sqs.SendMessageInput{})
+		assert.ErrorIs(t, err, sendErr)
+		_, err = breaker.SendMessage(context.TODO(), &sqs.SendMessageInput{})
+		assert.NoError(t, err)
+		_, err = breaker.SendMessage(context.TODO(), &sqs.SendMessageInput{})
+		assert.ErrorIs(t, err, sendErr)
+		assert.Equal(t, 3, mocksqs.CallCount(testsupport.SQSSendMessage))
+	})
+
+	t.Run("persistently failing batch", func(t *testing.T) {
+		env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+		env.SQSCircuitBreakerThreshold = 2
+		env.DeterministicOrder = true
+		t.Cleanup(func() {
+			env.SQSCircuitBreakerThreshold = 0
+			env.DeterministicOrder = false
+		})
+		content, err := os.ReadFile("./fixtures/good.eml")
+		require.NoError(t, err)
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		mocksqs.FailAll(sendErr)
+
+		records := []events.S3EventRecord{}
+		for _, key := range []string{"a.eml", "b.eml", "c.eml", "d.eml", "e.eml"} {
+			records = append(records, events.S3EventRecord{S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "test-bucket"},
+				Object: events.S3Object{Key: key},
+			}})
+		}
+		results, err := handleRecords(context.TODO(), records, mocks3, mocksqs)
+		assert.ErrorIs(t, err, sendErr)
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.Equal(t, 2, mocksqs.CallCount(testsupport.SQSSendMessage), "Breaker should open after 2 failed sends")
+		require.Len(t, results, 5)
+		for _, result := range results {
+			assert.Equal(t, eventHelpers.RecordStatusFailed, result.Status)
+		}
+		assert.Contains(t, results[4].Error, ErrCircuitOpen.Error())
+	})
+}
+
+func TestParseMessageAttributes(t *testing.T) {
+	attrs, err := parseMessageAttributes("")
+	assert.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	attrs, err = parseMessageAttributes("Environment=staging, Route = ffis ,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"Environment": "staging", "Route": "ffis"}, attrs)
+
+	for _, malformed := range []string{"Environment", "=staging", "Environment="} {
+		_, err = parseMessageAttributes(malformed)
+		assert.Error(t, err, malformed)
+	}
+
+	assert.Equal(t, []string{"SourceKey", "Route"}, parseAttributeNames(" SourceKey,,Route "))
+	assert.Equal(t, []string{}, parseAttributeNames(""))
+}
+
+func TestCapMessageAttributes(t *testing.T) {
+	attrs := map[string]string{"Empty": ""}
+	for i := 0; i < 12; i++ {
+		attrs[fmt.Sprintf("Attr%02d", i)] = fmt.Sprintf("value%d", i)
+	}
+
+	t.Run("within limit", func(t *testing.T) {
+		selected, spilled := capMessageAttributes(map[string]string{"A": "a", "Empty": ""}, nil, 10)
+		assert.Equal(t, map[string]sqsTypes.MessageAttributeValue{
+			"A": {DataType: aws.String("String"), StringValue: aws.String("a")},
+		}, selected)
+		assert.Nil(t, spilled)
+	})
+
+	t.Run("exceeding limit", func(t *testing.T) {
+		selected, spilled := capMessageAttributes(attrs, nil, maxSQSMessageAttributes)
+		assert.Len(t, selected, maxSQSMessageAttributes)
+		assert.Contains(t, selected, "Attr00")
+		assert.Contains(t, selected, "Attr09")
+		assert.Equal(t, map[string]string{"Attr10": "value10", "Attr11": "value11"}, spilled)
+	})
+
+	t.Run("priority attributes are selected first", func(t *testing.T) {
+		selected, spilled := capMessageAttributes(attrs,
+			[]string{"Attr11", "Unknown", "Attr10"}, maxSQSMessageAttributes)
+		assert.Len(t, selected, maxSQSMessageAttributes)
+		assert.Equal(t, "value11", *selected["Attr11"].StringValue)
+		assert.Equal(t, "value10", *selected["Attr10"].StringValue)
+		assert.Equal(t, map[string]string{"Attr08": "value8", "Attr09": "value9"}, spilled)
+	})
+}
+
+func TestEnqueueURLForDownloadSpillsExcessAttributes(t *testing.T) {
+	logger = log.NewNopLogger()
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	priorityMessageAttributes = []string{"SourceKey"}
+	t.Cleanup(func() { priorityMessageAttributes = nil })
+
+	attrs := map[string]string{"SourceKey": "test/email/file.eml"}
+	for i := 0; i < 11; i++ {
+		attrs[fmt.Sprintf("Route%02d", i)] = fmt.Sprintf("queue%d", i)
+	}
+	_, mocksqs := getMockClients()
+	err := enqueueURLForDownload(context.TODO(), mocksqs, legacySource(env),
+		"https://mcusercontent.com/123456/files/file-01.xlsx", "test/email/file.eml", nil, attrs)
+	require.NoError(t, err)
+
+	sent, message := sentDownloadMessage(t, mocksqs)
+	assert.Len(t, sent.Attributes, maxSQSMessageAttributes)
+	testsupport.AssertAttribute(t, sent, "SourceKey", "test/email/file.eml")
+	assert.Equal(t, map[string]string{"Route09": "queue9", "Route10": "queue10"}, message.Attributes)
+	assert.Equal(t, 2.0, sentMetrics["message.attributes_spilled"])
+	for name := range message.Attributes {
+		assert.NotContains(t, sent.Attributes, name, "Spilled attributes should not also be sent")
+	}
+}
+
+func TestParseURLFromEmailBodyWithMinConfidence(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.URLPattern = `https?://[^\s<>]+`
+	env.URLHostAllowlist = "mcusercontent.com"
+	env.AllowedExtensions = ""
+
+	clearWinner := testsupport.FFISEmail{
+		Links: []testsupport.Link{testDownloadLink},
+		FooterLinks: []testsupport.Link{
+			{Text: "Follow us", URL: "https://www.facebook.com/ffis.org"},
+			{Text: "Follow us", URL: "https://twitter.com/ffis_org"},
+			{Text: "Unsubscribe", URL: "https://ffis.us1.list-manage.com/unsubscribe?u=123456&id=abcdef"},
+		},
+		OmitHTML: true,
+	}
+	ambiguous := testsupport.FFISEmail{
+		Links:       []testsupport.Link{testDownloadLink, testPreviousDownloadLink},
+		FooterLinks: []testsupport.Link{{Text: "Follow us", URL: "https://www.facebook.com/ffis.org"}},
+		OmitHTML:    true,
+	}
+
+	for _, tt := range []struct {
+		name          string
+		emailFixture  string
+		email         testsupport.FFISEmail
+		minConfidence float64
+		expectedURL   string
+		expectedError error
+	}{
+		{"clear winner", "", clearWinner, 0.5, "https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"clear winner", "", clearWinner, 0, "", ErrMultipleFound},
+		{"ambiguous", "", ambiguous, 0.5, "", ErrMultipleFound},
+		{"fixture clear-winner.eml", "clear-winner.eml", testsupport.FFISEmail{}, 0.5,
+			"https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+		{"fixture clear-winner.eml", "clear-winner.eml", testsupport.FFISEmail{}, 0, "", ErrMultipleFound},
+		{"fixture ambiguous.eml", "ambiguous.eml", testsupport.FFISEmail{}, 0.5, "", ErrMultipleFound},
+		{"captured good.eml", "good.eml", testsupport.FFISEmail{}, 0.5,
+			"https://mcusercontent.com/123456/files/file-01.xlsx", nil},
+	} {
+		t.Run(fmt.Sprintf("%s with minimum confidence %v", tt.name, tt.minConfidence), func(t *testing.T) {
+			env.MinURLConfidence = tt.minConfidence
+			content := emailContent(t, tt.emailFixture, tt.email)
+			plaintext, err := plaintextMIMEFromEmailBody(bytes.NewReader(content))
+			require.NoError(t, err)
+
+			url, err := parseURLFromEmailBody(legacySource(env), plaintext)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedURL, url)
+		})
+	}
+}
+
+func TestScoreURLs(t *testing.T) {
+	scored := scoreURLs([]string{
+		"https://www.facebook.com/ffis.org",
+		"https://mcusercontent.com/123456/files/file-01.xlsx",
+		"https://cdn.mcusercontent.com/123456/files/logo.png",
+		"https://example.com/file-02.xlsx",
+	}, []string{"mcusercontent.com"}, ".xlsx")
+
+	urls := []string{}
+	for _, s := range scored {
+		urls = append(urls, s.URL)
+	}
+	assert.Equal(t, []string{
+		"https://mcusercontent.com/123456/files/file-01.xlsx",
+		"https://cdn.mcusercontent.com/123456/files/logo.png",
+		"https://example.com/file-02.xlsx",
+		"https://www.facebook.com/ffis.org",
+	}, urls)
+	assert.InDelta(t, 0.6+0.3+0.075, scored[0].Score, 1e-9)
+	assert.InDelta(t, 0.1, scored[3].Score, 1e-9)
+}
+
+func TestProcessRecordTracing(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}
+	recordSpans := func(t *testing.T) *tracetest.SpanRecorder {
+		recorder := tracetest.NewSpanRecorder()
+		tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+			sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+		t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+		return recorder
+	}
+	spansByName := func(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+		spans := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+		return spans
+	}
+
+	t.Run("successful record", func(t *testing.T) {
+		recorder := recordSpans(t)
+		content, err := os.ReadFile("./fixtures/good.eml")
+		require.NoError(t, err)
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		require.NoError(t, processRecord(context.Background(), record, mocks3, mocksqs))
+
+		spans := spansByName(recorder)
+		require.Contains(t, spans, "handle.record")
+		recordSpan := spans["handle.record"]
+		assert.Contains(t, recordSpan.Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeSuccess))
+		for _, name := range []string{"email.fetch", "email.parse", "url.match", "companions.fetch", "message.send"} {
+			require.Contains(t, spans, name)
+			assert.Equal(t, recordSpan.SpanContext().SpanID(), spans[name].Parent().SpanID(),
+				"%s should be a child of handle.record", name)
+			assert.Contains(t, spans[name].Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeSuccess))
+		}
+		assert.Contains(t, spans["email.fetch"].Attributes(), attribute.Int("bytes", len(content)))
+		assert.Contains(t, spans["email.parse"].Attributes(), attribute.Bool("compressed", false))
+		assert.Contains(t, spans["url.match"].Attributes(), attribute.String("download_host", "mcusercontent.com"))
+	})
+
+	t.Run("no URL matched", func(t *testing.T) {
+		recorder := recordSpans(t)
+		content, err := os.ReadFile("./fixtures/missing.eml")
+		require.NoError(t, err)
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		assert.ErrorIs(t, processRecord(context.Background(), record, mocks3, mocksqs), ErrNoMatchesFound)
+
+		spans := spansByName(recorder)
+		require.Contains(t, spans, "url.match")
+		assert.Equal(t, codes.Error, spans["url.match"].Status().Code)
+		assert.Contains(t, spans["url.match"].Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeError))
+		assert.Equal(t, codes.Error, spans["handle.record"].Status().Code)
+		assert.NotContains(t, spans, "message.send")
+		assert.NotContains(t, spans, "companions.fetch")
+	})
+}
+
+func TestRecordTimeout(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	restoreSendMetric := sendMetric
+	t.Cleanup(func() {
+		env = restoreEnv
+		sendMetric = restoreSendMetric
+	})
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	env.RecordTimeout = 50 * time.Millisecond
+	env.DeterministicOrder = true // a stuck record must not hold up the records after it
+	sentMetrics := map[string]float64{}
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	content, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+	records := []events.S3EventRecord{}
+	for _, key := range []string{"a-stuck.eml", "b.eml"} {
+		records = append(records, events.S3EventRecord{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: "test-bucket"},
+			Object: events.S3Object{Key: key},
+		}})
+	}
+
+	t.Run("stuck record times out while its siblings complete", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		recorder := tracetest.NewSpanRecorder()
+		tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+			sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+		t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		mocks3.blocking = map[string]bool{"a-stuck.eml": true}
+
+		results, err := handleRecords(context.Background(), records, mocks3, mocksqs)
+		assert.ErrorIs(t, err, eventHelpers.ErrRecordTimeout)
+		require.Len(t, results, 2)
+		assert.Equal(t, eventHelpers.RecordStatusFailed, results[0].Status)
+		assert.Equal(t, "RecordTimeout", results[0].ErrorClass)
+		assert.Equal(t, eventHelpers.RecordStatusSucceeded, results[1].Status)
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "b.eml", message.SourceFileKey)
+		assert.Equal(t, 1.0, sentMetrics["record.timeout"])
+
+		timedOutKeys := []string{}
+		for _, span := range recorder.Ended() {
+			attrs := attribute.NewSet(span.Attributes()...)
+			if timedOut, ok := attrs.Value("timed_out"); span.Name() == "handle.record" && ok && timedOut.AsBool() {
+				key, _ := attrs.Value("source_key")
+				timedOutKeys = append(timedOutKeys, key.AsString())
+			}
+		}
+		assert.Equal(t, []string{"a-stuck.eml"}, timedOutKeys,
+			"only the stuck record's span should be tagged as timed out")
+	})
+
+	t.Run("sooner invocation deadline is not a record timeout", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		env.RecordTimeout = time.Hour
+		t.Cleanup(func() { env.RecordTimeout = 50 * time.Millisecond })
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		mocks3.blocking = map[string]bool{"a-stuck.eml": true}
+
+		results, err := handleRecords(ctx, records[:1], mocks3, mocksqs)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.NotErrorIs(t, err, eventHelpers.ErrRecordTimeout)
+		require.Len(t, results, 1)
+		assert.Equal(t, "DeadlineExceeded", results[0].ErrorClass)
+		assert.NotContains(t, sentMetrics, "record.timeout")
+	})
+}
+
+func TestParseCompanionReferencePattern(t *testing.T) {
+	re, err := parseCompanionReferencePattern("")
+	require.NoError(t, err)
+	assert.Nil(t, re)
+
+	re, err = parseCompanionReferencePattern(`errata object (\S+)`)
+	require.NoError(t, err)
+	assert.NotNil(t, re)
+
+	_, err = parseCompanionReferencePattern(`errata object (\S+`)
+	assert.ErrorContains(t, err, "invalid companion reference pattern")
+
+	_, err = parseCompanionReferencePattern(`(errata|addendum) object (\S+)`)
+	assert.ErrorContains(t, err, "at most one capture group")
+}
+
+func TestCompanionKeys(t *testing.T) {
+	pattern := regexp.MustCompile(`errata object (\S+)`)
+	emailKey := "sources/2023/04/24/ffis.org/raw.eml"
+	plaintext := strings.Join([]string{
+		"see errata object errata-01.eml",
+		"see errata object sources/2023/04/17/ffis.org/errata.eml",
+		"see errata object errata-01.eml",
+		"see errata object raw.eml",
+	}, "\n")
+	assert.Equal(t, []string{
+		"sources/2023/04/24/ffis.org/errata-01.eml",
+		"sources/2023/04/17/ffis.org/errata.eml",
+	}, companionKeys(pattern, plaintext, emailKey))
+	assert.Empty(t, companionKeys(pattern, "no references here", emailKey))
+}
+
+func TestHandleS3EventCompanions(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	var err error
+	companionReferencePattern, err = parseCompanionReferencePattern(`errata object (\S+)`)
+	require.NoError(t, err)
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() {
+		sendMetric = restoreSendMetric
+		companionReferencePattern = nil
+	})
+
+	content, err := os.ReadFile("./fixtures/with-errata.eml")
+	require.NoError(t, err)
+	emailKey := "sources/2023/04/24/ffis.org/raw.eml"
+	companionKey := "sources/2023/04/24/ffis.org/errata-01.eml"
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: emailKey},
+	}}}}
+
+	t.Run("resolvable companion is included in message", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		mocks3.objects = map[string]string{companionKey: "errata"}
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx", message.DownloadURL)
+		assert.Equal(t, []string{companionKey}, message.CompanionKeys)
+		assert.Equal(t, float64(1), sentMetrics["email.companion_resolved"])
+		assert.NotContains(t, sentMetrics, "email.companion_missing")
+	})
+
+	t.Run("missing companion is skipped", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		mocks3.missing = map[string]bool{companionKey: true}
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx", message.DownloadURL)
+		assert.Empty(t, message.CompanionKeys)
+		assert.Equal(t, float64(1), sentMetrics["email.companion_missing"])
+		assert.NotContains(t, sentMetrics, "email.companion_resolved")
+	})
+}
+
+func TestHandleS3EventRestoreEvents(t *testing.T) {
+	logger = log.NewNopLogger()
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	t.Cleanup(func() { env.ProcessRestoreEvents = false })
+	content, err := os.ReadFile("./fixtures/good.eml")
+	require.NoError(t, err)
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{
+		EventName: eventHelpers.S3EventObjectRestoreCompleted,
+		S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: "test-bucket"},
+			Object: events.S3Object{Key: "sources/2022/04/24/ffis.org/raw.eml"},
+		},
+	}}}
+
+	t.Run("restore event is skipped when disabled", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		env.ProcessRestoreEvents = false
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		assert.Empty(t, mocksqs.Sent())
+		assert.Equal(t, float64(1), sentMetrics["record.skipped"])
+	})
+
+	t.Run("restored email is enqueued when enabled", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		env.ProcessRestoreEvents = true
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "sources/2022/04/24/ffis.org/raw.eml", message.SourceFileKey)
+		assert.NotContains(t, sentMetrics, "record.skipped")
+	})
+
+	t.Run("archived email fails with classified error", func(t *testing.T) {
+		env.ProcessRestoreEvents = true
+		mocks3, mocksqs := getMockClients()
+		mocks3.getObjectErr = &s3Types.InvalidObjectState{StorageClass: s3Types.StorageClassGlacier}
+		err := handleS3Event(context.Background(), s3Event, mocks3, mocksqs)
+		assert.ErrorIs(t, err, awsHelpers.ErrS3ObjectArchived)
+		var invocationErr *eventHelpers.InvocationError
+		require.ErrorAs(t, err, &invocationErr)
+		assert.Equal(t, map[string]int{"S3ObjectArchived": 1}, invocationErr.Summary.ErrorClasses)
+		assert.Empty(t, mocksqs.Sent())
+	})
+}
+
+func TestExtractPDFText(t *testing.T) {
+	t.Run("text is extracted", func(t *testing.T) {
+		data, err := os.ReadFile("./fixtures/download-link.pdf")
+		require.NoError(t, err)
+		text, err := extractPDFText(data)
+		require.NoError(t, err)
+		assert.Contains(t, text, "https://mcusercontent.com/123456789abcdef/files/FFIS-Grants-Update.xlsx")
+	})
+
+	t.Run("encrypted PDF is not read", func(t *testing.T) {
+		data, err := os.ReadFile("./fixtures/encrypted.pdf")
+		require.NoError(t, err)
+		_, err = extractPDFText(data)
+		assert.ErrorIs(t, err, ErrPDFEncrypted)
+	})
+
+	t.Run("malformed PDF is unreadable", func(t *testing.T) {
+		_, err := extractPDFText([]byte("%PDF-1.4\nnot really a PDF"))
+		assert.ErrorIs(t, err, ErrPDFUnreadable)
+	})
+
+	t.Run("PDF larger than the size limit is not read", func(t *testing.T) {
+		_, err := readLimitedPart(strings.NewReader(strings.Repeat("x", 11)), "", 10)
+		assert.ErrorIs(t, err, ErrPDFTooLarge)
+		data, err := readLimitedPart(strings.NewReader("eHh4"), "base64", 3)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("xxx"), data)
+	})
+}
+
+func TestHandleS3EventPDFAttachments(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	env.AllowedExtensions = ""
+	env.PDFSizeLimit = 10
+	content, err := os.ReadFile("./fixtures/with-pdf.eml")
+	require.NoError(t, err)
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/24/ffis.org/raw.eml"},
+	}}}}
+
+	t.Run("PDF attachments are ignored when disabled", func(t *testing.T) {
+		env.ExtractPDFAttachments = false
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		assert.ErrorIs(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs), ErrNoMatchesFound)
+		assert.Empty(t, mocksqs.Sent())
+	})
+
+	t.Run("URL in PDF attachment is enqueued when enabled", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		env.ExtractPDFAttachments = true
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "https://mcusercontent.com/123456789abcdef/files/FFIS-Grants-Update.xlsx", message.DownloadURL)
+		assert.Equal(t, float64(1), sentMetrics["email.url_from_pdf"])
+	})
+
+	t.Run("oversized PDF attachment is skipped", func(t *testing.T) {
+		sentMetrics = map[string]float64{}
+		env.ExtractPDFAttachments = true
+		env.PDFSizeLimit = 0
+		t.Cleanup(func() { env.PDFSizeLimit = 10 })
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(content)
+		assert.ErrorIs(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs), ErrNoMatchesFound)
+		assert.Empty(t, mocksqs.Sent())
+		assert.Equal(t, float64(1), sentMetrics["email.pdf_skipped"])
+	})
+
+	t.Run("plaintext URL takes precedence over PDF attachments", func(t *testing.T) {
+		env.ExtractPDFAttachments = true
+		good, err := os.ReadFile("./fixtures/good.eml")
+		require.NoError(t, err)
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(good)
+		require.NoError(t, handleS3Event(context.Background(), s3Event, mocks3, mocksqs))
+		_, message := sentDownloadMessage(t, mocksqs)
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx", message.DownloadURL)
+	})
+}
+
+func TestMaxMIMEDepth(t *testing.T) {
+	restoreEnv := env
+	t.Cleanup(func() { env = restoreEnv })
+	content, err := os.ReadFile("./fixtures/deeply-nested.eml")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		maxDepth int
+		expError error
+	}{
+		{0, nil},
+		{12, nil},
+		{11, ErrMimeTooDeep},
+		{1, ErrMimeTooDeep},
+	} {
+		t.Run(fmt.Sprintf("plaintext with maximum depth %d", tt.maxDepth), func(t *testing.T) {
+			env.MaxMIMEDepth = tt.maxDepth
+			plaintext, err := plaintextMIMEFromEmailBody(bytes.NewReader(content))
+			if tt.expError != nil {
+				assert.ErrorIs(t, err, tt.expError)
+				assert.Empty(t, plaintext)
+			} else {
+				require.NoError(t, err)
+				assert.Contains(t, plaintext, "https://mcusercontent.com/123456/files/file-01.xlsx")
+			}
+		})
+
+		t.Run(fmt.Sprintf("PDF attachments with maximum depth %d", tt.maxDepth), func(t *testing.T) {
+			env.MaxMIMEDepth = tt.maxDepth
+			msg, err := mail.ReadMessage(bytes.NewReader(content))
+			require.NoError(t, err)
+			attachments, err := pdfAttachments(msg.Header.Get("Content-Type"), msg.Body, awsHelpers.MB, 1)
+			if tt.expError != nil {
+				assert.ErrorIs(t, err, tt.expError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Empty(t, attachments)
+		})
+	}
+}
+
+func TestReadEmailFromS3(t *testing.T) {
+	logger = log.NewNopLogger()
+
+	t.Run("complete download", func(t *testing.T) {
+		b, err := readEmailFromS3(context.TODO(), &MockS3{content: "hello"}, "bucket", "key")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+	})
+
+	t.Run("truncated download", func(t *testing.T) {
+		b, err := readEmailFromS3(context.TODO(), &MockS3{content: "hello", truncatedBytes: 10}, "bucket", "key")
+		assert.ErrorIs(t, err, ErrTruncatedDownload)
+		assert.Nil(t, b)
+	})
 }
@@ -6,31 +6,69 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	goLog "log"
+	"time"
 
 	ddlambda "github.com/DataDog/datadog-lambda-go"
 	goenv "github.com/Netflix/go-env"
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/sentryHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
 )
 
 type Environment struct {
-	LogLevel            string `env:"LOG_LEVEL,default=INFO"`
-	DestinationQueueURL string `env:"FFIS_SQS_QUEUE_URL,required=true"`
-	UsePathStyleS3Opt   bool   `env:"S3_USE_PATH_STYLE,default=false"`
-	URLPattern          string `env:"FFIS_URL_PATTERN,default=https://mcusercontent.com/.+\\.xlsx"`
-	Extras              goenv.EnvSet
+	LogLevel                   string        `env:"LOG_LEVEL,default=INFO"`
+	DestinationQueueURL        string        `env:"FFIS_SQS_QUEUE_URL"`
+	UsePathStyleS3Opt          bool          `env:"S3_USE_PATH_STYLE,default=false"`
+	URLPattern                 string        `env:"FFIS_URL_PATTERN,default=https://mcusercontent.com/.+\\.xlsx"`
+	SourcesConfig              string        `env:"SOURCES_CONFIG"`
+	AllowedExtensions          string        `env:"DOWNLOAD_ALLOWED_EXTENSIONS"`
+	SentryDSN                  string        `env:"SENTRY_DSN" redact:"true"`
+	BenignErrors               string        `env:"BENIGN_ERRORS"`
+	DeterministicOrder         bool          `env:"DETERMINISTIC_ORDER,default=false"`
+	SQSCircuitBreakerThreshold int           `env:"SQS_CIRCUIT_BREAKER_THRESHOLD,default=5"`
+	MessageAttributes          string        `env:"SQS_MESSAGE_ATTRIBUTES"`
+	PriorityMessageAttributes  string        `env:"SQS_PRIORITY_MESSAGE_ATTRIBUTES"`
+	MinURLConfidence           float64       `env:"MIN_URL_CONFIDENCE,default=0"`
+	URLHostAllowlist           string        `env:"URL_HOST_ALLOWLIST,default=mcusercontent.com"`
+	DecodeHTMLEntities         bool          `env:"DECODE_HTML_ENTITIES,default=false"`
+	TracingProvider            string        `env:"TRACING_PROVIDER,default=datadog"`
+	CompanionReferencePattern  string        `env:"COMPANION_REFERENCE_PATTERN"`
+	AuditLogLevel              string        `env:"AUDIT_LOG_LEVEL,default=INFO"`
+	AuditLogSink               string        `env:"AUDIT_LOG_SINK,default=stdout"`
+	ProcessRestoreEvents       bool          `env:"PROCESS_RESTORE_EVENTS,default=false"`
+	WebhookURL                 string        `env:"WEBHOOK_URL" redact:"true"`
+	WebhookFormat              string        `env:"WEBHOOK_FORMAT,default=json"`
+	WebhookLogsURLTemplate     string        `env:"WEBHOOK_LOGS_URL_TEMPLATE"`
+	ExtractPDFAttachments      bool          `env:"EXTRACT_PDF_ATTACHMENTS,default=false"`
+	PDFSizeLimit               int64         `env:"PDF_SIZE_LIMIT,default=10"`
+	MaxMIMEDepth               int           `env:"MAX_MIME_DEPTH,default=10"`
+	DisabledFeatures           string        `env:"DISABLED_FEATURES"`
+	PostProcessWebhookURL      string        `env:"POST_PROCESS_WEBHOOK_URL" redact:"true"`
+	PostProcessWebhookTimeout  time.Duration `env:"POST_PROCESS_WEBHOOK_TIMEOUT,default=5s"`
+	RecordTimeout              time.Duration `env:"RECORD_TIMEOUT,default=0"`
+	MaxJobAge                  time.Duration `env:"MAX_JOB_AGE,default=0"`
+	Extras                     goenv.EnvSet  `redact:"true"`
 }
 
 var (
-	env    Environment
-	logger log.Logger
-	// sendMetric       = ddHelpers.NewMetricSender("EnqueueFFISDownload", "source:grants.gov")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	auditLogger      = log.NewNopAuditLogger()
+	failureNotifier  *eventHelpers.FailureNotifier
+	sources          []Source
+	sendMetric       = ddHelpers.NewMetricSender("EnqueueFFISDownload")
+	benignErrors     []error
 )
 
 func main() {
@@ -40,14 +78,65 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	auditSink, err := log.AuditSink(env.AuditLogSink)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	log.ConfigureAuditLogger(&auditLogger, env.AuditLogLevel, auditSink)
+	failureNotifier, err = eventHelpers.NewFailureNotifier("EnqueueFFISDownload",
+		env.WebhookURL, env.WebhookFormat, env.WebhookLogsURLTemplate)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	postProcessWebhook, err = NewPostProcessWebhook(env.PostProcessWebhookURL, env.PostProcessWebhookTimeout)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	sources, err = loadSources(env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	benignErrors, err = parseBenignErrors(env.BenignErrors)
+	if err != nil {
+		goLog.Fatalf("error configuring benign errors: %v", err)
+	}
+	staticMessageAttributes, err = parseMessageAttributes(env.MessageAttributes)
+	if err != nil {
+		goLog.Fatalf("error configuring message attributes: %v", err)
+	}
+	priorityMessageAttributes = parseAttributeNames(env.PriorityMessageAttributes)
+	companionReferencePattern, err = parseCompanionReferencePattern(env.CompanionReferencePattern)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+	if err := sentryHelpers.Init(env.SentryDSN); err != nil {
+		log.Warn(logger, "Sentry error reporting is disabled", "error", err)
+	}
 
-	log.Info(logger, "Starting EnqueueFFISDownload", "destinationQueue", env.DestinationQueueURL, "urlPattern", env.URLPattern)
+	for _, source := range sources {
+		log.Info(logger, "Starting EnqueueFFISDownload", "source", source.Name,
+			"destinationQueue", source.QueueURL, "urlPattern", source.URLPattern)
+	}
 
 	log.Debug(logger, "Starting Lambda")
-	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, s3Event events.S3Event) error {
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer sentryHelpers.Flush()
+		defer tracing.Flush(ctx)
 		cfg, err := awsHelpers.GetConfig(ctx)
 		if err != nil {
-			return fmt.Errorf("could not create AWS SDK config: %w", err)
+			return nil, fmt.Errorf("could not create AWS SDK config: %w", err)
 		}
 		awstrace.AppendMiddleware(&cfg)
 		log.Debug(logger, "Starting Lambda")
@@ -58,8 +147,8 @@ func main() {
 
 		sqsClient, err := awsHelpers.GetSQSClient(ctx)
 		if err != nil {
-			return fmt.Errorf("could not create AWS clients: %w", err)
+			return nil, fmt.Errorf("could not create AWS clients: %w", err)
 		}
-		return handleS3Event(ctx, s3Event, s3Client, sqsClient)
+		return handleInvocation(ctx, payload, s3Client, sqsClient)
 	}, nil))
 }
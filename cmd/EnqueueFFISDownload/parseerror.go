@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Limits on the text surrounding a parse failure that is included in a ParseError
+const (
+	// parseSnippetContextLines is the number of lines before and after the failing line.
+	parseSnippetContextLines = 1
+	// parseSnippetMaxLength is the maximum length (in bytes) of a snippet.
+	parseSnippetMaxLength = 240
+)
+
+// redactedAddress replaces email addresses in ParseError snippets.
+const redactedAddress = "[redacted address]"
+
+var snippetAddressPattern = regexp.MustCompile(`[^\s<>@"(),;:]+@[^\s<>@"(),;:]+`)
+
+// ParseError identifies where in the contents of an email parsing failed.
+type ParseError struct {
+	// Line is the approximate (1-based) line number at which parsing failed.
+	Line int
+	// Snippet is the text surrounding Line, in which email addresses are redacted.
+	Snippet string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s (near line %d: %q)", e.Err, e.Line, e.Snippet)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError returns a ParseError for err, which occurred after offset bytes of contents had
+// been read (see lineReader).
+func newParseError(contents []byte, offset int, err error) *ParseError {
+	if offset > len(contents) {
+		offset = len(contents)
+	}
+	// The byte at offset belongs to the next line, unless the failure occurred within a line
+	failedAt := offset
+	if failedAt > 0 && (failedAt == len(contents) || contents[failedAt-1] == '\n') {
+		failedAt--
+	}
+	line := bytes.Count(contents[:failedAt], []byte("\n")) + 1
+	lines := strings.Split(string(contents), "\n")
+	first, last := line-1-parseSnippetContextLines, line-1+parseSnippetContextLines
+	if first < 0 {
+		first = 0
+	}
+	if last >= len(lines) {
+		last = len(lines) - 1
+	}
+	snippet := strings.Join(lines[first:last+1], "\n")
+	snippet = strings.ReplaceAll(snippet, "\r", "")
+	snippet = snippetAddressPattern.ReplaceAllString(snippet, redactedAddress)
+	if len(snippet) > parseSnippetMaxLength {
+		snippet = strings.ToValidUTF8(snippet[:parseSnippetMaxLength], "") + "..."
+	}
+	return &ParseError{Line: line, Snippet: snippet, Err: err}
+}
+
+// lineReader reads contents no more than one line at a time, and tracks how much of contents
+// has been read. Since parsers only read as far ahead as they need to (aside from multipart
+// parts, which are read in larger chunks), the amount read when parsing fails approximates
+// the position of the failure.
+type lineReader struct {
+	contents []byte
+	offset   int
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	if r.offset >= len(r.contents) {
+		return 0, io.EOF
+	}
+	remaining := r.contents[r.offset:]
+	if i := bytes.IndexByte(remaining, '\n'); i >= 0 {
+		remaining = remaining[:i+1]
+	}
+	n := copy(p, remaining)
+	r.offset += n
+	return n, nil
+}
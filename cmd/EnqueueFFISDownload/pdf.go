@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// maxPDFPages is the maximum number of pages of a PDF attachment from which text is extracted.
+const maxPDFPages = 20
+
+var (
+	ErrNoPDFAttachments = errors.New("no PDF attachments found")
+	ErrPDFTooLarge      = errors.New("PDF attachment exceeds the size limit")
+	ErrPDFEncrypted     = errors.New("PDF attachment is encrypted")
+	ErrPDFUnreadable    = errors.New("PDF attachment could not be read")
+)
+
+// pdfAttachmentText returns the text extracted from every application/pdf attachment of the
+// (possibly gzip-compressed) email contained in emailBytes, separated by newlines.
+// Attachments that are larger than env.PDFSizeLimit megabytes, encrypted, or otherwise unreadable
+// are logged and skipped. Returns an error wrapping ErrNoPDFAttachments when the email has no
+// PDF attachments from which text could be extracted.
+func pdfAttachmentText(logger log.Logger, emailBytes []byte) (string, error) {
+	email, _, err := awsHelpers.DecompressIfGzipped(bytes.NewReader(emailBytes))
+	if err != nil {
+		return "", err
+	}
+	msg, err := mail.ReadMessage(email)
+	if err != nil {
+		return "", err
+	}
+	attachments, err := pdfAttachments(msg.Header.Get("Content-Type"), msg.Body, env.PDFSizeLimit*awsHelpers.MB, 1)
+	if err != nil {
+		return "", err
+	}
+
+	texts := []string{}
+	for _, attachment := range attachments {
+		logger := log.With(logger, "attachment_filename", attachment.filename)
+		if attachment.err != nil {
+			log.Warn(logger, "Skipping PDF attachment", "error", attachment.err)
+			sendMetric("email.pdf_skipped", 1)
+			continue
+		}
+		text, err := extractPDFText(attachment.data)
+		if err != nil {
+			log.Warn(logger, "Skipping PDF attachment", "error", err)
+			sendMetric("email.pdf_skipped", 1)
+			continue
+		}
+		log.Debug(logger, "Extracted text from PDF attachment", "text_bytes", len(text))
+		texts = append(texts, text)
+	}
+	if len(texts) == 0 {
+		return "", ErrNoPDFAttachments
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+type pdfAttachment struct {
+	filename string
+	data     []byte
+	err      error
+}
+
+// pdfAttachments returns the decoded contents of every application/pdf part of the MIME entity
+// with the given Content-Type header value and body, searching nested multipart entities.
+// Attachments larger than sizeLimit bytes are returned with an error wrapping ErrPDFTooLarge.
+// A multipart entity is at the given depth, which is limited by checkMIMEDepth.
+func pdfAttachments(contentType string, body io.Reader, sizeLimit int64, depth int) ([]pdfAttachment, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, nil
+	}
+	if err := checkMIMEDepth(depth); err != nil {
+		return nil, err
+	}
+
+	attachments := []pdfAttachment{}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return attachments, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		partType, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(partType, "multipart/"):
+			nested, err := pdfAttachments(p.Header.Get("Content-Type"), p, sizeLimit, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, nested...)
+
+		case partType == "application/pdf":
+			attachment := pdfAttachment{filename: p.FileName()}
+			attachment.data, attachment.err = readLimitedPart(p, p.Header.Get("Content-Transfer-Encoding"), sizeLimit)
+			attachments = append(attachments, attachment)
+		}
+	}
+}
+
+// readLimitedPart reads at most limit bytes of the decoded contents of a MIME part with the
+// given Content-Transfer-Encoding, returning an error wrapping ErrPDFTooLarge when the
+// contents are larger.
+func readLimitedPart(r io.Reader, transferEncoding string, limit int64) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrPDFTooLarge, limit)
+	}
+	return data, nil
+}
+
+// extractPDFText returns the text of the first maxPDFPages pages of the PDF document in data,
+// with each row of text on a separate line. Encrypted documents are not read.
+func extractPDFText(data []byte) (text string, err error) {
+	// The PDF library panics when it encounters some malformed documents
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrPDFUnreadable, r)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if errors.Is(err, pdf.ErrInvalidPassword) {
+		return "", ErrPDFEncrypted
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPDFUnreadable, err)
+	}
+	if !r.Trailer().Key("Encrypt").IsNull() {
+		return "", ErrPDFEncrypted
+	}
+
+	var sb strings.Builder
+	for i := 1; i <= r.NumPage() && i <= maxPDFPages; i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		rows, err := page.GetTextByRow()
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrPDFUnreadable, err)
+		}
+		// Rows are positioned from the bottom of the page
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Position > rows[j].Position })
+		for _, row := range rows {
+			for _, word := range row.Content {
+				sb.WriteString(word.S)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// matchPDFDownloadURL returns the download URL matched (see matchDownloadURL) in the text of
+// the PDF attachments of the email contained in emailBytes. It is used as a fallback when the
+// email plaintext failed to match with plaintextErr, which is returned when no text could be
+// extracted from any PDF attachment.
+func matchPDFDownloadURL(logger, auditLogger log.Logger, source Source, emailBytes []byte, plaintextErr error) (string, error) {
+	text, err := pdfAttachmentText(logger, emailBytes)
+	if err != nil {
+		log.Debug(logger, "No PDF attachment text to search for a download URL", "error", err)
+		return "", plaintextErr
+	}
+	url, err := matchDownloadURL(logger, auditLogger, source, text)
+	if err != nil {
+		return url, err
+	}
+	log.Info(logger, "Matched download URL in PDF attachment text")
+	sendMetric("email.url_from_pdf", 1, source.metricTag())
+	return url, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// Post-processing webhook configured by env.PostProcessWebhookURL (nil when not configured)
+var postProcessWebhook *PostProcessWebhook
+
+// PostProcessSummary describes a digest email that was successfully processed.
+type PostProcessSummary struct {
+	// Key is the S3 key of the digest email.
+	Key string `json:"key"`
+	// Source is the name of the configured source that matched Key.
+	Source string `json:"source"`
+	// Date is the date of the digest email in RFC 3339 format, if known.
+	Date string `json:"date,omitempty"`
+	// Sender is the address from which the digest email was sent, if known.
+	Sender string `json:"sender,omitempty"`
+	// URLCount is the number of URLs in the digest plaintext that match the source's URL pattern,
+	// which is zero when the download URL was found in a PDF attachment instead.
+	URLCount int `json:"urlCount"`
+	// DownloadURL is the URL that was enqueued for download.
+	DownloadURL string `json:"downloadUrl"`
+}
+
+// PostProcessWebhook posts a PostProcessSummary to an HTTP endpoint after each digest email is
+// processed, for downstream systems that cannot subscribe to AWS events.
+// A nil *PostProcessWebhook sends no notifications.
+type PostProcessWebhook struct {
+	// URL is the webhook endpoint to which summaries are posted.
+	URL string
+	// Client sends webhook requests.
+	Client eventHelpers.HTTPClient
+	// Timeout is the maximum duration of each webhook request.
+	Timeout time.Duration
+}
+
+// NewPostProcessWebhook returns a PostProcessWebhook which posts summaries to webhookURL,
+// giving up on each request after timeout.
+// Returns nil when webhookURL is empty, i.e. when the webhook is disabled.
+func NewPostProcessWebhook(webhookURL string, timeout time.Duration) (*PostProcessWebhook, error) {
+	if webhookURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(webhookURL); err != nil {
+		return nil, fmt.Errorf("invalid post-processing webhook URL: %w", err)
+	}
+	if timeout <= 0 {
+		timeout = eventHelpers.NotificationTimeout
+	}
+	return &PostProcessWebhook{URL: webhookURL, Client: &http.Client{}, Timeout: timeout}, nil
+}
+
+// Notify posts summary as a JSON document. Any 2xx response is considered successful.
+func (w *PostProcessWebhook) Notify(ctx context.Context, summary PostProcessSummary) error {
+	if w == nil {
+		return nil
+	}
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("error encoding post-processing summary: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("error creating post-processing webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting to post-processing webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post-processing webhook rejected summary with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyPostProcess posts a summary of the processed digest email to the configured
+// post-processing webhook, if any. Failure to notify is logged and counted but never fails
+// processing, since the download has already been enqueued.
+func notifyPostProcess(ctx context.Context, logger log.Logger, source Source, key string, emailBytes []byte, plaintext, downloadURL string) {
+	if postProcessWebhook == nil {
+		return
+	}
+	summary := postProcessSummary(logger, source, key, emailBytes, plaintext, downloadURL)
+	if err := postProcessWebhook.Notify(ctx, summary); err != nil {
+		log.Warn(logger, "Failed to notify post-processing webhook", "error", err)
+		sendMetric("webhook.post_process_failed", 1, source.metricTag())
+		return
+	}
+	log.Debug(logger, "Notified post-processing webhook")
+}
+
+// postProcessSummary returns the summary of the digest email at key, whose (possibly
+// gzip-compressed) contents are emailBytes. The date and sender are omitted from the summary
+// when the corresponding email headers are missing or malformed.
+func postProcessSummary(logger log.Logger, source Source, key string, emailBytes []byte, plaintext, downloadURL string) PostProcessSummary {
+	summary := PostProcessSummary{
+		Key:         key,
+		Source:      source.Name,
+		URLCount:    len(regexp.MustCompile(source.URLPattern).FindAllString(plaintext, -1)),
+		DownloadURL: downloadURL,
+	}
+	email, _, err := awsHelpers.DecompressIfGzipped(bytes.NewReader(emailBytes))
+	if err != nil {
+		log.Warn(logger, "Error decompressing email for post-processing summary", "error", err)
+		return summary
+	}
+	msg, err := mail.ReadMessage(email)
+	if err != nil {
+		log.Warn(logger, "Error reading email headers for post-processing summary", "error", err)
+		return summary
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		summary.Date = date.Format(time.RFC3339)
+	}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		summary.Sender = from.Address
+	}
+	return summary
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/testsupport"
+)
+
+// mockHTTPClient records the requests it is sent and responds with status, or fails with err.
+type mockHTTPClient struct {
+	requests []*http.Request
+	bodies   [][]byte
+	status   int
+	err      error
+}
+
+func (c *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	c.requests = append(c.requests, req)
+	c.bodies = append(c.bodies, body)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &http.Response{StatusCode: c.status, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestNewPostProcessWebhook(t *testing.T) {
+	w, err := NewPostProcessWebhook("", 5*time.Second)
+	require.NoError(t, err)
+	assert.Nil(t, w, "webhook should be disabled without a URL")
+
+	_, err = NewPostProcessWebhook("not a url", 5*time.Second)
+	assert.Error(t, err)
+
+	w, err = NewPostProcessWebhook("https://hooks.example.com/ingested", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://hooks.example.com/ingested", w.URL)
+	assert.Positive(t, w.Timeout)
+}
+
+func TestPostProcessWebhook(t *testing.T) {
+	logger = log.NewNopLogger()
+	restoreEnv := env
+	restoreWebhook := postProcessWebhook
+	restoreSendMetric := sendMetric
+	t.Cleanup(func() {
+		env = restoreEnv
+		postProcessWebhook = restoreWebhook
+		sendMetric = restoreSendMetric
+	})
+	env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+	env.MinURLConfidence = 0
+	sentMetrics := make(map[string]float64)
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-bucket"},
+		Object: events.S3Object{Key: "sources/2023/04/22/ffis.org/raw.eml"},
+	}}}}
+	setup := func(t *testing.T, client *mockHTTPClient, email testsupport.FFISEmail) (*MockS3, *testsupport.RecordingSQS) {
+		t.Helper()
+		for k := range sentMetrics {
+			delete(sentMetrics, k)
+		}
+		postProcessWebhook = &PostProcessWebhook{
+			URL: "https://hooks.example.com/ingested", Client: client, Timeout: time.Second,
+		}
+		mocks3, mocksqs := getMockClients()
+		mocks3.content = string(email.MustBuild())
+		return mocks3, mocksqs
+	}
+
+	t.Run("summary is posted after processing", func(t *testing.T) {
+		client := &mockHTTPClient{status: http.StatusNoContent}
+		mocks3, mocksqs := setup(t, client, testsupport.FFISEmail{Links: []testsupport.Link{testDownloadLink}})
+		require.NoError(t, handleS3Event(context.TODO(), s3Event, mocks3, mocksqs))
+
+		require.Len(t, client.requests, 1)
+		req := client.requests[0]
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "https://hooks.example.com/ingested", req.URL.String())
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		var summary PostProcessSummary
+		require.NoError(t, json.Unmarshal(client.bodies[0], &summary))
+		assert.Equal(t, PostProcessSummary{
+			Key:         "sources/2023/04/22/ffis.org/raw.eml",
+			Source:      legacySourceName,
+			Date:        "2023-04-22T14:55:26-05:00",
+			Sender:      "ffis@ffis.org",
+			URLCount:    1,
+			DownloadURL: "https://mcusercontent.com/123456/files/file-01.xlsx",
+		}, summary)
+		assert.NotContains(t, sentMetrics, "webhook.post_process_failed")
+	})
+
+	t.Run("URL count includes all matching URLs", func(t *testing.T) {
+		env.URLPattern = `https?://[^\s<>]+`
+		env.URLHostAllowlist = "mcusercontent.com"
+		env.MinURLConfidence = 0.5
+		t.Cleanup(func() {
+			env.URLPattern = "https://mcusercontent.com/.+\\.xlsx"
+			env.MinURLConfidence = 0
+		})
+		client := &mockHTTPClient{status: http.StatusOK}
+		mocks3, mocksqs := setup(t, client, testsupport.FFISEmail{
+			Links:       []testsupport.Link{testDownloadLink},
+			FooterLinks: []testsupport.Link{{Text: "Follow us", URL: "https://www.facebook.com/ffis.org"}},
+			OmitHTML:    true,
+		})
+		require.NoError(t, handleS3Event(context.TODO(), s3Event, mocks3, mocksqs))
+
+		require.Len(t, client.bodies, 1)
+		var summary PostProcessSummary
+		require.NoError(t, json.Unmarshal(client.bodies[0], &summary))
+		assert.Equal(t, 2, summary.URLCount)
+		assert.Equal(t, "https://mcusercontent.com/123456/files/file-01.xlsx", summary.DownloadURL)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		client *mockHTTPClient
+	}{
+		{"webhook request error", &mockHTTPClient{err: errors.New("connection refused")}},
+		{"webhook rejection", &mockHTTPClient{status: http.StatusInternalServerError}},
+	} {
+		t.Run(tt.name+" does not fail processing", func(t *testing.T) {
+			mocks3, mocksqs := setup(t, tt.client, testsupport.FFISEmail{Links: []testsupport.Link{testDownloadLink}})
+			require.NoError(t, handleS3Event(context.TODO(), s3Event, mocks3, mocksqs))
+			assert.Len(t, mocksqs.Sent(), 1, "download should be enqueued")
+			assert.Len(t, tt.client.requests, 1)
+			assert.Equal(t, 1.0, sentMetrics["webhook.post_process_failed"])
+		})
+	}
+
+	t.Run("no summary is posted when processing fails", func(t *testing.T) {
+		client := &mockHTTPClient{status: http.StatusOK}
+		mocks3, mocksqs := setup(t, client, testsupport.FFISEmail{
+			Links: []testsupport.Link{testDownloadLink, testPreviousDownloadLink},
+		})
+		assert.Error(t, handleS3Event(context.TODO(), s3Event, mocks3, mocksqs))
+		assert.Empty(t, client.requests)
+	})
+}
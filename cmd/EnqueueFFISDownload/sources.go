@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// legacySourceName is the name of the source that is synthesized from legacy environment
+// variables when SOURCES_CONFIG is not set.
+const legacySourceName = "ffis.org"
+
+var ErrInvalidSourcesConfig = errors.New("invalid sources configuration")
+
+// Source describes how emails archived for a particular data provider are handled.
+type Source struct {
+	// Name identifies the source in logs, metrics, and traces, e.g. "ffis.org".
+	Name string `json:"name"`
+	// DestinationSubpath is the path segment that identifies emails from this source in the keys
+	// of archived emails, e.g. "ffis.org" for keys like "sources/YYYY/MM/DD/ffis.org/raw.eml".
+	// An empty subpath matches every key.
+	DestinationSubpath string `json:"destinationSubpath"`
+	// URLPattern is a regular expression that identifies download links in emails.
+	URLPattern string `json:"urlPattern"`
+	// QueueURL is the URL of the SQS queue to which downloads are enqueued.
+	QueueURL string `json:"queueUrl"`
+}
+
+// metricTag returns the tag that identifies the source in metrics.
+func (s Source) metricTag() string {
+	return fmt.Sprintf("source:%s", s.Name)
+}
+
+// legacySource returns the source described by the FFIS_URL_PATTERN and FFIS_SQS_QUEUE_URL
+// environment variables, which matches every key in order to preserve the behavior of the
+// original FFIS-only setup.
+func legacySource(env Environment) Source {
+	return Source{
+		Name:       legacySourceName,
+		URLPattern: env.URLPattern,
+		QueueURL:   env.DestinationQueueURL,
+	}
+}
+
+// loadSources returns the sources given by the SOURCES_CONFIG JSON array. When SOURCES_CONFIG
+// is empty, the legacy source (see legacySource) is the only source.
+func loadSources(env Environment) ([]Source, error) {
+	if strings.TrimSpace(env.SourcesConfig) == "" {
+		if env.DestinationQueueURL == "" {
+			return nil, fmt.Errorf("%w: FFIS_SQS_QUEUE_URL is required when SOURCES_CONFIG is not set",
+				ErrInvalidSourcesConfig)
+		}
+		return []Source{legacySource(env)}, nil
+	}
+
+	var sources []Source
+	if err := json.Unmarshal([]byte(env.SourcesConfig), &sources); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSourcesConfig, err)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: no sources are configured", ErrInvalidSourcesConfig)
+	}
+	names := make(map[string]bool)
+	for i, source := range sources {
+		source.DestinationSubpath = strings.Trim(source.DestinationSubpath, "/")
+		if source.Name == "" {
+			source.Name = source.DestinationSubpath
+		}
+		if source.Name == "" {
+			return nil, fmt.Errorf("%w: source %d has no name", ErrInvalidSourcesConfig, i)
+		}
+		if names[source.Name] {
+			return nil, fmt.Errorf("%w: source name %q is not unique", ErrInvalidSourcesConfig, source.Name)
+		}
+		names[source.Name] = true
+		if source.QueueURL == "" {
+			return nil, fmt.Errorf("%w: source %q has no queue URL", ErrInvalidSourcesConfig, source.Name)
+		}
+		if source.URLPattern == "" {
+			return nil, fmt.Errorf("%w: source %q has no URL pattern", ErrInvalidSourcesConfig, source.Name)
+		}
+		if _, err := regexp.Compile(source.URLPattern); err != nil {
+			return nil, fmt.Errorf("%w: source %q has an invalid URL pattern: %w",
+				ErrInvalidSourcesConfig, source.Name, err)
+		}
+		sources[i] = source
+	}
+	return sources, nil
+}
+
+// configuredSources returns the sources loaded at startup, or the legacy source when
+// no sources have been loaded.
+func configuredSources() []Source {
+	if len(sources) > 0 {
+		return sources
+	}
+	return []Source{legacySource(env)}
+}
+
+// matchSource returns the source whose destination subpath ends the path of the directory
+// containing the archived email at key, e.g. "ffis.org" for "sources/YYYY/MM/DD/ffis.org/raw.eml".
+// When no subpath matches, the first source with an empty subpath (if any) is returned.
+// Returns false when no source matches key.
+func matchSource(sources []Source, key string) (Source, bool) {
+	dir := path.Dir(key)
+	var fallback Source
+	found := false
+	for _, source := range sources {
+		if source.DestinationSubpath == "" {
+			if !found {
+				fallback, found = source, true
+			}
+		} else if dir == source.DestinationSubpath || strings.HasSuffix(dir, "/"+source.DestinationSubpath) {
+			return source, true
+		}
+	}
+	return fallback, found
+}
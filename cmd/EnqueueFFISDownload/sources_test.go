@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSources(t *testing.T) {
+	t.Run("legacy configuration", func(t *testing.T) {
+		sources, err := loadSources(Environment{
+			DestinationQueueURL: "https://sqs.example.com/ffis",
+			URLPattern:          `https://mcusercontent.com/.+\.xlsx`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []Source{{
+			Name:       "ffis.org",
+			URLPattern: `https://mcusercontent.com/.+\.xlsx`,
+			QueueURL:   "https://sqs.example.com/ffis",
+		}}, sources)
+	})
+
+	t.Run("multiple sources", func(t *testing.T) {
+		sources, err := loadSources(Environment{SourcesConfig: `[
+			{"destinationSubpath": "ffis.org", "urlPattern": "https://.+\\.xlsx", "queueUrl": "https://sqs.example.com/ffis"},
+			{"name": "state-updates", "destinationSubpath": "/state_updates/", "urlPattern": "https://.+\\.csv", "queueUrl": "https://sqs.example.com/state"}
+		]`})
+		require.NoError(t, err)
+		require.Len(t, sources, 2)
+		assert.Equal(t, "ffis.org", sources[0].Name, "Name should default to the destination subpath")
+		assert.Equal(t, `https://.+\.xlsx`, sources[0].URLPattern)
+		assert.Equal(t, "state-updates", sources[1].Name)
+		assert.Equal(t, "state_updates", sources[1].DestinationSubpath)
+		assert.Equal(t, "https://sqs.example.com/state", sources[1].QueueURL)
+	})
+
+	for _, tt := range []struct {
+		name string
+		env  Environment
+	}{
+		{"legacy without queue", Environment{URLPattern: "https://.+"}},
+		{"malformed JSON", Environment{SourcesConfig: `[{`}},
+		{"empty array", Environment{SourcesConfig: `[]`}},
+		{"missing name", Environment{SourcesConfig: `[{"urlPattern": "a", "queueUrl": "q"}]`}},
+		{"duplicate name", Environment{SourcesConfig: `[{"name": "a", "urlPattern": "a", "queueUrl": "q"}, {"destinationSubpath": "a", "urlPattern": "a", "queueUrl": "q"}]`}},
+		{"missing queue", Environment{SourcesConfig: `[{"name": "a", "urlPattern": "a"}]`}},
+		{"missing URL pattern", Environment{SourcesConfig: `[{"name": "a", "queueUrl": "q"}]`}},
+		{"invalid URL pattern", Environment{SourcesConfig: `[{"name": "a", "urlPattern": "(", "queueUrl": "q"}]`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadSources(tt.env)
+			assert.ErrorIs(t, err, ErrInvalidSourcesConfig)
+		})
+	}
+}
+
+func TestMatchSource(t *testing.T) {
+	sources := []Source{
+		{Name: "catchall"},
+		{Name: "ffis.org", DestinationSubpath: "ffis.org"},
+		{Name: "state-updates", DestinationSubpath: "state/updates"},
+	}
+
+	source, ok := matchSource(sources, "sources/2023/04/24/ffis.org/raw.eml")
+	assert.True(t, ok)
+	assert.Equal(t, "ffis.org", source.Name, "Matching subpath should be preferred")
+
+	source, ok = matchSource(sources, "sources/2023/04/24/state/updates/raw.eml")
+	assert.True(t, ok)
+	assert.Equal(t, "state-updates", source.Name)
+
+	source, ok = matchSource(sources, "sources/2023/04/24/other/raw.eml")
+	assert.True(t, ok)
+	assert.Equal(t, "catchall", source.Name)
+
+	_, ok = matchSource(sources[1:], "sources/2023/04/24/other/raw.eml")
+	assert.False(t, ok)
+}
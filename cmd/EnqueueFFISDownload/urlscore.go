@@ -0,0 +1,76 @@
+package main
+
+import (
+	neturl "net/url"
+	"sort"
+	"strings"
+)
+
+// Weights of the signals that contribute to a candidate URL's score. A URL that is hosted by an
+// allowed host, references a data file, and appears first in the email scores 1.
+const (
+	urlScoreHostWeight      = 0.6
+	urlScoreExtensionWeight = 0.3
+	urlScorePositionWeight  = 0.1
+)
+
+// defaultDataFileExtensions are the extensions that indicate a data file when no
+// DOWNLOAD_ALLOWED_EXTENSIONS are configured.
+const defaultDataFileExtensions = ".xlsx,.xls,.csv"
+
+// scoredURL is a candidate download URL along with its score.
+type scoredURL struct {
+	URL   string
+	Score float64
+}
+
+// scoreURLs scores each of the candidate URLs (in order of appearance) according to whether its
+// host is one of (or a subdomain of one of) allowedHosts, whether its path ends with one of
+// dataExtensions, and how early it appears. The scored URLs are returned highest score first.
+func scoreURLs(candidates []string, allowedHosts []string, dataExtensions string) []scoredURL {
+	scored := make([]scoredURL, 0, len(candidates))
+	for i, candidate := range candidates {
+		score := urlScorePositionWeight * (1 - float64(i)/float64(len(candidates)))
+		if u, err := neturl.Parse(candidate); err == nil && isAllowedHost(u.Hostname(), allowedHosts) {
+			score += urlScoreHostWeight
+		}
+		if checkURLExtension(candidate, dataExtensions) == nil {
+			score += urlScoreExtensionWeight
+		}
+		scored = append(scored, scoredURL{candidate, score})
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	return scored
+}
+
+// isAllowedHost returns true when host is one of allowedHosts or a subdomain of one of them.
+func isAllowedHost(host string, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectConfidentURL picks the highest-scoring of multiple candidate URLs.
+// The confidence of the selection is the margin by which the best URL outscores the runner-up;
+// ok is false when the confidence does not reach minConfidence, i.e. when the candidates are
+// too similar to tell which is the data file.
+func selectConfidentURL(candidates []string, minConfidence float64) (url string, confidence float64, ok bool) {
+	dataExtensions := env.AllowedExtensions
+	if strings.TrimSpace(dataExtensions) == "" {
+		dataExtensions = defaultDataFileExtensions
+	}
+	scored := scoreURLs(candidates, strings.Split(env.URLHostAllowlist, ","), dataExtensions)
+	if len(scored) == 0 {
+		return "", 0, false
+	}
+	confidence = scored[0].Score
+	if len(scored) > 1 {
+		confidence -= scored[1].Score
+	}
+	return scored[0].URL, confidence, confidence >= minConfidence
+}
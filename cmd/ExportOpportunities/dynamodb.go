@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+)
+
+type DynamoDBScanAPI interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// ScanOpportunityItems scans the given table one page (of at most pageSize items) at a time,
+// calling fn with the items of each page in turn. When source is not empty, only items with
+// data from that source are scanned. Scanning stops at the first error returned by fn.
+func ScanOpportunityItems(ctx context.Context, c DynamoDBScanAPI, table, source string, pageSize int32,
+	fn func([]map[string]types.AttributeValue) error) error {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(table),
+		Limit:     aws.Int32(pageSize),
+	}
+	if source != "" {
+		filter := expression.Contains(expression.Name(awsHelpers.DDBSourcesAttributeName), source)
+		expr, err := expression.NewBuilder().WithFilter(filter).Build()
+		if err != nil {
+			return err
+		}
+		input.FilterExpression = expr.Filter()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+
+	for {
+		output, err := c.Scan(ctx, input)
+		if err != nil {
+			return err
+		}
+		if err := fn(output.Items); err != nil {
+			return err
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
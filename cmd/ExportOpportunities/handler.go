@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
+)
+
+const (
+	// exportDateLayout formats the date that names each export.
+	exportDateLayout = "2006-01-02"
+	// listValueSeparator separates the values of list attributes within a single CSV field.
+	listValueSeparator = "; "
+	// formulaPrefixes are the leading characters that cause spreadsheet applications to
+	// evaluate a CSV field as a formula.
+	formulaPrefixes = "=+-@"
+)
+
+// utf8BOM is written at the start of each CSV export so that Excel recognizes it as UTF-8.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+type opportunity grantsgov.OpportunitySynopsisDetail_1_0
+
+// columns are the header of the CSV export, which are the names of the opportunity fields
+// in the order that they are declared.
+var columns = opportunityColumns()
+
+// ScheduledEvent represents the invocation event for this Lambda function.
+// When Timestamp is zero, the export is named for the current date.
+type ScheduledEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type UploadManager interface {
+	Upload(context.Context, *s3.PutObjectInput, ...func(*manager.Uploader)) (*manager.UploadOutput, error)
+}
+
+// ExportManifest describes a completed CSV export.
+type ExportManifest struct {
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	Source      string    `json:"source,omitempty"`
+	Columns     []string  `json:"columns"`
+	RowCount    int       `json:"rowCount"`
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// handleEvent exports the opportunities table to a CSV file named for the date of the event,
+// then writes a manifest for the export. Items are scanned one page at a time and streamed
+// to S3 as they are written, so the export is never held in memory in its entirety.
+func handleEvent(ctx context.Context, dynamodbClient DynamoDBScanAPI, uploader UploadManager, event ScheduledEvent) error {
+	now := event.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+	date := now.Format(exportDateLayout)
+	csvKey := path.Join(env.ExportPrefix, date+".csv")
+	manifestKey := path.Join(env.ExportPrefix, date+".manifest.json")
+	logger := log.With(logger, "bucket", env.ExportBucket, "key", csvKey, "source", env.ExportSource)
+
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "opportunities.export")
+	rowCount, err := exportCSV(spanCtx, dynamodbClient, uploader, csvKey)
+	span.SetTag("rows", rowCount)
+	tracing.FinishWithOutcome(span, err)
+	if err != nil {
+		log.Error(logger, "Error exporting opportunities to CSV", err, "count_rows", rowCount)
+		sendMetric("export.failed", 1)
+		return err
+	}
+	log.Info(logger, "Exported opportunities to CSV", "count_rows", rowCount)
+	sendMetric("opportunity.exported", float64(rowCount))
+
+	manifest := ExportManifest{
+		Bucket:      env.ExportBucket,
+		Key:         csvKey,
+		Source:      env.ExportSource,
+		Columns:     columns,
+		RowCount:    rowCount,
+		GeneratedAt: now,
+	}
+	if err := uploadManifest(ctx, uploader, manifestKey, manifest); err != nil {
+		log.Error(logger, "Error uploading export manifest", err, "manifest_key", manifestKey)
+		sendMetric("export.failed", 1)
+		return err
+	}
+	log.Info(logger, "Uploaded export manifest", "manifest_key", manifestKey)
+	return nil
+}
+
+// exportCSV streams the CSV export of the opportunities table to the given key of the export
+// bucket and returns the number of rows (excluding the header) that were exported.
+func exportCSV(ctx context.Context, c DynamoDBScanAPI, uploader UploadManager, key string) (int, error) {
+	pr, pw := io.Pipe()
+	type result struct {
+		rows int
+		err  error
+	}
+	written := make(chan result, 1)
+	go func() {
+		rows, err := writeCSV(ctx, c, pw)
+		pw.CloseWithError(err)
+		written <- result{rows, err}
+	}()
+
+	_, uploadErr := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(env.ExportBucket),
+		Key:                  aws.String(key),
+		Body:                 pr,
+		ContentType:          aws.String("text/csv; charset=utf-8"),
+		ServerSideEncryption: s3Types.ServerSideEncryptionAes256,
+	})
+	// Unblock the writer in case the upload stopped reading before the export was complete
+	pr.CloseWithError(uploadErr)
+	res := <-written
+	if res.err != nil {
+		return res.rows, fmt.Errorf("error writing CSV: %w", res.err)
+	}
+	if uploadErr != nil {
+		return res.rows, fmt.Errorf("error uploading CSV: %w", uploadErr)
+	}
+	return res.rows, nil
+}
+
+// writeCSV writes a CSV-encoded row to w for every opportunity in the table, preceded by a
+// UTF-8 byte order mark and a header row. Returns the number of rows written (excluding the header).
+func writeCSV(ctx context.Context, c DynamoDBScanAPI, w io.Writer) (int, error) {
+	if _, err := w.Write(utf8BOM); err != nil {
+		return 0, err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	err := ScanOpportunityItems(ctx, c, env.SourceTable, env.ExportSource, int32(env.ScanPageSize),
+		func(items []map[string]types.AttributeValue) error {
+			for _, item := range items {
+				var opp opportunity
+				if err := attributevalue.UnmarshalMap(item, &opp); err != nil {
+					return fmt.Errorf("error decoding opportunity item: %w", err)
+				}
+				if err := cw.Write(opportunityRecord(opp)); err != nil {
+					return err
+				}
+				rows++
+			}
+			// Flush after every page so that rows are streamed rather than accumulated
+			cw.Flush()
+			return cw.Error()
+		})
+	if err != nil {
+		return rows, err
+	}
+	cw.Flush()
+	return rows, cw.Error()
+}
+
+func uploadManifest(ctx context.Context, uploader UploadManager, key string, manifest ExportManifest) error {
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(env.ExportBucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(b),
+		ContentType:          aws.String("application/json"),
+		ServerSideEncryption: s3Types.ServerSideEncryptionAes256,
+	})
+	return err
+}
+
+// opportunityColumns returns the names of the fields of the opportunity struct,
+// in the order that they are declared.
+func opportunityColumns() []string {
+	t := reflect.TypeOf(opportunity{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = t.Field(i).Name
+	}
+	return names
+}
+
+// opportunityRecord returns the CSV record for opp, whose fields correspond to columns.
+// The values of list fields are joined by listValueSeparator. Fields are escaped with
+// escapeFormula, since opportunities are described by their (untrusted) submitters.
+func opportunityRecord(opp opportunity) []string {
+	v := reflect.ValueOf(opp)
+	record := make([]string, v.NumField())
+	for i := range record {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Slice:
+			values := make([]string, field.Len())
+			for j := range values {
+				values[j] = fmt.Sprint(field.Index(j).Interface())
+			}
+			record[i] = strings.Join(values, listValueSeparator)
+		default:
+			record[i] = fmt.Sprint(field.Interface())
+		}
+		record[i] = escapeFormula(record[i])
+	}
+	return record
+}
+
+// escapeFormula prefixes value with a single quote when it begins with a character in
+// formulaPrefixes, so that spreadsheet applications display it as text rather than
+// evaluating it as a formula when the export is opened.
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-kit/log"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
+)
+
+func setupLambdaEnvForTesting(t *testing.T, extras goenv.EnvSet) {
+	t.Helper()
+
+	// Suppress normal lambda log output
+	logger = log.NewNopLogger()
+	sendMetric = func(metric string, value float64, tags ...string) {}
+
+	// Configure environment variables
+	es := goenv.EnvSet{
+		"GRANTS_PREPARED_DYNAMODB_NAME": "test-table",
+		"EXPORT_BUCKET_NAME":            "test-exports",
+		"SCAN_PAGE_SIZE":                "2",
+	}
+	for k, v := range extras {
+		es[k] = v
+	}
+	env = Environment{}
+	err := goenv.Unmarshal(es, &env)
+	require.NoError(t, err, "Error configuring environment variables for testing")
+}
+
+func setupS3ForTesting(t *testing.T, bucketName string) *s3.Client {
+	t.Helper()
+
+	// Start the S3 mock server and shut it down when the test ends
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	ts := httptest.NewServer(faker.Server())
+	t.Cleanup(ts.Close)
+
+	cfg, _ := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: ts.URL}, nil
+			}),
+		),
+	)
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+	_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+	require.NoError(t, err)
+	return client
+}
+
+// fakeTable is a DynamoDB table that supports paginated scans of its items,
+// which are returned in order of their grant_id.
+type fakeTable struct {
+	items     []map[string]types.AttributeValue
+	scanCalls []*dynamodb.ScanInput
+}
+
+func (f *fakeTable) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.scanCalls = append(f.scanCalls, params)
+	start := 0
+	if params.ExclusiveStartKey != nil {
+		lastID := params.ExclusiveStartKey["grant_id"].(*types.AttributeValueMemberS).Value
+		start = sort.Search(len(f.items), func(i int) bool {
+			return f.items[i]["grant_id"].(*types.AttributeValueMemberS).Value > lastID
+		})
+	}
+	end := start + int(aws.ToInt32(params.Limit))
+	if end > len(f.items) {
+		end = len(f.items)
+	}
+	output := &dynamodb.ScanOutput{Items: f.items[start:end]}
+	if end < len(f.items) {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{"grant_id": f.items[end-1]["grant_id"]}
+	}
+	return output, nil
+}
+
+// seedTable returns a fake table holding an item for each of the given opportunities.
+func seedTable(t *testing.T, opps ...opportunity) *fakeTable {
+	t.Helper()
+	table := &fakeTable{}
+	for _, opp := range opps {
+		item, err := attributevalue.MarshalMap(opp)
+		require.NoError(t, err)
+		item["grant_id"] = &types.AttributeValueMemberS{Value: string(opp.OpportunityID)}
+		item[awsHelpers.DDBSourcesAttributeName] = &types.AttributeValueMemberSS{
+			Value: []string{grantsgov.DynamoDBSource},
+		}
+		table.items = append(table.items, item)
+	}
+	sort.Slice(table.items, func(i, j int) bool {
+		return table.items[i]["grant_id"].(*types.AttributeValueMemberS).Value <
+			table.items[j]["grant_id"].(*types.AttributeValueMemberS).Value
+	})
+	return table
+}
+
+// seededOpportunities are the opportunities whose export is given by fixtures/golden.csv.
+var seededOpportunities = []opportunity{
+	{
+		OpportunityID:         "100001",
+		OpportunityTitle:      "Rural Broadband Deployment",
+		OpportunityNumber:     "USDA-RUS-24-001",
+		OpportunityCategory:   "D",
+		FundingInstrumentType: []grantsgov.FundingInstrumentTypes{"G", "CA"},
+		CFDANumbers:           []grantsgov.CFDANumberType{"10.886", "10.752"},
+		EligibleApplicants:    []grantsgov.EligibleApplicantTypes{"00", "25"},
+		AgencyCode:            "USDA-RUS",
+		AgencyName:            "Rural Utilities Service",
+		PostDate:              "01022024",
+		CloseDate:             "03042024",
+		LastUpdatedDate:       "01032024",
+		AwardCeiling:          "1000000",
+		AwardFloor:            "50000",
+		Description:           "Funds broadband, \"last mile\" connections, and related equipment.",
+		Version:               "Synopsis 1",
+	},
+	{
+		OpportunityID:          "100002",
+		OpportunityTitle:       "Community Health Workers, Training & Support",
+		OpportunityNumber:      "HHS-2024-ACF-001",
+		OpportunityCategory:    "D",
+		CFDANumbers:            []grantsgov.CFDANumberType{"93.243"},
+		AgencyCode:             "HHS-ACF",
+		AgencyName:             "Administration for Children and Families",
+		LastUpdatedDate:        "02012024",
+		CloseDateExplanation:   "Applications are reviewed\non a rolling basis.",
+		Description:            "Soutien aux agents de santé communautaires.",
+		GrantorContactEmail:    "grants@example.gov",
+		GrantorContactText:     " Program Office",
+		ExpectedNumberOfAwards: "12",
+	},
+	{
+		OpportunityID:    "100003",
+		OpportunityTitle: "Minimal Opportunity",
+		LastUpdatedDate:  "03012024",
+	},
+}
+
+func TestHandleEventGoldenCSV(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	s3svc := setupS3ForTesting(t, env.ExportBucket)
+	table := seedTable(t, seededOpportunities...)
+	timestamp := time.Date(2024, 3, 15, 16, 30, 0, 0, time.UTC)
+
+	err := handleEvent(context.Background(), table, manager.NewUploader(s3svc), ScheduledEvent{Timestamp: timestamp})
+	require.NoError(t, err)
+
+	// Pages of 2 items require 2 scans of the 3 seeded items
+	require.Len(t, table.scanCalls, 2)
+	for _, call := range table.scanCalls {
+		assert.Equal(t, "test-table", aws.ToString(call.TableName))
+		assert.Equal(t, int32(2), aws.ToInt32(call.Limit))
+		assert.Nil(t, call.FilterExpression)
+	}
+
+	resp, err := s3svc.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String("test-exports"),
+		Key:    aws.String("exports/opportunities/2024-03-15.csv"),
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/csv; charset=utf-8", aws.ToString(resp.ContentType))
+	actual, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	expected, err := os.ReadFile("fixtures/golden.csv")
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(actual, utf8BOM), "CSV export should start with a UTF-8 BOM")
+	assert.Equal(t, string(expected), string(actual))
+
+	resp, err = s3svc.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String("test-exports"),
+		Key:    aws.String("exports/opportunities/2024-03-15.manifest.json"),
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var manifest ExportManifest
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	assert.Equal(t, ExportManifest{
+		Bucket:      "test-exports",
+		Key:         "exports/opportunities/2024-03-15.csv",
+		Columns:     columns,
+		RowCount:    3,
+		GeneratedAt: timestamp,
+	}, manifest)
+}
+
+func TestHandleEventEmptyTable(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{"EXPORT_PREFIX": "custom/prefix"})
+	s3svc := setupS3ForTesting(t, env.ExportBucket)
+	table := seedTable(t)
+
+	err := handleEvent(context.Background(), table, manager.NewUploader(s3svc),
+		ScheduledEvent{Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)})
+	require.NoError(t, err)
+
+	resp, err := s3svc.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String("test-exports"),
+		Key:    aws.String("custom/prefix/2024-01-02.csv"),
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	actual, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	header := []byte{}
+	header = append(header, utf8BOM...)
+	for i, column := range columns {
+		if i > 0 {
+			header = append(header, ',')
+		}
+		header = append(header, column...)
+	}
+	header = append(header, '\n')
+	assert.Equal(t, string(header), string(actual))
+
+	resp, err = s3svc.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String("test-exports"),
+		Key:    aws.String("custom/prefix/2024-01-02.manifest.json"),
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var manifest ExportManifest
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	assert.Equal(t, 0, manifest.RowCount)
+}
+
+func TestHandleEventFilterBySource(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{"EXPORT_SOURCE": "ffis.org"})
+	s3svc := setupS3ForTesting(t, env.ExportBucket)
+	table := seedTable(t)
+
+	err := handleEvent(context.Background(), table, manager.NewUploader(s3svc), ScheduledEvent{})
+	require.NoError(t, err)
+	require.Len(t, table.scanCalls, 1)
+	call := table.scanCalls[0]
+	require.NotNil(t, call.FilterExpression)
+	assert.Contains(t, aws.ToString(call.FilterExpression), "contains(")
+	assert.Contains(t, call.ExpressionAttributeNames, "#0")
+	assert.Equal(t, "sources", call.ExpressionAttributeNames["#0"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "ffis.org"}, call.ExpressionAttributeValues[":0"])
+
+	resp, err := s3svc.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String("test-exports"),
+		Key:    aws.String("exports/opportunities/" + time.Now().UTC().Format(exportDateLayout) + ".manifest.json"),
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var manifest ExportManifest
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	assert.Equal(t, "ffis.org", manifest.Source)
+}
+
+type errorScanAPI struct{ err error }
+
+func (e errorScanAPI) Scan(context.Context, *dynamodb.ScanInput, ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return nil, e.err
+}
+
+type mockUploadManager struct {
+	err     error
+	bodies  map[string][]byte
+	readErr error
+}
+
+func (m *mockUploadManager) Upload(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	b, err := io.ReadAll(params.Body)
+	if err != nil {
+		m.readErr = err
+		return nil, err
+	}
+	if m.bodies == nil {
+		m.bodies = map[string][]byte{}
+	}
+	m.bodies[aws.ToString(params.Key)] = b
+	return &manager.UploadOutput{}, nil
+}
+
+func TestHandleEventErrors(t *testing.T) {
+	t.Run("scan fails", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		uploader := &mockUploadManager{}
+		scanErr := errors.New("scan failed")
+
+		err := handleEvent(context.Background(), errorScanAPI{scanErr}, uploader, ScheduledEvent{})
+		require.ErrorIs(t, err, scanErr)
+		assert.ErrorIs(t, uploader.readErr, scanErr, "upload should be aborted with the scan error")
+		assert.Empty(t, uploader.bodies, "no manifest should be written for a failed export")
+	})
+
+	t.Run("upload fails", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		items := make([]opportunity, 10)
+		for i := range items {
+			items[i] = opportunity{OpportunityID: grantsgov.Number20DigitsType(strconv.Itoa(i + 1))}
+		}
+		uploadErr := errors.New("upload failed")
+
+		err := handleEvent(context.Background(), seedTable(t, items...),
+			&mockUploadManager{err: uploadErr}, ScheduledEvent{})
+		require.ErrorIs(t, err, uploadErr)
+	})
+}
+
+func TestOpportunityColumns(t *testing.T) {
+	assert.Equal(t, "OpportunityID", columns[0])
+	assert.Equal(t, "OpportunityTitle", columns[1])
+	assert.Contains(t, columns, "CFDANumbers")
+	assert.Equal(t, "GrantorContactText", columns[len(columns)-1])
+	assert.Len(t, opportunityRecord(opportunity{}), len(columns))
+}
+
+func TestOpportunityRecordEscapesFormulas(t *testing.T) {
+	record := opportunityRecord(opportunity{
+		OpportunityID:                      "100004",
+		OpportunityTitle:                   "=HYPERLINK(\"https://example.com\", \"Apply\")",
+		AwardCeiling:                       "-1",
+		AwardFloor:                         "+1",
+		Description:                        "@SUM(A1:A2)",
+		AdditionalInformationOnEligibility: "Open to all = applicants",
+		CFDANumbers:                        []grantsgov.CFDANumberType{"-10.886", "10.752"},
+	})
+	field := func(name string) string {
+		for i, column := range columns {
+			if column == name {
+				return record[i]
+			}
+		}
+		require.Failf(t, "unknown column", "%q is not a column", name)
+		return ""
+	}
+
+	assert.Equal(t, "100004", field("OpportunityID"))
+	assert.Equal(t, "'=HYPERLINK(\"https://example.com\", \"Apply\")", field("OpportunityTitle"))
+	assert.Equal(t, "'-1", field("AwardCeiling"))
+	assert.Equal(t, "'+1", field("AwardFloor"))
+	assert.Equal(t, "'@SUM(A1:A2)", field("Description"))
+	assert.Equal(t, "Open to all = applicants", field("AdditionalInformationOnEligibility"),
+		"only leading formula characters should be escaped")
+	assert.Equal(t, "'-10.886; 10.752", field("CFDANumbers"))
+	assert.Equal(t, "", field("ArchiveDate"))
+}
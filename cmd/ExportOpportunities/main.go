@@ -0,0 +1,79 @@
+// Package main compiles to an AWS Lambda handler binary that, when invoked on a schedule,
+// exports every opportunity in the DynamoDB table identified by the
+// GRANTS_PREPARED_DYNAMODB_NAME environment variable to a single CSV file, which is written to
+// <EXPORT_PREFIX>/YYYY-MM-DD.csv in the S3 bucket named by the EXPORT_BUCKET_NAME environment
+// variable. A JSON manifest describing the export is written alongside the CSV file.
+// When EXPORT_SOURCE is configured, only opportunities with data from that source
+// (e.g. grants.gov) are exported.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+type Environment struct {
+	LogLevel          string `env:"LOG_LEVEL,default=INFO"`
+	SourceTable       string `env:"GRANTS_PREPARED_DYNAMODB_NAME,required=true"`
+	ExportBucket      string `env:"EXPORT_BUCKET_NAME,required=true"`
+	ExportPrefix      string `env:"EXPORT_PREFIX,default=exports/opportunities"`
+	ExportSource      string `env:"EXPORT_SOURCE"`
+	ScanPageSize      int    `env:"SCAN_PAGE_SIZE,default=500"`
+	UsePathStyleS3Opt bool   `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider   string `env:"TRACING_PROVIDER,default=datadog"`
+	Extras            goenv.EnvSet
+}
+
+var (
+	env        Environment
+	logger     log.Logger
+	sendMetric = ddHelpers.NewMetricSender("ExportOpportunities")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	if env.ScanPageSize < 1 {
+		goLog.Fatalf("error configuring environment variables: SCAN_PAGE_SIZE must be positive")
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event ScheduledEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		dynamodbSvc := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {})
+		s3Svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = env.UsePathStyleS3Opt
+		})
+		return handleEvent(ctx, dynamodbSvc, manager.NewUploader(s3Svc), event)
+	}, nil))
+}
@@ -14,6 +14,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/krolaw/zipstream"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 )
 
@@ -38,6 +40,7 @@ func fileUploadStream(ctx context.Context, m UploadManager, r io.Reader, bucket,
 		Bucket:               aws.String(bucket),
 		Key:                  aws.String(key),
 		Body:                 data,
+		Metadata:             awsHelpers.WithProcessorVersion(awsHelpers.WithLambdaRequestID(ctx, nil)),
 		ServerSideEncryption: types.ServerSideEncryptionAes256,
 	}); err != nil {
 		return log.Errorf(logger, "error uploading extracted XML to S3", err)
@@ -111,32 +114,6 @@ func manageStreamingDownloadUpload(ctx context.Context, c S3UploaderDownloaderAP
 	return nil
 }
 
-func moveS3Object(ctx context.Context, svc S3MoverAPIClient, bucket, oldKey, newKey string) error {
-	logger := log.With(logger, "bucket", bucket, "source_key", oldKey, "destination_key", newKey)
-
-	if _, err := svc.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:               aws.String(bucket),
-		CopySource:           aws.String(filepath.Join(bucket, oldKey)),
-		Key:                  aws.String(newKey),
-		ServerSideEncryption: types.ServerSideEncryptionAes256,
-	}); err != nil {
-		return log.Errorf(logger, "error copying extracted XML to permanent destination", err)
-	}
-	log.Debug(logger, "copied extracted XML to permanent destination")
-	sendMetric("xml.uploaded", 1)
-
-	if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(oldKey),
-	}); err != nil {
-		return log.Errorf(logger, "error deleting extracted XML from temporary destination", err)
-	}
-	log.Debug(logger, "deleted extracted XML from temporary destination")
-
-	log.Info(logger, "moved extracted XML to permanent destination")
-	return nil
-}
-
 // For local testing use the following event payload.
 // Note: you may need to change the bucket and file name as you see fit based on what is available
 // in your local environemnt.
@@ -147,6 +124,9 @@ func moveS3Object(ctx context.Context, svc S3MoverAPIClient, bucket, oldKey, new
 //	  --payload $(printf '{"Records":[{"s3":{"bucket":{"name":"grantsingest-tsh-grantssourcedata-456635181950-us-west-2"},"object":{"key":"archive.zip"}}}]}' | base64) \
 //	  /dev/stdout
 func handleS3Event(ctx context.Context, s3svc S3UploaderDownloaderMoverAPIClient, s3Event events.S3Event) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureExtract, len(s3Event.Records)) {
+		return nil
+	}
 	record := s3Event.Records[0]
 	bucket := record.S3.Bucket.Name
 	sourceKey := record.S3.Object.Key
@@ -160,10 +140,13 @@ func handleS3Event(ctx context.Context, s3svc S3UploaderDownloaderMoverAPIClient
 		return log.Errorf(logger, "failed to stream zip archive to XML object", err)
 	}
 
-	if err := moveS3Object(ctx, s3svc, bucket, tmpDestinationKey, destinationKey); err != nil {
+	if err := awsHelpers.MoveS3Object(ctx, s3svc, bucket, tmpDestinationKey, destinationKey); err != nil {
 		return log.Errorf(logger,
 			"failed to move XML upload from temporary path to permanent destination", err)
 	}
+	sendMetric("xml.uploaded", 1)
+	log.Info(logger, "moved extracted XML to permanent destination",
+		"bucket", bucket, "source_key", tmpDestinationKey, "destination_key", destinationKey)
 
 	return nil
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 )
 
 func setupLambdaEnvForTesting(t *testing.T) {
@@ -252,85 +253,6 @@ func TestFileDownloadStream(t *testing.T) {
 	})
 }
 
-type MockS3MoverAPIClient struct {
-	Copier  func(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
-	Deleter func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
-	// callCounter map[string]int
-	callCounter *MockS3MoverAPIClientCallCounter
-}
-
-type MockS3MoverAPIClientCallCounter struct {
-	CopyObject, DeleteObject int
-}
-
-func (c MockS3MoverAPIClient) CopyObject(
-	ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options),
-) (*s3.CopyObjectOutput, error) {
-	// c.callCounter["CopyObject"] += 1
-	c.callCounter.CopyObject++
-	return c.Copier(ctx, input, opts...)
-}
-
-func (c MockS3MoverAPIClient) DeleteObject(
-	ctx context.Context, input *s3.DeleteObjectInput, opts ...func(*s3.Options),
-) (*s3.DeleteObjectOutput, error) {
-	c.callCounter.DeleteObject++
-	return c.Deleter(ctx, input, opts...)
-}
-
-func TestMoveS3Object(t *testing.T) {
-	setupLambdaEnvForTesting(t)
-	bucket := "test-bucket"
-	oldKey := "old"
-	newKey := "new"
-	prepareS3 := func(t *testing.T) *s3.Client {
-		t.Helper()
-		s3svc, _, err := setupS3ForTesting(t, bucket)
-		require.NoError(t, err)
-		_, err = manager.NewUploader(s3svc).Upload(context.Background(), &s3.PutObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(oldKey),
-			Body:   bytes.NewBuffer([]byte("some data")),
-		})
-		require.NoError(t, err)
-		return s3svc
-	}
-
-	t.Run("copy failure", func(t *testing.T) {
-		s3svc := prepareS3(t)
-		svc := MockS3MoverAPIClient{
-			Copier: func(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (
-				*s3.CopyObjectOutput, error,
-			) {
-				return &s3.CopyObjectOutput{}, fmt.Errorf("some copy failure")
-			},
-			Deleter:     s3svc.DeleteObject,
-			callCounter: &MockS3MoverAPIClientCallCounter{},
-		}
-		assert.EqualError(t,
-			moveS3Object(context.Background(), svc, bucket, oldKey, newKey),
-			"error copying extracted XML to permanent destination: some copy failure")
-		assert.Equal(t, 1, svc.callCounter.CopyObject)
-		assert.Equal(t, 0, svc.callCounter.DeleteObject)
-	})
-
-	t.Run("delete failure", func(t *testing.T) {
-		s3svc := prepareS3(t)
-		svc := MockS3MoverAPIClient{
-			Copier: s3svc.CopyObject,
-			Deleter: func(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
-				return &s3.DeleteObjectOutput{}, fmt.Errorf("some delete failure")
-			},
-			callCounter: &MockS3MoverAPIClientCallCounter{},
-		}
-		assert.EqualError(t,
-			moveS3Object(context.Background(), svc, bucket, oldKey, newKey),
-			"error deleting extracted XML from temporary destination: some delete failure")
-		assert.Equal(t, 1, svc.callCounter.CopyObject)
-		assert.Equal(t, 1, svc.callCounter.DeleteObject)
-	})
-}
-
 func TestHandleS3Event(t *testing.T) {
 	setupLambdaEnvForTesting(t)
 	bucket := "test-bucket"
@@ -395,3 +317,43 @@ func TestHandleS3Event(t *testing.T) {
 			"failed to stream zip archive to XML object: error streaming source object from S3: operation error S3: GetObject, context canceled")
 	})
 }
+
+func TestHandleS3EventFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureExtract: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		assert.Fail(t, "Unexpected S3 request while disabled", "%s %s", req.Method, req.URL)
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: server.URL}, nil
+			}),
+		),
+	)
+	require.NoError(t, err)
+	s3svc := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+
+	err = handleS3Event(context.Background(), s3svc, events.S3Event{
+		Records: []events.S3EventRecord{{
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "test-bucket"},
+				Object: events.S3Object{Key: "path/to/archive.zip"},
+			},
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
@@ -29,15 +30,17 @@ type Environment struct {
 	LogLevel          string `env:"LOG_LEVEL,default=INFO"`
 	UsePathStyleS3Opt bool   `env:"S3_USE_PATH_STYLE,default=false"`
 	TmpKeyPrefix      string `env:"TMP_KEY_PATH_PREFIX,default=tmp"`
+	DisabledFeatures  string `env:"DISABLED_FEATURES"`
 	Extras            goenv.EnvSet
 	// Should use zero (default) except during testing or performance tuning
 	DownloadPartSize int64 `env:"DOWNLOAD_PART_SIZE,default=0"`
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("ExtractGrantsGovDBToXML", "source:grants.gov")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("ExtractGrantsGovDBToXML", "source:grants.gov")
 )
 
 func main() {
@@ -47,9 +50,18 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, s3Event events.S3Event) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
 		cfg, err := awsHelpers.GetConfig(ctx)
 		if err != nil {
 			return fmt.Errorf("could not create AWS SDK config: %w", err)
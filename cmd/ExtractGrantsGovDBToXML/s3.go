@@ -6,6 +6,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
 )
 
 type (
@@ -22,16 +23,10 @@ type (
 		manager.DownloadAPIClient
 	}
 
-	// S3MoverAPIClient is an API client copies and (subsequently) deletes S3 objects
-	S3MoverAPIClient interface {
-		CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
-		DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
-	}
-
 	// S3UploaderDownloaderMoverAPIClient is an API client that downloads, uploads, and moves S3 objects
 	S3UploaderDownloaderMoverAPIClient interface {
 		S3UploaderDownloaderAPIClient
-		S3MoverAPIClient
+		awsHelpers.S3MoveObjectAPI
 	}
 )
 
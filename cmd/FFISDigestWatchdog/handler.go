@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebTypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// ScheduledEvent represents the invocation event for this Lambda function.
+// When Timestamp is zero, the age of the newest digest is computed relative to the current time.
+type ScheduledEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DigestAlert is the detail of the alert that is published when the newest FFIS digest
+// is older than the configured maximum age.
+type DigestAlert struct {
+	Bucket     string     `json:"bucket"`
+	NewestKey  string     `json:"newest_key,omitempty"`
+	NewestDate *time.Time `json:"newest_date,omitempty"`
+	AgeDays    float64    `json:"age_days,omitempty"`
+	MaxAgeDays int        `json:"max_age_days"`
+	Message    string     `json:"message"`
+}
+
+// AlertPublisher publishes alerts about overdue FFIS digests.
+type AlertPublisher interface {
+	PublishAlert(context.Context, DigestAlert) error
+}
+
+type EventBridgePutEventsAPI interface {
+	PutEvents(context.Context, *eventbridge.PutEventsInput, ...func(*eventbridge.Options)) (
+		*eventbridge.PutEventsOutput, error)
+}
+
+type SNSPublishAPI interface {
+	Publish(context.Context, *sns.PublishInput, ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// digestKeyPattern matches the keys of archived FFIS digest emails, which are keyed as
+// "sources/YYYY/MM[/DD[/HH]]/<subpath>/raw.eml" depending on the date granularity configured
+// for ReceiveFFISEmail. Older keys may have unpadded month, day, and hour components.
+func digestKeyPattern(subpath string) *regexp.Regexp {
+	return regexp.MustCompile(`^sources/(\d{4})/(\d{1,2})(?:/(\d{1,2}))?(?:/(\d{1,2}))?/` +
+		regexp.QuoteMeta(subpath) + `/raw\.eml$`)
+}
+
+// parseDigestKeyDate returns the (UTC) date represented by the date components of a digest key.
+// When the key omits the day or hour, the date is the start of the month or day, respectively.
+// ok is false when key is not the key of an archived digest email or its date is invalid.
+func parseDigestKeyDate(pattern *regexp.Regexp, key string) (date time.Time, ok bool) {
+	m := pattern.FindStringSubmatch(key)
+	if m == nil {
+		return time.Time{}, false
+	}
+	components := []int{0, 1, 1, 0} // year, month, day, hour
+	for i, s := range m[1:] {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return time.Time{}, false
+		}
+		components[i] = n
+	}
+	year, month, day, hour := components[0], components[1], components[2], components[3]
+	date = time.Date(year, time.Month(month), day, hour, 0, 0, 0, time.UTC)
+	// time.Date normalizes out-of-range values, e.g. month 13, which are not valid dates
+	if date.Month() != time.Month(month) || date.Day() != day || date.Hour() != hour {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// handleEvent is a Lambda function handler that is called with the ScheduledEvent invocation
+// event. When invoked, it finds the newest FFIS digest archived in the source data bucket
+// during the current or previous year and publishes an alert if that digest is older than
+// env.MaxDigestAgeDays (or if no digest could be found).
+func handleEvent(ctx context.Context, s3Client s3.ListObjectsV2APIClient, alerter AlertPublisher, event ScheduledEvent) error {
+	now := event.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+	logger := log.With(logger, "bucket", env.SourceBucket, "max_digest_age_days", env.MaxDigestAgeDays)
+
+	pattern := digestKeyPattern(env.DigestSubpath)
+	var newestKey string
+	var newestDate time.Time
+	for _, year := range []int{now.Year() - 1, now.Year()} {
+		prefix := fmt.Sprintf("sources/%d/", year)
+		objects, err := awsHelpers.ListS3Objects(ctx, s3Client, env.SourceBucket, prefix)
+		if err != nil {
+			return log.Errorf(logger, "Error listing archived FFIS digests", err)
+		}
+		for _, obj := range objects {
+			key := aws.ToString(obj.Key)
+			if date, ok := parseDigestKeyDate(pattern, key); ok && date.After(newestDate) {
+				newestKey, newestDate = key, date
+			}
+		}
+	}
+
+	alert := DigestAlert{Bucket: env.SourceBucket, MaxAgeDays: env.MaxDigestAgeDays}
+	if newestKey == "" {
+		log.Warn(logger, "No archived FFIS digest found")
+		alert.Message = "No FFIS digest has been archived since the start of the previous year"
+		return publishAlert(ctx, logger, alerter, alert)
+	}
+
+	ageDays := now.Sub(newestDate).Hours() / 24
+	logger = log.With(logger, "newest_key", newestKey, "newest_date", newestDate, "age_days", ageDays)
+	sendMetric("ffis.digest.age_days", ageDays)
+	if ageDays <= float64(env.MaxDigestAgeDays) {
+		log.Info(logger, "Newest FFIS digest is within the maximum age")
+		return nil
+	}
+
+	log.Warn(logger, "Newest FFIS digest is older than the maximum age")
+	alert.NewestKey = newestKey
+	alert.NewestDate = &newestDate
+	alert.AgeDays = ageDays
+	alert.Message = fmt.Sprintf("No FFIS digest has been archived in the last %d days", env.MaxDigestAgeDays)
+	return publishAlert(ctx, logger, alerter, alert)
+}
+
+func publishAlert(ctx context.Context, logger log.Logger, alerter AlertPublisher, alert DigestAlert) error {
+	if err := alerter.PublishAlert(ctx, alert); err != nil {
+		return log.Errorf(logger, "Error publishing overdue FFIS digest alert", err)
+	}
+	sendMetric("ffis.digest.alert_published", 1)
+	log.Info(logger, "Published overdue FFIS digest alert")
+	return nil
+}
+
+// eventBridgeAlertPublisher publishes alerts as FFISDigestOverdue events to an EventBridge bus.
+type eventBridgeAlertPublisher struct {
+	client       EventBridgePutEventsAPI
+	eventBusName string
+}
+
+func (p *eventBridgeAlertPublisher) PublishAlert(ctx context.Context, alert DigestAlert) error {
+	detail, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("error marshaling alert: %w", err)
+	}
+	resp, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebTypes.PutEventsRequestEntry{{
+			Source:       aws.String("org.usdigitalresponse.grants-ingest"),
+			DetailType:   aws.String("FFISDigestOverdue"),
+			Detail:       aws.String(string(detail)),
+			EventBusName: aws.String(p.eventBusName),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing to EventBridge: %w", err)
+	}
+	if resp.FailedEntryCount > 0 {
+		for _, entry := range resp.Entries {
+			if entry.ErrorCode != nil {
+				return fmt.Errorf("EventBridge rejected the alert event: %s: %s",
+					aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+			}
+		}
+		return fmt.Errorf("EventBridge rejected the alert event")
+	}
+	return nil
+}
+
+// snsAlertPublisher publishes alerts as messages to an SNS topic.
+type snsAlertPublisher struct {
+	client   SNSPublishAPI
+	topicARN string
+}
+
+func (p *snsAlertPublisher) PublishAlert(ctx context.Context, alert DigestAlert) error {
+	message, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("error marshaling alert: %w", err)
+	}
+	if _, err := p.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Subject:  aws.String("FFIS digest overdue"),
+		Message:  aws.String(string(message)),
+	}); err != nil {
+		return fmt.Errorf("error publishing to SNS: %w", err)
+	}
+	return nil
+}
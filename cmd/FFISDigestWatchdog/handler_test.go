@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebTypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/go-kit/log"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLambdaEnvForTesting(t *testing.T) {
+	t.Helper()
+	logger = log.NewNopLogger()
+	env = Environment{
+		SourceBucket:     "test-source-bucket",
+		DigestSubpath:    "ffis.org",
+		MaxDigestAgeDays: 8,
+		AlertTarget:      AlertTargetEventBridge,
+		EventBusName:     "test-event-bus",
+	}
+	restoreSendMetric := sendMetric
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+}
+
+func setupS3ForTesting(t *testing.T, bucketName string, keys ...string) *s3.Client {
+	t.Helper()
+
+	// Start the S3 mock server and shut it down when the test ends
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	ts := httptest.NewServer(faker.Server())
+	t.Cleanup(ts.Close)
+
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: ts.URL}, nil
+			}),
+		),
+	)
+	require.NoError(t, err)
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+	_, err = client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+	require.NoError(t, err)
+	for _, key := range keys {
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("email")),
+		})
+		require.NoError(t, err)
+	}
+	return client
+}
+
+type mockAlertPublisher struct {
+	alerts []DigestAlert
+	err    error
+}
+
+func (m *mockAlertPublisher) PublishAlert(ctx context.Context, alert DigestAlert) error {
+	m.alerts = append(m.alerts, alert)
+	return m.err
+}
+
+func TestHandleEvent(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name              string
+		keys              []string
+		expectedNewestKey string
+		expectedAgeDays   float64
+		expectAlert       bool
+	}{
+		{
+			"fresh digest with padded key",
+			[]string{
+				"sources/2023/12/29/ffis.org/raw.eml",
+				"sources/2024/01/06/ffis.org/raw.eml",
+				"sources/2024/01/09/grants.gov/archive.zip",
+			},
+			"sources/2024/01/06/ffis.org/raw.eml", 4.5, false,
+		},
+		{
+			"fresh digest with unpadded key",
+			[]string{
+				"sources/2023/12/29/ffis.org/raw.eml",
+				"sources/2024/1/6/ffis.org/raw.eml",
+			},
+			"sources/2024/1/6/ffis.org/raw.eml", 4.5, false,
+		},
+		{
+			"fresh digest with hourly key",
+			[]string{"sources/2024/01/09/18/ffis.org/raw.eml"},
+			"sources/2024/01/09/18/ffis.org/raw.eml", 0.75, false,
+		},
+		{
+			"stale digest archived during the previous year",
+			[]string{
+				"sources/2023/11/24/ffis.org/raw.eml",
+				"sources/2023/12/1/ffis.org/raw.eml",
+				"sources/2024/01/09/grants.gov/archive.zip",
+				"sources/2024/01/09/state_updates/raw.eml",
+			},
+			"sources/2023/12/1/ffis.org/raw.eml", 40.5, true,
+		},
+		{
+			"empty bucket",
+			nil,
+			"", 0, true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setupLambdaEnvForTesting(t)
+			sentMetrics := map[string]float64{}
+			sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] = value }
+			s3Client := setupS3ForTesting(t, env.SourceBucket, tt.keys...)
+			alerter := &mockAlertPublisher{}
+
+			require.NoError(t, handleEvent(context.TODO(), s3Client, alerter, ScheduledEvent{Timestamp: now}))
+
+			if tt.expectedNewestKey != "" {
+				assert.InDelta(t, tt.expectedAgeDays, sentMetrics["ffis.digest.age_days"], 0.001)
+			} else {
+				assert.NotContains(t, sentMetrics, "ffis.digest.age_days")
+			}
+			if !tt.expectAlert {
+				assert.Empty(t, alerter.alerts)
+				assert.NotContains(t, sentMetrics, "ffis.digest.alert_published")
+				return
+			}
+			require.Len(t, alerter.alerts, 1)
+			alert := alerter.alerts[0]
+			assert.Equal(t, env.SourceBucket, alert.Bucket)
+			assert.Equal(t, 8, alert.MaxAgeDays)
+			assert.Equal(t, tt.expectedNewestKey, alert.NewestKey)
+			assert.InDelta(t, tt.expectedAgeDays, alert.AgeDays, 0.001)
+			assert.NotEmpty(t, alert.Message)
+			assert.Equal(t, 1.0, sentMetrics["ffis.digest.alert_published"])
+		})
+	}
+
+	t.Run("alert publishing fails", func(t *testing.T) {
+		setupLambdaEnvForTesting(t)
+		sendMetric = func(string, float64, ...string) {}
+		s3Client := setupS3ForTesting(t, env.SourceBucket)
+		alerter := &mockAlertPublisher{err: errors.New("oh no")}
+		assert.ErrorContains(t, handleEvent(context.TODO(), s3Client, alerter, ScheduledEvent{Timestamp: now}),
+			"oh no")
+	})
+
+	t.Run("listing fails", func(t *testing.T) {
+		setupLambdaEnvForTesting(t)
+		sendMetric = func(string, float64, ...string) {}
+		s3Client := setupS3ForTesting(t, "some-other-bucket")
+		alerter := &mockAlertPublisher{}
+		assert.Error(t, handleEvent(context.TODO(), s3Client, alerter, ScheduledEvent{Timestamp: now}))
+		assert.Empty(t, alerter.alerts)
+	})
+}
+
+func TestParseDigestKeyDate(t *testing.T) {
+	pattern := digestKeyPattern("ffis.org")
+	for _, tt := range []struct {
+		key          string
+		expectedDate time.Time
+		expectedOk   bool
+	}{
+		{"sources/2023/04/22/ffis.org/raw.eml", time.Date(2023, 4, 22, 0, 0, 0, 0, time.UTC), true},
+		{"sources/2023/4/2/ffis.org/raw.eml", time.Date(2023, 4, 2, 0, 0, 0, 0, time.UTC), true},
+		{"sources/2023/04/ffis.org/raw.eml", time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC), true},
+		{"sources/2023/4/ffis.org/raw.eml", time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC), true},
+		{"sources/2023/04/22/15/ffis.org/raw.eml", time.Date(2023, 4, 22, 15, 0, 0, 0, time.UTC), true},
+		{"sources/2023/4/22/5/ffis.org/raw.eml", time.Date(2023, 4, 22, 5, 0, 0, 0, time.UTC), true},
+		{"sources/2023/13/22/ffis.org/raw.eml", time.Time{}, false},
+		{"sources/2023/02/30/ffis.org/raw.eml", time.Time{}, false},
+		{"sources/2023/04/22/ffis.org/download.xlsx", time.Time{}, false},
+		{"sources/2023/04/22/grants.gov/raw.eml", time.Time{}, false},
+		{"sources/2023/04/22/ffis.org/extra/raw.eml", time.Time{}, false},
+		{"tmp/sources/2023/04/22/ffis.org/raw.eml", time.Time{}, false},
+	} {
+		t.Run(tt.key, func(t *testing.T) {
+			date, ok := parseDigestKeyDate(pattern, tt.key)
+			assert.Equal(t, tt.expectedOk, ok)
+			assert.Equal(t, tt.expectedDate, date)
+		})
+	}
+}
+
+func TestValidateAlertTarget(t *testing.T) {
+	assert.NoError(t, validateAlertTarget(Environment{AlertTarget: AlertTargetEventBridge, EventBusName: "bus"}))
+	assert.NoError(t, validateAlertTarget(Environment{AlertTarget: AlertTargetSNS, AlertSNSTopicARN: "arn"}))
+	assert.Error(t, validateAlertTarget(Environment{AlertTarget: AlertTargetEventBridge}))
+	assert.Error(t, validateAlertTarget(Environment{AlertTarget: AlertTargetSNS}))
+	assert.Error(t, validateAlertTarget(Environment{AlertTarget: "email", EventBusName: "bus"}))
+}
+
+type mockEventBridgeClient struct {
+	input  *eventbridge.PutEventsInput
+	output *eventbridge.PutEventsOutput
+	err    error
+}
+
+func (m *mockEventBridgeClient) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	m.input = params
+	return m.output, m.err
+}
+
+type mockSNSClient struct {
+	input *sns.PublishInput
+	err   error
+}
+
+func (m *mockSNSClient) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	m.input = params
+	return &sns.PublishOutput{}, m.err
+}
+
+func TestAlertPublishers(t *testing.T) {
+	newestDate := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	alert := DigestAlert{
+		Bucket:     "test-source-bucket",
+		NewestKey:  "sources/2023/12/01/ffis.org/raw.eml",
+		NewestDate: &newestDate,
+		AgeDays:    40.5,
+		MaxAgeDays: 8,
+		Message:    "No FFIS digest has been archived in the last 8 days",
+	}
+	expectedJSON, err := json.Marshal(alert)
+	require.NoError(t, err)
+
+	t.Run("EventBridge", func(t *testing.T) {
+		client := &mockEventBridgeClient{output: &eventbridge.PutEventsOutput{}}
+		require.NoError(t, (&eventBridgeAlertPublisher{client, "test-event-bus"}).PublishAlert(context.TODO(), alert))
+		require.Len(t, client.input.Entries, 1)
+		entry := client.input.Entries[0]
+		assert.Equal(t, "test-event-bus", aws.ToString(entry.EventBusName))
+		assert.Equal(t, "FFISDigestOverdue", aws.ToString(entry.DetailType))
+		assert.JSONEq(t, string(expectedJSON), aws.ToString(entry.Detail))
+
+		client.output = &eventbridge.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries: []ebTypes.PutEventsResultEntry{{
+				ErrorCode:    aws.String("InternalFailure"),
+				ErrorMessage: aws.String("try again"),
+			}},
+		}
+		assert.ErrorContains(t, (&eventBridgeAlertPublisher{client, "test-event-bus"}).PublishAlert(context.TODO(), alert),
+			"InternalFailure")
+
+		client.err = errors.New("oh no")
+		assert.ErrorContains(t, (&eventBridgeAlertPublisher{client, "test-event-bus"}).PublishAlert(context.TODO(), alert),
+			"oh no")
+	})
+
+	t.Run("SNS", func(t *testing.T) {
+		client := &mockSNSClient{}
+		require.NoError(t, (&snsAlertPublisher{client, "arn:aws:sns:us-west-2:123456789012:alerts"}).PublishAlert(context.TODO(), alert))
+		assert.Equal(t, "arn:aws:sns:us-west-2:123456789012:alerts", aws.ToString(client.input.TopicArn))
+		assert.JSONEq(t, string(expectedJSON), aws.ToString(client.input.Message))
+
+		client.err = errors.New("oh no")
+		assert.ErrorContains(t, (&snsAlertPublisher{client, "arn"}).PublishAlert(context.TODO(), alert), "oh no")
+	})
+}
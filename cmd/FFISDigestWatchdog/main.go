@@ -0,0 +1,112 @@
+// Package main compiles to an AWS Lambda handler binary that, when invoked on a schedule,
+// determines the age of the newest FFIS digest email archived in the S3 bucket named by the
+// GRANTS_SOURCE_DATA_BUCKET_NAME environment variable. The age (in days) is reported as the
+// ffis.digest.age_days metric, and an alert is published (to EventBridge or SNS, according to
+// the ALERT_TARGET environment variable) when no digest has been archived within the last
+// MAX_DIGEST_AGE_DAYS days.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+	"net/http"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+)
+
+const (
+	AlertTargetEventBridge = "eventbridge"
+	AlertTargetSNS         = "sns"
+)
+
+type Environment struct {
+	LogLevel          string `env:"LOG_LEVEL,default=INFO"`
+	SourceBucket      string `env:"GRANTS_SOURCE_DATA_BUCKET_NAME,required=true"`
+	UsePathStyleS3Opt bool   `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider   string `env:"TRACING_PROVIDER,default=datadog"`
+	DigestSubpath     string `env:"FFIS_DESTINATION_SUBPATH,default=ffis.org"`
+	MaxDigestAgeDays  int    `env:"MAX_DIGEST_AGE_DAYS,default=8"`
+	AlertTarget       string `env:"ALERT_TARGET,default=eventbridge"`
+	EventBusName      string `env:"EVENT_BUS_NAME"`
+	AlertSNSTopicARN  string `env:"ALERT_SNS_TOPIC_ARN"`
+	Extras            goenv.EnvSet
+}
+
+var (
+	env        Environment
+	logger     log.Logger
+	sendMetric = ddHelpers.NewMetricSender("FFISDigestWatchdog", "source:ffis.org")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	if err := validateAlertTarget(env); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event ScheduledEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		httptrace.WrapClient(http.DefaultClient)
+		s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = env.UsePathStyleS3Opt
+		})
+		var alerter AlertPublisher
+		switch env.AlertTarget {
+		case AlertTargetSNS:
+			alerter = &snsAlertPublisher{sns.NewFromConfig(cfg), env.AlertSNSTopicARN}
+		default:
+			alerter = &eventBridgeAlertPublisher{eventbridge.NewFromConfig(cfg), env.EventBusName}
+		}
+		return handleEvent(ctx, s3Client, alerter, event)
+	}, nil))
+}
+
+// validateAlertTarget returns an error if the configured ALERT_TARGET is unknown or if the
+// destination for alerts published to that target is not configured.
+func validateAlertTarget(e Environment) error {
+	switch e.AlertTarget {
+	case AlertTargetEventBridge:
+		if e.EventBusName == "" {
+			return fmt.Errorf("EVENT_BUS_NAME is required when ALERT_TARGET is %q", e.AlertTarget)
+		}
+	case AlertTargetSNS:
+		if e.AlertSNSTopicARN == "" {
+			return fmt.Errorf("ALERT_SNS_TOPIC_ARN is required when ALERT_TARGET is %q", e.AlertTarget)
+		}
+	default:
+		return fmt.Errorf("unknown ALERT_TARGET %q: must be %q or %q",
+			e.AlertTarget, AlertTargetEventBridge, AlertTargetSNS)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+// Headers of notification requests. Subscribers verify a notification by computing the
+// HMAC-SHA256 (keyed by the subscription secret) of the timestamp header value, a ".",
+// and the request body, and comparing its hex encoding to the signature header value
+// (which is prefixed by "sha256=").
+const (
+	SignatureHeader = "X-Grants-Ingest-Signature"
+	TimestampHeader = "X-Grants-Ingest-Timestamp"
+	EventIDHeader   = "X-Grants-Ingest-Event-Id"
+)
+
+// ErrDeliveryRejected indicates that a subscriber endpoint responded with a non-2xx status.
+var ErrDeliveryRejected = errors.New("endpoint rejected notification")
+
+// Notification is the JSON payload delivered to subscribers. EventID is the same for every
+// delivery of the same event, so subscribers can ignore redeliveries.
+type Notification struct {
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	EventTime      time.Time  `json:"event_time"`
+	SubscriptionID string     `json:"subscription_id"`
+	Opportunity    usdr.Grant `json:"opportunity"`
+}
+
+// signature returns the value of the signature header for a notification with the given body
+// that is sent at the given (Unix) timestamp.
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs body to the endpoint of sub, retrying failed requests (up to
+// MAX_DELIVERY_ATTEMPTS times in total) with a linearly-increasing backoff.
+// Requests are not retried when the endpoint responds with a client error, other than
+// 408 Request Timeout or 429 Too Many Requests.
+// Returns the HTTP status of the last response (or 0 when no response was received)
+// and the number of attempts made.
+func deliver(ctx context.Context, client *http.Client, sub Subscription, eventID string, body []byte) (int, int, error) {
+	for attempt := 1; ; attempt++ {
+		statusCode, err := post(ctx, client, sub, eventID, body)
+		if err == nil || !isRetryable(statusCode) || attempt >= env.MaxDeliveryAttempts {
+			return statusCode, attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return statusCode, attempt, fmt.Errorf("%w (%w)", err, ctx.Err())
+		case <-time.After(env.DeliveryRetryBackoff * time.Duration(attempt)):
+		}
+	}
+}
+
+// post makes a single signed delivery of body to the endpoint of sub.
+func post(ctx context.Context, client *http.Client, sub Subscription, eventID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.EndpointURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, eventID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, signature(sub.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain (a reasonable amount of) the response so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w: %s", ErrDeliveryRejected, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// isRetryable returns true when a request that resulted in statusCode (or in no response,
+// when statusCode is 0) may succeed if retried.
+func isRetryable(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
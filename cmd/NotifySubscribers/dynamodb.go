@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SubscriptionStore lists subscriptions and records the outcomes of deliveries to them.
+type SubscriptionStore interface {
+	// ListSubscriptions returns every subscription.
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	// RecordDelivery saves outcome as the latest delivery to the subscription with the given ID,
+	// along with the resulting state of the subscription's circuit breaker.
+	RecordDelivery(ctx context.Context, subscriptionID string, outcome DeliveryOutcome) error
+}
+
+type DynamoDBAPI interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// dynamoDBSubscriptionStore is a SubscriptionStore for subscriptions stored in a DynamoDB table
+// keyed by subscription_id.
+type dynamoDBSubscriptionStore struct {
+	client DynamoDBAPI
+	table  string
+}
+
+func (s *dynamoDBSubscriptionStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subscriptions := []Subscription{}
+	input := &dynamodb.ScanInput{TableName: aws.String(s.table), ConsistentRead: aws.Bool(true)}
+	for {
+		output, err := s.client.Scan(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range output.Items {
+			var sub Subscription
+			if err := attributevalue.UnmarshalMap(item, &sub); err != nil {
+				return nil, fmt.Errorf("error decoding subscription: %w", err)
+			}
+			subscriptions = append(subscriptions, sub)
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return subscriptions, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (s *dynamoDBSubscriptionStore) RecordDelivery(ctx context.Context, subscriptionID string, outcome DeliveryOutcome) error {
+	key, err := attributevalue.MarshalMap(map[string]string{"subscription_id": subscriptionID})
+	if err != nil {
+		return err
+	}
+	update := expression.
+		Set(expression.Name("last_delivery"), expression.Value(outcome)).
+		Set(expression.Name("consecutive_failures"), expression.Value(outcome.ConsecutiveFailures))
+	if outcome.BreakerOpenUntil.IsZero() {
+		update = update.Remove(expression.Name("breaker_open_until"))
+	} else {
+		update = update.Set(expression.Name("breaker_open_until"), expression.Value(outcome.BreakerOpenUntil))
+	}
+	// Outcomes are not recorded for subscriptions that were deleted during delivery
+	condition := expression.AttributeExists(expression.Name("subscription_id"))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       key,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+var ErrMissingNewVersion = errors.New("grant modification event has no new version")
+
+// handleEvent notifies every subscriber whose filter matches the opportunity created or updated
+// by the GrantModificationEvent in the detail of event. Events for deleted opportunities are
+// ignored, as are subscriptions whose circuit breaker is open.
+// Subscribers are notified concurrently, and the outcome of each delivery is recorded in store.
+// Delivery failures are not returned as errors, since retrying the event would re-notify the
+// subscribers that were notified successfully; they instead count toward opening the failing
+// subscription's circuit breaker.
+// Events are skipped without side effects while the notify_subscribers feature is disabled.
+func handleEvent(ctx context.Context, store SubscriptionStore, client *http.Client, event events.CloudWatchEvent) error {
+	logger := log.With(logger, "event_id", event.ID, "event_detail_type", event.DetailType)
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureNotifySubscribers, 1) {
+		return nil
+	}
+
+	var modification usdr.GrantModificationEvent
+	if err := json.Unmarshal(event.Detail, &modification); err != nil {
+		return log.Errorf(logger, "error decoding grant modification event", err)
+	}
+	eventType := modification.Type.String()
+	if eventType != usdr.EventTypeCreate && eventType != usdr.EventTypeUpdate {
+		log.Debug(logger, "Ignoring event for opportunity that was not created or updated",
+			"modification_type", eventType)
+		sendMetric("event.ignored", 1)
+		return nil
+	}
+	if modification.Versions.New == nil {
+		return log.Errorf(logger, "error reading grant modification event", ErrMissingNewVersion)
+	}
+	grant := *modification.Versions.New
+	logger = log.With(logger, "grant_id", grant.Opportunity.Id, "modification_type", eventType)
+
+	subscriptions, err := store.ListSubscriptions(ctx)
+	if err != nil {
+		return log.Errorf(logger, "error listing subscriptions", err)
+	}
+
+	now := time.Now()
+	matched, skipped := 0, 0
+	wg := sync.WaitGroup{}
+	for _, sub := range subscriptions {
+		if !sub.Filter.Matches(grant) {
+			continue
+		}
+		matched++
+		if sub.breakerOpen(now) {
+			log.Info(logger, "Skipping notification to subscriber with open circuit breaker",
+				"subscription_id", sub.ID, "breaker_open_until", sub.BreakerOpenUntil)
+			sendMetric("delivery.skipped", 1)
+			skipped++
+			continue
+		}
+		wg.Add(1)
+		go func(sub Subscription) {
+			defer wg.Done()
+			notifySubscriber(ctx, store, client, sub, Notification{
+				EventID:        event.ID,
+				EventType:      eventType,
+				EventTime:      event.Time,
+				SubscriptionID: sub.ID,
+				Opportunity:    grant,
+			})
+		}(sub)
+	}
+	wg.Wait()
+
+	log.Info(logger, "Finished notifying subscribers", "subscriptions", len(subscriptions),
+		"matched", matched, "skipped", skipped)
+	return nil
+}
+
+// notifySubscriber delivers notification to the endpoint of sub and records the outcome.
+// Errors are logged rather than returned, so that they do not affect other subscribers.
+func notifySubscriber(ctx context.Context, store SubscriptionStore, client *http.Client, sub Subscription, notification Notification) {
+	logger := log.With(logger, "subscription_id", sub.ID)
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		log.Error(logger, "Error encoding notification", err)
+		return
+	}
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "subscription.deliver")
+	statusCode, attempts, err := deliver(spanCtx, client, sub, notification.EventID, body)
+	span.SetTag("attempts", attempts)
+	span.SetTag("http.status_code", statusCode)
+	tracing.FinishWithOutcome(span, err)
+
+	outcome := newDeliveryOutcome(sub, time.Now(), statusCode, attempts, err)
+	outcome.EventID = notification.EventID
+	outcome.GrantID = notification.Opportunity.Opportunity.Id
+	if err != nil {
+		log.Warn(logger, "Failed to notify subscriber", "error", err, "status_code", statusCode,
+			"attempts", attempts, "consecutive_failures", outcome.ConsecutiveFailures)
+		sendMetric("delivery.failed", 1)
+		if !outcome.BreakerOpenUntil.IsZero() {
+			log.Warn(logger, "Opened circuit breaker for subscriber",
+				"breaker_open_until", outcome.BreakerOpenUntil)
+			sendMetric("breaker.opened", 1)
+		}
+	} else {
+		log.Info(logger, "Notified subscriber", "status_code", statusCode, "attempts", attempts)
+		sendMetric("delivery.succeeded", 1)
+	}
+
+	if err := store.RecordDelivery(ctx, sub.ID, outcome); err != nil {
+		log.Error(logger, "Error recording delivery outcome", err)
+		sendMetric("outcome.record_failed", 1)
+	}
+}
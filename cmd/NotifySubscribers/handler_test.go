@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+func setupLambdaEnvForTesting(t *testing.T, extras goenv.EnvSet) {
+	t.Helper()
+
+	// Suppress normal lambda log output
+	logger = log.NewNopLogger()
+	sendMetric = func(metric string, value float64, tags ...string) {}
+
+	// Configure environment variables
+	es := goenv.EnvSet{
+		"SUBSCRIPTIONS_DYNAMODB_NAME": "test-subscriptions",
+		"DELIVERY_RETRY_BACKOFF":      "1ms",
+		"BREAKER_FAILURE_THRESHOLD":   "2",
+	}
+	for k, v := range extras {
+		es[k] = v
+	}
+	env = Environment{}
+	err := goenv.Unmarshal(es, &env)
+	require.NoError(t, err, "Error configuring environment variables for testing")
+}
+
+// memSubscriptionStore is an in-memory SubscriptionStore.
+type memSubscriptionStore struct {
+	mu            sync.Mutex
+	subscriptions []Subscription
+	outcomes      map[string][]DeliveryOutcome
+	listErr       error
+	recordErr     error
+}
+
+func newMemSubscriptionStore(subscriptions ...Subscription) *memSubscriptionStore {
+	return &memSubscriptionStore{subscriptions: subscriptions, outcomes: map[string][]DeliveryOutcome{}}
+}
+
+func (s *memSubscriptionStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listErr != nil {
+		return nil, s.listErr
+	}
+	return append([]Subscription{}, s.subscriptions...), nil
+}
+
+func (s *memSubscriptionStore) RecordDelivery(ctx context.Context, subscriptionID string, outcome DeliveryOutcome) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recordErr != nil {
+		return s.recordErr
+	}
+	for i, sub := range s.subscriptions {
+		if sub.ID == subscriptionID {
+			s.subscriptions[i].ConsecutiveFailures = outcome.ConsecutiveFailures
+			s.subscriptions[i].BreakerOpenUntil = outcome.BreakerOpenUntil
+		}
+	}
+	s.outcomes[subscriptionID] = append(s.outcomes[subscriptionID], outcome)
+	return nil
+}
+
+func (s *memSubscriptionStore) subscription(t *testing.T, id string) Subscription {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subscriptions {
+		if sub.ID == id {
+			return sub
+		}
+	}
+	require.FailNow(t, "no such subscription", id)
+	return Subscription{}
+}
+
+// testEndpoint is a subscriber endpoint that verifies the signature of each notification it
+// receives, and responds with the configured status.
+type testEndpoint struct {
+	*httptest.Server
+	mu            sync.Mutex
+	secret        string
+	status        int
+	requests      int
+	notifications []Notification
+	badSignatures int
+}
+
+func newTestEndpoint(t *testing.T, secret string, status int) *testEndpoint {
+	t.Helper()
+	e := &testEndpoint{secret: secret, status: status}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.requests++
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		expected := signature(e.secret, r.Header.Get(TimestampHeader), body)
+		if r.Header.Get(SignatureHeader) != expected || r.Header.Get(TimestampHeader) == "" {
+			e.badSignatures++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var n Notification
+		require.NoError(t, json.Unmarshal(body, &n))
+		assert.Equal(t, n.EventID, r.Header.Get(EventIDHeader))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if e.status >= 200 && e.status <= 299 {
+			e.notifications = append(e.notifications, n)
+		}
+		w.WriteHeader(e.status)
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *testEndpoint) setStatus(status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = status
+}
+
+func (e *testEndpoint) stats() (requests int, notifications []Notification) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requests, append([]Notification{}, e.notifications...)
+}
+
+func newGrant(t *testing.T, id, agencyCode string, cfdaNumbers ...string) usdr.Grant {
+	t.Helper()
+	grant := usdr.Grant{
+		Opportunity: usdr.Opportunity{Id: id, Number: "NUM-" + id, Title: "Opportunity " + id},
+		Agency:      usdr.Agency{Code: agencyCode},
+	}
+	for _, n := range cfdaNumbers {
+		number, err := usdr.NewCFDANumber(n)
+		require.NoError(t, err)
+		grant.CFDANumbers = append(grant.CFDANumbers, number)
+	}
+	return grant
+}
+
+func modificationEvent(t *testing.T, newVersion, prevVersion *usdr.Grant) events.CloudWatchEvent {
+	t.Helper()
+	modification, err := usdr.NewGrantModificationEvent(newVersion, prevVersion)
+	require.NoError(t, err)
+	detail, err := json.Marshal(modification)
+	require.NoError(t, err)
+	return events.CloudWatchEvent{
+		ID:         fmt.Sprintf("event-%s", modification.Type),
+		DetailType: "GrantModificationEvent",
+		Source:     "org.usdigitalresponse.grants-ingest",
+		Time:       time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+		Detail:     detail,
+	}
+}
+
+func TestHandleEventNotifiesMatchingSubscribers(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	byAgency := newTestEndpoint(t, "agency-secret", http.StatusNoContent)
+	byCFDA := newTestEndpoint(t, "cfda-secret", http.StatusOK)
+	byBoth := newTestEndpoint(t, "both-secret", http.StatusOK)
+	unmatched := newTestEndpoint(t, "unmatched-secret", http.StatusOK)
+	store := newMemSubscriptionStore(
+		Subscription{ID: "by-agency", EndpointURL: byAgency.URL, Secret: "agency-secret",
+			Filter: SubscriptionFilter{AgencyCodes: []string{"usda"}}},
+		Subscription{ID: "by-cfda", EndpointURL: byCFDA.URL, Secret: "cfda-secret",
+			Filter: SubscriptionFilter{CFDAPrefixes: []string{"93.", "10.4"}}},
+		Subscription{ID: "by-both", EndpointURL: byBoth.URL, Secret: "both-secret",
+			Filter: SubscriptionFilter{AgencyCodes: []string{"USDA-RUS"}, CFDAPrefixes: []string{"10.8"}}},
+		Subscription{ID: "unmatched", EndpointURL: unmatched.URL, Secret: "unmatched-secret",
+			Filter: SubscriptionFilter{AgencyCodes: []string{"HHS"}}},
+	)
+
+	previous := newGrant(t, "1234", "USDA-RUS", "10.855")
+	grant := newGrant(t, "1234", "USDA-RUS", "10.855", "10.420")
+	grant.Opportunity.Title = "Updated title"
+	event := modificationEvent(t, &grant, &previous)
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient, event))
+
+	for _, tt := range []struct {
+		id       string
+		endpoint *testEndpoint
+		status   int
+	}{
+		{"by-agency", byAgency, http.StatusNoContent},
+		{"by-cfda", byCFDA, http.StatusOK},
+		{"by-both", byBoth, http.StatusOK},
+	} {
+		t.Run(tt.id, func(t *testing.T) {
+			requests, notifications := tt.endpoint.stats()
+			assert.Equal(t, 1, requests)
+			require.Len(t, notifications, 1)
+			assert.Equal(t, Notification{
+				EventID:        event.ID,
+				EventType:      usdr.EventTypeUpdate,
+				EventTime:      event.Time,
+				SubscriptionID: tt.id,
+				Opportunity:    grant,
+			}, notifications[0])
+
+			require.Len(t, store.outcomes[tt.id], 1)
+			outcome := store.outcomes[tt.id][0]
+			assert.True(t, outcome.Succeeded)
+			assert.Equal(t, tt.status, outcome.StatusCode)
+			assert.Equal(t, 1, outcome.Attempts)
+			assert.Equal(t, event.ID, outcome.EventID)
+			assert.Equal(t, "1234", outcome.GrantID)
+			assert.Empty(t, outcome.Error)
+		})
+	}
+	requests, _ := unmatched.stats()
+	assert.Zero(t, requests, "unmatched subscriber should not be notified")
+	assert.Empty(t, store.outcomes["unmatched"])
+}
+
+func TestHandleEventSignaturesUseSubscriptionSecret(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	endpoint := newTestEndpoint(t, "expected-secret", http.StatusOK)
+	store := newMemSubscriptionStore(
+		Subscription{ID: "wrong-secret", EndpointURL: endpoint.URL, Secret: "some-other-secret"})
+
+	grant := newGrant(t, "1234", "USDA-RUS")
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient,
+		modificationEvent(t, &grant, nil)))
+
+	assert.Equal(t, 1, endpoint.badSignatures)
+	require.Len(t, store.outcomes["wrong-secret"], 1)
+	outcome := store.outcomes["wrong-secret"][0]
+	assert.False(t, outcome.Succeeded)
+	assert.Equal(t, http.StatusUnauthorized, outcome.StatusCode)
+	assert.Equal(t, 1, outcome.Attempts, "client errors should not be retried")
+	assert.Contains(t, outcome.Error, ErrDeliveryRejected.Error())
+}
+
+func TestHandleEventFailingEndpointDoesNotBlockOthers(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{"MAX_DELIVERY_ATTEMPTS": "3"})
+	failing := newTestEndpoint(t, "secret", http.StatusServiceUnavailable)
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	healthy := newTestEndpoint(t, "secret", http.StatusOK)
+	store := newMemSubscriptionStore(
+		Subscription{ID: "failing", EndpointURL: failing.URL, Secret: "secret"},
+		Subscription{ID: "unreachable", EndpointURL: unreachable.URL, Secret: "secret"},
+		Subscription{ID: "healthy", EndpointURL: healthy.URL, Secret: "secret"},
+	)
+
+	grant := newGrant(t, "1234", "USDA-RUS")
+	err := handleEvent(context.Background(), store, http.DefaultClient, modificationEvent(t, &grant, nil))
+	require.NoError(t, err, "delivery failures should not fail the invocation")
+
+	_, notifications := healthy.stats()
+	assert.Len(t, notifications, 1)
+	requests, _ := failing.stats()
+	assert.Equal(t, 3, requests, "server errors should be retried")
+
+	for _, id := range []string{"failing", "unreachable"} {
+		require.Len(t, store.outcomes[id], 1)
+		outcome := store.outcomes[id][0]
+		assert.False(t, outcome.Succeeded)
+		assert.Equal(t, 3, outcome.Attempts)
+		assert.NotEmpty(t, outcome.Error)
+		assert.Equal(t, 1, outcome.ConsecutiveFailures)
+		assert.True(t, outcome.BreakerOpenUntil.IsZero(), "breaker should not open before threshold")
+	}
+	assert.Equal(t, 0, store.outcomes["unreachable"][0].StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, store.outcomes["failing"][0].StatusCode)
+	require.Len(t, store.outcomes["healthy"], 1)
+	assert.True(t, store.outcomes["healthy"][0].Succeeded)
+}
+
+func TestHandleEventCircuitBreaker(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{
+		"MAX_DELIVERY_ATTEMPTS":     "2",
+		"BREAKER_FAILURE_THRESHOLD": "3",
+		"BREAKER_COOLDOWN":          "1h",
+	})
+	endpoint := newTestEndpoint(t, "secret", http.StatusInternalServerError)
+	store := newMemSubscriptionStore(
+		Subscription{ID: "flaky", EndpointURL: endpoint.URL, Secret: "secret"})
+	grant := newGrant(t, "1234", "USDA-RUS")
+	event := modificationEvent(t, &grant, nil)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient, event))
+		assert.Equal(t, i, store.subscription(t, "flaky").ConsecutiveFailures)
+	}
+	requests, _ := endpoint.stats()
+	assert.Equal(t, 6, requests)
+	openUntil := store.subscription(t, "flaky").BreakerOpenUntil
+	assert.WithinDuration(t, time.Now().Add(time.Hour), openUntil, time.Minute,
+		"breaker should open after repeated failures")
+
+	// Deliveries are skipped while the breaker is open
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient, event))
+	requests, _ = endpoint.stats()
+	assert.Equal(t, 6, requests, "no requests should be made while the breaker is open")
+	assert.Len(t, store.outcomes["flaky"], 3, "skipped deliveries should not be recorded")
+
+	// After the cooldown, a failed delivery reopens the breaker
+	store.subscriptions[0].BreakerOpenUntil = time.Now().Add(-time.Second)
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient, event))
+	requests, _ = endpoint.stats()
+	assert.Equal(t, 8, requests)
+	assert.Equal(t, 4, store.subscription(t, "flaky").ConsecutiveFailures)
+	assert.True(t, store.subscription(t, "flaky").breakerOpen(time.Now()))
+
+	// After the cooldown, a successful delivery closes the breaker
+	store.subscriptions[0].BreakerOpenUntil = time.Now().Add(-time.Second)
+	endpoint.setStatus(http.StatusOK)
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient, event))
+	_, notifications := endpoint.stats()
+	assert.Len(t, notifications, 1)
+	sub := store.subscription(t, "flaky")
+	assert.Zero(t, sub.ConsecutiveFailures)
+	assert.True(t, sub.BreakerOpenUntil.IsZero())
+	assert.False(t, sub.breakerOpen(time.Now()))
+}
+
+func TestHandleEventIgnoresDeletedOpportunities(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	endpoint := newTestEndpoint(t, "secret", http.StatusOK)
+	store := newMemSubscriptionStore(
+		Subscription{ID: "all", EndpointURL: endpoint.URL, Secret: "secret"})
+
+	grant := newGrant(t, "1234", "USDA-RUS")
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient,
+		modificationEvent(t, nil, &grant)))
+	requests, _ := endpoint.stats()
+	assert.Zero(t, requests)
+	assert.Empty(t, store.outcomes)
+}
+
+func TestHandleEventFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureNotifySubscribers: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	endpoint := newTestEndpoint(t, "secret", http.StatusOK)
+	store := newMemSubscriptionStore(
+		Subscription{ID: "all", EndpointURL: endpoint.URL, Secret: "secret"})
+	store.listErr = errors.New("subscriptions should not be listed while disabled")
+
+	grant := newGrant(t, "1234", "USDA-RUS")
+	require.NoError(t, handleEvent(context.Background(), store, http.DefaultClient,
+		modificationEvent(t, &grant, nil)))
+	requests, _ := endpoint.stats()
+	assert.Zero(t, requests, "Subscribers should not be notified while disabled")
+	assert.Empty(t, store.outcomes)
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
+func TestHandleEventErrors(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	grant := newGrant(t, "1234", "USDA-RUS")
+
+	t.Run("invalid event detail", func(t *testing.T) {
+		event := events.CloudWatchEvent{ID: "bad", Detail: json.RawMessage(`"not an object"`)}
+		assert.Error(t, handleEvent(context.Background(), newMemSubscriptionStore(), http.DefaultClient, event))
+	})
+
+	t.Run("missing new version", func(t *testing.T) {
+		event := events.CloudWatchEvent{ID: "bad", Detail: json.RawMessage(`{"type":"create","versions":{}}`)}
+		err := handleEvent(context.Background(), newMemSubscriptionStore(), http.DefaultClient, event)
+		assert.ErrorIs(t, err, ErrMissingNewVersion)
+	})
+
+	t.Run("error listing subscriptions", func(t *testing.T) {
+		store := newMemSubscriptionStore()
+		store.listErr = errors.New("oh no")
+		err := handleEvent(context.Background(), store, http.DefaultClient, modificationEvent(t, &grant, nil))
+		assert.ErrorIs(t, err, store.listErr)
+	})
+
+	t.Run("error recording outcome", func(t *testing.T) {
+		endpoint := newTestEndpoint(t, "secret", http.StatusOK)
+		store := newMemSubscriptionStore(
+			Subscription{ID: "all", EndpointURL: endpoint.URL, Secret: "secret"})
+		store.recordErr = errors.New("oh no")
+		err := handleEvent(context.Background(), store, http.DefaultClient, modificationEvent(t, &grant, nil))
+		assert.NoError(t, err, "errors recording outcomes should not fail the invocation")
+		_, notifications := endpoint.stats()
+		assert.Len(t, notifications, 1)
+	})
+}
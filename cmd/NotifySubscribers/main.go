@@ -0,0 +1,88 @@
+// Package main compiles to an AWS Lambda handler binary that pushes notifications about new and
+// changed grant opportunities to external subscribers. It is invoked by an EventBridge rule for
+// each GrantModificationEvent that describes a created or updated opportunity, and POSTs a signed
+// JSON payload describing the opportunity to the endpoint of every subscription (stored in the
+// DynamoDB table named by the SUBSCRIPTIONS_DYNAMODB_NAME environment variable) whose filter
+// matches the opportunity. The outcome of each delivery is recorded on the subscription.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+	"net/http"
+	"time"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+type Environment struct {
+	LogLevel                string        `env:"LOG_LEVEL,default=INFO"`
+	SubscriptionsTable      string        `env:"SUBSCRIPTIONS_DYNAMODB_NAME,required=true"`
+	DeliveryTimeout         time.Duration `env:"DELIVERY_TIMEOUT,default=10s"`
+	MaxDeliveryAttempts     int           `env:"MAX_DELIVERY_ATTEMPTS,default=3"`
+	DeliveryRetryBackoff    time.Duration `env:"DELIVERY_RETRY_BACKOFF,default=1s"`
+	BreakerFailureThreshold int           `env:"BREAKER_FAILURE_THRESHOLD,default=5"`
+	BreakerCooldown         time.Duration `env:"BREAKER_COOLDOWN,default=15m"`
+	TracingProvider         string        `env:"TRACING_PROVIDER,default=datadog"`
+	DisabledFeatures        string        `env:"DISABLED_FEATURES"`
+	Extras                  goenv.EnvSet
+}
+
+var (
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("NotifySubscribers")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if env.MaxDeliveryAttempts < 1 {
+		goLog.Fatalf("error configuring environment variables: MAX_DELIVERY_ATTEMPTS must be positive")
+	}
+	if env.BreakerFailureThreshold < 1 {
+		goLog.Fatalf("error configuring environment variables: BREAKER_FAILURE_THRESHOLD must be positive")
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	httpClient := &http.Client{Timeout: env.DeliveryTimeout}
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event events.CloudWatchEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		dynamodbSvc := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {})
+		store := &dynamoDBSubscriptionStore{client: dynamodbSvc, table: env.SubscriptionsTable}
+		return handleEvent(ctx, store, httpClient, event)
+	}, nil))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+// Subscription is an external system's request to be notified about opportunities that match
+// its Filter. Subscriptions also track the recent delivery failures of their endpoint, which
+// drive the subscription's circuit breaker.
+type Subscription struct {
+	ID          string             `dynamodbav:"subscription_id"`
+	EndpointURL string             `dynamodbav:"endpoint_url"`
+	Secret      string             `dynamodbav:"secret"`
+	Filter      SubscriptionFilter `dynamodbav:"filter"`
+	// ConsecutiveFailures is the number of deliveries that have failed since the last success.
+	ConsecutiveFailures int `dynamodbav:"consecutive_failures"`
+	// BreakerOpenUntil is the time until which deliveries to the endpoint are suspended.
+	BreakerOpenUntil time.Time `dynamodbav:"breaker_open_until"`
+}
+
+// SubscriptionFilter describes the opportunities of interest to a subscriber. An opportunity
+// matches when it satisfies every criterion that is configured; a filter without any criteria
+// matches every opportunity.
+type SubscriptionFilter struct {
+	// AgencyCodes matches opportunities of the given agencies (case-insensitive). A code also
+	// matches its sub-agencies, e.g. "USDA" matches opportunities of "USDA-RUS".
+	AgencyCodes []string `dynamodbav:"agency_codes"`
+	// CFDAPrefixes matches opportunities with any CFDA number beginning with one of the given
+	// prefixes, e.g. "10." or "93.6".
+	CFDAPrefixes []string `dynamodbav:"cfda_prefixes"`
+}
+
+// Matches returns true when grant satisfies the filter.
+func (f SubscriptionFilter) Matches(grant usdr.Grant) bool {
+	return f.matchesAgency(grant.Agency.Code) && f.matchesCFDANumber(grant)
+}
+
+func (f SubscriptionFilter) matchesAgency(code string) bool {
+	if len(f.AgencyCodes) == 0 {
+		return true
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, want := range f.AgencyCodes {
+		want = strings.ToUpper(strings.TrimSpace(want))
+		if want != "" && (code == want || strings.HasPrefix(code, want+"-")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f SubscriptionFilter) matchesCFDANumber(grant usdr.Grant) bool {
+	if len(f.CFDAPrefixes) == 0 {
+		return true
+	}
+	for _, number := range grant.CFDANumbers {
+		for _, prefix := range f.CFDAPrefixes {
+			if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(string(number), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// breakerOpen returns true when deliveries to the subscription are suspended at the given time.
+// Once BreakerOpenUntil has passed, the breaker is half-open: the next delivery is attempted,
+// and it closes the breaker when it succeeds or reopens it when it fails.
+func (s Subscription) breakerOpen(at time.Time) bool {
+	return at.Before(s.BreakerOpenUntil)
+}
+
+// DeliveryOutcome describes the result of notifying a subscriber about an event, along with the
+// resulting state of the subscription's circuit breaker.
+type DeliveryOutcome struct {
+	EventID             string    `dynamodbav:"event_id"`
+	GrantID             string    `dynamodbav:"grant_id"`
+	DeliveredAt         time.Time `dynamodbav:"delivered_at"`
+	Succeeded           bool      `dynamodbav:"succeeded"`
+	StatusCode          int       `dynamodbav:"status_code,omitempty"`
+	Attempts            int       `dynamodbav:"attempts"`
+	Error               string    `dynamodbav:"error,omitempty"`
+	ConsecutiveFailures int       `dynamodbav:"-"`
+	BreakerOpenUntil    time.Time `dynamodbav:"-"`
+}
+
+// newDeliveryOutcome returns the outcome of a delivery to sub that finished at the given time,
+// after the given number of attempts. When the delivery failed, the failure is counted against
+// sub and its breaker is opened for BREAKER_COOLDOWN once BREAKER_FAILURE_THRESHOLD consecutive
+// deliveries have failed.
+func newDeliveryOutcome(sub Subscription, at time.Time, statusCode, attempts int, err error) DeliveryOutcome {
+	outcome := DeliveryOutcome{
+		DeliveredAt: at.UTC(),
+		Succeeded:   err == nil,
+		StatusCode:  statusCode,
+		Attempts:    attempts,
+	}
+	if err != nil {
+		outcome.Error = err.Error()
+		outcome.ConsecutiveFailures = sub.ConsecutiveFailures + 1
+		if outcome.ConsecutiveFailures >= env.BreakerFailureThreshold {
+			outcome.BreakerOpenUntil = at.Add(env.BreakerCooldown).UTC()
+		}
+	}
+	return outcome
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionFilterMatches(t *testing.T) {
+	grant := newGrant(t, "1234", "USDA-RUS", "10.855", "93.600")
+
+	for _, tt := range []struct {
+		name     string
+		filter   SubscriptionFilter
+		expMatch bool
+	}{
+		{"empty filter", SubscriptionFilter{}, true},
+		{"exact agency", SubscriptionFilter{AgencyCodes: []string{"USDA-RUS"}}, true},
+		{"parent agency", SubscriptionFilter{AgencyCodes: []string{"USDA"}}, true},
+		{"agency is case-insensitive", SubscriptionFilter{AgencyCodes: []string{" usda-rus "}}, true},
+		{"any of several agencies", SubscriptionFilter{AgencyCodes: []string{"HHS", "USDA"}}, true},
+		{"other agency", SubscriptionFilter{AgencyCodes: []string{"HHS"}}, false},
+		{"agency prefix is not a parent agency", SubscriptionFilter{AgencyCodes: []string{"USD"}}, false},
+		{"sub-agency of grant agency", SubscriptionFilter{AgencyCodes: []string{"USDA-RUS-X"}}, false},
+		{"blank agency", SubscriptionFilter{AgencyCodes: []string{""}}, false},
+		{"CFDA prefix of first number", SubscriptionFilter{CFDAPrefixes: []string{"10."}}, true},
+		{"CFDA prefix of second number", SubscriptionFilter{CFDAPrefixes: []string{"93.6"}}, true},
+		{"exact CFDA number", SubscriptionFilter{CFDAPrefixes: []string{"10.855"}}, true},
+		{"other CFDA prefix", SubscriptionFilter{CFDAPrefixes: []string{"11."}}, false},
+		{"blank CFDA prefix", SubscriptionFilter{CFDAPrefixes: []string{" "}}, false},
+		{"agency and CFDA prefix", SubscriptionFilter{
+			AgencyCodes: []string{"USDA"}, CFDAPrefixes: []string{"93."}}, true},
+		{"agency but not CFDA prefix", SubscriptionFilter{
+			AgencyCodes: []string{"USDA"}, CFDAPrefixes: []string{"11."}}, false},
+		{"CFDA prefix but not agency", SubscriptionFilter{
+			AgencyCodes: []string{"HHS"}, CFDAPrefixes: []string{"93."}}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expMatch, tt.filter.Matches(grant))
+		})
+	}
+
+	t.Run("CFDA prefix requires CFDA numbers", func(t *testing.T) {
+		filter := SubscriptionFilter{CFDAPrefixes: []string{"10."}}
+		assert.False(t, filter.Matches(newGrant(t, "5678", "USDA-RUS")))
+	})
+}
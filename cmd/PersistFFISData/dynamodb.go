@@ -20,35 +20,81 @@ type DynamoDBUpdateItemAPI interface {
 
 type opportunity ffis.FFISFundingOpportunity
 
-func UpdateOpportunity(ctx context.Context, c DynamoDBUpdateItemAPI, table string, opp opportunity) error {
+// UpdateOpportunity merges the FFIS-owned attributes of opp into the DynamoDB item of the same
+// grants.gov opportunity, and returns the resulting item.
+func UpdateOpportunity(ctx context.Context, c DynamoDBUpdateItemAPI, table string, opp opportunity) (map[string]types.AttributeValue, error) {
 	key, err := buildKey(opp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	oppAttr, err := attributevalue.MarshalMap(map[string]interface{}{"Bill": opp.Bill})
+	expr, err := buildUpdateExpression(opp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	condition, _ := awsHelpers.DDBIfAnyValueChangedCondition(oppAttr)
 
-	update := expression.Set(expression.Name("Bill"), expression.Value(oppAttr["Bill"]))
-	update = awsHelpers.DDBSetRevisionForUpdate(update)
-
-	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
-	if err != nil {
-		return err
-	}
-
-	_, err = c.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	output, err := c.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName:                 aws.String(table),
 		Key:                       key,
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
 		ConditionExpression:       expr.Condition(),
-		ReturnValues:              types.ReturnValueNone,
+		ReturnValues:              types.ReturnValueAllNew,
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return output.Attributes, nil
+}
+
+// ffisAttributes returns the FFIS-owned attributes of opp, keyed by their namespaced names.
+// The due date is omitted when opp does not provide one.
+func ffisAttributes(o opportunity) map[string]interface{} {
+	attrs := map[string]interface{}{
+		ffis.DynamoDBAttributeBill: o.Bill,
+		ffis.DynamoDBAttributeEligibility: map[string]bool{
+			"higher_education": o.Eligibility.HigherEducation,
+			"local":            o.Eligibility.Local,
+			"non_profits":      o.Eligibility.NonProfits,
+			"other":            o.Eligibility.Other,
+			"state":            o.Eligibility.State,
+			"tribal":           o.Eligibility.Tribal,
+		},
+		ffis.DynamoDBAttributeRollingDueDate: o.RollingDueDate,
+	}
+	if !o.DueDate.IsZero() {
+		attrs[ffis.DynamoDBAttributeDueDate] = o.DueDate.Format(ffis.DynamoDBDueDateLayout)
+	}
+	if o.OppNumber != "" {
+		attrs[ffis.DynamoDBAttributeOppNumber] = o.OppNumber
+	}
+	return attrs
+}
+
+func buildUpdateExpression(o opportunity) (expression.Expression, error) {
+	oppAttr, err := attributevalue.MarshalMap(ffisAttributes(o))
+	if err != nil {
+		return expression.Expression{}, err
+	}
+
+	update := expression.UpdateBuilder{}
+	for k, v := range oppAttr {
+		update = update.Set(expression.Name(k), expression.Value(v))
+	}
+	if _, ok := oppAttr[ffis.DynamoDBAttributeDueDate]; !ok {
+		update = update.Remove(expression.Name(ffis.DynamoDBAttributeDueDate))
+	}
+	// FFIS bills were previously saved to an un-namespaced attribute
+	update = update.Remove(expression.Name("Bill"))
+	update = awsHelpers.DDBAddSourceForUpdate(update, ffis.DynamoDBSource)
+	update = awsHelpers.DDBSetRevisionForUpdate(update)
+	update = awsHelpers.DDBIncrementVersionForUpdate(update)
+	condition, err := awsHelpers.DDBIfAnyValueChangedCondition(oppAttr)
+	if err != nil {
+		return expression.Expression{}, err
+	}
+
+	return expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
 }
 
 func buildKey(o opportunity) (map[string]types.AttributeValue, error) {
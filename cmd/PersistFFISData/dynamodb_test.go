@@ -5,19 +5,25 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 )
 
 type mockDynamoDBUpdateItemAPI struct {
 	expectedError error
+	attributes    map[string]types.AttributeValue
 	params        *dynamodb.UpdateItemInput
 }
 
 func (m *mockDynamoDBUpdateItemAPI) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
 	m.params = params
-	return &dynamodb.UpdateItemOutput{}, m.expectedError
+	return &dynamodb.UpdateItemOutput{Attributes: m.attributes}, m.expectedError
 }
 
 func TestUpsertDynamoDB(t *testing.T) {
@@ -38,7 +44,7 @@ func TestUpsertDynamoDB(t *testing.T) {
 				Bill:    test.bill,
 			}
 			mock := mockDynamoDBUpdateItemAPI{expectedError: test.expectedError}
-			result := UpdateOpportunity(context.TODO(), &mock, tableName, opp)
+			_, result := UpdateOpportunity(context.TODO(), &mock, tableName, opp)
 
 			if result != test.expectedError {
 				t.Errorf("Expected error %v, got %v", test.expectedError, result)
@@ -58,13 +64,62 @@ func TestUpsertDynamoDB(t *testing.T) {
 			if !checkMapContainsValue(t, values, test.bill) {
 				t.Error("Missing bill value in update attribute values")
 			}
-			if !checkMapContainsValue(t, passedParams.ExpressionAttributeNames, "revision") {
-				t.Errorf("Missing attribute %q in update attribute names", "revision")
+			for _, name := range []string{"revision", "version", "sources", "ffis_bill", "ffis_eligibility"} {
+				if !checkMapContainsValue(t, passedParams.ExpressionAttributeNames, name) {
+					t.Errorf("Missing attribute %q in update attribute names", name)
+				}
 			}
 		})
 	}
 }
 
+func TestBuildUpdateExpressionNamespacesFFISAttributes(t *testing.T) {
+	opp := opportunity{
+		GrantID:        123,
+		Bill:           "HR 1234",
+		OppNumber:      "USDA-FS-2020-01",
+		DueDate:        time.Date(2023, 5, 11, 0, 0, 0, 0, time.UTC),
+		RollingDueDate: false,
+		Eligibility:    ffis.FFISFundingEligibility{State: true},
+	}
+	expr, err := buildUpdateExpression(opp)
+	require.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, name := range expr.Names() {
+		names[name] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"ffis_bill": true, "ffis_eligibility": true, "ffis_due_date": true,
+		"ffis_rolling_due_date": true, "ffis_opportunity_number": true,
+		"Bill": true, "sources": true, "revision": true, "version": true,
+	}, names, "FFIS data should only write namespaced attributes (and remove the legacy Bill)")
+	assert.Contains(t, *expr.Update(), "REMOVE")
+	assert.Contains(t, *expr.Update(), "ADD")
+
+	var values []types.AttributeValue
+	for _, v := range expr.Values() {
+		values = append(values, v)
+	}
+	assert.Contains(t, values, &types.AttributeValueMemberSS{Value: []string{"ffis.org"}})
+	assert.Contains(t, values, &types.AttributeValueMemberS{Value: "2023-05-11"})
+
+	t.Run("missing due date is removed", func(t *testing.T) {
+		opp.DueDate = time.Time{}
+		opp.RollingDueDate = true
+		expr, err := buildUpdateExpression(opp)
+		require.NoError(t, err)
+		var removed bool
+		for placeholder, name := range expr.Names() {
+			if name == "ffis_due_date" {
+				removed = true
+				assert.NotContains(t, *expr.Condition(), placeholder+" ")
+			}
+		}
+		assert.True(t, removed, "Stale FFIS due date should be removed")
+	})
+}
+
 // checkMapContainsValue is a testing helper function that returns true if target is a value of m
 func checkMapContainsValue[K comparable, V comparable](t *testing.T, m map[K]V, target V) bool {
 	t.Helper()
@@ -7,9 +7,11 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 )
@@ -26,7 +28,12 @@ var (
 	ErrMissingGrantID = fmt.Errorf("grant id missing from FFIS data")
 )
 
+// handleS3Event persists the FFIS opportunity data referenced by the S3 event to DynamoDB.
+// Events are skipped without side effects while the persist feature is disabled.
 func handleS3Event(ctx context.Context, s3Event events.S3Event, s3client S3API, dbapi DynamoDBUpdateItemAPI) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeaturePersist, len(s3Event.Records)) {
+		return nil
+	}
 	uploadedFile := s3Event.Records[0].S3.Object.Key
 	bucket := s3Event.Records[0].S3.Bucket.Name
 	logger := log.With(logger, "source_key", uploadedFile, "source_bucket", bucket)
@@ -38,7 +45,8 @@ func handleS3Event(ctx context.Context, s3Event events.S3Event, s3client S3API,
 		return err
 	}
 
-	if err := UpdateOpportunity(ctx, dbapi, env.DestinationTable, opportunity(ffisData)); err != nil {
+	item, err := UpdateOpportunity(ctx, dbapi, env.DestinationTable, opportunity(ffisData))
+	if err != nil {
 		var conditionalCheckErr *types.ConditionalCheckFailedException
 		if errors.As(err, &conditionalCheckErr) {
 			log.Warn(logger, "FFIS data already matches the target DynamoDB item",
@@ -50,9 +58,28 @@ func handleS3Event(ctx context.Context, s3Event events.S3Event, s3client S3API,
 	}
 
 	sendMetric("opportunity.saved", 1)
+	checkDueDateConflict(logger, item)
 	return nil
 }
 
+// checkDueDateConflict reports when the FFIS due date of the given DynamoDB item disagrees with
+// the close date provided by grants.gov. Both dates are kept on the item as-is.
+func checkDueDateConflict(logger log.Logger, item map[string]types.AttributeValue) {
+	var dates struct {
+		FFISDueDate        string `dynamodbav:"ffis_due_date"`
+		GrantsGovCloseDate string `dynamodbav:"CloseDate"`
+	}
+	if err := attributevalue.UnmarshalMap(item, &dates); err != nil {
+		log.Warn(logger, "Could not check for conflicting close dates", "error", err)
+		return
+	}
+	if ffis.DueDateConflicts(dates.FFISDueDate, dates.GrantsGovCloseDate) {
+		log.Warn(logger, "FFIS due date conflicts with grants.gov close date",
+			"ffis_due_date", dates.FFISDueDate, "grants_gov_close_date", dates.GrantsGovCloseDate)
+		sendMetric("opportunity.close_date_conflict", 1)
+	}
+}
+
 func parseFFISData(ctx context.Context, bucket string, uploadedFile string, s3client S3API) (ffis.FFISFundingOpportunity, error) {
 	var ffisData ffis.FFISFundingOpportunity
 
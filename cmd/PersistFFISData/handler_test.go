@@ -16,15 +16,18 @@ import (
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 )
 
 type MockS3 struct {
-	content string
+	content        string
+	getObjectCalls int
 }
 
 func (mocks3 *MockS3) GetObject(ctx context.Context,
 	params *s3.GetObjectInput,
 	optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	mocks3.getObjectCalls++
 	contentBytes := []byte(mocks3.content)
 	return &s3.GetObjectOutput{
 		Body:          io.NopCloser(bytes.NewReader(contentBytes)),
@@ -71,6 +74,31 @@ func TestInvocationErrorsUpdatingOpportunity(t *testing.T) {
 
 }
 
+func TestHandleS3EventFeatureDisabled(t *testing.T) {
+	logger = log.NewNopLogger()
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeaturePersist: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	content, err := os.ReadFile("./fixtures/standard.json")
+	require.NoError(t, err)
+	mockS3 := getMockClients()
+	mockS3.content = string(content)
+	mockDB := &mockDynamoDBUpdateItemAPI{}
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "does/not/matter"},
+	}}}}
+
+	require.NoError(t, handleS3Event(context.Background(), s3Event, mockS3, mockDB))
+	assert.Equal(t, 0, mockS3.getObjectCalls, "S3 should not be called while disabled")
+	assert.Nil(t, mockDB.params, "DynamoDB should not be called while disabled")
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
 func TestParseFFISData(t *testing.T) {
 	logger = log.NewNopLogger()
 	var tests = []struct {
@@ -119,3 +147,47 @@ func getMockClients() *MockS3 {
 	mocks3 := MockS3{content: "test"}
 	return &mocks3
 }
+
+func TestHandleS3EventDueDateConflict(t *testing.T) {
+	logger = log.NewNopLogger()
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	mockS3 := getMockClients()
+	mockS3.content = `{"grant_id": 123, "bill": "HR 1234", "due_date": "2023-05-11T00:00:00Z"}`
+	s3Event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "123/123/ffis.org/v1.json"},
+	}}}}
+
+	for _, tt := range []struct {
+		name             string
+		item             map[string]types.AttributeValue // Returned by DynamoDB after merging FFIS data
+		expectedConflict float64
+	}{
+		{"no grants.gov data yet", map[string]types.AttributeValue{
+			"ffis_due_date": &types.AttributeValueMemberS{Value: "2023-05-11"},
+			"sources":       &types.AttributeValueMemberSS{Value: []string{"ffis.org"}},
+		}, 0},
+		{"grants.gov close date agrees", map[string]types.AttributeValue{
+			"CloseDate":     &types.AttributeValueMemberS{Value: "05112023"},
+			"ffis_due_date": &types.AttributeValueMemberS{Value: "2023-05-11"},
+			"sources":       &types.AttributeValueMemberSS{Value: []string{"ffis.org", "grants.gov"}},
+		}, 0},
+		{"grants.gov close date conflicts", map[string]types.AttributeValue{
+			"CloseDate":     &types.AttributeValueMemberS{Value: "06012023"},
+			"ffis_due_date": &types.AttributeValueMemberS{Value: "2023-05-11"},
+			"sources":       &types.AttributeValueMemberSS{Value: []string{"ffis.org", "grants.gov"}},
+		}, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			delete(sentMetrics, "opportunity.close_date_conflict")
+			err := handleS3Event(context.Background(), s3Event, mockS3,
+				&mockDynamoDBUpdateItemAPI{attributes: tt.item})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedConflict, sentMetrics["opportunity.close_date_conflict"])
+		})
+	}
+}
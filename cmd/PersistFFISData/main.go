@@ -16,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
@@ -25,13 +26,15 @@ type Environment struct {
 	LogLevel          string `env:"LOG_LEVEL,default=INFO"`
 	DestinationTable  string `env:"GRANTS_PREPARED_DYNAMODB_NAME,required=true"`
 	UsePathStyleS3Opt bool   `env:"S3_USE_PATH_STYLE,default=false"`
+	DisabledFeatures  string `env:"DISABLED_FEATURES"`
 	Extras            goenv.EnvSet
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("PersistFFISData", "source:ffis.org")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("PersistFFISData", "source:ffis.org")
 )
 
 func main() {
@@ -41,11 +44,20 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
 
 	log.Info(logger, "Starting PersistFFISData")
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, s3Event events.S3Event) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
 		cfg, err := awsHelpers.GetConfig(ctx)
 		if err != nil {
 			return fmt.Errorf("could not create AWS SDK config: %w", err)
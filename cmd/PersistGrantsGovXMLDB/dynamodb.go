@@ -9,31 +9,37 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
 )
 
 type DynamoDBUpdateItemAPI interface {
 	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
 }
 
-func UpdateDynamoDBItem(ctx context.Context, c DynamoDBUpdateItemAPI, table string, opp opportunity) error {
+// UpdateDynamoDBItem saves opp to its DynamoDB item (which may also hold data merged from FFIS)
+// and returns the resulting item.
+func UpdateDynamoDBItem(ctx context.Context, c DynamoDBUpdateItemAPI, table string, opp opportunity) (map[string]types.AttributeValue, error) {
 	key, err := buildKey(opp)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	expr, err := buildUpdateExpression(opp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = c.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+	output, err := c.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName:                 aws.String(table),
 		Key:                       key,
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
 		ConditionExpression:       expr.Condition(),
-		ReturnValues:              types.ReturnValueUpdatedNew,
+		ReturnValues:              types.ReturnValueAllNew,
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return output.Attributes, nil
 }
 
 func buildKey(o opportunity) (map[string]types.AttributeValue, error) {
@@ -47,12 +53,19 @@ func buildUpdateExpression(o opportunity) (expression.Expression, error) {
 	if err != nil {
 		return expression.Expression{}, err
 	}
+	if len(o.CFDANumbers) > 0 {
+		oppAttr[grantsgov.DynamoDBAttributePrimaryCFDANumber] = &types.AttributeValueMemberS{
+			Value: string(o.CFDANumbers[0]),
+		}
+	}
 
 	update := expression.UpdateBuilder{}
 	for k, v := range oppAttr {
 		update = update.Set(expression.Name(k), expression.Value(v))
 	}
+	update = awsHelpers.DDBAddSourceForUpdate(update, grantsgov.DynamoDBSource)
 	update = awsHelpers.DDBSetRevisionForUpdate(update)
+	update = awsHelpers.DDBIncrementVersionForUpdate(update)
 	condition, err := awsHelpers.DDBIfAnyValueChangedCondition(oppAttr)
 	if err != nil {
 		return expression.Expression{}, err
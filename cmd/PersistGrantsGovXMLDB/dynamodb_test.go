@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
@@ -65,7 +66,7 @@ func TestUploadDynamoDBItem(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			err := UpdateDynamoDBItem(context.TODO(), tt.client(t), testTableName, testOpportunity)
+			_, err := UpdateDynamoDBItem(context.TODO(), tt.client(t), testTableName, testOpportunity)
 			if tt.expErr != nil {
 				assert.EqualError(t, err, tt.expErr.Error())
 			} else {
@@ -74,3 +75,40 @@ func TestUploadDynamoDBItem(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildUpdateExpressionPrimaryCFDANumber(t *testing.T) {
+	hasName := func(expr expression.Expression, name string) bool {
+		for _, n := range expr.Names() {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("first CFDA number is set", func(t *testing.T) {
+		expr, err := buildUpdateExpression(opportunity{
+			OpportunityID: "123456",
+			CFDANumbers:   []grantsgov.CFDANumberType{"93.243", "93.110"},
+		})
+		assert.NoError(t, err)
+		assert.True(t, hasName(expr, grantsgov.DynamoDBAttributePrimaryCFDANumber))
+		assert.NotEmpty(t, findValuePlaceholder(expr, "93.243"))
+	})
+
+	t.Run("not set without CFDA numbers", func(t *testing.T) {
+		expr, err := buildUpdateExpression(opportunity{OpportunityID: "123456"})
+		assert.NoError(t, err)
+		assert.False(t, hasName(expr, grantsgov.DynamoDBAttributePrimaryCFDANumber))
+	})
+}
+
+// findValuePlaceholder returns the placeholder of the string value s in expr, if any.
+func findValuePlaceholder(expr expression.Expression, s string) string {
+	for placeholder, v := range expr.Values() {
+		if sv, ok := v.(*types.AttributeValueMemberS); ok && sv.Value == s {
+			return placeholder
+		}
+	}
+	return ""
+}
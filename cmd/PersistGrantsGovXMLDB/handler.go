@@ -8,12 +8,15 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/hashicorp/go-multierror"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 const (
@@ -31,13 +34,17 @@ type opportunity grantsgov.OpportunitySynopsisDetail_1_0
 // a partial or complete invocation failure.
 // Returns nil when all grant opportunities are successfully processed from all source records,
 // indicating complete success.
+// Events are skipped without side effects while the persist feature is disabled.
 func handleS3EventWithConfig(s3svc *s3.Client, dynamodbsvc DynamoDBUpdateItemAPI, ctx context.Context, s3Event events.S3Event) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeaturePersist, len(s3Event.Records)) {
+		return nil
+	}
 	wg := multierror.Group{}
 	for _, record := range s3Event.Records {
 		func(record events.S3EventRecord) {
 			wg.Go(func() (err error) {
-				span, ctx := tracer.StartSpanFromContext(ctx, "handle.record")
-				defer span.Finish(tracer.WithError(err))
+				span, ctx := tracing.StartSpanFromContext(ctx, "handle.record")
+				defer func() { span.Finish(tracing.WithError(err)) }()
 				defer func() {
 					if err != nil {
 						sendMetric("opportunity.failed", 1)
@@ -90,7 +97,8 @@ func processOpportunity(ctx context.Context, svc DynamoDBUpdateItemAPI, opp oppo
 	logger := log.With(logger,
 		"opportunity_id", opp.OpportunityID, "opportunity_number", opp.OpportunityNumber)
 
-	if err := UpdateDynamoDBItem(ctx, svc, env.DestinationTable, opp); err != nil {
+	item, err := UpdateDynamoDBItem(ctx, svc, env.DestinationTable, opp)
+	if err != nil {
 		var conditionalCheckErr *types.ConditionalCheckFailedException
 		if errors.As(err, &conditionalCheckErr) {
 			log.Warn(logger, "Grants.gov data already matches the target DynamoDB item",
@@ -102,5 +110,24 @@ func processOpportunity(ctx context.Context, svc DynamoDBUpdateItemAPI, opp oppo
 
 	log.Info(logger, "Successfully uploaded opportunity")
 	sendMetric("opportunity.saved", 1)
+	checkCloseDateConflict(logger, item)
 	return nil
 }
+
+// checkCloseDateConflict reports when the grants.gov close date of the given DynamoDB item
+// disagrees with the due date provided by FFIS. Both dates are kept on the item as-is.
+func checkCloseDateConflict(logger log.Logger, item map[string]types.AttributeValue) {
+	var dates struct {
+		GrantsGovCloseDate string `dynamodbav:"CloseDate"`
+		FFISDueDate        string `dynamodbav:"ffis_due_date"`
+	}
+	if err := attributevalue.UnmarshalMap(item, &dates); err != nil {
+		log.Warn(logger, "Could not check for conflicting close dates", "error", err)
+		return
+	}
+	if ffis.DueDateConflicts(dates.FFISDueDate, dates.GrantsGovCloseDate) {
+		log.Warn(logger, "Grants.gov close date conflicts with FFIS due date",
+			"grants_gov_close_date", dates.GrantsGovCloseDate, "ffis_due_date", dates.FFISDueDate)
+		sendMetric("opportunity.close_date_conflict", 1)
+	}
+}
@@ -25,7 +25,12 @@ import (
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func setupLambdaEnvForTesting(t *testing.T) {
@@ -138,7 +143,7 @@ func TestLambdaInvocationScenarios(t *testing.T) {
 		dynamodbClient := mockDynamoDBUpdateItemAPI{
 			mockUpdateItemAPI(func(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
 				t.Helper()
-				return nil, nil
+				return &dynamodb.UpdateItemOutput{}, nil
 			}),
 		}
 		err = handleS3EventWithConfig(s3Client, dynamodbClient, context.TODO(), events.S3Event{
@@ -173,7 +178,7 @@ func TestLambdaInvocationScenarios(t *testing.T) {
 		dynamodbClient := mockDynamoDBUpdateItemAPI{
 			mockUpdateItemAPI(func(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
 				t.Helper()
-				return nil, nil
+				return &dynamodb.UpdateItemOutput{}, nil
 			}),
 		}
 
@@ -197,6 +202,101 @@ func TestLambdaInvocationScenarios(t *testing.T) {
 	})
 }
 
+func TestLambdaInvocationFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeaturePersist: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		assert.Fail(t, "Unexpected S3 request while disabled", "%s %s", req.Method, req.URL)
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: server.URL}, nil
+			}),
+		),
+	)
+	require.NoError(t, err)
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+	dynamodbClient := mockDynamoDBUpdateItemAPI{func(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+		assert.Fail(t, "Unexpected DynamoDB request while disabled")
+		return nil, fmt.Errorf("unexpected request")
+	}}
+
+	err = handleS3EventWithConfig(s3Client, dynamodbClient, context.TODO(), events.S3Event{
+		Records: []events.S3EventRecord{{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: "test-source-bucket"},
+			Object: events.S3Object{Key: "sources/2022/09/08/grants.gov/123456/v2.xml"},
+		}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
+func TestLambdaInvocationTracing(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+	t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+
+	sourceBucketName := "test-source-bucket"
+	s3Client, err := setupS3ForTesting(t, sourceBucketName)
+	require.NoError(t, err)
+	var sourceData bytes.Buffer
+	require.NoError(t, template.Must(template.New("xml").Parse(SOURCE_OPPORTUNITY_TEMPLATE)).
+		Execute(&sourceData, map[string]string{
+			"OpportunityID":   "123456",
+			"LastUpdatedDate": "01022023",
+		}))
+	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(sourceBucketName),
+		Key:    aws.String("123/123456/grants.gov/v2.xml"),
+		Body:   bytes.NewReader(sourceData.Bytes()),
+	})
+	require.NoError(t, err)
+	dynamodbClient := mockDynamoDBUpdateItemAPI{
+		mockUpdateItemAPI(func(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+			return &dynamodb.UpdateItemOutput{}, nil
+		}),
+	}
+
+	err = handleS3EventWithConfig(s3Client, dynamodbClient, context.TODO(), events.S3Event{
+		Records: []events.S3EventRecord{
+			{S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: sourceBucketName},
+				Object: events.S3Object{Key: "does/not/exist"},
+			}},
+			{S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: sourceBucketName},
+				Object: events.S3Object{Key: "123/123456/grants.gov/v2.xml"},
+			}},
+		},
+	})
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	statusCodes := []codes.Code{}
+	for _, span := range spans {
+		assert.Equal(t, "handle.record", span.Name())
+		statusCodes = append(statusCodes, span.Status().Code)
+	}
+	assert.ElementsMatch(t, []codes.Code{codes.Error, codes.Unset}, statusCodes,
+		"Only the span for the failed record should be marked as an error")
+}
+
 func TestProcessOpportunity(t *testing.T) {
 	now := time.Now()
 	testOpportunity := opportunity{
@@ -230,3 +330,58 @@ func TestProcessOpportunity(t *testing.T) {
 		assert.NoError(t, processOpportunity(context.TODO(), dynamodbClient, testOpportunity))
 	})
 }
+
+func TestProcessOpportunityCloseDateConflict(t *testing.T) {
+	sentMetrics := map[string]float64{}
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	testOpportunity := opportunity{
+		OpportunityID:     "123456",
+		OpportunityNumber: "USDA-FS-2020-01",
+		CloseDate:         "05112023",
+	}
+
+	for _, tt := range []struct {
+		name             string
+		item             map[string]types.AttributeValue // Returned by DynamoDB after merging grants.gov data
+		expectedConflict float64
+	}{
+		{"no FFIS data yet", map[string]types.AttributeValue{
+			"CloseDate": &types.AttributeValueMemberS{Value: "05112023"},
+			"sources":   &types.AttributeValueMemberSS{Value: []string{"grants.gov"}},
+		}, 0},
+		{"FFIS due date agrees", map[string]types.AttributeValue{
+			"CloseDate":     &types.AttributeValueMemberS{Value: "05112023"},
+			"ffis_due_date": &types.AttributeValueMemberS{Value: "2023-05-11"},
+			"sources":       &types.AttributeValueMemberSS{Value: []string{"ffis.org", "grants.gov"}},
+		}, 0},
+		{"FFIS due date conflicts", map[string]types.AttributeValue{
+			"CloseDate":     &types.AttributeValueMemberS{Value: "05112023"},
+			"ffis_due_date": &types.AttributeValueMemberS{Value: "2023-04-30"},
+			"sources":       &types.AttributeValueMemberSS{Value: []string{"ffis.org", "grants.gov"}},
+		}, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setupLambdaEnvForTesting(t)
+			delete(sentMetrics, "opportunity.close_date_conflict")
+			dynamodbClient := mockDynamoDBUpdateItemAPI{
+				mockUpdateItemAPI(func(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+					names := []string{}
+					for _, name := range params.ExpressionAttributeNames {
+						assert.NotContains(t, name, "ffis_", "Grants.gov data should not write FFIS-owned attributes")
+						names = append(names, name)
+					}
+					assert.Contains(t, names, "sources")
+					assert.Contains(t, names, "version")
+					assert.Contains(t, *params.UpdateExpression, "ADD")
+					assert.Equal(t, types.ReturnValueAllNew, params.ReturnValues)
+					return &dynamodb.UpdateItemOutput{Attributes: tt.item}, nil
+				}),
+			}
+			require.NoError(t, processOpportunity(context.TODO(), dynamodbClient, testOpportunity))
+			assert.Equal(t, tt.expectedConflict, sentMetrics["opportunity.close_date_conflict"])
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
 )
@@ -24,7 +25,12 @@ type EventBridgePutEventsAPI interface {
 		*eventbridge.PutEventsOutput, error)
 }
 
+// handleEvent publishes grant modification events for the records of the DynamoDB stream event.
+// Records are skipped without side effects while the publish_events feature is disabled.
 func handleEvent(ctx context.Context, pub EventBridgePutEventsAPI, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeaturePublishEvents, len(event.Records)) {
+		return events.DynamoDBEventResponse{}, nil
+	}
 	sendMetric("invocation_batch_size", float64(len(event.Records)))
 	failures := make([]events.DynamoDBBatchItemFailure, 0)
 
@@ -16,6 +16,7 @@ import (
 	"github.com/oklog/ulid/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
 )
 
@@ -120,6 +121,40 @@ func TestHandleEvent(t *testing.T) {
 	assert.Equal(t, 3, mockEB.callCount)
 }
 
+func TestHandleEventFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeaturePublishEvents: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	event := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{
+			EventName: DDBStreamEventInsert,
+			Change: events.DynamoDBStreamRecord{
+				NewImage:       getFixtureItem(t, "fixtures/goodItem.json"),
+				SequenceNumber: "SkippedInsert",
+			},
+		},
+		{
+			EventName: DDBStreamEventDelete,
+			Change: events.DynamoDBStreamRecord{
+				NewImage:       getFixtureItem(t, "fixtures/goodItem.json"),
+				SequenceNumber: "SkippedDelete",
+			},
+		},
+	}}
+
+	mockEB := &mockEventBridgePutEventsAPI{}
+	resp, err := handleEvent(context.Background(), mockEB, event)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.BatchItemFailures)
+	assert.Equal(t, 0, mockEB.callCount, "EventBridge should not be called while disabled")
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 2}, sentMetrics)
+}
+
 func TestHandleRecord(t *testing.T) {
 	setupLambdaEnvForTesting(t)
 
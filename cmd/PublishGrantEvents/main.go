@@ -18,6 +18,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
@@ -25,15 +26,17 @@ import (
 )
 
 type Environment struct {
-	LogLevel     string `env:"LOG_LEVEL,default=INFO"`
-	EventBusName string `env:"EVENT_BUS_NAME,required=true"`
-	Extras       goenv.EnvSet
+	LogLevel         string `env:"LOG_LEVEL,default=INFO"`
+	EventBusName     string `env:"EVENT_BUS_NAME,required=true"`
+	DisabledFeatures string `env:"DISABLED_FEATURES"`
+	Extras           goenv.EnvSet
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("PublishGrantEvents")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("PublishGrantEvents")
 )
 
 func main() {
@@ -43,10 +46,19 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(
 		func(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+			defer ddHelpers.FlushMetricsAndLog(logger)
+			defer ddHelpers.StartInvocation(sendMetric)()
 			cfg, err := awsHelpers.GetConfig(ctx)
 			if err != nil {
 				resp := events.DynamoDBEventResponse{}
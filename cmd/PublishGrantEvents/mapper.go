@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/oklog/ulid/v2"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
 )
@@ -57,7 +58,7 @@ func (im *ItemMapper) timeFor(k string, layout string) (*time.Time, error) {
 
 func (im *ItemMapper) Grant() usdr.Grant {
 	grant := usdr.Grant{
-		Bill:                   im.stringFor("Bill"),
+		Bill:                   im.Bill(),
 		Revision:               im.Revision(),
 		Opportunity:            im.Opportunity(),
 		EligibleApplicants:     im.EligibleApplicants(),
@@ -113,6 +114,15 @@ func (im *ItemMapper) Grant() usdr.Grant {
 	return grant
 }
 
+// Bill returns the bill provided by FFIS, which was saved to the un-namespaced "Bill"
+// attribute before FFIS data was merged under its own attribute namespace.
+func (im *ItemMapper) Bill() string {
+	if bill := im.stringFor(ffis.DynamoDBAttributeBill); bill != "" {
+		return bill
+	}
+	return im.stringFor("Bill")
+}
+
 func (im *ItemMapper) Revision() usdr.Revision {
 	id, err := ulid.ParseStrict(im.stringFor("revision"))
 	if err != nil {
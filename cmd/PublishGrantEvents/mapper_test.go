@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,3 +41,27 @@ func TestGuardPanic(t *testing.T) {
 		assert.Equal(t, "do not panic", res)
 	})
 }
+
+func TestItemMapperBill(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		attrs    map[string]events.DynamoDBAttributeValue
+		expected string
+	}{
+		{"namespaced FFIS bill", map[string]events.DynamoDBAttributeValue{
+			"ffis_bill": events.NewStringAttribute("HR 1234"),
+		}, "HR 1234"},
+		{"legacy bill", map[string]events.DynamoDBAttributeValue{
+			"Bill": events.NewStringAttribute("HR 5678"),
+		}, "HR 5678"},
+		{"namespaced FFIS bill takes precedence", map[string]events.DynamoDBAttributeValue{
+			"Bill":      events.NewStringAttribute("HR 5678"),
+			"ffis_bill": events.NewStringAttribute("HR 1234"),
+		}, "HR 1234"},
+		{"no bill", map[string]events.DynamoDBAttributeValue{}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NewItemMapper(tt.attrs).Bill())
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+// AtomNamespace is the XML namespace of Atom feed documents (RFC 4287).
+const AtomNamespace = "http://www.w3.org/2005/Atom"
+
+// DefaultFeedID identifies the feed when FEED_ID is not configured. Since Atom IDs must never
+// change, entry IDs are derived from the feed ID and the grant_id of each opportunity.
+const DefaultFeedID = "tag:usdigitalresponse.org,2023:grants-ingest/opportunities"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated time.Time   `xml:"updated"`
+	Author  *atomPerson `xml:"author,omitempty"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Links     []atomLink  `xml:"link"`
+	Published time.Time   `xml:"published"`
+	Updated   time.Time   `xml:"updated"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Summary   string      `xml:"summary,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// feedID returns the configured FEED_ID, or DefaultFeedID when FEED_ID is not configured.
+func feedID() string {
+	if env.FeedID != "" {
+		return env.FeedID
+	}
+	return DefaultFeedID
+}
+
+// entryID returns the stable ID of the feed entry for the opportunity with the given grant_id,
+// so that feed readers recognize an opportunity that appears in successive versions of the feed.
+func entryID(grantID string) string {
+	return feedID() + "/" + url.PathEscape(grantID)
+}
+
+// parseFeed decodes an Atom feed document. An empty document decodes to a feed without entries.
+func parseFeed(b []byte) (atomFeed, error) {
+	var feed atomFeed
+	if len(bytes.TrimSpace(b)) == 0 {
+		return feed, nil
+	}
+	err := xml.Unmarshal(b, &feed)
+	return feed, err
+}
+
+// encode returns the feed as an XML document.
+func (f atomFeed) encode() ([]byte, error) {
+	b, err := xml.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// addEntry adds entry to the feed, unless the feed already has an entry with the same ID,
+// and retains only the maxEntries most recently published entries. The feed's metadata is
+// (re)set from the environment so that configuration changes apply to existing feeds.
+// The feed's updated time is that of its most recently updated entry, which makes adding the
+// same entry to the same feed idempotent.
+func (f *atomFeed) addEntry(entry atomEntry, maxEntries int) {
+	f.XMLName = xml.Name{Space: AtomNamespace, Local: "feed"}
+	f.ID = feedID()
+	f.Title = env.FeedTitle
+	f.Author = &atomPerson{Name: env.FeedAuthor}
+	f.Links = nil
+	if env.FeedSelfURL != "" {
+		f.Links = []atomLink{{Rel: "self", Type: "application/atom+xml", Href: env.FeedSelfURL}}
+	}
+
+	exists := false
+	for _, e := range f.Entries {
+		if e.ID == entry.ID {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		f.Entries = append(f.Entries, entry)
+	}
+	sort.SliceStable(f.Entries, func(i, j int) bool {
+		if !f.Entries[i].Published.Equal(f.Entries[j].Published) {
+			return f.Entries[i].Published.After(f.Entries[j].Published)
+		}
+		return f.Entries[i].ID > f.Entries[j].ID
+	})
+	if len(f.Entries) > maxEntries {
+		f.Entries = f.Entries[:maxEntries]
+	}
+
+	f.Updated = time.Time{}
+	for _, e := range f.Entries {
+		if e.Updated.After(f.Updated) {
+			f.Updated = e.Updated
+		}
+	}
+	if f.Updated.IsZero() {
+		f.Updated = entry.Updated
+	}
+}
+
+// newFeedEntry returns the feed entry for a newly-created grant, which was created at createdAt.
+func newFeedEntry(grant usdr.Grant, createdAt time.Time) atomEntry {
+	createdAt = createdAt.UTC().Truncate(time.Second)
+	title := grant.Opportunity.Title
+	if title == "" {
+		title = grant.Opportunity.Number
+	}
+	entry := atomEntry{
+		ID:        entryID(grant.Opportunity.Id),
+		Title:     title,
+		Published: createdAt,
+		Updated:   createdAt,
+		Links: []atomLink{{
+			Rel:  "alternate",
+			Type: "text/html",
+			Href: env.OpportunityURLPrefix + url.PathEscape(grant.Opportunity.Id),
+		}},
+		Summary: entrySummary(grant),
+	}
+	if agency := agencyName(grant.Agency); agency != "" {
+		entry.Author = &atomPerson{Name: agency}
+	}
+	return entry
+}
+
+// entrySummary describes the agency and close date of grant.
+func entrySummary(grant usdr.Grant) string {
+	parts := []string{}
+	if agency := agencyName(grant.Agency); agency != "" {
+		parts = append(parts, fmt.Sprintf("Agency: %s", agency))
+	}
+	closeDate := "not specified"
+	if grant.Opportunity.Milestones.Close.Date != nil {
+		closeDate = time.Time(*grant.Opportunity.Milestones.Close.Date).Format(usdr.DateLayout)
+	} else if explanation := strings.TrimSpace(grant.Opportunity.Milestones.Close.Explanation); explanation != "" {
+		closeDate = explanation
+	}
+	parts = append(parts, fmt.Sprintf("Close date: %s", closeDate))
+	return strings.Join(parts, "; ")
+}
+
+// agencyName returns the name and/or code of agency, e.g. "Rural Utilities Service (USDA-RUS)".
+func agencyName(agency usdr.Agency) string {
+	switch {
+	case agency.Name != "" && agency.Code != "":
+		return fmt.Sprintf("%s (%s)", agency.Name, agency.Code)
+	case agency.Name != "":
+		return agency.Name
+	default:
+		return agency.Code
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+var (
+	ErrMissingNewVersion  = errors.New("grant modification event has no new version")
+	ErrFeedUpdateConflict = errors.New("feed could not be updated without conflicts")
+)
+
+// handleEvent adds the opportunity created by the GrantModificationEvent in the detail of event
+// to the feed. Events for updated or deleted opportunities are ignored.
+// Since concurrent invocations may update the feed at the same time, each update is saved on
+// the condition that the feed has not changed since it was loaded; when that condition fails,
+// the update is retried (up to MAX_UPDATE_ATTEMPTS times in total) against the newer feed.
+// The feed is not saved when adding the opportunity would not change it (e.g. when the same
+// event is delivered more than once).
+// Events are skipped without side effects while the publish_feed feature is disabled.
+func handleEvent(ctx context.Context, store FeedStore, event events.CloudWatchEvent) error {
+	logger := log.With(logger, "event_id", event.ID, "event_detail_type", event.DetailType)
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeaturePublishFeed, 1) {
+		return nil
+	}
+
+	var modification usdr.GrantModificationEvent
+	if err := json.Unmarshal(event.Detail, &modification); err != nil {
+		return log.Errorf(logger, "error decoding grant modification event", err)
+	}
+	if modification.Type.String() != usdr.EventTypeCreate {
+		log.Debug(logger, "Ignoring event for opportunity that was not newly created",
+			"modification_type", modification.Type)
+		sendMetric("event.ignored", 1)
+		return nil
+	}
+	if modification.Versions.New == nil {
+		return log.Errorf(logger, "error reading grant modification event", ErrMissingNewVersion)
+	}
+	grant := *modification.Versions.New
+	entry := newFeedEntry(grant, event.Time)
+	logger = log.With(logger, "grant_id", grant.Opportunity.Id, "entry_id", entry.ID)
+
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "feed.update")
+	updated, attempts, err := updateFeed(spanCtx, store, entry)
+	span.SetTag("attempts", attempts)
+	tracing.FinishWithOutcome(span, err)
+	if err != nil {
+		sendMetric("feed.update_failed", 1)
+		return log.Errorf(logger, "error updating feed", err)
+	}
+	if !updated {
+		log.Info(logger, "Feed already includes opportunity")
+		sendMetric("feed.unchanged", 1)
+		return nil
+	}
+	log.Info(logger, "Added opportunity to feed", "attempts", attempts)
+	sendMetric("feed.updated", 1)
+	return nil
+}
+
+// updateFeed adds entry to the feed held by store, retrying when the feed is concurrently
+// modified. Returns whether the feed was changed, and the number of attempts made.
+func updateFeed(ctx context.Context, store FeedStore, entry atomEntry) (bool, int, error) {
+	for attempt := 1; attempt <= env.MaxUpdateAttempts; attempt++ {
+		current, etag, err := store.Load(ctx)
+		if err != nil {
+			return false, attempt, fmt.Errorf("error loading feed: %w", err)
+		}
+		feed, err := parseFeed(current)
+		if err != nil {
+			return false, attempt, fmt.Errorf("error parsing feed: %w", err)
+		}
+		feed.addEntry(entry, env.FeedMaxEntries)
+		b, err := feed.encode()
+		if err != nil {
+			return false, attempt, fmt.Errorf("error encoding feed: %w", err)
+		}
+		if bytes.Equal(b, current) {
+			return false, attempt, nil
+		}
+
+		err = store.Save(ctx, b, etag)
+		if errors.Is(err, ErrFeedConflict) {
+			log.Warn(logger, "Feed was modified concurrently; retrying update",
+				"attempt", attempt, "error", err)
+			sendMetric("feed.conflict", 1)
+			continue
+		}
+		if err != nil {
+			return false, attempt, fmt.Errorf("error saving feed: %w", err)
+		}
+		return true, attempt, nil
+	}
+	return false, env.MaxUpdateAttempts, ErrFeedUpdateConflict
+}
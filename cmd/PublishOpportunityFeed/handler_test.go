@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+func setupLambdaEnvForTesting(t *testing.T, extras goenv.EnvSet) {
+	t.Helper()
+
+	// Suppress normal lambda log output
+	logger = log.NewNopLogger()
+	sendMetric = func(metric string, value float64, tags ...string) {}
+
+	// Configure environment variables
+	es := goenv.EnvSet{
+		"FEED_BUCKET_NAME": "test-public",
+		"FEED_SELF_URL":    "https://example.com/feeds/opportunities.atom.xml",
+	}
+	for k, v := range extras {
+		es[k] = v
+	}
+	env = Environment{}
+	err := goenv.Unmarshal(es, &env)
+	require.NoError(t, err, "Error configuring environment variables for testing")
+}
+
+// memFeedStore is an in-memory FeedStore that enforces the same write conditions as S3.
+type memFeedStore struct {
+	body    []byte
+	etag    string
+	version int
+	saves   int
+	// beforeSave is called before each save is attempted, e.g. to simulate a concurrent writer
+	beforeSave func(s *memFeedStore)
+}
+
+func (s *memFeedStore) Load(context.Context) ([]byte, string, error) {
+	return append([]byte(nil), s.body...), s.etag, nil
+}
+
+func (s *memFeedStore) Save(ctx context.Context, body []byte, etag string) error {
+	if s.beforeSave != nil {
+		s.beforeSave(s)
+	}
+	if etag != s.etag {
+		return fmt.Errorf("%w: etag %q does not match %q", ErrFeedConflict, etag, s.etag)
+	}
+	s.put(body)
+	s.saves++
+	return nil
+}
+
+func (s *memFeedStore) put(body []byte) {
+	s.version++
+	s.body = append([]byte(nil), body...)
+	s.etag = fmt.Sprintf("%q", fmt.Sprintf("v%d", s.version))
+}
+
+func newGrant(id, title string, closeDate *time.Time) usdr.Grant {
+	grant := usdr.Grant{
+		Opportunity: usdr.Opportunity{Id: id, Number: "NUM-" + id, Title: title},
+		Agency:      usdr.Agency{Name: "Administration for Children and Families", Code: "HHS-ACF"},
+	}
+	if closeDate != nil {
+		d := usdr.Date(*closeDate)
+		grant.Opportunity.Milestones.Close.Date = &d
+	}
+	return grant
+}
+
+func modificationEvent(t *testing.T, newVersion, prevVersion *usdr.Grant, at time.Time) events.CloudWatchEvent {
+	t.Helper()
+	modification, err := usdr.NewGrantModificationEvent(newVersion, prevVersion)
+	require.NoError(t, err)
+	detail, err := json.Marshal(modification)
+	require.NoError(t, err)
+	return events.CloudWatchEvent{
+		ID:         fmt.Sprintf("event-%d", at.Unix()),
+		DetailType: "GrantModificationEvent",
+		Source:     "org.usdigitalresponse.grants-ingest",
+		Time:       at,
+		Detail:     detail,
+	}
+}
+
+func createEvent(t *testing.T, id string, at time.Time) events.CloudWatchEvent {
+	t.Helper()
+	closeDate := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	grant := newGrant(id, "Opportunity "+id, &closeDate)
+	return modificationEvent(t, &grant, nil, at)
+}
+
+// requireValidAtom asserts that b is an Atom feed document with the elements required by
+// RFC 4287, and returns the decoded feed.
+func requireValidAtom(t *testing.T, b []byte) atomFeed {
+	t.Helper()
+	var doc struct {
+		XMLName xml.Name
+		ID      []string `xml:"id"`
+		Title   []string `xml:"title"`
+		Updated []string `xml:"updated"`
+		Author  []struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+		Links []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Entries []struct {
+			XMLName   xml.Name
+			ID        []string `xml:"id"`
+			Title     []string `xml:"title"`
+			Updated   []string `xml:"updated"`
+			Published []string `xml:"published"`
+			Links     []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	require.NoError(t, xml.Unmarshal(b, &doc), "feed is not well-formed XML")
+	require.Equal(t, xml.Name{Space: AtomNamespace, Local: "feed"}, doc.XMLName)
+	require.Len(t, doc.ID, 1, "feed must have exactly one id")
+	require.Len(t, doc.Title, 1, "feed must have exactly one title")
+	require.Len(t, doc.Updated, 1, "feed must have exactly one updated")
+	_, err := time.Parse(time.RFC3339, doc.Updated[0])
+	require.NoError(t, err, "feed updated must be an RFC 3339 timestamp")
+	require.Len(t, doc.Author, 1, "feed must have an author")
+	require.NotEmpty(t, doc.Author[0].Name)
+
+	ids := map[string]bool{}
+	for _, entry := range doc.Entries {
+		require.Equal(t, AtomNamespace, entry.XMLName.Space)
+		require.Len(t, entry.ID, 1, "entry must have exactly one id")
+		require.False(t, ids[entry.ID[0]], "entry id %q is duplicated", entry.ID[0])
+		ids[entry.ID[0]] = true
+		require.Len(t, entry.Title, 1, "entry must have exactly one title")
+		require.Len(t, entry.Updated, 1, "entry must have exactly one updated")
+		_, err := time.Parse(time.RFC3339, entry.Updated[0])
+		require.NoError(t, err, "entry updated must be an RFC 3339 timestamp")
+		require.Len(t, entry.Published, 1, "entry must have exactly one published")
+		require.NotEmpty(t, entry.Links, "entry must have an alternate link")
+		assert.Equal(t, "alternate", entry.Links[0].Rel)
+		assert.NotEmpty(t, entry.Links[0].Href)
+	}
+
+	feed, err := parseFeed(b)
+	require.NoError(t, err)
+	return feed
+}
+
+func entryIDs(feed atomFeed) []string {
+	ids := []string{}
+	for _, entry := range feed.Entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestHandleEventDeduplicatesAcrossRuns(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	store := &memFeedStore{}
+	t1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "1001", t1)))
+	require.Equal(t, 1, store.saves)
+	feed := requireValidAtom(t, store.body)
+	assert.Equal(t, DefaultFeedID, feed.ID)
+	assert.Equal(t, "New Grant Opportunities", feed.Title)
+	assert.Equal(t, []atomLink{{Rel: "self", Type: "application/atom+xml",
+		Href: "https://example.com/feeds/opportunities.atom.xml"}}, feed.Links)
+	require.Len(t, feed.Entries, 1)
+	entry := feed.Entries[0]
+	assert.Equal(t, DefaultFeedID+"/1001", entry.ID)
+	assert.Equal(t, "Opportunity 1001", entry.Title)
+	assert.Equal(t, "https://www.grants.gov/search-results-detail/1001", entry.Links[0].Href)
+	assert.Equal(t, t1, entry.Published)
+	assert.Equal(t, "Administration for Children and Families (HHS-ACF)", entry.Author.Name)
+	assert.Equal(t, "Agency: Administration for Children and Families (HHS-ACF); Close date: 2024-06-30",
+		entry.Summary)
+	assert.Equal(t, t1, feed.Updated)
+	firstRun := append([]byte(nil), store.body...)
+
+	// Redelivery of the same event leaves the feed untouched
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "1001", t1)))
+	assert.Equal(t, 1, store.saves, "unchanged feed should not be saved again")
+	assert.Equal(t, firstRun, store.body)
+
+	// A later event for the same opportunity does not duplicate its entry
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "1001", t2)))
+	assert.Equal(t, 1, store.saves)
+
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "1002", t2)))
+	assert.Equal(t, 2, store.saves)
+	feed = requireValidAtom(t, store.body)
+	assert.Equal(t, []string{DefaultFeedID + "/1002", DefaultFeedID + "/1001"}, entryIDs(feed))
+	assert.Equal(t, t2, feed.Updated)
+	assert.Equal(t, t1, feed.Entries[1].Published, "existing entry should be retained as-is")
+}
+
+func TestHandleEventRetainsMostRecentEntries(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{"FEED_MAX_ENTRIES": "3", "FEED_ID": "urn:example:feed"})
+	store := &memFeedStore{}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Events are handled out of order
+	for _, i := range []int{2, 0, 4, 1, 3} {
+		at := start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, handleEvent(context.Background(), store, createEvent(t, fmt.Sprint(100+i), at)))
+	}
+	feed := requireValidAtom(t, store.body)
+	assert.Equal(t, "urn:example:feed", feed.ID)
+	assert.Equal(t, []string{"urn:example:feed/104", "urn:example:feed/103", "urn:example:feed/102"},
+		entryIDs(feed))
+
+	// An opportunity older than every retained entry does not change the feed
+	saves := store.saves
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "99", start.Add(-time.Hour))))
+	assert.Equal(t, saves, store.saves)
+}
+
+func TestHandleEventConcurrentUpdate(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	t1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &memFeedStore{}
+	store.beforeSave = func(s *memFeedStore) {
+		// Another invocation adds its opportunity between this invocation's load and save
+		s.beforeSave = nil
+		var other atomFeed
+		closeDate := t1
+		other.addEntry(newFeedEntry(newGrant("2001", "Concurrent", &closeDate), t1), env.FeedMaxEntries)
+		b, err := other.encode()
+		require.NoError(t, err)
+		s.put(b)
+	}
+
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "2002", t1.Add(time.Minute))))
+	assert.Equal(t, 1, store.saves, "only the retried save should succeed")
+	feed := requireValidAtom(t, store.body)
+	assert.Equal(t, []string{DefaultFeedID + "/2002", DefaultFeedID + "/2001"}, entryIDs(feed))
+}
+
+func TestHandleEventConflictsExhausted(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{"MAX_UPDATE_ATTEMPTS": "3"})
+	store := &memFeedStore{}
+	attempts := 0
+	store.beforeSave = func(s *memFeedStore) {
+		attempts++
+		s.put([]byte{})
+	}
+
+	err := handleEvent(context.Background(), store, createEvent(t, "3001", time.Now()))
+	assert.ErrorIs(t, err, ErrFeedUpdateConflict)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 0, store.saves)
+}
+
+func TestHandleEventIgnoresOtherModifications(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	store := &memFeedStore{}
+	prev := newGrant("4001", "Before", nil)
+	next := newGrant("4001", "After", nil)
+
+	for _, event := range []events.CloudWatchEvent{
+		modificationEvent(t, &next, &prev, time.Now()),
+		modificationEvent(t, nil, &prev, time.Now()),
+	} {
+		require.NoError(t, handleEvent(context.Background(), store, event))
+	}
+	assert.Equal(t, 0, store.saves)
+	assert.Empty(t, store.body)
+}
+
+func TestHandleEventFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeaturePublishFeed: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	store := &memFeedStore{}
+
+	require.NoError(t, handleEvent(context.Background(), store, createEvent(t, "5001", time.Now())))
+	assert.Equal(t, 0, store.saves, "feed should not be saved while disabled")
+	assert.Empty(t, store.body)
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
+type errorFeedStore struct{ err error }
+
+func (s errorFeedStore) Load(context.Context) ([]byte, string, error) { return nil, "", s.err }
+func (s errorFeedStore) Save(context.Context, []byte, string) error   { return s.err }
+
+func TestHandleEventErrors(t *testing.T) {
+	setupLambdaEnvForTesting(t, nil)
+
+	t.Run("malformed detail", func(t *testing.T) {
+		event := events.CloudWatchEvent{Detail: json.RawMessage(`{`)}
+		assert.Error(t, handleEvent(context.Background(), &memFeedStore{}, event))
+	})
+
+	t.Run("malformed feed", func(t *testing.T) {
+		store := &memFeedStore{body: []byte("<rss></rss>"), etag: `"x"`}
+		err := handleEvent(context.Background(), store, createEvent(t, "5001", time.Now()))
+		assert.Error(t, err)
+		assert.Equal(t, 0, store.saves)
+	})
+
+	t.Run("store fails", func(t *testing.T) {
+		storeErr := errors.New("access denied")
+		err := handleEvent(context.Background(), errorFeedStore{storeErr}, createEvent(t, "5002", time.Now()))
+		assert.ErrorIs(t, err, storeErr)
+	})
+}
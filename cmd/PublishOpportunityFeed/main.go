@@ -0,0 +1,90 @@
+// Package main compiles to an AWS Lambda handler binary that maintains an Atom feed of newly
+// ingested grant opportunities. It is invoked by an EventBridge rule for each
+// GrantModificationEvent that describes a newly-created opportunity, and adds an entry for that
+// opportunity to the feed stored at FEED_KEY in the S3 bucket named by the FEED_BUCKET_NAME
+// environment variable. The feed holds the FEED_MAX_ENTRIES most recent opportunities.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+type Environment struct {
+	LogLevel             string `env:"LOG_LEVEL,default=INFO"`
+	FeedBucket           string `env:"FEED_BUCKET_NAME,required=true"`
+	FeedKey              string `env:"FEED_KEY,default=feeds/opportunities.atom.xml"`
+	FeedID               string `env:"FEED_ID"`
+	FeedTitle            string `env:"FEED_TITLE,default=New Grant Opportunities"`
+	FeedAuthor           string `env:"FEED_AUTHOR,default=USDR Grants Ingest"`
+	FeedSelfURL          string `env:"FEED_SELF_URL"`
+	FeedMaxEntries       int    `env:"FEED_MAX_ENTRIES,default=100"`
+	OpportunityURLPrefix string `env:"OPPORTUNITY_URL_PREFIX,default=https://www.grants.gov/search-results-detail/"`
+	MaxUpdateAttempts    int    `env:"MAX_UPDATE_ATTEMPTS,default=5"`
+	UsePathStyleS3Opt    bool   `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider      string `env:"TRACING_PROVIDER,default=datadog"`
+	DisabledFeatures     string `env:"DISABLED_FEATURES"`
+	Extras               goenv.EnvSet
+}
+
+var (
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("PublishOpportunityFeed")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if env.FeedMaxEntries < 1 {
+		goLog.Fatalf("error configuring environment variables: FEED_MAX_ENTRIES must be positive")
+	}
+	if env.MaxUpdateAttempts < 1 {
+		goLog.Fatalf("error configuring environment variables: MAX_UPDATE_ATTEMPTS must be positive")
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event events.CloudWatchEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = env.UsePathStyleS3Opt
+		})
+		store := &s3FeedStore{client: s3Client, bucket: env.FeedBucket, key: env.FeedKey}
+		return handleEvent(ctx, store, event)
+	}, nil))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrFeedConflict indicates that the feed was modified (or created) by another writer
+// after it was loaded, so the update was not saved.
+var ErrFeedConflict = errors.New("feed was modified concurrently")
+
+// FeedStore loads and conditionally saves the feed document.
+type FeedStore interface {
+	// Load returns the feed document along with its ETag. When the feed does not yet exist,
+	// the returned document and ETag are both empty.
+	Load(ctx context.Context) ([]byte, string, error)
+	// Save replaces the feed document, provided that its current ETag is etag (or, when etag is
+	// empty, that the feed does not yet exist). Otherwise, returns an error wrapping ErrFeedConflict.
+	Save(ctx context.Context, body []byte, etag string) error
+}
+
+type S3GetPutObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3FeedStore is a FeedStore for a feed document stored in S3.
+type s3FeedStore struct {
+	client S3GetPutObjectAPI
+	bucket string
+	key    string
+}
+
+func (s *s3FeedStore) Load(ctx context.Context) ([]byte, string, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, aws.ToString(resp.ETag), nil
+}
+
+func (s *s3FeedStore) Save(ctx context.Context, body []byte, etag string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(s.key),
+		Body:                 bytes.NewReader(body),
+		ContentType:          aws.String("application/atom+xml; charset=utf-8"),
+		CacheControl:         aws.String("max-age=300"),
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	}, withWriteCondition(etag))
+	if isWriteConditionFailure(err) {
+		return fmt.Errorf("%w: %w", ErrFeedConflict, err)
+	}
+	return err
+}
+
+// withWriteCondition makes a PutObject request conditional upon the current ETag of the object
+// being etag, or (when etag is empty) upon the object not existing.
+// The conditional headers are set directly since PutObjectInput does not (yet) model them.
+func withWriteCondition(etag string) func(*s3.Options) {
+	header, value := "If-Match", etag
+	if etag == "" {
+		header, value = "If-None-Match", "*"
+	}
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue(header, value))
+	}
+}
+
+// isWriteConditionFailure returns true when err indicates that S3 rejected a conditional write,
+// either because the condition was not met or because a concurrent conditional write to the
+// same object was in progress.
+func isWriteConditionFailure(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	return false
+}
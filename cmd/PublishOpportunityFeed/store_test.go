@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conditionalS3Server is a fake S3 endpoint for a single object, which honors the If-Match
+// and If-None-Match conditions of PutObject requests as S3 does.
+type conditionalS3Server struct {
+	mu      sync.Mutex
+	body    []byte
+	etag    string
+	headers []http.Header
+}
+
+func (s *conditionalS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = append(s.headers, r.Header.Clone())
+
+	switch r.Method {
+	case http.MethodGet:
+		if s.etag == "" {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", s.etag)
+		w.Header().Set("Content-Length", fmt.Sprint(len(s.body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.body)
+	case http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && s.etag != "" ||
+			r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != s.etag {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		sum := md5.Sum(body)
+		s.body = body
+		s.etag = fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+		w.Header().Set("ETag", s.etag)
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`,
+		code, http.StatusText(status))
+}
+
+func setupS3StoreForTesting(t *testing.T) (*s3FeedStore, *conditionalS3Server) {
+	t.Helper()
+	server := &conditionalS3Server{}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithRegion("us-west-2"),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: ts.URL}, nil
+			}),
+		),
+		config.WithRetryMaxAttempts(1),
+	)
+	require.NoError(t, err)
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+	return &s3FeedStore{client: client, bucket: "test-public", key: "feeds/opportunities.atom.xml"}, server
+}
+
+func TestS3FeedStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("load missing feed", func(t *testing.T) {
+		store, _ := setupS3StoreForTesting(t)
+		body, etag, err := store.Load(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, body)
+		assert.Empty(t, etag)
+	})
+
+	t.Run("create and update feed", func(t *testing.T) {
+		store, server := setupS3StoreForTesting(t)
+		require.NoError(t, store.Save(ctx, []byte("first"), ""))
+		assert.Equal(t, "*", server.headers[0].Get("If-None-Match"))
+		assert.Empty(t, server.headers[0].Get("If-Match"))
+
+		body, etag, err := store.Load(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(body))
+		require.NotEmpty(t, etag)
+
+		require.NoError(t, store.Save(ctx, []byte("second"), etag))
+		assert.Equal(t, etag, server.headers[2].Get("If-Match"))
+		assert.Empty(t, server.headers[2].Get("If-None-Match"))
+		body, _, err = store.Load(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(body))
+	})
+
+	t.Run("feed created concurrently", func(t *testing.T) {
+		store, server := setupS3StoreForTesting(t)
+		require.NoError(t, store.Save(ctx, []byte("other writer"), ""))
+
+		err := store.Save(ctx, []byte("this writer"), "")
+		assert.ErrorIs(t, err, ErrFeedConflict)
+		assert.Equal(t, "other writer", string(server.body))
+	})
+
+	t.Run("feed modified concurrently", func(t *testing.T) {
+		store, server := setupS3StoreForTesting(t)
+		require.NoError(t, store.Save(ctx, []byte("first"), ""))
+		_, etag, err := store.Load(ctx)
+		require.NoError(t, err)
+		require.NoError(t, store.Save(ctx, []byte("other writer"), etag))
+
+		err = store.Save(ctx, []byte("this writer"), etag)
+		assert.ErrorIs(t, err, ErrFeedConflict)
+		assert.Equal(t, "other writer", string(server.body))
+	})
+}
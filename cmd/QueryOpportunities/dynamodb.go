@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DDBTableKeyAttributeName is the name of the hash key attribute of the prepared data table.
+const DDBTableKeyAttributeName = "grant_id"
+
+type DynamoDBQueryAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// QueryOpportunityItems returns at most q.limit items from the index of the given table that
+// is named by q, whose key attribute is equal to the filter value of q. When the index holds
+// more matching items, the returned key identifies the last item that was evaluated, from
+// which a subsequent query should continue.
+func QueryOpportunityItems(ctx context.Context, c DynamoDBQueryAPI, table string, q opportunityQuery) (
+	[]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	keyCond := expression.Key(q.filter.keyAttribute).Equal(expression.Value(q.value))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output, err := c.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		IndexName:                 aws.String(q.filter.indexName()),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ExclusiveStartKey:         q.startKey,
+		Limit:                     aws.Int32(q.limit),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return output.Items, output.LastEvaluatedKey, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/usdr"
+)
+
+const (
+	// OpportunitiesPath is the path of the opportunities resource.
+	OpportunitiesPath = "/opportunities"
+
+	// ParamCFDA filters opportunities by their primary (i.e. first) CFDA number.
+	// Opportunities whose only other CFDA numbers match are not returned, since the index
+	// that is queried is keyed by the primary CFDA number alone.
+	ParamCFDA      = "cfda"
+	ParamAgency    = "agency"
+	ParamLimit     = "limit"
+	ParamNextToken = "nextToken"
+
+	// pageOverheadBytes is reserved from the response size limit for the parts of the
+	// response body other than the opportunities (e.g. the next token).
+	pageOverheadBytes = 1024
+)
+
+var (
+	ErrUnknownParameter = errors.New("unknown query parameter")
+	ErrFilterRequired   = errors.New("exactly one of the cfda or agency query parameters is required")
+	ErrInvalidCFDA      = errors.New("cfda must be a CFDA number, e.g. 93.243")
+	ErrInvalidAgency    = errors.New("agency must be an agency code, e.g. HHS-ACF")
+	ErrInvalidLimit     = errors.New("limit must be a positive integer no greater than the maximum page size")
+	ErrInvalidNextToken = errors.New("nextToken is not valid for this query")
+)
+
+// agencyCodeRegexp matches grants.gov agency codes, e.g. "HHS" or "DOC-NOAA-ERA".
+var agencyCodeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,254}$`)
+
+type opportunity grantsgov.OpportunitySynopsisDetail_1_0
+
+// opportunityFilter identifies a query parameter by which opportunities are filtered,
+// and the key attribute of the DynamoDB index that is queried for that filter.
+type opportunityFilter struct {
+	param        string
+	keyAttribute string
+}
+
+var (
+	cfdaFilter   = opportunityFilter{ParamCFDA, grantsgov.DynamoDBAttributePrimaryCFDANumber}
+	agencyFilter = opportunityFilter{ParamAgency, "AgencyCode"}
+)
+
+// indexName returns the name of the DynamoDB index that is queried for the filter.
+func (f opportunityFilter) indexName() string {
+	if f == cfdaFilter {
+		return env.CFDAIndexName
+	}
+	return env.AgencyIndexName
+}
+
+// opportunityQuery is a validated request for a page of opportunities.
+type opportunityQuery struct {
+	filter   opportunityFilter
+	value    string
+	limit    int32
+	startKey map[string]types.AttributeValue
+}
+
+// opportunitiesPage is the body of a successful response.
+type opportunitiesPage struct {
+	Opportunities []json.RawMessage `json:"opportunities"`
+	NextToken     string            `json:"nextToken,omitempty"`
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// handleRequest handles a Lambda Function URL (or API Gateway HTTP API proxy) request for
+// GET /opportunities, which returns a page of the opportunities that match the cfda (which
+// matches primary CFDA numbers only) or agency query parameter. Opportunities are returned in
+// the same schema with which they are stored in DynamoDB. When more opportunities match, the
+// response includes a nextToken, which continues the query when given as the nextToken query
+// parameter of a subsequent request.
+// Malformed requests are rejected with a 400 response; failed queries result in a 500 response.
+func handleRequest(ctx context.Context, c DynamoDBQueryAPI, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	method := req.RequestContext.HTTP.Method
+	logger := log.With(logger, "method", method, "path", req.RawPath,
+		"query", req.RawQueryString, "request_id", req.RequestContext.RequestID)
+
+	if strings.TrimSuffix(req.RawPath, "/") != OpportunitiesPath {
+		return errorResponse(http.StatusNotFound, "not found"), nil
+	}
+	if method != http.MethodGet {
+		resp := errorResponse(http.StatusMethodNotAllowed, "method not allowed")
+		resp.Headers["Allow"] = http.MethodGet
+		return resp, nil
+	}
+
+	q, err := parseQuery(req.QueryStringParameters)
+	if err != nil {
+		log.Info(logger, "Rejected malformed request", "error", err)
+		sendMetric("request.invalid", 1)
+		return errorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	logger = log.With(logger, "filter", q.filter.param, "index", q.filter.indexName())
+
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "opportunities.query")
+	span.SetTag("filter", q.filter.param)
+	items, lastKey, err := QueryOpportunityItems(spanCtx, c, env.DestinationTable, q)
+	span.SetTag("items", len(items))
+	tracing.FinishWithOutcome(span, err)
+	if err != nil {
+		log.Error(logger, "Error querying opportunities", err)
+		sendMetric("request.failed", 1)
+		return errorResponse(http.StatusInternalServerError, "internal error"), nil
+	}
+
+	page, truncated, err := buildPage(items, lastKey, q.filter, env.MaxResponseBytes)
+	if err != nil {
+		log.Error(logger, "Error building page of opportunities", err)
+		sendMetric("request.failed", 1)
+		return errorResponse(http.StatusInternalServerError, "internal error"), nil
+	}
+	if truncated {
+		log.Warn(logger, "Page of opportunities was truncated to the response size limit",
+			"count_items", len(items), "count_opportunities", len(page.Opportunities),
+			"max_response_bytes", env.MaxResponseBytes)
+		sendMetric("response.truncated", 1)
+	}
+
+	log.Info(logger, "Returning page of opportunities",
+		"count_opportunities", len(page.Opportunities), "has_next_token", page.NextToken != "")
+	sendMetric("opportunities.returned", float64(len(page.Opportunities)))
+	return jsonResponse(http.StatusOK, page), nil
+}
+
+// parseQuery validates the query parameters of a request for opportunities.
+// Returns an error describing the first malformed or missing parameter.
+func parseQuery(params map[string]string) (opportunityQuery, error) {
+	q := opportunityQuery{limit: int32(env.DefaultPageSize)}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	filters := []opportunityFilter{}
+	for _, name := range names {
+		switch name {
+		case ParamCFDA:
+			filters = append(filters, cfdaFilter)
+		case ParamAgency:
+			filters = append(filters, agencyFilter)
+		case ParamLimit, ParamNextToken:
+		default:
+			return q, fmt.Errorf("%w %q", ErrUnknownParameter, name)
+		}
+	}
+	if len(filters) != 1 {
+		return q, ErrFilterRequired
+	}
+	q.filter = filters[0]
+	q.value = strings.TrimSpace(params[q.filter.param])
+
+	switch q.filter {
+	case cfdaFilter:
+		if _, err := usdr.NewCFDANumber(q.value); err != nil {
+			return q, ErrInvalidCFDA
+		}
+	case agencyFilter:
+		if !agencyCodeRegexp.MatchString(q.value) {
+			return q, ErrInvalidAgency
+		}
+	}
+
+	if s, ok := params[ParamLimit]; ok {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > env.MaxPageSize {
+			return q, fmt.Errorf("%w (%d)", ErrInvalidLimit, env.MaxPageSize)
+		}
+		q.limit = int32(limit)
+	}
+
+	if token, ok := params[ParamNextToken]; ok {
+		startKey, err := decodeNextToken(token, q.filter, q.value)
+		if err != nil {
+			return q, err
+		}
+		q.startKey = startKey
+	}
+	return q, nil
+}
+
+// buildPage returns a page of the opportunities in the given query result items, which
+// continues from lastKey (if any). Opportunities that would cause the JSON-encoded page to
+// exceed maxBytes are omitted, in which case the page continues from the last opportunity
+// that was included and the returned bool is true. At least one opportunity is included in
+// every page of a non-empty result.
+func buildPage(items []map[string]types.AttributeValue, lastKey map[string]types.AttributeValue,
+	filter opportunityFilter, maxBytes int) (opportunitiesPage, bool, error) {
+	page := opportunitiesPage{Opportunities: []json.RawMessage{}}
+	truncated := false
+	size := pageOverheadBytes
+	for i, item := range items {
+		var opp opportunity
+		if err := attributevalue.UnmarshalMap(item, &opp); err != nil {
+			return page, false, err
+		}
+		b, err := json.Marshal(opp)
+		if err != nil {
+			return page, false, err
+		}
+		if i > 0 && size+len(b)+1 > maxBytes {
+			lastKey = itemKey(items[i-1], filter)
+			truncated = true
+			break
+		}
+		size += len(b) + 1
+		page.Opportunities = append(page.Opportunities, b)
+	}
+
+	if len(lastKey) > 0 {
+		token, err := encodeNextToken(lastKey)
+		if err != nil {
+			return page, false, err
+		}
+		page.NextToken = token
+	}
+	return page, truncated, nil
+}
+
+// itemKey returns the key of item in the index queried for filter, which consists of
+// the table key and the index key.
+func itemKey(item map[string]types.AttributeValue, filter opportunityFilter) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		DDBTableKeyAttributeName: item[DDBTableKeyAttributeName],
+		filter.keyAttribute:      item[filter.keyAttribute],
+	}
+}
+
+// encodeNextToken encodes the key from which a query continues as an opaque, URL-safe token.
+func encodeNextToken(key map[string]types.AttributeValue) (string, error) {
+	var values map[string]string
+	if err := attributevalue.UnmarshalMap(key, &values); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeNextToken returns the key encoded by token, after verifying that the key continues
+// a query of the index for filter with the given value.
+// Returns ErrInvalidNextToken when token is malformed or was issued for a different query.
+func decodeNextToken(token string, filter opportunityFilter, value string) (map[string]types.AttributeValue, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidNextToken
+	}
+	var values map[string]string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, ErrInvalidNextToken
+	}
+	if len(values) != 2 || values[DDBTableKeyAttributeName] == "" || values[filter.keyAttribute] != value {
+		return nil, ErrInvalidNextToken
+	}
+	key, err := attributevalue.MarshalMap(values)
+	if err != nil {
+		return nil, ErrInvalidNextToken
+	}
+	return key, nil
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayV2HTTPResponse {
+	b, err := json.Marshal(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		b = []byte(`{"error":"internal error"}`)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(b),
+	}
+}
+
+func errorResponse(status int, message string) events.APIGatewayV2HTTPResponse {
+	return jsonResponse(status, errorBody{Error: message})
+}
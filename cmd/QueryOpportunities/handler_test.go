@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
+)
+
+func setupLambdaEnvForTesting(t *testing.T) {
+	t.Helper()
+
+	// Suppress normal lambda log output
+	logger = log.NewNopLogger()
+	sendMetric = func(metric string, value float64, tags ...string) {}
+
+	// Configure environment variables
+	err := goenv.Unmarshal(goenv.EnvSet{
+		"GRANTS_PREPARED_DYNAMODB_NAME": "test-table",
+		"DEFAULT_PAGE_SIZE":             "2",
+		"MAX_PAGE_SIZE":                 "10",
+	}, &env)
+	require.NoError(t, err, "Error configuring environment variables for testing")
+}
+
+type mockQueryAPI func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+
+func (m mockQueryAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return m(ctx, params, optFns...)
+}
+
+// indexItem returns a table item for an opportunity with the given ID, agency code, and CFDA number.
+func indexItem(id, agency, cfda string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"grant_id":            &types.AttributeValueMemberS{Value: id},
+		"OpportunityID":       &types.AttributeValueMemberS{Value: id},
+		"OpportunityTitle":    &types.AttributeValueMemberS{Value: "Opportunity " + id},
+		"AgencyCode":          &types.AttributeValueMemberS{Value: agency},
+		"primary_cfda_number": &types.AttributeValueMemberS{Value: cfda},
+		"CFDANumbers": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberS{Value: cfda},
+		}},
+		"revision": &types.AttributeValueMemberS{Value: "01H0000000000000000000000"},
+	}
+}
+
+func getRequest(params map[string]string) events.APIGatewayV2HTTPRequest {
+	req := events.APIGatewayV2HTTPRequest{RawPath: "/opportunities", QueryStringParameters: params}
+	req.RequestContext.HTTP.Method = http.MethodGet
+	return req
+}
+
+func decodePage(t *testing.T, resp events.APIGatewayV2HTTPResponse) (opportunitiesPage, []opportunity) {
+	t.Helper()
+	require.Equal(t, http.StatusOK, resp.StatusCode, resp.Body)
+	assert.Equal(t, "application/json", resp.Headers["Content-Type"])
+	var page opportunitiesPage
+	require.NoError(t, json.Unmarshal([]byte(resp.Body), &page))
+	opps := []opportunity{}
+	for _, raw := range page.Opportunities {
+		var opp opportunity
+		require.NoError(t, json.Unmarshal(raw, &opp))
+		opps = append(opps, opp)
+	}
+	return page, opps
+}
+
+func TestHandleRequestPagination(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	items := []map[string]types.AttributeValue{
+		indexItem("1", "HHS", "93.243"),
+		indexItem("2", "HHS-ACF", "93.243"),
+		indexItem("3", "HHS-ACF", "93.243"),
+	}
+	queries := []*dynamodb.QueryInput{}
+	// Simulates an index that holds items, returning pages in order of grant_id
+	client := mockQueryAPI(func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+		queries = append(queries, params)
+		start := 0
+		if params.ExclusiveStartKey != nil {
+			for i, item := range items {
+				if item["grant_id"].(*types.AttributeValueMemberS).Value ==
+					params.ExclusiveStartKey["grant_id"].(*types.AttributeValueMemberS).Value {
+					start = i + 1
+				}
+			}
+		}
+		end := start + int(*params.Limit)
+		if end >= len(items) {
+			return &dynamodb.QueryOutput{Items: items[start:]}, nil
+		}
+		return &dynamodb.QueryOutput{
+			Items:            items[start:end],
+			LastEvaluatedKey: itemKey(items[end-1], cfdaFilter),
+		}, nil
+	})
+
+	resp, err := handleRequest(context.TODO(), client, getRequest(map[string]string{"cfda": "93.243"}))
+	require.NoError(t, err)
+	page, opps := decodePage(t, resp)
+	require.Len(t, opps, 2)
+	assert.Equal(t, grantsgov.Number20DigitsType("1"), opps[0].OpportunityID)
+	assert.Equal(t, grantsgov.StringMin1Max255Type("HHS"), opps[0].AgencyCode)
+	assert.Equal(t, []grantsgov.CFDANumberType{"93.243"}, opps[0].CFDANumbers)
+	assert.Equal(t, grantsgov.Number20DigitsType("2"), opps[1].OpportunityID)
+	require.NotEmpty(t, page.NextToken)
+
+	require.Len(t, queries, 1)
+	assert.Equal(t, aws.String("test-table"), queries[0].TableName)
+	assert.Equal(t, aws.String("cfda_index"), queries[0].IndexName)
+	assert.Equal(t, aws.Int32(2), queries[0].Limit)
+	assert.Nil(t, queries[0].ExclusiveStartKey)
+	assert.Equal(t, "primary_cfda_number", queries[0].ExpressionAttributeNames["#0"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "93.243"}, queries[0].ExpressionAttributeValues[":0"])
+
+	resp, err = handleRequest(context.TODO(), client,
+		getRequest(map[string]string{"cfda": "93.243", "nextToken": page.NextToken}))
+	require.NoError(t, err)
+	page, opps = decodePage(t, resp)
+	require.Len(t, opps, 1)
+	assert.Equal(t, grantsgov.Number20DigitsType("3"), opps[0].OpportunityID)
+	assert.Empty(t, page.NextToken, "last page should not have a next token")
+	require.Len(t, queries, 2)
+	assert.Equal(t, itemKey(items[1], cfdaFilter), queries[1].ExclusiveStartKey)
+}
+
+func TestHandleRequestFilters(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	var query *dynamodb.QueryInput
+	client := mockQueryAPI(func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+		query = params
+		return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{}}, nil
+	})
+
+	t.Run("empty results", func(t *testing.T) {
+		resp, err := handleRequest(context.TODO(), client, getRequest(map[string]string{"agency": "HHS", "limit": "5"}))
+		require.NoError(t, err)
+		page, opps := decodePage(t, resp)
+		assert.Empty(t, opps)
+		assert.Empty(t, page.NextToken)
+		assert.JSONEq(t, `{"opportunities": []}`, resp.Body)
+		assert.Equal(t, aws.String("agency_index"), query.IndexName)
+		assert.Equal(t, "AgencyCode", query.ExpressionAttributeNames["#0"])
+		assert.Equal(t, aws.Int32(5), query.Limit)
+	})
+
+	t.Run("trailing slash", func(t *testing.T) {
+		req := getRequest(map[string]string{"agency": "HHS"})
+		req.RawPath = "/opportunities/"
+		resp, err := handleRequest(context.TODO(), client, req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		req := getRequest(map[string]string{"agency": "HHS"})
+		req.RawPath = "/grants"
+		resp, err := handleRequest(context.TODO(), client, req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("unsupported method", func(t *testing.T) {
+		req := getRequest(map[string]string{"agency": "HHS"})
+		req.RequestContext.HTTP.Method = http.MethodPost
+		resp, err := handleRequest(context.TODO(), client, req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Equal(t, http.MethodGet, resp.Headers["Allow"])
+	})
+}
+
+func TestHandleRequestBadParameters(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	client := mockQueryAPI(func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+		t.Error("malformed requests should not be queried")
+		return nil, errors.New("unexpected query")
+	})
+	cfdaToken, err := encodeNextToken(itemKey(indexItem("1", "HHS", "93.243"), cfdaFilter))
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name   string
+		params map[string]string
+		expErr error
+	}{
+		{"no filter", map[string]string{}, ErrFilterRequired},
+		{"both filters", map[string]string{"cfda": "93.243", "agency": "HHS"}, ErrFilterRequired},
+		{"unknown parameter", map[string]string{"cfda": "93.243", "sort": "asc"}, ErrUnknownParameter},
+		{"malformed CFDA number", map[string]string{"cfda": "93243"}, ErrInvalidCFDA},
+		{"empty CFDA number", map[string]string{"cfda": ""}, ErrInvalidCFDA},
+		{"malformed agency", map[string]string{"agency": "HHS; DROP"}, ErrInvalidAgency},
+		{"non-numeric limit", map[string]string{"agency": "HHS", "limit": "ten"}, ErrInvalidLimit},
+		{"zero limit", map[string]string{"agency": "HHS", "limit": "0"}, ErrInvalidLimit},
+		{"limit exceeds maximum page size", map[string]string{"agency": "HHS", "limit": "11"}, ErrInvalidLimit},
+		{"malformed next token", map[string]string{"cfda": "93.243", "nextToken": "not a token!"}, ErrInvalidNextToken},
+		{"next token for another value", map[string]string{"cfda": "93.110", "nextToken": cfdaToken}, ErrInvalidNextToken},
+		{"next token for another filter", map[string]string{"agency": "HHS", "nextToken": cfdaToken}, ErrInvalidNextToken},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseQuery(tt.params)
+			assert.ErrorIs(t, err, tt.expErr)
+
+			resp, err := handleRequest(context.TODO(), client, getRequest(tt.params))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			var body errorBody
+			require.NoError(t, json.Unmarshal([]byte(resp.Body), &body))
+			assert.Contains(t, body.Error, tt.expErr.Error())
+		})
+	}
+}
+
+func TestHandleRequestQueryFailure(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	client := mockQueryAPI(func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+		return nil, errors.New("throttled")
+	})
+	resp, err := handleRequest(context.TODO(), client, getRequest(map[string]string{"cfda": "93.243"}))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.NotContains(t, resp.Body, "throttled", "internal errors should not be exposed")
+}
+
+func TestBuildPageResponseSizeCap(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	items := []map[string]types.AttributeValue{
+		indexItem("1", "HHS", "93.243"),
+		indexItem("2", "HHS", "93.243"),
+		indexItem("3", "HHS", "93.243"),
+	}
+	lastKey := itemKey(items[2], agencyFilter)
+
+	page, truncated, err := buildPage(items, lastKey, agencyFilter, 1<<20)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	assert.Len(t, page.Opportunities, 3)
+
+	// Room for only two opportunities
+	maxBytes := pageOverheadBytes + len(page.Opportunities[0]) + len(page.Opportunities[1]) + 2
+	page, truncated, err = buildPage(items, lastKey, agencyFilter, maxBytes)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Len(t, page.Opportunities, 2)
+	startKey, err := decodeNextToken(page.NextToken, agencyFilter, "HHS")
+	require.NoError(t, err)
+	assert.Equal(t, itemKey(items[1], agencyFilter), startKey,
+		"next page should continue from the last opportunity that was included")
+
+	// At least one opportunity is always included
+	page, truncated, err = buildPage(items, lastKey, agencyFilter, 0)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Len(t, page.Opportunities, 1)
+}
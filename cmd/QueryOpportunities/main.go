@@ -0,0 +1,76 @@
+// Package main compiles to an AWS Lambda handler binary that serves read-only queries for
+// the grant opportunities persisted to the DynamoDB table identified by the
+// GRANTS_PREPARED_DYNAMODB_NAME environment variable. It is invoked by a Lambda Function URL
+// (or API Gateway HTTP API) request for GET /opportunities, which is filtered by either the
+// cfda or agency query parameter and answered by querying the corresponding index of the table.
+// The CFDA index is keyed by each opportunity's first CFDA number only, so opportunities are
+// not found by any of their other CFDA numbers.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+type Environment struct {
+	LogLevel         string `env:"LOG_LEVEL,default=INFO"`
+	DestinationTable string `env:"GRANTS_PREPARED_DYNAMODB_NAME,required=true"`
+	CFDAIndexName    string `env:"CFDA_INDEX_NAME,default=cfda_index"`
+	AgencyIndexName  string `env:"AGENCY_INDEX_NAME,default=agency_index"`
+	DefaultPageSize  int    `env:"DEFAULT_PAGE_SIZE,default=25"`
+	MaxPageSize      int    `env:"MAX_PAGE_SIZE,default=100"`
+	MaxResponseBytes int    `env:"MAX_RESPONSE_BYTES,default=4194304"`
+	TracingProvider  string `env:"TRACING_PROVIDER,default=datadog"`
+	Extras           goenv.EnvSet
+}
+
+var (
+	env        Environment
+	logger     log.Logger
+	sendMetric = ddHelpers.NewMetricSender("QueryOpportunities")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	if env.DefaultPageSize < 1 || env.DefaultPageSize > env.MaxPageSize {
+		goLog.Fatalf("error configuring environment variables: DEFAULT_PAGE_SIZE must be between 1 and MAX_PAGE_SIZE")
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{}, fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		dynamodbSvc := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {})
+
+		return handleRequest(ctx, dynamodbSvc, req)
+	}, nil))
+}
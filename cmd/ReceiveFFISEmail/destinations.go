@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+// S3 clients that act as the IAM roles assumed to archive emails (see destinationClient)
+var destinationClients = &assumedRoleClients{newClient: newAssumedRoleS3Client}
+
+// assumedRoleClients lazily creates and caches S3 clients by the ARN of the IAM role as which
+// they act. It is safe for concurrent use.
+type assumedRoleClients struct {
+	// newClient creates an S3 client that acts as the IAM role identified by roleARN.
+	newClient func(ctx context.Context, roleARN string) (S3API, error)
+
+	mu      sync.Mutex
+	clients map[string]S3API
+}
+
+// get returns the S3 client that acts as the IAM role identified by roleARN, creating it when
+// it does not exist. Clients are cached for the lifetime of the execution environment, since
+// their credentials are refreshed as they expire. Clients that could not be created are not
+// cached, so creating them is attempted again for the next email.
+func (c *assumedRoleClients) get(ctx context.Context, roleARN string) (S3API, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[roleARN]; ok {
+		return client, nil
+	}
+	client, err := c.newClient(ctx, roleARN)
+	if err != nil {
+		return nil, err
+	}
+	if c.clients == nil {
+		c.clients = make(map[string]S3API)
+	}
+	c.clients[roleARN] = client
+	return client, nil
+}
+
+// destinationClient returns the S3 client with which emails from source are archived, which is
+// client unless the source is configured with a destination role.
+// Returns an error wrapping awsHelpers.ErrAssumeRoleFailed when the role cannot be assumed.
+func destinationClient(ctx context.Context, client S3API, source SourceConfig) (S3API, error) {
+	if source.DestinationRoleARN == "" {
+		return client, nil
+	}
+	return destinationClients.get(ctx, source.DestinationRoleARN)
+}
+
+// newAssumedRoleS3Client creates an S3 client that acts as the IAM role identified by roleARN,
+// using credentials obtained from STS with the execution environment's own credentials.
+func newAssumedRoleS3Client(ctx context.Context, roleARN string) (S3API, error) {
+	cfg, err := awsHelpers.GetConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AWS SDK config: %w", err)
+	}
+	awstrace.AppendMiddleware(&cfg)
+	assumed, err := awsHelpers.AssumeRoleConfig(ctx, cfg, sts.NewFromConfig(cfg), roleARN)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(assumed, func(o *s3.Options) {
+		o.UsePathStyle = env.UsePathStyleS3Opt
+	}), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+)
+
+// mockSTSAPI fails every request to assume a role with err.
+type mockSTSAPI struct {
+	err   error
+	calls int
+}
+
+func (m *mockSTSAPI) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	m.calls++
+	return nil, m.err
+}
+
+func TestProcessEmailDestinationBucket(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { setupLambdaEnvForTesting(t) })
+	restoreDestinationClients := destinationClients
+	t.Cleanup(func() { destinationClients = restoreDestinationClients })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	roleARN := "arn:aws:iam::123456789012:role/state-sources-writer"
+	env.SourcesConfig = `[
+		{"keyPrefix": "ses/ffis_ingest/new/", "validSenders": ["example.org"], "destinationSubpath": "ffis.org"},
+		{"keyPrefix": "ses/state_updates/new/", "validSenders": ["example.org"], "destinationSubpath": "state_updates",
+			"destinationBucket": "state-sources", "destinationRoleArn": "` + roleARN + `"}
+	]`
+	sources, err = loadSourcesConfig(env)
+	require.NoError(t, err)
+	recordForKey := func(key string) events.S3EventRecord {
+		return events.S3EventRecord{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: "source-bucket"},
+			Object: events.S3Object{Key: key},
+		}}
+	}
+
+	t.Run("same-account default", func(t *testing.T) {
+		destinationClients = &assumedRoleClients{newClient: func(ctx context.Context, roleARN string) (S3API, error) {
+			t.Fatal("No role should be assumed")
+			return nil, nil
+		}}
+		client := &mockS3API{body: goodEmail}
+		require.NoError(t, processEmail(context.TODO(), client, recordForKey("ses/ffis_ingest/new/abc123")))
+		require.NotNil(t, client.copyObjectInput)
+		assert.Equal(t, env.DestinationBucket, aws.ToString(client.copyObjectInput.Bucket))
+		assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+	})
+
+	t.Run("per-source override", func(t *testing.T) {
+		destClient := &mockS3API{}
+		assumedRoles := []string{}
+		destinationClients = &assumedRoleClients{newClient: func(ctx context.Context, roleARN string) (S3API, error) {
+			assumedRoles = append(assumedRoles, roleARN)
+			return destClient, nil
+		}}
+		client := &mockS3API{body: goodEmail}
+		for _, key := range []string{"ses/state_updates/new/abc123", "ses/state_updates/new/def456"} {
+			require.NoError(t, processEmail(context.TODO(), client, recordForKey(key)))
+		}
+		assert.Nil(t, client.copyObjectInput, "Email should not be copied with the default client")
+		require.NotNil(t, destClient.copyObjectInput)
+		assert.Equal(t, 2, destClient.copyObjectCalls)
+		assert.Equal(t, "state-sources", aws.ToString(destClient.copyObjectInput.Bucket))
+		assert.Equal(t, "sources/2023/04/22/state_updates/raw.eml", aws.ToString(destClient.copyObjectInput.Key))
+		assert.Equal(t, []string{roleARN}, assumedRoles, "Client for the role should be cached")
+	})
+
+	t.Run("STS failure", func(t *testing.T) {
+		restoreRetryClasses := pipelineRetryErrorClasses
+		t.Cleanup(func() { pipelineRetryErrorClasses = restoreRetryClasses })
+		pipelineRetryErrorClasses = parsePipelineRetryErrorClasses("AccessDenied,AssumeRoleFailed")
+		mockSTS := &mockSTSAPI{err: &smithy.GenericAPIError{Code: "AccessDenied"}}
+		destinationClients = &assumedRoleClients{newClient: func(ctx context.Context, roleARN string) (S3API, error) {
+			_, err := awsHelpers.AssumeRoleConfig(ctx, aws.Config{}, mockSTS, roleARN)
+			return nil, err
+		}}
+		client := &mockS3API{body: goodEmail}
+		err := processEmail(context.TODO(), client, recordForKey("ses/state_updates/new/abc123"))
+		assert.ErrorIs(t, err, awsHelpers.ErrAssumeRoleFailed)
+		assert.ErrorContains(t, err, roleARN)
+		assert.Equal(t, "AssumeRoleFailed", eventHelpers.ClassifyError(err))
+		assert.False(t, isRecoverablePipelineError(err), "Failure to assume a role should be permanent")
+		assert.Nil(t, client.copyObjectInput)
+		assert.Equal(t, float64(1), sentMetrics["destination.assume_role_failed"])
+
+		// Failures are not cached
+		err = processEmail(context.TODO(), client, recordForKey("ses/state_updates/new/abc123"))
+		assert.ErrorIs(t, err, awsHelpers.ErrAssumeRoleFailed)
+		assert.Equal(t, 2, mockSTS.calls)
+	})
+}
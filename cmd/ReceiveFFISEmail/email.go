@@ -4,51 +4,176 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/mail"
+	"net/textproto"
 	"strings"
 	"time"
+	"unicode"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/emailHelpers"
+)
+
+// Policies for handling emails that fail sender verification
+const (
+	UnknownSenderPolicyReject         = "reject"
+	UnknownSenderPolicyQuarantine     = "quarantine"
+	UnknownSenderPolicyArchiveFlagged = "archive_flagged"
+)
+
+// Policies for verifying emails whose From header(s) carry multiple addresses
+const (
+	// FromAddressPolicyStrict requires every From address to be an allowed sender.
+	FromAddressPolicyStrict = "strict"
+	// FromAddressPolicyLenient requires at least one From address to be an allowed sender.
+	FromAddressPolicyLenient = "lenient"
+)
+
+// Preferences for the header from which an email's date is taken
+const (
+	// DateHeaderPreferenceDate keys emails by their Date header.
+	DateHeaderPreferenceDate = "date"
+	// DateHeaderPreferenceResentDate keys emails by their (most recent) Resent-Date header when
+	// present and parseable, or else by their Date header.
+	DateHeaderPreferenceResentDate = "resent_date"
+)
+
+// Reasons for which an otherwise-trusted email may be quarantined
+const (
+	QuarantineReasonUnexpectedAttachment = "unexpected_attachment"
+	QuarantineReasonDKIMCheckFailed      = "dkim_failed"
+	QuarantineReasonSPFSoftFail          = "spf_softfail"
 )
 
 var (
 	ErrEmailUnrecognizedSender  = errors.New("email has unrecognized sender")
+	ErrEmailSuspectedSpoof      = errors.New("email sender name imitates a trusted organization")
 	ErrEmailSpamCheckFailed     = errors.New("email spam check failed")
 	ErrEmailVirusCheckFailed    = errors.New("email virus check failed")
 	ErrEmailSPFCheckFailed      = errors.New("email SPF check failed")
-	ErrEmailFailedToParse       = errors.New("failed to parse email")
-	ErrEmailDateFailedToParse   = errors.New("failed to parse email date")
-	ErrEmailSenderFailedToParse = errors.New("failed to parse email sender")
+	ErrEmailDKIMCheckFailed     = errors.New("email DKIM check failed")
+	ErrUnknownSenderPolicy      = errors.New("unknown sender policy")
+	ErrNoSenderAllowlist        = errors.New("no allowed email senders are configured")
+	ErrUnknownFromAddressPolicy = errors.New("unknown From address policy")
+	ErrUnknownDateHeaderPref    = errors.New("unknown date header preference")
+	ErrMimeTooDeep              = errors.New("MIME parts are nested too deeply")
 )
 
-func parseEmailContents(r io.Reader) (msg *mail.Message, sender *mail.Address, date time.Time, err error) {
-	msg, err = mail.ReadMessage(r)
-	if err != nil {
-		err = fmt.Errorf("%w: %w", ErrEmailFailedToParse, err)
-		return
+// validateUnknownSenderPolicy returns an error wrapping ErrUnknownSenderPolicy when policy is not
+// a supported UNKNOWN_SENDER_POLICY value.
+func validateUnknownSenderPolicy(policy string) error {
+	switch policy {
+	case UnknownSenderPolicyReject, UnknownSenderPolicyQuarantine, UnknownSenderPolicyArchiveFlagged:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownSenderPolicy, policy)
 	}
+}
 
-	p := mail.AddressParser{}
-	sender, err = p.Parse(msg.Header.Get("From"))
-	if err != nil {
-		err = fmt.Errorf("%w: %w", ErrEmailSenderFailedToParse, err)
-		return
+// validateFromAddressPolicy returns an error wrapping ErrUnknownFromAddressPolicy when policy
+// is not a supported FROM_ADDRESS_POLICY value.
+func validateFromAddressPolicy(policy string) error {
+	switch policy {
+	case FromAddressPolicyStrict, FromAddressPolicyLenient:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFromAddressPolicy, policy)
 	}
+}
 
-	date, err = msg.Header.Date()
-	if err != nil {
-		err = fmt.Errorf("%w: %w", ErrEmailDateFailedToParse, err)
-		return
+// validateDateHeaderPreference returns an error wrapping ErrUnknownDateHeaderPref when
+// preference is not a supported DATE_HEADER_PREFERENCE value.
+func validateDateHeaderPreference(preference string) error {
+	switch preference {
+	case DateHeaderPreferenceDate, DateHeaderPreferenceResentDate:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownDateHeaderPref, preference)
 	}
+}
 
-	return
+// dateHeadersDisagree reports whether the dates of the Date and Resent-Date headers of an
+// email differ by more than tolerance.
+func dateHeadersDisagree(date, resent time.Time, tolerance time.Duration) bool {
+	diff := date.Sub(resent)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff > tolerance
+}
+
+func verifyEmailIsTrusted(msg *mail.Message, sender *mail.Address, allowedSenders []string) error {
+	if err := verifyEmailSender(msg, sender, allowedSenders); err != nil {
+		return err
+	}
+	return verifyEmailContents(msg)
 }
 
-func verifyEmailIsTrusted(msg *mail.Message, sender *mail.Address) error {
-	allowedFromDomains := strings.Split(env.AllowedEmailSenders, ",")
-	if !emailAddressAllowed(sender.Address, allowedFromDomains...) {
+// verifyEmailSender verifies that the email was sent by a recognized sender, i.e. that the
+// sender's address or domain is in allowedSenders and (when strict DKIM checking is enabled)
+// that the email passed DKIM verification.
+// When the email has multiple From addresses, they are verified according to
+// env.FromAddressPolicy: either all of them (strict) or at least one of them (lenient)
+// must be allowed.
+// Returns ErrNoSenderAllowlist when allowedSenders is empty, since no sender can be recognized.
+func verifyEmailSender(msg *mail.Message, sender *mail.Address, allowedSenders []string) error {
+	if len(allowedSenders) == 0 {
+		return ErrNoSenderAllowlist
+	}
+	if !fromAddressesAllowed(senderAddresses(msg, sender), allowedSenders) {
 		return ErrEmailUnrecognizedSender
 	}
+	if env.StrictDKIMCheck {
+		if err := checkEmailDKIM(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// senderAddresses returns every From address of msg or, when these cannot be parsed, sender.
+func senderAddresses(msg *mail.Message, sender *mail.Address) []*mail.Address {
+	if msg != nil {
+		if from, err := emailHelpers.FromAddresses(msg.Header); err == nil {
+			return from
+		}
+	}
+	return []*mail.Address{sender}
+}
+
+// formatAddresses formats addresses as they would appear in a From header.
+func formatAddresses(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, addr := range addresses {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// fromAddressesAllowed returns true when the given From addresses satisfy env.FromAddressPolicy,
+// i.e. when every address (strict) or any address (lenient) is in allowedSenders.
+func fromAddressesAllowed(addresses []*mail.Address, allowedSenders []string) bool {
+	allowed := 0
+	for _, addr := range addresses {
+		if emailAddressAllowed(addr.Address, allowedSenders...) {
+			allowed++
+		}
+	}
+	if env.FromAddressPolicy == FromAddressPolicyLenient {
+		return allowed > 0
+	}
+	return allowed > 0 && allowed == len(addresses)
+}
+
+// verifyEmailContents verifies the SPF, spam, and virus verdicts of the email, regardless of
+// its sender.
+func verifyEmailContents(msg *mail.Message) error {
 	if err := checkEmailSPF(msg); err != nil {
-		return err
+		// SPF soft-failures are quarantined (rather than rejected) when quarantine mode is enabled
+		if !(env.QuarantineSuspiciousEmails && emailSPFSoftFailed(msg)) {
+			return err
+		}
 	}
 	if err := checkEmailSpam(msg); err != nil {
 		return err
@@ -59,16 +184,73 @@ func verifyEmailIsTrusted(msg *mail.Message, sender *mail.Address) error {
 	return nil
 }
 
+// defaultOrganizationNames are the names of the organization that sends FFIS emails, which
+// are used when ORGANIZATION_NAMES is not set.
+var defaultOrganizationNames = []string{"FFIS", "Federal Funds Information for States"}
+
+// organizationNames are the names that only trusted senders may use as their display name
+// (see imitatedOrganization), as configured by env.OrganizationNames.
+var organizationNames = defaultOrganizationNames
+
+// parseOrganizationNames parses the comma-separated list of organization names configured
+// by ORGANIZATION_NAMES, defaulting to defaultOrganizationNames when it lists no names.
+func parseOrganizationNames(s string) []string {
+	names := []string{}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return defaultOrganizationNames
+	}
+	return names
+}
+
+// normalizeDisplayName normalizes the display name of an email address for comparison with
+// organization names: letters are lower-cased, dots are removed (so that "F.F.I.S." matches
+// "FFIS"), and any other runs of non-alphanumeric characters become single spaces.
+func normalizeDisplayName(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), ".", "")
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// imitatedOrganization returns the organization name (from organizations) that is imitated by
+// the display name of a From address which is not in allowedSenders, e.g. "FFIS Updates" from
+// an address outside of the FFIS domain. A display name imitates an organization when it
+// contains the organization's name as whole words. Returns false when no address imitates
+// an organization.
+func imitatedOrganization(addresses []*mail.Address, allowedSenders, organizations []string) (string, *mail.Address, bool) {
+	for _, addr := range addresses {
+		if addr.Name == "" || emailAddressAllowed(addr.Address, allowedSenders...) {
+			continue
+		}
+		name := " " + normalizeDisplayName(addr.Name) + " "
+		for _, organization := range organizations {
+			if normalized := normalizeDisplayName(organization); normalized != "" &&
+				strings.Contains(name, " "+normalized+" ") {
+				return organization, addr, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
 // checkEmailAddress determines whether a given email address matches one or more items
 // in an allow list, which may be populated with a combination of email addresses and domain names.
 // Returns true when emailAddress matches an item in allowList, or else returns false
-// after match candidates are exhausted.
+// after match candidates are exhausted. Blank items in allowList never match.
 // Note that this function does NOT determine email address validity or deliverability.
 // See normalizeEmailAddress for more information on normalization/comparability behavior.
 func emailAddressAllowed(emailAddress string, allowList ...string) bool {
 	_, domain, emailAddress := normalizeEmailAddress(emailAddress)
 	for _, allowed := range allowList {
 		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "" {
+			continue
+		}
 
 		if strings.Contains(allowed, "@") {
 			// Allowed item is an email address – check if normalized values match
@@ -123,3 +305,85 @@ func checkEmailVirus(msg *mail.Message) error {
 	}
 	return nil
 }
+
+// emailSPFSoftFailed returns true when the email's SPF result is "softfail", which indicates
+// that the sending domain discourages, but does not prohibit, use of the sending host.
+func emailSPFSoftFailed(msg *mail.Message) bool {
+	return strings.HasPrefix(msg.Header.Get("Received-SPF"), "softfail")
+}
+
+// checkEmailDKIM requires that the email's Authentication-Results header reports a
+// passing DKIM result.
+func checkEmailDKIM(msg *mail.Message) error {
+	if result, _ := emailDKIMResult(msg); result != "pass" {
+		return ErrEmailDKIMCheckFailed
+	}
+	return nil
+}
+
+// emailDKIMResult returns the DKIM result (e.g. "pass" or "fail") from the email's
+// Authentication-Results header. The returned bool is false when no DKIM result is present.
+func emailDKIMResult(msg *mail.Message) (string, bool) {
+	for _, field := range strings.Split(msg.Header.Get("Authentication-Results"), ";") {
+		method, result, found := strings.Cut(strings.TrimSpace(field), "=")
+		if found && strings.EqualFold(method, "dkim") {
+			if fields := strings.Fields(result); len(fields) > 0 {
+				return strings.ToLower(fields[0]), true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// suspiciousEmailReasons inspects an email that has already been verified as trusted and
+// returns the reasons (if any) for which it should nonetheless be quarantined for review.
+// Note that this function consumes the message body.
+func suspiciousEmailReasons(msg *mail.Message) ([]string, error) {
+	reasons := []string{}
+	if emailSPFSoftFailed(msg) {
+		reasons = append(reasons, QuarantineReasonSPFSoftFail)
+	}
+	if result, found := emailDKIMResult(msg); found && result != "pass" {
+		reasons = append(reasons, QuarantineReasonDKIMCheckFailed)
+	}
+	hasAttachment, err := mimePartHasAttachment(textproto.MIMEHeader(msg.Header), msg.Body, 0)
+	if err != nil {
+		return reasons, err
+	}
+	if hasAttachment {
+		reasons = append(reasons, QuarantineReasonUnexpectedAttachment)
+	}
+	return reasons, nil
+}
+
+// mimePartHasAttachment returns true if the MIME part described by header and body, or any
+// of its descendant parts, is an attachment. The part is nested within depth multipart parts.
+// Returns an error wrapping ErrMimeTooDeep rather than descending into multipart parts that
+// are nested more than env.MaxMIMEDepth levels deep (unless MaxMIMEDepth is 0).
+func mimePartHasAttachment(header textproto.MIMEHeader, body io.Reader, depth int) (bool, error) {
+	disposition, _, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err == nil && disposition == "attachment" {
+		return true, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return false, nil
+	}
+	if env.MaxMIMEDepth > 0 && depth >= env.MaxMIMEDepth {
+		return false, fmt.Errorf("%w: exceeds maximum depth of %d", ErrMimeTooDeep, env.MaxMIMEDepth)
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if found, err := mimePartHasAttachment(part.Header, part, depth+1); found || err != nil {
+			return found, err
+		}
+	}
+}
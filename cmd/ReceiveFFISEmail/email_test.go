@@ -1,37 +1,16 @@
 package main
 
 import (
+	"fmt"
+	"net/mail"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/emailHelpers"
 )
 
-func TestParseEmailContents(t *testing.T) {
-	for _, tt := range []struct {
-		name          string
-		pathToFixture string
-		expError      error
-	}{
-		{"empty email file", "fixtures/bad_empty.eml", ErrEmailFailedToParse},
-		{"invalid email file", "fixtures/bad_data.eml", ErrEmailFailedToParse},
-		{"unparseable sender", "fixtures/bad_from.eml", ErrEmailSenderFailedToParse},
-		{"missing sender", "fixtures/bad_fromMissing.eml", ErrEmailSenderFailedToParse},
-		{"unparseable date", "fixtures/bad_date.eml", ErrEmailDateFailedToParse},
-		{"missing date", "fixtures/bad_dateMissing.eml", ErrEmailDateFailedToParse},
-	} {
-		t.Run(tt.name, func(t *testing.T) {
-			_, _, _, err := parseEmailContents(getFixture(t, tt.pathToFixture))
-			if tt.expError != nil {
-				assert.Error(t, err)
-				assert.ErrorIs(t, err, tt.expError)
-			} else {
-				assert.NoError(t, err)
-			}
-		})
-	}
-}
-
 func TestVerifyEmailIsTrusted(t *testing.T) {
 	setupLambdaEnvForTesting(t)
 
@@ -47,10 +26,10 @@ func TestVerifyEmailIsTrusted(t *testing.T) {
 		{"fail address check", "fixtures/bad_sender.eml", ErrEmailUnrecognizedSender},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			msg, sender, _, err := parseEmailContents(getFixture(t, tt.pathToFixture))
+			msg, sender, _, err := emailHelpers.ParseContents(getFixture(t, tt.pathToFixture))
 			require.NoError(t, err)
 
-			assert.ErrorIs(t, verifyEmailIsTrusted(msg, sender), tt.expError)
+			assert.ErrorIs(t, verifyEmailIsTrusted(msg, sender, sources[0].ValidSenders), tt.expError)
 		})
 	}
 }
@@ -86,9 +65,197 @@ func TestCheckEmailAddress(t *testing.T) {
 			{"someone@example.com", []string{"another@example.com"}},
 			{"someone@example.com", []string{"example.net", "example.org"}},
 			{"some.one@example.com", []string{"example.net", "example.org"}},
+			{"someone", []string{""}},
+			{"someone@", []string{" "}},
+			{"someone@example.com", []string{}},
 		} {
 			assert.False(t, emailAddressAllowed(tt.address, tt.allowList...),
 				"Email %q unexpectedly matched by allow-list %q", tt.address, tt.allowList)
 		}
 	})
 }
+
+func TestVerifyEmailIsTrustedQuarantineMode(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.QuarantineSuspiciousEmails, env.StrictDKIMCheck = false, false })
+
+	for _, tt := range []struct {
+		name          string
+		pathToFixture string
+		quarantine    bool
+		strictDKIM    bool
+		expError      error
+	}{
+		{"SPF soft-fail rejected by default", "fixtures/suspicious_spfSoftfail.eml", false, false, ErrEmailSPFCheckFailed},
+		{"SPF soft-fail allowed in quarantine mode", "fixtures/suspicious_spfSoftfail.eml", true, false, nil},
+		{"DKIM failure allowed when not strict", "fixtures/suspicious_dkimFail.eml", true, false, nil},
+		{"DKIM failure rejected when strict", "fixtures/suspicious_dkimFail.eml", true, true, ErrEmailDKIMCheckFailed},
+		{"missing DKIM result rejected when strict", "fixtures/good.eml", false, true, ErrEmailDKIMCheckFailed},
+		{"hard SPF failure rejected in quarantine mode", "fixtures/bad_spf.eml", true, false, ErrEmailSPFCheckFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env.QuarantineSuspiciousEmails, env.StrictDKIMCheck = tt.quarantine, tt.strictDKIM
+			msg, sender, _, err := emailHelpers.ParseContents(getFixture(t, tt.pathToFixture))
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, verifyEmailIsTrusted(msg, sender, sources[0].ValidSenders), tt.expError)
+		})
+	}
+}
+
+func TestSuspiciousEmailReasons(t *testing.T) {
+	for _, tt := range []struct {
+		pathToFixture string
+		expReasons    []string
+	}{
+		{"fixtures/good.eml", []string{}},
+		{"fixtures/good_base64Body.eml", []string{}},
+		{"fixtures/suspicious_attachment.eml", []string{QuarantineReasonUnexpectedAttachment}},
+		{"fixtures/suspicious_dkimFail.eml", []string{QuarantineReasonDKIMCheckFailed}},
+		{"fixtures/suspicious_spfSoftfail.eml", []string{QuarantineReasonSPFSoftFail}},
+	} {
+		t.Run(tt.pathToFixture, func(t *testing.T) {
+			msg, _, _, err := emailHelpers.ParseContents(getFixture(t, tt.pathToFixture))
+			require.NoError(t, err)
+
+			reasons, err := suspiciousEmailReasons(msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expReasons, reasons)
+		})
+	}
+}
+
+func TestVerifyEmailSenderEmptyAllowlist(t *testing.T) {
+	for _, allowList := range [][]string{nil, {}} {
+		err := verifyEmailSender(nil, &mail.Address{Address: "someone@example.com"}, allowList)
+		assert.ErrorIs(t, err, ErrNoSenderAllowlist,
+			"sender should be rejected, not accepted, when allowlist is %q", allowList)
+	}
+}
+
+func TestVerifyEmailSenderMultipleFromAddresses(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.FromAddressPolicy = FromAddressPolicyStrict })
+
+	for _, tt := range []struct {
+		name       string
+		fromHeader string
+		policy     string
+		expError   error
+	}{
+		{"all allowed in one header (strict)", "From: a@example.org, b@example.org", FromAddressPolicyStrict, nil},
+		{"all allowed in one header (lenient)", "From: a@example.org, b@example.org", FromAddressPolicyLenient, nil},
+		{"first allowed in one header (strict)", "From: a@example.org, b@example.com", FromAddressPolicyStrict, ErrEmailUnrecognizedSender},
+		{"first allowed in one header (lenient)", "From: a@example.org, b@example.com", FromAddressPolicyLenient, nil},
+		{"last allowed in one header (strict)", "From: a@example.com, b@example.org", FromAddressPolicyStrict, ErrEmailUnrecognizedSender},
+		{"last allowed in one header (lenient)", "From: a@example.com, b@example.org", FromAddressPolicyLenient, nil},
+		{"none allowed in one header (lenient)", "From: a@example.com, b@example.net", FromAddressPolicyLenient, ErrEmailUnrecognizedSender},
+		{"all allowed in two headers (strict)", "From: a@example.org\r\nFrom: b@example.org", FromAddressPolicyStrict, nil},
+		{"first allowed in two headers (strict)", "From: a@example.org\r\nFrom: b@example.com", FromAddressPolicyStrict, ErrEmailUnrecognizedSender},
+		{"first allowed in two headers (lenient)", "From: a@example.org\r\nFrom: b@example.com", FromAddressPolicyLenient, nil},
+		{"last allowed in two headers (strict)", "From: a@example.com\r\nFrom: b@example.org", FromAddressPolicyStrict, ErrEmailUnrecognizedSender},
+		{"last allowed in two headers (lenient)", "From: a@example.com\r\nFrom: b@example.org", FromAddressPolicyLenient, nil},
+		{"none allowed in two headers (lenient)", "From: a@example.com\r\nFrom: b@example.net", FromAddressPolicyLenient, ErrEmailUnrecognizedSender},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env.FromAddressPolicy = tt.policy
+			raw := tt.fromHeader + "\r\nDate: Mon, 02 Jan 2023 15:04:05 -0500\r\n\r\nHello\r\n"
+			msg, sender, _, err := emailHelpers.ParseContents(strings.NewReader(raw))
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(sender.Address, "a@"),
+				"sender should be the first From address")
+
+			assert.ErrorIs(t, verifyEmailSender(msg, sender, sources[0].ValidSenders), tt.expError)
+		})
+	}
+}
+
+func TestValidateFromAddressPolicy(t *testing.T) {
+	assert.NoError(t, validateFromAddressPolicy(FromAddressPolicyStrict))
+	assert.NoError(t, validateFromAddressPolicy(FromAddressPolicyLenient))
+	assert.ErrorIs(t, validateFromAddressPolicy("sometimes"), ErrUnknownFromAddressPolicy)
+}
+
+func TestValidateDateHeaderPreference(t *testing.T) {
+	assert.NoError(t, validateDateHeaderPreference(DateHeaderPreferenceDate))
+	assert.NoError(t, validateDateHeaderPreference(DateHeaderPreferenceResentDate))
+	assert.ErrorIs(t, validateDateHeaderPreference("received"), ErrUnknownDateHeaderPref)
+}
+
+func TestSuspiciousEmailReasonsMaxMIMEDepth(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.MaxMIMEDepth = 10 })
+
+	for _, tt := range []struct {
+		maxDepth int
+		expError error
+	}{
+		{0, nil},
+		{12, nil},
+		{11, ErrMimeTooDeep},
+		{1, ErrMimeTooDeep},
+	} {
+		t.Run(fmt.Sprintf("maximum depth %d", tt.maxDepth), func(t *testing.T) {
+			env.MaxMIMEDepth = tt.maxDepth
+			msg, _, _, err := emailHelpers.ParseContents(getFixture(t, "fixtures/suspicious_deeplyNested.eml"))
+			require.NoError(t, err)
+
+			reasons, err := suspiciousEmailReasons(msg)
+			if tt.expError != nil {
+				assert.ErrorIs(t, err, tt.expError)
+			} else {
+				assert.NoError(t, err)
+				assert.Empty(t, reasons)
+			}
+		})
+	}
+
+	t.Run("default depth permits typical emails", func(t *testing.T) {
+		env.MaxMIMEDepth = 10
+		msg, _, _, err := emailHelpers.ParseContents(getFixture(t, "fixtures/suspicious_attachment.eml"))
+		require.NoError(t, err)
+		reasons, err := suspiciousEmailReasons(msg)
+		require.NoError(t, err)
+		assert.Equal(t, []string{QuarantineReasonUnexpectedAttachment}, reasons)
+	})
+}
+
+func TestParseOrganizationNames(t *testing.T) {
+	assert.Equal(t, defaultOrganizationNames, parseOrganizationNames(""))
+	assert.Equal(t, defaultOrganizationNames, parseOrganizationNames(" , "))
+	assert.Equal(t, []string{"FFIS", "Grants Office"}, parseOrganizationNames(" FFIS,,Grants Office "))
+}
+
+func TestImitatedOrganization(t *testing.T) {
+	allowedSenders := []string{"ffis.org"}
+	for _, tt := range []struct {
+		name            string
+		fromHeader      string
+		expOrganization string
+		expImitator     string
+	}{
+		{"spoofed acronym", `"FFIS Updates" <randomaddr@gmail.com>`, "FFIS", "randomaddr@gmail.com"},
+		{"spoofed acronym with dots", `"F.F.I.S." <randomaddr@gmail.com>`, "FFIS", "randomaddr@gmail.com"},
+		{"spoofed full name", `"federal funds information for states - Team" <team@example.com>`,
+			"Federal Funds Information for States", "team@example.com"},
+		{"second address spoofed", `news@ffis.org, "FFIS-Alerts" <alerts@example.net>`, "FFIS", "alerts@example.net"},
+		{"legitimate sender", `"FFIS Updates" <news@ffis.org>`, "", ""},
+		{"unrelated sender", `"Unknown Person" <whoami@unrecognizeddomain.xyz>`, "", ""},
+		{"name containing acronym", `"Jeff Fisher" <jffisher@example.com>`, "", ""},
+		{"address without name", `ffis@gmail.com`, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses, err := mail.ParseAddressList(tt.fromHeader)
+			require.NoError(t, err)
+			organization, imitator, ok := imitatedOrganization(addresses, allowedSenders, defaultOrganizationNames)
+			assert.Equal(t, tt.expOrganization != "", ok)
+			assert.Equal(t, tt.expOrganization, organization)
+			if tt.expImitator != "" {
+				require.NotNil(t, imitator)
+				assert.Equal(t, tt.expImitator, imitator.Address)
+			} else {
+				assert.Nil(t, imitator)
+			}
+		})
+	}
+}
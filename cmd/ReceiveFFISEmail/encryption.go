@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var ErrInvalidEncryptionConfig = errors.New("invalid encryption configuration")
+
+// Encryption of archived emails, by default and by sender (see loadEncryptionConfig)
+var (
+	destinationEncryption = EncryptionConfig{ServerSideEncryption: string(types.ServerSideEncryptionAes256)}
+	senderEncryption      map[string]EncryptionConfig
+)
+
+// EncryptionConfig describes the server-side encryption of emails archived in the destination bucket.
+type EncryptionConfig struct {
+	// ServerSideEncryption is either "AES256" (SSE-S3) or "aws:kms" (SSE-KMS).
+	ServerSideEncryption string `json:"sse"`
+	// KMSKeyID is the ID or ARN of the KMS key used for SSE-KMS encryption.
+	// When empty, SSE-KMS encryption uses the AWS managed key for S3.
+	KMSKeyID string `json:"kmsKeyId,omitempty"`
+}
+
+func (c EncryptionConfig) validate() error {
+	switch types.ServerSideEncryption(c.ServerSideEncryption) {
+	case types.ServerSideEncryptionAes256:
+		if c.KMSKeyID != "" {
+			return fmt.Errorf("%w: a KMS key ID requires %s encryption",
+				ErrInvalidEncryptionConfig, types.ServerSideEncryptionAwsKms)
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("%w: unsupported server-side encryption %q",
+			ErrInvalidEncryptionConfig, c.ServerSideEncryption)
+	}
+	return nil
+}
+
+// applyToCopy configures input to encrypt the copied object according to c.
+func (c EncryptionConfig) applyToCopy(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption = types.ServerSideEncryption(c.ServerSideEncryption)
+	input.SSEKMSKeyId = nil
+	if c.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(c.KMSKeyID)
+	}
+}
+
+// loadEncryptionConfig returns the global encryption configuration given by DESTINATION_SSE
+// and DESTINATION_KMS_KEY_ID, along with the per-sender encryption configurations given by the
+// SENDER_ENCRYPTION_CONFIG JSON object, which maps sender email addresses and/or domains to
+// the encryption configuration used for their emails, e.g.
+// {"ffis.org": {"sse": "aws:kms", "kmsKeyId": "alias/ffis"}, "someone@example.com": {"sse": "AES256"}}
+// The keys of the returned map are normalized (see normalizeEmailAddress).
+func loadEncryptionConfig(env Environment) (EncryptionConfig, map[string]EncryptionConfig, error) {
+	global := EncryptionConfig{ServerSideEncryption: env.DestinationSSE, KMSKeyID: env.DestinationKMSKeyID}
+	if err := global.validate(); err != nil {
+		return global, nil, err
+	}
+
+	bySender := map[string]EncryptionConfig{}
+	if strings.TrimSpace(env.SenderEncryptionConfig) == "" {
+		return global, bySender, nil
+	}
+	var configs map[string]EncryptionConfig
+	if err := json.Unmarshal([]byte(env.SenderEncryptionConfig), &configs); err != nil {
+		return global, nil, fmt.Errorf("%w: %w", ErrInvalidEncryptionConfig, err)
+	}
+	for sender, config := range configs {
+		if err := config.validate(); err != nil {
+			return global, nil, fmt.Errorf("sender %q: %w", sender, err)
+		}
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if strings.Contains(sender, "@") {
+			_, _, sender = normalizeEmailAddress(sender)
+		}
+		if sender == "" {
+			return global, nil, fmt.Errorf("%w: sender must not be empty", ErrInvalidEncryptionConfig)
+		}
+		bySender[sender] = config
+	}
+	return global, bySender, nil
+}
+
+// encryptionForSender returns the encryption configuration for emails sent from emailAddress,
+// which is the configuration for that address, or else for its domain, or else the global
+// configuration. The returned string is the address or domain whose configuration was
+// selected, and is empty when the global configuration was selected.
+func encryptionForSender(emailAddress string) (EncryptionConfig, string) {
+	_, domain, normalized := normalizeEmailAddress(emailAddress)
+	if config, ok := senderEncryption[normalized]; ok {
+		return config, normalized
+	}
+	if config, ok := senderEncryption[domain]; ok {
+		return config, domain
+	}
+	return destinationEncryption, ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEncryptionConfig(t *testing.T) {
+	t.Run("global configuration only", func(t *testing.T) {
+		global, bySender, err := loadEncryptionConfig(Environment{DestinationSSE: "AES256"})
+		require.NoError(t, err)
+		assert.Equal(t, EncryptionConfig{ServerSideEncryption: "AES256"}, global)
+		assert.Empty(t, bySender)
+	})
+
+	t.Run("per-sender configuration", func(t *testing.T) {
+		global, bySender, err := loadEncryptionConfig(Environment{
+			DestinationSSE:      "aws:kms",
+			DestinationKMSKeyID: "alias/default",
+			SenderEncryptionConfig: `{
+				"FFIS.org": {"sse": "aws:kms", "kmsKeyId": "alias/ffis"},
+				" Some.Person+grants@example.org ": {"sse": "AES256"}
+			}`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, EncryptionConfig{ServerSideEncryption: "aws:kms", KMSKeyID: "alias/default"}, global)
+		assert.Equal(t, map[string]EncryptionConfig{
+			"ffis.org":               {ServerSideEncryption: "aws:kms", KMSKeyID: "alias/ffis"},
+			"someperson@example.org": {ServerSideEncryption: "AES256"},
+		}, bySender)
+	})
+
+	for _, tt := range []struct {
+		name string
+		env  Environment
+	}{
+		{"unsupported global encryption", Environment{DestinationSSE: "none"}},
+		{"global KMS key without KMS encryption", Environment{DestinationSSE: "AES256", DestinationKMSKeyID: "alias/default"}},
+		{"malformed JSON", Environment{DestinationSSE: "AES256", SenderEncryptionConfig: `{"ffis.org":`}},
+		{"unsupported sender encryption", Environment{DestinationSSE: "AES256", SenderEncryptionConfig: `{"ffis.org": {"sse": "aws:kms:dsse"}}`}},
+		{"sender KMS key without KMS encryption", Environment{DestinationSSE: "AES256", SenderEncryptionConfig: `{"ffis.org": {"sse": "AES256", "kmsKeyId": "alias/ffis"}}`}},
+		{"empty sender", Environment{DestinationSSE: "AES256", SenderEncryptionConfig: `{" ": {"sse": "AES256"}}`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadEncryptionConfig(tt.env)
+			assert.ErrorIs(t, err, ErrInvalidEncryptionConfig)
+		})
+	}
+}
+
+func TestProcessEmailSenderEncryption(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	restoreGlobal, restoreBySender := destinationEncryption, senderEncryption
+	t.Cleanup(func() { destinationEncryption, senderEncryption = restoreGlobal, restoreBySender })
+	env.DestinationSSE = "AES256"
+	env.SenderEncryptionConfig = `{
+		"example.org": {"sse": "aws:kms", "kmsKeyId": "alias/example-org"},
+		"some.person@example.org": {"sse": "aws:kms", "kmsKeyId": "alias/some-person"}
+	}`
+	var err error
+	destinationEncryption, senderEncryption, err = loadEncryptionConfig(env)
+	require.NoError(t, err)
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	for _, tt := range []struct {
+		name        string
+		sender      string
+		expSSE      types.ServerSideEncryption
+		expKMSKeyID *string
+	}{
+		{"sender address configuration", "some.person@example.org", types.ServerSideEncryptionAwsKms, aws.String("alias/some-person")},
+		{"sender domain configuration", "other.person@example.org", types.ServerSideEncryptionAwsKms, aws.String("alias/example-org")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockS3API{body: bytes.Replace(goodEmail,
+				[]byte("some.person@example.org"), []byte(tt.sender), -1)}
+			require.NoError(t, processEmail(context.TODO(), client, record))
+			require.NotNil(t, client.copyObjectInput)
+			assert.Equal(t, tt.expSSE, client.copyObjectInput.ServerSideEncryption)
+			assert.Equal(t, tt.expKMSKeyID, client.copyObjectInput.SSEKMSKeyId)
+		})
+	}
+
+	t.Run("global configuration", func(t *testing.T) {
+		delete(senderEncryption, "example.org")
+		client := &mockS3API{body: bytes.Replace(goodEmail,
+			[]byte("some.person@example.org"), []byte("other.person@example.org"), -1)}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.NotNil(t, client.copyObjectInput)
+		assert.Equal(t, types.ServerSideEncryptionAes256, client.copyObjectInput.ServerSideEncryption)
+		assert.Nil(t, client.copyObjectInput.SSEKMSKeyId)
+	})
+}
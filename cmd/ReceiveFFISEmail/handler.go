@@ -2,59 +2,519 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/mail"
+	"net/url"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/emailHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 )
 
+const (
+	KeyDateGranularityMonth = "month"
+	KeyDateGranularityDay   = "day"
+	KeyDateGranularityHour  = "hour"
+)
+
+var ErrUnknownKeyDateGranularity = errors.New("unknown key date granularity")
+
 type S3API interface {
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObjectLockConfiguration(ctx context.Context, params *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error)
+}
+
+// handleInvocation handles a raw invocation payload (see eventHelpers.InvocationHandler).
+// Events are skipped without side effects while the receive_email feature is disabled.
+func handleInvocation(ctx context.Context, client S3API, payload json.RawMessage) (interface{}, error) {
+	return eventHelpers.InvocationHandler{
+		Name:             "ReceiveFFISEmail",
+		Feature:          configHelpers.FeatureReceiveEmail,
+		DisabledFeatures: disabledFeatures,
+		Env:              env,
+		Sources:          func() interface{} { return describeSources() },
+		Logger:           logger,
+		SendMetric:       sendMetric,
+		HealthCheck: func(ctx context.Context) eventHelpers.HealthReport {
+			return handleHealthCheck(ctx, client)
+		},
+		HandleRecords: func(ctx context.Context, records []events.S3EventRecord) ([]eventHelpers.RecordResult, error) {
+			return handleRecords(ctx, client, records)
+		},
+		HandleEvent: func(ctx context.Context, event events.S3Event) error {
+			return handleEvent(ctx, client, event)
+		},
+	}.Handle(ctx, payload)
 }
 
+// handleHealthCheck verifies that the destination bucket is reachable (and, when retention is
+// configured, supports object lock) and that metrics can be emitted, without processing any events.
+func handleHealthCheck(ctx context.Context, client S3API) eventHelpers.HealthReport {
+	checks := []eventHelpers.HealthCheck{
+		{Name: "s3.destination_bucket", Required: true, Check: func(ctx context.Context) error {
+			_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(env.DestinationBucket)})
+			return err
+		}},
+		{Name: "metrics", Check: func(ctx context.Context) error {
+			sendMetric("healthcheck", 1)
+			return nil
+		}},
+	}
+	if destinationRetention.enabled() {
+		checks = append(checks, eventHelpers.HealthCheck{
+			Name: "s3.destination_object_lock", Required: true, Check: func(ctx context.Context) error {
+				return verifyObjectLock(ctx, client, env.DestinationBucket)
+			}})
+	}
+	report := eventHelpers.RunHealthChecks(ctx, eventHelpers.HealthCheckTimeout, checks)
+	if err := report.Err(); err != nil {
+		log.Error(logger, "Health check failed", err)
+		return report
+	}
+	log.Info(logger, "Health check succeeded")
+	return report
+}
+
+// handleEvent processes every record in the S3 event. When processing fails for any record,
+// the full error detail is logged and the returned error summarizes the invocation's
+// per-record outcomes, which is also posted to the failure notification webhook (if configured).
+// Emails that arrived outside the expected delivery schedule are reported in a summary log entry.
+// Records for restore events are skipped unless env.ProcessRestoreEvents is enabled
+// (see eventHelpers.FilterProcessableRecords).
 func handleEvent(ctx context.Context, client S3API, event events.S3Event) error {
-	sourceBucket := event.Records[0].S3.Bucket.Name
-	sourceKey := event.Records[0].S3.Object.Key
-	logger := log.With(logger, "source_bucket", sourceBucket, "source_key", sourceKey,
-		"destination_bucket", env.DestinationBucket)
+	records, skipped := eventHelpers.FilterProcessableRecords(event.Records, env.ProcessRestoreEvents)
+	for _, record := range skipped {
+		log.Info(logger, "Skipping record for S3 event that is not processable",
+			"event_name", record.EventName, "source_bucket", record.S3.Bucket.Name,
+			"source_key", record.S3.Object.Key)
+		sendMetric("record.skipped", 1, fmt.Sprintf("event_name:%s", record.EventName))
+	}
+	ctx, offSchedule := withOffScheduleRecorder(ctx)
+	results, err := handleRecords(ctx, client, records)
+	if keys := offSchedule.Keys(); len(keys) > 0 {
+		log.Warn(logger, "Invocation summary: archived emails that arrived off schedule",
+			"records", len(results), "off_schedule", len(keys),
+			"off_schedule_keys", strings.Join(keys, ","))
+	}
+	if err != nil {
+		log.Error(logger, "Failed to process one or more records", err)
+		invocationErr := eventHelpers.NewInvocationError(results, err)
+		eventHelpers.NotifyFailure(ctx, logger, sendMetric, failureNotifier, invocationErr)
+		return invocationErr
+	}
+	return nil
+}
+
+// dateFromBackfillKey parses the date from the leading YYYY-MM-DD of the file name in key,
+// e.g. "ses/ffis_ingest/backfill/2022-11-08-competitive-update.eml".
+func dateFromBackfillKey(key string) (time.Time, error) {
+	name := path.Base(key)
+	if len(name) < len("2006-01-02") {
+		return time.Time{}, fmt.Errorf("file name %q does not begin with a date", name)
+	}
+	return time.Parse("2006-01-02", name[:len("2006-01-02")])
+}
+
+// keyDateLayout returns the time layout used to format dates in destination object keys
+// according to the given KEY_DATE_GRANULARITY value.
+func keyDateLayout(granularity string) (string, error) {
+	switch granularity {
+	case KeyDateGranularityMonth:
+		return "2006/01", nil
+	case KeyDateGranularityDay:
+		return "2006/01/02", nil
+	case KeyDateGranularityHour:
+		return "2006/01/02/15", nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownKeyDateGranularity, granularity)
+	}
+}
 
-	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(sourceBucket),
-		Key:    aws.String(sourceKey),
-	})
+// destinationKey returns the destination object key for an email with the given date
+// from the source with the given destination subpath.
+func destinationKey(prefix, subpath string, date time.Time) (string, error) {
+	layout, err := keyDateLayout(env.KeyDateGranularity)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%s/raw.eml", prefix, date.Format(layout), subpath), nil
+}
+
+// handleRecords processes every record concurrently or, when env.DeterministicOrder is enabled,
+// sequentially in order of bucket and key.
+// When env.RecordTimeout is positive, each record that is not processed (including any retries)
+// within that duration, or before the invocation's deadline if sooner, fails with
+// eventHelpers.ErrRecordTimeout.
+func handleRecords(ctx context.Context, client S3API, records []events.S3EventRecord) ([]eventHelpers.RecordResult, error) {
+	return eventHelpers.HandleRecords(ctx, records, env.DeterministicOrder, env.RecordTimeout,
+		func(ctx context.Context, i int, record events.S3EventRecord) error {
+			err := processEmailWithRetries(ctx, client, record)
+			if errors.Is(err, eventHelpers.ErrRecordTimeout) {
+				tags := []string{}
+				if source, ok := matchSource(sources, record.S3.Object.Key); ok {
+					tags = append(tags, source.metricTag())
+				}
+				sendMetric("record.timeout", 1, tags...)
+			}
+			return err
+		})
+}
+
+// processEmail verifies the email referenced by the S3 event record and, when it can be trusted
+// (see validateEmail), copies it to the destination bucket of its source (or of its tenant).
+// Records that match no configured source and objects that are not raw emails are skipped.
+func processEmail(ctx context.Context, client S3API, record events.S3EventRecord) (err error) {
+	sourceBucket := record.S3.Bucket.Name
+	sourceKey := record.S3.Object.Key
+	logger := log.With(logger, "event_name", record.EventName, "event_version", record.EventVersion,
+		"source_bucket", sourceBucket, "source_key", sourceKey)
+
+	// Each phase of processing is traced by a child span of the record span, which is tagged
+	// as timed out when the record timeout of ctx (see eventHelpers.WithRecordTimeout) elapsed
+	recordSpan, ctx := tracing.StartSpanFromContext(ctx, "handle.record")
+	recordSpan.SetTag("source_bucket", sourceBucket)
+	recordSpan.SetTag("source_key", sourceKey)
+	defer func() {
+		err = eventHelpers.RecordTimeoutError(ctx, err)
+		if errors.Is(err, eventHelpers.ErrRecordTimeout) {
+			recordSpan.SetTag("timed_out", true)
+		}
+		tracing.FinishWithOutcome(recordSpan, err)
+	}()
+
+	// The allowed senders and destination subpath are those of the source matching the key,
+	// whose name tags the record's metrics and spans
+	source, ok := matchSource(sources, sourceKey)
+	if !ok {
+		sendMetric("email.unmatched_source", 1)
+		recordSpan.SetTag("skipped", true)
+		log.Warn(logger, "Skipping email because its key does not match any configured source")
+		return nil
+	}
+	logger = log.With(logger, "source", source.sourceName(), "source_key_prefix", source.KeyPrefix,
+		"destination_subpath", source.DestinationSubpath)
+	recordSpan.SetTag("source", source.sourceName())
+	metricTags := []string{source.metricTag()}
+
+	fetchSpan, fetchCtx := tracing.StartSpanFromContext(ctx, "email.fetch")
+	data, err := fetchS3Object(fetchCtx, client, sourceBucket, sourceKey)
+	fetchSpan.SetTag("bytes", len(data))
+	tracing.FinishWithOutcome(fetchSpan, err)
 	if err != nil {
 		return log.Errorf(logger, "failed to retrieve S3 object", err)
 	}
-	defer resp.Body.Close()
+	// SES notifications are stored alongside raw emails, and every kind of object is counted
+	kind, notification := emailHelpers.InboundObjectKind(data)
+	recordSpan.SetTag("inbound_kind", kind)
+	sendMetric("inbound.kind", 1, append([]string{"kind:" + kind}, metricTags...)...)
+	if kind == emailHelpers.InboundKindSESNotification {
+		// The email described by the notification is archived when its raw object is processed
+		recordSpan.SetTag("skipped", true)
+		log.Info(logger, "Skipping SES notification because it is not a raw email",
+			"notification_type", notification.NotificationType,
+			"ses_message_id", notification.Mail.MessageID, "ses_source", notification.Mail.Source)
+		return nil
+	}
 
-	msg, sender, sentAt, err := parseEmailContents(resp.Body)
+	parseSpan, _ := tracing.StartSpanFromContext(ctx, "email.parse")
+	msg, sender, sentAt, err := parseEmail(logger, parseSpan, data)
+	tracing.FinishWithOutcome(parseSpan, err)
 	if err != nil {
-		return log.Errorf(logger, "failed to parse email from S3 object", err)
+		return err
 	}
 	logger = log.With(logger,
 		"email_sender_name", sender.Name, "email_sender_address", sender.Address)
 
-	if err := verifyEmailIsTrusted(msg, sender); err != nil {
-		sendMetric("email.untrusted", 1)
-		return log.Errorf(logger, "email cannot be trusted", err)
+	// When tenants are configured, the email is archived to the bucket and key prefix of the
+	// tenant identified by its Delivered-To recipient, which also tags its metrics and spans
+	var tenant TenantConfig
+	if len(tenants) > 0 {
+		var tenantName, unrecognized string
+		tenantName, tenant, unrecognized = resolveTenant(msg.Header)
+		metricTags = append(metricTags, tenantMetricTag(tenantName))
+		logger = log.With(logger, "tenant", tenantName)
+		recordSpan.SetTag("tenant", tenantName)
+		if unrecognized != "" {
+			sendMetric("email.unrecognized_tenant", 1, metricTags...)
+			log.Warn(logger, "Routing email to the default tenant because its recipient names an unknown tenant",
+				"recipient_tenant", unrecognized)
+		}
+	}
+	destBucket := tenant.bucket(source.bucket())
+	logger = log.With(logger, "destination_bucket", destBucket)
+	// Failure to assume the source's destination role is never retried
+	// (see isRecoverablePipelineError)
+	destClient, err := destinationClient(ctx, client, source)
+	if err != nil {
+		sendMetric("destination.assume_role_failed", 1, metricTags...)
+		return log.Errorf(log.With(logger, "destination_role_arn", source.DestinationRoleARN),
+			"failed to obtain credentials for destination bucket", err)
+	}
+	if source.DestinationRoleARN != "" {
+		logger = log.With(logger, "destination_role_arn", source.DestinationRoleARN)
+	}
+
+	validateSpan, _ := tracing.StartSpanFromContext(ctx, "email.validate")
+	auditLogger := log.With(auditLogger, "source_bucket", sourceBucket, "source_key", sourceKey,
+		"source_key_prefix", source.KeyPrefix)
+	destPrefix, senderVerified, err := validateEmail(logger, auditLogger, msg, sender, source, metricTags)
+	validateSpan.SetTag("destination_prefix", destPrefix)
+	validateSpan.SetTag("sender_verified", senderVerified)
+	tracing.FinishWithOutcome(validateSpan, err)
+	// Suspected spoofs are archived under the quarantine prefix before being reported as failures
+	var spoofErr error
+	if errors.Is(err, ErrEmailSuspectedSpoof) {
+		spoofErr, err = err, nil
+		recordSpan.SetTag("suspected_spoof", true)
+	}
+	if err != nil {
+		return err
 	}
 
-	destKey := fmt.Sprintf("sources/%s/ffis.org/raw.eml", sentAt.Format("2006/01/02"))
+	keyDate := sentAt
+	copyInput := &s3.CopyObjectInput{
+		CopySource: aws.String(filepath.Join(sourceBucket, sourceKey)),
+		Bucket:     aws.String(destBucket),
+	}
+	encryption, encryptionSender := encryptionForSender(sender.Address)
+	encryption.applyToCopy(copyInput)
+	logger = log.With(logger, "sse", encryption.ServerSideEncryption,
+		"sse_sender_config", encryptionSender)
+	if destinationRetention.enabled() {
+		// Emails are not archived without the retention required of them
+		if err := verifyObjectLock(ctx, destClient, destBucket); err != nil {
+			return log.Errorf(logger, "failed to verify object lock support of destination bucket", err)
+		}
+		archivedAt := time.Now()
+		destinationRetention.applyToCopy(copyInput, archivedAt)
+		logger = log.With(logger, "object_lock_mode", destinationRetention.Mode,
+			"retain_until", destinationRetention.retainUntil(archivedAt))
+	}
+	tags := url.Values{}
+	// Backfilled emails are filed according to the date given by their file name, since their
+	// Date header may reflect when they were re-saved rather than when they were sent.
+	if env.BackfillPrefix != "" && strings.HasPrefix(sourceKey, env.BackfillPrefix) {
+		if backfillDate, err := dateFromBackfillKey(sourceKey); err != nil {
+			log.Warn(logger, "Could not determine backfill date from file name; using email date instead",
+				"error", err)
+		} else {
+			keyDate = backfillDate
+		}
+		tags.Set("backfilled", "true")
+		sendMetric("email.backfilled", 1, metricTags...)
+	}
+	// Emails dated outside env.ExpectedDeliveryDOWs are archived as usual, but tagged as such
+	if isOffSchedule(keyDate) {
+		tags.Set("off_schedule", "true")
+		sendMetric("email.off_schedule", 1, metricTags...)
+		recordSpan.SetTag("off_schedule", true)
+		recordOffSchedule(ctx, sourceKey)
+		log.Warn(logger, "Email arrived outside the expected delivery schedule",
+			"delivery_weekday", keyDate.In(deliveryLocation).Weekday().String())
+	}
+	metadata := map[string]string{}
+	if !senderVerified {
+		tags.Set("sender_verified", "false")
+		metadata["sender-verified"] = "false"
+	}
+	if err := fitArchiveAttributes(logger, copyInput, metadata, tags, metricTags); err != nil {
+		return log.Errorf(logger, "failed to fit archived email metadata and tags within S3 limits", err)
+	}
+
+	destKey, err := destinationKey(tenant.keyPrefix(destPrefix), source.DestinationSubpath, keyDate)
+	if err != nil {
+		return log.Errorf(logger, "failed to determine destination key", err)
+	}
+	copyInput.Key = aws.String(destKey)
 	logger = log.With(logger, "destination_key", destKey, "email_date", sentAt)
-	if _, err := client.CopyObject(ctx, &s3.CopyObjectInput{
-		CopySource:           aws.String(filepath.Join(sourceBucket, sourceKey)),
-		Bucket:               aws.String(env.DestinationBucket),
-		Key:                  aws.String(destKey),
-		ServerSideEncryption: types.ServerSideEncryptionAes256,
-	}); err != nil {
+	uploadSpan, uploadCtx := tracing.StartSpanFromContext(ctx, "email.upload")
+	uploadSpan.SetTag("destination_key", destKey)
+	uploadSpan.SetTag("bytes", len(data))
+	// A retried attempt may follow one whose copy succeeded before it failed, in which case
+	// the copy is not repeated (which would emit a duplicate notification downstream)
+	if attempt := pipelineAttempt(ctx); attempt > 1 {
+		copied, err := destinationHasCopy(uploadCtx, destClient, destBucket, destKey, data)
+		if err != nil {
+			tracing.FinishWithOutcome(uploadSpan, err)
+			return log.Errorf(logger, "failed to check for existing destination object", err)
+		}
+		if copied {
+			uploadSpan.SetTag("skipped", true)
+			tracing.FinishWithOutcome(uploadSpan, nil)
+			sendMetric("email.copy_skipped", 1, metricTags...)
+			log.Info(logger, "Email was already copied to destination bucket by an earlier attempt",
+				"attempt", attempt)
+			return spoofErr
+		}
+	}
+	_, err = destClient.CopyObject(uploadCtx, copyInput)
+	tracing.FinishWithOutcome(uploadSpan, err)
+	if err != nil {
 		return log.Errorf(logger, "failed to copy S3 object", err)
 	}
 
 	log.Info(logger, "Successfully copied email to destination bucket")
-	return nil
+	return spoofErr
+}
+
+// parseEmail parses the (possibly gzip-compressed) email contained in data (see
+// emailHelpers.Parse). Whether the email was compressed is tagged on span.
+// The returned sentAt is taken from the header given by env.DateHeaderPreference, and a warning
+// is logged when the Date and Resent-Date headers differ by more than env.DateHeaderTolerance.
+// When the Date header is missing or invalid, the time at which the email was received
+// is used in its place, if known.
+func parseEmail(logger log.Logger, span tracing.Span, data []byte) (
+	msg *mail.Message, sender *mail.Address, sentAt time.Time, err error,
+) {
+	// Parsing failures are never retried because the email content is already fully buffered
+	email, err := emailHelpers.Parse(data)
+	if err != nil {
+		return nil, nil, time.Time{}, log.Errorf(logger, "failed to parse email from S3 object", err)
+	}
+	span.SetTag("compressed", email.Compressed)
+	if email.Compressed {
+		sendMetric("email.decompressed", 1)
+		log.Info(logger, "Decompressed gzip-compressed email")
+	}
+	if email.DateHeaderErr != nil {
+		sendMetric("email.date_header_fallback", 1)
+		log.Warn(logger, "Using time of receipt as email date because its Date header is missing or invalid",
+			"error", email.DateHeaderErr, "received_time", email.Date)
+	}
+	sentAt = email.Date
+
+	if resentAt, ok := emailHelpers.ResentDate(email.Message.Header); ok {
+		if email.DateHeaderErr == nil && dateHeadersDisagree(sentAt, resentAt, env.DateHeaderTolerance) {
+			sendMetric("email.date_header_disagreement", 1)
+			log.Warn(logger, "Date and Resent-Date headers disagree beyond tolerance",
+				"date_header", sentAt, "resent_date_header", resentAt,
+				"tolerance", env.DateHeaderTolerance, "date_header_preference", env.DateHeaderPreference)
+		}
+		if env.DateHeaderPreference == DateHeaderPreferenceResentDate {
+			sentAt = resentAt
+		}
+	}
+	return email.Message, email.Sender, sentAt, nil
+}
+
+// validateEmail verifies the sender and contents of msg, returning the prefix under which the
+// email should be archived and whether its sender was verified.
+// Emails that fail sender verification are handled according to env.UnknownSenderPolicy,
+// except for suspected spoofs (whose sender name imitates one of organizationNames), for which
+// an error wrapping ErrEmailSuspectedSpoof is returned along with the quarantine prefix.
+// When expectedRecipients are configured, emails addressed to none of them are handled
+// according to env.UnexpectedRecipientPolicy.
+// When quarantine mode is enabled, trusted emails with suspicious characteristics are
+// archived under the quarantine prefix.
+// Each policy decision is recorded as an event with auditLogger, and metrics are sent with metricTags.
+func validateEmail(
+	logger, auditLogger log.Logger, msg *mail.Message, sender *mail.Address, source SourceConfig, metricTags []string,
+) (
+	destPrefix string, senderVerified bool, err error,
+) {
+	destPrefix = "sources"
+	senderVerified = true
+	if err := verifyEmailSender(msg, sender, source.ValidSenders); err != nil {
+		if errors.Is(err, ErrNoSenderAllowlist) {
+			// A missing allowlist is a misconfiguration, not a verdict about the sender
+			sendMetric("email.untrusted", 1, metricTags...)
+			return "", false, log.Errorf(logger, "email sender cannot be verified", err)
+		}
+		if errors.Is(err, ErrEmailUnrecognizedSender) {
+			addresses := senderAddresses(msg, sender)
+			if organization, imitator, ok := imitatedOrganization(addresses, source.ValidSenders, organizationNames); ok {
+				// Unlike benign misdirected mail, suspected spoofs are always quarantined for
+				// review and reported as failures, regardless of env.UnknownSenderPolicy
+				err = fmt.Errorf("%w: %w", ErrEmailSuspectedSpoof, err)
+				log.Audit(auditLogger, log.AuditDecisionReject, "sender_spoof", imitator.Address,
+					"reason", err.Error(), "imitated_organization", organization,
+					"from", formatAddresses(addresses), "destination_prefix", "quarantine")
+				sendMetric("email.suspected_spoof", 1, metricTags...)
+				log.Warn(logger, "Quarantining email whose sender name imitates a trusted organization",
+					"imitated_organization", organization, "from", formatAddresses(addresses))
+				return "quarantine", false, log.Errorf(logger, "email sender is suspected of spoofing", err)
+			}
+		}
+		rule := "sender_allowlist"
+		if errors.Is(err, ErrEmailDKIMCheckFailed) {
+			rule = "sender_dkim"
+		}
+		log.Audit(auditLogger, log.AuditDecisionReject, rule, sender.Address,
+			"reason", err.Error(), "unknown_sender_policy", env.UnknownSenderPolicy)
+		switch env.UnknownSenderPolicy {
+		case UnknownSenderPolicyQuarantine:
+			destPrefix = "failed"
+			sendMetric("email.unknown_sender_quarantined", 1, metricTags...)
+			log.Warn(logger, "Quarantining email that failed sender verification", "error", err)
+		case UnknownSenderPolicyArchiveFlagged:
+			senderVerified = false
+			sendMetric("email.unverified_sender", 1, metricTags...)
+			log.Warn(logger, "Archiving email that failed sender verification as unverified",
+				"error", err)
+		default:
+			sendMetric("email.untrusted", 1, metricTags...)
+			return "", false, log.Errorf(logger, "email cannot be trusted", err)
+		}
+	} else {
+		log.Audit(auditLogger, log.AuditDecisionAccept, "sender_allowlist", sender.Address,
+			"strict_dkim_check", env.StrictDKIMCheck)
+	}
+	if err := verifyEmailContents(msg); err != nil {
+		log.Audit(auditLogger, log.AuditDecisionReject, "email_contents", sender.Address,
+			"reason", err.Error())
+		sendMetric("email.untrusted", 1, metricTags...)
+		return "", false, log.Errorf(logger, "email cannot be trusted", err)
+	}
+	log.Audit(auditLogger, log.AuditDecisionAccept, "email_contents", sender.Address)
+
+	if len(expectedRecipients) > 0 {
+		recipients := emailRecipients(msg.Header)
+		if err := verifyEmailRecipients(recipients, expectedRecipients); err != nil {
+			log.Audit(auditLogger, log.AuditDecisionReject, "expected_recipients", sender.Address,
+				"reason", err.Error(), "recipients", formatRecipients(recipients),
+				"unexpected_recipient_policy", env.UnexpectedRecipientPolicy)
+			sendMetric("email.unexpected_recipient", 1,
+				append([]string{"policy:" + env.UnexpectedRecipientPolicy}, metricTags...)...)
+			if env.UnexpectedRecipientPolicy == UnexpectedRecipientPolicyReject {
+				return "", false, log.Errorf(logger, "email is not addressed to an expected recipient", err)
+			}
+			log.Warn(logger, "Archiving email that is not addressed to an expected recipient",
+				"recipients", formatRecipients(recipients))
+		} else {
+			log.Audit(auditLogger, log.AuditDecisionAccept, "expected_recipients", sender.Address,
+				"recipients", formatRecipients(recipients))
+		}
+	}
+
+	if env.QuarantineSuspiciousEmails && destPrefix == "sources" {
+		reasons, err := suspiciousEmailReasons(msg)
+		if err != nil {
+			return "", false, log.Errorf(logger, "failed to inspect email contents", err)
+		}
+		if len(reasons) > 0 {
+			log.Audit(auditLogger, log.AuditDecisionReject, "suspicious_email", sender.Address,
+				"reason", strings.Join(reasons, ","), "destination_prefix", "quarantine")
+			destPrefix = "quarantine"
+			for _, reason := range reasons {
+				sendMetric("email.quarantined", 1,
+					append([]string{fmt.Sprintf("reason:%s", reason)}, metricTags...)...)
+			}
+			log.Warn(logger, "Quarantining suspicious email", "reasons", strings.Join(reasons, ","))
+		}
+	}
+	return destPrefix, senderVerified, nil
 }
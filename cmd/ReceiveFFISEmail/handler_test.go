@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	goenv "github.com/Netflix/go-env"
 	"github.com/aws/aws-lambda-go/events"
@@ -14,11 +20,20 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/go-kit/log"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func setupLambdaEnvForTesting(t *testing.T) {
@@ -34,6 +49,8 @@ func setupLambdaEnvForTesting(t *testing.T) {
 		"S3_USE_PATH_STYLE":              "true",
 	}, &env)
 	require.NoError(t, err, "Error configuring lambda environment for testing")
+	sources, err = loadSourcesConfig(env)
+	require.NoError(t, err, "Error configuring sources for testing")
 }
 
 func setupS3ForTesting(t *testing.T, sourceBucket, destBucket string) *s3.Client {
@@ -99,6 +116,20 @@ func TestHandleEvent(t *testing.T) {
 			uploadFixture:     true,
 			shouldError:       false,
 		},
+		{
+			name:              "successful invocation with base64-encoded body",
+			pathToFixture:     "fixtures/good_base64Body.eml",
+			destinationBucket: env.DestinationBucket,
+			uploadFixture:     true,
+			shouldError:       false,
+		},
+		{
+			name:              "successful invocation with gzip-compressed object lacking Content-Encoding",
+			pathToFixture:     "fixtures/good_gzipNoEncoding.eml",
+			destinationBucket: env.DestinationBucket,
+			uploadFixture:     true,
+			shouldError:       false,
+		},
 		{
 			name:              "invalid email",
 			pathToFixture:     "fixtures/bad_data.eml",
@@ -162,7 +193,1008 @@ func TestHandleEvent(t *testing.T) {
 			} else {
 				assert.Error(t, err)
 				assert.ErrorContains(t, err, tt.errShouldContain)
+				var invocationErr *eventHelpers.InvocationError
+				if assert.ErrorAs(t, err, &invocationErr) {
+					assert.Equal(t, 1, invocationErr.Summary.Failed)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleInvocationAdminReprocess(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	sourceBucket := "source-bucket"
+	sourceKey := "sources/2023/4/24/raw.eml"
+	svc := setupS3ForTesting(t, sourceBucket, env.DestinationBucket)
+	_, err := svc.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(sourceBucket),
+		Key:    aws.String(sourceKey),
+		Body:   getFixture(t, "fixtures/good.eml"),
+	})
+	require.NoError(t, err)
+
+	t.Run("valid reprocess request", func(t *testing.T) {
+		resp, err := handleInvocation(context.Background(), svc, json.RawMessage(fmt.Sprintf(
+			`{"adminAction": "reprocess", "bucket": %q, "key": %q}`, sourceBucket, sourceKey)))
+		require.NoError(t, err)
+		require.IsType(t, eventHelpers.AdminResponse{}, resp)
+		adminResp := resp.(eventHelpers.AdminResponse)
+		assert.Equal(t, eventHelpers.AdminActionReprocess, adminResp.Action)
+		require.Len(t, adminResp.Results, 1)
+		assert.Equal(t, eventHelpers.RecordResult{
+			Bucket: sourceBucket,
+			Key:    sourceKey,
+			Status: eventHelpers.RecordStatusSucceeded,
+		}, adminResp.Results[0])
+
+		_, err = svc.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    aws.String("sources/2023/04/22/ffis.org/raw.eml"),
+		})
+		assert.NoError(t, err, "Could not find the copied destination S3 object")
+	})
+
+	t.Run("reprocess failure is reported in the response", func(t *testing.T) {
+		resp, err := handleInvocation(context.Background(), svc, json.RawMessage(fmt.Sprintf(
+			`{"adminAction": "reprocess", "bucket": %q, "key": "does/not/exist"}`, sourceBucket)))
+		require.NoError(t, err)
+		adminResp := resp.(eventHelpers.AdminResponse)
+		require.Len(t, adminResp.Results, 1)
+		assert.Equal(t, eventHelpers.RecordStatusFailed, adminResp.Results[0].Status)
+		assert.Contains(t, adminResp.Results[0].Error, "failed to retrieve S3 object")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := handleInvocation(context.Background(), svc, json.RawMessage(fmt.Sprintf(
+			`{"adminAction": "reprocess", "bucket": %q}`, sourceBucket)))
+		assert.ErrorIs(t, err, eventHelpers.ErrMissingAdminParameter)
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		_, err := handleInvocation(context.Background(), svc, json.RawMessage(fmt.Sprintf(
+			`{"adminAction": "delete-everything", "bucket": %q, "key": %q}`, sourceBucket, sourceKey)))
+		assert.ErrorIs(t, err, eventHelpers.ErrUnknownAdminAction)
+	})
+}
+
+func TestHandleInvocationFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureReceiveEmail: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	client := &mockS3API{body: goodEmail}
+	payload, err := json.Marshal(events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}}})
+	require.NoError(t, err)
+
+	resp, err := handleInvocation(context.Background(), client, payload)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, 0, client.getObjectCalls, "S3 should not be read while disabled")
+	assert.Equal(t, 0, client.headObjectCalls, "S3 should not be read while disabled")
+	assert.Equal(t, 0, client.copyObjectCalls, "S3 should not be written while disabled")
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
+func TestHandleInvocationHealthCheck(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+
+	t.Run("healthy", func(t *testing.T) {
+		svc := setupS3ForTesting(t, "source-bucket", env.DestinationBucket)
+		resp, err := handleInvocation(context.Background(), svc, json.RawMessage(`{"healthcheck": true}`))
+		require.NoError(t, err)
+		require.IsType(t, eventHelpers.HealthReport{}, resp)
+		report := resp.(eventHelpers.HealthReport)
+		assert.True(t, report.Healthy)
+		for _, check := range report.Checks {
+			assert.Equal(t, eventHelpers.HealthCheckStatusOK, check.Status, check.Name)
+		}
+	})
+
+	t.Run("destination bucket unreachable", func(t *testing.T) {
+		client := &mockS3API{headBucketError: errors.New("access denied")}
+		_, err := handleInvocation(context.Background(), client, json.RawMessage(`{"healthcheck": true}`))
+		assert.ErrorIs(t, err, eventHelpers.ErrHealthCheckFailed)
+		var healthErr *eventHelpers.HealthCheckError
+		require.ErrorAs(t, err, &healthErr, "The report should be returned to the invoker")
+		report := healthErr.Report
+		assert.False(t, report.Healthy)
+		assert.Equal(t, "s3.destination_bucket", report.Checks[0].Name)
+		assert.Equal(t, eventHelpers.HealthCheckStatusFailed, report.Checks[0].Status)
+		assert.Equal(t, 0, client.getObjectCalls, "No events should be processed")
+	})
+}
+
+func TestHandleInvocationDescribe(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { setupLambdaEnvForTesting(t) })
+	restoreDisabledFeatures := disabledFeatures
+	t.Cleanup(func() { disabledFeatures = restoreDisabledFeatures })
+	restoreVersion, restoreCommit := awsHelpers.ProcessorVersion, awsHelpers.BuildCommit
+	t.Cleanup(func() { awsHelpers.ProcessorVersion, awsHelpers.BuildCommit = restoreVersion, restoreCommit })
+	awsHelpers.ProcessorVersion, awsHelpers.BuildCommit = "v1.2.3", "0123abc"
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureReceiveEmail: true}
+	env.SourcesConfig = `[
+		{"keyPrefix": "ses/ffis_ingest/new/", "validSenders": ["ffis.org"], "destinationSubpath": "ffis.org"},
+		{"name": "state", "keyPrefix": "ses/state_updates/new/", "validSenders": ["state.gov"],
+			"destinationSubpath": "state_updates", "destinationBucket": "state-sources"}
+	]`
+	var err error
+	sources, err = loadSourcesConfig(env)
+	require.NoError(t, err)
+	env.WebhookURL = "https://hooks.example.com/services/s3cr3t-webhook"
+	env.SentryDSN = "https://s3cr3t-key@sentry.example.com/1"
+	env.Extras = goenv.EnvSet{"AWS_SESSION_TOKEN": "s3cr3t-session"}
+
+	client := &mockS3API{}
+	resp, err := handleInvocation(context.Background(), client, json.RawMessage(`{"adminAction": "describe"}`))
+	require.NoError(t, err)
+	description, ok := resp.(eventHelpers.DescribeResponse)
+	require.True(t, ok, "Unexpected response type %T", resp)
+	assert.Equal(t, "describe", description.Action)
+	assert.Equal(t, "ReceiveFFISEmail", description.Handler)
+	assert.Equal(t, "v1.2.3", description.Version)
+	assert.Equal(t, "0123abc", description.Commit)
+	assert.Equal(t, []string{"receive_email"}, description.DisabledFeatures)
+	assert.Equal(t, []SourceConfig{
+		{Name: "ffis.org", KeyPrefix: "ses/ffis_ingest/new/", ValidSenders: []string{"ffis.org"},
+			DestinationSubpath: "ffis.org", DestinationBucket: env.DestinationBucket},
+		{Name: "state", KeyPrefix: "ses/state_updates/new/", ValidSenders: []string{"state.gov"},
+			DestinationSubpath: "state_updates", DestinationBucket: "state-sources"},
+	}, description.Sources)
+	assert.Equal(t, env.DestinationBucket, description.Environment["DestinationBucket"])
+	assert.Equal(t, awsHelpers.RedactedValue, description.Environment["WebhookURL"])
+	assert.Equal(t, awsHelpers.RedactedValue, description.Environment["SentryDSN"])
+	assert.Equal(t, awsHelpers.RedactedValue, description.Environment["Extras"])
+	assert.Equal(t, 0, client.getObjectCalls, "No events should be processed")
+
+	encoded, err := json.Marshal(resp)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "s3cr3t")
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(encoded, &fields))
+	for _, key := range []string{
+		"adminAction", "handler", "version", "commit", "disabledFeatures", "sources", "environment",
+	} {
+		assert.Contains(t, fields, key)
+	}
+}
+
+func TestHandleEventQuarantine(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	env.QuarantineSuspiciousEmails = true
+	t.Cleanup(func() { env.QuarantineSuspiciousEmails = false })
+
+	for _, tt := range []struct {
+		name          string
+		pathToFixture string
+		expKey        string
+		unexpKey      string
+	}{
+		{
+			"clean email follows normal path",
+			"fixtures/good.eml",
+			"sources/2023/04/22/ffis.org/raw.eml",
+			"quarantine/2023/04/22/ffis.org/raw.eml",
+		},
+		{
+			"flagged email is quarantined",
+			"fixtures/suspicious_attachment.eml",
+			"quarantine/2023/04/22/ffis.org/raw.eml",
+			"sources/2023/04/22/ffis.org/raw.eml",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceBucket := "source-bucket"
+			sourceKey := "source/key.eml"
+			svc := setupS3ForTesting(t, sourceBucket, env.DestinationBucket)
+			_, err := svc.PutObject(context.Background(), &s3.PutObjectInput{
+				Bucket: aws.String(sourceBucket),
+				Key:    aws.String(sourceKey),
+				Body:   getFixture(t, tt.pathToFixture),
+			})
+			require.NoError(t, err)
+
+			require.NoError(t, handleEvent(context.Background(), svc, events.S3Event{
+				Records: []events.S3EventRecord{{S3: events.S3Entity{
+					Bucket: events.S3Bucket{Name: sourceBucket},
+					Object: events.S3Object{Key: sourceKey},
+				}}},
+			}))
+
+			_, err = svc.HeadObject(context.Background(), &s3.HeadObjectInput{
+				Bucket: aws.String(env.DestinationBucket),
+				Key:    aws.String(tt.expKey),
+			})
+			assert.NoError(t, err, "Could not find the copied destination S3 object")
+			_, err = svc.HeadObject(context.Background(), &s3.HeadObjectInput{
+				Bucket: aws.String(env.DestinationBucket),
+				Key:    aws.String(tt.unexpKey),
+			})
+			assert.Error(t, err, "Unexpected S3 object exists")
+		})
+	}
+}
+
+func TestProcessEmailBackfill(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name          string
+		sourceKey     string
+		expDestKey    string
+		expBackfilled bool
+	}{
+		{
+			"file name date is preferred for backfilled emails",
+			"ses/ffis_ingest/backfill/2022-11-08-competitive-update.eml",
+			"sources/2022/11/08/ffis.org/raw.eml",
+			true,
+		},
+		{
+			"invalid file name date falls back to email date",
+			"ses/ffis_ingest/backfill/competitive-update.eml",
+			"sources/2023/04/22/ffis.org/raw.eml",
+			true,
+		},
+		{
+			"email date is used outside the backfill prefix",
+			"ses/ffis_ingest/new/2022-11-08-competitive-update.eml",
+			"sources/2023/04/22/ffis.org/raw.eml",
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockS3API{body: goodEmail}
+			require.NoError(t, processEmail(context.TODO(), client, events.S3EventRecord{
+				S3: events.S3Entity{
+					Bucket: events.S3Bucket{Name: "source-bucket"},
+					Object: events.S3Object{Key: tt.sourceKey},
+				},
+			}))
+			require.NotNil(t, client.copyObjectInput)
+			assert.Equal(t, tt.expDestKey, aws.ToString(client.copyObjectInput.Key))
+			if tt.expBackfilled {
+				assert.Equal(t, "backfilled=true", aws.ToString(client.copyObjectInput.Tagging))
+			} else {
+				assert.Nil(t, client.copyObjectInput.Tagging)
+			}
+		})
+	}
+}
+
+func TestDestinationKeyDateGranularity(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.KeyDateGranularity = KeyDateGranularityDay })
+	date := time.Date(2023, time.April, 22, 15, 4, 5, 0, time.UTC)
+
+	for _, tt := range []struct {
+		granularity string
+		expKey      string
+	}{
+		{KeyDateGranularityMonth, "sources/2023/04/ffis.org/raw.eml"},
+		{KeyDateGranularityDay, "sources/2023/04/22/ffis.org/raw.eml"},
+		{KeyDateGranularityHour, "sources/2023/04/22/15/ffis.org/raw.eml"},
+	} {
+		t.Run(tt.granularity, func(t *testing.T) {
+			env.KeyDateGranularity = tt.granularity
+			key, err := destinationKey("sources", "ffis.org", date)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expKey, key)
+		})
+	}
+
+	t.Run("default granularity is day", func(t *testing.T) {
+		setupLambdaEnvForTesting(t)
+		assert.Equal(t, KeyDateGranularityDay, env.KeyDateGranularity)
+	})
+
+	t.Run("unknown granularity", func(t *testing.T) {
+		env.KeyDateGranularity = "fortnight"
+		_, err := destinationKey("sources", "ffis.org", date)
+		assert.ErrorIs(t, err, ErrUnknownKeyDateGranularity)
+	})
+}
+
+func TestProcessEmailDateHeaders(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.DateHeaderPreference = DateHeaderPreferenceDate })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	for _, tt := range []struct {
+		name            string
+		resentDate      string
+		preference      string
+		expDestKey      string
+		expDisagreement bool
+	}{
+		{
+			"no Resent-Date header",
+			"",
+			DateHeaderPreferenceResentDate,
+			"sources/2023/04/22/ffis.org/raw.eml",
+			false,
+		},
+		{
+			"agreeing headers",
+			"Sat, 22 Apr 2023 15:30:00 -0500",
+			DateHeaderPreferenceDate,
+			"sources/2023/04/22/ffis.org/raw.eml",
+			false,
+		},
+		{
+			"disagreeing headers keyed by Date",
+			"Tue, 25 Apr 2023 09:00:00 -0500",
+			DateHeaderPreferenceDate,
+			"sources/2023/04/22/ffis.org/raw.eml",
+			true,
+		},
+		{
+			"disagreeing headers keyed by Resent-Date",
+			"Tue, 25 Apr 2023 09:00:00 -0500",
+			DateHeaderPreferenceResentDate,
+			"sources/2023/04/25/ffis.org/raw.eml",
+			true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k := range sentMetrics {
+				delete(sentMetrics, k)
+			}
+			env.DateHeaderPreference = tt.preference
+			email := goodEmail
+			if tt.resentDate != "" {
+				email = append([]byte("Resent-Date: "+tt.resentDate+"\n"), goodEmail...)
+			}
+			client := &mockS3API{body: email}
+			require.NoError(t, processEmail(context.TODO(), client, record))
+			require.NotNil(t, client.copyObjectInput)
+			assert.Equal(t, tt.expDestKey, aws.ToString(client.copyObjectInput.Key))
+			if tt.expDisagreement {
+				assert.Equal(t, 1.0, sentMetrics["email.date_header_disagreement"])
+			} else {
+				assert.NotContains(t, sentMetrics, "email.date_header_disagreement")
 			}
 		})
 	}
 }
+
+func TestProcessEmailDateHeaderFallback(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	undatedEmail := bytes.Replace(goodEmail, []byte("Date: Sat, 22 Apr 2023 14:55:26 -0500\n"), nil, 1)
+	require.NotEqual(t, goodEmail, undatedEmail)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	t.Run("keyed by time of receipt", func(t *testing.T) {
+		email := append([]byte("Received: from mx.example.org by inbound-smtp.us-west-2.amazonaws.com; "+
+			"Sun, 23 Apr 2023 10:00:00 +0000\n"), undatedEmail...)
+		client := &mockS3API{body: email}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.NotNil(t, client.copyObjectInput)
+		assert.Equal(t, "sources/2023/04/23/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+		assert.Equal(t, 1.0, sentMetrics["email.date_header_fallback"])
+	})
+
+	t.Run("time of receipt is unknown", func(t *testing.T) {
+		client := &mockS3API{body: undatedEmail}
+		err := processEmail(context.TODO(), client, record)
+		assert.ErrorIs(t, err, emailHelpers.ErrEmailDateFailedToParse)
+		assert.Nil(t, client.copyObjectInput)
+	})
+}
+
+func TestProcessEmailUnknownSenderPolicy(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.UnknownSenderPolicy = UnknownSenderPolicyReject })
+	unknownSenderEmail, err := os.ReadFile("fixtures/bad_sender.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	resetMetrics := func() {
+		for k := range sentMetrics {
+			delete(sentMetrics, k)
+		}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		resetMetrics()
+		env.UnknownSenderPolicy = UnknownSenderPolicyReject
+		client := &mockS3API{body: unknownSenderEmail}
+		err := processEmail(context.TODO(), client, record)
+		assert.ErrorIs(t, err, ErrEmailUnrecognizedSender)
+		assert.Equal(t, 0, client.copyObjectCalls)
+		assert.Equal(t, map[string]float64{"inbound.kind": 1, "email.untrusted": 1}, sentMetrics)
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		resetMetrics()
+		env.UnknownSenderPolicy = UnknownSenderPolicyQuarantine
+		client := &mockS3API{body: unknownSenderEmail}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.Equal(t, 1, client.copyObjectCalls)
+		assert.Equal(t, "failed/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+		assert.Nil(t, client.copyObjectInput.Tagging)
+		assert.Equal(t, map[string]float64{"inbound.kind": 1, "email.unknown_sender_quarantined": 1}, sentMetrics)
+	})
+
+	t.Run("archive_flagged", func(t *testing.T) {
+		resetMetrics()
+		env.UnknownSenderPolicy = UnknownSenderPolicyArchiveFlagged
+		client := &mockS3API{body: unknownSenderEmail}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.Equal(t, 1, client.copyObjectCalls)
+		assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+		assert.Equal(t, "sender_verified=false", aws.ToString(client.copyObjectInput.Tagging))
+		assert.Equal(t, map[string]string{"sender-verified": "false"}, client.copyObjectInput.Metadata)
+		assert.Equal(t, map[string]float64{"inbound.kind": 1, "email.unverified_sender": 1}, sentMetrics)
+	})
+
+	t.Run("policy applies after DKIM check", func(t *testing.T) {
+		resetMetrics()
+		env.UnknownSenderPolicy, env.StrictDKIMCheck = UnknownSenderPolicyQuarantine, true
+		t.Cleanup(func() { env.StrictDKIMCheck = false })
+		goodEmail, err := os.ReadFile("fixtures/good.eml")
+		require.NoError(t, err)
+		client := &mockS3API{body: goodEmail}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.Equal(t, 1, client.copyObjectCalls)
+		assert.Equal(t, "failed/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+	})
+
+	t.Run("failed content checks are rejected regardless of policy", func(t *testing.T) {
+		resetMetrics()
+		env.UnknownSenderPolicy = UnknownSenderPolicyArchiveFlagged
+		spamEmail, err := os.ReadFile("fixtures/bad_spam.eml")
+		require.NoError(t, err)
+		client := &mockS3API{body: spamEmail}
+		assert.ErrorIs(t, processEmail(context.TODO(), client, record), ErrEmailSpamCheckFailed)
+		assert.Equal(t, 0, client.copyObjectCalls)
+	})
+}
+
+func TestProcessEmailSuspectedSpoof(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.UnknownSenderPolicy = UnknownSenderPolicyReject })
+	var auditEvents bytes.Buffer
+	restoreAuditLogger := auditLogger
+	auditLogger = log.NewJSONLogger(&auditEvents)
+	t.Cleanup(func() { auditLogger = restoreAuditLogger })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	reset := func() {
+		auditEvents.Reset()
+		for k := range sentMetrics {
+			delete(sentMetrics, k)
+		}
+	}
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	for _, policy := range []string{UnknownSenderPolicyReject, UnknownSenderPolicyQuarantine} {
+		t.Run(fmt.Sprintf("spoofed name with %s policy", policy), func(t *testing.T) {
+			reset()
+			env.UnknownSenderPolicy = policy
+			spoofedEmail, err := os.ReadFile("fixtures/bad_spoofedSender.eml")
+			require.NoError(t, err)
+			client := &mockS3API{body: spoofedEmail}
+			err = processEmail(context.TODO(), client, record)
+			assert.ErrorIs(t, err, ErrEmailSuspectedSpoof)
+			assert.Equal(t, ErrEmailSuspectedSpoof.Error(), eventHelpers.ClassifyError(err))
+			require.Equal(t, 1, client.copyObjectCalls, "Suspected spoof should be quarantined")
+			assert.Equal(t, "quarantine/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+			assert.Equal(t, "sender_verified=false", aws.ToString(client.copyObjectInput.Tagging))
+			assert.Equal(t, map[string]float64{"inbound.kind": 1, "email.suspected_spoof": 1}, sentMetrics)
+
+			var decision map[string]interface{}
+			require.NoError(t, json.NewDecoder(&auditEvents).Decode(&decision))
+			assert.Equal(t, "reject", decision["decision"])
+			assert.Equal(t, "sender_spoof", decision["rule"])
+			assert.Equal(t, "randomaddr@gmail.com", decision["subject"])
+			assert.Equal(t, "FFIS", decision["imitated_organization"])
+			assert.Equal(t, `"FFIS Updates" <randomaddr@gmail.com>`, decision["from"])
+		})
+	}
+
+	t.Run("unrelated sender", func(t *testing.T) {
+		reset()
+		badSenderEmail, err := os.ReadFile("fixtures/bad_sender.eml")
+		require.NoError(t, err)
+		client := &mockS3API{body: badSenderEmail}
+		err = processEmail(context.TODO(), client, record)
+		assert.ErrorIs(t, err, ErrEmailUnrecognizedSender)
+		assert.NotErrorIs(t, err, ErrEmailSuspectedSpoof)
+		assert.Equal(t, ErrEmailUnrecognizedSender.Error(), eventHelpers.ClassifyError(err))
+		assert.Equal(t, 0, client.copyObjectCalls)
+		assert.Equal(t, map[string]float64{"inbound.kind": 1, "email.untrusted": 1}, sentMetrics)
+		assert.NotContains(t, auditEvents.String(), "sender_spoof")
+	})
+
+	t.Run("legitimate sender using organization name", func(t *testing.T) {
+		reset()
+		goodEmail, err := os.ReadFile("fixtures/good.eml")
+		require.NoError(t, err)
+		client := &mockS3API{body: bytes.Replace(goodEmail,
+			[]byte("From: Some Person <"), []byte("From: FFIS Updates <"), 1)}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.Equal(t, 1, client.copyObjectCalls)
+		assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+		assert.Equal(t, map[string]float64{"inbound.kind": 1}, sentMetrics)
+		assert.NotContains(t, auditEvents.String(), "sender_spoof")
+	})
+}
+
+func TestHandleEventMultipleSources(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	env.SourcesConfig = `[
+		{"keyPrefix": "ses/ffis_ingest/new/", "validSenders": ["example.org"], "destinationSubpath": "ffis.org"},
+		{"keyPrefix": "ses/state_updates/new/", "validSenders": ["unrecognizeddomain.xyz"], "destinationSubpath": "state_updates"}
+	]`
+	var err error
+	sources, err = loadSourcesConfig(env)
+	require.NoError(t, err)
+	t.Cleanup(func() { setupLambdaEnvForTesting(t) })
+
+	// Records are processed concurrently, so metrics are recorded under a lock
+	var mu sync.Mutex
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sentMetrics[metric] += value
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	sourceBucket := "source-bucket"
+	svc := setupS3ForTesting(t, sourceBucket, env.DestinationBucket)
+	records := []events.S3EventRecord{}
+	for key, fixture := range map[string]string{
+		"ses/ffis_ingest/new/abc123":   "fixtures/good.eml",
+		"ses/state_updates/new/def456": "fixtures/bad_sender.eml",
+		"ses/unconfigured/new/ghi789":  "fixtures/good.eml",
+	} {
+		_, err := svc.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(sourceBucket),
+			Key:    aws.String(key),
+			Body:   getFixture(t, fixture),
+		})
+		require.NoError(t, err)
+		records = append(records, events.S3EventRecord{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: sourceBucket},
+			Object: events.S3Object{Key: key},
+		}})
+	}
+
+	require.NoError(t, handleEvent(context.Background(), svc, events.S3Event{Records: records}))
+
+	for _, key := range []string{
+		"sources/2023/04/22/ffis.org/raw.eml",
+		"sources/2023/04/22/state_updates/raw.eml",
+	} {
+		_, err = svc.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    aws.String(key),
+		})
+		assert.NoError(t, err, "Could not find the copied destination S3 object %s", key)
+	}
+	resp, err := svc.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(env.DestinationBucket),
+	})
+	require.NoError(t, err)
+	assert.Len(t, resp.Contents, 2, "Unmatched email should not be copied")
+	assert.Equal(t, float64(1), sentMetrics["email.unmatched_source"])
+	assert.NotContains(t, sentMetrics, "email.untrusted")
+}
+
+func TestProcessEmailSourceTags(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	env.SourcesConfig = `[
+		{"keyPrefix": "ses/ffis_ingest/new/", "validSenders": ["example.org"], "destinationSubpath": "ffis.org"},
+		{"name": "state-updates", "keyPrefix": "ses/state_updates/new/", "validSenders": ["example.org"], "destinationSubpath": "state_updates"}
+	]`
+	var err error
+	sources, err = loadSourcesConfig(env)
+	require.NoError(t, err)
+	t.Cleanup(func() { setupLambdaEnvForTesting(t) })
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+	t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+	sentTags := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentTags[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	spamEmail, err := os.ReadFile("fixtures/bad_spam.eml")
+	require.NoError(t, err)
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		key             string
+		expectedSource  string
+		expectedDestKey string
+	}{
+		{"ses/ffis_ingest/new/abc123", "ffis.org", "sources/2023/04/22/ffis.org/raw.eml"},
+		{"ses/state_updates/new/def456", "state-updates", "sources/2023/04/22/state_updates/raw.eml"},
+	} {
+		t.Run(tt.expectedSource, func(t *testing.T) {
+			record := events.S3EventRecord{S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "source-bucket"},
+				Object: events.S3Object{Key: tt.key},
+			}}
+			client := &mockS3API{body: goodEmail}
+			require.NoError(t, processEmail(context.TODO(), client, record))
+			assert.Equal(t, tt.expectedDestKey, aws.ToString(client.copyObjectInput.Key))
+			spans := recorder.Ended()
+			require.NotEmpty(t, spans)
+			recordSpan := spans[len(spans)-1]
+			require.Equal(t, "handle.record", recordSpan.Name())
+			assert.Contains(t, recordSpan.Attributes(), attribute.String("source", tt.expectedSource))
+
+			assert.Error(t, processEmail(context.TODO(), &mockS3API{body: spamEmail}, record))
+			assert.Equal(t, []string{"source:" + tt.expectedSource}, sentTags["email.untrusted"])
+		})
+	}
+}
+
+func TestProcessEmailTracing(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	recorder := tracetest.NewSpanRecorder()
+	tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+	t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+	spansByName := func() map[string]sdktrace.ReadOnlySpan {
+		spans := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+		return spans
+	}
+
+	t.Run("successful record", func(t *testing.T) {
+		goodEmail, err := os.ReadFile("fixtures/good.eml")
+		require.NoError(t, err)
+		require.NoError(t, processEmail(context.TODO(), &mockS3API{body: goodEmail}, record))
+
+		spans := spansByName()
+		require.Contains(t, spans, "handle.record")
+		recordSpan := spans["handle.record"]
+		assert.Contains(t, recordSpan.Attributes(), attribute.String("source_key", "ses/ffis_ingest/new/abc123"))
+		assert.Contains(t, recordSpan.Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeSuccess))
+		for _, name := range []string{"email.fetch", "email.parse", "email.validate", "email.upload"} {
+			require.Contains(t, spans, name)
+			assert.Equal(t, recordSpan.SpanContext().SpanID(), spans[name].Parent().SpanID(),
+				"%s should be a child of handle.record", name)
+			assert.Contains(t, spans[name].Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeSuccess))
+		}
+		assert.Contains(t, spans["email.fetch"].Attributes(), attribute.Int("bytes", len(goodEmail)))
+		assert.Contains(t, spans["email.parse"].Attributes(), attribute.Bool("compressed", false))
+		assert.Contains(t, spans["email.validate"].Attributes(), attribute.String("destination_prefix", "sources"))
+		assert.Contains(t, spans["email.upload"].Attributes(), attribute.Int("bytes", len(goodEmail)))
+		assert.Contains(t, spans["email.upload"].Attributes(),
+			attribute.String("destination_key", "sources/2023/04/22/ffis.org/raw.eml"))
+	})
+
+	t.Run("failed validation", func(t *testing.T) {
+		recorder = tracetest.NewSpanRecorder()
+		tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+			sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+		badSenderEmail, err := os.ReadFile("fixtures/bad_sender.eml")
+		require.NoError(t, err)
+		assert.Error(t, processEmail(context.TODO(), &mockS3API{body: badSenderEmail}, record))
+
+		spans := spansByName()
+		require.Contains(t, spans, "handle.record")
+		require.Contains(t, spans, "email.validate")
+		assert.NotContains(t, spans, "email.upload")
+		assert.Equal(t, codes.Error, spans["email.validate"].Status().Code)
+		assert.Contains(t, spans["email.validate"].Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeError))
+		assert.Equal(t, codes.Error, spans["handle.record"].Status().Code)
+		assert.Contains(t, spans["email.fetch"].Attributes(), attribute.String(tracing.OutcomeTag, tracing.OutcomeSuccess))
+	})
+}
+
+func TestProcessEmailAudit(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	var auditEvents bytes.Buffer
+	restoreAuditLogger := auditLogger
+	auditLogger = log.NewJSONLogger(&auditEvents)
+	t.Cleanup(func() { auditLogger = restoreAuditLogger })
+	decodeAuditEvents := func(t *testing.T) []map[string]interface{} {
+		t.Helper()
+		decoded := []map[string]interface{}{}
+		dec := json.NewDecoder(&auditEvents)
+		for dec.More() {
+			event := map[string]interface{}{}
+			require.NoError(t, dec.Decode(&event))
+			decoded = append(decoded, event)
+		}
+		return decoded
+	}
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	t.Run("accepted email", func(t *testing.T) {
+		auditEvents.Reset()
+		goodEmail, err := os.ReadFile("fixtures/good.eml")
+		require.NoError(t, err)
+		require.NoError(t, processEmail(context.TODO(), &mockS3API{body: goodEmail}, record))
+
+		decisions := decodeAuditEvents(t)
+		require.Len(t, decisions, 2)
+		for i, rule := range []string{"sender_allowlist", "email_contents"} {
+			assert.Equal(t, "accept", decisions[i]["decision"])
+			assert.Equal(t, rule, decisions[i]["rule"])
+			assert.Equal(t, "some.person@example.org", decisions[i]["subject"])
+			assert.Equal(t, "ses/ffis_ingest/new/abc123", decisions[i]["source_key"])
+		}
+	})
+
+	t.Run("rejected sender", func(t *testing.T) {
+		auditEvents.Reset()
+		badSenderEmail, err := os.ReadFile("fixtures/bad_sender.eml")
+		require.NoError(t, err)
+		assert.ErrorIs(t, processEmail(context.TODO(), &mockS3API{body: badSenderEmail}, record),
+			ErrEmailUnrecognizedSender)
+
+		decisions := decodeAuditEvents(t)
+		require.Len(t, decisions, 1)
+		assert.Equal(t, "reject", decisions[0]["decision"])
+		assert.Equal(t, "sender_allowlist", decisions[0]["rule"])
+		assert.Equal(t, "whoami@unrecognizeddomain.xyz", decisions[0]["subject"])
+		assert.Equal(t, ErrEmailUnrecognizedSender.Error(), decisions[0]["reason"])
+		assert.Equal(t, UnknownSenderPolicyReject, decisions[0]["unknown_sender_policy"])
+	})
+}
+
+func TestHandleEventRestoreEvents(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() { env.ProcessRestoreEvents = false })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	event := events.S3Event{Records: []events.S3EventRecord{
+		{
+			EventName: "ObjectRestore:Post",
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "source-bucket"},
+				Object: events.S3Object{Key: "ses/ffis_ingest/new/restoring"},
+			},
+		},
+		{
+			EventName: eventHelpers.S3EventObjectRestoreCompleted,
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: "source-bucket"},
+				Object: events.S3Object{Key: "ses/ffis_ingest/new/restored"},
+			},
+		},
+	}}
+
+	t.Run("restore events are skipped when disabled", func(t *testing.T) {
+		sentMetrics = make(map[string]float64)
+		env.ProcessRestoreEvents = false
+		client := &mockS3API{body: goodEmail}
+		require.NoError(t, handleEvent(context.TODO(), client, event))
+		assert.Equal(t, 0, client.copyObjectCalls)
+		assert.Equal(t, float64(2), sentMetrics["record.skipped"])
+	})
+
+	t.Run("restored email is archived when enabled", func(t *testing.T) {
+		sentMetrics = make(map[string]float64)
+		env.ProcessRestoreEvents = true
+		client := &mockS3API{body: goodEmail}
+		require.NoError(t, handleEvent(context.TODO(), client, event))
+		require.Equal(t, 1, client.copyObjectCalls)
+		assert.Equal(t, "source-bucket/ses/ffis_ingest/new/restored",
+			aws.ToString(client.copyObjectInput.CopySource))
+		assert.Equal(t, float64(1), sentMetrics["record.skipped"])
+	})
+}
+
+func TestHandleInvocationUnknownEventVersion(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	for _, tt := range []struct {
+		version   string
+		expMetric float64
+	}{
+		{"2.2", 0},
+		{"3.0", 1},
+	} {
+		t.Run(tt.version, func(t *testing.T) {
+			sentMetrics = make(map[string]float64)
+			client := &mockS3API{body: goodEmail}
+			_, err := handleInvocation(context.TODO(), client, json.RawMessage(fmt.Sprintf(`{"Records": [{
+				"eventVersion": %q,
+				"eventSource": "aws:s3",
+				"eventName": "ObjectCreated:Put",
+				"s3": {"bucket": {"name": "source-bucket"}, "object": {"key": "ses/ffis_ingest/new/abc123"}}
+			}]}`, tt.version)))
+			require.NoError(t, err)
+			assert.Equal(t, 1, client.copyObjectCalls, "email should be archived regardless of event version")
+			assert.Equal(t, tt.expMetric, sentMetrics["record.unknown_event_version"])
+		})
+	}
+}
+
+func TestHandleEventFailureNotification(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	t.Cleanup(func() { failureNotifier = nil })
+
+	var mu sync.Mutex
+	notifications := []eventHelpers.FailureNotification{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification eventHelpers.FailureNotification
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&notification))
+		mu.Lock()
+		defer mu.Unlock()
+		notifications = append(notifications, notification)
+	}))
+	t.Cleanup(webhook.Close)
+	event := events.S3Event{Records: []events.S3EventRecord{{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}}}
+
+	t.Run("successful invocation is not notified", func(t *testing.T) {
+		failureNotifier, err = eventHelpers.NewFailureNotifier("ReceiveFFISEmail",
+			webhook.URL, eventHelpers.NotificationFormatJSON, "")
+		require.NoError(t, err)
+		require.NoError(t, handleEvent(context.TODO(), &mockS3API{body: goodEmail}, event))
+		assert.Empty(t, notifications)
+	})
+
+	t.Run("failed invocation is notified", func(t *testing.T) {
+		failureNotifier, err = eventHelpers.NewFailureNotifier("ReceiveFFISEmail",
+			webhook.URL, eventHelpers.NotificationFormatJSON, "")
+		require.NoError(t, err)
+		client := &mockS3API{body: goodEmail, getObjectErrors: []error{&types.NoSuchKey{}}}
+		require.Error(t, handleEvent(context.TODO(), client, event))
+		require.Len(t, notifications, 1)
+		assert.Equal(t, "ReceiveFFISEmail", notifications[0].Handler)
+		assert.Equal(t, []string{"ses/ffis_ingest/new/abc123"}, notifications[0].FailedKeys)
+		assert.Equal(t, map[string]int{"NoSuchKey": 1}, notifications[0].ErrorClasses)
+	})
+
+	t.Run("notification failure does not change the invocation error", func(t *testing.T) {
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(unavailable.Close)
+		failureNotifier, err = eventHelpers.NewFailureNotifier("ReceiveFFISEmail",
+			unavailable.URL, eventHelpers.NotificationFormatSlack, "")
+		require.NoError(t, err)
+		client := &mockS3API{body: goodEmail, getObjectErrors: []error{&types.NoSuchKey{}}}
+		err := handleEvent(context.TODO(), client, event)
+		var invocationErr *eventHelpers.InvocationError
+		require.ErrorAs(t, err, &invocationErr)
+		assert.Equal(t, 1, invocationErr.Summary.Failed)
+		assert.Equal(t, float64(1), sentMetrics["notification.failed"])
+	})
+}
+
+func TestHandleRecordsRecordTimeout(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	env.RecordTimeout = 50 * time.Millisecond
+	env.DeterministicOrder = true // a stuck record must not hold up the records after it
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	records := []events.S3EventRecord{}
+	for _, key := range []string{"ses/ffis_ingest/new/a-stuck", "ses/ffis_ingest/new/b"} {
+		records = append(records, events.S3EventRecord{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: "source-bucket"},
+			Object: events.S3Object{Key: key},
+		}})
+	}
+
+	t.Run("stuck record times out while its siblings complete", func(t *testing.T) {
+		sentMetrics = make(map[string]float64)
+		recorder := tracetest.NewSpanRecorder()
+		tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+			sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+		t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+		client := &mockS3API{body: goodEmail, blockingKeys: map[string]bool{"ses/ffis_ingest/new/a-stuck": true}}
+
+		results, err := handleRecords(context.Background(), client, records)
+		assert.ErrorIs(t, err, eventHelpers.ErrRecordTimeout)
+		require.Len(t, results, 2)
+		assert.Equal(t, eventHelpers.RecordStatusFailed, results[0].Status)
+		assert.Equal(t, "RecordTimeout", results[0].ErrorClass)
+		assert.Equal(t, eventHelpers.RecordStatusSucceeded, results[1].Status)
+		assert.Equal(t, 1, client.copyObjectCalls, "sibling record should be archived")
+		assert.Equal(t, float64(1), sentMetrics["record.timeout"])
+
+		timedOutKeys := []string{}
+		for _, span := range recorder.Ended() {
+			attrs := attribute.NewSet(span.Attributes()...)
+			if timedOut, ok := attrs.Value("timed_out"); span.Name() == "handle.record" && ok && timedOut.AsBool() {
+				key, _ := attrs.Value("source_key")
+				timedOutKeys = append(timedOutKeys, key.AsString())
+			}
+		}
+		assert.Equal(t, []string{"ses/ffis_ingest/new/a-stuck"}, timedOutKeys,
+			"only the stuck record's span should be tagged as timed out")
+	})
+
+	t.Run("sooner invocation deadline is not a record timeout", func(t *testing.T) {
+		sentMetrics = make(map[string]float64)
+		env.RecordTimeout = time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		client := &mockS3API{body: goodEmail, blockingKeys: map[string]bool{"ses/ffis_ingest/new/a-stuck": true}}
+
+		results, err := handleRecords(ctx, client, records[:1])
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.NotErrorIs(t, err, eventHelpers.ErrRecordTimeout)
+		require.Len(t, results, 1)
+		assert.Equal(t, "DeadlineExceeded", results[0].ErrorClass)
+		assert.NotContains(t, sentMetrics, "record.timeout")
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/emailHelpers"
+)
+
+func TestProcessEmailInboundKind(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	sentTags := make(map[string][][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		sentTags[metric] = append(sentTags[metric], tags)
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	for _, tt := range []struct {
+		fixture      string
+		expectedKind string
+		expectCopy   bool
+	}{
+		{"fixtures/good.eml", emailHelpers.InboundKindRawEmail, true},
+		{"fixtures/ses_notification.json", emailHelpers.InboundKindSESNotification, false},
+	} {
+		t.Run(tt.expectedKind, func(t *testing.T) {
+			for k := range sentTags {
+				delete(sentTags, k)
+			}
+			data, err := os.ReadFile(tt.fixture)
+			require.NoError(t, err)
+			client := &mockS3API{body: data}
+			require.NoError(t, processEmail(context.TODO(), client, record))
+
+			assert.Equal(t, [][]string{{"kind:" + tt.expectedKind, "source:ffis.org"}}, sentTags["inbound.kind"])
+			if tt.expectCopy {
+				require.Equal(t, 1, client.copyObjectCalls)
+				assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+			} else {
+				assert.Equal(t, 0, client.copyObjectCalls, "SES notifications should not be archived")
+			}
+		})
+	}
+}
@@ -3,32 +3,79 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	goLog "log"
+	"time"
+	_ "time/tzdata"
 
 	ddlambda "github.com/DataDog/datadog-lambda-go"
 	goenv "github.com/Netflix/go-env"
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/sentryHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
 )
 
 type Environment struct {
-	LogLevel            string `env:"LOG_LEVEL,default=INFO"`
-	DestinationBucket   string `env:"GRANTS_SOURCE_DATA_BUCKET_NAME,required=true"`
-	UsePathStyleS3Opt   bool   `env:"S3_USE_PATH_STYLE,default=false"`
-	AllowedEmailSenders string `env:"ALLOWED_EMAIL_SENDERS,required=true"`
-	Extras              goenv.EnvSet
+	LogLevel                   string        `env:"LOG_LEVEL,default=INFO"`
+	DestinationBucket          string        `env:"GRANTS_SOURCE_DATA_BUCKET_NAME,required=true"`
+	UsePathStyleS3Opt          bool          `env:"S3_USE_PATH_STYLE,default=false"`
+	AllowedEmailSenders        string        `env:"ALLOWED_EMAIL_SENDERS"`
+	SourcesConfig              string        `env:"SOURCES_CONFIG"`
+	MaxFetchBackoff            time.Duration `env:"MAX_FETCH_BACKOFF,default=5s"`
+	DownloadChunkLimit         int64         `env:"DOWNLOAD_CHUNK_LIMIT,default=10"`
+	QuarantineSuspiciousEmails bool          `env:"QUARANTINE_SUSPICIOUS_EMAILS,default=false"`
+	StrictDKIMCheck            bool          `env:"STRICT_DKIM_CHECK,default=false"`
+	MaxMIMEDepth               int           `env:"MAX_MIME_DEPTH,default=10"`
+	BackfillPrefix             string        `env:"BACKFILL_PREFIX,default=ses/ffis_ingest/backfill/"`
+	KeyDateGranularity         string        `env:"KEY_DATE_GRANULARITY,default=day"`
+	UnknownSenderPolicy        string        `env:"UNKNOWN_SENDER_POLICY,default=reject"`
+	FromAddressPolicy          string        `env:"FROM_ADDRESS_POLICY,default=strict"`
+	SentryDSN                  string        `env:"SENTRY_DSN" redact:"true"`
+	DeterministicOrder         bool          `env:"DETERMINISTIC_ORDER,default=false"`
+	TracingProvider            string        `env:"TRACING_PROVIDER,default=datadog"`
+	ExpectedDeliveryDOWs       string        `env:"EXPECTED_DELIVERY_DOWS"`
+	ExpectedDeliveryTimezone   string        `env:"EXPECTED_DELIVERY_TIMEZONE,default=UTC"`
+	AuditLogLevel              string        `env:"AUDIT_LOG_LEVEL,default=INFO"`
+	AuditLogSink               string        `env:"AUDIT_LOG_SINK,default=stdout"`
+	ProcessRestoreEvents       bool          `env:"PROCESS_RESTORE_EVENTS,default=false"`
+	WebhookURL                 string        `env:"WEBHOOK_URL" redact:"true"`
+	WebhookFormat              string        `env:"WEBHOOK_FORMAT,default=json"`
+	WebhookLogsURLTemplate     string        `env:"WEBHOOK_LOGS_URL_TEMPLATE"`
+	PipelineMaxAttempts        int           `env:"PIPELINE_MAX_ATTEMPTS,default=1"`
+	PipelineRetryErrorClasses  string        `env:"PIPELINE_RETRY_ERROR_CLASSES"`
+	PipelineRetryDelay         time.Duration `env:"PIPELINE_RETRY_DELAY,default=1s"`
+	DestinationSSE             string        `env:"DESTINATION_SSE,default=AES256"`
+	DestinationKMSKeyID        string        `env:"DESTINATION_KMS_KEY_ID"`
+	SenderEncryptionConfig     string        `env:"SENDER_ENCRYPTION_CONFIG"`
+	TenantConfig               string        `env:"TENANT_CONFIG"`
+	DisabledFeatures           string        `env:"DISABLED_FEATURES"`
+	RecordTimeout              time.Duration `env:"RECORD_TIMEOUT,default=0"`
+	ObjectLockRetainDays       int           `env:"OBJECT_LOCK_RETAIN_DAYS,default=0"`
+	ObjectLockMode             string        `env:"OBJECT_LOCK_MODE,default=GOVERNANCE"`
+	OrganizationNames          string        `env:"ORGANIZATION_NAMES"`
+	DateHeaderPreference       string        `env:"DATE_HEADER_PREFERENCE,default=date"`
+	DateHeaderTolerance        time.Duration `env:"DATE_HEADER_TOLERANCE,default=1h"`
+	ExpectedRecipients         string        `env:"EXPECTED_RECIPIENTS"`
+	UnexpectedRecipientPolicy  string        `env:"UNEXPECTED_RECIPIENT_POLICY,default=warn"`
+	Extras                     goenv.EnvSet  `redact:"true"`
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("ReceiveFFISEmail")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	auditLogger      = log.NewNopAuditLogger()
+	failureNotifier  *eventHelpers.FailureNotifier
+	sources          []SourceConfig
+	sendMetric       = ddHelpers.NewMetricSender("ReceiveFFISEmail")
 )
 
 func main() {
@@ -38,20 +85,89 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	auditSink, err := log.AuditSink(env.AuditLogSink)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	log.ConfigureAuditLogger(&auditLogger, env.AuditLogLevel, auditSink)
+	failureNotifier, err = eventHelpers.NewFailureNotifier("ReceiveFFISEmail",
+		env.WebhookURL, env.WebhookFormat, env.WebhookLogsURLTemplate)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if _, err := keyDateLayout(env.KeyDateGranularity); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := validateUnknownSenderPolicy(env.UnknownSenderPolicy); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := validateFromAddressPolicy(env.FromAddressPolicy); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := validateDateHeaderPreference(env.DateHeaderPreference); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := validateUnexpectedRecipientPolicy(env.UnexpectedRecipientPolicy); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	sources, err = loadSourcesConfig(env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	destinationEncryption, senderEncryption, err = loadEncryptionConfig(env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	organizationNames = parseOrganizationNames(env.OrganizationNames)
+	expectedRecipients = parseExpectedRecipients(env.ExpectedRecipients)
+	destinationRetention, err = loadRetentionConfig(env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	tenants, err = loadTenantConfig(env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	expectedDeliveryDays, err = parseExpectedDeliveryDays(env.ExpectedDeliveryDOWs)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	deliveryLocation, err = time.LoadLocation(env.ExpectedDeliveryTimezone)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	pipelineRetryErrorClasses = parsePipelineRetryErrorClasses(env.PipelineRetryErrorClasses)
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+	if err := sentryHelpers.Init(env.SentryDSN); err != nil {
+		log.Warn(logger, "Sentry error reporting is disabled", "error", err)
+	}
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(
-		func(ctx context.Context, event events.S3Event) error {
+		func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+			defer ddHelpers.FlushMetricsAndLog(logger)
+			defer ddHelpers.StartInvocation(sendMetric)()
+			defer sentryHelpers.Flush()
+			defer tracing.Flush(ctx)
 			cfg, err := awsHelpers.GetConfig(ctx)
 			if err != nil {
-				return fmt.Errorf("could not create AWS SDK config: %w", err)
+				return nil, fmt.Errorf("could not create AWS SDK config: %w", err)
 			}
 			awstrace.AppendMiddleware(&cfg)
 
 			s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
 				o.UsePathStyle = env.UsePathStyleS3Opt
 			})
-			return handleEvent(ctx, s3Client, event)
+			return handleInvocation(ctx, s3Client, payload)
 		}, nil),
 	)
 }
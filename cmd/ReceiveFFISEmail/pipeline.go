@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// Classes (as named by eventHelpers.ClassifyError) of processEmail errors that are retried,
+// as configured by env.PipelineRetryErrorClasses
+var pipelineRetryErrorClasses map[string]bool
+
+// parsePipelineRetryErrorClasses parses a comma-separated list of error class names
+// (e.g. "InternalError,SlowDown") as configured by PIPELINE_RETRY_ERROR_CLASSES.
+func parsePipelineRetryErrorClasses(s string) map[string]bool {
+	classes := make(map[string]bool)
+	for _, class := range strings.Split(s, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes[class] = true
+		}
+	}
+	return classes
+}
+
+// isRecoverablePipelineError returns true when err belongs to one of the error classes
+// for which processEmail is retried. Failures to assume a destination role are permanent,
+// since the role's permissions will not change between attempts.
+func isRecoverablePipelineError(err error) bool {
+	return err != nil && !errors.Is(err, awsHelpers.ErrAssumeRoleFailed) &&
+		pipelineRetryErrorClasses[eventHelpers.ClassifyError(err)]
+}
+
+// processEmailWithRetries calls processEmail for the record and, when it fails with a
+// recoverable error, calls it again until it succeeds or env.PipelineMaxAttempts attempts
+// have been made. Retried attempts do not repeat the copy to the destination bucket when an
+// earlier attempt already completed it (see destinationHasCopy).
+func processEmailWithRetries(ctx context.Context, client S3API, record events.S3EventRecord) error {
+	for attempt := 1; ; attempt++ {
+		err := processEmail(withPipelineAttempt(ctx, attempt), client, record)
+		if err == nil || attempt >= env.PipelineMaxAttempts || !isRecoverablePipelineError(err) {
+			return err
+		}
+		log.Warn(logger, "Retrying email processing after recoverable failure", "error", err,
+			"attempt", attempt, "retry_in", env.PipelineRetryDelay,
+			"source_bucket", record.S3.Bucket.Name, "source_key", record.S3.Object.Key)
+		sendMetric("email.pipeline_retry", 1, fmt.Sprintf("error_class:%s", eventHelpers.ClassifyError(err)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(env.PipelineRetryDelay):
+		}
+	}
+}
+
+type pipelineAttemptKey struct{}
+
+// withPipelineAttempt returns a context which identifies the attempt number of processEmail.
+func withPipelineAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, pipelineAttemptKey{}, attempt)
+}
+
+// pipelineAttempt returns the attempt number of processEmail identified by ctx,
+// which is 1 when ctx does not identify an attempt.
+func pipelineAttempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(pipelineAttemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// destinationHasCopy reports whether the object at key in bucket already has
+// the given contents, by requesting the object on condition that its ETag matches the MD5
+// checksum of data (which is the ETag of an object created by a single-part copy).
+// Since the ETag of an SSE-KMS encrypted object is not its MD5 checksum, such objects are
+// never reported as matching, and are copied again.
+func destinationHasCopy(ctx context.Context, client S3API, bucket, key string, data []byte) (bool, error) {
+	sum := md5.Sum(data)
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		IfMatch: aws.String(fmt.Sprintf("%q", hex.EncodeToString(sum[:]))),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey", "PreconditionFailed":
+			return false, nil
+		}
+	}
+	return false, err
+}
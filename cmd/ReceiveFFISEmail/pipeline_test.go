@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePipelineRetryErrorClasses(t *testing.T) {
+	assert.Empty(t, parsePipelineRetryErrorClasses(""))
+	assert.Equal(t, map[string]bool{"InternalError": true, "SlowDown": true},
+		parsePipelineRetryErrorClasses(" InternalError,,SlowDown "))
+}
+
+func TestProcessEmailWithRetries(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	env.PipelineMaxAttempts = 3
+	env.PipelineRetryDelay = 0
+	pipelineRetryErrorClasses = parsePipelineRetryErrorClasses("InternalError")
+	t.Cleanup(func() { pipelineRetryErrorClasses = nil })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	t.Run("recoverable partial failure succeeds on retry without copying again", func(t *testing.T) {
+		sentMetrics = make(map[string]float64)
+		// The copy is completed, but its response is lost
+		client := &mockS3API{body: goodEmail, copyObjectErrors: []error{
+			&smithy.GenericAPIError{Code: "InternalError"},
+		}}
+		require.NoError(t, processEmailWithRetries(context.TODO(), client, record))
+		assert.Equal(t, 2, client.getObjectCalls)
+		assert.Equal(t, 1, client.headObjectCalls)
+		assert.Equal(t, 1, client.copyObjectCalls, "copy should not be repeated")
+		assert.Equal(t, float64(1), sentMetrics["email.pipeline_retry"])
+		assert.Equal(t, float64(1), sentMetrics["email.copy_skipped"])
+	})
+
+	t.Run("retried attempt copies again when the destination does not match", func(t *testing.T) {
+		client := &mockS3API{body: goodEmail, copyObjectErrors: []error{
+			&smithy.GenericAPIError{Code: "InternalError"},
+		}}
+		require.NoError(t, processEmailWithRetries(context.TODO(), &mismatchedCopyS3API{client}, record))
+		assert.Equal(t, 1, client.headObjectCalls)
+		assert.Equal(t, 2, client.copyObjectCalls)
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		client := &mockS3API{body: goodEmail, copyObjectErrors: []error{
+			&smithy.GenericAPIError{Code: "InternalError"},
+			&smithy.GenericAPIError{Code: "InternalError"},
+			&smithy.GenericAPIError{Code: "InternalError"},
+		}}
+		// The destination never matches, so every attempt copies again
+		err := processEmailWithRetries(context.TODO(), &mismatchedCopyS3API{client}, record)
+		assert.ErrorContains(t, err, "InternalError")
+		assert.Equal(t, 3, client.getObjectCalls)
+		assert.Equal(t, 3, client.copyObjectCalls)
+	})
+
+	t.Run("non-recoverable failure is not retried", func(t *testing.T) {
+		sentMetrics = make(map[string]float64)
+		client := &mockS3API{body: goodEmail, copyObjectErrors: []error{&types.ObjectNotInActiveTierError{}}}
+		assert.Error(t, processEmailWithRetries(context.TODO(), client, record))
+		assert.Equal(t, 1, client.getObjectCalls)
+		assert.Equal(t, 0, client.headObjectCalls)
+		assert.Equal(t, 1, client.copyObjectCalls)
+		assert.NotContains(t, sentMetrics, "email.pipeline_retry")
+	})
+
+	t.Run("retries are disabled with a single attempt", func(t *testing.T) {
+		env.PipelineMaxAttempts = 1
+		t.Cleanup(func() { env.PipelineMaxAttempts = 3 })
+		client := &mockS3API{body: goodEmail, copyObjectErrors: []error{
+			&smithy.GenericAPIError{Code: "InternalError"},
+		}}
+		assert.Error(t, processEmailWithRetries(context.TODO(), client, record))
+		assert.Equal(t, 1, client.copyObjectCalls)
+	})
+}
+
+// mismatchedCopyS3API simulates a destination object whose contents never match the email.
+type mismatchedCopyS3API struct{ *mockS3API }
+
+func (m *mismatchedCopyS3API) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	m.headObjectCalls++
+	return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+}
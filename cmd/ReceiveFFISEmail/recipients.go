@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Policies for handling emails that are not addressed to any of EXPECTED_RECIPIENTS
+const (
+	// UnexpectedRecipientPolicyWarn archives the email as usual after logging a warning.
+	UnexpectedRecipientPolicyWarn = "warn"
+	// UnexpectedRecipientPolicyReject fails the email without archiving it.
+	UnexpectedRecipientPolicyReject = "reject"
+)
+
+var (
+	ErrEmailUnexpectedRecipient = errors.New("email is not addressed to an expected recipient")
+	ErrUnknownRecipientPolicy   = errors.New("unknown unexpected recipient policy")
+)
+
+// Addresses or domains to which ingested emails are expected to be addressed (see
+// parseExpectedRecipients). Recipients are not checked when empty.
+var expectedRecipients []string
+
+// recipientAddressHeaders are the headers whose addresses are checked against expectedRecipients.
+// Emails that are BCC'd to the ingest address do not list it in To or Cc, so the headers that
+// record the envelope recipient (when added by a forwarding or receiving mail system) are
+// checked as well.
+var recipientAddressHeaders = []string{"To", "Cc", "X-Original-To", "Delivered-To"}
+
+// validateUnexpectedRecipientPolicy returns an error wrapping ErrUnknownRecipientPolicy
+// when policy is not a supported UNEXPECTED_RECIPIENT_POLICY value.
+func validateUnexpectedRecipientPolicy(policy string) error {
+	switch policy {
+	case UnexpectedRecipientPolicyWarn, UnexpectedRecipientPolicyReject:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownRecipientPolicy, policy)
+	}
+}
+
+// parseExpectedRecipients parses the comma-separated list of addresses and/or domains
+// configured by EXPECTED_RECIPIENTS, which are matched in the manner of ALLOWED_EMAIL_SENDERS
+// (so that "ingest@example.org" also matches plus-addressed recipients like
+// "ingest+statea@example.org").
+func parseExpectedRecipients(s string) []string {
+	recipients := []string{}
+	for _, recipient := range strings.Split(s, ",") {
+		if recipient = strings.ToLower(strings.TrimSpace(recipient)); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// emailRecipients returns the addresses of every recipientAddressHeaders header of an email,
+// in order. Each header may list multiple comma-separated recipients, including groups such
+// as "Team: a@example.org, b@example.org;" (whose members are returned) or
+// "undisclosed-recipients:;" (which lists none). Headers that cannot be parsed as a whole are
+// parsed one comma-separated item at a time, skipping malformed items.
+func emailRecipients(h mail.Header) []*mail.Address {
+	p := mail.AddressParser{}
+	addresses := []*mail.Address{}
+	for _, name := range recipientAddressHeaders {
+		for _, value := range h[name] {
+			if list, err := p.ParseList(value); err == nil {
+				addresses = append(addresses, list...)
+				continue
+			}
+			for _, item := range strings.Split(value, ",") {
+				if address, err := p.Parse(strings.TrimSpace(item)); err == nil {
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+	return addresses
+}
+
+// verifyEmailRecipients returns an error wrapping ErrEmailUnexpectedRecipient when none of
+// recipients match expected. Any recipients are accepted when expected is empty.
+func verifyEmailRecipients(recipients []*mail.Address, expected []string) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	for _, recipient := range recipients {
+		if emailAddressAllowed(recipient.Address, expected...) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrEmailUnexpectedRecipient, formatRecipients(recipients))
+}
+
+// formatRecipients formats the addresses of recipients as a comma-separated list.
+func formatRecipients(recipients []*mail.Address) string {
+	if len(recipients) == 0 {
+		return "no recipients"
+	}
+	formatted := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		formatted[i] = recipient.Address
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+func TestValidateUnexpectedRecipientPolicy(t *testing.T) {
+	assert.NoError(t, validateUnexpectedRecipientPolicy(UnexpectedRecipientPolicyWarn))
+	assert.NoError(t, validateUnexpectedRecipientPolicy(UnexpectedRecipientPolicyReject))
+	assert.ErrorIs(t, validateUnexpectedRecipientPolicy("quarantine"), ErrUnknownRecipientPolicy)
+}
+
+func TestParseExpectedRecipients(t *testing.T) {
+	assert.Empty(t, parseExpectedRecipients(""))
+	assert.Empty(t, parseExpectedRecipients(" , "))
+	assert.Equal(t, []string{"ingest@example.org", "example.com"},
+		parseExpectedRecipients(" Ingest@Example.org,, example.com "))
+}
+
+func TestEmailRecipients(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		headers  string
+		expected []string
+	}{
+		{"single To", "To: ingest@example.org\r\n", []string{"ingest@example.org"}},
+		{
+			"comma-separated recipients",
+			"To: \"Doe, Jane\" <jane@example.com>, ingest@example.org\r\n",
+			[]string{"jane@example.com", "ingest@example.org"},
+		},
+		{
+			"multiple To headers",
+			"To: jane@example.com\r\nTo: ingest@example.org\r\n",
+			[]string{"jane@example.com", "ingest@example.org"},
+		},
+		{
+			"To and Cc",
+			"To: jane@example.com\r\nCc: Grants Ingest <ingest@example.org>\r\n",
+			[]string{"jane@example.com", "ingest@example.org"},
+		},
+		{
+			"group syntax",
+			"To: Grants Team: jane@example.com, ingest@example.org;, john@example.com\r\n",
+			[]string{"jane@example.com", "ingest@example.org", "john@example.com"},
+		},
+		{
+			"BCC'd with undisclosed recipients",
+			"To: undisclosed-recipients:;\r\nX-Original-To: ingest@example.org\r\n",
+			[]string{"ingest@example.org"},
+		},
+		{"Delivered-To", "Delivered-To: ingest@example.org\r\n", []string{"ingest@example.org"}},
+		{
+			"malformed recipient among others",
+			"To: jane@example.com, <<not an address, ingest@example.org\r\n",
+			[]string{"jane@example.com", "ingest@example.org"},
+		},
+		{"no recipients", "Subject: hello\r\n", []string{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(tt.headers + "\r\nbody\r\n"))
+			require.NoError(t, err)
+			actual := []string{}
+			for _, address := range emailRecipients(msg.Header) {
+				actual = append(actual, address.Address)
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestVerifyEmailRecipients(t *testing.T) {
+	recipients := []*mail.Address{{Address: "jane@example.com"}, {Address: "Ingest+StateA@example.org"}}
+	assert.NoError(t, verifyEmailRecipients(recipients, nil))
+	assert.NoError(t, verifyEmailRecipients(recipients, []string{"ingest@example.org"}))
+	assert.NoError(t, verifyEmailRecipients(recipients, []string{"example.com"}))
+	assert.ErrorIs(t, verifyEmailRecipients(recipients, []string{"other@example.org"}),
+		ErrEmailUnexpectedRecipient)
+	assert.ErrorIs(t, verifyEmailRecipients(nil, []string{"ingest@example.org"}),
+		ErrEmailUnexpectedRecipient)
+}
+
+func TestProcessEmailUnexpectedRecipient(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	restoreExpectedRecipients := expectedRecipients
+	t.Cleanup(func() { expectedRecipients = restoreExpectedRecipients })
+	var auditEvents bytes.Buffer
+	restoreAuditLogger := auditLogger
+	auditLogger = log.NewJSONLogger(&auditEvents)
+	t.Cleanup(func() { auditLogger = restoreAuditLogger })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+	lastAuditEvent := func(t *testing.T) map[string]interface{} {
+		t.Helper()
+		var event map[string]interface{}
+		dec := json.NewDecoder(&auditEvents)
+		for dec.More() {
+			event = map[string]interface{}{}
+			require.NoError(t, dec.Decode(&event))
+		}
+		require.NotNil(t, event)
+		return event
+	}
+
+	for _, tt := range []struct {
+		name       string
+		expected   string
+		policy     string
+		expErr     error
+		expArchive bool
+		expMetric  bool
+	}{
+		{"not configured", "", UnexpectedRecipientPolicyReject, nil, true, false},
+		{"expected recipient", "anotherperson@example.com", UnexpectedRecipientPolicyReject, nil, true, false},
+		{"expected recipient domain", "example.com", UnexpectedRecipientPolicyReject, nil, true, false},
+		{"unexpected recipient with warn policy", "ingest@example.org", UnexpectedRecipientPolicyWarn, nil, true, true},
+		{
+			"unexpected recipient with reject policy",
+			"ingest@example.org",
+			UnexpectedRecipientPolicyReject,
+			ErrEmailUnexpectedRecipient,
+			false,
+			true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			auditEvents.Reset()
+			for k := range sentMetrics {
+				delete(sentMetrics, k)
+			}
+			expectedRecipients = parseExpectedRecipients(tt.expected)
+			env.UnexpectedRecipientPolicy = tt.policy
+			client := &mockS3API{body: goodEmail}
+
+			err := processEmail(context.TODO(), client, record)
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.expArchive {
+				require.NotNil(t, client.copyObjectInput)
+				assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+			} else {
+				assert.Nil(t, client.copyObjectInput, "Email should not be archived")
+			}
+
+			event := lastAuditEvent(t)
+			if tt.expMetric {
+				assert.Equal(t, 1.0, sentMetrics["email.unexpected_recipient"])
+				assert.Equal(t, "reject", event["decision"])
+				assert.Equal(t, "expected_recipients", event["rule"])
+				assert.Equal(t, "anotherperson@example.com", event["recipients"])
+				assert.Equal(t, tt.policy, event["unexpected_recipient_policy"])
+			} else {
+				assert.NotContains(t, sentMetrics, "email.unexpected_recipient")
+				assert.Equal(t, "accept", event["decision"])
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	ErrInvalidRetentionConfig = errors.New("invalid object lock retention configuration")
+	ErrObjectLockNotEnabled   = errors.New("object lock is not enabled for the destination bucket")
+)
+
+var (
+	// Retention of archived emails (see loadRetentionConfig)
+	destinationRetention RetentionConfig
+	// Destination buckets whose object lock configuration has been verified
+	objectLockBuckets sync.Map
+)
+
+// RetentionConfig describes the Object Lock retention of emails archived in the destination bucket.
+type RetentionConfig struct {
+	// Mode is either GOVERNANCE or COMPLIANCE.
+	Mode types.ObjectLockMode
+	// Days is the number of days for which each archived email is retained, or 0 when archived
+	// emails are not retained.
+	Days int
+}
+
+// loadRetentionConfig returns the retention configuration given by OBJECT_LOCK_RETAIN_DAYS and
+// OBJECT_LOCK_MODE. Retention is disabled when OBJECT_LOCK_RETAIN_DAYS is 0.
+func loadRetentionConfig(env Environment) (RetentionConfig, error) {
+	config := RetentionConfig{
+		Mode: types.ObjectLockMode(strings.ToUpper(strings.TrimSpace(env.ObjectLockMode))),
+		Days: env.ObjectLockRetainDays,
+	}
+	if config.Days < 0 {
+		return config, fmt.Errorf("%w: retention days must not be negative", ErrInvalidRetentionConfig)
+	}
+	switch config.Mode {
+	case types.ObjectLockModeGovernance, types.ObjectLockModeCompliance:
+	default:
+		return config, fmt.Errorf("%w: unsupported object lock mode %q",
+			ErrInvalidRetentionConfig, env.ObjectLockMode)
+	}
+	return config, nil
+}
+
+func (c RetentionConfig) enabled() bool {
+	return c.Days > 0
+}
+
+// retainUntil returns the time until which an object archived at archivedAt is retained.
+func (c RetentionConfig) retainUntil(archivedAt time.Time) time.Time {
+	return archivedAt.UTC().AddDate(0, 0, c.Days)
+}
+
+// applyToCopy configures input to retain the copied object (from archivedAt) according to c.
+// input is left unchanged when retention is disabled.
+func (c RetentionConfig) applyToCopy(input *s3.CopyObjectInput, archivedAt time.Time) {
+	if !c.enabled() {
+		return
+	}
+	input.ObjectLockMode = c.Mode
+	input.ObjectLockRetainUntilDate = aws.Time(c.retainUntil(archivedAt))
+}
+
+// verifyObjectLock returns an error wrapping ErrObjectLockNotEnabled when bucket was not created
+// with Object Lock enabled, in which case retention cannot be set on the objects written to it.
+// Successful verifications are cached for the lifetime of the execution environment.
+func verifyObjectLock(ctx context.Context, client S3API, bucket string) error {
+	if _, ok := objectLockBuckets.Load(bucket); ok {
+		return nil
+	}
+	resp, err := client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+		return fmt.Errorf("%w: %s", ErrObjectLockNotEnabled, bucket)
+	} else if err != nil {
+		return fmt.Errorf("error getting object lock configuration of bucket %q: %w", bucket, err)
+	}
+	if resp.ObjectLockConfiguration == nil ||
+		resp.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("%w: %s", ErrObjectLockNotEnabled, bucket)
+	}
+	objectLockBuckets.Store(bucket, true)
+	return nil
+}
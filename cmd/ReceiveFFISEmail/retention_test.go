@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRetentionConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		days      int
+		mode      string
+		expConfig RetentionConfig
+		expErr    error
+	}{
+		{"unconfigured", 0, "GOVERNANCE", RetentionConfig{Mode: types.ObjectLockModeGovernance}, nil},
+		{"governance", 365, "GOVERNANCE", RetentionConfig{Mode: types.ObjectLockModeGovernance, Days: 365}, nil},
+		{"compliance", 30, " compliance ", RetentionConfig{Mode: types.ObjectLockModeCompliance, Days: 30}, nil},
+		{"negative days", -1, "GOVERNANCE", RetentionConfig{}, ErrInvalidRetentionConfig},
+		{"unsupported mode", 30, "LEGAL_HOLD", RetentionConfig{}, ErrInvalidRetentionConfig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadRetentionConfig(Environment{ObjectLockRetainDays: tt.days, ObjectLockMode: tt.mode})
+			if tt.expErr != nil {
+				assert.ErrorIs(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expConfig, config)
+			assert.Equal(t, tt.days > 0, config.enabled())
+		})
+	}
+}
+
+func TestProcessEmailObjectLockRetention(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	restoreRetention := destinationRetention
+	t.Cleanup(func() {
+		destinationRetention = restoreRetention
+		objectLockBuckets.Delete(env.DestinationBucket)
+	})
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+	lockEnabled := &types.ObjectLockConfiguration{ObjectLockEnabled: types.ObjectLockEnabledEnabled}
+
+	t.Run("unconfigured", func(t *testing.T) {
+		destinationRetention = RetentionConfig{Mode: types.ObjectLockModeGovernance}
+		client := &mockS3API{body: goodEmail, objectLockConfig: lockEnabled}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.NotNil(t, client.copyObjectInput)
+		assert.Empty(t, client.copyObjectInput.ObjectLockMode)
+		assert.Nil(t, client.copyObjectInput.ObjectLockRetainUntilDate)
+		assert.Zero(t, client.objectLockConfigCalls, "Bucket should not be verified")
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		destinationRetention = RetentionConfig{Mode: types.ObjectLockModeCompliance, Days: 30}
+		client := &mockS3API{body: goodEmail, objectLockConfig: lockEnabled}
+		for i := 0; i < 2; i++ {
+			start := time.Now()
+			require.NoError(t, processEmail(context.TODO(), client, record))
+			require.NotNil(t, client.copyObjectInput)
+			assert.Equal(t, types.ObjectLockModeCompliance, client.copyObjectInput.ObjectLockMode)
+			require.NotNil(t, client.copyObjectInput.ObjectLockRetainUntilDate)
+			assert.WithinRange(t, *client.copyObjectInput.ObjectLockRetainUntilDate,
+				start.AddDate(0, 0, 30), time.Now().AddDate(0, 0, 30))
+		}
+		assert.Equal(t, 1, client.objectLockConfigCalls, "Bucket verification should be cached")
+	})
+
+	t.Run("bucket without object lock", func(t *testing.T) {
+		objectLockBuckets.Delete(env.DestinationBucket)
+		destinationRetention = RetentionConfig{Mode: types.ObjectLockModeGovernance, Days: 30}
+		client := &mockS3API{body: goodEmail}
+		assert.ErrorIs(t, processEmail(context.TODO(), client, record), ErrObjectLockNotEnabled)
+		assert.Zero(t, client.copyObjectCalls, "Email should not be archived without retention")
+
+		client = &mockS3API{body: goodEmail, objectLockConfig: &types.ObjectLockConfiguration{}}
+		assert.ErrorIs(t, processEmail(context.TODO(), client, record), ErrObjectLockNotEnabled)
+		assert.Zero(t, client.copyObjectCalls, "Email should not be archived without retention")
+	})
+}
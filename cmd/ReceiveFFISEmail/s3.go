@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// fetchS3Object reads the entire contents of an S3 object into memory, downloading it in
+// chunks of at most env.DownloadChunkLimit megabytes.
+// Transient failures, which may occur either when requesting a chunk or while it is streamed,
+// are retried (for that chunk only) until env.MaxFetchBackoff elapses. Failures that cannot be
+// resolved by retrying (e.g. the object does not exist or access is denied) are returned
+// immediately. Since the full object is buffered before it is returned, callers that fail to
+// parse the contents should not retry, as re-fetching the same bytes cannot produce a
+// different outcome.
+func fetchS3Object(ctx context.Context, client awsHelpers.S3GetObjectAPI, bucket, key string) ([]byte, error) {
+	r := awsHelpers.NewChunkedReader(ctx, client, bucket, key, env.DownloadChunkLimit*awsHelpers.MB)
+	r.MaxRetryElapsedTime = env.MaxFetchBackoff
+	attempt := 1
+	r.OnRetry = func(err error, offset int64, d time.Duration) {
+		log.Warn(logger, "Retrying failed S3 object fetch", "error", err, "attempt", attempt,
+			"retry_in", d, "bucket", bucket, "key", key, "offset", offset)
+		sendMetric("s3.fetch_retry", 1)
+		attempt++
+	}
+	return io.ReadAll(r)
+}
+
+// archiveAttributeBudget fits the metadata and tags of archived emails within S3's limits.
+// Whether the sender was verified is required, since downstream consumers rely on it to
+// distinguish unverified emails.
+var archiveAttributeBudget = awsHelpers.S3AttributeBudget{
+	Priority: []string{"sender-verified", "sender_verified", "backfilled", "off_schedule"},
+	Required: []string{"sender-verified", "sender_verified"},
+}
+
+// fitArchiveAttributes sets the given metadata and tags (when not empty) on input, replacing
+// those of the source object, after fitting them within S3's limits (see archiveAttributeBudget).
+// Each metadata entry or tag that is truncated or dropped is logged at the WARN level and
+// counted by the s3.attribute_budget_exceeded metric, rather than letting the copy fail.
+func fitArchiveAttributes(
+	logger log.Logger, input *s3.CopyObjectInput, metadata map[string]string, tags url.Values, metricTags []string,
+) error {
+	fittedMetadata, metadataChanges, err := archiveAttributeBudget.FitMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	fittedTags, tagChanges, err := archiveAttributeBudget.FitTags(tags)
+	if err != nil {
+		return err
+	}
+	for _, changed := range []struct {
+		kind    string
+		changes []awsHelpers.S3AttributeChange
+	}{{"metadata", metadataChanges}, {"tag", tagChanges}} {
+		for _, change := range changed.changes {
+			sendMetric("s3.attribute_budget_exceeded", 1, append([]string{
+				"attribute:" + changed.kind, "action:" + change.Action}, metricTags...)...)
+			log.Warn(logger, "Archived email attribute exceeds S3 limits", "attribute", changed.kind,
+				"key", change.Key, "action", change.Action, "original_bytes", change.OriginalSize)
+		}
+	}
+
+	if len(fittedMetadata) > 0 {
+		input.Metadata = fittedMetadata
+		input.MetadataDirective = types.MetadataDirectiveReplace
+	}
+	if len(fittedTags) > 0 {
+		input.Tagging = aws.String(fittedTags.Encode())
+		input.TaggingDirective = types.TaggingDirectiveReplace
+	}
+	return nil
+}
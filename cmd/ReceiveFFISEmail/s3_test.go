@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/eventHelpers"
+)
+
+type mockS3API struct {
+	getObjectCalls  int
+	getObjectErrors []error
+	body            []byte
+	// Keys for which GetObject blocks until the request's context is done, like a hung read
+	blockingKeys    map[string]bool
+	copyObjectCalls int
+	copyObjectInput *s3.CopyObjectInput
+	// Errors returned (in order) by CopyObject after the object is copied
+	copyObjectErrors []error
+	copiedKeys       map[string]bool
+	headObjectCalls  int
+	headBucketError  error
+	// Returned by GetObjectLockConfiguration; object lock is not enabled when nil
+	objectLockConfig      *types.ObjectLockConfiguration
+	objectLockConfigCalls int
+}
+
+func (m *mockS3API) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.getObjectCalls++
+	if m.blockingKeys[aws.ToString(params.Key)] {
+		<-ctx.Done()
+		return nil, fmt.Errorf("operation error S3: GetObject, %w", ctx.Err())
+	}
+	if len(m.getObjectErrors) > 0 {
+		err := m.getObjectErrors[0]
+		m.getObjectErrors = m.getObjectErrors[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	body := m.body
+	var start, end int
+	if _, err := fmt.Sscanf(aws.ToString(params.Range), "bytes=%d-%d", &start, &end); err != nil {
+		return &s3.GetObjectOutput{
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}, nil
+	}
+	if end >= len(body) {
+		end = len(body) - 1
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body[start : end+1])),
+		ContentLength: int64(end + 1 - start),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(body))),
+	}, nil
+}
+
+func (m *mockS3API) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	m.copyObjectCalls++
+	m.copyObjectInput = params
+	if m.copiedKeys == nil {
+		m.copiedKeys = make(map[string]bool)
+	}
+	m.copiedKeys[aws.ToString(params.Key)] = true
+	if len(m.copyObjectErrors) > 0 {
+		err := m.copyObjectErrors[0]
+		m.copyObjectErrors = m.copyObjectErrors[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *mockS3API) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	m.headObjectCalls++
+	if !m.copiedKeys[aws.ToString(params.Key)] {
+		return nil, &types.NotFound{}
+	}
+	sum := md5.Sum(m.body)
+	if params.IfMatch != nil && aws.ToString(params.IfMatch) != fmt.Sprintf("%q", hex.EncodeToString(sum[:])) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (m *mockS3API) GetObjectLockConfiguration(ctx context.Context, params *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	m.objectLockConfigCalls++
+	if m.objectLockConfig == nil {
+		return nil, &smithy.GenericAPIError{Code: "ObjectLockConfigurationNotFoundError"}
+	}
+	return &s3.GetObjectLockConfigurationOutput{ObjectLockConfiguration: m.objectLockConfig}, nil
+}
+
+func (m *mockS3API) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, m.headBucketError
+}
+
+func TestProcessEmailFetchRetries(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	env.MaxFetchBackoff = 10 * time.Second
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	badEmail, err := os.ReadFile("fixtures/bad_data.eml")
+	require.NoError(t, err)
+
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "test-source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	t.Run("transient fetch error is retried", func(t *testing.T) {
+		client := &mockS3API{
+			body:            goodEmail,
+			getObjectErrors: []error{errors.New("connection reset by peer")},
+		}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		assert.Equal(t, 2, client.getObjectCalls)
+		assert.Equal(t, 1, client.copyObjectCalls)
+	})
+
+	t.Run("missing object is not retried", func(t *testing.T) {
+		client := &mockS3API{body: goodEmail, getObjectErrors: []error{&types.NoSuchKey{}}}
+		assert.ErrorContains(t, processEmail(context.TODO(), client, record),
+			"failed to retrieve S3 object")
+		assert.Equal(t, 1, client.getObjectCalls)
+		assert.Equal(t, 0, client.copyObjectCalls)
+	})
+
+	t.Run("archived object is not retried", func(t *testing.T) {
+		client := &mockS3API{body: goodEmail, getObjectErrors: []error{
+			&types.InvalidObjectState{StorageClass: types.StorageClassGlacier},
+		}}
+		err := processEmail(context.TODO(), client, record)
+		assert.ErrorIs(t, err, awsHelpers.ErrS3ObjectArchived)
+		assert.Equal(t, "S3ObjectArchived", eventHelpers.ClassifyError(err))
+		assert.Equal(t, 1, client.getObjectCalls)
+		assert.Equal(t, 0, client.copyObjectCalls)
+	})
+
+	t.Run("parse error is not retried", func(t *testing.T) {
+		client := &mockS3API{body: badEmail}
+		assert.ErrorContains(t, processEmail(context.TODO(), client, record),
+			"failed to parse email from S3 object")
+		assert.Equal(t, 1, client.getObjectCalls)
+		assert.Equal(t, 0, client.copyObjectCalls)
+	})
+}
+
+func TestFitArchiveAttributes(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		sentMetrics[strings.Join(append([]string{metric}, tags...), ",")] += value
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	t.Run("no attributes", func(t *testing.T) {
+		input := &s3.CopyObjectInput{}
+		require.NoError(t, fitArchiveAttributes(logger, input, map[string]string{}, url.Values{}, nil))
+		assert.Nil(t, input.Metadata)
+		assert.Empty(t, input.MetadataDirective)
+		assert.Nil(t, input.Tagging)
+		assert.Empty(t, input.TaggingDirective)
+		assert.Empty(t, sentMetrics)
+	})
+
+	t.Run("over budget", func(t *testing.T) {
+		input := &s3.CopyObjectInput{}
+		metadata := map[string]string{"sender-verified": "false", "note": strings.Repeat("n", 3000)}
+		tags := url.Values{"sender_verified": {"false"}, "backfilled": {"true"}, "off_schedule": {"true"}}
+		for i := 0; i < 8; i++ {
+			tags.Set(fmt.Sprintf("extra_%d", i), "true")
+		}
+		require.NoError(t, fitArchiveAttributes(logger, input, metadata, tags, []string{"source:ffis"}))
+
+		assert.Equal(t, "false", input.Metadata["sender-verified"])
+		assert.LessOrEqual(t, awsHelpers.S3MetadataSize(input.Metadata), awsHelpers.MaxS3UserMetadataSize)
+		assert.Equal(t, types.MetadataDirectiveReplace, input.MetadataDirective)
+		encoded, err := url.ParseQuery(aws.ToString(input.Tagging))
+		require.NoError(t, err)
+		assert.Len(t, encoded, awsHelpers.MaxS3ObjectTags)
+		for _, key := range []string{"sender_verified", "backfilled", "off_schedule"} {
+			assert.Contains(t, encoded, key)
+		}
+		assert.NotContains(t, encoded, "extra_0")
+		assert.Equal(t, types.TaggingDirectiveReplace, input.TaggingDirective)
+		assert.Equal(t, map[string]float64{
+			"s3.attribute_budget_exceeded,attribute:metadata,action:truncated,source:ffis": 1,
+			"s3.attribute_budget_exceeded,attribute:tag,action:dropped,source:ffis":        1,
+		}, sentMetrics)
+	})
+
+	t.Run("required attributes over budget", func(t *testing.T) {
+		tags := url.Values{"sender_verified": {strings.Repeat("f", awsHelpers.MaxS3TagValueLength+1)}}
+		assert.ErrorIs(t, fitArchiveAttributes(logger, &s3.CopyObjectInput{}, map[string]string{}, tags, nil),
+			awsHelpers.ErrS3RequiredAttributesExceedLimit)
+	})
+}
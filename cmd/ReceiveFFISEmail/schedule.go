@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Weekdays on which digests are expected to arrive, as configured by env.ExpectedDeliveryDOWs
+// (nil when the check is disabled)
+var expectedDeliveryDays map[time.Weekday]bool
+
+// Location in which the delivery weekday of an email is determined,
+// as configured by env.ExpectedDeliveryTimezone
+var deliveryLocation = time.UTC
+
+var weekdayAbbreviations = map[string]time.Weekday{
+	"SUN": time.Sunday,
+	"MON": time.Monday,
+	"TUE": time.Tuesday,
+	"WED": time.Wednesday,
+	"THU": time.Thursday,
+	"FRI": time.Friday,
+	"SAT": time.Saturday,
+}
+
+// parseExpectedDeliveryDays parses a comma-separated list of weekday abbreviations
+// (e.g. "MON,TUE") as configured by EXPECTED_DELIVERY_DOWS. Returns nil when s is empty.
+func parseExpectedDeliveryDays(s string) (map[time.Weekday]bool, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	days := make(map[time.Weekday]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		day, ok := weekdayAbbreviations[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown day of week %q in EXPECTED_DELIVERY_DOWS", name)
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+// isOffSchedule reports whether date falls on a weekday (in deliveryLocation) on which no
+// digest is expected. Always returns false when expected delivery days are not configured.
+func isOffSchedule(date time.Time) bool {
+	if expectedDeliveryDays == nil {
+		return false
+	}
+	return !expectedDeliveryDays[date.In(deliveryLocation).Weekday()]
+}
+
+type offScheduleRecorderKey struct{}
+
+// offScheduleRecorder collects the source keys of off-schedule emails handled during an
+// invocation, so that they can be reported in the invocation summary.
+type offScheduleRecorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+// withOffScheduleRecorder returns a context which causes off-schedule emails processed with it
+// to be recorded by the returned recorder.
+func withOffScheduleRecorder(ctx context.Context) (context.Context, *offScheduleRecorder) {
+	r := &offScheduleRecorder{}
+	return context.WithValue(ctx, offScheduleRecorderKey{}, r), r
+}
+
+// recordOffSchedule records key with the recorder of ctx, if any.
+func recordOffSchedule(ctx context.Context, key string) {
+	if r, ok := ctx.Value(offScheduleRecorderKey{}).(*offScheduleRecorder); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.keys = append(r.keys, key)
+	}
+}
+
+// Keys returns the sorted source keys of the recorded off-schedule emails.
+func (r *offScheduleRecorder) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := append([]string{}, r.keys...)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpectedDeliveryDays(t *testing.T) {
+	days, err := parseExpectedDeliveryDays("")
+	require.NoError(t, err)
+	assert.Nil(t, days)
+
+	days, err = parseExpectedDeliveryDays("MON, tue,")
+	require.NoError(t, err)
+	assert.Equal(t, map[time.Weekday]bool{time.Monday: true, time.Tuesday: true}, days)
+
+	_, err = parseExpectedDeliveryDays("MON,Funday")
+	assert.ErrorContains(t, err, "FUNDAY")
+}
+
+func TestProcessEmailOffSchedule(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	t.Cleanup(func() {
+		expectedDeliveryDays = nil
+		deliveryLocation = time.UTC
+	})
+	// Dated Sat, 22 Apr 2023 14:55:26 -0500, i.e. Saturday 19:55 UTC
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	for _, tt := range []struct {
+		name           string
+		expectedDays   string
+		timezone       string
+		expOffSchedule bool
+	}{
+		{"unset", "", "UTC", false},
+		{"on schedule", "FRI,SAT", "UTC", false},
+		{"off schedule", "MON,TUE", "UTC", true},
+		{"weekday is determined in configured timezone", "SAT", "Asia/Tokyo", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k := range sentMetrics {
+				delete(sentMetrics, k)
+			}
+			expectedDeliveryDays, err = parseExpectedDeliveryDays(tt.expectedDays)
+			require.NoError(t, err)
+			deliveryLocation, err = time.LoadLocation(tt.timezone)
+			require.NoError(t, err)
+
+			client := &mockS3API{body: goodEmail}
+			ctx, recorder := withOffScheduleRecorder(context.TODO())
+			require.NoError(t, processEmail(ctx, client, record))
+			require.Equal(t, 1, client.copyObjectCalls, "email should be archived regardless of schedule")
+			assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+			if tt.expOffSchedule {
+				assert.Equal(t, "off_schedule=true", aws.ToString(client.copyObjectInput.Tagging))
+				assert.Equal(t, float64(1), sentMetrics["email.off_schedule"])
+				assert.Equal(t, []string{record.S3.Object.Key}, recorder.Keys())
+			} else {
+				assert.Nil(t, client.copyObjectInput.Tagging)
+				assert.NotContains(t, sentMetrics, "email.off_schedule")
+				assert.Empty(t, recorder.Keys())
+			}
+		})
+	}
+}
+
+func TestRecordOffScheduleWithoutRecorder(t *testing.T) {
+	assert.NotPanics(t, func() { recordOffSchedule(context.TODO(), "some/key") })
+}
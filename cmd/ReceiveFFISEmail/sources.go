@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// legacyDestinationSubpath is the destination subpath of the source that is synthesized
+// from legacy environment variables when SOURCES_CONFIG is not set.
+const legacyDestinationSubpath = "ffis.org"
+
+var ErrInvalidSourcesConfig = errors.New("invalid sources configuration")
+
+// SourceConfig describes how emails received under a particular S3 key prefix (i.e. by way of
+// a particular SES receipt rule) are validated and archived.
+type SourceConfig struct {
+	// Name identifies the source in logs, metrics, and traces. Defaults to DestinationSubpath.
+	Name string `json:"name,omitempty"`
+	// KeyPrefix is the prefix of source object keys to which this configuration applies.
+	// An empty prefix matches every key.
+	KeyPrefix string `json:"keyPrefix"`
+	// ValidSenders lists the email addresses and/or domains that are allowed to send emails
+	// for this source.
+	ValidSenders []string `json:"validSenders"`
+	// DestinationSubpath is the path under which emails are archived in the destination bucket,
+	// e.g. "ffis.org" for keys like "sources/YYYY/MM/DD/ffis.org/raw.eml".
+	DestinationSubpath string `json:"destinationSubpath"`
+	// URLPattern is an optional regular expression that identifies download links in emails
+	// from this source.
+	URLPattern string `json:"urlPattern,omitempty"`
+	// DestinationBucket is the bucket to which emails from this source are archived.
+	// Defaults to GRANTS_SOURCE_DATA_BUCKET_NAME.
+	DestinationBucket string `json:"destinationBucket,omitempty"`
+	// DestinationRoleARN optionally identifies an IAM role that is assumed to archive emails
+	// from this source, e.g. when DestinationBucket belongs to another account. Since emails are
+	// archived by copying them, the role must also be allowed to read the source bucket.
+	DestinationRoleARN string `json:"destinationRoleArn,omitempty"`
+}
+
+// loadSourcesConfig returns the source configurations given by the SOURCES_CONFIG JSON array.
+// When SOURCES_CONFIG is empty, a single source that matches every key is synthesized from
+// ALLOWED_EMAIL_SENDERS, in order to preserve the behavior of the original FFIS-only setup.
+func loadSourcesConfig(env Environment) ([]SourceConfig, error) {
+	if strings.TrimSpace(env.SourcesConfig) == "" {
+		validSenders, err := senderAllowlist(strings.Split(env.AllowedEmailSenders, ","))
+		if err != nil {
+			return nil, fmt.Errorf("%w: ALLOWED_EMAIL_SENDERS is required when SOURCES_CONFIG is not set: %w",
+				ErrInvalidSourcesConfig, err)
+		}
+		return []SourceConfig{{
+			ValidSenders:       validSenders,
+			DestinationSubpath: legacyDestinationSubpath,
+		}}, nil
+	}
+
+	var sources []SourceConfig
+	if err := json.Unmarshal([]byte(env.SourcesConfig), &sources); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSourcesConfig, err)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%w: no sources are configured", ErrInvalidSourcesConfig)
+	}
+	for i, source := range sources {
+		validSenders, err := senderAllowlist(source.ValidSenders)
+		if err != nil {
+			return nil, fmt.Errorf("%w: source %d: %w", ErrInvalidSourcesConfig, i, err)
+		}
+		sources[i].ValidSenders = validSenders
+		if strings.Trim(source.DestinationSubpath, "/") == "" {
+			return nil, fmt.Errorf("%w: source %d has no destination subpath", ErrInvalidSourcesConfig, i)
+		}
+		sources[i].DestinationSubpath = strings.Trim(source.DestinationSubpath, "/")
+		if source.URLPattern != "" {
+			if _, err := regexp.Compile(source.URLPattern); err != nil {
+				return nil, fmt.Errorf("%w: source %d has an invalid URL pattern: %w",
+					ErrInvalidSourcesConfig, i, err)
+			}
+		}
+		sources[i].DestinationBucket = strings.TrimSpace(source.DestinationBucket)
+		sources[i].DestinationRoleARN = strings.TrimSpace(source.DestinationRoleARN)
+		if roleARN := sources[i].DestinationRoleARN; roleARN != "" {
+			if parsed, err := arn.Parse(roleARN); err != nil || parsed.Service != "iam" ||
+				!strings.HasPrefix(parsed.Resource, "role/") {
+				return nil, fmt.Errorf("%w: source %d has an invalid destination role ARN %q",
+					ErrInvalidSourcesConfig, i, roleARN)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// sourceName returns the name of the source, which defaults to its destination subpath
+// (e.g. "ffis.org" for the source synthesized from legacy environment variables).
+func (s SourceConfig) sourceName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.DestinationSubpath
+}
+
+// bucket returns the name of the bucket to which emails from the source are archived.
+func (s SourceConfig) bucket() string {
+	if s.DestinationBucket != "" {
+		return s.DestinationBucket
+	}
+	return env.DestinationBucket
+}
+
+// describeSources returns the configured sources as described in response to a describe request
+// (see eventHelpers.AdminActionDescribe), with their default names and destination buckets
+// filled in.
+func describeSources() []SourceConfig {
+	described := make([]SourceConfig, 0, len(sources))
+	for _, source := range sources {
+		source.Name = source.sourceName()
+		source.DestinationBucket = source.bucket()
+		described = append(described, source)
+	}
+	return described
+}
+
+// metricTag returns the tag that identifies the source in metrics.
+func (s SourceConfig) metricTag() string {
+	return fmt.Sprintf("source:%s", s.sourceName())
+}
+
+// senderAllowlist returns the non-blank items of senders, with surrounding whitespace removed.
+// Returns ErrNoSenderAllowlist when no such items remain, since an empty allowlist would
+// otherwise be indistinguishable from a misconfiguration that recognizes no senders.
+func senderAllowlist(senders []string) ([]string, error) {
+	allowlist := []string{}
+	for _, sender := range senders {
+		if sender = strings.TrimSpace(sender); sender != "" {
+			allowlist = append(allowlist, sender)
+		}
+	}
+	if len(allowlist) == 0 {
+		return nil, ErrNoSenderAllowlist
+	}
+	return allowlist, nil
+}
+
+// matchSource returns the configured source with the longest key prefix that matches key.
+// Returns false when no configured source matches key.
+func matchSource(sources []SourceConfig, key string) (SourceConfig, bool) {
+	var match SourceConfig
+	found := false
+	for _, source := range sources {
+		if strings.HasPrefix(key, source.KeyPrefix) && (!found || len(source.KeyPrefix) > len(match.KeyPrefix)) {
+			match, found = source, true
+		}
+	}
+	return match, found
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSourcesConfig(t *testing.T) {
+	t.Run("legacy configuration", func(t *testing.T) {
+		sources, err := loadSourcesConfig(Environment{AllowedEmailSenders: "example.org,someone@example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, []SourceConfig{{
+			ValidSenders:       []string{"example.org", "someone@example.com"},
+			DestinationSubpath: "ffis.org",
+		}}, sources)
+	})
+
+	t.Run("multiple sources", func(t *testing.T) {
+		sources, err := loadSourcesConfig(Environment{SourcesConfig: `[
+			{"keyPrefix": "ses/ffis_ingest/new/", "validSenders": ["ffis.org"], "destinationSubpath": "ffis.org", "urlPattern": "https://.+\\.xlsx"},
+			{"keyPrefix": "ses/state_updates/new/", "validSenders": ["state.gov"], "destinationSubpath": "/state_updates/"}
+		]`})
+		require.NoError(t, err)
+		require.Len(t, sources, 2)
+		assert.Equal(t, "ffis.org", sources[0].DestinationSubpath)
+		assert.Equal(t, `https://.+\.xlsx`, sources[0].URLPattern)
+		assert.Equal(t, "state_updates", sources[1].DestinationSubpath)
+	})
+
+	t.Run("destination bucket and role", func(t *testing.T) {
+		sources, err := loadSourcesConfig(Environment{SourcesConfig: `[
+			{"validSenders": ["state.gov"], "destinationSubpath": "state_updates", "destinationBucket": " state-sources ",
+				"destinationRoleArn": "arn:aws:iam::123456789012:role/state-sources-writer"}
+		]`})
+		require.NoError(t, err)
+		require.Len(t, sources, 1)
+		assert.Equal(t, "state-sources", sources[0].DestinationBucket)
+		assert.Equal(t, "arn:aws:iam::123456789012:role/state-sources-writer", sources[0].DestinationRoleARN)
+	})
+
+	for _, tt := range []struct {
+		name string
+		env  Environment
+	}{
+		{"no senders", Environment{}},
+		{"malformed JSON", Environment{SourcesConfig: `[{`}},
+		{"empty array", Environment{SourcesConfig: `[]`}},
+		{"missing senders", Environment{SourcesConfig: `[{"keyPrefix": "a/", "destinationSubpath": "a"}]`}},
+		{"missing subpath", Environment{SourcesConfig: `[{"keyPrefix": "a/", "validSenders": ["a.org"]}]`}},
+		{"invalid URL pattern", Environment{SourcesConfig: `[{"validSenders": ["a.org"], "destinationSubpath": "a", "urlPattern": "("}]`}},
+		{"invalid role ARN", Environment{SourcesConfig: `[{"validSenders": ["a.org"], "destinationSubpath": "a", "destinationRoleArn": "state-sources-writer"}]`}},
+		{"non-role ARN", Environment{SourcesConfig: `[{"validSenders": ["a.org"], "destinationSubpath": "a", "destinationRoleArn": "arn:aws:s3:::state-sources"}]`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadSourcesConfig(tt.env)
+			assert.ErrorIs(t, err, ErrInvalidSourcesConfig)
+		})
+	}
+}
+
+func TestLoadSourcesConfigRejectsEmptySenderAllowlist(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		env  Environment
+	}{
+		{"legacy unset", Environment{}},
+		{"legacy blank items", Environment{AllowedEmailSenders: " , ,"}},
+		{"source with empty senders", Environment{SourcesConfig: `[{"validSenders": [], "destinationSubpath": "a"}]`}},
+		{"source with blank senders", Environment{SourcesConfig: `[{"validSenders": ["", " "], "destinationSubpath": "a"}]`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := loadSourcesConfig(tt.env)
+			assert.ErrorIs(t, err, ErrNoSenderAllowlist)
+			assert.ErrorIs(t, err, ErrInvalidSourcesConfig)
+			assert.Nil(t, sources)
+		})
+	}
+
+	t.Run("blank items are dropped", func(t *testing.T) {
+		sources, err := loadSourcesConfig(Environment{AllowedEmailSenders: "example.org, ,"})
+		require.NoError(t, err)
+		require.Len(t, sources, 1)
+		assert.Equal(t, []string{"example.org"}, sources[0].ValidSenders)
+	})
+}
+
+func TestMatchSource(t *testing.T) {
+	sources := []SourceConfig{
+		{KeyPrefix: "ses/", DestinationSubpath: "catchall"},
+		{KeyPrefix: "ses/state_updates/new/", DestinationSubpath: "state_updates"},
+	}
+
+	source, ok := matchSource(sources, "ses/state_updates/new/abc123")
+	assert.True(t, ok)
+	assert.Equal(t, "state_updates", source.DestinationSubpath, "Longest matching prefix should be used")
+
+	source, ok = matchSource(sources, "ses/ffis_ingest/new/abc123")
+	assert.True(t, ok)
+	assert.Equal(t, "catchall", source.DestinationSubpath)
+
+	_, ok = matchSource(sources, "other/abc123")
+	assert.False(t, ok)
+}
+
+func TestSourceName(t *testing.T) {
+	assert.Equal(t, "ffis.org", SourceConfig{DestinationSubpath: "ffis.org"}.sourceName())
+	assert.Equal(t, "state-updates",
+		SourceConfig{Name: "state-updates", DestinationSubpath: "state_updates"}.sourceName())
+	assert.Equal(t, "source:state-updates", SourceConfig{Name: "state-updates"}.metricTag())
+}
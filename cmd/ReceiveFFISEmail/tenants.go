@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"path"
+	"strings"
+)
+
+// DefaultTenant is the tenant of emails whose recipients do not identify a configured tenant.
+const DefaultTenant = "default"
+
+var ErrInvalidTenantConfig = errors.New("invalid tenant configuration")
+
+// Destinations of archived emails by tenant (see loadTenantConfig)
+var tenants map[string]TenantConfig
+
+// tenantRecipientHeader is the header whose addresses identify the tenant of an email.
+// Delivered-To is set by the receiving mail system to the address to which the email was
+// actually delivered, whereas headers like To are set by the sender, who must not be able to
+// route an email to another tenant.
+const tenantRecipientHeader = "Delivered-To"
+
+// TenantConfig describes where emails addressed to a particular tenant are archived.
+type TenantConfig struct {
+	// DestinationBucket is the bucket to which the tenant's emails are archived.
+	// Defaults to the destination bucket of each email's source (see SourceConfig.bucket).
+	DestinationBucket string `json:"destinationBucket,omitempty"`
+	// DestinationPrefix is inserted below the root prefix of the tenant's archived emails,
+	// e.g. "tenants/a" for keys like "sources/tenants/a/YYYY/MM/DD/ffis.org/raw.eml", so that
+	// they are processed by the same bucket notifications as the emails of other tenants.
+	DestinationPrefix string `json:"destinationPrefix,omitempty"`
+}
+
+// bucket returns the name of the bucket to which the tenant's emails are archived when they
+// would otherwise be archived to defaultBucket.
+func (c TenantConfig) bucket(defaultBucket string) string {
+	if c.DestinationBucket != "" {
+		return c.DestinationBucket
+	}
+	return defaultBucket
+}
+
+// keyPrefix returns the prefix under which the tenant's emails are archived when they would
+// otherwise be archived under prefix (e.g. "sources" or "quarantine").
+func (c TenantConfig) keyPrefix(prefix string) string {
+	if c.DestinationPrefix == "" {
+		return prefix
+	}
+	return path.Join(prefix, c.DestinationPrefix)
+}
+
+// loadTenantConfig returns the per-tenant configurations given by the TENANT_CONFIG JSON object,
+// which maps tenant names to the destination of their emails, e.g.
+// {"statea": {"destinationPrefix": "tenants/statea"}, "stateb": {"destinationBucket": "stateb-sources"}}
+// Tenants are identified by the plus-addressing extension of the address to which emails are
+// delivered (see recipientTenant), so the keys of the returned map are lowercase.
+// A tenant named DefaultTenant, when configured, receives emails that identify no other tenant.
+// Returns an empty map when TENANT_CONFIG is empty, which disables tenant routing.
+func loadTenantConfig(env Environment) (map[string]TenantConfig, error) {
+	configs := map[string]TenantConfig{}
+	if strings.TrimSpace(env.TenantConfig) == "" {
+		return configs, nil
+	}
+	var byName map[string]TenantConfig
+	if err := json.Unmarshal([]byte(env.TenantConfig), &byName); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTenantConfig, err)
+	}
+	for name, config := range byName {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.ContainsAny(name, "@+ ") {
+			return nil, fmt.Errorf("%w: %q is not a valid tenant name", ErrInvalidTenantConfig, name)
+		}
+		if _, exists := configs[name]; exists {
+			return nil, fmt.Errorf("%w: tenant name %q is not unique", ErrInvalidTenantConfig, name)
+		}
+		config.DestinationBucket = strings.TrimSpace(config.DestinationBucket)
+		config.DestinationPrefix = strings.Trim(config.DestinationPrefix, "/")
+		configs[name] = config
+	}
+	return configs, nil
+}
+
+// recipientTenant returns the plus-addressing extension of the first plus-addressed
+// tenantRecipientHeader address of the email with header h, e.g. "statea" for
+// "ingest+StateA@example.org". Returns false when no such address is plus-addressed.
+func recipientTenant(h mail.Header) (string, bool) {
+	addresses, err := h.AddressList(tenantRecipientHeader)
+	if err != nil {
+		// The header is either absent or malformed, neither of which identifies a tenant
+		return "", false
+	}
+	for _, address := range addresses {
+		local, _, _ := strings.Cut(address.Address, "@")
+		if _, extension, ok := strings.Cut(local, "+"); ok {
+			if tenant := strings.ToLower(strings.TrimSpace(extension)); tenant != "" {
+				return tenant, true
+			}
+		}
+	}
+	return "", false
+}
+
+// resolveTenant returns the name and configuration of the tenant to which the email with
+// header h is routed. Emails that are not plus-addressed, or whose plus-addressing extension
+// names no configured tenant, are routed to DefaultTenant; in the latter case, the unrecognized
+// extension is also returned.
+func resolveTenant(h mail.Header) (tenant string, config TenantConfig, unrecognized string) {
+	extension, ok := recipientTenant(h)
+	if !ok {
+		return DefaultTenant, tenants[DefaultTenant], ""
+	}
+	if config, ok := tenants[extension]; ok {
+		return extension, config, ""
+	}
+	return DefaultTenant, tenants[DefaultTenant], extension
+}
+
+// tenantMetricTag returns the tag that identifies the tenant in metrics.
+func tenantMetricTag(tenant string) string {
+	return fmt.Sprintf("tenant:%s", tenant)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTenantConfig(t *testing.T) {
+	t.Run("not configured", func(t *testing.T) {
+		configs, err := loadTenantConfig(Environment{})
+		require.NoError(t, err)
+		assert.Empty(t, configs)
+	})
+
+	t.Run("per-tenant configuration", func(t *testing.T) {
+		configs, err := loadTenantConfig(Environment{TenantConfig: `{
+			"StateA": {"destinationPrefix": "/tenants/statea/"},
+			" stateb ": {"destinationBucket": "stateb-sources"},
+			"default": {"destinationPrefix": "tenants/unassigned"}
+		}`})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]TenantConfig{
+			"statea":  {DestinationPrefix: "tenants/statea"},
+			"stateb":  {DestinationBucket: "stateb-sources"},
+			"default": {DestinationPrefix: "tenants/unassigned"},
+		}, configs)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		config string
+	}{
+		{"malformed JSON", `{"statea":`},
+		{"empty name", `{" ": {}}`},
+		{"plus-addressed name", `{"ingest+statea": {}}`},
+		{"duplicate name", `{"statea": {}, "StateA": {}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTenantConfig(Environment{TenantConfig: tt.config})
+			assert.ErrorIs(t, err, ErrInvalidTenantConfig)
+		})
+	}
+}
+
+func TestRecipientTenant(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		headers        string
+		expectedTenant string
+		expectedOk     bool
+	}{
+		{"plus-addressed Delivered-To", "Delivered-To: ingest+StateA@example.org\r\n", "statea", true},
+		{"plus-addressed Delivered-To with display name", "Delivered-To: \"Grants Ingest\" <ingest+stateb@example.org>\r\n", "stateb", true},
+		{"first plus-addressed of several recipients", "Delivered-To: someone@example.org, ingest+statea@example.org, ingest+stateb@example.org\r\n", "statea", true},
+		{"Delivered-To takes precedence", "Delivered-To: ingest+stateb@example.org\r\nTo: ingest+statea@example.org\r\n", "stateb", true},
+		{"To is set by the sender", "Delivered-To: ingest@example.org\r\nTo: ingest+statea@example.org\r\n", "", false},
+		{"To without Delivered-To", "To: ingest+statea@example.org\r\n", "", false},
+		{"not plus-addressed", "Delivered-To: ingest@example.org\r\n", "", false},
+		{"empty extension", "Delivered-To: ingest+@example.org\r\n", "", false},
+		{"no recipients", "Subject: hello\r\n", "", false},
+		{"malformed recipients", "Delivered-To: <<ingest+statea\r\n", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(tt.headers + "\r\nbody\r\n"))
+			require.NoError(t, err)
+			tenant, ok := recipientTenant(msg.Header)
+			assert.Equal(t, tt.expectedTenant, tenant)
+			assert.Equal(t, tt.expectedOk, ok)
+		})
+	}
+}
+
+func TestProcessEmailTenantRouting(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	restoreTenants := tenants
+	t.Cleanup(func() { tenants = restoreTenants })
+	sentTags := make(map[string][]string)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentTags[metric] = tags }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	spamEmail, err := os.ReadFile("fixtures/bad_spam.eml")
+	require.NoError(t, err)
+	record := events.S3EventRecord{S3: events.S3Entity{
+		Bucket: events.S3Bucket{Name: "source-bucket"},
+		Object: events.S3Object{Key: "ses/ffis_ingest/new/abc123"},
+	}}
+	withRecipient := func(email []byte, recipient string) []byte {
+		return append([]byte("Delivered-To: "+recipient+"\n"), email...)
+	}
+
+	t.Run("tenants not configured", func(t *testing.T) {
+		tenants = map[string]TenantConfig{}
+		client := &mockS3API{body: withRecipient(goodEmail, "ingest+statea@example.com")}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		assert.Equal(t, env.DestinationBucket, aws.ToString(client.copyObjectInput.Bucket))
+		assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+	})
+
+	tenants, err = loadTenantConfig(Environment{TenantConfig: `{
+		"statea": {"destinationPrefix": "tenants/statea"},
+		"stateb": {"destinationBucket": "stateb-sources", "destinationPrefix": "tenants/stateb"}
+	}`})
+	require.NoError(t, err)
+	for _, tt := range []struct {
+		name           string
+		recipient      string
+		expectedTenant string
+		expectedBucket string
+		expectedKey    string
+	}{
+		{"tenant prefix", "ingest+StateA@example.com", "statea",
+			env.DestinationBucket, "sources/tenants/statea/2023/04/22/ffis.org/raw.eml"},
+		{"tenant bucket and prefix", "ingest+stateb@example.com", "stateb",
+			"stateb-sources", "sources/tenants/stateb/2023/04/22/ffis.org/raw.eml"},
+		{"fallback for non-plus-addressed recipient", "ingest@example.com", DefaultTenant,
+			env.DestinationBucket, "sources/2023/04/22/ffis.org/raw.eml"},
+		{"fallback for unknown tenant", "ingest+statez@example.com", DefaultTenant,
+			env.DestinationBucket, "sources/2023/04/22/ffis.org/raw.eml"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k := range sentTags {
+				delete(sentTags, k)
+			}
+			client := &mockS3API{body: withRecipient(goodEmail, tt.recipient)}
+			require.NoError(t, processEmail(context.TODO(), client, record))
+			require.NotNil(t, client.copyObjectInput)
+			assert.Equal(t, tt.expectedBucket, aws.ToString(client.copyObjectInput.Bucket))
+			assert.Equal(t, tt.expectedKey, aws.ToString(client.copyObjectInput.Key))
+			if tt.recipient == "ingest+statez@example.com" {
+				assert.Equal(t, []string{"source:ffis.org", "tenant:default"}, sentTags["email.unrecognized_tenant"])
+			} else {
+				assert.NotContains(t, sentTags, "email.unrecognized_tenant")
+			}
+
+			assert.Error(t, processEmail(context.TODO(), &mockS3API{body: withRecipient(spamEmail, tt.recipient)}, record))
+			assert.Equal(t, []string{"source:ffis.org", "tenant:" + tt.expectedTenant}, sentTags["email.untrusted"])
+		})
+	}
+
+	t.Run("sender-controlled To is not routed", func(t *testing.T) {
+		email := bytes.Replace(goodEmail, []byte("anotherperson@example.com"), []byte("ingest+statea@example.com"), -1)
+		client := &mockS3API{body: email}
+		require.NoError(t, processEmail(context.TODO(), client, record))
+		require.NotNil(t, client.copyObjectInput)
+		assert.Equal(t, env.DestinationBucket, aws.ToString(client.copyObjectInput.Bucket))
+		assert.Equal(t, "sources/2023/04/22/ffis.org/raw.eml", aws.ToString(client.copyObjectInput.Key))
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+)
+
+// ScheduledEvent represents the invocation event for this Lambda function.
+// StartDate and EndDate (e.g. 2023-05-15) select the (inclusive) range of dates to reconcile.
+// When EndDate is empty, the range ends on the date of Timestamp (or, when Timestamp is zero,
+// the current date). When StartDate is empty, the range spans LOOKBACK_DAYS days.
+type ScheduledEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+}
+
+// dateRange returns the (UTC) start and end dates selected by the event.
+func (e ScheduledEvent) dateRange(now time.Time) (start, end time.Time, err error) {
+	end = now.UTC().Truncate(24 * time.Hour)
+	if e.EndDate != "" {
+		if end, err = time.Parse(dateLayout, e.EndDate); err != nil {
+			return start, end, fmt.Errorf("invalid end_date: %w", err)
+		}
+	}
+	start = end.AddDate(0, 0, 1-env.LookbackDays)
+	if e.StartDate != "" {
+		if start, err = time.Parse(dateLayout, e.StartDate); err != nil {
+			return start, end, fmt.Errorf("invalid start_date: %w", err)
+		}
+	}
+	if start.After(end) {
+		return start, end, fmt.Errorf("start_date %s is after end_date %s",
+			start.Format(dateLayout), end.Format(dateLayout))
+	}
+	return start, end, nil
+}
+
+// handleEvent is a Lambda function handler that is called with the ScheduledEvent invocation
+// event. When invoked, it reconciles the expected and actual outputs of the FFIS pipeline for
+// the selected date range, repairs the gaps it finds (when env.RepairEnabled is true), and
+// stores a JSON report of the gaps under env.ReportKeyPrefix in the prepared data bucket.
+// Gaps are not treated as errors. While the reconcile_repair feature is disabled, gaps are
+// still reported but no pipeline stage is re-triggered.
+func handleEvent(ctx context.Context, c S3API, event ScheduledEvent) error {
+	now := event.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+	start, end, err := event.dateRange(now)
+	if err != nil {
+		return log.Errorf(logger, "Error determining date range to reconcile", err)
+	}
+	logger := log.With(logger, "start_date", start.Format(dateLayout), "end_date", end.Format(dateLayout),
+		"source_bucket", env.SourceBucket, "prepared_data_bucket", env.PreparedDataBucket,
+		"repair_enabled", env.RepairEnabled)
+
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "pipeline.reconcile")
+	report, err := reconcile(spanCtx, c, start, end, now)
+	tracing.FinishWithOutcome(span, err)
+	if err != nil {
+		return log.Errorf(logger, "Error reconciling FFIS pipeline outputs", err)
+	}
+	report.GeneratedAt = now
+	logger = log.With(logger, "count_emails", report.Checked.Emails,
+		"count_downloads", report.Checked.Downloads, "count_manifests", report.Checked.Manifests,
+		"count_opportunities", report.Checked.Opportunities, "count_pending", report.Checked.Pending,
+		"count_gaps", len(report.Gaps))
+
+	for _, gapType := range GapTypes {
+		sendMetric(fmt.Sprintf("gap.%s", gapType), float64(report.GapCounts[gapType]))
+	}
+	for _, gap := range report.Gaps {
+		log.Warn(logger, "Found gap in FFIS pipeline outputs", "gap_type", gap.Type,
+			"bucket", gap.Bucket, "key", gap.Key, "expected_key", gap.ExpectedKey, "detail", gap.Detail)
+	}
+
+	if env.RepairEnabled && len(report.Gaps) > 0 &&
+		!disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureReconcileRepair, len(report.Gaps)) {
+		span, spanCtx := tracing.StartSpanFromContext(ctx, "pipeline.repair")
+		succeeded, failed := repairGaps(spanCtx, c, report, now)
+		span.SetTag("succeeded", succeeded)
+		span.SetTag("failed", failed)
+		tracing.FinishWithOutcome(span, nil)
+		sendMetric("repair.succeeded", float64(succeeded))
+		sendMetric("repair.failed", float64(failed))
+		logger = log.With(logger, "count_repairs_succeeded", succeeded, "count_repairs_failed", failed)
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return log.Errorf(logger, "Error marshaling reconciliation report", err)
+	}
+	reportKey := path.Join(env.ReportKeyPrefix,
+		fmt.Sprintf("%s_%s.json", report.StartDate, report.EndDate))
+	if _, err := c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(env.PreparedDataBucket),
+		Key:                  aws.String(reportKey),
+		Body:                 bytes.NewReader(b),
+		ContentType:          aws.String("application/json"),
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	}); err != nil {
+		return log.Errorf(logger, "Error storing reconciliation report", err)
+	}
+
+	log.Info(logger, "Reconciled FFIS pipeline outputs", "report_key", reportKey)
+	return nil
+}
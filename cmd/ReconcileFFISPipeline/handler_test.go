@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+func setupLambdaEnvForTesting(t *testing.T, extras goenv.EnvSet) {
+	t.Helper()
+
+	// Suppress normal lambda log output
+	logger = log.NewNopLogger()
+	sendMetric = func(metric string, value float64, tags ...string) {}
+
+	// Configure environment variables
+	es := goenv.EnvSet{
+		"GRANTS_SOURCE_DATA_BUCKET_NAME":   "test-source",
+		"GRANTS_PREPARED_DATA_BUCKET_NAME": "test-prepared",
+		"LOOKBACK_DAYS":                    "21",
+		"MAX_CONCURRENT_CHECKS":            "3",
+	}
+	for k, v := range extras {
+		es[k] = v
+	}
+	env = Environment{}
+	err := goenv.Unmarshal(es, &env)
+	require.NoError(t, err, "Error configuring environment variables for testing")
+}
+
+type fakeObject struct {
+	body         []byte
+	contentType  string
+	metadata     map[string]string
+	lastModified time.Time
+}
+
+// fakeS3 is a fake S3API holding objects in any number of buckets.
+type fakeS3 struct {
+	mu          sync.Mutex
+	objects     map[string]map[string]fakeObject // Objects by key, by bucket
+	copies      []*s3.CopyObjectInput
+	copyErrKeys map[string]bool
+	headErrKeys map[string]bool
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects:     map[string]map[string]fakeObject{},
+		copyErrKeys: map[string]bool{},
+		headErrKeys: map[string]bool{},
+	}
+}
+
+func (c *fakeS3) put(bucket, key string, obj fakeObject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.objects[bucket] == nil {
+		c.objects[bucket] = map[string]fakeObject{}
+	}
+	c.objects[bucket][key] = obj
+}
+
+func (c *fakeS3) get(bucket, key string) (fakeObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[bucket][key]
+	return obj, ok
+}
+
+func (c *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := []string{}
+	for key := range c.objects[aws.ToString(params.Bucket)] {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range keys {
+		obj := c.objects[aws.ToString(params.Bucket)][key]
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			LastModified: aws.Time(obj.lastModified),
+		})
+	}
+	return out, nil
+}
+
+func (c *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	obj, ok := c.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(obj.body))}, nil
+}
+
+func (c *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if c.headErrKeys[aws.ToString(params.Key)] {
+		return nil, fmt.Errorf("access denied")
+	}
+	obj, ok := c.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ContentType:          aws.String(obj.contentType),
+		Metadata:             obj.metadata,
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	}, nil
+}
+
+func (c *fakeS3) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	c.mu.Lock()
+	c.copies = append(c.copies, params)
+	c.mu.Unlock()
+	if c.copyErrKeys[aws.ToString(params.Key)] {
+		return nil, fmt.Errorf("access denied")
+	}
+	source, err := url.PathUnescape(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	bucket, key, _ := strings.Cut(source, "/")
+	obj, ok := c.get(bucket, key)
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		obj.contentType = aws.ToString(params.ContentType)
+		obj.metadata = params.Metadata
+	}
+	c.put(aws.ToString(params.Bucket), aws.ToString(params.Key), obj)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (c *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if params.ServerSideEncryption != types.ServerSideEncryptionAes256 {
+		return nil, fmt.Errorf("bucket policy requires encrypted uploads")
+	}
+	c.put(aws.ToString(params.Bucket), aws.ToString(params.Key),
+		fakeObject{body: b, contentType: aws.ToString(params.ContentType)})
+	return &s3.PutObjectOutput{}, nil
+}
+
+var testNow = time.Date(2023, 5, 16, 12, 0, 0, 0, time.UTC)
+
+// seedPipelineForTesting populates c with the outputs of the FFIS pipeline over several weeks,
+// including deliberate gaps:
+//   - the 2023-05-08 digest was never downloaded
+//   - the 2023-05-01 spreadsheet was never split
+//   - the 2023-05-10 spreadsheet (with an unpadded key) could not be parsed
+//   - an opportunity recorded by the 2023-05-15 manifest does not exist
+func seedPipelineForTesting(t *testing.T, c *fakeS3) {
+	t.Helper()
+	source := func(key string, lastModified time.Time, metadata map[string]string) {
+		c.put("test-source", key, fakeObject{
+			body:         []byte("contents"),
+			contentType:  "application/octet-stream",
+			metadata:     metadata,
+			lastModified: lastModified,
+		})
+	}
+	manifest := func(key string, lastModified time.Time, m ffis.SplitManifest) {
+		b, err := json.Marshal(m)
+		require.NoError(t, err)
+		c.put("test-prepared", key, fakeObject{body: b, lastModified: lastModified})
+	}
+	day := func(d int) time.Time { return time.Date(2023, 5, d, 9, 0, 0, 0, time.UTC) }
+
+	// Outside the reconciled date range
+	source("sources/2023/04/01/ffis.org/raw.eml", time.Date(2023, 4, 1, 9, 0, 0, 0, time.UTC), nil)
+	manifest("manifests/ffis/2023-04-01.json", time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC), ffis.SplitManifest{
+		SourceBucket: "test-source", SourceKey: "sources/2023/04/01/ffis.org/download.xlsx",
+	})
+
+	// Download missing
+	source("sources/2023/05/08/ffis.org/raw.eml", day(8), map[string]string{"sender-verified": "false"})
+
+	// Manifest missing
+	source("sources/2023/05/01/ffis.org/raw.eml", day(1), nil)
+	source("sources/2023/05/01/ffis.org/download.xlsx", day(1), nil)
+
+	// Split failed
+	source("sources/2023/5/10/ffis.org/raw.eml", day(10), nil)
+	source("sources/2023/5/10/ffis.org/download.xlsx", day(10), nil)
+	manifest("manifests/ffis/2023-05-10.json", day(10), ffis.SplitManifest{
+		SourceBucket: "test-source", SourceKey: "sources/2023/5/10/ffis.org/download.xlsx",
+		Error: "spreadsheet has no opportunity rows",
+	})
+
+	// Opportunity missing
+	source("sources/2023/05/15/ffis.org/raw.eml", day(15), nil)
+	source("sources/2023/05/15/ffis.org/download.xlsx", day(15), nil)
+	source("sources/2023/05/15/grants.gov/archive.zip", day(15), nil)
+	manifest("manifests/ffis/2023-05-15.json", day(15), ffis.SplitManifest{
+		SourceBucket: "test-source", SourceKey: "sources/2023/05/15/ffis.org/download.xlsx",
+		Written: []ffis.SplitManifestEntry{
+			{Row: 10, GrantID: 100001, Key: "100/100001/ffis.org/v1.json"},
+			{Row: 11, GrantID: 100002, Key: "100/100002/ffis.org/v1.json"},
+		},
+		Unchanged: []ffis.SplitManifestEntry{
+			{Row: 12, GrantID: 100003, Key: "100/100003/ffis.org/v1.json"},
+		},
+		Failed: []ffis.SplitManifestEntry{{Row: 13, Reason: "missing grant ID"}},
+	})
+	for _, key := range []string{"100/100001/ffis.org/v1.json", "100/100003/ffis.org/v1.json"} {
+		c.put("test-prepared", key, fakeObject{body: []byte("{}"), lastModified: day(15)})
+	}
+
+	// Too recent to check
+	source("sources/2023/05/16/ffis.org/raw.eml", testNow.Add(-30*time.Minute), nil)
+}
+
+func loadReportForTesting(t *testing.T, c *fakeS3, key string) Report {
+	t.Helper()
+	obj, ok := c.get("test-prepared", key)
+	require.True(t, ok, "report was not stored at %s", key)
+	assert.Equal(t, "application/json", obj.contentType)
+	var report Report
+	require.NoError(t, json.Unmarshal(obj.body, &report))
+	return report
+}
+
+func TestHandleEvent(t *testing.T) {
+	ctx := context.Background()
+	reportKey := "reports/reconciliation/ffis/2023-04-26_2023-05-16.json"
+	expectedGaps := []Gap{
+		{
+			Type:           GapDownloadMissing,
+			Bucket:         "test-source",
+			Key:            "sources/2023/05/08/ffis.org/raw.eml",
+			ExpectedBucket: "test-source",
+			ExpectedKey:    "sources/2023/05/08/ffis.org/download.xlsx",
+			Repair: &Repair{Action: RepairReenqueueDownload, Bucket: "test-source",
+				Key: "sources/2023/05/08/ffis.org/raw.eml"},
+		},
+		{
+			Type:           GapManifestMissing,
+			Bucket:         "test-source",
+			Key:            "sources/2023/05/01/ffis.org/download.xlsx",
+			ExpectedBucket: "test-prepared",
+			ExpectedKey:    "manifests/ffis/2023-05-01.json",
+			Repair: &Repair{Action: RepairResplit, Bucket: "test-source",
+				Key: "sources/2023/05/01/ffis.org/download.xlsx"},
+		},
+		{
+			Type:           GapSplitFailed,
+			Bucket:         "test-source",
+			Key:            "sources/2023/5/10/ffis.org/download.xlsx",
+			ExpectedBucket: "test-prepared",
+			ExpectedKey:    "manifests/ffis/2023-05-10.json",
+			Detail:         "spreadsheet has no opportunity rows",
+			Repair: &Repair{Action: RepairResplit, Bucket: "test-source",
+				Key: "sources/2023/5/10/ffis.org/download.xlsx"},
+		},
+		{
+			Type:           GapOpportunityMissing,
+			Bucket:         "test-prepared",
+			Key:            "manifests/ffis/2023-05-15.json",
+			ExpectedBucket: "test-prepared",
+			ExpectedKey:    "100/100002/ffis.org/v1.json",
+			GrantID:        100002,
+			Repair: &Repair{Action: RepairResplit, Bucket: "test-source",
+				Key: "sources/2023/05/15/ffis.org/download.xlsx"},
+		},
+	}
+
+	t.Run("reports gaps without repairing them", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		metrics := map[string]float64{}
+		sendMetric = func(metric string, value float64, tags ...string) { metrics[metric] += value }
+		c := newFakeS3()
+		seedPipelineForTesting(t, c)
+
+		require.NoError(t, handleEvent(ctx, c, ScheduledEvent{Timestamp: testNow}))
+		report := loadReportForTesting(t, c, reportKey)
+		assert.Equal(t, "2023-04-26", report.StartDate)
+		assert.Equal(t, "2023-05-16", report.EndDate)
+		assert.Equal(t, testNow, report.GeneratedAt)
+		assert.False(t, report.RepairEnabled)
+		assert.Equal(t, CheckCounts{Emails: 5, Downloads: 3, Manifests: 2, Opportunities: 3, Pending: 1},
+			report.Checked)
+		assert.Equal(t, expectedGaps, report.Gaps)
+		assert.Equal(t, map[string]int{
+			GapDownloadMissing: 1, GapManifestMissing: 1, GapSplitFailed: 1, GapOpportunityMissing: 1,
+		}, report.GapCounts)
+		assert.Equal(t, map[string]float64{
+			"gap.download_missing": 1, "gap.manifest_missing": 1,
+			"gap.split_failed": 1, "gap.opportunity_missing": 1,
+		}, metrics)
+		assert.Empty(t, c.copies, "no objects should be re-triggered when repairs are disabled")
+	})
+
+	t.Run("reports gaps without repairing them while repair is disabled", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, goenv.EnvSet{"REPAIR_ENABLED": "true"})
+		disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureReconcileRepair: true}
+		t.Cleanup(func() { disabledFeatures = nil })
+		metrics := map[string]float64{}
+		sendMetric = func(metric string, value float64, tags ...string) { metrics[metric] += value }
+		c := newFakeS3()
+		seedPipelineForTesting(t, c)
+
+		require.NoError(t, handleEvent(ctx, c, ScheduledEvent{Timestamp: testNow}))
+		report := loadReportForTesting(t, c, reportKey)
+		assert.Equal(t, expectedGaps, report.Gaps, "no repairs should be attempted")
+		assert.Empty(t, c.copies, "no objects should be re-triggered while repair is disabled")
+		assert.Equal(t, float64(4), metrics["stage.skipped_disabled"])
+		assert.NotContains(t, metrics, "repair.succeeded")
+	})
+
+	t.Run("repairs gaps", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, goenv.EnvSet{"REPAIR_ENABLED": "true"})
+		metrics := map[string]float64{}
+		sendMetric = func(metric string, value float64, tags ...string) { metrics[metric] += value }
+		c := newFakeS3()
+		seedPipelineForTesting(t, c)
+		c.copyErrKeys["sources/2023/05/01/ffis.org/download.xlsx"] = true
+
+		require.NoError(t, handleEvent(ctx, c, ScheduledEvent{Timestamp: testNow}))
+		report := loadReportForTesting(t, c, reportKey)
+		assert.True(t, report.RepairEnabled)
+		require.Len(t, report.Gaps, 4)
+		for _, gap := range report.Gaps {
+			assert.True(t, gap.Repair.Attempted, "repair of %s gap was not attempted", gap.Type)
+			if gap.Type == GapManifestMissing {
+				assert.Contains(t, gap.Repair.Error, "access denied")
+			} else {
+				assert.Empty(t, gap.Repair.Error)
+			}
+		}
+		assert.Equal(t, float64(3), metrics["repair.succeeded"])
+		assert.Equal(t, float64(1), metrics["repair.failed"])
+
+		copied := []string{}
+		for _, input := range c.copies {
+			assert.Equal(t, aws.ToString(input.Bucket)+"/"+aws.ToString(input.Key),
+				aws.ToString(input.CopySource), "objects should be copied onto themselves")
+			assert.Equal(t, types.MetadataDirectiveReplace, input.MetadataDirective)
+			assert.Equal(t, types.ServerSideEncryptionAes256, input.ServerSideEncryption)
+			copied = append(copied, aws.ToString(input.Key))
+		}
+		assert.ElementsMatch(t, []string{
+			"sources/2023/05/08/ffis.org/raw.eml",
+			"sources/2023/05/01/ffis.org/download.xlsx",
+			"sources/2023/5/10/ffis.org/download.xlsx",
+			"sources/2023/05/15/ffis.org/download.xlsx",
+		}, copied)
+
+		email, _ := c.get("test-source", "sources/2023/05/08/ffis.org/raw.eml")
+		assert.Equal(t, "application/octet-stream", email.contentType)
+		assert.Equal(t, map[string]string{
+			"sender-verified":       "false",
+			ReconciledAtMetadataKey: "2023-05-16T12:00:00Z",
+		}, email.metadata)
+	})
+
+	t.Run("re-triggers each object once", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, goenv.EnvSet{"REPAIR_ENABLED": "true"})
+		c := newFakeS3()
+		seedPipelineForTesting(t, c)
+		delete(c.objects["test-prepared"], "100/100001/ffis.org/v1.json")
+
+		require.NoError(t, handleEvent(ctx, c, ScheduledEvent{Timestamp: testNow}))
+		report := loadReportForTesting(t, c, reportKey)
+		assert.Equal(t, 2, report.GapCounts[GapOpportunityMissing])
+		resplits := 0
+		for _, input := range c.copies {
+			if aws.ToString(input.Key) == "sources/2023/05/15/ffis.org/download.xlsx" {
+				resplits++
+			}
+		}
+		assert.Equal(t, 1, resplits)
+	})
+
+	t.Run("explicit date range", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newFakeS3()
+		seedPipelineForTesting(t, c)
+
+		require.NoError(t, handleEvent(ctx, c, ScheduledEvent{
+			Timestamp: testNow, StartDate: "2023-05-01", EndDate: "2023-05-08",
+		}))
+		report := loadReportForTesting(t, c, "reports/reconciliation/ffis/2023-05-01_2023-05-08.json")
+		assert.Equal(t, CheckCounts{Emails: 2, Downloads: 1}, report.Checked)
+		assert.Equal(t, []Gap{expectedGaps[0], expectedGaps[1]}, report.Gaps)
+	})
+
+	t.Run("no pipeline outputs", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newFakeS3()
+		require.NoError(t, handleEvent(ctx, c, ScheduledEvent{Timestamp: testNow}))
+		report := loadReportForTesting(t, c, reportKey)
+		assert.Empty(t, report.Gaps)
+		assert.Equal(t, 0, report.GapCounts[GapDownloadMissing])
+	})
+
+	t.Run("error checking opportunity", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newFakeS3()
+		seedPipelineForTesting(t, c)
+		c.headErrKeys["100/100003/ffis.org/v1.json"] = true
+
+		err := handleEvent(ctx, c, ScheduledEvent{Timestamp: testNow})
+		assert.ErrorContains(t, err, "access denied")
+		_, ok := c.get("test-prepared", reportKey)
+		assert.False(t, ok, "incomplete report should not be stored")
+	})
+}
+
+func TestScheduledEventDateRange(t *testing.T) {
+	setupLambdaEnvForTesting(t, goenv.EnvSet{"LOOKBACK_DAYS": "7"})
+	date := func(s string) time.Time {
+		d, err := time.Parse(dateLayout, s)
+		require.NoError(t, err)
+		return d
+	}
+
+	for _, tt := range []struct {
+		name          string
+		event         ScheduledEvent
+		expectedStart string
+		expectedEnd   string
+		expectedErr   string
+	}{
+		{"default", ScheduledEvent{}, "2023-05-10", "2023-05-16", ""},
+		{"end date only", ScheduledEvent{EndDate: "2023-05-08"}, "2023-05-02", "2023-05-08", ""},
+		{"start and end dates", ScheduledEvent{StartDate: "2023-01-01", EndDate: "2023-05-08"}, "2023-01-01", "2023-05-08", ""},
+		{"invalid start date", ScheduledEvent{StartDate: "2023-02-30"}, "", "", "invalid start_date"},
+		{"invalid end date", ScheduledEvent{EndDate: "May 8"}, "", "", "invalid end_date"},
+		{"start after end", ScheduledEvent{StartDate: "2023-05-20"}, "", "", "is after end_date"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := tt.event.dateRange(testNow)
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, date(tt.expectedStart), start)
+			assert.Equal(t, date(tt.expectedEnd), end)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Package main compiles to an AWS Lambda handler binary that, when invoked on a schedule,
+// reconciles the expected outputs of the FFIS ingestion pipeline with the objects that actually
+// exist in S3. For each FFIS digest email archived under the sources/ prefix of the bucket named
+// by GRANTS_SOURCE_DATA_BUCKET_NAME within the reconciled date range, it verifies that the
+// spreadsheet was downloaded, that the spreadsheet was split (i.e. that a split manifest exists
+// under the manifests/ffis/ prefix of the bucket named by GRANTS_PREPARED_DATA_BUCKET_NAME), and
+// that every opportunity recorded by the manifest exists in the prepared data bucket.
+// Gaps are reported as metrics and as a JSON report stored under REPORT_KEY_PREFIX in the
+// prepared data bucket. When REPAIR_ENABLED is true (and the reconcile_repair feature is not
+// listed in DISABLED_FEATURES), the pipeline stage that should have produced each missing output
+// is re-triggered.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+	"time"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+type Environment struct {
+	LogLevel            string        `env:"LOG_LEVEL,default=INFO"`
+	SourceBucket        string        `env:"GRANTS_SOURCE_DATA_BUCKET_NAME,required=true"`
+	PreparedDataBucket  string        `env:"GRANTS_PREPARED_DATA_BUCKET_NAME,required=true"`
+	DigestSubpath       string        `env:"FFIS_DESTINATION_SUBPATH,default=ffis.org"`
+	LookbackDays        int           `env:"LOOKBACK_DAYS,default=7"`
+	GracePeriod         time.Duration `env:"GRACE_PERIOD,default=2h"`
+	MaxConcurrentChecks int           `env:"MAX_CONCURRENT_CHECKS,default=10"`
+	RepairEnabled       bool          `env:"REPAIR_ENABLED,default=false"`
+	ReportKeyPrefix     string        `env:"REPORT_KEY_PREFIX,default=reports/reconciliation/ffis"`
+	UsePathStyleS3Opt   bool          `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider     string        `env:"TRACING_PROVIDER,default=datadog"`
+	DisabledFeatures    string        `env:"DISABLED_FEATURES"`
+	Extras              goenv.EnvSet
+}
+
+var (
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("ReconcileFFISPipeline", "source:ffis.org")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if env.LookbackDays < 1 {
+		goLog.Fatalf("error configuring environment variables: LOOKBACK_DAYS must be positive")
+	}
+	if env.MaxConcurrentChecks < 1 {
+		goLog.Fatalf("error configuring environment variables: MAX_CONCURRENT_CHECKS must be positive")
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event ScheduledEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = env.UsePathStyleS3Opt
+		})
+		return handleEvent(ctx, s3Client, event)
+	}, nil))
+}
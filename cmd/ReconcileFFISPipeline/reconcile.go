@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/hashicorp/go-multierror"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+type S3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// manifestObject is a split manifest along with its object key.
+type manifestObject struct {
+	key          string
+	lastModified time.Time
+	manifest     ffis.SplitManifest
+}
+
+// opportunityCheck identifies an opportunity object that a split manifest records as existing.
+type opportunityCheck struct {
+	manifest *manifestObject
+	entry    ffis.SplitManifestEntry
+}
+
+// reconcile cross-references the digest emails and downloaded spreadsheets dated between start
+// and end (inclusive) with the outputs the pipeline should have produced from them, and returns
+// a report of the gaps it finds. Objects last modified within env.GracePeriod of now are not
+// checked, since the pipeline may still be processing them.
+func reconcile(ctx context.Context, c S3API, start, end, now time.Time) (*Report, error) {
+	report := newReport(start, end)
+	pendingSince := now.Add(-env.GracePeriod)
+
+	sources, err := listSourceObjects(ctx, c, env.SourceBucket, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error listing source objects: %w", err)
+	}
+	report.Checked.Emails = len(sources.emails)
+	report.Checked.Downloads = len(sources.downloads)
+
+	for _, key := range sortedKeys(sources.emails) {
+		if aws.ToTime(sources.emails[key].LastModified).After(pendingSince) {
+			report.Checked.Pending++
+			continue
+		}
+		downloadKey := downloadKeyForEmail(key)
+		if _, exists := sources.downloads[downloadKey]; !exists {
+			report.addGap(Gap{
+				Type:           GapDownloadMissing,
+				Bucket:         env.SourceBucket,
+				Key:            key,
+				ExpectedBucket: env.SourceBucket,
+				ExpectedKey:    downloadKey,
+				Repair:         &Repair{Action: RepairReenqueueDownload, Bucket: env.SourceBucket, Key: key},
+			})
+		}
+	}
+
+	manifests, err := loadManifests(ctx, c, env.PreparedDataBucket, start)
+	if err != nil {
+		return nil, fmt.Errorf("error loading split manifests: %w", err)
+	}
+	checks := []opportunityCheck{}
+	for _, key := range sortedKeys(sources.downloads) {
+		if aws.ToTime(sources.downloads[key].LastModified).After(pendingSince) {
+			report.Checked.Pending++
+			continue
+		}
+		resplit := &Repair{Action: RepairResplit, Bucket: env.SourceBucket, Key: key}
+		m, exists := manifests[key]
+		if !exists {
+			gap := Gap{
+				Type:           GapManifestMissing,
+				Bucket:         env.SourceBucket,
+				Key:            key,
+				ExpectedBucket: env.PreparedDataBucket,
+				Repair:         resplit,
+			}
+			if date, ok := parseSourceKeyDate(sourceKeyPattern(env.DigestSubpath, downloadFileName), key); ok {
+				gap.ExpectedKey = ffis.SplitManifestKey(date.Format(dateLayout))
+			}
+			report.addGap(gap)
+			continue
+		}
+		report.Checked.Manifests++
+		if m.manifest.Error != "" {
+			report.addGap(Gap{
+				Type:           GapSplitFailed,
+				Bucket:         env.SourceBucket,
+				Key:            key,
+				ExpectedBucket: env.PreparedDataBucket,
+				ExpectedKey:    m.key,
+				Detail:         m.manifest.Error,
+				Repair:         resplit,
+			})
+			continue
+		}
+		for _, entries := range [][]ffis.SplitManifestEntry{m.manifest.Written, m.manifest.Unchanged} {
+			for _, entry := range entries {
+				if entry.Key != "" {
+					checks = append(checks, opportunityCheck{manifest: m, entry: entry})
+				}
+			}
+		}
+	}
+
+	report.Checked.Opportunities = len(checks)
+	missing, err := findMissingOpportunities(ctx, c, checks)
+	if err != nil {
+		return nil, fmt.Errorf("error checking opportunity objects: %w", err)
+	}
+	for _, check := range missing {
+		report.addGap(Gap{
+			Type:           GapOpportunityMissing,
+			Bucket:         env.PreparedDataBucket,
+			Key:            check.manifest.key,
+			ExpectedBucket: env.PreparedDataBucket,
+			ExpectedKey:    check.entry.Key,
+			GrantID:        check.entry.GrantID,
+			Repair: &Repair{
+				Action: RepairResplit,
+				Bucket: check.manifest.manifest.SourceBucket,
+				Key:    check.manifest.manifest.SourceKey,
+			},
+		})
+	}
+
+	report.sortGaps()
+	return report, nil
+}
+
+// loadManifests returns the split manifests in bucket that were last modified on or after since,
+// keyed by the key of the spreadsheet they describe. When several manifests describe the same
+// spreadsheet, the most recently modified one is used.
+func loadManifests(ctx context.Context, c S3API, bucket string, since time.Time) (map[string]*manifestObject, error) {
+	objects, err := awsHelpers.ListS3Objects(ctx, c, bucket, ffis.SplitManifestKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	manifests := map[string]*manifestObject{}
+	for _, obj := range objects {
+		lastModified := aws.ToTime(obj.LastModified)
+		if lastModified.Before(since) {
+			continue
+		}
+		m := &manifestObject{key: aws.ToString(obj.Key), lastModified: lastModified}
+		if err := getJSON(ctx, c, bucket, m.key, &m.manifest); err != nil {
+			log.Warn(logger, "Ignoring split manifest that could not be loaded",
+				"manifest_key", m.key, "error", err)
+			sendMetric("manifest.unreadable", 1)
+			continue
+		}
+		if existing, exists := manifests[m.manifest.SourceKey]; !exists || m.lastModified.After(existing.lastModified) {
+			manifests[m.manifest.SourceKey] = m
+		}
+	}
+	return manifests, nil
+}
+
+// findMissingOpportunities checks (with up to env.MaxConcurrentChecks concurrent requests)
+// whether the opportunity object of each check exists, and returns the checks of the
+// opportunities that do not.
+func findMissingOpportunities(ctx context.Context, c S3API, checks []opportunityCheck) ([]opportunityCheck, error) {
+	work := make(chan opportunityCheck)
+	missing := []opportunityCheck{}
+	mu := sync.Mutex{}
+	wg := multierror.Group{}
+	// Work stops being sent once any check fails, so that the remaining workers can exit
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i := 0; i < env.MaxConcurrentChecks; i++ {
+		wg.Go(func() error {
+			for check := range work {
+				exists, err := objectExists(ctx, c, env.PreparedDataBucket, check.entry.Key)
+				if err != nil {
+					cancel()
+					return fmt.Errorf("error checking %s: %w", check.entry.Key, err)
+				}
+				if !exists {
+					mu.Lock()
+					missing = append(missing, check)
+					mu.Unlock()
+				}
+			}
+			return nil
+		})
+	}
+
+	go func() {
+		defer close(work)
+		for _, check := range checks {
+			select {
+			case work <- check:
+			case <-workCtx.Done():
+				return
+			}
+		}
+	}()
+	if err := wg.Wait().ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// objectExists returns true when an object exists at key in bucket.
+func objectExists(ctx context.Context, c S3API, bucket, key string) (bool, error) {
+	_, err := c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func getJSON(ctx context.Context, c S3API, bucket, key string, v interface{}) error {
+	resp, err := c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func sortedKeys(objects map[string]types.Object) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// ReconciledAtMetadataKey is the user-defined metadata key (i.e. x-amz-meta-reconciled-at)
+// that records when an object was last re-triggered in order to repair a gap.
+const ReconciledAtMetadataKey = "reconciled-at"
+
+// repairGaps attempts the repair of each gap in report, recording the outcome of each repair.
+// Each object is re-triggered at most once, even when it is the repair target of several gaps.
+// Returns the numbers of successful and failed repairs.
+func repairGaps(ctx context.Context, c S3API, report *Report, now time.Time) (succeeded, failed int) {
+	outcomes := map[string]error{}
+	for i := range report.Gaps {
+		repair := report.Gaps[i].Repair
+		if repair == nil {
+			continue
+		}
+		target := repair.Bucket + "/" + repair.Key
+		err, attempted := outcomes[target]
+		if !attempted {
+			err = retriggerObject(ctx, c, repair.Bucket, repair.Key, now)
+			outcomes[target] = err
+			logger := log.With(logger, "action", repair.Action, "bucket", repair.Bucket, "key", repair.Key)
+			if err != nil {
+				log.Error(logger, "Error repairing pipeline gap", err)
+				failed++
+			} else {
+				log.Info(logger, "Re-triggered pipeline stage to repair gap")
+				succeeded++
+			}
+		}
+		repair.Attempted = true
+		if err != nil {
+			repair.Error = err.Error()
+		}
+	}
+	return succeeded, failed
+}
+
+// retriggerObject copies the object at key in bucket onto itself, which emits an S3
+// ObjectCreated:Copy event that re-triggers the pipeline stage that processes the object.
+// S3 only allows an object to be copied onto itself when its metadata is replaced, so the
+// object retains its existing content type, user-defined metadata, and encryption, and the
+// time of the repair is recorded under ReconciledAtMetadataKey.
+func retriggerObject(ctx context.Context, c S3API, bucket, key string, now time.Time) error {
+	head, err := c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("error reading S3 object attributes: %w", err)
+	}
+	metadata := make(map[string]string, len(head.Metadata)+1)
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	metadata[ReconciledAtMetadataKey] = now.UTC().Format(time.RFC3339)
+
+	input := &s3.CopyObjectInput{
+		CopySource:           aws.String((&url.URL{Path: bucket + "/" + key}).EscapedPath()),
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ContentType:          head.ContentType,
+		Metadata:             metadata,
+		MetadataDirective:    types.MetadataDirectiveReplace,
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	}
+	if head.ServerSideEncryption == types.ServerSideEncryptionAwsKms {
+		input.ServerSideEncryption = head.ServerSideEncryption
+		input.SSEKMSKeyId = head.SSEKMSKeyId
+	}
+	if _, err := c.CopyObject(ctx, input); err != nil {
+		return fmt.Errorf("error copying S3 object onto itself: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Types of gaps between the expected and actual outputs of the FFIS pipeline
+const (
+	// An archived digest email has no downloaded spreadsheet
+	GapDownloadMissing = "download_missing"
+	// A downloaded spreadsheet has no split manifest
+	GapManifestMissing = "manifest_missing"
+	// The split manifest of a downloaded spreadsheet records that it could not be parsed
+	GapSplitFailed = "split_failed"
+	// An opportunity recorded by a split manifest does not exist in the prepared data bucket
+	GapOpportunityMissing = "opportunity_missing"
+)
+
+// GapTypes are all types of gaps, in the order in which they are reported.
+var GapTypes = []string{GapDownloadMissing, GapManifestMissing, GapSplitFailed, GapOpportunityMissing}
+
+// Actions that repair gaps by re-triggering the pipeline stage that should have produced the
+// missing output
+const (
+	// Re-triggers EnqueueFFISDownload for an archived digest email
+	RepairReenqueueDownload = "reenqueue_download"
+	// Re-triggers SplitFFISSpreadsheet for a downloaded spreadsheet
+	RepairResplit = "resplit"
+)
+
+// Report is the machine-readable result of a reconciliation run.
+type Report struct {
+	StartDate     string         `json:"start_date"` // First reconciled date (inclusive), e.g. 2023-05-15
+	EndDate       string         `json:"end_date"`   // Last reconciled date (inclusive)
+	GeneratedAt   time.Time      `json:"generated_at"`
+	RepairEnabled bool           `json:"repair_enabled"`
+	Checked       CheckCounts    `json:"checked"`
+	GapCounts     map[string]int `json:"gap_counts"`
+	Gaps          []Gap          `json:"gaps"`
+}
+
+// CheckCounts are the numbers of pipeline outputs that were checked by a reconciliation run.
+type CheckCounts struct {
+	Emails        int `json:"emails"`
+	Downloads     int `json:"downloads"`
+	Manifests     int `json:"manifests"`
+	Opportunities int `json:"opportunities"`
+	// Objects too recent to expect their outputs to exist yet (see GRACE_PERIOD)
+	Pending int `json:"pending"`
+}
+
+// Gap describes an output that the pipeline should have produced from an existing object,
+// but which does not exist.
+type Gap struct {
+	Type           string  `json:"type"`
+	Bucket         string  `json:"bucket"`
+	Key            string  `json:"key"` // The object from which the missing output should have been produced
+	ExpectedBucket string  `json:"expected_bucket"`
+	ExpectedKey    string  `json:"expected_key,omitempty"` // Empty when the expected key cannot be determined
+	GrantID        int64   `json:"grant_id,omitempty"`
+	Detail         string  `json:"detail,omitempty"`
+	Repair         *Repair `json:"repair,omitempty"`
+}
+
+// Repair describes the action that repairs a gap, and its outcome.
+type Repair struct {
+	Action    string `json:"action"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`       // The object whose pipeline stage is re-triggered
+	Attempted bool   `json:"attempted"` // False when repairs are not enabled
+	Error     string `json:"error,omitempty"`
+}
+
+// addGap adds gap to the report.
+func (r *Report) addGap(gap Gap) {
+	r.Gaps = append(r.Gaps, gap)
+	r.GapCounts[gap.Type]++
+}
+
+// sortGaps orders the report's gaps by type (according to GapTypes) and then by key.
+func (r *Report) sortGaps() {
+	order := map[string]int{}
+	for i, t := range GapTypes {
+		order[t] = i
+	}
+	sort.SliceStable(r.Gaps, func(i, j int) bool {
+		a, b := r.Gaps[i], r.Gaps[j]
+		if a.Type != b.Type {
+			return order[a.Type] < order[b.Type]
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.ExpectedKey < b.ExpectedKey
+	})
+}
+
+func newReport(start, end time.Time) *Report {
+	r := &Report{
+		StartDate:     start.Format(dateLayout),
+		EndDate:       end.Format(dateLayout),
+		RepairEnabled: env.RepairEnabled,
+		GapCounts:     map[string]int{},
+		Gaps:          []Gap{},
+	}
+	for _, t := range GapTypes {
+		r.GapCounts[t] = 0
+	}
+	return r
+}
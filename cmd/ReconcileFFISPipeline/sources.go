@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+)
+
+const dateLayout = "2006-01-02"
+
+// File names of the objects archived by the FFIS pipeline in the source data bucket
+const (
+	emailFileName    = "raw.eml"
+	downloadFileName = "download.xlsx"
+)
+
+// sourceKeyPattern matches the keys of the objects with the given file name that are archived by
+// the FFIS pipeline, which are keyed as "sources/YYYY/MM[/DD[/HH]]/<subpath>/<file name>"
+// depending on the date granularity configured for ReceiveFFISEmail.
+// Older keys may have unpadded month, day, and hour components.
+func sourceKeyPattern(subpath, fileName string) *regexp.Regexp {
+	return regexp.MustCompile(`^sources/(\d{4})/(\d{1,2})(?:/(\d{1,2}))?(?:/(\d{1,2}))?/` +
+		regexp.QuoteMeta(subpath) + `/` + regexp.QuoteMeta(fileName) + `$`)
+}
+
+// parseSourceKeyDate returns the (UTC) date represented by the date components of a source key.
+// When the key omits the day or hour, the date is the start of the month or day, respectively.
+// ok is false when key does not match pattern or its date is invalid.
+func parseSourceKeyDate(pattern *regexp.Regexp, key string) (date time.Time, ok bool) {
+	m := pattern.FindStringSubmatch(key)
+	if m == nil {
+		return time.Time{}, false
+	}
+	components := []int{0, 1, 1, 0} // year, month, day, hour
+	for i, s := range m[1:] {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return time.Time{}, false
+		}
+		components[i] = n
+	}
+	year, month, day, hour := components[0], components[1], components[2], components[3]
+	date = time.Date(year, time.Month(month), day, hour, 0, 0, 0, time.UTC)
+	// time.Date normalizes out-of-range values, e.g. month 13, which are not valid dates
+	if date.Month() != time.Month(month) || date.Day() != day || date.Hour() != hour {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// downloadKeyForEmail returns the key to which DownloadFFISSpreadsheet saves the spreadsheet
+// linked from the digest email at emailKey.
+func downloadKeyForEmail(emailKey string) string {
+	return strings.TrimSuffix(emailKey, emailFileName) + downloadFileName
+}
+
+// sourceObjects are the objects archived by the FFIS pipeline in the source data bucket,
+// keyed by object key.
+type sourceObjects struct {
+	emails    map[string]types.Object
+	downloads map[string]types.Object
+}
+
+// listSourceObjects returns the digest emails and downloaded spreadsheets in bucket whose keys
+// are dated between start and end (inclusive).
+func listSourceObjects(ctx context.Context, c s3.ListObjectsV2APIClient, bucket string, start, end time.Time) (sourceObjects, error) {
+	objects := sourceObjects{emails: map[string]types.Object{}, downloads: map[string]types.Object{}}
+	emailPattern := sourceKeyPattern(env.DigestSubpath, emailFileName)
+	downloadPattern := sourceKeyPattern(env.DigestSubpath, downloadFileName)
+	until := end.AddDate(0, 0, 1)
+	for year := start.Year(); year <= end.Year(); year++ {
+		listed, err := awsHelpers.ListS3Objects(ctx, c, bucket, fmt.Sprintf("sources/%d/", year))
+		if err != nil {
+			return objects, err
+		}
+		for _, obj := range listed {
+			key := aws.ToString(obj.Key)
+			if date, ok := parseSourceKeyDate(emailPattern, key); ok && !date.Before(start) && date.Before(until) {
+				objects.emails[key] = obj
+			} else if date, ok := parseSourceKeyDate(downloadPattern, key); ok && !date.Before(start) && date.Before(until) {
+				objects.downloads[key] = obj
+			}
+		}
+	}
+	return objects, nil
+}
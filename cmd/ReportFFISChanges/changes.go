@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/jsonHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+// deadlineFields are the opportunity fields that determine its deadline.
+var deadlineFields = map[string]bool{"due_date": true, "due_date_unparsed": true, "rolling_due_date": true}
+
+// ChangeReport summarizes the differences between two FFIS editions.
+type ChangeReport struct {
+	Edition         string
+	PreviousEdition string // Empty when there is no previous edition
+	GeneratedAt     time.Time
+	Added           []OpportunitySummary
+	Removed         []OpportunitySummary
+	Changed         []OpportunityChange
+	Unchanged       int
+}
+
+// OpportunitySummary describes an opportunity as it appears in an FFIS edition.
+// When the opportunity's contents are unavailable, only its GrantID is known.
+type OpportunitySummary struct {
+	GrantID  int64
+	Title    string
+	Number   string
+	Agency   string
+	Deadline string
+}
+
+// OpportunityChange describes an opportunity that differs between two FFIS editions.
+type OpportunityChange struct {
+	OpportunitySummary
+	PreviousDeadline string
+	// Fields are the names of the fields that changed, or nil when they could not be
+	// determined because either version of the opportunity is unavailable.
+	Fields []string
+}
+
+// DeadlineMoved returns true when the opportunity's deadline changed.
+func (c OpportunityChange) DeadlineMoved() bool {
+	for _, field := range c.Fields {
+		if deadlineFields[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// compareEditions compares the opportunities of the edition described by latest with those of
+// the edition described by previous (which is nil when there is no previous edition, in which
+// case every opportunity is added). Opportunities are matched by grant ID, so they are compared
+// even when their object keys differ between editions. Opportunities that could not be
+// processed in the latest edition are not reported as removed.
+func compareEditions(ctx context.Context, c S3API, latest ffis.SplitManifest, previous *ffis.SplitManifest) (ChangeReport, error) {
+	report := ChangeReport{
+		Added:   []OpportunitySummary{},
+		Removed: []OpportunitySummary{},
+		Changed: []OpportunityChange{},
+	}
+	current, failed := manifestOpportunities(latest)
+	prior := map[int64]ffis.SplitManifestEntry{}
+	if previous != nil {
+		prior, _ = manifestOpportunities(*previous)
+	}
+
+	for _, id := range sortedGrantIDs(current) {
+		entry := current[id]
+		priorEntry, existed := prior[id]
+		if existed && entry.SHA256 != "" && entry.SHA256 == priorEntry.SHA256 {
+			report.Unchanged++
+			continue
+		}
+		b, err := loadOpportunity(ctx, c, entry)
+		if err != nil {
+			return report, err
+		}
+		if !existed {
+			report.Added = append(report.Added, summarizeOpportunity(id, b))
+			continue
+		}
+		priorB, err := loadOpportunity(ctx, c, priorEntry)
+		if err != nil {
+			return report, err
+		}
+		change := OpportunityChange{OpportunitySummary: summarizeOpportunity(id, b)}
+		change.PreviousDeadline = summarizeOpportunity(id, priorB).Deadline
+		if b != nil && priorB != nil {
+			if change.Fields, err = jsonHelpers.DiffFields(priorB, b); err != nil {
+				return report, err
+			}
+			if len(change.Fields) == 0 {
+				report.Unchanged++
+				continue
+			}
+		}
+		report.Changed = append(report.Changed, change)
+	}
+
+	for _, id := range sortedGrantIDs(prior) {
+		if _, exists := current[id]; exists || failed[id] {
+			continue
+		}
+		b, err := loadOpportunity(ctx, c, prior[id])
+		if err != nil {
+			return report, err
+		}
+		report.Removed = append(report.Removed, summarizeOpportunity(id, b))
+	}
+	return report, nil
+}
+
+// loadOpportunity returns the contents of the opportunity as recorded by entry,
+// or nil when the recorded version of the opportunity is no longer available.
+func loadOpportunity(ctx context.Context, c S3API, entry ffis.SplitManifestEntry) ([]byte, error) {
+	b, err := opportunityVersion(ctx, c, env.PreparedDataBucket, entry.Key, entry.SHA256)
+	if errors.Is(err, ErrVersionNotFound) {
+		log.Warn(logger, "Opportunity version recorded by split manifest is unavailable",
+			"grant_id", entry.GrantID, "key", entry.Key, "sha256", entry.SHA256)
+		sendMetric("opportunity.unavailable", 1)
+		return nil, nil
+	}
+	return b, err
+}
+
+// summarizeOpportunity describes the opportunity with the given grant ID and JSON contents b,
+// which may be nil when the contents are unavailable.
+func summarizeOpportunity(id int64, b []byte) OpportunitySummary {
+	summary := OpportunitySummary{GrantID: id}
+	var opp ffis.FFISFundingOpportunity
+	if b == nil || json.Unmarshal(b, &opp) != nil {
+		return summary
+	}
+	summary.Title = opp.OppTitle
+	summary.Number = opp.OppNumber
+	summary.Agency = opp.Agency
+	switch {
+	case opp.RollingDueDate:
+		summary.Deadline = "Rolling"
+	case !opp.DueDate.IsZero():
+		summary.Deadline = opp.DueDate.Format("2006-01-02")
+	case opp.DueDateUnparsed != "":
+		summary.Deadline = opp.DueDateUnparsed
+	default:
+		summary.Deadline = "Not specified"
+	}
+	return summary
+}
+
+func sortedGrantIDs(entries map[int64]ffis.SplitManifestEntry) []int64 {
+	ids := make([]int64, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
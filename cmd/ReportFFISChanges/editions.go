@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+// Matches the edition date in the key of a split manifest, e.g. manifests/ffis/2023-05-15.json.
+// Separators are optional so that manifests named in other formats (e.g. 20230515.json) are
+// also recognized.
+var manifestEditionRegex = regexp.MustCompile(`(\d{4})[-_/]?(\d{2})[-_/]?(\d{2})`)
+
+// Matches the grant ID in the key of an FFIS opportunity object, e.g. 347/347509/ffis.org/v1.json
+var opportunityKeyGrantIDRegex = regexp.MustCompile(`(?:^|/)(\d+)/ffis\.org/`)
+
+// edition identifies the split manifest of a single FFIS spreadsheet edition.
+type edition struct {
+	Name         string // e.g. 2023-05-15
+	ManifestKey  string
+	lastModified time.Time
+}
+
+// parseManifestEdition returns the edition described by the split manifest at key,
+// or false when key does not name an edition.
+func parseManifestEdition(key string) (edition, bool) {
+	m := manifestEditionRegex.FindStringSubmatch(strings.TrimPrefix(key, ffis.SplitManifestKeyPrefix))
+	if m == nil {
+		return edition{}, false
+	}
+	name := fmt.Sprintf("%s-%s-%s", m[1], m[2], m[3])
+	if _, err := time.Parse("2006-01-02", name); err != nil {
+		return edition{}, false
+	}
+	return edition{Name: name, ManifestKey: key}, true
+}
+
+// listEditions returns the editions with split manifests in bucket, from oldest to newest.
+// When several manifests name the same edition, the most recently modified one is used.
+func listEditions(ctx context.Context, c s3.ListObjectsV2APIClient, bucket string) ([]edition, error) {
+	objects, err := awsHelpers.ListS3Objects(ctx, c, bucket, ffis.SplitManifestKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]edition{}
+	for _, obj := range objects {
+		e, ok := parseManifestEdition(aws.ToString(obj.Key))
+		if !ok {
+			continue
+		}
+		e.lastModified = aws.ToTime(obj.LastModified)
+		if existing, exists := byName[e.Name]; !exists || e.lastModified.After(existing.lastModified) {
+			byName[e.Name] = e
+		}
+	}
+	editions := make([]edition, 0, len(byName))
+	for _, e := range byName {
+		editions = append(editions, e)
+	}
+	sort.Slice(editions, func(i, j int) bool { return editions[i].Name < editions[j].Name })
+	return editions, nil
+}
+
+// loadManifest returns the split manifest at key.
+func loadManifest(ctx context.Context, c S3GetObjectAPI, bucket, key string) (ffis.SplitManifest, error) {
+	var manifest ffis.SplitManifest
+	resp, err := c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return manifest, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(b, &manifest)
+	return manifest, err
+}
+
+// manifestOpportunities returns the entries of the opportunities that are included in the
+// edition described by manifest, keyed by grant ID, along with the grant IDs of opportunities
+// that could not be processed. The grant ID of an entry that does not provide one is
+// determined from its key; entries without any grant ID are ignored.
+func manifestOpportunities(manifest ffis.SplitManifest) (map[int64]ffis.SplitManifestEntry, map[int64]bool) {
+	included := map[int64]ffis.SplitManifestEntry{}
+	for _, entries := range [][]ffis.SplitManifestEntry{manifest.Written, manifest.Unchanged} {
+		for _, entry := range entries {
+			if id := entryGrantID(entry); id != 0 {
+				entry.GrantID = id
+				included[id] = entry
+			}
+		}
+	}
+	failed := map[int64]bool{}
+	for _, entry := range manifest.Failed {
+		if id := entryGrantID(entry); id != 0 {
+			failed[id] = true
+		}
+	}
+	return included, failed
+}
+
+func entryGrantID(entry ffis.SplitManifestEntry) int64 {
+	if entry.GrantID != 0 {
+		return entry.GrantID
+	}
+	if m := opportunityKeyGrantIDRegex.FindStringSubmatch(entry.Key); m != nil {
+		if id, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			return id
+		}
+	}
+	return 0
+}
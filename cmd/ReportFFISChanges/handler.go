@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+var ErrEditionNotFound = errors.New("no split manifest found for FFIS edition")
+
+// ScheduledEvent represents the invocation event for this Lambda function.
+// When Edition is empty, the latest FFIS edition is reported, unless it has already been
+// reported. Otherwise, the named edition (e.g. 2023-05-22) is reported, replacing any
+// existing report for that edition.
+type ScheduledEvent struct {
+	Edition string `json:"edition"`
+}
+
+// reportedEdition is an FFIS edition along with its split manifest.
+type reportedEdition struct {
+	edition
+	manifest ffis.SplitManifest
+}
+
+// handleEvent reports the changes between the latest (or requested) FFIS edition and the
+// edition before it. Editions whose spreadsheets could not be parsed are skipped, and when
+// there is no earlier edition (e.g. on the first run), every opportunity is reported as new.
+// The plaintext report is stored first and the HTML report last, after the report is emailed,
+// since the existence of the HTML report indicates that the edition has been reported.
+func handleEvent(ctx context.Context, c S3API, mailer ReportMailer, event ScheduledEvent) error {
+	logger := log.With(logger, "bucket", env.PreparedDataBucket, "requested_edition", event.Edition)
+
+	editions, err := listEditions(ctx, c, env.PreparedDataBucket)
+	if err != nil {
+		return log.Errorf(logger, "Error listing FFIS editions", err)
+	}
+	latest, previous, err := selectEditions(ctx, c, editions, event.Edition)
+	if err != nil {
+		return log.Errorf(logger, "Error selecting FFIS editions to compare", err)
+	}
+	if latest == nil {
+		log.Info(logger, "No FFIS editions to report")
+		return nil
+	}
+	var previousManifest *ffis.SplitManifest
+	logger = log.With(logger, "edition", latest.Name)
+	if previous != nil {
+		previousManifest = &previous.manifest
+		logger = log.With(logger, "previous_edition", previous.Name)
+	} else {
+		log.Warn(logger, "No previous FFIS edition found; reporting every opportunity as new")
+	}
+
+	htmlKey := path.Join(env.ReportKeyPrefix, latest.Name+".html")
+	textKey := path.Join(env.ReportKeyPrefix, latest.Name+".txt")
+	if event.Edition == "" {
+		exists, err := objectExists(ctx, c, htmlKey)
+		if err != nil {
+			return log.Errorf(logger, "Error checking for existing report", err)
+		}
+		if exists {
+			log.Info(logger, "Latest FFIS edition has already been reported", "key", htmlKey)
+			sendMetric("report.skipped", 1)
+			return nil
+		}
+	}
+
+	span, spanCtx := tracing.StartSpanFromContext(ctx, "editions.compare")
+	report, err := compareEditions(spanCtx, c, latest.manifest, previousManifest)
+	tracing.FinishWithOutcome(span, err)
+	if err != nil {
+		return log.Errorf(logger, "Error comparing FFIS editions", err)
+	}
+	report.Edition = latest.Name
+	if previous != nil {
+		report.PreviousEdition = previous.Name
+	}
+	report.GeneratedAt = time.Now().UTC()
+	logger = log.With(logger, "count_added", len(report.Added), "count_changed", len(report.Changed),
+		"count_removed", len(report.Removed), "count_unchanged", report.Unchanged)
+
+	html, text, err := renderReport(report)
+	if err != nil {
+		return log.Errorf(logger, "Error rendering report", err)
+	}
+	if err := putReport(ctx, c, textKey, "text/plain; charset=utf-8", text); err != nil {
+		return log.Errorf(logger, "Error storing plaintext report", err)
+	}
+	if mailer != nil {
+		if err := mailer.SendReport(ctx, reportSubject(report), html, text); err != nil {
+			sendMetric("report.email_failed", 1)
+			return log.Errorf(logger, "Error emailing report", err)
+		}
+		log.Info(logger, "Emailed report")
+	}
+	if err := putReport(ctx, c, htmlKey, "text/html; charset=utf-8", html); err != nil {
+		return log.Errorf(logger, "Error storing HTML report", err)
+	}
+
+	log.Info(logger, "Reported changes between FFIS editions", "key", htmlKey)
+	sendMetric("report.created", 1)
+	sendMetric("opportunity.added", float64(len(report.Added)))
+	sendMetric("opportunity.changed", float64(len(report.Changed)))
+	sendMetric("opportunity.removed", float64(len(report.Removed)))
+	return nil
+}
+
+// selectEditions returns the edition to report (i.e. the requested edition or, when requested is
+// empty, the latest edition) and the edition before it, which is nil when there is none.
+// Editions whose manifests record that the spreadsheet could not be parsed are skipped.
+// Returns a nil edition to report when there are no editions.
+func selectEditions(ctx context.Context, c S3API, editions []edition, requested string) (*reportedEdition, *reportedEdition, error) {
+	var selected []*reportedEdition
+	for i := len(editions) - 1; i >= 0 && len(selected) < 2; i-- {
+		e := editions[i]
+		if len(selected) == 0 && requested != "" && e.Name != requested {
+			if e.Name < requested {
+				break
+			}
+			continue
+		}
+		manifest, err := loadManifest(ctx, c, env.PreparedDataBucket, e.ManifestKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading split manifest %s: %w", e.ManifestKey, err)
+		}
+		if manifest.Error != "" {
+			log.Warn(logger, "Skipping FFIS edition whose spreadsheet could not be parsed",
+				"edition", e.Name, "manifest_key", e.ManifestKey, "manifest_error", manifest.Error)
+			if requested != "" && len(selected) == 0 {
+				return nil, nil, fmt.Errorf("FFIS edition %s could not be parsed: %s", e.Name, manifest.Error)
+			}
+			continue
+		}
+		selected = append(selected, &reportedEdition{edition: e, manifest: manifest})
+	}
+
+	switch {
+	case len(selected) == 0 && requested != "":
+		return nil, nil, fmt.Errorf("%w: %s", ErrEditionNotFound, requested)
+	case len(selected) == 0:
+		return nil, nil, nil
+	case len(selected) == 1:
+		return selected[0], nil, nil
+	default:
+		return selected[0], selected[1], nil
+	}
+}
+
+// objectExists returns true when an object exists at key in env.PreparedDataBucket.
+func objectExists(ctx context.Context, c S3API, key string) (bool, error) {
+	_, err := c.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(env.PreparedDataBucket),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func putReport(ctx context.Context, c S3API, key, contentType string, body []byte) error {
+	_, err := c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(env.PreparedDataBucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(body),
+		ContentType:          aws.String(contentType),
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	})
+	return err
+}
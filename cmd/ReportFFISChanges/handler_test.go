@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLambdaEnvForTesting(t *testing.T, extras goenv.EnvSet) {
+	t.Helper()
+
+	// Suppress normal lambda log output
+	logger = log.NewNopLogger()
+	sendMetric = func(metric string, value float64, tags ...string) {}
+
+	// Configure environment variables
+	es := goenv.EnvSet{
+		"GRANTS_PREPARED_DATA_BUCKET_NAME": "test-prepared",
+		"MAX_VERSIONS_SEARCHED":            "5",
+	}
+	for k, v := range extras {
+		es[k] = v
+	}
+	env = Environment{}
+	err := goenv.Unmarshal(es, &env)
+	require.NoError(t, err, "Error configuring environment variables for testing")
+}
+
+type fakeObjectVersion struct {
+	id           string
+	body         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+// versionedS3 is a fake S3API for a single versioned bucket.
+type versionedS3 struct {
+	mu      sync.Mutex
+	objects map[string][]fakeObjectVersion // Versions of each object, oldest first
+	clock   time.Time
+}
+
+// newVersionedS3 returns a versionedS3 holding the object versions described by the named fixture,
+// which maps each object key to a list of JSON documents (from the oldest version to the newest).
+func newVersionedS3(t *testing.T, fixture string) *versionedS3 {
+	t.Helper()
+	c := &versionedS3{
+		objects: map[string][]fakeObjectVersion{},
+		clock:   time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if fixture == "" {
+		return c
+	}
+	f, err := os.ReadFile(fmt.Sprintf("fixtures/%s.json", fixture))
+	require.NoError(t, err)
+	var objects map[string][]json.RawMessage
+	require.NoError(t, json.Unmarshal(f, &objects))
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, version := range objects[key] {
+			var b bytes.Buffer
+			require.NoError(t, json.Compact(&b, version))
+			c.put(key, b.Bytes(), "application/json")
+		}
+	}
+	return c
+}
+
+func (c *versionedS3) put(key string, body []byte, contentType string) {
+	c.clock = c.clock.Add(time.Minute)
+	c.objects[key] = append(c.objects[key], fakeObjectVersion{
+		id:           fmt.Sprintf("v%d", len(c.objects[key])+1),
+		body:         body,
+		contentType:  contentType,
+		lastModified: c.clock,
+	})
+}
+
+func (c *versionedS3) current(key string) (fakeObjectVersion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := c.objects[key]
+	if len(versions) == 0 {
+		return fakeObjectVersion{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+func (c *versionedS3) sortedKeys(prefix string) []string {
+	keys := []string{}
+	for key := range c.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *versionedS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &s3.ListObjectsV2Output{}
+	for _, key := range c.sortedKeys(aws.ToString(params.Prefix)) {
+		latest := c.objects[key][len(c.objects[key])-1]
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			LastModified: aws.Time(latest.lastModified),
+		})
+	}
+	return out, nil
+}
+
+func (c *versionedS3) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &s3.ListObjectVersionsOutput{}
+	for _, key := range c.sortedKeys(aws.ToString(params.Prefix)) {
+		versions := c.objects[key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			if len(out.Versions) == int(params.MaxKeys) {
+				return out, nil
+			}
+			out.Versions = append(out.Versions, types.ObjectVersion{
+				Key:       aws.String(key),
+				VersionId: aws.String(versions[i].id),
+			})
+		}
+	}
+	return out, nil
+}
+
+func (c *versionedS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := c.objects[aws.ToString(params.Key)]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if params.VersionId == nil || aws.ToString(params.VersionId) == versions[i].id {
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(versions[i].body))}, nil
+		}
+	}
+	return nil, &types.NoSuchKey{}
+}
+
+func (c *versionedS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.objects[aws.ToString(params.Key)]) == 0 {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (c *versionedS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if params.ServerSideEncryption != types.ServerSideEncryptionAes256 {
+		return nil, fmt.Errorf("bucket policy requires encrypted uploads")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(aws.ToString(params.Key), b, aws.ToString(params.ContentType))
+	return &s3.PutObjectOutput{}, nil
+}
+
+type sentReport struct {
+	subject    string
+	html, text string
+}
+
+type fakeMailer struct {
+	sent []sentReport
+	err  error
+}
+
+func (m *fakeMailer) SendReport(ctx context.Context, subject string, html, text []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, sentReport{subject: subject, html: string(html), text: string(text)})
+	return nil
+}
+
+func TestHandleEvent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reports changes between editions", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "changes")
+		require.NoError(t, handleEvent(ctx, c, nil, ScheduledEvent{}))
+
+		textReport, ok := c.current("reports/ffis/2023-05-22.txt")
+		require.True(t, ok, "plaintext report was not stored")
+		assert.Equal(t, "text/plain; charset=utf-8", textReport.contentType)
+		htmlReport, ok := c.current("reports/ffis/2023-05-22.html")
+		require.True(t, ok, "HTML report was not stored")
+		assert.Equal(t, "text/html; charset=utf-8", htmlReport.contentType)
+
+		text := string(textReport.body)
+		assert.Contains(t, text, "FFIS changes for the 2023-05-22 edition\nCompared with the 2023-05-15 edition.")
+		assert.Contains(t, text, "New opportunities (1):\n"+
+			"- Rolling Safety Grants (DOT-SS4A-2023-01), Department of Transportation; grant ID 100005; due Rolling\n")
+		assert.Contains(t, text, "Changed opportunities (2):\n"+
+			"- Bridge Investment Program (DOT-BIP-2023-01), Department of Transportation; grant ID 100002; due 2023-07-14\n"+
+			"  Deadline moved from 2023-06-30 to 2023-07-14\n"+
+			"  Changed fields: due_date\n"+
+			"- Brownfields Assessment Grants (EPA-OLEM-2023-02), Environmental Protection Agency; grant ID 100003; due 2023-08-01\n"+
+			"  Changed fields: estimated_funding\n")
+		assert.Contains(t, text, "Removed opportunities (1):\n"+
+			"- Urban and Community Forestry (USDA-FS-2023-03), Forest Service; grant ID 100004; due 2023-05-20\n")
+		assert.Contains(t, text, "Unchanged opportunities: 1\n")
+		assert.NotContains(t, text, "100006", "opportunity that failed in latest edition should not be reported")
+
+		html := string(htmlReport.body)
+		assert.Contains(t, html, "<h2>Changed opportunities (2)</h2>")
+		assert.Contains(t, html, "<strong>Deadline moved</strong> from 2023-06-30 to 2023-07-14")
+	})
+
+	t.Run("tolerates drift between editions", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "drift")
+		require.NoError(t, handleEvent(ctx, c, nil, ScheduledEvent{}))
+
+		_, ok := c.current("reports/ffis/2023-05-29.txt")
+		assert.False(t, ok, "edition whose spreadsheet could not be parsed should not be reported")
+		report, ok := c.current("reports/ffis/2023-05-22.txt")
+		require.True(t, ok, "report for latest parsed edition was not stored")
+		text := string(report.body)
+		assert.Contains(t, text, "Compared with the 2023-05-15 edition.")
+		assert.Contains(t, text, "New opportunities (0):\nNone\n")
+		assert.Contains(t, text, "Removed opportunities (0):\nNone\n")
+		assert.Contains(t, text, "Changed opportunities (2):\n"+
+			"- Energy Efficiency and Conservation Block Grants (DOE-EECBG-2023), Department of Energy; grant ID 200001; due 2023-07-31\n"+
+			"  Changed fields: opportunity_title\n"+
+			"- Port Infrastructure Development (DOT-PIDP-2023), Maritime Administration; grant ID 200002; due 2023-05-31\n"+
+			"  Changed fields: unknown (previous version unavailable)\n")
+	})
+
+	t.Run("reports every opportunity as new without a previous edition", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "changes")
+		delete(c.objects, "manifests/ffis/2023-05-22.json")
+		require.NoError(t, handleEvent(ctx, c, nil, ScheduledEvent{}))
+
+		report, ok := c.current("reports/ffis/2023-05-15.txt")
+		require.True(t, ok, "report was not stored")
+		text := string(report.body)
+		assert.Contains(t, text, "No previous edition was found, so every opportunity is listed as new.")
+		assert.Contains(t, text, "New opportunities (5):")
+		assert.Contains(t, text, "Bridge Investment Program (DOT-BIP-2023-01), Department of Transportation; grant ID 100002; due 2023-06-30")
+		assert.Contains(t, text, "Unchanged opportunities: 0\n")
+	})
+
+	t.Run("skips edition that was already reported", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "changes")
+		c.put("reports/ffis/2023-05-22.html", []byte("existing"), "text/html; charset=utf-8")
+		mailer := &fakeMailer{}
+		require.NoError(t, handleEvent(ctx, c, mailer, ScheduledEvent{}))
+
+		report, _ := c.current("reports/ffis/2023-05-22.html")
+		assert.Equal(t, "existing", string(report.body))
+		assert.Empty(t, mailer.sent)
+
+		require.NoError(t, handleEvent(ctx, c, mailer, ScheduledEvent{Edition: "2023-05-22"}),
+			"requested edition should be reported again")
+		report, _ = c.current("reports/ffis/2023-05-22.html")
+		assert.NotEqual(t, "existing", string(report.body))
+		assert.Len(t, mailer.sent, 1)
+	})
+
+	t.Run("reports requested edition", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "changes")
+		require.NoError(t, handleEvent(ctx, c, nil, ScheduledEvent{Edition: "2023-05-15"}))
+		_, ok := c.current("reports/ffis/2023-05-15.html")
+		assert.True(t, ok, "requested edition was not reported")
+		_, ok = c.current("reports/ffis/2023-05-22.html")
+		assert.False(t, ok, "only the requested edition should be reported")
+
+		err := handleEvent(ctx, c, nil, ScheduledEvent{Edition: "2023-05-08"})
+		assert.ErrorIs(t, err, ErrEditionNotFound)
+	})
+
+	t.Run("emails report", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, goenv.EnvSet{"REPORT_KEY_PREFIX": "weekly"})
+		c := newVersionedS3(t, "changes")
+		mailer := &fakeMailer{}
+		require.NoError(t, handleEvent(ctx, c, mailer, ScheduledEvent{}))
+
+		require.Len(t, mailer.sent, 1)
+		assert.Equal(t, "FFIS changes for the 2023-05-22 edition: 1 new, 2 changed, 1 removed", mailer.sent[0].subject)
+		textReport, _ := c.current("weekly/2023-05-22.txt")
+		htmlReport, _ := c.current("weekly/2023-05-22.html")
+		assert.Equal(t, string(textReport.body), mailer.sent[0].text)
+		assert.Equal(t, string(htmlReport.body), mailer.sent[0].html)
+	})
+
+	t.Run("does not mark edition as reported when email fails", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "changes")
+		mailer := &fakeMailer{err: fmt.Errorf("email address is not verified")}
+		require.Error(t, handleEvent(ctx, c, mailer, ScheduledEvent{}))
+		_, ok := c.current("reports/ffis/2023-05-22.html")
+		assert.False(t, ok, "HTML report should not be stored until the report is emailed")
+
+		mailer.err = nil
+		require.NoError(t, handleEvent(ctx, c, mailer, ScheduledEvent{}))
+		assert.Len(t, mailer.sent, 1)
+		_, ok = c.current("reports/ffis/2023-05-22.html")
+		assert.True(t, ok, "HTML report was not stored")
+	})
+
+	t.Run("no editions", func(t *testing.T) {
+		setupLambdaEnvForTesting(t, nil)
+		c := newVersionedS3(t, "")
+		require.NoError(t, handleEvent(ctx, c, nil, ScheduledEvent{}))
+		assert.Empty(t, c.objects)
+	})
+}
+
+func TestParseManifestEdition(t *testing.T) {
+	for _, tt := range []struct {
+		key      string
+		expected string
+		ok       bool
+	}{
+		{"manifests/ffis/2023-05-15.json", "2023-05-15", true},
+		{"manifests/ffis/20230515.json", "2023-05-15", true},
+		{"manifests/ffis/2023/05/15.json", "2023-05-15", true},
+		{"manifests/ffis/2023-13-15.json", "", false},
+		{"manifests/ffis/latest.json", "", false},
+	} {
+		t.Run(tt.key, func(t *testing.T) {
+			e, ok := parseManifestEdition(tt.key)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, e.Name)
+		})
+	}
+}
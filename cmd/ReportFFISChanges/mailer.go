@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// ReportMailer sends a rendered report to its recipients.
+type ReportMailer interface {
+	SendReport(ctx context.Context, subject string, html, text []byte) error
+}
+
+type SESSendEmailAPI interface {
+	SendEmailWithContext(ctx aws.Context, input *ses.SendEmailInput, opts ...request.Option) (*ses.SendEmailOutput, error)
+}
+
+// sesReportMailer is a ReportMailer that sends reports by email via SES.
+type sesReportMailer struct {
+	client    SESSendEmailAPI
+	sender    string
+	recipient string
+}
+
+func (m *sesReportMailer) SendReport(ctx context.Context, subject string, html, text []byte) error {
+	_, err := m.client.SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source:      aws.String(m.sender),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(m.recipient)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(subject)},
+			Body: &ses.Body{
+				Html: &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(string(html))},
+				Text: &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(string(text))},
+			},
+		},
+	})
+	return err
+}
@@ -0,0 +1,94 @@
+// Package main compiles to an AWS Lambda handler binary that reports the changes between
+// editions of the FFIS spreadsheet. When invoked on a schedule, it compares the split manifest
+// of the latest FFIS edition with that of the previous edition, determines which opportunities
+// were added, removed, or changed (e.g. because their deadline moved), and stores the report as
+// HTML and plaintext under REPORT_KEY_PREFIX in the S3 bucket named by the
+// GRANTS_PREPARED_DATA_BUCKET_NAME environment variable. When REPORT_EMAIL_RECIPIENT is
+// configured, the report is also sent to that address via SES.
+package main
+
+import (
+	"context"
+	"fmt"
+	goLog "log"
+
+	ddlambda "github.com/DataDog/datadog-lambda-go"
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
+)
+
+type Environment struct {
+	LogLevel             string `env:"LOG_LEVEL,default=INFO"`
+	PreparedDataBucket   string `env:"GRANTS_PREPARED_DATA_BUCKET_NAME,required=true"`
+	ReportKeyPrefix      string `env:"REPORT_KEY_PREFIX,default=reports/ffis"`
+	ReportEmailRecipient string `env:"REPORT_EMAIL_RECIPIENT"`
+	ReportEmailSender    string `env:"REPORT_EMAIL_SENDER"`
+	MaxVersionsSearched  int32  `env:"MAX_VERSIONS_SEARCHED,default=10"`
+	UsePathStyleS3Opt    bool   `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider      string `env:"TRACING_PROVIDER,default=datadog"`
+	Extras               goenv.EnvSet
+}
+
+var (
+	env        Environment
+	logger     log.Logger
+	sendMetric = ddHelpers.NewMetricSender("ReportFFISChanges")
+)
+
+func main() {
+	es, err := goenv.UnmarshalFromEnviron(&env)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	env.Extras = es
+	log.ConfigureLogger(&logger, env.LogLevel)
+	if env.ReportEmailRecipient != "" && env.ReportEmailSender == "" {
+		goLog.Fatalf("error configuring environment variables: REPORT_EMAIL_SENDER is required when REPORT_EMAIL_RECIPIENT is set")
+	}
+	if env.MaxVersionsSearched < 1 {
+		goLog.Fatalf("error configuring environment variables: MAX_VERSIONS_SEARCHED must be positive")
+	}
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
+
+	var mailer ReportMailer
+	if env.ReportEmailRecipient != "" {
+		sess, err := session.NewSession()
+		if err != nil {
+			goLog.Fatalf("error configuring SES client: %v", err)
+		}
+		mailer = &sesReportMailer{
+			client:    ses.New(sess),
+			sender:    env.ReportEmailSender,
+			recipient: env.ReportEmailRecipient,
+		}
+	}
+
+	log.Debug(logger, "Starting Lambda")
+	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, event ScheduledEvent) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
+		cfg, err := awsHelpers.GetConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create AWS SDK config: %w", err)
+		}
+		awstrace.AppendMiddleware(&cfg)
+		s3Svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = env.UsePathStyleS3Opt
+		})
+		return handleEvent(ctx, s3Svc, mailer, event)
+	}, nil))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrVersionNotFound indicates that no (readable) version of an opportunity object matches the
+// content recorded by a split manifest, e.g. because the version has since expired.
+var ErrVersionNotFound = errors.New("no version of object matches the split manifest")
+
+type S3GetObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+type S3API interface {
+	s3.ListObjectsV2APIClient
+	S3GetObjectAPI
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// opportunityVersion returns the contents of the version of the object at key whose hex-encoded
+// SHA-256 digest is sha256Hex (or, when sha256Hex is empty, of the current version).
+// Since opportunity objects are overwritten as each edition is split, the version recorded by
+// an older edition's manifest is found by searching (up to env.MaxVersionsSearched of) the
+// object's versions, newest first. Versions that cannot be read (e.g. because they have been
+// archived) are skipped. Returns an error wrapping ErrVersionNotFound when no version matches.
+func opportunityVersion(ctx context.Context, c S3API, bucket, key, sha256Hex string) ([]byte, error) {
+	resp, err := c.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(key),
+		MaxKeys: env.MaxVersionsSearched,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range resp.Versions {
+		if aws.ToString(version.Key) != key {
+			continue
+		}
+		b, err := getObjectVersion(ctx, c, bucket, key, aws.ToString(version.VersionId))
+		var archived *types.InvalidObjectState
+		if errors.As(err, &archived) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(b)
+		if sha256Hex == "" || hex.EncodeToString(digest[:]) == sha256Hex {
+			return b, nil
+		}
+	}
+	return nil, ErrVersionNotFound
+}
+
+func getObjectVersion(ctx context.Context, c S3GetObjectAPI, bucket, key, versionID string) ([]byte, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	resp, err := c.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmlTemplate "html/template"
+	"strings"
+	textTemplate "text/template"
+)
+
+var templateFuncs = map[string]interface{}{
+	"describe": describeOpportunity,
+	"fields":   describeFields,
+}
+
+var textReportTemplate = textTemplate.Must(textTemplate.New("report").Funcs(templateFuncs).Parse(
+	`FFIS changes for the {{.Edition}} edition
+{{if .PreviousEdition}}Compared with the {{.PreviousEdition}} edition.
+{{- else}}No previous edition was found, so every opportunity is listed as new.{{end}}
+
+New opportunities ({{len .Added}}):
+{{range .Added}}- {{describe .}}
+{{else}}None
+{{end}}
+Changed opportunities ({{len .Changed}}):
+{{range .Changed}}- {{describe .OpportunitySummary}}
+{{- if .DeadlineMoved}}
+  Deadline moved from {{.PreviousDeadline}} to {{.Deadline}}
+{{- end}}
+  Changed fields: {{fields .Fields}}
+{{else}}None
+{{end}}
+Removed opportunities ({{len .Removed}}):
+{{range .Removed}}- {{describe .}}
+{{else}}None
+{{end}}
+Unchanged opportunities: {{.Unchanged}}
+Generated at {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}
+`))
+
+var htmlReportTemplate = htmlTemplate.Must(htmlTemplate.New("report").Funcs(templateFuncs).Parse(
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>FFIS changes for the {{.Edition}} edition</title>
+</head>
+<body>
+<h1>FFIS changes for the {{.Edition}} edition</h1>
+{{if .PreviousEdition}}<p>Compared with the {{.PreviousEdition}} edition.</p>
+{{- else}}<p>No previous edition was found, so every opportunity is listed as new.</p>{{end}}
+<h2>New opportunities ({{len .Added}})</h2>
+{{if .Added}}<ul>
+{{range .Added}}<li>{{describe .}}</li>
+{{end}}</ul>{{else}}<p>None</p>{{end}}
+<h2>Changed opportunities ({{len .Changed}})</h2>
+{{if .Changed}}<ul>
+{{range .Changed}}<li>{{describe .OpportunitySummary}}
+{{- if .DeadlineMoved}}<br><strong>Deadline moved</strong> from {{.PreviousDeadline}} to {{.Deadline}}{{end}}
+<br>Changed fields: {{fields .Fields}}</li>
+{{end}}</ul>{{else}}<p>None</p>{{end}}
+<h2>Removed opportunities ({{len .Removed}})</h2>
+{{if .Removed}}<ul>
+{{range .Removed}}<li>{{describe .}}</li>
+{{end}}</ul>{{else}}<p>None</p>{{end}}
+<p>Unchanged opportunities: {{.Unchanged}}</p>
+<p><small>Generated at {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</small></p>
+</body>
+</html>
+`))
+
+// describeOpportunity returns a single-line description of an opportunity,
+// e.g. "FY 2023 Community Connect (USDA-RUS-2023-01), Rural Utilities Service; grant ID 347509; due 2023-06-30".
+func describeOpportunity(s OpportunitySummary) string {
+	if s.Title == "" && s.Number == "" {
+		return fmt.Sprintf("Grant ID %d (details unavailable)", s.GrantID)
+	}
+	name := s.Title
+	if s.Number != "" && name != "" {
+		name = fmt.Sprintf("%s (%s)", name, s.Number)
+	} else if s.Number != "" {
+		name = s.Number
+	}
+	if s.Agency != "" {
+		name = fmt.Sprintf("%s, %s", name, s.Agency)
+	}
+	parts := []string{name}
+	parts = append(parts, fmt.Sprintf("grant ID %d", s.GrantID))
+	if s.Deadline != "" {
+		parts = append(parts, fmt.Sprintf("due %s", s.Deadline))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// describeFields returns a comma-separated list of changed field names.
+func describeFields(fields []string) string {
+	if fields == nil {
+		return "unknown (previous version unavailable)"
+	}
+	return strings.Join(fields, ", ")
+}
+
+// reportSubject returns a summary of report that is suitable as an email subject.
+func reportSubject(report ChangeReport) string {
+	return fmt.Sprintf("FFIS changes for the %s edition: %d new, %d changed, %d removed",
+		report.Edition, len(report.Added), len(report.Changed), len(report.Removed))
+}
+
+// renderReport returns the HTML and plaintext renderings of report.
+func renderReport(report ChangeReport) (html, text []byte, err error) {
+	htmlBuf, textBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := htmlReportTemplate.Execute(htmlBuf, report); err != nil {
+		return nil, nil, err
+	}
+	if err := textReportTemplate.Execute(textBuf, report); err != nil {
+		return nil, nil, err
+	}
+	return htmlBuf.Bytes(), textBuf.Bytes(), nil
+}
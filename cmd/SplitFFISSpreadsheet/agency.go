@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+// otherAgency is the normalized name of agencies that are not found in agencyAliases.
+const otherAgency = "other"
+
+// agencyAliasesByDepartment lists the (lower-cased) names and common abbreviations of each
+// federal department or agency, including those of its frequently-listed sub-agencies.
+var agencyAliasesByDepartment = map[string][]string{
+	"USDA": {"usda", "department of agriculture", "forest service",
+		"animal and plant health inspection service", "aphis",
+		"natural resources conservation service", "nrcs", "rural development"},
+	"DOC": {"doc", "department of commerce", "economic development administration", "eda",
+		"national oceanic and atmospheric administration", "noaa",
+		"national telecommunications and information administration", "ntia"},
+	"DOE": {"doe", "department of energy", "office of energy efficiency and renewable energy", "eere",
+		"national energy technology laboratory", "netl", "grid deployment office"},
+	"DHS": {"dhs", "department of homeland security", "federal emergency management agency", "fema"},
+	"HHS": {"hhs", "department of health and human services",
+		"administration for children and families", "acf",
+		"centers for disease control and prevention", "cdc",
+		"health resources and services administration", "hrsa",
+		"substance abuse and mental health services administration", "samhsa"},
+	"HUD": {"hud", "department of housing and urban development"},
+	"DOI": {"doi", "department of the interior", "bureau of reclamation",
+		"fish and wildlife service", "national park service"},
+	"DOJ": {"doj", "department of justice", "bureau of justice assistance", "bja",
+		"office of justice programs", "ojp"},
+	"DOL": {"dol", "department of labor", "employment and training administration", "eta"},
+	"DOT": {"dot", "department of transportation", "federal aviation administration", "faa",
+		"federal highway administration", "fhwa", "federal railroad administration", "fra",
+		"federal transit administration", "fta",
+		"national highway traffic safety administration", "nhtsa"},
+	"ED":       {"ed", "department of education"},
+	"EPA":      {"epa", "environmental protection agency"},
+	"Treasury": {"treasury", "department of the treasury"},
+}
+
+// agencyAliases maps each alias in agencyAliasesByDepartment to its department.
+var agencyAliases = func() map[string]string {
+	aliases := make(map[string]string)
+	for department, names := range agencyAliasesByDepartment {
+		for _, name := range names {
+			aliases[name] = department
+		}
+	}
+	return aliases
+}()
+
+// normalizeAgency returns the abbreviation of the federal department or agency named by raw,
+// or false when raw is not recognized. Matching is case-insensitive and ignores extraneous
+// whitespace as well as "U.S." prefixes (e.g. "U.S. Department of Transportation").
+func normalizeAgency(raw string) (string, bool) {
+	name := strings.ToLower(strings.Join(strings.Fields(raw), " "))
+	for _, prefix := range []string{"u.s. ", "us "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	agency, ok := agencyAliases[name]
+	return agency, ok
+}
+
+// countOpportunitiesByAgency returns the number of opportunities for each normalized agency
+// (see normalizeAgency), where opportunities of unrecognized agencies are counted under
+// otherAgency. The distinct unrecognized agency values are also returned, in the order in
+// which they were first encountered.
+func countOpportunitiesByAgency(opportunities []ffis.FFISFundingOpportunity) (counts map[string]int, unrecognized []string) {
+	counts = make(map[string]int)
+	seen := make(map[string]bool)
+	for _, opp := range opportunities {
+		agency, ok := normalizeAgency(opp.Agency)
+		if !ok {
+			agency = otherAgency
+			if !seen[opp.Agency] {
+				seen[opp.Agency] = true
+				unrecognized = append(unrecognized, opp.Agency)
+			}
+		}
+		counts[agency]++
+	}
+	return counts, unrecognized
+}
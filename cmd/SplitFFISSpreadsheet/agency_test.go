@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+func TestNormalizeAgency(t *testing.T) {
+	for _, tt := range []struct {
+		raw        string
+		expected   string
+		recognized bool
+	}{
+		{"Forest Service", "USDA", true},
+		{"Office of Energy Efficiency and Renewable Energy", "DOE", true},
+		{"  national   ENERGY technology laboratory ", "DOE", true},
+		{"U.S. Department of Transportation", "DOT", true},
+		{"US Department of the Treasury", "Treasury", true},
+		{"FEMA", "DHS", true},
+		{"Department of Redundancy Department", "", false},
+		{"", "", false},
+	} {
+		t.Run(tt.raw, func(t *testing.T) {
+			agency, ok := normalizeAgency(tt.raw)
+			assert.Equal(t, tt.recognized, ok)
+			assert.Equal(t, tt.expected, agency)
+		})
+	}
+}
+
+func TestCountOpportunitiesByAgency(t *testing.T) {
+	for _, tt := range []struct {
+		name                 string
+		agencies             []string
+		expectedCounts       map[string]int
+		expectedUnrecognized []string
+	}{
+		{"empty", nil, map[string]int{}, nil},
+		{
+			"recognized aliases are grouped",
+			[]string{"Forest Service", "USDA", "EPA", "Environmental Protection Agency", "NOAA"},
+			map[string]int{"USDA": 2, "EPA": 2, "DOC": 1},
+			nil,
+		},
+		{
+			"unrecognized agencies are counted as other",
+			[]string{"Mystery Agency", "DOT", "Mystery Agency", "", "Other Mystery Agency"},
+			map[string]int{"DOT": 1, "other": 4},
+			[]string{"Mystery Agency", "", "Other Mystery Agency"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opportunities := make([]ffis.FFISFundingOpportunity, 0, len(tt.agencies))
+			for _, agency := range tt.agencies {
+				opportunities = append(opportunities, ffis.FFISFundingOpportunity{Agency: agency})
+			}
+			counts, unrecognized := countOpportunitiesByAgency(opportunities)
+			assert.Equal(t, tt.expectedCounts, counts)
+			assert.Equal(t, tt.expectedUnrecognized, unrecognized)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+const (
+	spreadsheetFormatXLSX = "xlsx"
+	spreadsheetFormatCSV  = "csv"
+
+	// formatSniffLength is the number of leading bytes inspected to detect a spreadsheet's format.
+	formatSniffLength = 512
+	// delimiterSniffLength is the maximum number of leading bytes inspected to detect
+	// the delimiter of a CSV file.
+	delimiterSniffLength = 64 * 1024
+)
+
+var (
+	ErrUnknownSpreadsheetFormat = errors.New("unknown spreadsheet format")
+	ErrMissingCSVHeaders        = errors.New("CSV file has no header row")
+
+	// XLSX files are zip archives
+	zipMagic = []byte("PK\x03\x04")
+	// Excel begins the CSV files that it exports with a UTF-8 byte order mark
+	utf8BOM = []byte("\xef\xbb\xbf")
+	// Delimiters that are considered when reading a CSV file. Excel exports CSV files using
+	// the list separator of the exporting system's locale, which is sometimes a semicolon.
+	csvDelimiters = []rune{',', ';', '\t'}
+
+	// Since hyperlinks are lost when a spreadsheet is exported as CSV, the link to each
+	// opportunity is expected in an additional column with one of these (lower-cased) headers.
+	csvLinkHeaders = map[string]bool{
+		"link":             true,
+		"url":              true,
+		"opportunity link": true,
+		"opportunity url":  true,
+	}
+)
+
+// parseSpreadsheet detects the format of the FFIS spreadsheet provided by r (whose S3 object
+// key is key) and parses it as either an XLSX or CSV file.
+func parseSpreadsheet(r io.Reader, key string, logger log.Logger) (parsedSpreadsheet, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(formatSniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return parsedSpreadsheet{}, err
+	}
+
+	format, err := detectSpreadsheetFormat(key, head)
+	if err != nil {
+		return parsedSpreadsheet{}, err
+	}
+	log.Info(logger, "Detected spreadsheet format", "format", format)
+	sendMetric("spreadsheet.format", 1, fmt.Sprintf("format:%s", format))
+
+	if format == spreadsheetFormatCSV {
+		return parseCSVFile(br, logger)
+	}
+	return parseXLSXFile(br, logger)
+}
+
+// detectSpreadsheetFormat determines whether a spreadsheet is an XLSX or CSV file based on
+// its leading bytes and, when these are inconclusive, its file extension.
+// Since spreadsheets are always stored with an .xlsx extension by DownloadFFISSpreadsheet,
+// the contents of a file take precedence over its extension.
+func detectSpreadsheetFormat(key string, head []byte) (string, error) {
+	if bytes.HasPrefix(head, zipMagic) {
+		return spreadsheetFormatXLSX, nil
+	}
+	if strings.EqualFold(path.Ext(key), ".csv") {
+		return spreadsheetFormatCSV, nil
+	}
+	// Text files (unlike binary files) are not expected to contain NUL bytes
+	if len(head) > 0 && bytes.IndexByte(head, 0) == -1 {
+		return spreadsheetFormatCSV, nil
+	}
+	return "", fmt.Errorf("%w: object %q is neither an XLSX nor a CSV file",
+		ErrUnknownSpreadsheetFormat, key)
+}
+
+// sniffCSVDelimiter returns the delimiter from csvDelimiters that occurs most frequently
+// (outside of quoted fields) in data, defaulting to a comma.
+func sniffCSVDelimiter(data []byte) rune {
+	if len(data) > delimiterSniffLength {
+		data = data[:delimiterSniffLength]
+	}
+	counts := make(map[rune]int)
+	quoted := false
+	for _, c := range string(data) {
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted {
+			counts[c]++
+		}
+	}
+	delimiter := csvDelimiters[0]
+	for _, d := range csvDelimiters[1:] {
+		if counts[d] > counts[delimiter] {
+			delimiter = d
+		}
+	}
+	return delimiter
+}
+
+// parseCSVFile reads a CSV export of an FFIS spreadsheet and converts its rows into funding
+// opportunities in the same manner as parseXLSXFile. A leading UTF-8 byte order mark is ignored,
+// and the delimiter is detected with sniffCSVDelimiter. The grant ID of each opportunity is
+// parsed from the link in the column whose header is one of csvLinkHeaders; when no such
+// column exists, no opportunities can be identified. An error wrapping ErrMissingCSVHeaders
+// is returned when the file has no header row, since it is then not an FFIS spreadsheet.
+func parseCSVFile(r io.Reader, logger log.Logger) (parsedSpreadsheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return parsedSpreadsheet{}, err
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = sniffCSVDelimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return parsedSpreadsheet{}, fmt.Errorf("error reading CSV file: %w", err)
+	}
+	log.Debug(logger, "Read CSV file", "delimiter", string(reader.Comma))
+
+	headerRowIndex := csvHeaderRow(rows)
+	if headerRowIndex < 0 {
+		return parsedSpreadsheet{}, fmt.Errorf("%w: expected a row beginning with \"CFDA\"", ErrMissingCSVHeaders)
+	}
+	linkColIndex := -1
+	for i, header := range rows[headerRowIndex] {
+		if csvLinkHeaders[strings.ToLower(strings.TrimSpace(header))] {
+			linkColIndex = i
+			break
+		}
+	}
+	if linkColIndex < 0 {
+		log.Warn(logger, "CSV file has no link column from which to parse grant IDs")
+	}
+
+	return parseRows(rows, func(rowIndex, colIndex int) (bool, string, error) {
+		row := rows[rowIndex]
+		if linkColIndex < 0 || linkColIndex >= len(row) || row[linkColIndex] == "" {
+			return false, "", nil
+		}
+		return true, row[linkColIndex], nil
+	}, logger), nil
+}
+
+// csvHeaderRow returns the index of the header row (whose first cell is "CFDA") in rows,
+// or -1 if there is no such row.
+func csvHeaderRow(rows [][]string) int {
+	for i, row := range rows {
+		if len(row) > 0 && row[0] == "CFDA" {
+			return i
+		}
+	}
+	return -1
+}
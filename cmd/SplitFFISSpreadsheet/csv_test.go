@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+func TestParseSpreadsheet_csv_matches_xlsx(t *testing.T) {
+	// Ignore logging in this test
+	logger = log.NewNopLogger()
+
+	marshalAll := func(t *testing.T, fixture, key string) []string {
+		t.Helper()
+		f, err := os.Open(fixture)
+		require.NoError(t, err, "Error opening spreadsheet fixture")
+		defer f.Close()
+
+		parsed, err := parseSpreadsheet(f, key, logger)
+		require.NoError(t, err)
+		prepared := make([]string, 0, len(parsed.Opportunities))
+		for _, opp := range parsed.Opportunities {
+			b, err := json.Marshal(opportunity(opp))
+			require.NoError(t, err)
+			prepared = append(prepared, string(b))
+		}
+		return prepared
+	}
+
+	expected := marshalAll(t, "fixtures/example_spreadsheet.xlsx", "sources/2023/05/15/ffis.org/download.xlsx")
+	require.Len(t, expected, 4)
+
+	for _, tt := range []struct {
+		name    string
+		fixture string
+		key     string
+	}{
+		{"comma-delimited", "fixtures/example_spreadsheet.csv", "sources/2023/05/15/ffis.org/download.csv"},
+		{"semicolon-delimited", "fixtures/example_spreadsheet_semicolon.csv", "sources/2023/05/15/ffis.org/download.csv"},
+		{"CSV with xlsx extension", "fixtures/example_spreadsheet.csv", "sources/2023/05/15/ffis.org/download.xlsx"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, expected, marshalAll(t, tt.fixture, tt.key))
+		})
+	}
+}
+
+func TestParseCSVFile_missing_headers(t *testing.T) {
+	logger = log.NewNopLogger()
+	_, err := parseCSVFile(strings.NewReader("foo,bar\n1,2\n"), logger)
+	assert.ErrorIs(t, err, ErrMissingCSVHeaders)
+}
+
+func TestParseCSVFile_missing_link_column(t *testing.T) {
+	logger = log.NewNopLogger()
+	parsed, err := parseCSVFile(strings.NewReader(
+		"CFDA,Opportunity Title\nSome Act\n10.727,Example Opportunity\n"), logger)
+	assert.NoError(t, err)
+	assert.Empty(t, parsed.Opportunities, "Opportunities cannot be identified without links")
+	assert.Equal(t, []ffis.SplitManifestEntry{{Row: 3, Reason: "missing grant ID"}}, parsed.Rejected)
+}
+
+func TestDetectSpreadsheetFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		key            string
+		head           string
+		expectedFormat string
+		expectedErr    error
+	}{
+		{"xlsx", "ffis.org/download.xlsx", "PK\x03\x04\x14\x00", spreadsheetFormatXLSX, nil},
+		{"xlsx with csv extension", "ffis.org/download.csv", "PK\x03\x04\x14\x00", spreadsheetFormatXLSX, nil},
+		{"csv", "ffis.org/download.csv", "CFDA,Opportunity Title", spreadsheetFormatCSV, nil},
+		{"csv with upper-case extension", "ffis.org/download.CSV", "CFDA,Opportunity Title", spreadsheetFormatCSV, nil},
+		{"csv with BOM", "ffis.org/download.csv", "\xef\xbb\xbfCFDA;Opportunity Title", spreadsheetFormatCSV, nil},
+		{"csv with xlsx extension", "ffis.org/download.xlsx", "\xef\xbb\xbf,,,,,,,", spreadsheetFormatCSV, nil},
+		{"binary with xlsx extension", "ffis.org/download.xlsx", "\x89PNG\r\n\x1a\n\x00\x00", "", ErrUnknownSpreadsheetFormat},
+		{"empty with xlsx extension", "ffis.org/download.xlsx", "", "", ErrUnknownSpreadsheetFormat},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := detectSpreadsheetFormat(tt.key, []byte(tt.head))
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedFormat, format)
+		})
+	}
+}
+
+func TestSniffCSVDelimiter(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		data     string
+		expected rune
+	}{
+		{"comma", "CFDA,Opportunity Title,Agency\n10.727,Example,Forest Service\n", ','},
+		{"semicolon", "CFDA;Opportunity Title;Agency\n10.727;Example;Forest Service\n", ';'},
+		{"tab", "CFDA\tOpportunity Title\tAgency\n", '\t'},
+		{"semicolon with quoted commas", "CFDA;Title\n\"10.720, 10.727\";\"One, two, three\"\n", ';'},
+		{"no delimiters", "CFDA\n", ','},
+		{"empty", "", ','},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(tt.expected), string(sniffCSVDelimiter([]byte(tt.data))))
+		})
+	}
+}
+
+func TestParseCSVFile_due_date_formats(t *testing.T) {
+	logger = log.NewNopLogger()
+	dueDateLayouts = parseDueDateLayouts("2006.01.02; ")
+	t.Cleanup(func() { dueDateLayouts = nil })
+	f, err := os.Open("fixtures/example_spreadsheet_due_dates.csv")
+	require.NoError(t, err)
+	defer f.Close()
+
+	parsed, err := parseCSVFile(f, logger)
+	require.NoError(t, err)
+	require.Len(t, parsed.Opportunities, 4, "Opportunities with unparseable due dates should not be dropped")
+
+	dueDates := make([]string, 0, len(parsed.Opportunities))
+	unparsed := make([]string, 0, len(parsed.Opportunities))
+	for _, opp := range parsed.Opportunities {
+		b, err := json.Marshal(opportunity(opp))
+		require.NoError(t, err)
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(b, &doc))
+		dueDates = append(dueDates, doc["due_date"].(string))
+		unparsed = append(unparsed, opp.DueDateUnparsed)
+	}
+	assert.Equal(t, []string{
+		"2023-05-11T00:00:00Z", // May 11, 2023
+		"2023-06-02T00:00:00Z", // 2023.06.02 (configured layout)
+		"0001-01-01T00:00:00Z", // Sometime in the fall
+		"2023-06-12T00:00:00Z", // 45089 (Excel serial)
+	}, dueDates)
+	assert.Equal(t, []string{"", "", "Sometime in the fall", ""}, unparsed)
+}
@@ -3,21 +3,27 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/hashicorp/go-multierror"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/jsonHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
-)
-
-const (
-	MB = int64(1024 * 1024)
 )
 
 type opportunity ffis.FFISFundingOpportunity
@@ -29,17 +35,22 @@ func (o *opportunity) S3ObjectKey() string {
 }
 
 // handleS3Event handles events representing S3 bucket notifications of type "ObjectCreated:*"
+// Events are skipped without side effects while the split feature is disabled.
 func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events.S3Event) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureSplit, len(s3Event.Records)) {
+		return nil
+	}
+
 	// Configure service clients
 	s3svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.UsePathStyle = env.UsePathStyleS3Opt
 	})
 
 	// Create an opportunities channel to receive opportunities from the source sheet
-	opportunities := make(chan opportunity)
+	opportunities := make(chan splitItem)
 
 	// Create a pool of workers to consume and upload values received from the opportunities channel
-	processingSpan, processingCtx := tracer.StartSpanFromContext(ctx, "processing")
+	processingSpan, processingCtx := tracing.StartSpanFromContext(ctx, "processing")
 	wg := multierror.Group{}
 	for i := 0; i < env.MaxConcurrentUploads; i++ {
 		wg.Go(func() error {
@@ -48,11 +59,14 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 	}
 
 	// Receive the source records from the event and process each spreadsheet within them
-	sourcingSpan, sourcingCtx := tracer.StartSpanFromContext(ctx, "handle.records")
+	sourcingSpan, sourcingCtx := tracing.StartSpanFromContext(ctx, "handle.records")
 
 	sourcingErrs := &multierror.Error{}
+	manifests := make([]*manifestRecorder, 0, len(s3Event.Records))
 	for i, record := range s3Event.Records {
-		recordSpan, recordCtx := tracer.StartSpanFromContext(sourcingCtx, "handle.record")
+		recordSpan, recordCtx := tracing.StartSpanFromContext(sourcingCtx, "handle.record")
+		manifest := newManifestRecorder(record.S3.Bucket.Name, record.S3.Object.Key, time.Now())
+		manifests = append(manifests, manifest)
 
 		sourcingErr := func(i int, record events.S3EventRecord) error {
 			sourceBucket := record.S3.Bucket.Name
@@ -62,33 +76,47 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 
 			log.Info(logger, "Downloading ffis.org spreadsheet from S3")
 
-			resp, err := s3svc.GetObject(recordCtx, &s3.GetObjectInput{
-				Bucket: aws.String(sourceBucket),
-				// The FFIS xlsx spreadsheet
-				Key: aws.String(sourceKey),
-			})
-			if err != nil {
-				log.Error(logger, "Error getting source S3 object", err)
-				return err
+			// The FFIS spreadsheet is downloaded in chunks as it is parsed
+			source := awsHelpers.NewChunkedReader(recordCtx, s3svc,
+				sourceBucket, sourceKey, env.DownloadChunkLimit*awsHelpers.MB)
+			source.OnRetry = func(err error, offset int64, d time.Duration) {
+				log.Warn(logger, "Retrying failed download of source S3 object chunk",
+					"error", err, "offset", offset, "retry_in", d)
+				sendMetric("source.chunk_retry", 1)
 			}
 
-			defer resp.Body.Close()
-
-			log.Info(logger, "Parsing excel file")
+			log.Info(logger, "Parsing spreadsheet file")
 
-			parsedOpportunities, err := parseXLSXFile(resp.Body, logger)
+			// The source digest is recorded in the split manifest
+			digest := sha256.New()
+			parsed, err := parseSpreadsheet(io.TeeReader(source, digest), sourceKey, logger)
+			manifest.recordParsed(parsed, hex.EncodeToString(digest.Sum(nil)), err)
 
-			log.Info(logger, "Spreadsheet parsed", "total_opportunties", len(parsedOpportunities))
+			log.Info(logger, "Spreadsheet parsed", "total_opportunties", len(parsed.Opportunities))
 
 			if err != nil {
-				log.Error(logger, "Error parsing excel file", err)
+				log.Error(logger, "Error parsing spreadsheet file", err)
 				return err
 			}
 
-			for _, opp := range parsedOpportunities {
+			agencyCounts, unrecognizedAgencies := countOpportunitiesByAgency(parsed.Opportunities)
+			for _, agency := range unrecognizedAgencies {
+				log.Info(logger, "Counting opportunities of unrecognized agency as other",
+					"raw_value", agency)
+			}
+			for agency, count := range agencyCounts {
+				sendMetric("opportunity.parsed", float64(count), fmt.Sprintf("agency:%s", agency))
+			}
+			manifest.recordAgencies(agencyCounts)
+
+			for j, opp := range parsed.Opportunities {
 				// Cast opp to opportunity type and send it down the channel
 				// for processing
-				opportunities <- opportunity(opp)
+				opportunities <- splitItem{
+					opportunity: opportunity(opp),
+					row:         parsed.RowNumbers[j],
+					manifest:    manifest,
+				}
 			}
 
 			return nil
@@ -96,7 +124,7 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 		if sourcingErr != nil {
 			sourcingErrs = multierror.Append(sourcingErrs, sourcingErr)
 		}
-		recordSpan.Finish(tracer.WithError(sourcingErr))
+		recordSpan.Finish(tracing.WithError(sourcingErr))
 	}
 
 	// All source records have been consumed; close the channel so that workers shut down
@@ -108,8 +136,12 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 	processingErrs := wg.Wait()
 	processingSpan.Finish()
 
+	// Manifests are written regardless of whether the run partially failed
+	manifestErrs := writeManifests(ctx, s3svc, manifests)
+
 	// Combine any sourcing and processing errors to return as a single "mega-multi-error"
 	errs := multierror.Append(sourcingErrs, processingErrs)
+	errs = multierror.Append(errs, manifestErrs)
 	if err := errs.ErrorOrNil(); err != nil {
 		var countSourcingErrors, countProcessingErrors int
 		if sourcingErrs != nil {
@@ -128,14 +160,46 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 	return nil
 }
 
-// processOpportunities consumes opportunities from the channel and uploads them to S3.
-func processOpportunities(ctx context.Context, svc *s3.Client, ch <-chan opportunity) (errs error) {
-	span, ctx := tracer.StartSpanFromContext(ctx, "processing.worker")
+// splitItem is an opportunity parsed from a spreadsheet row, along with the manifest that
+// records the outcome of processing it.
+type splitItem struct {
+	opportunity opportunity
+	row         int
+	manifest    *manifestRecorder
+}
+
+// writeManifests completes and uploads each of the given split manifests. Manifests are not
+// written in shadow mode, or when ctx is already canceled.
+func writeManifests(ctx context.Context, svc S3PutObjectAPI, manifests []*manifestRecorder) error {
+	var errs error
+	for _, recorder := range manifests {
+		manifest := recorder.complete(time.Now())
+		if env.ShadowMode {
+			log.Info(logger, "Skipping split manifest in shadow mode",
+				"source_object_key", manifest.SourceKey)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			log.Warn(logger, "Skipping split manifest because context canceled",
+				"source_object_key", manifest.SourceKey, "reason", err)
+			continue
+		}
+		if err := writeManifest(ctx, svc, manifest); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// processOpportunities consumes opportunities from the channel and uploads them to S3,
+// recording the outcome of each in its manifest.
+func processOpportunities(ctx context.Context, svc S3ReadWriteObjectAPI, ch <-chan splitItem) (errs error) {
+	span, ctx := tracing.StartSpanFromContext(ctx, "processing.worker")
 
 	whenCanceled := func() error {
 		err := ctx.Err()
 		log.Debug(logger, "Done processing opportunities because context canceled", "reason", err)
-		span.Finish(tracer.WithError(err))
+		span.Finish(tracing.WithError(err))
 		errs = multierror.Append(errs, err)
 		return errs
 	}
@@ -149,20 +213,21 @@ func processOpportunities(ctx context.Context, svc *s3.Client, ch <-chan opportu
 
 		default:
 			select {
-			case opportunity, ok := <-ch:
+			case item, ok := <-ch:
 				if !ok {
 					log.Debug(logger, "Done processing opportunities because channel is closed")
 					span.Finish()
 					return
 				}
 
-				workSpan, ctx := tracer.StartSpanFromContext(ctx, "processing.worker.work")
-				err := processOpportunity(ctx, svc, opportunity)
+				workSpan, ctx := tracing.StartSpanFromContext(ctx, "processing.worker.work")
+				outcome, err := processOpportunity(ctx, svc, item.opportunity)
 				if err != nil {
 					sendMetric("opportunity.failed", 1)
 					errs = multierror.Append(errs, err)
 				}
-				workSpan.Finish(tracer.WithError(err))
+				item.manifest.recordOpportunity(item.row, item.opportunity, outcome, err)
+				workSpan.Finish(tracing.WithError(err))
 
 			case <-ctx.Done():
 				return whenCanceled()
@@ -171,8 +236,19 @@ func processOpportunities(ctx context.Context, svc *s3.Client, ch <-chan opportu
 	}
 }
 
+// opportunityOutcome describes the result of processing an opportunity.
+type opportunityOutcome struct {
+	// Hex-encoded SHA-256 digest of the opportunity JSON
+	SHA256 string
+	// Whether the upload was skipped because the existing S3 object is identical
+	Unchanged bool
+}
+
 // processOpportunity marshals the opportunity to JSON and uploads it to S3.
-func processOpportunity(ctx context.Context, svc S3PutObjectAPI, opp opportunity) error {
+// In shadow mode, the JSON is compared against the existing S3 object instead of being uploaded.
+// When env.SkipUnchanged is enabled, the upload is skipped if the existing S3 object is identical,
+// unless that object was last modified longer ago than env.ReprocessIfOlderThan.
+func processOpportunity(ctx context.Context, svc S3ReadWriteObjectAPI, opp opportunity) (opportunityOutcome, error) {
 	key := opp.S3ObjectKey()
 
 	logger := log.With(logger,
@@ -184,19 +260,113 @@ func processOpportunity(ctx context.Context, svc S3PutObjectAPI, opp opportunity
 	// Convert the parsed opportunity to JSON
 	b, err := json.Marshal(opp)
 	if err != nil {
-		return log.Errorf(logger, "Error marshaling JSON for opportunity", err)
+		return opportunityOutcome{}, log.Errorf(logger, "Error marshaling JSON for opportunity", err)
+	}
+	sum := sha256.Sum256(b)
+	outcome := opportunityOutcome{SHA256: hex.EncodeToString(sum[:])}
+
+	// Never write a document whose shape downstream consumers do not expect
+	if err := ffis.ValidateOpportunityJSON(b); err != nil {
+		sendMetric("opportunity.invalid", 1)
+		return outcome, log.Errorf(logger, "Prepared opportunity does not conform to JSON schema", err)
+	}
+
+	if env.ShadowMode {
+		return outcome, compareOpportunity(ctx, svc, key, b, logger)
+	}
+
+	if env.SkipUnchanged {
+		unchanged, lastModified, err := isOpportunityUnchanged(ctx, svc, key, b)
+		if err != nil {
+			log.Warn(logger, "Error checking existing opportunity; uploading regardless", "error", err)
+		} else if unchanged && exceedsReprocessWindow(lastModified) {
+			log.Info(logger, "Reprocessing unchanged opportunity because the existing object is stale",
+				"remote_last_modified", lastModified, "reprocess_if_older_than", env.ReprocessIfOlderThan)
+			sendMetric("opportunity.reprocessed", 1)
+		} else if unchanged {
+			log.Info(logger, "Skipping upload of unchanged opportunity")
+			sendMetric("opportunity.unchanged", 1)
+			outcome.Unchanged = true
+			return outcome, nil
+		}
 	}
 
 	log.Info(logger, "Uploading opportunity")
 
 	// Upload the object
 	if err := UploadS3Object(ctx, svc, env.DestinationBucket, key, bytes.NewReader(b)); err != nil {
-		return log.Errorf(logger, "Error uploading prepared opportunity to S3", err)
+		return outcome, log.Errorf(logger, "Error uploading prepared opportunity to S3", err)
 	}
 
 	log.Info(logger, "Successfully uploaded opportunity")
 
 	sendMetric("opportunity.created", 1)
 
+	return outcome, nil
+}
+
+// isOpportunityUnchanged returns true when the existing S3 object at key is identical to b,
+// along with the time at which the existing object was last modified.
+// Returns false when no object exists at key.
+func isOpportunityUnchanged(ctx context.Context, svc S3GetObjectAPI, key string, b []byte) (bool, time.Time, error) {
+	resp, err := svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(env.DestinationBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return false, time.Time{}, nil
+		}
+		return false, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	existing, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return bytes.Equal(existing, b), aws.ToTime(resp.LastModified), nil
+}
+
+// exceedsReprocessWindow returns true when env.ReprocessIfOlderThan is set and the given
+// last-modified time of a stored object is older than that duration.
+func exceedsReprocessWindow(lastModified time.Time) bool {
+	return env.ReprocessIfOlderThan > 0 && time.Since(lastModified) > env.ReprocessIfOlderThan
+}
+
+// compareOpportunity compares newly-derived opportunity JSON against the existing S3 object
+// at key, without modifying the object. The number of top-level fields that differ is emitted
+// as the parse.diff metric, and the names of those fields are logged. When no object exists
+// at key, every field of the new JSON is considered to differ.
+func compareOpportunity(ctx context.Context, svc S3GetObjectAPI, key string, b []byte, logger log.Logger) error {
+	existing := []byte("{}")
+	resp, err := svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(env.DestinationBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if !errors.As(err, &nsk) {
+			return log.Errorf(logger, "Error getting existing opportunity from S3", err)
+		}
+		log.Warn(logger, "No existing opportunity found for shadow comparison")
+	} else {
+		defer resp.Body.Close()
+		if existing, err = io.ReadAll(resp.Body); err != nil {
+			return log.Errorf(logger, "Error reading existing opportunity from S3", err)
+		}
+	}
+
+	diffs, err := jsonHelpers.DiffFields(existing, b)
+	if err != nil {
+		return log.Errorf(logger, "Error comparing opportunity JSON", err)
+	}
+	sendMetric("parse.diff", float64(len(diffs)))
+	if len(diffs) > 0 {
+		log.Warn(logger, "Shadow output differs from existing opportunity",
+			"count_diff_fields", len(diffs), "diff_fields", strings.Join(diffs, ","))
+	} else {
+		log.Info(logger, "Shadow output matches existing opportunity")
+	}
 	return nil
 }
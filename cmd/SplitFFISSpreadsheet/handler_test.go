@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,7 +28,13 @@ import (
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/jsonschema"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestOpportunityS3ObjectKey(t *testing.T) {
@@ -101,13 +109,14 @@ func TestLambaInvocation(t *testing.T) {
 	assert.NoError(t, err, "Error opening spreadsheet fixture")
 
 	expectedOpp := ffis.FFISFundingOpportunity{
-		CFDA:             "81.086",
-		OppTitle:         "Example Opportunity 1",
-		Agency:           "Office of Energy Efficiency and Renewable Energy",
-		EstimatedFunding: 5000000,
-		ExpectedAwards:   "N/A",
-		OppNumber:        "ABC-0003065",
-		GrantID:          123456,
+		CFDA:               "81.086",
+		AssistanceListings: []string{"81.086"},
+		OppTitle:           "Example Opportunity 1",
+		Agency:             "Office of Energy Efficiency and Renewable Energy",
+		EstimatedFunding:   5000000,
+		ExpectedAwards:     "N/A",
+		OppNumber:          "ABC-0003065",
+		GrantID:            123456,
 		Eligibility: ffis.FFISFundingEligibility{
 			State:           false,
 			Local:           false,
@@ -155,6 +164,28 @@ func TestLambaInvocation(t *testing.T) {
 		var savedOpportunity ffis.FFISFundingOpportunity
 		assert.NoError(t, json.Unmarshal(b, &savedOpportunity))
 		assert.Equal(t, expectedOpp, savedOpportunity)
+
+		manifest := getSplitManifest(t, s3client, now.Format("2006-01-02"))
+		assert.Equal(t, sourceBucketName, manifest.SourceBucket)
+		assert.Equal(t, objectKey, manifest.SourceKey)
+		assert.Len(t, manifest.SourceSHA256, 64)
+		assert.Empty(t, manifest.Error)
+		assert.Equal(t, map[string]int{"DOE": 2, "USDA": 2}, manifest.Agencies)
+		assert.Empty(t, manifest.Failed)
+		assert.Empty(t, manifest.Unchanged)
+		require.Len(t, manifest.Written, 4)
+		writtenRows := []int{}
+		for _, entry := range manifest.Written {
+			writtenRows = append(writtenRows, entry.Row)
+			if entry.GrantID == expectedOpp.GrantID {
+				assert.Equal(t, key, entry.Key)
+				assert.Equal(t, 10, entry.Row)
+				sum := sha256.Sum256(b)
+				assert.Equal(t, hex.EncodeToString(sum[:]), entry.SHA256)
+			}
+		}
+		assert.ElementsMatch(t, []int{10, 13, 14, 17}, writtenRows)
+		assert.False(t, manifest.CompletedAt.Before(manifest.StartedAt))
 	})
 
 	t.Run("invalid excel file", func(t *testing.T) {
@@ -186,6 +217,50 @@ func TestLambaInvocation(t *testing.T) {
 		} else {
 			require.Fail(t, "Invocation error could not be interpreted as *multierror.Error")
 		}
+		manifest := getSplitManifest(t, s3client, "2023-05-15")
+		assert.Equal(t, "sources/2023/05/15/ffis.org/download.xlsx", manifest.SourceKey)
+		assert.NotEmpty(t, manifest.Error, "Manifest should be written when parsing fails")
+		assert.Empty(t, manifest.Written)
+	})
+
+	t.Run("traced with OpenTelemetry", func(t *testing.T) {
+		setupLambdaEnvForTesting(t)
+		recorder := tracetest.NewSpanRecorder()
+		tracing.SetProvider(tracing.NewOpenTelemetryProvider(
+			sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+		t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+
+		sourceBucketName := "test-source-bucket"
+		s3client, cfg, err := setupS3ForTesting(t, sourceBucketName)
+		require.NoError(t, err)
+		_, err = s3client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(sourceBucketName),
+			Key:    aws.String("sources/2023/05/15/ffis.org/download.xlsx"),
+			Body:   bytes.NewReader([]byte("foobar")),
+		})
+		require.NoError(t, err)
+
+		err = handleS3EventWithConfig(cfg, context.TODO(), events.S3Event{
+			Records: []events.S3EventRecord{
+				{S3: events.S3Entity{
+					Bucket: events.S3Bucket{Name: sourceBucketName},
+					Object: events.S3Object{Key: "sources/2023/05/15/ffis.org/download.xlsx"},
+				}},
+			},
+		})
+		require.Error(t, err)
+
+		spansByName := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range recorder.Ended() {
+			spansByName[span.Name()] = span
+		}
+		require.Contains(t, spansByName, "processing")
+		require.Contains(t, spansByName, "handle.records")
+		require.Contains(t, spansByName, "handle.record")
+		recordSpan := spansByName["handle.record"]
+		assert.Equal(t, spansByName["handle.records"].SpanContext().SpanID(), recordSpan.Parent().SpanID())
+		assert.Equal(t, codes.Error, recordSpan.Status().Code)
+		assert.Equal(t, codes.Unset, spansByName["handle.records"].Status().Code)
 	})
 
 	t.Run("Context canceled during invocation", func(t *testing.T) {
@@ -214,3 +289,192 @@ func TestLambaInvocation(t *testing.T) {
 		}
 	})
 }
+
+func getSplitManifest(t *testing.T, s3client *s3.Client, edition string) ffis.SplitManifest {
+	t.Helper()
+	resp, err := s3client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(env.DestinationBucket),
+		Key:    aws.String(ffis.SplitManifestKey(edition)),
+	})
+	require.NoError(t, err, "Error getting split manifest")
+	defer resp.Body.Close()
+	var manifest ffis.SplitManifest
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	return manifest
+}
+
+func TestLambdaInvocationFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureSplit: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	sourceBucketName := "test-source-bucket"
+	s3client, cfg, err := setupS3ForTesting(t, sourceBucketName)
+	require.NoError(t, err, "Error configuring test environment")
+	excelFixture, err := os.Open("fixtures/example_spreadsheet.xlsx")
+	require.NoError(t, err, "Error opening spreadsheet fixture")
+	defer excelFixture.Close()
+	objectKey := fmt.Sprintf("sources/%s/ffis.org/download.xlsx", time.Now().Format("2006/01/02"))
+	_, err = s3client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(sourceBucketName),
+		Key:    aws.String(objectKey),
+		Body:   excelFixture,
+	})
+	require.NoError(t, err, "Error uploading test fixture")
+
+	err = handleS3EventWithConfig(cfg, context.TODO(), events.S3Event{
+		Records: []events.S3EventRecord{{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: sourceBucketName},
+			Object: events.S3Object{Key: objectKey},
+		}}},
+	})
+	require.NoError(t, err)
+
+	resp, err := s3client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(env.DestinationBucket),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Contents, "nothing should be written while disabled")
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
+func TestProcessOpportunitySkipUnchanged(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	s3client, _, err := setupS3ForTesting(t, "test-source-bucket")
+	require.NoError(t, err)
+	env.SkipUnchanged = true
+	t.Cleanup(func() { env.SkipUnchanged = false })
+
+	opp := opportunity{GrantID: 123456, OppTitle: "Example Opportunity 1", CFDA: "81.086"}
+	outcome, err := processOpportunity(context.TODO(), s3client, opp)
+	require.NoError(t, err)
+	assert.False(t, outcome.Unchanged, "New opportunity should be written")
+	assert.Len(t, outcome.SHA256, 64)
+
+	unchangedOutcome, err := processOpportunity(context.TODO(), s3client, opp)
+	require.NoError(t, err)
+	assert.True(t, unchangedOutcome.Unchanged, "Identical opportunity should be skipped")
+	assert.Equal(t, outcome.SHA256, unchangedOutcome.SHA256)
+
+	changed := opp
+	changed.OppTitle = "Example Opportunity 1 (Amended)"
+	changedOutcome, err := processOpportunity(context.TODO(), s3client, changed)
+	require.NoError(t, err)
+	assert.False(t, changedOutcome.Unchanged, "Changed opportunity should be written")
+	assert.NotEqual(t, outcome.SHA256, changedOutcome.SHA256)
+
+	t.Run("unchanged opportunity within reprocess window is skipped", func(t *testing.T) {
+		env.ReprocessIfOlderThan = time.Hour
+		t.Cleanup(func() { env.ReprocessIfOlderThan = 0 })
+		outcome, err := processOpportunity(context.TODO(), s3client, changed)
+		require.NoError(t, err)
+		assert.True(t, outcome.Unchanged)
+	})
+
+	t.Run("unchanged opportunity beyond reprocess window is reprocessed", func(t *testing.T) {
+		env.ReprocessIfOlderThan = time.Nanosecond
+		t.Cleanup(func() { env.ReprocessIfOlderThan = 0 })
+		time.Sleep(time.Millisecond)
+		outcome, err := processOpportunity(context.TODO(), s3client, changed)
+		require.NoError(t, err)
+		assert.False(t, outcome.Unchanged)
+	})
+}
+
+func TestProcessOpportunityShadowMode(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	s3client, _, err := setupS3ForTesting(t, "test-source-bucket")
+	require.NoError(t, err)
+
+	sentMetrics := make(map[string][]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		sentMetrics[metric] = append(sentMetrics[metric], value)
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	opp := opportunity{GrantID: 123456, OppTitle: "Example Opportunity 1", CFDA: "81.086"}
+	_, err = processOpportunity(context.TODO(), s3client, opp)
+	require.NoError(t, err)
+	getStoredOpportunity := func(t *testing.T) []byte {
+		t.Helper()
+		resp, err := s3client.GetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    aws.String(opp.S3ObjectKey()),
+		})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return b
+	}
+	stored := getStoredOpportunity(t)
+
+	env.ShadowMode = true
+	t.Cleanup(func() { env.ShadowMode = false })
+
+	t.Run("matching output emits zero diff", func(t *testing.T) {
+		sentMetrics["parse.diff"] = nil
+		_, err := processOpportunity(context.TODO(), s3client, opp)
+		require.NoError(t, err)
+		assert.Equal(t, []float64{0}, sentMetrics["parse.diff"])
+		assert.Equal(t, stored, getStoredOpportunity(t))
+	})
+
+	t.Run("changed output emits diff without writing", func(t *testing.T) {
+		sentMetrics["parse.diff"] = nil
+		changed := opp
+		changed.OppTitle = "Example Opportunity 1 (Amended)"
+		changed.CFDA = "81.087"
+		_, err := processOpportunity(context.TODO(), s3client, changed)
+		require.NoError(t, err)
+		assert.Equal(t, []float64{2}, sentMetrics["parse.diff"])
+		assert.Equal(t, stored, getStoredOpportunity(t),
+			"Existing opportunity should not be overwritten in shadow mode")
+	})
+
+	t.Run("missing existing output", func(t *testing.T) {
+		sentMetrics["parse.diff"] = nil
+		other := opportunity{GrantID: 654321, OppTitle: "Example Opportunity 2"}
+		_, err := processOpportunity(context.TODO(), s3client, other)
+		require.NoError(t, err)
+		require.Len(t, sentMetrics["parse.diff"], 1)
+		assert.Greater(t, sentMetrics["parse.diff"][0], float64(0))
+		_, err = s3client.GetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    aws.String(other.S3ObjectKey()),
+		})
+		assert.Error(t, err, "Opportunity should not be written in shadow mode")
+	})
+}
+
+func TestProcessOpportunitySchemaViolation(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	s3client, _, err := setupS3ForTesting(t, "test-source-bucket")
+	require.NoError(t, err)
+
+	sentMetrics := make(map[string][]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		sentMetrics[metric] = append(sentMetrics[metric], value)
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	opp := opportunity{GrantID: 123456, OppTitle: "Example Opportunity 1",
+		AssistanceListings: []string{"81.086", "81.08"}}
+	_, err = processOpportunity(context.TODO(), s3client, opp)
+	var violation *jsonschema.ValidationError
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, "#/assistance_listings/1", violation.Path)
+	assert.Contains(t, err.Error(), "#/assistance_listings/1", "Error reported in the manifest should include the path")
+	assert.Equal(t, []float64{1}, sentMetrics["opportunity.invalid"])
+	_, err = s3client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(env.DestinationBucket),
+		Key:    aws.String(opp.S3ObjectKey()),
+	})
+	assert.Error(t, err, "Invalid opportunity should not be written")
+}
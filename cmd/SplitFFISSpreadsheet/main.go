@@ -2,11 +2,16 @@
 // FFIS excel file from the source S3 bucket and uploads the parsed opportunities to
 // as individual JSON files to the destination S3 bucket. If a row of the spreadsheet
 // is not able to be parsed, the error is logged at WARN level and the row is skipped.
+// When SHADOW_MODE is enabled, parsed opportunities are compared against the JSON files
+// already stored in the destination bucket instead of being uploaded.
+// After each spreadsheet is split, a manifest summarizing the opportunities that were written,
+// skipped as unchanged, or failed is uploaded to the destination bucket.
 package main
 
 import (
 	"context"
 	"fmt"
+	"time"
 
 	goLog "log"
 
@@ -14,25 +19,37 @@ import (
 	goenv "github.com/Netflix/go-env"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/ddHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go-v2/aws"
 )
 
 type Environment struct {
-	LogLevel             string `env:"LOG_LEVEL,default=INFO"`
-	DownloadChunkLimit   int64  `env:"DOWNLOAD_CHUNK_LIMIT,default=10"`
-	DestinationBucket    string `env:"GRANTS_PREPARED_DATA_BUCKET_NAME,required=true"`
-	MaxConcurrentUploads int    `env:"MAX_CONCURRENT_UPLOADS,default=1"`
-	UsePathStyleS3Opt    bool   `env:"S3_USE_PATH_STYLE,default=false"`
+	LogLevel             string                  `env:"LOG_LEVEL,default=INFO"`
+	DownloadChunkLimit   int64                   `env:"DOWNLOAD_CHUNK_LIMIT,default=10"`
+	DestinationBucket    string                  `env:"GRANTS_PREPARED_DATA_BUCKET_NAME,required=true"`
+	MaxConcurrentUploads int                     `env:"MAX_CONCURRENT_UPLOADS,default=1"`
+	UsePathStyleS3Opt    bool                    `env:"S3_USE_PATH_STYLE,default=false"`
+	TracingProvider      string                  `env:"TRACING_PROVIDER,default=datadog"`
+	S3ChecksumAlgorithm  types.ChecksumAlgorithm `env:"S3_CHECKSUM_ALGORITHM,default=SHA256"`
+	ShadowMode           bool                    `env:"SHADOW_MODE,default=false"`
+	SkipUnchanged        bool                    `env:"SKIP_UNCHANGED_OPPORTUNITIES,default=false"`
+	ReprocessIfOlderThan time.Duration           `env:"REPROCESS_IF_OLDER_THAN,default=0s"`
+	DueDateLayouts       string                  `env:"DUE_DATE_LAYOUTS"`
+	DisabledFeatures     string                  `env:"DISABLED_FEATURES"`
 	Extras               goenv.EnvSet
 }
 
 var (
-	env        Environment
-	logger     log.Logger
-	sendMetric = ddHelpers.NewMetricSender("SplitFFISSpreadsheet", "source:ffis.org")
+	env              Environment
+	logger           log.Logger
+	disabledFeatures configHelpers.FeatureSet
+	sendMetric       = ddHelpers.NewMetricSender("SplitFFISSpreadsheet", "source:ffis.org")
+	dueDateLayouts   []string
 )
 
 func main() {
@@ -43,9 +60,26 @@ func main() {
 	}
 	env.Extras = es
 	log.ConfigureLogger(&logger, env.LogLevel)
+	disabledFeatures, err = configHelpers.ParseDisabledFeatures(env.DisabledFeatures)
+	if err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	if err := awsHelpers.ValidateChecksumAlgorithm(env.S3ChecksumAlgorithm); err != nil {
+		goLog.Fatalf("error configuring environment variables: %v", err)
+	}
+	dueDateLayouts = parseDueDateLayouts(env.DueDateLayouts)
+	if err := ddHelpers.ConfigureMetricDestination(); err != nil {
+		goLog.Fatalf("error configuring metrics destination: %v", err)
+	}
+	if err := tracing.Configure(context.Background(), env.TracingProvider); err != nil {
+		goLog.Fatalf("error configuring tracing: %v", err)
+	}
 
 	log.Debug(logger, "Starting Lambda")
 	lambda.Start(ddlambda.WrapFunction(func(ctx context.Context, s3Event events.S3Event) error {
+		defer ddHelpers.FlushMetricsAndLog(logger)
+		defer ddHelpers.StartInvocation(sendMetric)()
+		defer tracing.Flush(ctx)
 		cfg, err := awsHelpers.GetConfig(ctx)
 		if err != nil {
 			return fmt.Errorf("could not create AWS SDK config: %w", err)
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+// Matches the date path of a source spreadsheet key, e.g. sources/2023/05/15/ffis.org/download.xlsx
+var sourceKeyDateRegex = regexp.MustCompile(`(?:^|/)(\d{4})/(\d{2})/(\d{2})/ffis\.org/`)
+
+// manifestEdition identifies the spreadsheet at sourceKey for the purpose of naming its manifest.
+// This is the date given by the key's date path (e.g. 2023-05-15) or, when the key has no date path,
+// the date of startedAt.
+func manifestEdition(sourceKey string, startedAt time.Time) string {
+	if m := sourceKeyDateRegex.FindStringSubmatch(sourceKey); m != nil {
+		return fmt.Sprintf("%s-%s-%s", m[1], m[2], m[3])
+	}
+	return startedAt.UTC().Format("2006-01-02")
+}
+
+// manifestRecorder accumulates the manifest of a single spreadsheet split run.
+// It is safe for concurrent use by multiple upload workers.
+type manifestRecorder struct {
+	mu       sync.Mutex
+	manifest ffis.SplitManifest
+}
+
+func newManifestRecorder(sourceBucket, sourceKey string, startedAt time.Time) *manifestRecorder {
+	return &manifestRecorder{manifest: ffis.SplitManifest{
+		SourceBucket: sourceBucket,
+		SourceKey:    sourceKey,
+		StartedAt:    startedAt,
+		Written:      []ffis.SplitManifestEntry{},
+		Unchanged:    []ffis.SplitManifestEntry{},
+		Failed:       []ffis.SplitManifestEntry{},
+	}}
+}
+
+// recordParsed records the outcome of parsing the spreadsheet, whose contents have the given
+// hex-encoded SHA-256 digest.
+func (r *manifestRecorder) recordParsed(parsed parsedSpreadsheet, sourceSHA256 string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest.SourceSHA256 = sourceSHA256
+	if err != nil {
+		r.manifest.Error = err.Error()
+	}
+	r.manifest.Failed = append(r.manifest.Failed, parsed.Rejected...)
+}
+
+// recordAgencies records the number of parsed opportunities for each normalized agency.
+func (r *manifestRecorder) recordAgencies(counts map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest.Agencies = counts
+}
+
+// recordOpportunity records the outcome of processing the opportunity parsed from the given row.
+func (r *manifestRecorder) recordOpportunity(row int, opp opportunity, outcome opportunityOutcome, err error) {
+	entry := ffis.SplitManifestEntry{
+		Row:     row,
+		GrantID: opp.GrantID,
+		Key:     opp.S3ObjectKey(),
+		SHA256:  outcome.SHA256,
+		Status:  opp.Status,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err != nil:
+		entry.Reason = err.Error()
+		r.manifest.Failed = append(r.manifest.Failed, entry)
+	case outcome.Unchanged:
+		r.manifest.Unchanged = append(r.manifest.Unchanged, entry)
+	default:
+		r.manifest.Written = append(r.manifest.Written, entry)
+	}
+}
+
+// complete marks the run as completed at the given time and returns the resulting manifest.
+func (r *manifestRecorder) complete(completedAt time.Time) ffis.SplitManifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest.CompletedAt = completedAt
+	return r.manifest
+}
+
+// writeManifest uploads the manifest to env.DestinationBucket at the key named for the
+// spreadsheet's edition.
+func writeManifest(ctx context.Context, svc S3PutObjectAPI, manifest ffis.SplitManifest) error {
+	key := ffis.SplitManifestKey(manifestEdition(manifest.SourceKey, manifest.StartedAt))
+	logger := log.With(logger, "source_object_key", manifest.SourceKey,
+		"bucket", env.DestinationBucket, "key", key,
+		"count_written", len(manifest.Written),
+		"count_unchanged", len(manifest.Unchanged),
+		"count_failed", len(manifest.Failed))
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return log.Errorf(logger, "Error marshaling JSON for split manifest", err)
+	}
+	if err := UploadS3Object(ctx, svc, env.DestinationBucket, key, bytes.NewReader(b)); err != nil {
+		sendMetric("manifest.failed", 1)
+		return log.Errorf(logger, "Error uploading split manifest to S3", err)
+	}
+	log.Info(logger, "Uploaded split manifest")
+	sendMetric("manifest.created", 1)
+	return nil
+}
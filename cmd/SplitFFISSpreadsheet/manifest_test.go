@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+func TestManifestEdition(t *testing.T) {
+	startedAt := time.Date(2023, 5, 16, 1, 2, 3, 0, time.UTC)
+	for _, tt := range []struct {
+		sourceKey string
+		expected  string
+	}{
+		{"sources/2023/05/15/ffis.org/download.xlsx", "2023-05-15"},
+		{"sources/2023/05/15/ffis.org/download.csv", "2023-05-15"},
+		{"2023/05/15/ffis.org/download.xlsx", "2023-05-15"},
+		{"sources/2023/05/ffis.org/download.xlsx", "2023-05-16"},
+		{"does/not/matter", "2023-05-16"},
+	} {
+		t.Run(tt.sourceKey, func(t *testing.T) {
+			assert.Equal(t, tt.expected, manifestEdition(tt.sourceKey, startedAt))
+		})
+	}
+}
+
+func TestManifestRecorder(t *testing.T) {
+	startedAt := time.Date(2023, 5, 15, 12, 0, 0, 0, time.UTC)
+	recorder := newManifestRecorder("source-bucket", "sources/2023/05/15/ffis.org/download.xlsx", startedAt)
+	recorder.recordParsed(parsedSpreadsheet{
+		Rejected: []ffis.SplitManifestEntry{{Row: 12, Reason: "missing grant ID"}},
+	}, "abcdef", nil)
+	recorder.recordOpportunity(10, opportunity{GrantID: 123456, Status: ffis.OpportunityStatusNew},
+		opportunityOutcome{SHA256: "aaa"}, nil)
+	recorder.recordOpportunity(13, opportunity{GrantID: 512512},
+		opportunityOutcome{SHA256: "bbb", Unchanged: true}, nil)
+	recorder.recordOpportunity(14, opportunity{GrantID: 215125},
+		opportunityOutcome{SHA256: "ccc"}, errors.New("upload failed"))
+
+	completedAt := startedAt.Add(3 * time.Second)
+	assert.Equal(t, ffis.SplitManifest{
+		SourceBucket: "source-bucket",
+		SourceKey:    "sources/2023/05/15/ffis.org/download.xlsx",
+		SourceSHA256: "abcdef",
+		StartedAt:    startedAt,
+		CompletedAt:  completedAt,
+		Agencies:     map[string]int{"DOT": 2, "other": 1},
+		Written: []ffis.SplitManifestEntry{
+			{Row: 10, GrantID: 123456, Key: "123/123456/ffis.org/v1.json", SHA256: "aaa", Status: "new"},
+		},
+		Unchanged: []ffis.SplitManifestEntry{
+			{Row: 13, GrantID: 512512, Key: "512/512512/ffis.org/v1.json", SHA256: "bbb"},
+		},
+		Failed: []ffis.SplitManifestEntry{
+			{Row: 12, Reason: "missing grant ID"},
+			{Row: 14, GrantID: 215125, Key: "215/215125/ffis.org/v1.json", SHA256: "ccc", Reason: "upload failed"},
+		},
+	}, recorder.complete(completedAt))
+}
+
+func TestManifestRecorderParseError(t *testing.T) {
+	recorder := newManifestRecorder("source-bucket", "sources/2023/05/15/ffis.org/download.xlsx", time.Now())
+	recorder.recordParsed(parsedSpreadsheet{}, "abcdef", errors.New("zip: not a valid zip file"))
+	manifest := recorder.complete(time.Now())
+	assert.Equal(t, "zip: not a valid zip file", manifest.Error)
+	assert.Equal(t, []ffis.SplitManifestEntry{}, manifest.Written)
+	assert.Equal(t, []ffis.SplitManifestEntry{}, manifest.Failed)
+}
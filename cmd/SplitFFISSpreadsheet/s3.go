@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
 )
 
 // S3PutObjectAPI is the interface for writing new or replacement objects in an S3 bucket
@@ -15,15 +16,31 @@ type S3PutObjectAPI interface {
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 }
 
+// S3GetObjectAPI is the interface for retrieving objects from an S3 bucket
+type S3GetObjectAPI interface {
+	// GetObject retrieves an object from S3
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3ReadWriteObjectAPI is the interface for both retrieving and writing objects in an S3 bucket
+type S3ReadWriteObjectAPI interface {
+	S3GetObjectAPI
+	S3PutObjectAPI
+}
+
 // UploadS3Object uploads bytes read from from r to an S3 object at the given bucket and key.
-// If an error was encountered during upload, returns the error.
+// The uploaded content is verified by S3 using a checksum computed with env.S3ChecksumAlgorithm.
+// If an error was encountered during upload, returns the error, which wraps
+// awsHelpers.ErrS3ChecksumMismatch when the content was corrupted in transit.
 // Returns nil when the upload was successful.
 func UploadS3Object(ctx context.Context, c S3PutObjectAPI, bucket, key string, r io.Reader) error {
 	_, err := c.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:               aws.String(bucket),
 		Key:                  aws.String(key),
 		Body:                 r,
+		Metadata:             awsHelpers.WithProcessorVersion(awsHelpers.WithLambdaRequestID(ctx, nil)),
 		ServerSideEncryption: types.ServerSideEncryptionAes256,
+		ChecksumAlgorithm:    env.S3ChecksumAlgorithm,
 	})
-	return err
+	return awsHelpers.WrapS3ChecksumError(err)
 }
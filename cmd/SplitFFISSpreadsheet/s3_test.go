@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
 )
 
 type mockPutObjectAPI func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
@@ -23,6 +26,7 @@ func TestUploadS3Object(t *testing.T) {
 	testObjectKey := "test/key"
 	testReader := bytes.NewReader([]byte("hello!"))
 	testError := fmt.Errorf("oh no this is an error")
+	env.S3ChecksumAlgorithm = types.ChecksumAlgorithmCrc32c
 
 	for _, tt := range []struct {
 		name   string
@@ -38,6 +42,7 @@ func TestUploadS3Object(t *testing.T) {
 					assert.Equal(t, aws.String(testObjectKey), params.Key)
 					assert.Equal(t, testReader, params.Body)
 					assert.Equal(t, params.ServerSideEncryption, types.ServerSideEncryptionAes256)
+					assert.Equal(t, types.ChecksumAlgorithmCrc32c, params.ChecksumAlgorithm)
 					return &s3.PutObjectOutput{}, nil
 				})
 			},
@@ -55,13 +60,42 @@ func TestUploadS3Object(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"PutObject rejects corrupted content",
+			func(t *testing.T) S3PutObjectAPI {
+				return mockPutObjectAPI(func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					return nil, &smithy.GenericAPIError{Code: "BadDigest"}
+				})
+			},
+			awsHelpers.ErrS3ChecksumMismatch,
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			err := UploadS3Object(context.TODO(), tt.client(t),
 				testBucketName, testObjectKey, testReader)
 			if tt.expErr != nil {
-				assert.EqualError(t, err, tt.expErr.Error())
+				assert.ErrorIs(t, err, tt.expErr)
 			}
 		})
 	}
 }
+
+func TestUploadS3ObjectLambdaRequestID(t *testing.T) {
+	var metadata map[string]string
+	client := mockPutObjectAPI(func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+		metadata = params.Metadata
+		return &s3.PutObjectOutput{}, nil
+	})
+
+	t.Run("with Lambda context", func(t *testing.T) {
+		ctx := lambdacontext.NewContext(context.TODO(),
+			&lambdacontext.LambdaContext{AwsRequestID: "abc-123"})
+		assert.NoError(t, UploadS3Object(ctx, client, "test-bucket", "test/key", bytes.NewReader([]byte("hello!"))))
+		assert.Equal(t, map[string]string{"lambda-request-id": "abc-123"}, metadata)
+	})
+
+	t.Run("without Lambda context", func(t *testing.T) {
+		assert.NoError(t, UploadS3Object(context.TODO(), client, "test-bucket", "test/key", bytes.NewReader([]byte("hello!"))))
+		assert.Empty(t, metadata)
+	})
+}
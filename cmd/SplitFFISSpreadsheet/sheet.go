@@ -7,13 +7,83 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/spreadsheetHelpers"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 	"github.com/xuri/excelize/v2"
 )
 
+var (
+	// Used to test if a value is a CFDA number. Apparently
+	// this is a consistent CFDA format based on this page:
+	// https://grantsgovprod.wordpress.com/2018/06/04/what-is-a-cfda-number-2/
+	// Sometimes, FFIS appends a + sign character to this cell to indicate
+	// that there are additional CFDA numbers not included in the spreadsheet.
+	cfdaRegex = regexp.MustCompile(`^([0-9]{1,2}\.[0-9]{0,3})\+?$`)
+
+	// Matches a normalized Assistance Listing (formerly CFDA) number, i.e. NN.NNN
+	assistanceListingRegex = regexp.MustCompile(`^[0-9]{2}\.[0-9]{3}$`)
+
+	// Separates multiple CFDA numbers in a single cell
+	cfdaSeparatorRegex = regexp.MustCompile(`[,;\s]+`)
+)
+
+// parseDueDateLayouts parses the semicolon-separated time layouts configured by DUE_DATE_LAYOUTS,
+// which are recognized in due date cells in addition to spreadsheetHelpers.DateLayouts.
+// Semicolons are used because commas appear in layouts such as "January 2, 2006".
+func parseDueDateLayouts(s string) []string {
+	layouts := []string{}
+	for _, layout := range strings.Split(s, ";") {
+		if layout = strings.TrimSpace(layout); layout != "" {
+			layouts = append(layouts, layout)
+		}
+	}
+	return layouts
+}
+
+// splitCFDACell splits the value of a CFDA cell, which may contain multiple CFDA numbers,
+// into its individual (non-empty) values.
+func splitCFDACell(cell string) []string {
+	values := []string{}
+	for _, v := range cfdaSeparatorRegex.Split(strings.TrimSpace(cell), -1) {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseAssistanceListings extracts the Assistance Listing (formerly CFDA) numbers from a CFDA cell.
+// Each number is normalized to the NN.NNN format, since Excel may treat a CFDA number
+// ending in 0 as a number rather than as a string (e.g. 10.720 becomes 10.72).
+// Returns the valid numbers in the order that they appear (without duplicates), along with
+// any malformed values that could not be parsed as Assistance Listing numbers.
+func parseAssistanceListings(cell string) (listings []string, malformed []string) {
+	seen := make(map[string]bool)
+	for _, value := range splitCFDACell(cell) {
+		if !cfdaRegex.MatchString(value) {
+			malformed = append(malformed, value)
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimRight(value, "+"), 64)
+		if err != nil {
+			malformed = append(malformed, value)
+			continue
+		}
+		listing := fmt.Sprintf("%06.3f", f)
+		if !assistanceListingRegex.MatchString(listing) {
+			malformed = append(malformed, value)
+			continue
+		}
+		if !seen[listing] {
+			seen[listing] = true
+			listings = append(listings, listing)
+		}
+	}
+	return listings, malformed
+}
+
 // Currently, the FFIS spreadsheet uses an "X" to indicate eligibility
 func parseEligibility(value string) bool {
 	return value == "X"
@@ -31,23 +101,13 @@ func parseEligibility(value string) bool {
 // logger: The log.Logger used to log any parsing errors at the WARN level.
 //
 // Returns:
-// A slice of ffis.FFISFundingOpportunity objects representing the parsed funding opportunities from the Excel file.
+// A parsedSpreadsheet describing the parsed funding opportunities from the Excel file.
 // An error is returned if the parsing process fails at a level beyond individual cell parsing.
-func parseXLSXFile(r io.Reader, logger log.Logger) ([]ffis.FFISFundingOpportunity, error) {
+func parseXLSXFile(r io.Reader, logger log.Logger) (parsedSpreadsheet, error) {
 	xlFile, err := excelize.OpenReader(r)
 
 	if err != nil {
-		return nil, err
-	}
-
-	// Used to test if a cell is a CFDA number. Apparently
-	// this is a consistent CFDA format based on this page:
-	// https://grantsgovprod.wordpress.com/2018/06/04/what-is-a-cfda-number-2/
-	// Sometimes, FFIS appends a + sign character to this cell to indicate
-	// that there are additional CFDA numbers not included in the spreadsheet.
-	cfdaRegex, err := regexp.Compile(`^([0-9]{1,2}\.[0-9]{0,3})\+?$`)
-	if err != nil {
-		return nil, err
+		return parsedSpreadsheet{}, err
 	}
 
 	defer func() {
@@ -63,13 +123,42 @@ func parseXLSXFile(r io.Reader, logger log.Logger) ([]ffis.FFISFundingOpportunit
 	// size, and will not scale to extremely large worksheets (memory overhead)
 	rows, err := xlFile.GetRows(sheet)
 	if err != nil {
-		return nil, err
+		return parsedSpreadsheet{}, err
 	}
 
+	return parseRows(rows, func(rowIndex, colIndex int) (bool, string, error) {
+		// cellAxis (eg. A4) is used to get a hyperlink for a cell. We
+		// need to increment the index because Excel is not zero-indexed
+		cellAxis, err := excelize.CoordinatesToCellName(colIndex+1, rowIndex+1)
+		if err != nil {
+			return false, "", fmt.Errorf("error parsing cell axis: %w", err)
+		}
+		return xlFile.GetCellHyperLink(sheet, cellAxis)
+	}, logger), nil
+}
+
+// parsedSpreadsheet describes the funding opportunities parsed from an FFIS spreadsheet.
+type parsedSpreadsheet struct {
+	Opportunities []ffis.FFISFundingOpportunity
+	// The 1-indexed spreadsheet row number of each of Opportunities
+	RowNumbers []int
+	// Opportunity rows that were omitted from Opportunities because they have no valid grant ID
+	Rejected []ffis.SplitManifestEntry
+}
+
+// cellLinkFunc returns the link target (if any) of the cell at the given zero-indexed
+// row and column of a spreadsheet.
+type cellLinkFunc func(rowIndex, colIndex int) (hasLink bool, target string, err error)
+
+// parseRows converts the rows of an FFIS spreadsheet (regardless of its file format) into
+// funding opportunities, retaining only those with a valid grant ID. Grant IDs are parsed from
+// the link target of each opportunity's Opportunity Number cell, as provided by cellLink.
+// Errors parsing individual cells are logged at the WARN level rather than returned.
+func parseRows(rows [][]string, cellLink cellLinkFunc, logger log.Logger) parsedSpreadsheet {
 	sendMetric("spreadsheet.row_count", float64(len(rows)))
 	log.Info(logger, "Parsing spreadsheet", "total_rows", len(rows))
 
-	var opportunities []ffis.FFISFundingOpportunity
+	var parsed parsedSpreadsheet
 
 	// Tracks if the iterator has found headers for the sheet. A header
 	// is a column header, like "CFDA", "Opportunity Title", etc.
@@ -81,9 +170,14 @@ func parseXLSXFile(r io.Reader, logger log.Logger) ([]ffis.FFISFundingOpportunit
 	// "Inflation Reduction Act".
 	bill := ""
 
+	// The index of the optional column that marks each opportunity's status, if any
+	statusColIndex := -1
+
 rowLoop:
 	for rowIndex, row := range rows {
 		opportunity := ffis.FFISFundingOpportunity{}
+		isOpportunityRow := false
+		statusCell := ""
 
 		for colIndex, cell := range row {
 			logger := log.With(logger, "row_index", row, "column_index", colIndex)
@@ -93,6 +187,7 @@ rowLoop:
 			// the content follows
 			if colIndex == 0 && cell == "CFDA" {
 				foundHeaders = true
+				statusColIndex = findStatusColumn(row)
 				continue rowLoop
 			}
 
@@ -111,23 +206,33 @@ rowLoop:
 
 				// If we don't match a CFDA number and the row isn't blank, we
 				// assume it's a bill and continue
-				if !cfdaRegex.MatchString(cell) {
+				if values := splitCFDACell(cell); len(values) == 0 || !cfdaRegex.MatchString(values[0]) {
 					bill = cell
 					continue rowLoop
 				}
 			}
 
+			if colIndex == statusColIndex {
+				statusCell = cell
+				continue
+			}
+
 			// Populate opportunity based on an assumed format
 			// where colIndex is a column (zero is A, 1 is B, etc.)
 			switch colIndex {
 			case 0:
-				if f, err := strconv.ParseFloat(strings.TrimRight(cell, "+"), 64); err != nil {
-					log.Warn(logger, "Error parsing CFDA", err)
+				isOpportunityRow = true
+				// A single cell may list multiple CFDA numbers, e.g. "10.720, 10.727"
+				listings, malformed := parseAssistanceListings(cell)
+				for _, value := range malformed {
+					log.Warn(logger, "Error parsing CFDA", "raw_value", value)
 					sendMetric("spreadsheet.cell_parsing_errors", 1, "target:CFDA")
+				}
+				if len(listings) == 0 {
 					continue
-				} else {
-					opportunity.CFDA = fmt.Sprintf("%06.3f", f)
 				}
+				opportunity.CFDA = listings[0]
+				opportunity.AssistanceListings = listings
 			case 1:
 				opportunity.OppTitle = cell
 			case 2:
@@ -151,16 +256,7 @@ rowLoop:
 			case 5:
 				opportunity.OppNumber = cell
 
-				// cellAxis (eg. A4) is used to get a hyperlink for a cell. We
-				// need to increment the index because Excel is not zero-indexed
-				cellAxis, err := excelize.CoordinatesToCellName(colIndex+1, rowIndex+1)
-				if err != nil {
-					log.Warn(logger, "Error parsing cell axis for grant ID", "error", err)
-					sendMetric("spreadsheet.cell_parsing_errors", 1, "target:GrantID")
-					continue
-				}
-
-				hasLink, target, err := xlFile.GetCellHyperLink(sheet, cellAxis)
+				hasLink, target, err := cellLink(rowIndex, colIndex)
 				if err != nil {
 					// log this, it is not worth aborting the whole extraction for
 					log.Warn(logger, "Error getting cell hyperlink for grant ID", "error", err)
@@ -201,25 +297,23 @@ rowLoop:
 			case 11:
 				opportunity.Eligibility.Other = parseEligibility(cell)
 			case 12:
-				// If we fail to parse the date, just skip the column
-				// and not the whole row
-				dateParsed := false
-				dateLayouts := [...]string{"1-2-06", "1/2/06"}
-				for _, layout := range dateLayouts {
-					t, err := time.Parse(layout, cell)
-					if err == nil {
-						log.Debug(logger, "Parsed DueDate with layout", "layout", layout)
-						opportunity.DueDate = t
-						dateParsed = true
-						break
-					}
-				}
-				if !dateParsed {
-					log.Warn(logger, "Could not parse DueDate according to any attempted layouts",
-						"attempted_layouts", dateLayouts, "raw_value", cell)
+				// If we fail to parse the date, flag the raw value rather than
+				// skipping the whole row
+				dueDate, err := spreadsheetHelpers.NormalizeDateCell(cell, dueDateLayouts...)
+				if err != nil {
+					log.Warn(logger, "Could not parse DueDate",
+						"error", err, "raw_value", dueDate.Raw)
 					sendMetric("spreadsheet.cell_parsing_errors", 1, "target:DueDate")
+					opportunity.DueDateUnparsed = strings.TrimSpace(dueDate.Raw)
 					continue
 				}
+				log.Debug(logger, "Parsed DueDate", "kind", dueDate.Kind, "raw_value", dueDate.Raw)
+				switch dueDate.Kind {
+				case spreadsheetHelpers.DateKindDate:
+					opportunity.DueDate = dueDate.Date
+				case spreadsheetHelpers.DateKindRolling:
+					opportunity.RollingDueDate = true
+				}
 			case 13:
 				opportunity.Match = parseEligibility(cell)
 			}
@@ -228,11 +322,23 @@ rowLoop:
 			opportunity.Bill = bill
 		}
 
+		if isOpportunityRow {
+			parseOpportunityStatus(&opportunity, statusCell,
+				log.With(logger, "row_index", rowIndex, "column_index", statusColIndex))
+		}
+
 		// Only add valid opportunities
 		if opportunity.GrantID > 0 {
-			opportunities = append(opportunities, opportunity)
+			parsed.Opportunities = append(parsed.Opportunities, opportunity)
+			parsed.RowNumbers = append(parsed.RowNumbers, rowIndex+1)
+		} else if isOpportunityRow {
+			parsed.Rejected = append(parsed.Rejected, ffis.SplitManifestEntry{
+				Row:    rowIndex + 1,
+				Reason: "missing grant ID",
+				Status: opportunity.Status,
+			})
 		}
 	}
 
-	return opportunities, nil
+	return parsed
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
 )
 
@@ -17,7 +20,8 @@ func TestParseXLSXFile_good(t *testing.T) {
 	// Ignore logging in this test
 	logger = log.NewNopLogger()
 
-	opportunities, err := parseXLSXFile(excelFixture, logger)
+	parsed, err := parseXLSXFile(excelFixture, logger)
+	opportunities := parsed.Opportunities
 	assert.NoError(t, err)
 	assert.NotNil(t, opportunities)
 
@@ -26,13 +30,14 @@ func TestParseXLSXFile_good(t *testing.T) {
 
 	// Our first row in the fixture sheet should match this exactly
 	expectedOpportunity := ffis.FFISFundingOpportunity{
-		CFDA:             "81.086",
-		OppTitle:         "Example Opportunity 1",
-		Agency:           "Office of Energy Efficiency and Renewable Energy",
-		EstimatedFunding: 5000000,
-		ExpectedAwards:   "N/A",
-		OppNumber:        "ABC-0003065",
-		GrantID:          123456,
+		CFDA:               "81.086",
+		AssistanceListings: []string{"81.086"},
+		OppTitle:           "Example Opportunity 1",
+		Agency:             "Office of Energy Efficiency and Renewable Energy",
+		EstimatedFunding:   5000000,
+		ExpectedAwards:     "N/A",
+		OppNumber:          "ABC-0003065",
+		GrantID:            123456,
 		Eligibility: ffis.FFISFundingEligibility{
 			State:           false,
 			Local:           false,
@@ -49,6 +54,8 @@ func TestParseXLSXFile_good(t *testing.T) {
 	expectedOpportunity.DueDate = date
 
 	assert.Equal(t, expectedOpportunity, opportunities[0])
+	assert.Equal(t, []int{10, 13, 14, 17}, parsed.RowNumbers)
+	assert.Empty(t, parsed.Rejected)
 }
 
 func TestParseEligibility(t *testing.T) {
@@ -80,7 +87,8 @@ func TestParseXLSXFile_cfda_format_4_digit(t *testing.T) {
 	// Ignore logging in this test
 	logger = log.NewNopLogger()
 
-	opportunities, err := parseXLSXFile(excelFixture, logger)
+	parsed, err := parseXLSXFile(excelFixture, logger)
+	opportunities := parsed.Opportunities
 	assert.NoError(t, err)
 	assert.NotNil(t, opportunities)
 
@@ -113,7 +121,8 @@ func TestParseXLSXFile_cfda_format_trailing_plus_sign(t *testing.T) {
 	// Ignore logging in this test
 	logger = log.NewNopLogger()
 
-	opportunities, err := parseXLSXFile(excelFixture, logger)
+	parsed, err := parseXLSXFile(excelFixture, logger)
+	opportunities := parsed.Opportunities
 	assert.NoError(t, err)
 	assert.NotNil(t, opportunities)
 
@@ -133,3 +142,87 @@ func TestParseXLSXFile_cfda_format_trailing_plus_sign(t *testing.T) {
 		assert.Equal(t, expectedRow.expectedCFDA, opportunities[idx].CFDA)
 	}
 }
+
+func TestParseXLSXFile_multiple_cfda(t *testing.T) {
+	/*
+		This test is for a spreadsheet where CFDA cells may contain multiple (and sometimes malformed)
+		Assistance Listing numbers, separated by commas, semicolons, or whitespace.
+	*/
+	excelFixture, err := os.Open("fixtures/example_spreadsheet_multiple_cfda.xlsx")
+	assert.NoError(t, err, "Error opening spreadsheet fixture")
+
+	// Ignore logging in this test
+	logger = log.NewNopLogger()
+
+	parsed, err := parseXLSXFile(excelFixture, logger)
+	opportunities := parsed.Opportunities
+	assert.NoError(t, err)
+
+	// Fixture has 4 opportunities
+	assert.Len(t, opportunities, 4)
+
+	for idx, expectedRow := range []struct {
+		expectedCFDA     string
+		expectedListings []string
+	}{
+		{"10.720", []string{"10.720", "10.727"}},
+		{"81.253", []string{"81.253", "81.254"}},
+		{"66.046", []string{"66.046"}},
+		{"81.086", []string{"81.086"}},
+	} {
+		assert.Equal(t, "Inflation Reduction Act", opportunities[idx].Bill)
+		assert.Equal(t, expectedRow.expectedCFDA, opportunities[idx].CFDA)
+		assert.Equal(t, expectedRow.expectedListings, opportunities[idx].AssistanceListings)
+	}
+}
+
+func TestParseAssistanceListings(t *testing.T) {
+	for _, tt := range []struct {
+		cell              string
+		expectedListings  []string
+		expectedMalformed []string
+	}{
+		{"81.086", []string{"81.086"}, nil},
+		{"10.72", []string{"10.720"}, nil},
+		{"2.98", []string{"02.980"}, nil},
+		{"81.253+", []string{"81.253"}, nil},
+		{"10.720, 10.727", []string{"10.720", "10.727"}, nil},
+		{"10.720;10.727\n10.728", []string{"10.720", "10.727", "10.728"}, nil},
+		{"10.720, 10.720", []string{"10.720"}, nil},
+		{"66.046 66.0461 XY.123", []string{"66.046"}, []string{"66.0461", "XY.123"}},
+		{"123.456", nil, []string{"123.456"}},
+		{"", nil, nil},
+	} {
+		t.Run(tt.cell, func(t *testing.T) {
+			listings, malformed := parseAssistanceListings(tt.cell)
+			assert.Equal(t, tt.expectedListings, listings)
+			assert.Equal(t, tt.expectedMalformed, malformed)
+		})
+	}
+}
+
+func TestFixtureOpportunitiesConformToJSONSchema(t *testing.T) {
+	// Ignore logging in this test
+	logger = log.NewNopLogger()
+
+	fixtures, err := filepath.Glob("fixtures/example_spreadsheet*")
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+	for _, fixture := range fixtures {
+		t.Run(fixture, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			require.NoError(t, err, "Error opening spreadsheet fixture")
+			defer f.Close()
+
+			parsed, err := parseSpreadsheet(f, fixture, logger)
+			require.NoError(t, err)
+			require.NotEmpty(t, parsed.Opportunities)
+			for i, opp := range parsed.Opportunities {
+				b, err := json.Marshal(opportunity(opp))
+				require.NoError(t, err)
+				assert.NoError(t, ffis.ValidateOpportunityJSON(b),
+					"Opportunity from row %d does not conform to schema", parsed.RowNumbers[i])
+			}
+		})
+	}
+}
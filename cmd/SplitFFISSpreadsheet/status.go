@@ -0,0 +1,108 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+var (
+	// Headers (lower-cased) of the optional column in which FFIS marks each opportunity's status
+	statusHeaders = map[string]bool{
+		"status":             true,
+		"opportunity status": true,
+		"change":             true,
+	}
+
+	// Maps the (lower-cased) words with which FFIS marks an opportunity's status to the
+	// corresponding ffis.OpportunityStatus constant.
+	opportunityStatusWords = map[string]string{
+		"new":       ffis.OpportunityStatusNew,
+		"added":     ffis.OpportunityStatusNew,
+		"posted":    ffis.OpportunityStatusNew,
+		"modified":  ffis.OpportunityStatusModified,
+		"updated":   ffis.OpportunityStatusModified,
+		"revised":   ffis.OpportunityStatusModified,
+		"changed":   ffis.OpportunityStatusModified,
+		"deleted":   ffis.OpportunityStatusDeleted,
+		"removed":   ffis.OpportunityStatusDeleted,
+		"cancelled": ffis.OpportunityStatusDeleted,
+		"canceled":  ffis.OpportunityStatusDeleted,
+	}
+
+	// Matches a status marker that prefixes an opportunity title, e.g. "NEW: Title",
+	// "[Updated] Title", or "(Removed) Title"
+	titleStatusMarkerRegex = regexp.MustCompile(`^\s*(?:\[\s*([A-Za-z]+)\s*\]|\(\s*([A-Za-z]+)\s*\)|([A-Za-z]+)\s*:)\s*(\S.*)$`)
+)
+
+// normalizeOpportunityStatus maps the value of a status cell to an ffis.OpportunityStatus constant.
+// Returns an empty status when the cell is blank, and false when its value is not recognized.
+func normalizeOpportunityStatus(cell string) (string, bool) {
+	value := strings.ToLower(strings.TrimSpace(cell))
+	if value == "" {
+		return "", true
+	}
+	status, ok := opportunityStatusWords[value]
+	return status, ok
+}
+
+// parseTitleStatus extracts a recognized status marker from the beginning of an opportunity
+// title. Returns the normalized status (if any) along with the title without its marker.
+// Titles whose prefix is not a recognized status word (e.g. "Phase II: ...") are returned as-is.
+func parseTitleStatus(title string) (status string, remaining string) {
+	m := titleStatusMarkerRegex.FindStringSubmatch(title)
+	if m == nil {
+		return "", title
+	}
+	word := m[1] + m[2] + m[3]
+	status, ok := opportunityStatusWords[strings.ToLower(word)]
+	if !ok {
+		return "", title
+	}
+	return status, m[4]
+}
+
+// resolveOpportunityStatus combines the status given by an opportunity's status cell (if any)
+// with the status marker of its title (if any). Returns ffis.OpportunityStatusUnknown, along
+// with false, when the cell's value is not recognized or when the two markers conflict.
+func resolveOpportunityStatus(cell, titleStatus string) (string, bool) {
+	cellStatus, ok := normalizeOpportunityStatus(cell)
+	switch {
+	case !ok:
+		return ffis.OpportunityStatusUnknown, false
+	case cellStatus == "":
+		return titleStatus, true
+	case titleStatus != "" && titleStatus != cellStatus:
+		return ffis.OpportunityStatusUnknown, false
+	}
+	return cellStatus, true
+}
+
+// findStatusColumn returns the index of the status column in the given header row,
+// or -1 if the spreadsheet has no such column.
+func findStatusColumn(headers []string) int {
+	for i, header := range headers {
+		if statusHeaders[strings.ToLower(strings.TrimSpace(header))] {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseOpportunityStatus sets the status of opp from its status cell (if any) and any status
+// marker in its title, which is removed from the title. Opportunities whose status cannot be
+// determined are marked with ffis.OpportunityStatusUnknown, and the error is logged at the
+// WARN level rather than returned.
+func parseOpportunityStatus(opp *ffis.FFISFundingOpportunity, cell string, logger log.Logger) {
+	titleStatus, title := parseTitleStatus(opp.OppTitle)
+	status, ok := resolveOpportunityStatus(cell, titleStatus)
+	if !ok {
+		log.Warn(logger, "Could not determine opportunity status",
+			"raw_value", cell, "title_status", titleStatus)
+		sendMetric("spreadsheet.cell_parsing_errors", 1, "target:Status")
+	}
+	opp.OppTitle = title
+	opp.Status = status
+}
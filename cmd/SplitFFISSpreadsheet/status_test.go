@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/ffis"
+)
+
+func TestParseTitleStatus(t *testing.T) {
+	for _, tt := range []struct {
+		title             string
+		expectedStatus    string
+		expectedRemaining string
+	}{
+		{"NEW: Example Opportunity", ffis.OpportunityStatusNew, "Example Opportunity"},
+		{"  Updated:Example Opportunity", ffis.OpportunityStatusModified, "Example Opportunity"},
+		{"[Revised] Example Opportunity", ffis.OpportunityStatusModified, "Example Opportunity"},
+		{"( Removed ) Example Opportunity", ffis.OpportunityStatusDeleted, "Example Opportunity"},
+		{"CANCELED: Example Opportunity", ffis.OpportunityStatusDeleted, "Example Opportunity"},
+		{"Phase II: Example Opportunity", "", "Phase II: Example Opportunity"},
+		{"Research: New Approaches", "", "Research: New Approaches"},
+		{"New Example Opportunity", "", "New Example Opportunity"},
+		{"NEW:", "", "NEW:"},
+		{"", "", ""},
+	} {
+		t.Run(tt.title, func(t *testing.T) {
+			status, remaining := parseTitleStatus(tt.title)
+			assert.Equal(t, tt.expectedStatus, status)
+			assert.Equal(t, tt.expectedRemaining, remaining)
+		})
+	}
+}
+
+func TestResolveOpportunityStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		cell           string
+		titleStatus    string
+		expectedStatus string
+		expectedOK     bool
+	}{
+		{"absent", "", "", "", true},
+		{"cell only", " Modified ", "", ffis.OpportunityStatusModified, true},
+		{"title only", "", ffis.OpportunityStatusNew, ffis.OpportunityStatusNew, true},
+		{"agreeing markers", "Removed", ffis.OpportunityStatusDeleted, ffis.OpportunityStatusDeleted, true},
+		{"conflicting markers", "Deleted", ffis.OpportunityStatusNew, ffis.OpportunityStatusUnknown, false},
+		{"unrecognized cell", "Pending", "", ffis.OpportunityStatusUnknown, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, ok := resolveOpportunityStatus(tt.cell, tt.titleStatus)
+			assert.Equal(t, tt.expectedStatus, status)
+			assert.Equal(t, tt.expectedOK, ok)
+		})
+	}
+}
+
+func TestParseCSVFile_opportunity_statuses(t *testing.T) {
+	logger = log.NewNopLogger()
+	var parsingErrors float64
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) {
+		if metric == "spreadsheet.cell_parsing_errors" && len(tags) == 1 && tags[0] == "target:Status" {
+			parsingErrors += value
+		}
+	}
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+	f, err := os.Open("fixtures/example_spreadsheet_statuses.csv")
+	require.NoError(t, err)
+	defer f.Close()
+
+	parsed, err := parseCSVFile(f, logger)
+	require.NoError(t, err)
+	require.Len(t, parsed.Opportunities, 6)
+
+	titles := make([]string, 0, len(parsed.Opportunities))
+	statuses := make([]string, 0, len(parsed.Opportunities))
+	for _, opp := range parsed.Opportunities {
+		titles = append(titles, opp.OppTitle)
+		statuses = append(statuses, opp.Status)
+	}
+	assert.Equal(t, []string{
+		"Example Opportunity 1",
+		"Example Opportunity 2",
+		"Example Opportunity 3",
+		"Example Opportunity 4",
+		"Example Opportunity 5",
+		"Phase II: Example Opportunity 6",
+	}, titles)
+	assert.Equal(t, []string{
+		ffis.OpportunityStatusNew,      // Title marker
+		ffis.OpportunityStatusModified, // Status column
+		ffis.OpportunityStatusDeleted,  // Both, in agreement
+		"",                             // Neither
+		ffis.OpportunityStatusUnknown,  // Both, in conflict
+		ffis.OpportunityStatusUnknown,  // Unrecognized status column
+	}, statuses)
+	assert.Equal(t, float64(2), parsingErrors)
+	assert.Equal(t, []ffis.SplitManifestEntry{
+		{Row: 17, Reason: "missing grant ID", Status: ffis.OpportunityStatusDeleted},
+	}, parsed.Rejected)
+}
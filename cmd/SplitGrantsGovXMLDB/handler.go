@@ -7,15 +7,18 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/go-kit/log/level"
 	"github.com/hashicorp/go-multierror"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	"github.com/usdigitalresponse/grants-ingest/internal/log"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
 	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 const (
@@ -41,7 +44,12 @@ func (o *opportunity) S3ObjectKey() string {
 // a partial or complete invocation failure.
 // Returns nil when all grant opportunities are successfully processed from all source records,
 // indicating complete success.
+// Events are skipped without side effects while the split feature is disabled.
 func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events.S3Event) error {
+	if disabledFeatures.SkipIfDisabled(logger, sendMetric, configHelpers.FeatureSplit, len(s3Event.Records)) {
+		return nil
+	}
+
 	// Configure service clients
 	s3svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.UsePathStyle = env.UsePathStyleS3Opt
@@ -52,7 +60,7 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 	opportunities := make(chan opportunity)
 
 	// Create a pool of workers to consume and upload values received from the opportunities channel
-	processingSpan, processingCtx := tracer.StartSpanFromContext(ctx, "processing")
+	processingSpan, processingCtx := tracing.StartSpanFromContext(ctx, "processing")
 	wg := multierror.Group{}
 	for i := 0; i < env.MaxConcurrentUploads; i++ {
 		wg.Go(func() error {
@@ -65,10 +73,10 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 	// first encountered error, we instead accumulate them into a single "multi-error".
 	// Only one source record is consumed at a time; in normal cases, the invocation event
 	// will only provide a single source record.
-	sourcingSpan, sourcingCtx := tracer.StartSpanFromContext(ctx, "handle.records")
+	sourcingSpan, sourcingCtx := tracing.StartSpanFromContext(ctx, "handle.records")
 	sourcingErrs := &multierror.Error{}
 	for i, record := range s3Event.Records {
-		recordSpan, recordCtx := tracer.StartSpanFromContext(sourcingCtx, "handle.record")
+		recordSpan, recordCtx := tracing.StartSpanFromContext(sourcingCtx, "handle.record")
 		sourcingErr := func(i int, record events.S3EventRecord) error {
 			sourceBucket := record.S3.Bucket.Name
 			sourceKey := record.S3.Object.Key
@@ -85,7 +93,21 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 				return err
 			}
 
-			buffer := bufio.NewReaderSize(resp.Body, int(env.DownloadChunkLimit*MB))
+			defer resp.Body.Close()
+
+			// Extracts are occasionally gzip-compressed without a Content-Encoding header,
+			// so the body is sniffed for compression regardless of the declared encoding
+			body, compressed, err := awsHelpers.DecompressIfGzipped(resp.Body)
+			if err != nil {
+				log.Error(logger, "Error decompressing gzip-compressed source S3 object", err)
+				return err
+			}
+			if compressed {
+				sendMetric("source.decompressed", 1)
+				log.Info(logger, "Decompressing gzip-compressed source S3 object")
+			}
+
+			buffer := bufio.NewReaderSize(body, int(env.DownloadChunkLimit*MB))
 			if err := readOpportunities(recordCtx, buffer, opportunities); err != nil {
 				log.Error(logger, "Error reading source opportunities from S3", err)
 				return err
@@ -97,7 +119,7 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 		if sourcingErr != nil {
 			sourcingErrs = multierror.Append(sourcingErrs, sourcingErr)
 		}
-		recordSpan.Finish(tracer.WithError(sourcingErr))
+		recordSpan.Finish(tracing.WithError(sourcingErr))
 	}
 
 	// All source records have been consumed; close the channel so that workers shut down
@@ -135,14 +157,14 @@ func handleS3EventWithConfig(cfg aws.Config, ctx context.Context, s3Event events
 // readOpportunities stops and returns an error when the context is canceled
 // or an error is encountered while reading.
 func readOpportunities(ctx context.Context, r io.Reader, ch chan<- opportunity) error {
-	span, ctx := tracer.StartSpanFromContext(ctx, "read.xml")
+	span, ctx := tracing.StartSpanFromContext(ctx, "read.xml")
 
 	d := xml.NewDecoder(r)
 	for {
 		// Check for context cancelation before/between reads
 		if err := ctx.Err(); err != nil {
 			log.Warn(logger, "Context canceled before reading was complete", "reason", err)
-			span.Finish(tracer.WithError(err))
+			span.Finish(tracing.WithError(err))
 			return err
 		}
 
@@ -153,7 +175,7 @@ func readOpportunities(ctx context.Context, r io.Reader, ch chan<- opportunity)
 				break
 			}
 			level.Error(logger).Log("msg", "Error reading XML token", "error", err)
-			span.Finish(tracer.WithError(err))
+			span.Finish(tracing.WithError(err))
 			return err
 		}
 
@@ -163,7 +185,7 @@ func readOpportunities(ctx context.Context, r io.Reader, ch chan<- opportunity)
 			var opportunity opportunity
 			if err := d.DecodeElement(&opportunity, &se); err != nil {
 				level.Error(logger).Log("msg", "Error decoding XML token", "error", err)
-				span.Finish(tracer.WithError(err))
+				span.Finish(tracing.WithError(err))
 				return err
 			}
 			ch <- opportunity
@@ -180,12 +202,12 @@ func readOpportunities(ctx context.Context, r io.Reader, ch chan<- opportunity)
 // grantOpportunity as well as the reason for the context cancelation, if any.
 // Returns nil if all opportunities were processed successfully until the channel was closed.
 func processOpportunities(ctx context.Context, svc *s3.Client, ch <-chan opportunity) (errs error) {
-	span, ctx := tracer.StartSpanFromContext(ctx, "processing.worker")
+	span, ctx := tracing.StartSpanFromContext(ctx, "processing.worker")
 
 	whenCanceled := func() error {
 		err := ctx.Err()
 		log.Debug(logger, "Done processing opportunities because context canceled", "reason", err)
-		span.Finish(tracer.WithError(err))
+		span.Finish(tracing.WithError(err))
 		errs = multierror.Append(errs, err)
 		return errs
 	}
@@ -206,13 +228,13 @@ func processOpportunities(ctx context.Context, svc *s3.Client, ch <-chan opportu
 					return
 				}
 
-				workSpan, ctx := tracer.StartSpanFromContext(ctx, "processing.worker.work")
+				workSpan, ctx := tracing.StartSpanFromContext(ctx, "processing.worker.work")
 				err := processOpportunity(ctx, svc, opportunity)
 				if err != nil {
 					sendMetric("opportunity.failed", 1)
 					errs = multierror.Append(errs, err)
 				}
-				workSpan.Finish(tracer.WithError(err))
+				workSpan.Finish(tracing.WithError(err))
 
 			case <-ctx.Done():
 				return whenCanceled()
@@ -221,11 +243,19 @@ func processOpportunities(ctx context.Context, svc *s3.Client, ch <-chan opportu
 	}
 }
 
+// exceedsReprocessWindow returns true when env.ReprocessIfOlderThan is set and the given
+// last-modified time of a stored object is older than that duration.
+func exceedsReprocessWindow(lastModified time.Time) bool {
+	return env.ReprocessIfOlderThan > 0 && time.Since(lastModified) > env.ReprocessIfOlderThan
+}
+
 // processOpportunity takes a single opportunity and conditionally uploads an XML
 // representation of the opportunity to its configured S3 destination. Before uploading,
 // any extant S3 object with a matching key in the bucket named by env.DestinationBucket
 // is compared with the opportunity. An upload is initiated when the opportunity was updated
 // more recently than the extant object was last modified, or when no extant object exists.
+// When env.ReprocessIfOlderThan is set, an upload is also initiated when the extant object
+// was last modified longer ago than that duration.
 func processOpportunity(ctx context.Context, svc S3ReadWriteObjectAPI, opp opportunity) error {
 	logger := log.With(logger,
 		"opportunity_id", opp.OpportunityID, "opportunity_number", opp.OpportunityNumber)
@@ -248,12 +278,17 @@ func processOpportunity(ctx context.Context, svc S3ReadWriteObjectAPI, opp oppor
 
 	isNew := false
 	if remoteLastModified != nil {
-		if remoteLastModified.After(lastModified) {
+		if !remoteLastModified.After(lastModified) {
+			log.Debug(logger, "Uploading updated opportunity to replace outdated remote record")
+		} else if exceedsReprocessWindow(*remoteLastModified) {
+			log.Debug(logger, "Reprocessing up-to-date opportunity because the extant record is stale",
+				"reprocess_if_older_than", env.ReprocessIfOlderThan)
+			sendMetric("opportunity.reprocessed", 1)
+		} else {
 			log.Debug(logger, "Skipping opportunity upload because the extant record is up-to-date")
 			sendMetric("opportunity.skipped", 1)
 			return nil
 		}
-		log.Debug(logger, "Uploading updated opportunity to replace outdated remote record")
 	} else {
 		isNew = true
 		log.Debug(logger, "Uploading new opportunity")
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/xml"
@@ -27,6 +28,7 @@ import (
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
 	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
 )
 
@@ -357,6 +359,54 @@ func TestLambdaInvocationScenarios(t *testing.T) {
 		assert.NoError(t, err, "Expected destination object was not created")
 	})
 
+	t.Run("Gzip-compressed source object without Content-Encoding", func(t *testing.T) {
+		setupLambdaEnvForTesting(t)
+
+		sourceBucketName := "test-source-bucket"
+		s3client, cfg, err := setupS3ForTesting(t, sourceBucketName)
+		require.NoError(t, err)
+		sourceTemplate := template.Must(
+			template.New("xml").Delims("{{", "}}").Parse(SOURCE_OPPORTUNITY_TEMPLATE),
+		)
+		var sourceData bytes.Buffer
+		gz := gzip.NewWriter(&sourceData)
+		_, err = gz.Write([]byte("<Grants>"))
+		require.NoError(t, err)
+		require.NoError(t, sourceTemplate.Execute(gz, map[string]string{
+			"OpportunityID":   "98765",
+			"LastUpdatedDate": "01022023",
+		}))
+		_, err = gz.Write([]byte("</Grants>"))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		_, err = s3client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(sourceBucketName),
+			Key:    aws.String("sources/2023/02/03/grants.gov/extract.xml"),
+			Body:   bytes.NewReader(sourceData.Bytes()),
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, handleS3EventWithConfig(cfg, context.TODO(), events.S3Event{
+			Records: []events.S3EventRecord{
+				{S3: events.S3Entity{
+					Bucket: events.S3Bucket{Name: sourceBucketName},
+					Object: events.S3Object{Key: "sources/2023/02/03/grants.gov/extract.xml"},
+				}},
+			},
+		}))
+
+		resp, err := s3client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(env.DestinationBucket),
+			Key:    aws.String("987/98765/grants.gov/v2.xml"),
+		})
+		require.NoError(t, err, "Expected destination object was not created")
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var savedOpportunity grantsgov.OpportunitySynopsisDetail_1_0
+		require.NoError(t, xml.Unmarshal(b, &savedOpportunity))
+		assert.Equal(t, grantsgov.Number20DigitsType("98765"), savedOpportunity.OpportunityID)
+	})
+
 	t.Run("Context canceled during invocation", func(t *testing.T) {
 		setupLambdaEnvForTesting(t)
 		_, cfg, err := setupS3ForTesting(t, "source-bucket")
@@ -392,6 +442,50 @@ func (r *MockReader) Read(p []byte) (int, error) {
 	return r.read(p)
 }
 
+func TestLambdaInvocationFeatureDisabled(t *testing.T) {
+	setupLambdaEnvForTesting(t)
+	disabledFeatures = configHelpers.FeatureSet{configHelpers.FeatureSplit: true}
+	t.Cleanup(func() { disabledFeatures = nil })
+	sentMetrics := make(map[string]float64)
+	restoreSendMetric := sendMetric
+	sendMetric = func(metric string, value float64, tags ...string) { sentMetrics[metric] += value }
+	t.Cleanup(func() { sendMetric = restoreSendMetric })
+
+	sourceBucketName := "test-source-bucket"
+	s3client, cfg, err := setupS3ForTesting(t, sourceBucketName)
+	require.NoError(t, err, "Error configuring test environment")
+	var sourceGrantsData bytes.Buffer
+	sourceGrantsData.WriteString("<Grants>")
+	require.NoError(t, template.Must(template.New("xml").Parse(SOURCE_OPPORTUNITY_TEMPLATE)).Execute(
+		&sourceGrantsData, map[string]string{
+			"OpportunityID":   "1234",
+			"LastUpdatedDate": time.Now().Format("01022006"),
+		}))
+	sourceGrantsData.WriteString("</Grants>")
+	objectKey := fmt.Sprintf("sources/%s/grants.gov/extract.xml", time.Now().Format("2006/01/02"))
+	_, err = s3client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(sourceBucketName),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(sourceGrantsData.Bytes()),
+	})
+	require.NoError(t, err, "Error creating test source object")
+
+	err = handleS3EventWithConfig(cfg, context.TODO(), events.S3Event{
+		Records: []events.S3EventRecord{{S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: sourceBucketName},
+			Object: events.S3Object{Key: objectKey},
+		}}},
+	})
+	require.NoError(t, err)
+
+	resp, err := s3client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(env.DestinationBucket),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Contents, "nothing should be written while disabled")
+	assert.Equal(t, map[string]float64{"stage.skipped_disabled": 1}, sentMetrics)
+}
+
 func TestReadOpportunities(t *testing.T) {
 	t.Run("Context cancelled between reads", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.TODO())
@@ -453,4 +547,42 @@ func TestProcessOpportunity(t *testing.T) {
 		err := processOpportunity(context.TODO(), s3Client, testOpportunity)
 		assert.ErrorContains(t, err, "Error uploading prepared grant opportunity to S3")
 	})
+	t.Run("Reprocess if older than", func(t *testing.T) {
+		staleOpportunity := opportunity{
+			OpportunityID: "1234",
+			LastUpdatedDate: grantsgov.MMDDYYYYType(
+				now.AddDate(0, 0, -10).Format(grantsgov.TimeLayoutMMDDYYYYType)),
+		}
+		for _, tt := range []struct {
+			name                 string
+			reprocessIfOlderThan time.Duration
+			remoteLastModified   time.Time
+			expectUpload         bool
+		}{
+			{"disabled", 0, now.Add(-48 * time.Hour), false},
+			{"extant object within window is skipped", 24 * time.Hour, now.Add(-time.Hour), false},
+			{"extant object beyond window is reprocessed", 24 * time.Hour, now.Add(-48 * time.Hour), true},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				setupLambdaEnvForTesting(t)
+				env.ReprocessIfOlderThan = tt.reprocessIfOlderThan
+				t.Cleanup(func() { env.ReprocessIfOlderThan = 0 })
+				uploaded := false
+				s3Client := mockS3ReadwriteObjectAPI{
+					mockHeadObjectAPI(
+						func(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+							return &s3.HeadObjectOutput{LastModified: aws.Time(tt.remoteLastModified)}, nil
+						},
+					),
+					mockGetObjectAPI(nil),
+					mockPutObjectAPI(func(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+						uploaded = true
+						return &s3.PutObjectOutput{}, nil
+					}),
+				}
+				require.NoError(t, processOpportunity(context.TODO(), s3Client, staleOpportunity))
+				assert.Equal(t, tt.expectUpload, uploaded)
+			})
+		}
+	})
 }
@@ -10,6 +10,7 @@ import (
 	awsTransport "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
 )
 
 // S3GetObjectAPI is the interface for retrieving objects from an S3 bucket
@@ -56,14 +57,18 @@ func GetS3LastModified(ctx context.Context, c s3.HeadObjectAPIClient, bucket, ke
 }
 
 // UploadS3Object uploads bytes read from from r to an S3 object at the given bucket and key.
-// If an error was encountered during upload, returns the error.
+// The uploaded content is verified by S3 using a checksum computed with env.S3ChecksumAlgorithm.
+// If an error was encountered during upload, returns the error, which wraps
+// awsHelpers.ErrS3ChecksumMismatch when the content was corrupted in transit.
 // Returns nil when the upload was successful.
 func UploadS3Object(ctx context.Context, c S3PutObjectAPI, bucket, key string, r io.Reader) error {
 	_, err := c.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:               aws.String(bucket),
 		Key:                  aws.String(key),
 		Body:                 r,
+		Metadata:             awsHelpers.WithProcessorVersion(awsHelpers.WithLambdaRequestID(ctx, nil)),
 		ServerSideEncryption: types.ServerSideEncryptionAes256,
+		ChecksumAlgorithm:    env.S3ChecksumAlgorithm,
 	})
-	return err
+	return awsHelpers.WrapS3ChecksumError(err)
 }
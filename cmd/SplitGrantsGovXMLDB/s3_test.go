@@ -12,8 +12,10 @@ import (
 	awsTransport "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/stretchr/testify/assert"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
 )
 
 type mockGetObjectAPI func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
@@ -122,6 +124,7 @@ func TestUploadS3Object(t *testing.T) {
 	testObjectKey := "test/key"
 	testReader := bytes.NewReader([]byte("hello!"))
 	testError := fmt.Errorf("oh no this is an error")
+	env.S3ChecksumAlgorithm = types.ChecksumAlgorithmCrc32c
 
 	for _, tt := range []struct {
 		name   string
@@ -137,6 +140,7 @@ func TestUploadS3Object(t *testing.T) {
 					assert.Equal(t, aws.String(testObjectKey), params.Key)
 					assert.Equal(t, testReader, params.Body)
 					assert.Equal(t, params.ServerSideEncryption, types.ServerSideEncryptionAes256)
+					assert.Equal(t, types.ChecksumAlgorithmCrc32c, params.ChecksumAlgorithm)
 					return &s3.PutObjectOutput{}, nil
 				})
 			},
@@ -154,12 +158,21 @@ func TestUploadS3Object(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			"PutObject rejects corrupted content",
+			func(t *testing.T) S3PutObjectAPI {
+				return mockPutObjectAPI(func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+					return nil, &smithy.GenericAPIError{Code: "BadDigest"}
+				})
+			},
+			awsHelpers.ErrS3ChecksumMismatch,
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			err := UploadS3Object(context.TODO(), tt.client(t),
 				testBucketName, testObjectKey, testReader)
 			if tt.expErr != nil {
-				assert.EqualError(t, err, tt.expErr.Error())
+				assert.ErrorIs(t, err, tt.expErr)
 			}
 		})
 	}
@@ -2,14 +2,19 @@ package awsHelpers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
+// ErrAssumeRoleFailed is returned when credentials for an IAM role cannot be obtained from STS.
+var ErrAssumeRoleFailed = errors.New("failed to assume IAM role")
+
 // GetConfig returns an AWS SDK v2 Config with a custom resolver that resolves SDK requests
 // to an endpoint at http://$LOCALSTACK_HOSTNAME:4566 when $LOCALSTACK_HOSTNAME is configured
 // in the current environment.
@@ -34,6 +39,21 @@ func GetConfig(ctx context.Context) (aws.Config, error) {
 	return config.LoadDefaultConfig(ctx, config.WithEndpointResolverWithOptions(resolver))
 }
 
+// AssumeRoleConfig returns a copy of cfg whose credentials are those of the IAM role identified
+// by roleARN, as obtained by client (which is typically an STS client created from cfg).
+// The credentials are cached and refreshed before they expire. They are also retrieved before
+// returning, so that a role which cannot be assumed (e.g. because its trust policy does not
+// allow it) results in an error wrapping ErrAssumeRoleFailed rather than a failure of the first
+// request made with the returned config.
+func AssumeRoleConfig(ctx context.Context, cfg aws.Config, client stscreds.AssumeRoleAPIClient, roleARN string) (aws.Config, error) {
+	assumed := cfg.Copy()
+	assumed.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(client, roleARN))
+	if _, err := assumed.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("%w %s: %w", ErrAssumeRoleFailed, roleARN, err)
+	}
+	return assumed, nil
+}
+
 func GetSQSClient(ctx context.Context) (*sqs.Client, error) {
 	cfg, err := GetConfig(ctx)
 	if err != nil {
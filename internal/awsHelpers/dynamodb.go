@@ -16,6 +16,17 @@ func DDBSetRevisionForUpdate(builder expression.UpdateBuilder) expression.Update
 	return builder.Set(expression.Name("revision"), expression.Value(ulid.Make().String()))
 }
 
+// DDBVersionAttributeName is the name of the integer attribute that counts the writes to an item.
+const DDBVersionAttributeName = "version"
+
+// DDBIncrementVersionForUpdate adds a DynamoDB ADD operation to an UpdateBuilder, which
+// increments the value of an item's "version" attribute (starting from 1 for new items).
+// Unlike the revision, the version allows the writes to an item to be ordered and compared,
+// e.g. for optimistic locking.
+func DDBIncrementVersionForUpdate(builder expression.UpdateBuilder) expression.UpdateBuilder {
+	return builder.Add(expression.Name(DDBVersionAttributeName), expression.Value(1))
+}
+
 // DDBIfAnyValueChangedCondition creates a conditional update expression that will only allow
 // a table item to update if one of the field values provided in ifAttributeValuesChanged is different
 // than the currently-stored values. This facilitates updating certain attributes (not included
@@ -42,3 +53,14 @@ func DDBIfAnyValueChangedCondition(ifAttributeValuesChanged map[string]types.Att
 	}
 	return condition, nil
 }
+
+// DDBSourcesAttributeName is the name of the string set attribute that records which
+// sources (e.g. grants.gov, ffis.org) have contributed data to an item.
+const DDBSourcesAttributeName = "sources"
+
+// DDBAddSourceForUpdate adds a DynamoDB ADD operation to an UpdateBuilder, which adds source
+// to the set of sources that have contributed to an item.
+func DDBAddSourceForUpdate(builder expression.UpdateBuilder, source string) expression.UpdateBuilder {
+	return builder.Add(expression.Name(DDBSourcesAttributeName),
+		expression.Value(&types.AttributeValueMemberSS{Value: []string{source}}))
+}
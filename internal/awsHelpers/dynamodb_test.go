@@ -25,6 +25,18 @@ func TestDDBSetRevisionForUpdate(t *testing.T) {
 		"Expression does not seem to set 'revision' field to ULID string")
 }
 
+func TestDDBIncrementVersionForUpdate(t *testing.T) {
+	expr, err := expression.NewBuilder().WithUpdate(
+		DDBIncrementVersionForUpdate(expression.UpdateBuilder{})).Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, "ADD #0 :0", strings.TrimSpace(*expr.Update()))
+	assert.Equal(t, map[string]string{"#0": "version"}, expr.Names())
+	assert.Equal(t, map[string]types.AttributeValue{
+		":0": &types.AttributeValueMemberN{Value: "1"},
+	}, expr.Values())
+}
+
 func TestDDBIfAnyValueChangedCondition(t *testing.T) {
 	t.Run("empty map returns error", func(t *testing.T) {
 		_, err := DDBIfAnyValueChangedCondition(map[string]types.AttributeValue{})
@@ -73,6 +85,18 @@ func TestDDBIfAnyValueChangedCondition(t *testing.T) {
 	}
 }
 
+func TestDDBAddSourceForUpdate(t *testing.T) {
+	expr, err := expression.NewBuilder().WithUpdate(
+		DDBAddSourceForUpdate(expression.UpdateBuilder{}, "ffis.org")).Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, "ADD #0 :0", strings.TrimSpace(*expr.Update()))
+	assert.Equal(t, map[string]string{"#0": "sources"}, expr.Names())
+	assert.Equal(t, map[string]types.AttributeValue{
+		":0": &types.AttributeValueMemberSS{Value: []string{"ffis.org"}},
+	}, expr.Values())
+}
+
 // Test helper that renders a DynamoDB expression string, replacing expression attribute
 // name/value placeholders with their literal forms.
 //
@@ -0,0 +1,517 @@
+package awsHelpers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsTransport "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/cenkalti/backoff/v4"
+)
+
+// MB is the number of bytes in a megabyte, for use when converting DOWNLOAD_CHUNK_LIMIT values.
+const MB = int64(1024 * 1024)
+
+// DefaultChecksumAlgorithm is the checksum algorithm used to verify the integrity of uploads
+// unless another algorithm is configured.
+const DefaultChecksumAlgorithm = types.ChecksumAlgorithmSha256
+
+// DefaultChunkRetryElapsedTime is the default limit on time spent retrying a single chunk.
+const DefaultChunkRetryElapsedTime = 30 * time.Second
+
+var (
+	ErrS3ChunkLengthMismatch  = errors.New("S3 object chunk length does not match requested range")
+	ErrS3ObjectLengthMismatch = errors.New("assembled S3 object length does not match its content length")
+	ErrS3ContentRangeInvalid  = errors.New("S3 response has an invalid Content-Range")
+
+	ErrS3ChecksumMismatch       = errors.New("S3 object content does not match its checksum")
+	ErrUnknownChecksumAlgorithm = errors.New("unknown S3 checksum algorithm")
+
+	ErrS3ObjectArchived = errors.New("S3 object is archived and must be restored before it can be read")
+
+	ErrS3ObjectVerificationFailed = errors.New("S3 object could not be verified as readable")
+)
+
+// S3VerificationReadBytes is the number of leading bytes read from an object to verify that its
+// contents are readable (e.g. that the caller can decrypt them).
+const S3VerificationReadBytes = 1024
+
+// gzipMagic is the header that begins every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// S3GetObjectAPI is the interface for retrieving objects from an S3 bucket
+type S3GetObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3CopyDestinationAPI is the interface for writing objects to a destination bucket, either by
+// server-side copy or by (multipart) upload.
+type S3CopyDestinationAPI interface {
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	manager.UploadAPIClient
+}
+
+// S3MoveObjectAPI is the interface for moving objects within an S3 bucket, including verifying
+// that the moved object is readable before the original is deleted.
+type S3MoveObjectAPI interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// S3ObjectLocation identifies an S3 object along with the region of the bucket that contains it.
+type S3ObjectLocation struct {
+	Region string
+	Bucket string
+	Key    string
+}
+
+// CopyS3Object copies the object at src to dst. When both buckets are in the same region, the
+// object is copied server-side with a single CopyObject request, so that its contents never
+// pass through the caller. Otherwise, the object is streamed from srcClient to dstClient with
+// at most one upload part buffered in memory at a time.
+// In either case, the destination object is encrypted with SSE-S3 and retains the content type
+// and user-defined metadata of the source object.
+func CopyS3Object(ctx context.Context, srcClient S3GetObjectAPI, dstClient S3CopyDestinationAPI, src, dst S3ObjectLocation) error {
+	if src.Region == dst.Region {
+		_, err := dstClient.CopyObject(ctx, &s3.CopyObjectInput{
+			CopySource:           aws.String((&url.URL{Path: src.Bucket + "/" + src.Key}).EscapedPath()),
+			Bucket:               aws.String(dst.Bucket),
+			Key:                  aws.String(dst.Key),
+			ServerSideEncryption: types.ServerSideEncryptionAes256,
+		})
+		return err
+	}
+
+	resp, err := srcClient.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(src.Bucket),
+		Key:    aws.String(src.Key),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	uploader := manager.NewUploader(dstClient, func(u *manager.Uploader) {
+		// Upload parts sequentially so that only one part is buffered at a time
+		u.Concurrency = 1
+	})
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(dst.Bucket),
+		Key:                  aws.String(dst.Key),
+		Body:                 resp.Body,
+		ContentType:          resp.ContentType,
+		Metadata:             resp.Metadata,
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	})
+	return err
+}
+
+// MoveS3Object moves the object at srcKey to dstKey within bucket by copying it server-side
+// and then deleting the source object. Since the destination object is created by a single
+// CopyObject request, S3 event notifications for dstKey only ever describe a complete object.
+// The destination object is encrypted with SSE-S3 and retains the content type and
+// user-defined metadata of the source object.
+// The source object is only deleted once the destination object is verified to be readable
+// (see VerifyS3ObjectReadable); otherwise, it is left in place and the returned error wraps
+// ErrS3ObjectVerificationFailed.
+func MoveS3Object(ctx context.Context, client S3MoveObjectAPI, bucket, srcKey, dstKey string) error {
+	src, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(srcKey),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return fmt.Errorf("error reading S3 object attributes before copying: %w", err)
+	}
+	if _, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		CopySource:           aws.String((&url.URL{Path: bucket + "/" + srcKey}).EscapedPath()),
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(dstKey),
+		ServerSideEncryption: types.ServerSideEncryptionAes256,
+	}); err != nil {
+		return fmt.Errorf("error copying S3 object: %w", err)
+	}
+	if err := VerifyS3ObjectReadable(ctx, client, bucket, dstKey, src.ContentLength,
+		aws.ToString(src.ChecksumSHA256)); err != nil {
+		return fmt.Errorf("source S3 object was not deleted: %w", err)
+	}
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(srcKey),
+	}); err != nil {
+		return fmt.Errorf("error deleting S3 object after copying: %w", err)
+	}
+	return nil
+}
+
+// VerifyS3ObjectReadable verifies that the object at key in bucket has the expected size and
+// (when both it and checksumSHA256 are available) SHA-256 checksum, and that its leading
+// S3VerificationReadBytes bytes can be read. The read detects objects whose attributes are
+// readable but whose contents are not, e.g. due to missing KMS key permissions.
+// Any failure is returned wrapped with ErrS3ObjectVerificationFailed.
+func VerifyS3ObjectReadable(ctx context.Context, client S3MoveObjectAPI, bucket, key string, size int64, checksumSHA256 string) error {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrS3ObjectVerificationFailed, err)
+	}
+	if head.ContentLength != size {
+		return fmt.Errorf("%w: expected %d bytes but object has %d bytes",
+			ErrS3ObjectVerificationFailed, size, head.ContentLength)
+	}
+	// Checksums of multipart objects (suffixed by the number of parts) depend on the part
+	// sizes, so they are only compared when neither object has one
+	stored := aws.ToString(head.ChecksumSHA256)
+	if stored != "" && checksumSHA256 != "" && !strings.Contains(stored+checksumSHA256, "-") &&
+		stored != checksumSHA256 {
+		return fmt.Errorf("%w: expected SHA-256 checksum %s but object has %s",
+			ErrS3ObjectVerificationFailed, checksumSHA256, stored)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	n := size
+	if n > S3VerificationReadBytes {
+		n = S3VerificationReadBytes
+	}
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrS3ObjectVerificationFailed, err)
+	}
+	defer resp.Body.Close()
+	read, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrS3ObjectVerificationFailed, err)
+	}
+	if read != n {
+		return fmt.Errorf("%w: expected to read %d bytes but read %d bytes",
+			ErrS3ObjectVerificationFailed, n, read)
+	}
+	return nil
+}
+
+// ListS3Objects returns every object in bucket whose key begins with prefix, following
+// continuation tokens until all pages of results have been retrieved.
+func ListS3Objects(ctx context.Context, client s3.ListObjectsV2APIClient, bucket, prefix string) ([]types.Object, error) {
+	objects := []types.Object{}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing S3 objects: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+	}
+	return objects, nil
+}
+
+// LambdaRequestIDMetadataKey is the user-defined metadata key (i.e. x-amz-meta-lambda-request-id)
+// that identifies the Lambda invocation which wrote an S3 object.
+const LambdaRequestIDMetadataKey = "lambda-request-id"
+
+// WithLambdaRequestID returns a copy of metadata to which the request ID of the Lambda invocation
+// associated with ctx is added under LambdaRequestIDMetadataKey, so that objects can be traced
+// back to the invocation that wrote them. When ctx has no Lambda context (e.g. during local runs),
+// metadata is returned unchanged.
+func WithLambdaRequestID(ctx context.Context, metadata map[string]string) map[string]string {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok || lc.AwsRequestID == "" {
+		return metadata
+	}
+	m := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		m[k] = v
+	}
+	m[LambdaRequestIDMetadataKey] = lc.AwsRequestID
+	return m
+}
+
+// ProcessorVersionMetadataKey is the user-defined metadata key (i.e. x-amz-meta-processor-version)
+// that identifies the version of the code which wrote an S3 object.
+const ProcessorVersionMetadataKey = "processor-version"
+
+// ProcessorVersion identifies the version of the code that is running. It is set at build time,
+// e.g. with -ldflags "-X github.com/usdigitalresponse/grants-ingest/internal/awsHelpers.ProcessorVersion=v1.2.3",
+// and is empty for builds that do not set it.
+var ProcessorVersion string
+
+// BuildCommit identifies the commit from which the running code was built. Like ProcessorVersion,
+// it is set at build time with -ldflags "-X ...awsHelpers.BuildCommit=<sha>", and is empty for
+// builds that do not set it.
+var BuildCommit string
+
+// WithProcessorVersion returns a copy of metadata to which ProcessorVersion is added under
+// ProcessorVersionMetadataKey, so that objects written by a particular version of the code
+// can be identified (e.g. for targeted reprocessing). When ProcessorVersion is not set,
+// metadata is returned unchanged.
+func WithProcessorVersion(metadata map[string]string) map[string]string {
+	if ProcessorVersion == "" {
+		return metadata
+	}
+	m := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		m[k] = v
+	}
+	m[ProcessorVersionMetadataKey] = ProcessorVersion
+	return m
+}
+
+// IsPermanentS3Error returns true when err represents an S3 API failure that will not
+// succeed if retried.
+func IsPermanentS3Error(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var respErr *awsTransport.ResponseError
+	if errors.As(err, &respErr) {
+		status := respErr.ResponseError.HTTPStatusCode()
+		return status >= 400 && status < 500 &&
+			status != 408 && // Request Timeout
+			status != 429 // Too Many Requests
+	}
+	return false
+}
+
+// ValidateChecksumAlgorithm returns an error wrapping ErrUnknownChecksumAlgorithm when alg
+// is not a checksum algorithm supported by S3 (e.g. SHA256 or CRC32C).
+func ValidateChecksumAlgorithm(alg types.ChecksumAlgorithm) error {
+	for _, known := range alg.Values() {
+		if alg == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownChecksumAlgorithm, alg)
+}
+
+// WrapS3ChecksumError returns err wrapped with ErrS3ChecksumMismatch when err indicates that
+// S3 rejected an upload because the uploaded content did not match its checksum, which means
+// that the content was corrupted in transit. Any other error (including nil) is returned as-is.
+func WrapS3ChecksumError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "BadDigest", "XAmzContentChecksumMismatch":
+			return fmt.Errorf("%w: %w", ErrS3ChecksumMismatch, err)
+		}
+	}
+	return err
+}
+
+// WrapS3ObjectArchivedError returns err wrapped with ErrS3ObjectArchived when err indicates that
+// S3 refused to read an object because it has transitioned to an archival storage class (e.g.
+// Glacier) and has not been restored. Any other error (including nil) is returned as-is.
+func WrapS3ObjectArchivedError(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState" {
+		return fmt.Errorf("%w: %w", ErrS3ObjectArchived, err)
+	}
+	return err
+}
+
+// DecompressIfGzipped returns a reader that yields the decompressed contents of r when r begins
+// with the gzip magic bytes, or else yields the contents of r unmodified. The returned bool is
+// true when r was found to be gzip-compressed.
+// Objects are sniffed regardless of their declared Content-Encoding, because S3 objects are
+// occasionally gzip-compressed without being labeled as such.
+func DecompressIfGzipped(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if !bytes.Equal(header, gzipMagic) {
+		return br, false, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, true, err
+	}
+	return gz, true, nil
+}
+
+// ChunkedReader is an io.Reader that downloads an S3 object with a series of ranged GetObject
+// requests, each of which is no larger than the configured chunk size.
+// A failed chunk is retried (from the start of that chunk) without re-downloading any chunks
+// that were already read. The length of every chunk is verified against the object's content
+// length, so that the assembled object is exactly as long as the object in S3; a chunk that is
+// truncated mid-stream is treated as a failure of that chunk.
+type ChunkedReader struct {
+	// MaxRetryElapsedTime limits the time spent retrying any single chunk.
+	MaxRetryElapsedTime time.Duration
+	// OnRetry, when non-nil, is called before a failed chunk request is retried.
+	OnRetry func(err error, offset int64, retryIn time.Duration)
+
+	ctx       context.Context
+	client    S3GetObjectAPI
+	bucket    string
+	key       string
+	chunkSize int64
+
+	// offset is the position of the next byte to request from S3
+	offset int64
+	// size is the object's content length, or -1 before the first chunk has been retrieved
+	size int64
+	etag *string
+	buf  []byte
+	err  error
+}
+
+// NewChunkedReader returns a ChunkedReader for the S3 object at the given bucket and key,
+// which requests at most chunkSize bytes at a time.
+// Objects that are no larger than chunkSize are retrieved with a single request.
+func NewChunkedReader(ctx context.Context, client S3GetObjectAPI, bucket, key string, chunkSize int64) *ChunkedReader {
+	if chunkSize <= 0 {
+		chunkSize = 10 * MB
+	}
+	return &ChunkedReader{
+		MaxRetryElapsedTime: DefaultChunkRetryElapsedTime,
+		ctx:                 ctx,
+		client:              client,
+		bucket:              bucket,
+		key:                 key,
+		chunkSize:           chunkSize,
+		size:                -1,
+	}
+}
+
+// Read implements io.Reader.
+func (r *ChunkedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.size >= 0 && r.offset >= r.size {
+			return 0, io.EOF
+		}
+		if err := r.fetchChunk(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fetchChunk retrieves the chunk that begins at r.offset, retrying transient failures.
+func (r *ChunkedReader) fetchChunk() error {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = r.MaxRetryElapsedTime
+	return backoff.RetryNotify(func() error {
+		chunk, size, etag, err := r.getRange(r.offset, r.offset+r.chunkSize-1)
+		if err != nil {
+			return err
+		}
+		if r.size < 0 {
+			r.size, r.etag = size, etag
+		} else if size != r.size {
+			return backoff.Permanent(fmt.Errorf("%w: content length changed from %d to %d",
+				ErrS3ObjectLengthMismatch, r.size, size))
+		}
+		r.buf = chunk
+		r.offset += int64(len(chunk))
+		return nil
+	}, backoff.WithContext(b, r.ctx), func(err error, d time.Duration) {
+		if r.OnRetry != nil {
+			r.OnRetry(err, r.offset, d)
+		}
+	})
+}
+
+// getRange requests the inclusive byte range [start, end] of the object and returns the
+// response body along with the object's total size and ETag.
+func (r *ChunkedReader) getRange(start, end int64) ([]byte, int64, *string, error) {
+	resp, err := r.client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(r.bucket),
+		Key:     aws.String(r.key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		IfMatch: r.etag,
+	})
+	if err != nil {
+		var respErr *awsTransport.ResponseError
+		if start == 0 && errors.As(err, &respErr) && respErr.HTTPStatusCode() == 416 {
+			// S3 rejects any range request for an empty object
+			return nil, 0, nil, nil
+		}
+		if err := WrapS3ObjectArchivedError(err); errors.Is(err, ErrS3ObjectArchived) {
+			// Archived objects cannot be read until they are restored
+			return nil, 0, nil, backoff.Permanent(err)
+		}
+		if IsPermanentS3Error(err) {
+			return nil, 0, nil, backoff.Permanent(err)
+		}
+		return nil, 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	size := resp.ContentLength
+	if resp.ContentRange != nil {
+		size, err = parseContentRangeSize(aws.ToString(resp.ContentRange))
+		if err != nil {
+			return nil, 0, nil, backoff.Permanent(err)
+		}
+	} else if start != 0 {
+		// The range was ignored, so the response does not contain the requested chunk
+		return nil, 0, nil, backoff.Permanent(fmt.Errorf("%w: missing from ranged response",
+			ErrS3ContentRangeInvalid))
+	} else {
+		// The range was ignored, so the response contains the entire object
+		end = size - 1
+	}
+
+	expected := size - start
+	if expected > end-start+1 {
+		expected = end - start + 1
+	}
+	chunk, err := io.ReadAll(io.LimitReader(resp.Body, expected+1))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if int64(len(chunk)) != expected {
+		return nil, 0, nil, fmt.Errorf("%w: expected %d bytes at offset %d but received %d",
+			ErrS3ChunkLengthMismatch, expected, start, len(chunk))
+	}
+	return chunk, size, resp.ETag, nil
+}
+
+// parseContentRangeSize returns the complete length of an object from a Content-Range header
+// value in the form "bytes start-end/size".
+func parseContentRangeSize(contentRange string) (int64, error) {
+	_, sizeStr, found := strings.Cut(contentRange, "/")
+	if !found || !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, fmt.Errorf("%w: %q", ErrS3ContentRangeInvalid, contentRange)
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrS3ContentRangeInvalid, contentRange)
+	}
+	return size, nil
+}
@@ -0,0 +1,638 @@
+package awsHelpers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupS3ForTesting(t *testing.T, bucketName string) *s3.Client {
+	t.Helper()
+
+	// Start the S3 mock server and shut it down when the test ends
+	backend := s3mem.New()
+	faker := gofakes3.New(backend)
+	ts := httptest.NewServer(faker.Server())
+	t.Cleanup(ts.Close)
+
+	cfg, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider("TEST", "TEST", "TESTING"),
+		),
+		config.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}),
+		config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(_, _ string, _ ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: ts.URL}, nil
+			}),
+		),
+	)
+	require.NoError(t, err)
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+	_, err = client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+	require.NoError(t, err)
+	return client
+}
+
+// flakyS3GetObjectAPI records the range of every GetObject request and fails requests
+// according to the configured failures, which are keyed by (1-indexed) request number.
+type flakyS3GetObjectAPI struct {
+	client S3GetObjectAPI
+	ranges []string
+	// failures cause the request to return an error
+	failures map[int]error
+	// truncations cause the response body to be cut short
+	truncations map[int]bool
+}
+
+func (c *flakyS3GetObjectAPI) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.ranges = append(c.ranges, aws.ToString(params.Range))
+	if err, exists := c.failures[len(c.ranges)]; exists {
+		return nil, err
+	}
+	resp, err := c.client.GetObject(ctx, params, optFns...)
+	if err == nil && c.truncations[len(c.ranges)] {
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(b[:len(b)/2]))
+	}
+	return resp, err
+}
+
+func TestChunkedReader(t *testing.T) {
+	const bucket = "test-bucket"
+	const chunkSize = 1024
+	client := setupS3ForTesting(t, bucket)
+
+	putObject := func(t *testing.T, key string, size int) []byte {
+		t.Helper()
+		content := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(content)
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+		return content
+	}
+
+	t.Run("object smaller than one chunk uses a single request", func(t *testing.T) {
+		content := putObject(t, "small", chunkSize/2)
+		flaky := &flakyS3GetObjectAPI{client: client}
+		b, err := io.ReadAll(NewChunkedReader(context.TODO(), flaky, bucket, "small", chunkSize))
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, []string{"bytes=0-1023"}, flaky.ranges)
+	})
+
+	t.Run("multi-chunk object", func(t *testing.T) {
+		content := putObject(t, "large", chunkSize*2+chunkSize/2)
+		flaky := &flakyS3GetObjectAPI{client: client}
+		b, err := io.ReadAll(NewChunkedReader(context.TODO(), flaky, bucket, "large", chunkSize))
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, []string{"bytes=0-1023", "bytes=1024-2047", "bytes=2048-3071"}, flaky.ranges)
+	})
+
+	t.Run("object that is an exact multiple of the chunk size", func(t *testing.T) {
+		content := putObject(t, "exact", chunkSize*2)
+		flaky := &flakyS3GetObjectAPI{client: client}
+		b, err := io.ReadAll(NewChunkedReader(context.TODO(), flaky, bucket, "exact", chunkSize))
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, []string{"bytes=0-1023", "bytes=1024-2047"}, flaky.ranges)
+	})
+
+	t.Run("mid-stream chunk failure is retried without restarting", func(t *testing.T) {
+		content := putObject(t, "flaky", chunkSize*3)
+		flaky := &flakyS3GetObjectAPI{
+			client:      client,
+			failures:    map[int]error{2: errors.New("connection reset by peer")},
+			truncations: map[int]bool{4: true},
+		}
+		r := NewChunkedReader(context.TODO(), flaky, bucket, "flaky", chunkSize)
+		retriedOffsets := []int64{}
+		r.OnRetry = func(err error, offset int64, retryIn time.Duration) {
+			retriedOffsets = append(retriedOffsets, offset)
+		}
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, []string{
+			"bytes=0-1023",
+			"bytes=1024-2047", // fails
+			"bytes=1024-2047",
+			"bytes=2048-3071", // truncated
+			"bytes=2048-3071",
+		}, flaky.ranges)
+		assert.Equal(t, []int64{1024, 2048}, retriedOffsets)
+	})
+
+	t.Run("missing object is not retried", func(t *testing.T) {
+		flaky := &flakyS3GetObjectAPI{client: client}
+		_, err := io.ReadAll(NewChunkedReader(context.TODO(), flaky, bucket, "does-not-exist", chunkSize))
+		var noSuchKey *types.NoSuchKey
+		assert.ErrorAs(t, err, &noSuchKey)
+		assert.Len(t, flaky.ranges, 1)
+	})
+
+	t.Run("archived object is not retried", func(t *testing.T) {
+		putObject(t, "archived", chunkSize)
+		flaky := &flakyS3GetObjectAPI{client: client, failures: map[int]error{
+			1: &types.InvalidObjectState{StorageClass: types.StorageClassGlacier},
+		}}
+		_, err := io.ReadAll(NewChunkedReader(context.TODO(), flaky, bucket, "archived", chunkSize))
+		assert.ErrorIs(t, err, ErrS3ObjectArchived)
+		assert.Len(t, flaky.ranges, 1)
+	})
+
+	t.Run("retries stop when context is canceled", func(t *testing.T) {
+		putObject(t, "canceled", chunkSize)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		flaky := &flakyS3GetObjectAPI{client: client, failures: map[int]error{1: errors.New("oops")}}
+		_, err := io.ReadAll(NewChunkedReader(ctx, flaky, bucket, "canceled", chunkSize))
+		assert.Error(t, err)
+		assert.Len(t, flaky.ranges, 1)
+	})
+}
+
+func TestParseContentRangeSize(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		expSize int64
+		expErr  bool
+	}{
+		{"bytes 0-1023/4096", 4096, false},
+		{"bytes 2048-3071/3072", 3072, false},
+		{"bytes 0-1023/*", 0, true},
+		{"0-1023/4096", 0, true},
+		{"bytes 0-1023", 0, true},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			size, err := parseContentRangeSize(tt.value)
+			if tt.expErr {
+				assert.ErrorIs(t, err, ErrS3ContentRangeInvalid)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expSize, size)
+			}
+		})
+	}
+}
+
+func TestValidateChecksumAlgorithm(t *testing.T) {
+	for _, alg := range []types.ChecksumAlgorithm{
+		types.ChecksumAlgorithmSha256,
+		types.ChecksumAlgorithmCrc32c,
+		DefaultChecksumAlgorithm,
+	} {
+		assert.NoError(t, ValidateChecksumAlgorithm(alg), alg)
+	}
+	assert.ErrorIs(t, ValidateChecksumAlgorithm("MD5"), ErrUnknownChecksumAlgorithm)
+	assert.ErrorIs(t, ValidateChecksumAlgorithm(""), ErrUnknownChecksumAlgorithm)
+}
+
+func TestWrapS3ChecksumError(t *testing.T) {
+	for _, code := range []string{"BadDigest", "XAmzContentChecksumMismatch"} {
+		apiErr := &smithy.GenericAPIError{Code: code}
+		err := WrapS3ChecksumError(fmt.Errorf("operation error S3: PutObject: %w", apiErr))
+		assert.ErrorIs(t, err, ErrS3ChecksumMismatch, code)
+		assert.ErrorIs(t, err, apiErr, code)
+	}
+
+	otherErr := &smithy.GenericAPIError{Code: "AccessDenied"}
+	assert.Equal(t, otherErr, WrapS3ChecksumError(otherErr))
+	assert.Nil(t, WrapS3ChecksumError(nil))
+}
+
+func TestWrapS3ObjectArchivedError(t *testing.T) {
+	archivedErr := &types.InvalidObjectState{StorageClass: types.StorageClassGlacier}
+	err := WrapS3ObjectArchivedError(fmt.Errorf("operation error S3: GetObject: %w", archivedErr))
+	assert.ErrorIs(t, err, ErrS3ObjectArchived)
+	assert.ErrorIs(t, err, archivedErr)
+
+	otherErr := &smithy.GenericAPIError{Code: "AccessDenied"}
+	assert.Equal(t, otherErr, WrapS3ObjectArchivedError(otherErr))
+	assert.Nil(t, WrapS3ObjectArchivedError(nil))
+}
+
+func TestDecompressIfGzipped(t *testing.T) {
+	content := []byte("<Grants><OpportunitySynopsisDetail_1_0/></Grants>")
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for _, tt := range []struct {
+		name           string
+		input          []byte
+		expected       []byte
+		expectedGzip   bool
+		expectedErrMsg string
+	}{
+		{"plain content", content, content, false, ""},
+		{"gzip-compressed content", compressed.Bytes(), content, true, ""},
+		{"empty content", []byte{}, []byte{}, false, ""},
+		{"shorter than gzip header", []byte{0x1f}, []byte{0x1f}, false, ""},
+		{"truncated gzip header", []byte{0x1f, 0x8b, 0x08}, nil, true, "unexpected EOF"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, isGzip, err := DecompressIfGzipped(bytes.NewReader(tt.input))
+			assert.Equal(t, tt.expectedGzip, isGzip)
+			if tt.expectedErrMsg != "" {
+				assert.ErrorContains(t, err, tt.expectedErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			actual, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+// recordingS3Client records the name of every call to the S3 API methods used for copying.
+type recordingS3Client struct {
+	*s3.Client
+	calls []string
+}
+
+func (c *recordingS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.calls = append(c.calls, "GetObject")
+	return c.Client.GetObject(ctx, params, optFns...)
+}
+
+func (c *recordingS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	c.calls = append(c.calls, "PutObject")
+	return c.Client.PutObject(ctx, params, optFns...)
+}
+
+func (c *recordingS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	c.calls = append(c.calls, "CopyObject")
+	return c.Client.CopyObject(ctx, params, optFns...)
+}
+
+func TestCopyS3Object(t *testing.T) {
+	const srcBucket, dstBucket = "source-bucket", "destination-bucket"
+	content := []byte("Subject: Hello\r\n\r\nHello, world!")
+
+	putSourceObject := func(t *testing.T, client *s3.Client, key string) {
+		t.Helper()
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket:      aws.String(srcBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(content),
+			ContentType: aws.String("message/rfc822"),
+		})
+		require.NoError(t, err)
+	}
+	getObject := func(t *testing.T, client *s3.Client, bucket, key string) *s3.GetObjectOutput {
+		t.Helper()
+		resp, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("same region uses server-side copy", func(t *testing.T) {
+		client := setupS3ForTesting(t, srcBucket)
+		_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String(dstBucket)})
+		require.NoError(t, err)
+		putSourceObject(t, client, "sources/2023/04/22/ffis.org/raw.eml")
+
+		recorder := &recordingS3Client{Client: client}
+		require.NoError(t, CopyS3Object(context.TODO(), recorder, recorder,
+			S3ObjectLocation{Region: "us-west-2", Bucket: srcBucket, Key: "sources/2023/04/22/ffis.org/raw.eml"},
+			S3ObjectLocation{Region: "us-west-2", Bucket: dstBucket, Key: "replicas/raw.eml"},
+		))
+		assert.Equal(t, []string{"CopyObject"}, recorder.calls)
+
+		resp := getObject(t, client, dstBucket, "replicas/raw.eml")
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+	})
+
+	t.Run("cross-region streams the object", func(t *testing.T) {
+		srcClient := setupS3ForTesting(t, srcBucket)
+		dstClient := setupS3ForTesting(t, dstBucket)
+		putSourceObject(t, srcClient, "sources/2023/04/22/ffis.org/raw.eml")
+
+		srcRecorder := &recordingS3Client{Client: srcClient}
+		dstRecorder := &recordingS3Client{Client: dstClient}
+		require.NoError(t, CopyS3Object(context.TODO(), srcRecorder, dstRecorder,
+			S3ObjectLocation{Region: "us-west-2", Bucket: srcBucket, Key: "sources/2023/04/22/ffis.org/raw.eml"},
+			S3ObjectLocation{Region: "us-east-1", Bucket: dstBucket, Key: "replicas/raw.eml"},
+		))
+		assert.Equal(t, []string{"GetObject"}, srcRecorder.calls)
+		assert.Equal(t, []string{"PutObject"}, dstRecorder.calls)
+
+		resp := getObject(t, dstClient, dstBucket, "replicas/raw.eml")
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, "message/rfc822", aws.ToString(resp.ContentType))
+	})
+
+	t.Run("cross-region copy of missing object", func(t *testing.T) {
+		srcClient := setupS3ForTesting(t, srcBucket)
+		dstRecorder := &recordingS3Client{Client: setupS3ForTesting(t, dstBucket)}
+		err := CopyS3Object(context.TODO(), srcClient, dstRecorder,
+			S3ObjectLocation{Region: "us-west-2", Bucket: srcBucket, Key: "does/not/exist"},
+			S3ObjectLocation{Region: "us-east-1", Bucket: dstBucket, Key: "replicas/raw.eml"},
+		)
+		var noSuchKey *types.NoSuchKey
+		assert.ErrorAs(t, err, &noSuchKey)
+		assert.Empty(t, dstRecorder.calls)
+	})
+}
+
+func TestMoveS3Object(t *testing.T) {
+	const bucket = "test-bucket"
+	client := setupS3ForTesting(t, bucket)
+	content := []byte("spreadsheet contents")
+	_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("tmp/abc123"),
+		Body:   bytes.NewReader(content),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, MoveS3Object(context.TODO(), client, bucket,
+		"tmp/abc123", "sources/2023/05/01/ffis.org/download.xlsx"))
+
+	resp, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("sources/2023/05/01/ffis.org/download.xlsx"),
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+
+	_, err = client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("tmp/abc123"),
+	})
+	assert.Error(t, err, "Source object should be deleted after moving")
+
+	assert.Error(t, MoveS3Object(context.TODO(), client, bucket, "does/not/exist", "some/key"))
+
+	t.Run("source is kept when destination is unreadable", func(t *testing.T) {
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("tmp/def456"),
+			Body:   bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+		unreadable := &unreadableS3Client{Client: client, unreadableKey: "sources/2023/05/02/ffis.org/download.xlsx"}
+
+		err = MoveS3Object(context.TODO(), unreadable, bucket,
+			"tmp/def456", "sources/2023/05/02/ffis.org/download.xlsx")
+		assert.ErrorIs(t, err, ErrS3ObjectVerificationFailed)
+		assert.Equal(t, 1, unreadable.rangedReads, "destination should be read after its attributes")
+		_, err = client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("tmp/def456"),
+		})
+		assert.NoError(t, err, "Source object should not be deleted")
+	})
+
+	t.Run("source is kept when copy fails", func(t *testing.T) {
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("tmp/ghi789"),
+			Body:   bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+		failing := &failingMoveS3Client{Client: client, copyErr: errors.New("some copy failure")}
+
+		err = MoveS3Object(context.TODO(), failing, bucket, "tmp/ghi789", "extract/extract.xml")
+		assert.ErrorContains(t, err, "some copy failure")
+		assert.Equal(t, 0, failing.deletes)
+	})
+
+	t.Run("delete failure", func(t *testing.T) {
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("tmp/jkl012"),
+			Body:   bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+		failing := &failingMoveS3Client{Client: client, deleteErr: errors.New("some delete failure")}
+
+		err = MoveS3Object(context.TODO(), failing, bucket, "tmp/jkl012", "extract/extract.xml")
+		assert.ErrorContains(t, err, "some delete failure")
+		assert.Equal(t, 1, failing.deletes)
+	})
+
+	t.Run("keys are escaped in the copy source", func(t *testing.T) {
+		const srcKey = "tmp/2023 05 01/extract 1.xml"
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(srcKey),
+			Body:   bytes.NewReader(content),
+		})
+		require.NoError(t, err)
+		failing := &failingMoveS3Client{Client: client}
+
+		require.NoError(t, MoveS3Object(context.TODO(), failing, bucket, srcKey, "extract/escaped.xml"))
+		assert.Equal(t, "test-bucket/tmp/2023%2005%2001/extract%201.xml", failing.copySource)
+	})
+}
+
+// failingMoveS3Client records the copy source of CopyObject requests and optionally fails
+// CopyObject and DeleteObject requests.
+type failingMoveS3Client struct {
+	*s3.Client
+	copyErr, deleteErr error
+	copySource         string
+	deletes            int
+}
+
+func (c *failingMoveS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	c.copySource = aws.ToString(params.CopySource)
+	if c.copyErr != nil {
+		return nil, c.copyErr
+	}
+	return c.Client.CopyObject(ctx, params, optFns...)
+}
+
+func (c *failingMoveS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	c.deletes++
+	if c.deleteErr != nil {
+		return nil, c.deleteErr
+	}
+	return c.Client.DeleteObject(ctx, params, optFns...)
+}
+
+// unreadableS3Client simulates an object whose attributes can be read but whose contents cannot,
+// e.g. because the object is encrypted with a KMS key that the caller may not use.
+type unreadableS3Client struct {
+	*s3.Client
+	unreadableKey string
+	rangedReads   int
+}
+
+func (c *unreadableS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if aws.ToString(params.Key) == c.unreadableKey {
+		if params.Range != nil {
+			c.rangedReads++
+		}
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to use KMS key"}
+	}
+	return c.Client.GetObject(ctx, params, optFns...)
+}
+
+func TestVerifyS3ObjectReadable(t *testing.T) {
+	const bucket = "test-bucket"
+	client := setupS3ForTesting(t, bucket)
+	content := []byte("spreadsheet contents")
+	_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("sources/download.xlsx"),
+		Body:   bytes.NewReader(content),
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, VerifyS3ObjectReadable(context.TODO(), client, bucket,
+		"sources/download.xlsx", int64(len(content)), ""))
+	assert.ErrorIs(t, VerifyS3ObjectReadable(context.TODO(), client, bucket,
+		"sources/download.xlsx", int64(len(content))+1, ""), ErrS3ObjectVerificationFailed)
+	assert.ErrorIs(t, VerifyS3ObjectReadable(context.TODO(), client, bucket,
+		"does/not/exist", 0, ""), ErrS3ObjectVerificationFailed)
+}
+
+func TestListS3Objects(t *testing.T) {
+	const bucket = "test-bucket"
+	client := setupS3ForTesting(t, bucket)
+	expectedKeys := []string{}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("sources/2023/05/%02d/ffis.org/raw.eml", i+1)
+		expectedKeys = append(expectedKeys, key)
+		_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("email")),
+		})
+		require.NoError(t, err)
+	}
+	_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("other/key"),
+		Body:   bytes.NewReader([]byte("other")),
+	})
+	require.NoError(t, err)
+
+	// A small page size ensures that every page of results is followed
+	pagedClient := &pageLimitedS3ListObjectsAPI{client, 2}
+	objects, err := ListS3Objects(context.TODO(), pagedClient, bucket, "sources/")
+	require.NoError(t, err)
+	keys := []string{}
+	for _, obj := range objects {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	assert.Equal(t, expectedKeys, keys)
+
+	objects, err = ListS3Objects(context.TODO(), client, bucket, "nothing/")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+
+	_, err = ListS3Objects(context.TODO(), client, "does-not-exist", "sources/")
+	assert.Error(t, err)
+}
+
+// pageLimitedS3ListObjectsAPI limits the number of keys returned by each ListObjectsV2 request.
+type pageLimitedS3ListObjectsAPI struct {
+	client  s3.ListObjectsV2APIClient
+	maxKeys int32
+}
+
+func (c *pageLimitedS3ListObjectsAPI) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	params.MaxKeys = c.maxKeys
+	return c.client.ListObjectsV2(ctx, params, optFns...)
+}
+
+func TestWithLambdaRequestID(t *testing.T) {
+	lambdaCtx := lambdacontext.NewContext(context.TODO(),
+		&lambdacontext.LambdaContext{AwsRequestID: "abc-123"})
+
+	t.Run("adds request ID from Lambda context", func(t *testing.T) {
+		metadata := map[string]string{"final-url": "https://example.com"}
+		assert.Equal(t, map[string]string{
+			"final-url":         "https://example.com",
+			"lambda-request-id": "abc-123",
+		}, WithLambdaRequestID(lambdaCtx, metadata))
+		assert.Equal(t, map[string]string{"final-url": "https://example.com"}, metadata,
+			"Given metadata should not be modified")
+	})
+
+	t.Run("adds request ID to nil metadata", func(t *testing.T) {
+		assert.Equal(t, map[string]string{"lambda-request-id": "abc-123"},
+			WithLambdaRequestID(lambdaCtx, nil))
+	})
+
+	t.Run("omitted without Lambda context", func(t *testing.T) {
+		assert.Nil(t, WithLambdaRequestID(context.TODO(), nil))
+		assert.Equal(t, map[string]string{"k": "v"},
+			WithLambdaRequestID(context.TODO(), map[string]string{"k": "v"}))
+	})
+}
+
+func TestWithProcessorVersion(t *testing.T) {
+	restoreVersion := ProcessorVersion
+	t.Cleanup(func() { ProcessorVersion = restoreVersion })
+
+	t.Run("adds configured version", func(t *testing.T) {
+		ProcessorVersion = "v1.2.3-4-gabcdef0"
+		metadata := map[string]string{"lambda-request-id": "abc-123"}
+		assert.Equal(t, map[string]string{
+			"lambda-request-id": "abc-123",
+			"processor-version": "v1.2.3-4-gabcdef0",
+		}, WithProcessorVersion(metadata))
+		assert.Equal(t, map[string]string{"lambda-request-id": "abc-123"}, metadata,
+			"Given metadata should not be modified")
+		assert.Equal(t, map[string]string{"processor-version": "v1.2.3-4-gabcdef0"},
+			WithProcessorVersion(nil))
+	})
+
+	t.Run("omitted when version is not configured", func(t *testing.T) {
+		ProcessorVersion = ""
+		assert.Nil(t, WithProcessorVersion(nil))
+		assert.Equal(t, map[string]string{"k": "v"}, WithProcessorVersion(map[string]string{"k": "v"}))
+	})
+}
@@ -0,0 +1,209 @@
+package awsHelpers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+)
+
+// Limits that S3 places on the user-defined metadata and tags of an object
+const (
+	// MaxS3UserMetadataSize is the maximum total size (in bytes) of the keys and values of an
+	// object's user-defined metadata.
+	MaxS3UserMetadataSize = 2 * 1024
+	// MaxS3ObjectTags is the maximum number of tags on an object.
+	MaxS3ObjectTags = 10
+	// MaxS3TagKeyLength is the maximum length (in characters) of a tag key.
+	MaxS3TagKeyLength = 128
+	// MaxS3TagValueLength is the maximum length (in characters) of a tag value.
+	MaxS3TagValueLength = 256
+)
+
+// Actions taken on metadata entries and tags that do not fit within S3's limits
+const (
+	S3AttributeTruncated = "truncated"
+	S3AttributeDropped   = "dropped"
+)
+
+// ErrS3RequiredAttributesExceedLimit is returned when the required metadata entries or tags
+// of an object cannot fit within S3's limits on their own.
+var ErrS3RequiredAttributesExceedLimit = errors.New("required S3 object attributes exceed limit")
+
+// S3AttributeChange describes a metadata entry or tag that was truncated or dropped so that
+// an object's metadata or tags fit within S3's limits.
+type S3AttributeChange struct {
+	Key string
+	// Action is either S3AttributeTruncated or S3AttributeDropped.
+	Action string
+	// OriginalSize is the size (in bytes) of the value before it was changed.
+	OriginalSize int
+}
+
+// S3AttributeBudget determines which metadata entries or tags of an object are kept when they
+// exceed S3's limits, rather than letting the request to write the object fail.
+// Attributes are truncated or dropped in order of ascending priority: first, any attributes
+// whose keys are not listed by Priority (in alphabetical order of their keys), then the
+// attributes listed by Priority, from last to first. Attributes whose keys are listed by
+// Required are never truncated or dropped.
+type S3AttributeBudget struct {
+	// Priority lists attribute keys from highest to lowest priority.
+	Priority []string
+	// Required lists the keys of attributes that must be kept as-is.
+	Required []string
+}
+
+func (b S3AttributeBudget) isRequired(key string) bool {
+	for _, required := range b.Required {
+		if key == required {
+			return true
+		}
+	}
+	return false
+}
+
+// expendable returns the keys that are not required, in the order in which they are truncated
+// or dropped.
+func (b S3AttributeBudget) expendable(keys []string) []string {
+	rank := make(map[string]int, len(b.Priority))
+	for i, key := range b.Priority {
+		if _, ok := rank[key]; !ok {
+			rank[key] = i
+		}
+	}
+	ordered := []string{}
+	for _, key := range keys {
+		if !b.isRequired(key) {
+			ordered = append(ordered, key)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, iRanked := rank[ordered[i]]
+		rj, jRanked := rank[ordered[j]]
+		switch {
+		case iRanked && jRanked:
+			return ri > rj
+		case iRanked != jRanked:
+			return !iRanked
+		}
+		return ordered[i] < ordered[j]
+	})
+	return ordered
+}
+
+// S3MetadataSize returns the size of metadata as measured by S3 against MaxS3UserMetadataSize,
+// i.e. the total number of bytes in the UTF-8 encoding of each key and value.
+func S3MetadataSize(metadata map[string]string) int {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// FitMetadata returns metadata fitted within MaxS3UserMetadataSize, along with the changes that
+// were made to it (if any). Metadata that already fits is returned as-is; otherwise, a copy is
+// made and its expendable entries are visited in order of ascending priority until it fits:
+// each is truncated when removing the excess bytes from the end of its value leaves some of
+// the value, or else dropped.
+// Returns an error wrapping ErrS3RequiredAttributesExceedLimit when the required entries alone
+// exceed the limit.
+func (b S3AttributeBudget) FitMetadata(metadata map[string]string) (map[string]string, []S3AttributeChange, error) {
+	if S3MetadataSize(metadata) <= MaxS3UserMetadataSize {
+		return metadata, []S3AttributeChange{}, nil
+	}
+	fitted := make(map[string]string, len(metadata))
+	keys := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		fitted[k] = v
+		keys = append(keys, k)
+	}
+	changes := []S3AttributeChange{}
+	size := S3MetadataSize(fitted)
+	for _, key := range b.expendable(keys) {
+		if size <= MaxS3UserMetadataSize {
+			break
+		}
+		value := fitted[key]
+		if excess := size - MaxS3UserMetadataSize; excess < len(value) {
+			truncated := truncateUTF8(value, len(value)-excess)
+			if truncated != "" {
+				fitted[key] = truncated
+				size -= len(value) - len(truncated)
+				changes = append(changes, S3AttributeChange{key, S3AttributeTruncated, len(value)})
+				continue
+			}
+		}
+		delete(fitted, key)
+		size -= len(key) + len(value)
+		changes = append(changes, S3AttributeChange{key, S3AttributeDropped, len(value)})
+	}
+	if size > MaxS3UserMetadataSize {
+		return nil, changes, fmt.Errorf("%w: metadata is %d bytes (limit %d)",
+			ErrS3RequiredAttributesExceedLimit, size, MaxS3UserMetadataSize)
+	}
+	return fitted, changes, nil
+}
+
+// FitTags returns a copy of tags that is within S3's limits on tags, along with the changes
+// that were made to it (if any). Only the first value of each tag is kept. Expendable tags
+// whose keys are too long are dropped, and those whose values are too long are truncated;
+// then expendable tags are dropped in order of ascending priority until there are no more
+// than MaxS3ObjectTags. Returns an error wrapping ErrS3RequiredAttributesExceedLimit when
+// a required tag is too long, or when there are too many required tags.
+func (b S3AttributeBudget) FitTags(tags url.Values) (url.Values, []S3AttributeChange, error) {
+	fitted := make(url.Values, len(tags))
+	changes := []S3AttributeChange{}
+	for key, values := range tags {
+		value := ""
+		if len(values) > 0 {
+			value = values[0]
+		}
+		keyTooLong := utf8.RuneCountInString(key) > MaxS3TagKeyLength
+		valueTooLong := utf8.RuneCountInString(value) > MaxS3TagValueLength
+		if (keyTooLong || valueTooLong) && b.isRequired(key) {
+			return nil, changes, fmt.Errorf("%w: tag %q is too long", ErrS3RequiredAttributesExceedLimit, key)
+		}
+		if keyTooLong {
+			changes = append(changes, S3AttributeChange{key, S3AttributeDropped, len(value)})
+			continue
+		}
+		if valueTooLong {
+			changes = append(changes, S3AttributeChange{key, S3AttributeTruncated, len(value)})
+			value = string([]rune(value)[:MaxS3TagValueLength])
+		}
+		fitted.Set(key, value)
+	}
+	// Changes to individual tags are reported in a deterministic order
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	keys := make([]string, 0, len(fitted))
+	for key := range fitted {
+		keys = append(keys, key)
+	}
+	for _, key := range b.expendable(keys) {
+		if len(fitted) <= MaxS3ObjectTags {
+			break
+		}
+		changes = append(changes, S3AttributeChange{key, S3AttributeDropped, len(fitted.Get(key))})
+		fitted.Del(key)
+	}
+	if len(fitted) > MaxS3ObjectTags {
+		return nil, changes, fmt.Errorf("%w: %d tags (limit %d)",
+			ErrS3RequiredAttributesExceedLimit, len(fitted), MaxS3ObjectTags)
+	}
+	return fitted, changes, nil
+}
+
+// truncateUTF8 returns the longest prefix of s that is at most n bytes long and does not end
+// with a partial UTF-8 sequence.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
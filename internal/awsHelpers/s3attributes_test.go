@@ -0,0 +1,160 @@
+package awsHelpers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3AttributeBudgetFitMetadata(t *testing.T) {
+	budget := S3AttributeBudget{
+		Priority: []string{"checksum", "source-key", "edition", "final-url", "redirect-chain"},
+		Required: []string{"checksum", "source-key"},
+	}
+	checksum := strings.Repeat("c", 64)
+	sourceKey := "sources/2023/04/22/ffis.org/raw.eml"
+
+	t.Run("within budget", func(t *testing.T) {
+		metadata := map[string]string{"checksum": checksum, "source-key": sourceKey, "edition": "2023-04-22"}
+		fitted, changes, err := budget.FitMetadata(metadata)
+		require.NoError(t, err)
+		assert.Equal(t, metadata, fitted)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("unlisted entries are fitted first", func(t *testing.T) {
+		metadata := map[string]string{
+			"checksum":       checksum,
+			"source-key":     sourceKey,
+			"edition":        "2023-04-22",
+			"redirect-chain": strings.Repeat("r", 200),
+			"zz-extra":       strings.Repeat("z", 1800),
+			"aa-extra":       strings.Repeat("a", 300),
+		}
+		fitted, changes, err := budget.FitMetadata(metadata)
+		require.NoError(t, err)
+		assert.Equal(t, MaxS3UserMetadataSize, S3MetadataSize(fitted))
+		assert.Equal(t, []S3AttributeChange{
+			{"aa-extra", S3AttributeDropped, 300},
+			{"zz-extra", S3AttributeTruncated, 1800},
+		}, changes)
+		assert.NotContains(t, fitted, "aa-extra")
+		assert.Equal(t, strings.Repeat("z", 1692), fitted["zz-extra"])
+		assert.Equal(t, strings.Repeat("r", 200), fitted["redirect-chain"])
+		assert.Len(t, metadata, 6, "Input should not be modified")
+	})
+
+	t.Run("entries are fitted in order of ascending priority", func(t *testing.T) {
+		metadata := map[string]string{
+			"checksum":       checksum,
+			"source-key":     sourceKey,
+			"edition":        "2023-04-22",
+			"final-url":      "https://example.com/" + strings.Repeat("u", 1980),
+			"redirect-chain": strings.Repeat("r", 100),
+		}
+		fitted, changes, err := budget.FitMetadata(metadata)
+		require.NoError(t, err)
+		assert.Equal(t, []S3AttributeChange{
+			{"redirect-chain", S3AttributeDropped, 100},
+			{"final-url", S3AttributeTruncated, 2000},
+		}, changes)
+		assert.Equal(t, MaxS3UserMetadataSize, S3MetadataSize(fitted))
+		assert.Len(t, fitted["final-url"], 1905)
+		assert.True(t, strings.HasPrefix(metadata["final-url"], fitted["final-url"]))
+		assert.Equal(t, checksum, fitted["checksum"])
+		assert.Equal(t, sourceKey, fitted["source-key"])
+		assert.Equal(t, "2023-04-22", fitted["edition"])
+	})
+
+	t.Run("truncation does not split characters", func(t *testing.T) {
+		metadata := map[string]string{"checksum": checksum, "final-url": strings.Repeat("é", 1100)}
+		fitted, changes, err := budget.FitMetadata(metadata)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		assert.Equal(t, S3AttributeTruncated, changes[0].Action)
+		assert.True(t, strings.HasPrefix(metadata["final-url"], fitted["final-url"]))
+		assert.Equal(t, 0, len(fitted["final-url"])%len("é"))
+		assert.LessOrEqual(t, S3MetadataSize(fitted), MaxS3UserMetadataSize)
+	})
+
+	t.Run("required entries are never dropped", func(t *testing.T) {
+		metadata := map[string]string{
+			"checksum":   checksum,
+			"source-key": strings.Repeat("k", 2100),
+			"edition":    "2023-04-22",
+		}
+		_, changes, err := budget.FitMetadata(metadata)
+		assert.ErrorIs(t, err, ErrS3RequiredAttributesExceedLimit)
+		assert.Equal(t, []S3AttributeChange{{"edition", S3AttributeDropped, 10}}, changes)
+	})
+}
+
+func TestS3AttributeBudgetFitTags(t *testing.T) {
+	budget := S3AttributeBudget{
+		Priority: []string{"checksum", "source-key", "tag-0", "tag-1", "tag-2"},
+		Required: []string{"checksum", "source-key"},
+	}
+
+	t.Run("within budget", func(t *testing.T) {
+		tags := url.Values{"checksum": {"abc"}, "source-key": {"ses/ffis_ingest/new/abc123"}}
+		fitted, changes, err := budget.FitTags(tags)
+		require.NoError(t, err)
+		assert.Equal(t, tags, fitted)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("too many tags", func(t *testing.T) {
+		tags := url.Values{"checksum": {"abc"}, "source-key": {"ses/ffis_ingest/new/abc123"}}
+		for i := 0; i < 12; i++ {
+			tags.Set(fmt.Sprintf("tag-%d", i), "true")
+		}
+		fitted, changes, err := budget.FitTags(tags)
+		require.NoError(t, err)
+		assert.Len(t, fitted, MaxS3ObjectTags)
+		assert.Equal(t, []S3AttributeChange{
+			{"tag-10", S3AttributeDropped, 4},
+			{"tag-11", S3AttributeDropped, 4},
+			{"tag-3", S3AttributeDropped, 4},
+			{"tag-4", S3AttributeDropped, 4},
+		}, changes)
+		for _, key := range []string{"checksum", "source-key", "tag-0", "tag-1", "tag-2"} {
+			assert.Contains(t, fitted, key)
+		}
+	})
+
+	t.Run("long keys and values", func(t *testing.T) {
+		longKey := strings.Repeat("k", MaxS3TagKeyLength+1)
+		tags := url.Values{
+			"checksum": {"abc"},
+			"tag-0":    {strings.Repeat("v", MaxS3TagValueLength+10)},
+			longKey:    {"true"},
+		}
+		fitted, changes, err := budget.FitTags(tags)
+		require.NoError(t, err)
+		assert.Equal(t, []S3AttributeChange{
+			{longKey, S3AttributeDropped, 4},
+			{"tag-0", S3AttributeTruncated, MaxS3TagValueLength + 10},
+		}, changes)
+		assert.Equal(t, strings.Repeat("v", MaxS3TagValueLength), fitted.Get("tag-0"))
+		assert.NotContains(t, fitted, longKey)
+	})
+
+	t.Run("required tags are never dropped", func(t *testing.T) {
+		tags := url.Values{"source-key": {strings.Repeat("k", MaxS3TagValueLength+1)}}
+		_, _, err := budget.FitTags(tags)
+		assert.ErrorIs(t, err, ErrS3RequiredAttributesExceedLimit)
+
+		tags = url.Values{}
+		for i := 0; i < MaxS3ObjectTags+1; i++ {
+			tags.Set(fmt.Sprintf("tag-%d", i), "true")
+		}
+		_, _, err = S3AttributeBudget{Required: []string{
+			"tag-0", "tag-1", "tag-2", "tag-3", "tag-4", "tag-5", "tag-6", "tag-7", "tag-8", "tag-9", "tag-10",
+		}}.FitTags(tags)
+		assert.ErrorIs(t, err, ErrS3RequiredAttributesExceedLimit)
+	})
+}
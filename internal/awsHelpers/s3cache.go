@@ -0,0 +1,163 @@
+package awsHelpers
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsTransport "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Defaults for DefaultS3ObjectCache
+const (
+	DefaultS3CacheTTL           = 5 * time.Minute
+	DefaultS3CacheMaxObjectSize = 1 * MB
+	DefaultS3CacheMaxTotalSize  = 32 * MB
+)
+
+// DefaultS3ObjectCache is the container-wide cache used by GetCached.
+var DefaultS3ObjectCache = NewS3ObjectCache(DefaultS3CacheTTL, DefaultS3CacheMaxObjectSize, DefaultS3CacheMaxTotalSize)
+
+// GetCached returns the contents of the S3 object at key in bucket using DefaultS3ObjectCache.
+// See S3ObjectCache.Get for more information.
+func GetCached(ctx context.Context, client S3GetObjectAPI, bucket, key string) ([]byte, error) {
+	return DefaultS3ObjectCache.Get(ctx, client, bucket, key)
+}
+
+type cachedS3Object struct {
+	location  S3ObjectLocation
+	body      []byte
+	etag      string
+	expiresAt time.Time
+}
+
+// S3ObjectCache caches the contents of small S3 objects in memory, so that objects which are
+// re-read by every invocation (e.g. configuration files and lookup tables) are only downloaded
+// again once they have changed. Cached objects are served without contacting S3 until their TTL
+// elapses, after which they are revalidated with a conditional GetObject request that only
+// downloads the object when its ETag has changed.
+// Objects larger than the maximum object size are never cached, and the least recently used
+// objects are evicted as necessary to keep the combined size of cached objects within the
+// maximum total size. An S3ObjectCache is safe for concurrent use.
+type S3ObjectCache struct {
+	ttl           time.Duration
+	maxObjectSize int64
+	maxTotalSize  int64
+	now           func() time.Time
+
+	mu sync.Mutex
+	// Cached objects, keyed by location, whose elements are ordered from most to least recently used
+	entries map[S3ObjectLocation]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+// NewS3ObjectCache returns an S3ObjectCache that serves cached objects for ttl before revalidating
+// them, and which caches objects of up to maxObjectSize bytes, up to a total of maxTotalSize bytes.
+// A ttl of zero revalidates cached objects on every read.
+func NewS3ObjectCache(ttl time.Duration, maxObjectSize, maxTotalSize int64) *S3ObjectCache {
+	return &S3ObjectCache{
+		ttl:           ttl,
+		maxObjectSize: maxObjectSize,
+		maxTotalSize:  maxTotalSize,
+		now:           time.Now,
+		entries:       make(map[S3ObjectLocation]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// Get returns the contents of the S3 object at key in bucket, from the cache when possible.
+// The returned slice is shared with the cache and other callers, and must not be modified.
+// Concurrent reads of an object that is not cached may each download the object.
+func (c *S3ObjectCache) Get(ctx context.Context, client S3GetObjectAPI, bucket, key string) ([]byte, error) {
+	location := S3ObjectLocation{Bucket: bucket, Key: key}
+	cached, isCached := c.lookup(location)
+	if isCached && c.now().Before(cached.expiresAt) {
+		return cached.body, nil
+	}
+
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if isCached && cached.etag != "" {
+		input.IfNoneMatch = aws.String(cached.etag)
+	}
+	resp, err := client.GetObject(ctx, input)
+	if err != nil {
+		if isCached && isS3NotModified(err) {
+			c.revalidate(location, cached.etag)
+			return cached.body, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.store(location, body, aws.ToString(resp.ETag))
+	return body, nil
+}
+
+// lookup returns a copy of the cache entry for location, marking it as most recently used.
+func (c *S3ObjectCache) lookup(location S3ObjectLocation) (cachedS3Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[location]
+	if !ok {
+		return cachedS3Object{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return *elem.Value.(*cachedS3Object), true
+}
+
+// revalidate extends the TTL of the cache entry for location, unless the entry has been
+// replaced by a version of the object with a different ETag in the meantime.
+func (c *S3ObjectCache) revalidate(location S3ObjectLocation, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[location]; ok {
+		if entry := elem.Value.(*cachedS3Object); entry.etag == etag {
+			entry.expiresAt = c.now().Add(c.ttl)
+		}
+	}
+}
+
+// store caches body as the contents of the object at location, replacing any previously-cached
+// contents, and evicts the least recently used objects as necessary to stay within the maximum
+// total size. Objects that exceed the maximum object size are not cached.
+func (c *S3ObjectCache) store(location S3ObjectLocation, body []byte, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[location]; ok {
+		c.remove(elem)
+	}
+	size := int64(len(body))
+	if size > c.maxObjectSize || size > c.maxTotalSize {
+		return
+	}
+	for c.size+size > c.maxTotalSize {
+		c.remove(c.lru.Back())
+	}
+	entry := &cachedS3Object{location: location, body: body, etag: etag, expiresAt: c.now().Add(c.ttl)}
+	c.entries[location] = c.lru.PushFront(entry)
+	c.size += size
+}
+
+// remove evicts the cache entry held by elem. The caller must hold c.mu.
+func (c *S3ObjectCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedS3Object)
+	delete(c.entries, entry.location)
+	c.size -= int64(len(entry.body))
+}
+
+// isS3NotModified reports whether err is the response to a conditional request for an object
+// that has not changed.
+func isS3NotModified(err error) bool {
+	var respErr *awsTransport.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified
+}
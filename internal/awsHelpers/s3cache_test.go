@@ -0,0 +1,187 @@
+package awsHelpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsTransport "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conditionalS3GetObjectAPI serves objects from memory, honoring If-None-Match conditions
+// as S3 does, and counts requests and downloads by key.
+type conditionalS3GetObjectAPI struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	requests  map[string]int
+	downloads map[string]int
+}
+
+func newConditionalS3GetObjectAPI(objects map[string][]byte) *conditionalS3GetObjectAPI {
+	return &conditionalS3GetObjectAPI{
+		objects:   objects,
+		requests:  make(map[string]int),
+		downloads: make(map[string]int),
+	}
+}
+
+func (c *conditionalS3GetObjectAPI) put(key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[key] = body
+}
+
+func (c *conditionalS3GetObjectAPI) counts(key string) (requests, downloads int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests[key], c.downloads[key]
+}
+
+func (c *conditionalS3GetObjectAPI) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := aws.ToString(params.Key)
+	c.requests[key]++
+	body, ok := c.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no such key %q", key)
+	}
+	sum := md5.Sum(body)
+	etag := fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+	if aws.ToString(params.IfNoneMatch) == etag {
+		return nil, &awsTransport.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{Response: &smithyhttp.Response{
+				Response: &http.Response{StatusCode: http.StatusNotModified},
+			}},
+		}
+	}
+	c.downloads[key]++
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		ETag:          aws.String(etag),
+	}, nil
+}
+
+func TestS3ObjectCache(t *testing.T) {
+	setup := func(t *testing.T, maxObjectSize, maxTotalSize int64) (*S3ObjectCache, *conditionalS3GetObjectAPI, *time.Time) {
+		t.Helper()
+		client := newConditionalS3GetObjectAPI(map[string][]byte{
+			"a": []byte("aaaa"),
+			"b": []byte("bbbb"),
+			"c": []byte("cccc"),
+		})
+		cache := NewS3ObjectCache(5*time.Minute, maxObjectSize, maxTotalSize)
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+		return cache, client, &now
+	}
+	get := func(t *testing.T, cache *S3ObjectCache, client S3GetObjectAPI, key string) string {
+		t.Helper()
+		body, err := cache.Get(context.TODO(), client, "test-bucket", key)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("miss then hit", func(t *testing.T) {
+		cache, client, _ := setup(t, 1024, 1024)
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		requests, downloads := client.counts("a")
+		assert.Equal(t, 1, requests, "hit before TTL elapses should not contact S3")
+		assert.Equal(t, 1, downloads)
+	})
+
+	t.Run("unchanged object revalidated after TTL", func(t *testing.T) {
+		cache, client, now := setup(t, 1024, 1024)
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		*now = now.Add(6 * time.Minute)
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		requests, downloads := client.counts("a")
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, 1, downloads, "304 revalidation should not re-download")
+
+		// Revalidation renews the TTL
+		*now = now.Add(4 * time.Minute)
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		requests, _ = client.counts("a")
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("changed object refreshed after TTL", func(t *testing.T) {
+		cache, client, now := setup(t, 1024, 1024)
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		client.put("a", []byte("AAAA"))
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"), "cached contents should be served before TTL elapses")
+		*now = now.Add(6 * time.Minute)
+		assert.Equal(t, "AAAA", get(t, cache, client, "a"))
+		assert.Equal(t, "AAAA", get(t, cache, client, "a"))
+		requests, downloads := client.counts("a")
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, 2, downloads)
+	})
+
+	t.Run("oversized objects are not cached", func(t *testing.T) {
+		cache, client, _ := setup(t, 3, 1024)
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		assert.Equal(t, "aaaa", get(t, cache, client, "a"))
+		_, downloads := client.counts("a")
+		assert.Equal(t, 2, downloads)
+		assert.Zero(t, cache.size)
+	})
+
+	t.Run("least recently used objects are evicted", func(t *testing.T) {
+		cache, client, _ := setup(t, 1024, 8)
+		get(t, cache, client, "a")
+		get(t, cache, client, "b")
+		get(t, cache, client, "a")
+		get(t, cache, client, "c") // evicts b, which is less recently used than a
+		assert.Equal(t, int64(8), cache.size)
+
+		get(t, cache, client, "a")
+		get(t, cache, client, "c")
+		get(t, cache, client, "b")
+		for key, expected := range map[string]int{"a": 1, "b": 2, "c": 1} {
+			_, downloads := client.counts(key)
+			assert.Equal(t, expected, downloads, "unexpected downloads of %q", key)
+		}
+		assert.LessOrEqual(t, cache.size, int64(8))
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		cache, client, _ := setup(t, 1024, 1024)
+		_, err := cache.Get(context.TODO(), client, "test-bucket", "missing")
+		assert.Error(t, err)
+		client.put("missing", []byte("found"))
+		assert.Equal(t, "found", get(t, cache, client, "missing"))
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		cache, client, _ := setup(t, 1024, 8)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			key := []string{"a", "b", "c"}[i%3]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				body, err := cache.Get(context.TODO(), client, "test-bucket", key)
+				assert.NoError(t, err)
+				assert.Equal(t, key+key+key+key, string(body))
+			}()
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, cache.size, int64(8))
+	})
+}
@@ -0,0 +1,268 @@
+package awsHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretReferenceScheme is the prefix that identifies an environment value as a reference
+// to a Secrets Manager secret rather than a literal value.
+const SecretReferenceScheme = "secretsmanager://"
+
+// RedactedValue replaces secret values whenever a configuration struct is rendered for logging.
+const RedactedValue = "[REDACTED]"
+
+// RedactTag is the struct tag that marks a configuration field as always secret, e.g. a webhook
+// URL that embeds a token, so that Redact replaces its value even when it was not resolved from
+// a secret reference.
+const RedactTag = "redact"
+
+var (
+	ErrSecretResolution  = errors.New("failed to resolve secret")
+	ErrSecretNotString   = errors.New("secret has no string value")
+	ErrSecretNotJSON     = errors.New("secret value is not a JSON object")
+	ErrSecretKeyNotFound = errors.New("key not found in secret")
+)
+
+// SecretsManagerGetSecretValueAPI is the interface for retrieving secret values from Secrets Manager
+type SecretsManagerGetSecretValueAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretReference identifies a secret (and optionally a key within a JSON secret) to resolve.
+type SecretReference struct {
+	Name    string
+	JSONKey string
+}
+
+func (ref SecretReference) String() string {
+	if ref.JSONKey == "" {
+		return ref.Name
+	}
+	return fmt.Sprintf("%s#%s", ref.Name, ref.JSONKey)
+}
+
+// ParseSecretReference parses a value in the form "secretsmanager://name#jsonKey", where the
+// "#jsonKey" suffix is optional. Returns false if the value is not a secret reference.
+func ParseSecretReference(value string) (SecretReference, bool) {
+	if !strings.HasPrefix(value, SecretReferenceScheme) {
+		return SecretReference{}, false
+	}
+	name, key, _ := strings.Cut(strings.TrimPrefix(value, SecretReferenceScheme), "#")
+	if name == "" {
+		return SecretReference{}, false
+	}
+	return SecretReference{Name: name, JSONKey: key}, true
+}
+
+type cachedSecret struct {
+	value     string
+	expiresAt time.Time
+}
+
+// SecretsResolver resolves secret references using Secrets Manager. Retrieved secret values
+// are cached in memory for the configured TTL so that warm invocations avoid redundant
+// GetSecretValue calls while still picking up rotated secrets once the TTL elapses.
+type SecretsResolver struct {
+	client SecretsManagerGetSecretValueAPI
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu sync.Mutex
+	// Cached secret values, keyed by secret name
+	cache map[string]cachedSecret
+	// References discovered by ResolveEnvironment, keyed by struct field name
+	fieldRefs map[string]SecretReference
+	// Every resolved value, used to redact secrets from logged configuration
+	resolved map[string]struct{}
+}
+
+// NewSecretsResolver returns a SecretsResolver that caches secret values for ttl.
+// A ttl of zero disables caching.
+func NewSecretsResolver(c SecretsManagerGetSecretValueAPI, ttl time.Duration) *SecretsResolver {
+	return &SecretsResolver{
+		client:    c,
+		ttl:       ttl,
+		now:       time.Now,
+		cache:     make(map[string]cachedSecret),
+		fieldRefs: make(map[string]SecretReference),
+		resolved:  make(map[string]struct{}),
+	}
+}
+
+// Resolve returns the plaintext value identified by value when it is a secret reference,
+// or else returns value unchanged.
+// Returned errors name the secret reference but never include any part of the secret value.
+func (r *SecretsResolver) Resolve(ctx context.Context, value string) (string, error) {
+	ref, ok := ParseSecretReference(value)
+	if !ok {
+		return value, nil
+	}
+	return r.resolveReference(ctx, ref)
+}
+
+func (r *SecretsResolver) resolveReference(ctx context.Context, ref SecretReference) (string, error) {
+	secret, err := r.getSecretString(ctx, ref.Name)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %w", ErrSecretResolution, ref, err)
+	}
+
+	value := secret
+	if ref.JSONKey != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+			// The JSON error is intentionally discarded since it may contain secret content
+			return "", fmt.Errorf("%w %q: %w", ErrSecretResolution, ref, ErrSecretNotJSON)
+		}
+		v, exists := fields[ref.JSONKey]
+		if !exists {
+			return "", fmt.Errorf("%w %q: %w", ErrSecretResolution, ref, ErrSecretKeyNotFound)
+		}
+		if s, isString := v.(string); isString {
+			value = s
+		} else {
+			value = fmt.Sprint(v)
+		}
+	}
+
+	r.mu.Lock()
+	r.resolved[value] = struct{}{}
+	r.mu.Unlock()
+	return value, nil
+}
+
+func (r *SecretsResolver) getSecretString(ctx context.Context, name string) (string, error) {
+	r.mu.Lock()
+	cached, isCached := r.cache[name]
+	r.mu.Unlock()
+	if isCached && r.now().Before(cached.expiresAt) {
+		return cached.value, nil
+	}
+
+	resp, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.SecretString == nil {
+		return "", ErrSecretNotString
+	}
+
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[name] = cachedSecret{value: *resp.SecretString, expiresAt: r.now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return *resp.SecretString, nil
+}
+
+// ResolveEnvironment replaces the value of every exported string field in the struct pointed to
+// by env that holds a secret reference with its resolved secret value.
+// References are remembered by field name, so calling ResolveEnvironment again (e.g. at the
+// start of each warm invocation) refreshes the fields from the cache or, once the TTL has
+// elapsed, from Secrets Manager.
+func (r *SecretsResolver) ResolveEnvironment(ctx context.Context, env interface{}) error {
+	v := reflect.ValueOf(env)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected pointer to struct but got %T", env)
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() || field.Type.Kind() != reflect.String {
+			continue
+		}
+		if ref, ok := ParseSecretReference(v.Field(i).String()); ok {
+			r.mu.Lock()
+			r.fieldRefs[field.Name] = ref
+			r.mu.Unlock()
+		}
+	}
+
+	r.mu.Lock()
+	fieldRefs := make(map[string]SecretReference, len(r.fieldRefs))
+	for name, ref := range r.fieldRefs {
+		fieldRefs[name] = ref
+	}
+	r.mu.Unlock()
+
+	for name, ref := range fieldRefs {
+		value, err := r.resolveReference(ctx, ref)
+		if err != nil {
+			return err
+		}
+		v.FieldByName(name).SetString(value)
+	}
+	return nil
+}
+
+// Redact returns a map of the exported fields of the struct (or pointer to struct) env
+// that is suitable for logging. Values of fields that were resolved from secret references,
+// as well as any remaining unresolved secret references, are replaced by RedactedValue
+// (in addition to the fields that are redacted by the package-level Redact function).
+func (r *SecretsResolver) Redact(env interface{}) map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return redactFields(env, func(field reflect.StructField, value interface{}) bool {
+		if _, isRef := r.fieldRefs[field.Name]; isRef {
+			return true
+		}
+		if s, isString := value.(string); isString {
+			_, isSecret := r.resolved[s]
+			return isSecret
+		}
+		return false
+	})
+}
+
+// Redact returns a map of the exported fields of the struct (or pointer to struct) env
+// that is suitable for logging, for configurations that are not resolved by a SecretsResolver.
+// Values of fields tagged with `redact:"true"` (unless they are empty) and of fields that are
+// unresolved secret references are replaced by RedactedValue. Fields holding a goenv.EnvSet
+// are always redacted, since the full process environment includes AWS credentials.
+func Redact(env interface{}) map[string]interface{} {
+	return redactFields(env, func(reflect.StructField, interface{}) bool { return false })
+}
+
+// redactFields implements Redact, additionally redacting the values of fields for which
+// isSecret returns true.
+func redactFields(env interface{}, isSecret func(field reflect.StructField, value interface{}) bool) map[string]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(env))
+	redacted := make(map[string]interface{})
+	if v.Kind() != reflect.Struct {
+		return redacted
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if _, isEnvSet := value.(goenv.EnvSet); isEnvSet {
+			value = RedactedValue
+		} else if isSecret(field, value) {
+			value = RedactedValue
+		} else if field.Tag.Get(RedactTag) == "true" && !v.Field(i).IsZero() {
+			value = RedactedValue
+		} else if s, isString := value.(string); isString {
+			if _, isRef := ParseSecretReference(s); isRef {
+				value = RedactedValue
+			}
+		}
+		redacted[field.Name] = value
+	}
+	return redacted
+}
@@ -0,0 +1,207 @@
+package awsHelpers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	goenv "github.com/Netflix/go-env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSecretsManager struct {
+	secrets map[string]string
+	calls   map[string]int
+}
+
+func (m *mockSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	name := aws.ToString(params.SecretId)
+	if m.calls == nil {
+		m.calls = make(map[string]int)
+	}
+	m.calls[name]++
+	secret, exists := m.secrets[name]
+	if !exists {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(secret)}, nil
+}
+
+func TestParseSecretReference(t *testing.T) {
+	for _, tt := range []struct {
+		value  string
+		expRef SecretReference
+		expOK  bool
+	}{
+		{"secretsmanager://my-secret", SecretReference{Name: "my-secret"}, true},
+		{"secretsmanager://my-secret#apiKey", SecretReference{Name: "my-secret", JSONKey: "apiKey"}, true},
+		{"secretsmanager://", SecretReference{}, false},
+		{"plain-value", SecretReference{}, false},
+		{"ssm://my-param", SecretReference{}, false},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			ref, ok := ParseSecretReference(tt.value)
+			assert.Equal(t, tt.expOK, ok)
+			assert.Equal(t, tt.expRef, ref)
+		})
+	}
+}
+
+func TestSecretsResolverResolve(t *testing.T) {
+	client := &mockSecretsManager{secrets: map[string]string{
+		"plain": "s3cr3t-plain",
+		"json":  `{"apiKey": "s3cr3t-json", "port": 8080}`,
+	}}
+	r := NewSecretsResolver(client, time.Minute)
+
+	t.Run("plain-string secret", func(t *testing.T) {
+		value, err := r.Resolve(context.TODO(), "secretsmanager://plain")
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t-plain", value)
+	})
+	t.Run("JSON secret", func(t *testing.T) {
+		value, err := r.Resolve(context.TODO(), "secretsmanager://json#apiKey")
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t-json", value)
+		value, err = r.Resolve(context.TODO(), "secretsmanager://json#port")
+		require.NoError(t, err)
+		assert.Equal(t, "8080", value)
+	})
+	t.Run("missing JSON key", func(t *testing.T) {
+		_, err := r.Resolve(context.TODO(), "secretsmanager://json#missing")
+		assert.ErrorIs(t, err, ErrSecretResolution)
+		assert.ErrorIs(t, err, ErrSecretKeyNotFound)
+		assert.ErrorContains(t, err, "json#missing")
+		assert.NotContains(t, err.Error(), "s3cr3t")
+	})
+	t.Run("key requested from non-JSON secret", func(t *testing.T) {
+		_, err := r.Resolve(context.TODO(), "secretsmanager://plain#apiKey")
+		assert.ErrorIs(t, err, ErrSecretNotJSON)
+		assert.NotContains(t, err.Error(), "s3cr3t")
+	})
+	t.Run("missing secret", func(t *testing.T) {
+		_, err := r.Resolve(context.TODO(), "secretsmanager://does-not-exist")
+		assert.ErrorIs(t, err, ErrSecretResolution)
+		assert.ErrorContains(t, err, "does-not-exist")
+	})
+	t.Run("non-reference values are returned unchanged", func(t *testing.T) {
+		value, err := r.Resolve(context.TODO(), "just-a-value")
+		require.NoError(t, err)
+		assert.Equal(t, "just-a-value", value)
+	})
+}
+
+func TestSecretsResolverCacheExpiry(t *testing.T) {
+	client := &mockSecretsManager{secrets: map[string]string{"rotating": "first"}}
+	r := NewSecretsResolver(client, 5*time.Minute)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	value, err := r.Resolve(context.TODO(), "secretsmanager://rotating")
+	require.NoError(t, err)
+	assert.Equal(t, "first", value)
+
+	client.secrets["rotating"] = "second"
+	now = now.Add(4 * time.Minute)
+	value, err = r.Resolve(context.TODO(), "secretsmanager://rotating")
+	require.NoError(t, err)
+	assert.Equal(t, "first", value, "Cached value should be used before TTL elapses")
+	assert.Equal(t, 1, client.calls["rotating"])
+
+	now = now.Add(2 * time.Minute)
+	value, err = r.Resolve(context.TODO(), "secretsmanager://rotating")
+	require.NoError(t, err)
+	assert.Equal(t, "second", value, "Secret should be re-fetched after TTL elapses")
+	assert.Equal(t, 2, client.calls["rotating"])
+}
+
+func TestSecretsResolverResolveEnvironment(t *testing.T) {
+	type testEnvironment struct {
+		LogLevel string
+		APIKey   string
+		Token    string
+		Count    int
+	}
+	client := &mockSecretsManager{secrets: map[string]string{
+		"api": `{"key": "s3cr3t-key"}`,
+		"tok": "s3cr3t-token",
+	}}
+	r := NewSecretsResolver(client, time.Minute)
+
+	env := testEnvironment{
+		LogLevel: "INFO",
+		APIKey:   "secretsmanager://api#key",
+		Token:    "secretsmanager://tok",
+		Count:    3,
+	}
+	require.NoError(t, r.ResolveEnvironment(context.TODO(), &env))
+	assert.Equal(t, "s3cr3t-key", env.APIKey)
+	assert.Equal(t, "s3cr3t-token", env.Token)
+	assert.Equal(t, "INFO", env.LogLevel)
+
+	// Subsequent (warm) resolution re-uses remembered references and cached values
+	require.NoError(t, r.ResolveEnvironment(context.TODO(), &env))
+	assert.Equal(t, "s3cr3t-key", env.APIKey)
+	assert.Equal(t, 1, client.calls["api"])
+
+	redacted := r.Redact(env)
+	assert.Equal(t, RedactedValue, redacted["APIKey"])
+	assert.Equal(t, RedactedValue, redacted["Token"])
+	assert.Equal(t, "INFO", redacted["LogLevel"])
+	assert.Equal(t, 3, redacted["Count"])
+
+	t.Run("failure names the secret", func(t *testing.T) {
+		env := testEnvironment{APIKey: "secretsmanager://missing#key"}
+		err := NewSecretsResolver(client, time.Minute).ResolveEnvironment(context.TODO(), &env)
+		assert.ErrorIs(t, err, ErrSecretResolution)
+		assert.ErrorContains(t, err, "missing#key")
+	})
+}
+
+func TestRedact(t *testing.T) {
+	type testEnvironment struct {
+		LogLevel   string
+		WebhookURL string `redact:"true"`
+		SentryDSN  string `redact:"true"`
+		Token      string
+		Extras     map[string]string `redact:"true"`
+		unexported string
+	}
+	redacted := Redact(testEnvironment{
+		LogLevel:   "INFO",
+		WebhookURL: "https://hooks.example.com/s3cr3t",
+		Token:      "secretsmanager://tok",
+		Extras:     map[string]string{"AWS_SESSION_TOKEN": "s3cr3t"},
+		unexported: "hidden",
+	})
+	assert.Equal(t, map[string]interface{}{
+		"LogLevel":   "INFO",
+		"WebhookURL": RedactedValue,
+		"SentryDSN":  "",
+		"Token":      RedactedValue,
+		"Extras":     RedactedValue,
+	}, redacted, "Empty tagged fields should remain empty to show that they are not configured")
+	assert.Empty(t, Redact("not a struct"))
+
+	t.Run("environment extras are always redacted", func(t *testing.T) {
+		type testEnvironment struct {
+			LogLevel string
+			Extras   goenv.EnvSet
+		}
+		env := testEnvironment{
+			LogLevel: "INFO",
+			Extras: goenv.EnvSet{
+				"AWS_SECRET_ACCESS_KEY": "s3cr3t",
+				"AWS_SESSION_TOKEN":     "s3cr3t",
+			},
+		}
+		assert.Equal(t, RedactedValue, Redact(env)["Extras"])
+		resolver := NewSecretsResolver(&mockSecretsManager{}, time.Minute)
+		assert.Equal(t, RedactedValue, resolver.Redact(env)["Extras"])
+		assert.Equal(t, "INFO", resolver.Redact(env)["LogLevel"])
+	})
+}
@@ -0,0 +1,116 @@
+// Package configHelpers provides configuration that is shared by multiple Lambda handlers.
+package configHelpers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// Feature names a stage of the ingestion pipeline that can be disabled with DISABLED_FEATURES.
+// Handlers for the same stage of different sources share a feature, so that e.g. disabling
+// "persist" stops persistence of both FFIS and Grants.gov data.
+type Feature string
+
+const (
+	FeatureReceiveEmail    Feature = "receive_email"
+	FeatureEnqueueDownload Feature = "enqueue_download"
+	FeatureDownload        Feature = "download"
+	FeatureExtract         Feature = "extract"
+	FeatureSplit           Feature = "split"
+	FeaturePersist         Feature = "persist"
+	FeaturePublishEvents   Feature = "publish_events"
+	FeaturePublishFeed     Feature = "publish_feed"
+	// FeatureNotifySubscribers disables webhook notifications to external subscribers.
+	FeatureNotifySubscribers Feature = "notify_subscribers"
+	// FeatureReconcileRepair disables the re-triggering of stalled pipeline stages by
+	// ReconcileFFISPipeline, which continues to report gaps.
+	FeatureReconcileRepair Feature = "reconcile_repair"
+)
+
+// KnownFeatures lists every feature that may be named by DISABLED_FEATURES.
+var KnownFeatures = []Feature{
+	FeatureReceiveEmail,
+	FeatureEnqueueDownload,
+	FeatureDownload,
+	FeatureExtract,
+	FeatureSplit,
+	FeaturePersist,
+	FeaturePublishEvents,
+	FeaturePublishFeed,
+	FeatureNotifySubscribers,
+	FeatureReconcileRepair,
+}
+
+// SkippedDisabledMetric is the metric that counts records (or events, for handlers that are not
+// record-oriented) which were skipped because the handler's stage is disabled.
+const SkippedDisabledMetric = "stage.skipped_disabled"
+
+var ErrUnknownFeature = errors.New("unknown feature")
+
+// FeatureSet is a set of features. The zero value is an empty set.
+type FeatureSet map[Feature]bool
+
+// ParseDisabledFeatures parses the comma-separated list of feature names given by the
+// DISABLED_FEATURES environment variable, e.g. "enqueue_download,persist".
+// Names are not case-sensitive and blank items are ignored.
+// Returns an error wrapping ErrUnknownFeature if any name is not one of KnownFeatures,
+// since a misspelled name would otherwise leave a stage running during an incident.
+func ParseDisabledFeatures(names string) (FeatureSet, error) {
+	known := make(map[Feature]bool, len(KnownFeatures))
+	for _, feature := range KnownFeatures {
+		known[feature] = true
+	}
+	features := FeatureSet{}
+	unknown := []string{}
+	for _, name := range strings.Split(names, ",") {
+		feature := Feature(strings.ToLower(strings.TrimSpace(name)))
+		if feature == "" {
+			continue
+		}
+		if !known[feature] {
+			unknown = append(unknown, string(feature))
+			continue
+		}
+		features[feature] = true
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFeature, strings.Join(unknown, ","))
+	}
+	return features, nil
+}
+
+// Names returns the names of the features in the set, in alphabetical order.
+func (s FeatureSet) Names() []string {
+	names := make([]string, 0, len(s))
+	for feature, inSet := range s {
+		if inSet {
+			names = append(names, string(feature))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Disabled returns true when feature is in the set.
+func (s FeatureSet) Disabled(feature Feature) bool {
+	return s[feature]
+}
+
+// SkipIfDisabled returns true when feature is in the set, in which case the handler should
+// return successfully without side effects so that queued events are drained instead of retried.
+// The skip is logged as a warning and count is added to SkippedDisabledMetric with sendMetric.
+func (s FeatureSet) SkipIfDisabled(logger log.Logger, sendMetric func(metric string, value float64, tags ...string),
+	feature Feature, count int) bool {
+	if !s.Disabled(feature) {
+		return false
+	}
+	log.Warn(logger, "STAGE DISABLED: skipping processing because this stage is listed in DISABLED_FEATURES",
+		"feature", feature, "count_skipped", count)
+	sendMetric(SkippedDisabledMetric, float64(count), fmt.Sprintf("feature:%s", feature))
+	return true
+}
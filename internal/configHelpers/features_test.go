@@ -0,0 +1,74 @@
+package configHelpers
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDisabledFeatures(t *testing.T) {
+	for _, tt := range []struct {
+		names    string
+		expected FeatureSet
+	}{
+		{"", FeatureSet{}},
+		{" , ", FeatureSet{}},
+		{"persist", FeatureSet{FeaturePersist: true}},
+		{"enqueue_download, PERSIST,", FeatureSet{FeatureEnqueueDownload: true, FeaturePersist: true}},
+		{"notify_subscribers,reconcile_repair",
+			FeatureSet{FeatureNotifySubscribers: true, FeatureReconcileRepair: true}},
+	} {
+		t.Run(tt.names, func(t *testing.T) {
+			features, err := ParseDisabledFeatures(tt.names)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, features)
+		})
+	}
+
+	t.Run("names", func(t *testing.T) {
+		features, err := ParseDisabledFeatures("persist,enqueue_download")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"enqueue_download", "persist"}, features.Names())
+		assert.Equal(t, []string{}, FeatureSet(nil).Names())
+	})
+
+	t.Run("unknown features", func(t *testing.T) {
+		features, err := ParseDisabledFeatures("persist,persistence,enqueue")
+		assert.ErrorIs(t, err, ErrUnknownFeature)
+		assert.ErrorContains(t, err, "enqueue,persistence")
+		assert.Nil(t, features)
+	})
+
+	t.Run("every known feature", func(t *testing.T) {
+		names := ""
+		for _, feature := range KnownFeatures {
+			names += string(feature) + ","
+		}
+		features, err := ParseDisabledFeatures(names)
+		require.NoError(t, err)
+		assert.Len(t, features, len(KnownFeatures))
+	})
+}
+
+func TestSkipIfDisabled(t *testing.T) {
+	type sentMetric struct {
+		name  string
+		value float64
+		tags  []string
+	}
+	sent := []sentMetric{}
+	sendMetric := func(metric string, value float64, tags ...string) {
+		sent = append(sent, sentMetric{metric, value, tags})
+	}
+	features := FeatureSet{FeaturePersist: true}
+
+	assert.False(t, features.SkipIfDisabled(log.NewNopLogger(), sendMetric, FeatureSplit, 3))
+	assert.Empty(t, sent)
+	assert.False(t, FeatureSet(nil).SkipIfDisabled(log.NewNopLogger(), sendMetric, FeaturePersist, 3))
+	assert.Empty(t, sent)
+
+	assert.True(t, features.SkipIfDisabled(log.NewNopLogger(), sendMetric, FeaturePersist, 3))
+	assert.Equal(t, []sentMetric{{"stage.skipped_disabled", 3, []string{"feature:persist"}}}, sent)
+}
@@ -0,0 +1,178 @@
+package ddHelpers
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMetricBufferSize is the number of metric points that may await sending before
+	// further points are dropped.
+	DefaultMetricBufferSize = 1024
+	// DefaultMetricFlushTimeout limits how long FlushMetrics waits for buffered metric points
+	// to be sent, so that a slow metrics destination cannot significantly delay an invocation.
+	DefaultMetricFlushTimeout = 500 * time.Millisecond
+	// DefaultMetricSendTimeout limits how long the background goroutine waits for each metric
+	// point to be sent, so that an unresponsive metrics destination cannot stall the buffer.
+	DefaultMetricSendTimeout = 50 * time.Millisecond
+)
+
+var (
+	// droppedMetricsMetric counts the metric points that were dropped because the buffer was full.
+	droppedMetricsMetric = fmt.Sprintf("%s.metrics.dropped", ServiceNamespace)
+	// selfErrorsMetric counts the metric points that could not be sent because the sink
+	// returned an error or timed out.
+	selfErrorsMetric = fmt.Sprintf("%s.metrics.self.errors", ServiceNamespace)
+)
+
+var (
+	ErrMetricFlushTimeout = errors.New("timed out flushing buffered metrics")
+	ErrMetricSendTimeout  = errors.New("timed out sending metric")
+)
+
+// metricPoint is a single metric value awaiting sending. A point with a non-nil flushed channel
+// is instead a marker, which is closed once every point enqueued before it has been sent.
+type metricPoint struct {
+	metric  string
+	value   float64
+	tags    []string
+	flushed chan struct{}
+}
+
+// metricSink sends a single metric point to a metrics destination.
+type metricSink func(metric string, value float64, tags ...string) error
+
+// bufferedMetricSender decouples emitting metrics from sending them over the network.
+// Metric points are enqueued onto a bounded buffer, from which a background goroutine sends
+// them to sink, so that a slow or unreachable metrics destination never blocks the caller.
+// When the buffer is full, points are dropped (and counted) rather than waited upon.
+// Errors returned by sink, and sends that take longer than sendTimeout, are counted rather than
+// reported to the caller, so that emitting metrics can never affect the outcome of the work
+// being measured.
+type bufferedMetricSender struct {
+	sink        metricSink
+	sendTimeout time.Duration
+	points      chan metricPoint
+	dropped     atomic.Int64
+
+	// Points that could not be sent since the last flush, which are counted by selfErrorsMetric
+	failed atomic.Int64
+	// Points that could not be sent and have not yet been reported by takeSendErrors
+	unreported atomic.Int64
+	lastErr    atomic.Value
+	// Set while a call to sink is in progress, including after it has timed out
+	sending atomic.Bool
+}
+
+// newBufferedMetricSender starts a bufferedMetricSender that buffers up to size points
+// and waits at most sendTimeout for each point to be sent.
+func newBufferedMetricSender(sink metricSink, size int, sendTimeout time.Duration) *bufferedMetricSender {
+	b := &bufferedMetricSender{
+		sink:        sink,
+		sendTimeout: sendTimeout,
+		points:      make(chan metricPoint, size),
+	}
+	go b.run()
+	return b
+}
+
+func (b *bufferedMetricSender) run() {
+	for p := range b.points {
+		if p.flushed != nil {
+			// Failures to send these counts are not themselves counted, since they would
+			// otherwise be reported again by every flush.
+			if n := b.dropped.Swap(0); n > 0 {
+				_ = b.sendPoint(droppedMetricsMetric, float64(n), nil)
+			}
+			if n := b.failed.Swap(0); n > 0 {
+				_ = b.sendPoint(selfErrorsMetric, float64(n), nil)
+			}
+			close(p.flushed)
+			continue
+		}
+		if err := b.sendPoint(p.metric, p.value, p.tags); err != nil {
+			b.recordError(err)
+		}
+	}
+}
+
+// sendPoint sends a metric point to sink, waiting at most sendTimeout. A call that times out is
+// left to finish in the background; until it does, further points fail immediately rather than
+// accumulating blocked calls.
+func (b *bufferedMetricSender) sendPoint(metric string, value float64, tags []string) error {
+	if b.sending.Load() {
+		return ErrMetricSendTimeout
+	}
+	b.sending.Store(true)
+	done := make(chan error, 1)
+	go func() {
+		err := b.sink(metric, value, tags...)
+		b.sending.Store(false)
+		done <- err
+	}()
+
+	timer := time.NewTimer(b.sendTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrMetricSendTimeout
+	}
+}
+
+func (b *bufferedMetricSender) recordError(err error) {
+	b.failed.Add(1)
+	b.unreported.Add(1)
+	b.lastErr.Store(errorValue{err})
+}
+
+// errorValue wraps errors of varying concrete types for storage in an atomic.Value.
+type errorValue struct{ err error }
+
+// takeSendErrors returns the number of points that could not be sent since it was last called,
+// along with the most recent error.
+func (b *bufferedMetricSender) takeSendErrors() (int64, error) {
+	n := b.unreported.Swap(0)
+	if n == 0 {
+		return 0, nil
+	}
+	v, _ := b.lastErr.Load().(errorValue)
+	return n, v.err
+}
+
+// send enqueues a metric point without blocking. The point is dropped if the buffer is full.
+func (b *bufferedMetricSender) send(metric string, value float64, tags ...string) {
+	select {
+	case b.points <- metricPoint{metric: metric, value: value, tags: tags}:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// flush waits until every point enqueued before it was called has been sent, along with a count
+// of any points that were dropped. Returns ErrMetricFlushTimeout if this takes longer than timeout.
+func (b *bufferedMetricSender) flush(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	flushed := make(chan struct{})
+	select {
+	case b.points <- metricPoint{flushed: flushed}:
+	case <-timer.C:
+		return ErrMetricFlushTimeout
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-timer.C:
+		return ErrMetricFlushTimeout
+	}
+}
+
+// stop ends the background goroutine once all enqueued points have been sent.
+// The sender must not be used after it is stopped.
+func (b *bufferedMetricSender) stop() {
+	close(b.points)
+}
@@ -0,0 +1,146 @@
+package ddHelpers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowSink records the metrics it receives after waiting for delay or, when blocked,
+// until it is unblocked.
+type slowSink struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	block   chan struct{}
+	metrics map[string]float64
+}
+
+func newSlowSink(delay time.Duration) *slowSink {
+	return &slowSink{delay: delay, metrics: map[string]float64{}}
+}
+
+func (s *slowSink) send(metric string, value float64, tags ...string) error {
+	if s.block != nil {
+		<-s.block
+	}
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics[metric] += value
+	return nil
+}
+
+func (s *slowSink) received() map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	received := make(map[string]float64, len(s.metrics))
+	for k, v := range s.metrics {
+		received[k] = v
+	}
+	return received
+}
+
+func TestBufferedMetricSender(t *testing.T) {
+	t.Run("sending does not wait on a slow sink", func(t *testing.T) {
+		sink := newSlowSink(50 * time.Millisecond)
+		buffer := newBufferedMetricSender(sink.send, 10, 5*time.Second)
+		t.Cleanup(buffer.stop)
+
+		// Simulates processing 5 records, each of which emits a metric
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			buffer.send("record.processed", 1)
+		}
+		assert.Less(t, time.Since(start), 50*time.Millisecond,
+			"Record processing should not wait for metrics to be sent")
+
+		require.NoError(t, buffer.flush(5*time.Second))
+		assert.Equal(t, map[string]float64{"record.processed": 5}, sink.received())
+	})
+
+	t.Run("points are dropped and counted when the buffer is full", func(t *testing.T) {
+		sink := newSlowSink(0)
+		sink.block = make(chan struct{})
+		buffer := newBufferedMetricSender(sink.send, 2, 5*time.Second)
+		t.Cleanup(buffer.stop)
+
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			buffer.send("record.processed", 1)
+		}
+		assert.Less(t, time.Since(start), 50*time.Millisecond,
+			"Sending should not block when the buffer is full")
+
+		close(sink.block)
+		require.NoError(t, buffer.flush(5*time.Second))
+		received := sink.received()
+		// The worker may have taken one point off the buffer before blocking
+		assert.GreaterOrEqual(t, received["record.processed"], float64(2))
+		assert.LessOrEqual(t, received["record.processed"], float64(3))
+		assert.Equal(t, float64(10), received["record.processed"]+received[droppedMetricsMetric])
+	})
+
+	t.Run("flush times out when the sink is unresponsive", func(t *testing.T) {
+		sink := newSlowSink(0)
+		sink.block = make(chan struct{})
+		t.Cleanup(func() { close(sink.block) })
+		buffer := newBufferedMetricSender(sink.send, 2, 5*time.Second)
+		t.Cleanup(buffer.stop)
+		buffer.send("record.processed", 1)
+
+		start := time.Now()
+		assert.ErrorIs(t, buffer.flush(20*time.Millisecond), ErrMetricFlushTimeout)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("sink errors are counted rather than returned", func(t *testing.T) {
+		sinkErr := errors.New("connection refused")
+		var mu sync.Mutex
+		attempted := map[string]float64{}
+		buffer := newBufferedMetricSender(func(metric string, value float64, tags ...string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempted[metric] += value
+			return sinkErr
+		}, 10, 5*time.Second)
+		t.Cleanup(buffer.stop)
+
+		for i := 0; i < 3; i++ {
+			buffer.send("record.processed", 1)
+		}
+		require.NoError(t, buffer.flush(5*time.Second))
+		failed, err := buffer.takeSendErrors()
+		assert.Equal(t, int64(3), failed)
+		assert.ErrorIs(t, err, sinkErr)
+		mu.Lock()
+		assert.Equal(t, map[string]float64{"record.processed": 3, selfErrorsMetric: 3}, attempted)
+		mu.Unlock()
+
+		failed, err = buffer.takeSendErrors()
+		assert.Zero(t, failed, "Errors should only be reported once")
+		assert.NoError(t, err)
+	})
+
+	t.Run("sends to an unresponsive sink time out", func(t *testing.T) {
+		sink := newSlowSink(0)
+		sink.block = make(chan struct{})
+		t.Cleanup(func() { close(sink.block) })
+		buffer := newBufferedMetricSender(sink.send, 10, 20*time.Millisecond)
+		t.Cleanup(buffer.stop)
+
+		for i := 0; i < 5; i++ {
+			buffer.send("record.processed", 1)
+		}
+		start := time.Now()
+		require.NoError(t, buffer.flush(5*time.Second))
+		assert.Less(t, time.Since(start), time.Second,
+			"Only the first point should wait for the send timeout")
+		failed, err := buffer.takeSendErrors()
+		assert.Equal(t, int64(5), failed)
+		assert.ErrorIs(t, err, ErrMetricSendTimeout)
+	})
+}
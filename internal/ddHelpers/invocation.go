@@ -0,0 +1,36 @@
+package ddHelpers
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// warm is set when the first invocation handled by the Lambda execution environment begins.
+var warm atomic.Bool
+
+// StartInvocation records the start of a Lambda invocation by emitting the invocation.cold_start
+// metric with sendMetric (typically created with NewMetricSender). Its value is 1 when this is
+// the first invocation handled by the execution environment (i.e. a cold start) and 0 otherwise.
+//
+// The returned function should be deferred until the invocation ends (but before metrics are
+// flushed), at which point it emits the duration of the invocation in milliseconds as the
+// invocation.duration metric, tagged with "cold:true" or "cold:false".
+func StartInvocation(sendMetric func(metric string, value float64, tags ...string)) (finish func()) {
+	start := time.Now()
+	cold := !warm.Swap(true)
+	if cold {
+		sendMetric("invocation.cold_start", 1)
+	} else {
+		sendMetric("invocation.cold_start", 0)
+	}
+	return func() {
+		sendMetric("invocation.duration", float64(time.Since(start).Milliseconds()),
+			ColdStartTag(cold))
+	}
+}
+
+// ColdStartTag returns the tag that identifies metrics emitted during cold-start invocations.
+func ColdStartTag(cold bool) string {
+	return fmt.Sprintf("cold:%t", cold)
+}
@@ -0,0 +1,42 @@
+package ddHelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartInvocation(t *testing.T) {
+	warm.Store(false)
+	t.Cleanup(func() { warm.Store(false) })
+	type sentMetric struct {
+		metric string
+		value  float64
+		tags   []string
+	}
+	var sent []sentMetric
+	sendMetric := func(metric string, value float64, tags ...string) {
+		sent = append(sent, sentMetric{metric, value, tags})
+	}
+
+	for i, expectCold := range []bool{true, false, false} {
+		sent = nil
+		StartInvocation(sendMetric)()
+		require.Len(t, sent, 2, "invocation %d", i+1)
+		assert.Equal(t, "invocation.cold_start", sent[0].metric)
+		if expectCold {
+			assert.Equal(t, float64(1), sent[0].value, "invocation %d should be a cold start", i+1)
+		} else {
+			assert.Equal(t, float64(0), sent[0].value, "invocation %d should be warm", i+1)
+		}
+		assert.Equal(t, "invocation.duration", sent[1].metric)
+		assert.GreaterOrEqual(t, sent[1].value, float64(0))
+		assert.Equal(t, []string{ColdStartTag(expectCold)}, sent[1].tags)
+	}
+}
+
+func TestColdStartTag(t *testing.T) {
+	assert.Equal(t, "cold:true", ColdStartTag(true))
+	assert.Equal(t, "cold:false", ColdStartTag(false))
+}
@@ -0,0 +1,111 @@
+package ddHelpers
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+const defaultDogStatsDPort = "8125"
+
+var (
+	statsdClient statsd.ClientInterface
+	metricBuffer *bufferedMetricSender
+)
+
+// dogStatsDAddress returns the address of the DogStatsD server configured by environment variables.
+// A Unix domain socket path given by DD_DOGSTATSD_SOCKET takes precedence over a UDP address
+// given by DD_AGENT_HOST and DD_DOGSTATSD_PORT (which defaults to 8125).
+// Returns false when no DogStatsD server is configured.
+func dogStatsDAddress(getenv func(string) string) (string, bool) {
+	if socket := getenv("DD_DOGSTATSD_SOCKET"); socket != "" {
+		return statsd.UnixAddressPrefix + socket, true
+	}
+	if host := getenv("DD_AGENT_HOST"); host != "" {
+		port := getenv("DD_DOGSTATSD_PORT")
+		if port == "" {
+			port = defaultDogStatsDPort
+		}
+		return net.JoinHostPort(host, port), true
+	}
+	return "", false
+}
+
+// ConfigureMetricDestination directs metrics emitted by functions created with NewMetricSender
+// to the DogStatsD server configured by the DD_DOGSTATSD_SOCKET (for UDS) or
+// DD_AGENT_HOST and DD_DOGSTATSD_PORT (for UDP) environment variables.
+// Metrics are buffered (see bufferedMetricSender) and sent by a background goroutine, so that
+// emitting a metric never waits on, nor fails because of, the DogStatsD server.
+// When none of these are set, metrics continue to be emitted with ddlambda.Metric.
+func ConfigureMetricDestination() error {
+	addr, ok := dogStatsDAddress(os.Getenv)
+	if !ok {
+		return nil
+	}
+	client, err := statsd.New(addr)
+	if err != nil {
+		return fmt.Errorf("error creating DogStatsD client for %s: %w", addr, err)
+	}
+	buffer := newBufferedMetricSender(func(metric string, value float64, tags ...string) error {
+		return client.Distribution(metric, value, tags, 1)
+	}, DefaultMetricBufferSize, DefaultMetricSendTimeout)
+	ddLambdaMetricSender = buffer.send
+	if metricBuffer != nil {
+		metricBuffer.stop()
+	}
+	if statsdClient != nil {
+		statsdClient.Close()
+	}
+	metricBuffer, statsdClient = buffer, client
+	return nil
+}
+
+// FlushMetrics sends any metrics buffered for a DogStatsD server configured by
+// ConfigureMetricDestination, waiting at most DefaultMetricFlushTimeout for buffered metrics
+// to be sent. It should be called before each invocation returns, since the Lambda execution
+// environment may be frozen indefinitely once it does.
+func FlushMetrics() error {
+	return FlushMetricsWithTimeout(DefaultMetricFlushTimeout)
+}
+
+// FlushMetricsWithTimeout is like FlushMetrics but waits at most timeout for buffered metrics
+// to be sent. Returns ErrMetricFlushTimeout if the timeout elapses first.
+func FlushMetricsWithTimeout(timeout time.Duration) error {
+	if statsdClient == nil {
+		return nil
+	}
+	if metricBuffer != nil {
+		if err := metricBuffer.flush(timeout); err != nil {
+			return err
+		}
+	}
+	return statsdClient.Flush()
+}
+
+// FlushMetricsAndLog is like FlushMetrics, but never returns an error, so that an unavailable
+// DogStatsD server cannot affect the outcome of an invocation. Instead, a flush error and any
+// metric points that could not be sent since the previous call are logged in a single WARN-level
+// log. It is intended to be deferred once per invocation.
+func FlushMetricsAndLog(logger log.Logger) {
+	flushErr := FlushMetrics()
+	var failed int64
+	var sendErr error
+	if metricBuffer != nil {
+		failed, sendErr = metricBuffer.takeSendErrors()
+	}
+	if flushErr == nil && failed == 0 {
+		return
+	}
+	kv := []interface{}{"failed_points", failed}
+	if sendErr != nil {
+		kv = append(kv, "send_error", sendErr)
+	}
+	if flushErr != nil {
+		kv = append(kv, "error", flushErr)
+	}
+	log.Warn(logger, "Error sending metrics", kv...)
+}
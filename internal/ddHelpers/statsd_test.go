@@ -0,0 +1,142 @@
+package ddHelpers
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+	kitlog "github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDogStatsDAddress(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		environ     map[string]string
+		expAddress  string
+		expFoundEnv bool
+	}{
+		{"not configured", map[string]string{}, "", false},
+		{"socket", map[string]string{"DD_DOGSTATSD_SOCKET": "/var/run/dsd.socket"}, "unix:///var/run/dsd.socket", true},
+		{"host and port", map[string]string{"DD_AGENT_HOST": "10.0.0.1", "DD_DOGSTATSD_PORT": "9125"}, "10.0.0.1:9125", true},
+		{"host with default port", map[string]string{"DD_AGENT_HOST": "10.0.0.1"}, "10.0.0.1:8125", true},
+		{"socket takes precedence", map[string]string{
+			"DD_DOGSTATSD_SOCKET": "/var/run/dsd.socket",
+			"DD_AGENT_HOST":       "10.0.0.1",
+		}, "unix:///var/run/dsd.socket", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, ok := dogStatsDAddress(func(k string) string { return tt.environ[k] })
+			assert.Equal(t, tt.expFoundEnv, ok)
+			assert.Equal(t, tt.expAddress, addr)
+		})
+	}
+}
+
+func TestConfigureMetricDestination(t *testing.T) {
+	restoreMetricSender := ddLambdaMetricSender
+	t.Cleanup(func() {
+		ddLambdaMetricSender = restoreMetricSender
+		statsdClient, metricBuffer = nil, nil
+	})
+
+	receiveMetric := func(t *testing.T, conn net.PacketConn) string {
+		t.Helper()
+		sendMetric := NewMetricSender("testing", "foo:bar")
+		sendMetric("my_metric", 1234, "fizz:fuzz")
+		require.NoError(t, FlushMetrics())
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	t.Run("UDP", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+		require.NoError(t, err)
+		t.Setenv("DD_DOGSTATSD_SOCKET", "")
+		t.Setenv("DD_AGENT_HOST", host)
+		t.Setenv("DD_DOGSTATSD_PORT", port)
+
+		require.NoError(t, ConfigureMetricDestination())
+		assert.Contains(t, receiveMetric(t, conn), "grants_ingest.testing.my_metric:1234|d|#foo:bar,fizz:fuzz")
+	})
+
+	t.Run("UDS", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "dsd.socket")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		t.Setenv("DD_DOGSTATSD_SOCKET", socketPath)
+
+		require.NoError(t, ConfigureMetricDestination())
+		assert.Contains(t, receiveMetric(t, conn), "grants_ingest.testing.my_metric:1234|d|#foo:bar,fizz:fuzz")
+	})
+}
+
+func TestMetricFailuresDoNotAffectInvocations(t *testing.T) {
+	restoreMetricSender := ddLambdaMetricSender
+	t.Cleanup(func() {
+		ddLambdaMetricSender = restoreMetricSender
+		statsdClient, metricBuffer = nil, nil
+	})
+
+	// Simulates a handler that emits a metric for each of the records that it processes
+	handler := func(sendMetric func(metric string, value float64, tags ...string)) (int, error) {
+		processed := 0
+		for i := 0; i < 100; i++ {
+			sendMetric("record.processed", 1)
+			processed++
+		}
+		return processed, nil
+	}
+
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	for _, tt := range []struct {
+		name string
+		sink metricSink
+	}{
+		{"sink always errors", func(string, float64, ...string) error {
+			return errors.New("connection refused")
+		}},
+		{"sink always blocks", func(string, float64, ...string) error {
+			<-block
+			return nil
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := newBufferedMetricSender(tt.sink, DefaultMetricBufferSize, DefaultMetricSendTimeout)
+			t.Cleanup(buffer.stop)
+			ddLambdaMetricSender = buffer.send
+			metricBuffer, statsdClient = buffer, &statsd.NoOpClient{}
+			logs := &bytes.Buffer{}
+			logger := kitlog.NewLogfmtLogger(logs)
+
+			for invocation := 1; invocation <= 2; invocation++ {
+				start := time.Now()
+				processed, err := func() (int, error) {
+					defer FlushMetricsAndLog(logger)
+					return handler(NewMetricSender("testing"))
+				}()
+				assert.Less(t, time.Since(start), DefaultMetricFlushTimeout,
+					"Invocation should not wait on the metrics destination")
+				assert.NoError(t, err)
+				assert.Equal(t, 100, processed)
+				assert.Equal(t, invocation, strings.Count(logs.String(), "Error sending metrics"),
+					"Metric errors should be logged once per invocation")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package emailHelpers provides parsing of the emails received by way of SES, which is shared
+// by the ReceiveFFISEmail Lambda handler and the grants-ingest CLI.
+package emailHelpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+)
+
+var (
+	ErrEmailFailedToParse       = errors.New("failed to parse email")
+	ErrEmailDateFailedToParse   = errors.New("failed to parse email date")
+	ErrEmailSenderFailedToParse = errors.New("failed to parse email sender")
+)
+
+// Email is a parsed email.
+type Email struct {
+	Message *mail.Message
+	// Sender is the first From address.
+	Sender *mail.Address
+	// Date is taken from the Date header, or else from the time at which the email was
+	// received (see ReceivedTime) when the Date header is missing or invalid.
+	Date time.Time
+	// DateHeaderErr is the reason that the Date header could not be used, when Date is the
+	// time at which the email was received.
+	DateHeaderErr error
+	// Compressed indicates whether the email was gzip-compressed.
+	Compressed bool
+}
+
+// Parse parses the (possibly gzip-compressed) email contained in data.
+// Returns an error wrapping ErrEmailDateFailedToParse only when neither the Date header nor
+// the time at which the email was received is known.
+func Parse(data []byte) (Email, error) {
+	// Emails are occasionally stored gzip-compressed without a Content-Encoding header,
+	// so the contents are sniffed for compression regardless of the declared encoding
+	contents, compressed, err := awsHelpers.DecompressIfGzipped(bytes.NewReader(data))
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to decompress gzip-compressed email: %w", err)
+	}
+
+	email := Email{Compressed: compressed}
+	email.Message, email.Sender, email.Date, err = ParseContents(contents)
+	if errors.Is(err, ErrEmailDateFailedToParse) {
+		if receivedAt, ok := ReceivedTime(email.Message.Header); ok {
+			email.Date, email.DateHeaderErr, err = receivedAt, err, nil
+		}
+	}
+	if err != nil {
+		return Email{}, err
+	}
+	return email, nil
+}
+
+// ParseContents parses an email, returning the message along with its sender (i.e. the
+// first From address) and the date from its Date header.
+// When only the date cannot be parsed, the message and sender are returned along with an
+// error wrapping ErrEmailDateFailedToParse.
+func ParseContents(r io.Reader) (msg *mail.Message, sender *mail.Address, date time.Time, err error) {
+	msg, err = mail.ReadMessage(r)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrEmailFailedToParse, err)
+		return
+	}
+
+	from, err := FromAddresses(msg.Header)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrEmailSenderFailedToParse, err)
+		return
+	}
+	sender = from[0]
+
+	date, err = msg.Header.Date()
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrEmailDateFailedToParse, err)
+		return
+	}
+
+	return
+}
+
+// FromAddresses returns every address in the From header(s) of an email. Although RFC 5322
+// permits a single From header listing multiple authors, emails are occasionally received with
+// multiple From headers, so the addresses of every From header are returned (in order).
+// Returns an error when any From header is unparseable, or when there is no From address.
+func FromAddresses(h mail.Header) ([]*mail.Address, error) {
+	p := mail.AddressParser{}
+	values := h["From"]
+	if len(values) == 0 {
+		values = []string{""}
+	}
+	addresses := []*mail.Address{}
+	for _, value := range values {
+		list, err := p.ParseList(value)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, list...)
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("mail: no address")
+	}
+	return addresses, nil
+}
+
+// ReceivedTime returns the time at which the email was received, which is useful as a
+// fallback for keying emails whose Date header is missing or unparseable.
+// The time is taken from the X-SES-Receipt header when it carries a timestamp, or else from
+// the first (i.e. most recent) Received header, which is added by SES upon receipt.
+// Returns false when neither header provides a parseable timestamp.
+func ReceivedTime(h mail.Header) (time.Time, bool) {
+	for _, value := range []string{h.Get("X-SES-Receipt"), h.Get("Received")} {
+		if t, ok := parseTraceTimestamp(value); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTraceTimestamp parses the date-time that follows the final semicolon of a trace header
+// value such as "from mx.example.com by inbound-smtp.amazonaws.com; Sat, 22 Apr 2023 19:55:30
+// +0000 (UTC)", or the entire value when it does not contain a semicolon.
+func parseTraceTimestamp(value string) (time.Time, bool) {
+	if i := strings.LastIndex(value, ";"); i >= 0 {
+		value = value[i+1:]
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := mail.ParseDate(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ResentDate returns the date of the most recent Resent-Date header, which is prepended to an
+// email each time it is resent (e.g. forwarded by a mailing list). Returns false when the header
+// is missing or unparseable.
+func ResentDate(h mail.Header) (time.Time, bool) {
+	value := strings.TrimSpace(h.Get("Resent-Date"))
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := mail.ParseDate(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
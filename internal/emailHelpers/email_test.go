@@ -0,0 +1,173 @@
+package emailHelpers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/mail"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getFixture(t *testing.T, path string) *os.File {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestParse(t *testing.T) {
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	undatedEmail := bytes.Replace(goodEmail, []byte("Date: Sat, 22 Apr 2023 14:55:26 -0500\n"), nil, 1)
+	require.NotEqual(t, goodEmail, undatedEmail)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err = gz.Write(goodEmail)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	t.Run("dated by Date header", func(t *testing.T) {
+		email, err := Parse(goodEmail)
+		require.NoError(t, err)
+		assert.Equal(t, "some.person@example.org", email.Sender.Address)
+		assert.True(t, time.Date(2023, 4, 22, 19, 55, 26, 0, time.UTC).Equal(email.Date))
+		assert.NoError(t, email.DateHeaderErr)
+		assert.False(t, email.Compressed)
+	})
+
+	t.Run("gzip-compressed", func(t *testing.T) {
+		email, err := Parse(gzipped.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "some.person@example.org", email.Sender.Address)
+		assert.True(t, email.Compressed)
+	})
+
+	t.Run("dated by time of receipt", func(t *testing.T) {
+		email, err := Parse(append([]byte("Received: from mx.example.org by inbound-smtp; "+
+			"Sun, 23 Apr 2023 10:00:00 +0000\n"), undatedEmail...))
+		require.NoError(t, err)
+		assert.True(t, time.Date(2023, 4, 23, 10, 0, 0, 0, time.UTC).Equal(email.Date))
+		assert.ErrorIs(t, email.DateHeaderErr, ErrEmailDateFailedToParse)
+	})
+
+	t.Run("time of receipt is unknown", func(t *testing.T) {
+		_, err := Parse(undatedEmail)
+		assert.ErrorIs(t, err, ErrEmailDateFailedToParse)
+	})
+
+	t.Run("truncated gzip data", func(t *testing.T) {
+		_, err := Parse(gzipped.Bytes()[:20])
+		assert.Error(t, err)
+	})
+}
+
+func TestParseContents(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		pathToFixture string
+		expError      error
+	}{
+		{"empty email file", "fixtures/bad_empty.eml", ErrEmailFailedToParse},
+		{"invalid email file", "fixtures/bad_data.eml", ErrEmailFailedToParse},
+		{"unparseable sender", "fixtures/bad_from.eml", ErrEmailSenderFailedToParse},
+		{"missing sender", "fixtures/bad_fromMissing.eml", ErrEmailSenderFailedToParse},
+		{"unparseable date", "fixtures/bad_date.eml", ErrEmailDateFailedToParse},
+		{"missing date", "fixtures/bad_dateMissing.eml", ErrEmailDateFailedToParse},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := ParseContents(getFixture(t, tt.pathToFixture))
+			if tt.expError != nil {
+				assert.Error(t, err)
+				assert.ErrorIs(t, err, tt.expError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReceivedTime(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		header   mail.Header
+		expected time.Time
+		expOK    bool
+	}{
+		{
+			"Received header added by SES",
+			mail.Header{"Received": []string{
+				"from mail-yw1-f170.google.com (mail-yw1-f170.google.com [209.85.128.170]) " +
+					"by inbound-smtp.us-west-2.amazonaws.com with SMTP id 6fsq0n4ueb3ngm1bd8m0ecg3jp7hsu0c4c7ll9o1 " +
+					"for ffis-ingest@example.com; Sat, 22 Apr 2023 19:55:30 +0000 (UTC)",
+				"by mail-yw1-f170.google.com with SMTP id 00721157ae682-54fc6949475so3276707b3; " +
+					"Sat, 22 Apr 2023 12:55:27 -0700 (PDT)",
+			}},
+			time.Date(2023, 4, 22, 19, 55, 30, 0, time.UTC),
+			true,
+		},
+		{
+			"SES receipt timestamp header",
+			mail.Header{
+				"X-Ses-Receipt": []string{"Sat, 22 Apr 2023 19:55:31 +0000"},
+				"Received":      []string{"from mx.example.org by inbound-smtp; Sat, 22 Apr 2023 19:55:30 +0000"},
+			},
+			time.Date(2023, 4, 22, 19, 55, 31, 0, time.UTC),
+			true,
+		},
+		{
+			"opaque SES receipt header falls back to Received header",
+			mail.Header{
+				"X-Ses-Receipt": []string{"AEFBQUFBQUFBQUFFd0l0eGtGaXhBZkQ0UFdHeEo="},
+				"Received":      []string{"from mx.example.org by inbound-smtp; Sat, 22 Apr 2023 19:55:30 +0000"},
+			},
+			time.Date(2023, 4, 22, 19, 55, 30, 0, time.UTC),
+			true,
+		},
+		{
+			"unparseable Received header",
+			mail.Header{"Received": []string{"from mx.example.org by inbound-smtp; yesterday"}},
+			time.Time{},
+			false,
+		},
+		{"no trace headers", mail.Header{}, time.Time{}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := ReceivedTime(tt.header)
+			assert.Equal(t, tt.expOK, ok)
+			assert.True(t, tt.expected.Equal(actual), "expected %s but got %s", tt.expected, actual)
+		})
+	}
+}
+
+func TestResentDate(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		header   mail.Header
+		expected time.Time
+		expOK    bool
+	}{
+		{
+			"most recent Resent-Date header",
+			mail.Header{"Resent-Date": []string{
+				"Mon, 24 Apr 2023 09:00:00 -0400",
+				"Sun, 23 Apr 2023 09:00:00 -0400",
+			}},
+			time.Date(2023, 4, 24, 13, 0, 0, 0, time.UTC),
+			true,
+		},
+		{"unparseable Resent-Date header", mail.Header{"Resent-Date": []string{"last week"}}, time.Time{}, false},
+		{"no Resent-Date header", mail.Header{}, time.Time{}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := ResentDate(tt.header)
+			assert.Equal(t, tt.expOK, ok)
+			assert.True(t, tt.expected.Equal(actual), "expected %s but got %s", tt.expected, actual)
+		})
+	}
+}
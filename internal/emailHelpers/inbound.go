@@ -0,0 +1,47 @@
+package emailHelpers
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Kinds of objects written to the inbound bucket by SES
+const (
+	// InboundKindRawEmail is an email in RFC 5322 format, as written by the SES S3 action.
+	InboundKindRawEmail = "raw_email"
+	// InboundKindSESNotification is the JSON notification that SES publishes about a received
+	// email, which some configurations also write to the inbound bucket.
+	InboundKindSESNotification = "ses_notification"
+)
+
+// SESNotification is the subset of an SES receipt notification that identifies the email it
+// describes. See https://docs.aws.amazon.com/ses/latest/dg/receiving-email-notifications-contents.html
+type SESNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		MessageID string `json:"messageId"`
+		Source    string `json:"source"`
+	} `json:"mail"`
+}
+
+// utf8BOM may precede the JSON document of an SES notification.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// InboundObjectKind returns the kind of the inbound object whose contents are data, along with
+// the decoded notification when the object is an SES notification.
+// Raw emails always begin with a header field (or are gzip-compressed), whereas SES notifications
+// are JSON objects that describe the received email with a notification type and a "mail" object,
+// so only the first non-whitespace byte needs to be inspected before attempting to decode JSON.
+// Objects that are not SES notifications are assumed to be raw emails.
+func InboundObjectKind(data []byte) (string, *SESNotification) {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return InboundKindRawEmail, nil
+	}
+	var notification SESNotification
+	if err := json.Unmarshal(trimmed, &notification); err != nil ||
+		notification.NotificationType == "" || notification.Mail.MessageID == "" {
+		return InboundKindRawEmail, nil
+	}
+	return InboundKindSESNotification, &notification
+}
@@ -0,0 +1,51 @@
+package emailHelpers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInboundObjectKind(t *testing.T) {
+	goodEmail, err := os.ReadFile("fixtures/good.eml")
+	require.NoError(t, err)
+	notificationJSON, err := os.ReadFile("fixtures/ses_notification.json")
+	require.NoError(t, err)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err = gz.Write(goodEmail)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for _, tt := range []struct {
+		name         string
+		data         []byte
+		expectedKind string
+	}{
+		{"raw email", goodEmail, InboundKindRawEmail},
+		{"gzip-compressed raw email", gzipped.Bytes(), InboundKindRawEmail},
+		{"empty object", []byte{}, InboundKindRawEmail},
+		{"SES notification", notificationJSON, InboundKindSESNotification},
+		{"SES notification with BOM and leading whitespace",
+			append([]byte("\xef\xbb\xbf\r\n  "), notificationJSON...), InboundKindSESNotification},
+		{"JSON that is not an SES notification", []byte(`{"hello": "world"}`), InboundKindRawEmail},
+		{"malformed JSON", []byte(`{"notificationType": "Received",`), InboundKindRawEmail},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, notification := InboundObjectKind(tt.data)
+			assert.Equal(t, tt.expectedKind, kind)
+			if tt.expectedKind == InboundKindSESNotification {
+				require.NotNil(t, notification)
+				assert.Equal(t, "Received", notification.NotificationType)
+				assert.Equal(t, "o3vrnil0e2ic28trm7dfhrc2v0clambda4nbp0g1", notification.Mail.MessageID)
+				assert.Equal(t, "some.person@example.org", notification.Mail.Source)
+			} else {
+				assert.Nil(t, notification)
+			}
+		})
+	}
+}
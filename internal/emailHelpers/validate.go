@@ -0,0 +1,84 @@
+package emailHelpers
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// bulkValidateChunkSize is the size of the ranges in which stored emails are fetched.
+const bulkValidateChunkSize = 10 * awsHelpers.MB
+
+// BulkValidateAPI lists and retrieves the stored emails that are validated by BulkValidate.
+type BulkValidateAPI interface {
+	s3.ListObjectsV2APIClient
+	awsHelpers.S3GetObjectAPI
+}
+
+// BulkValidateFailure identifies a stored email that could not be validated.
+type BulkValidateFailure struct {
+	Key   string
+	Error string
+}
+
+// BulkValidateSummary reports the outcome of validating every object under a prefix.
+type BulkValidateSummary struct {
+	Objects int
+	Parsed  int
+	// Skipped counts SES notifications, which are not parsed as emails.
+	Skipped  int
+	Failures []BulkValidateFailure
+}
+
+// BulkValidate fetches and parses (see Parse) each object in bucket whose key begins with
+// prefix, without writing anything. This makes it possible to check that a parser change still
+// accepts the emails that have already been archived. Senders are not verified, since that
+// depends on the source configuration at the time each email was received.
+// Objects that cannot be fetched or parsed are reported as failures rather than returned as
+// errors, so that a single bad object does not prevent the rest from being validated.
+// Returns an error only when the objects cannot be listed.
+func BulkValidate(ctx context.Context, logger log.Logger, client BulkValidateAPI, bucket, prefix string) (BulkValidateSummary, error) {
+	summary := BulkValidateSummary{Failures: []BulkValidateFailure{}}
+	objects, err := awsHelpers.ListS3Objects(ctx, client, bucket, prefix)
+	if err != nil {
+		return summary, log.Errorf(logger, "failed to list stored emails", err)
+	}
+	for _, obj := range objects {
+		key := aws.ToString(obj.Key)
+		logger := log.With(logger, "bucket", bucket, "key", key)
+		summary.Objects++
+
+		data, err := io.ReadAll(awsHelpers.NewChunkedReader(ctx, client, bucket, key, bulkValidateChunkSize))
+		if err != nil {
+			summary.Failures = append(summary.Failures,
+				BulkValidateFailure{key, log.Errorf(logger, "failed to retrieve S3 object", err).Error()})
+			continue
+		}
+		if kind, _ := InboundObjectKind(data); kind == InboundKindSESNotification {
+			log.Debug(logger, "Skipping SES notification because it is not a raw email")
+			summary.Skipped++
+			continue
+		}
+		if _, err := Parse(data); err != nil {
+			summary.Failures = append(summary.Failures,
+				BulkValidateFailure{key, log.Errorf(logger, "failed to parse email", err).Error()})
+			continue
+		}
+		summary.Parsed++
+	}
+	return summary, nil
+}
+
+// Print writes a human-readable report of s to w.
+func (s BulkValidateSummary) Print(w io.Writer) {
+	for _, failure := range s.Failures {
+		fmt.Fprintf(w, "FAILED %s: %s\n", failure.Key, failure.Error)
+	}
+	fmt.Fprintf(w, "Validated %d objects: %d parsed, %d failed, %d skipped\n",
+		s.Objects, s.Parsed, len(s.Failures), s.Skipped)
+}
@@ -0,0 +1,120 @@
+package emailHelpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockBulkValidateAPI serves objects by key, listing at most two keys per page.
+type mockBulkValidateAPI struct {
+	objects  map[string][]byte
+	listErr  error
+	getCalls int
+}
+
+func (m *mockBulkValidateAPI) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
+	keys := []string{}
+	for key := range m.objects {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) && key > aws.ToString(params.ContinuationToken) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	resp := &s3.ListObjectsV2Output{}
+	for i, key := range keys {
+		if i == 2 {
+			resp.IsTruncated = true
+			resp.NextContinuationToken = aws.String(keys[i-1])
+			break
+		}
+		resp.Contents = append(resp.Contents, types.Object{Key: aws.String(key)})
+	}
+	return resp, nil
+}
+
+func (m *mockBulkValidateAPI) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.getCalls++
+	body, ok := m.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}, nil
+}
+
+func TestBulkValidate(t *testing.T) {
+	logger := log.NewNopLogger()
+	objects := map[string][]byte{}
+	for key, fixture := range map[string]string{
+		"sources/2023/04/22/ffis.org/raw.eml":    "fixtures/good.eml",
+		"sources/2023/04/23/ffis.org/raw.eml":    "fixtures/good_base64Body.eml",
+		"sources/2023/04/24/ffis.org/raw.eml":    "fixtures/good_gzipNoEncoding.eml",
+		"sources/2023/04/25/ffis.org/raw.eml":    "fixtures/bad_date.eml",
+		"sources/2023/04/26/ffis.org/raw.eml":    "fixtures/bad_fromMissing.eml",
+		"sources/2023/04/27/ffis.org/raw.eml":    "fixtures/ses_notification.json",
+		"quarantine/2023/04/22/ffis.org/raw.eml": "fixtures/bad_empty.eml",
+	} {
+		data, err := os.ReadFile(fixture)
+		require.NoError(t, err)
+		objects[key] = data
+	}
+
+	t.Run("mixed stored objects", func(t *testing.T) {
+		client := &mockBulkValidateAPI{objects: objects}
+		summary, err := BulkValidate(context.TODO(), logger, client, "test-destination-bucket", "sources/")
+		require.NoError(t, err)
+		assert.Equal(t, 6, summary.Objects)
+		assert.Equal(t, 3, summary.Parsed)
+		assert.Equal(t, 1, summary.Skipped)
+		failedKeys := []string{}
+		for _, failure := range summary.Failures {
+			failedKeys = append(failedKeys, failure.Key)
+			assert.NotEmpty(t, failure.Error)
+		}
+		assert.Equal(t, []string{
+			"sources/2023/04/25/ffis.org/raw.eml",
+			"sources/2023/04/26/ffis.org/raw.eml",
+		}, failedKeys)
+		assert.Contains(t, summary.Failures[0].Error, ErrEmailDateFailedToParse.Error())
+		assert.Contains(t, summary.Failures[1].Error, ErrEmailSenderFailedToParse.Error())
+
+		var report bytes.Buffer
+		summary.Print(&report)
+		assert.Equal(t, "FAILED sources/2023/04/25/ffis.org/raw.eml: "+summary.Failures[0].Error+"\n"+
+			"FAILED sources/2023/04/26/ffis.org/raw.eml: "+summary.Failures[1].Error+"\n"+
+			"Validated 6 objects: 3 parsed, 2 failed, 1 skipped\n", report.String())
+	})
+
+	t.Run("empty prefix", func(t *testing.T) {
+		client := &mockBulkValidateAPI{objects: objects}
+		summary, err := BulkValidate(context.TODO(), logger, client, "test-destination-bucket", "failed/")
+		require.NoError(t, err)
+		assert.Equal(t, BulkValidateSummary{Failures: []BulkValidateFailure{}}, summary)
+		assert.Zero(t, client.getCalls)
+	})
+
+	t.Run("listing fails", func(t *testing.T) {
+		listErr := errors.New("access denied")
+		client := &mockBulkValidateAPI{objects: objects, listErr: listErr}
+		_, err := BulkValidate(context.TODO(), logger, client, "test-destination-bucket", "sources/")
+		assert.ErrorIs(t, err, listErr)
+	})
+}
@@ -0,0 +1,39 @@
+package eventHelpers
+
+import (
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+)
+
+// DescribeResponse is returned as the Lambda response for AdminActionDescribe invocations so
+// that operators can see the configuration with which a deployed handler is actually running.
+type DescribeResponse struct {
+	Action  string `json:"adminAction"`
+	Handler string `json:"handler"`
+	// Version and Commit identify the build of the handler (see awsHelpers.ProcessorVersion
+	// and awsHelpers.BuildCommit).
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	// DisabledFeatures lists the features disabled by DISABLED_FEATURES, in alphabetical order.
+	DisabledFeatures []string `json:"disabledFeatures"`
+	// Sources describes the configured sources, in a form that is specific to the handler.
+	Sources interface{} `json:"sources"`
+	// Environment contains the fields of the handler's environment configuration, in which
+	// secrets are redacted (see awsHelpers.Redact).
+	Environment map[string]interface{} `json:"environment"`
+}
+
+// NewDescribeResponse returns the DescribeResponse of the named handler, which is configured
+// by env (a struct or pointer to struct) and disabledFeatures and handles events from sources.
+func NewDescribeResponse(handler string, env interface{}, disabledFeatures configHelpers.FeatureSet,
+	sources interface{}) DescribeResponse {
+	return DescribeResponse{
+		Action:           AdminActionDescribe,
+		Handler:          handler,
+		Version:          awsHelpers.ProcessorVersion,
+		Commit:           awsHelpers.BuildCommit,
+		DisabledFeatures: disabledFeatures.Names(),
+		Sources:          sources,
+		Environment:      awsHelpers.Redact(env),
+	}
+}
@@ -0,0 +1,168 @@
+package eventHelpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+)
+
+const (
+	// FailureSummaryMaxRecords is the maximum number of failed records described by a FailureSummary.
+	FailureSummaryMaxRecords = 25
+	// FailureSummaryMaxErrorLength is the maximum length of each record's error message in a
+	// FailureSummary, after which the message is truncated.
+	FailureSummaryMaxErrorLength = 512
+	// FailureSummaryMaxBytes is the maximum size of a rendered FailureSummary, which keeps it
+	// well below the payload limits of Lambda destinations (256 KB).
+	FailureSummaryMaxBytes = 32 * 1024
+)
+
+// FailureSummary is a compact description of a failed invocation.
+type FailureSummary struct {
+	TotalRecords int            `json:"totalRecords"`
+	Succeeded    int            `json:"succeeded"`
+	Failed       int            `json:"failed"`
+	ErrorClasses map[string]int `json:"errorClasses"`
+	// Records describes the failed records, up to FailureSummaryMaxRecords.
+	Records []RecordResult `json:"records"`
+	// OmittedRecords is the number of failed records not described in Records.
+	OmittedRecords int `json:"omittedRecords"`
+}
+
+// InvocationError is returned by handlers whose invocation failed to process one or more records.
+// Its Error() method renders a FailureSummary as JSON so that the failure context delivered to
+// Lambda OnFailure destinations is compact and machine-readable; the full error detail
+// should be logged separately.
+type InvocationError struct {
+	Summary FailureSummary
+	err     error
+}
+
+// NewInvocationError summarizes the per-record results of a failed invocation.
+// Returns nil when err is nil.
+func NewInvocationError(results []RecordResult, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	summary := FailureSummary{
+		TotalRecords: len(results),
+		ErrorClasses: make(map[string]int),
+		Records:      []RecordResult{},
+	}
+	for _, result := range results {
+		if result.Status != RecordStatusFailed {
+			summary.Succeeded++
+			continue
+		}
+		summary.Failed++
+		summary.ErrorClasses[result.ErrorClass]++
+		if len(summary.Records) < FailureSummaryMaxRecords {
+			result.Error = truncate(result.Error, FailureSummaryMaxErrorLength)
+			summary.Records = append(summary.Records, result)
+		}
+	}
+	summary.OmittedRecords = summary.Failed - len(summary.Records)
+
+	// Drop record details as necessary to stay within the size limit
+	for len(summary.Records) > 0 {
+		if b, _ := marshalCompact(summary); len(b) <= FailureSummaryMaxBytes {
+			break
+		}
+		summary.Records = summary.Records[:len(summary.Records)-1]
+		summary.OmittedRecords++
+	}
+
+	return &InvocationError{Summary: summary, err: err}
+}
+
+func (e *InvocationError) Error() string {
+	b, err := marshalCompact(e.Summary)
+	if err != nil {
+		return e.err.Error()
+	}
+	return string(b)
+}
+
+func marshalCompact(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+func (e *InvocationError) Unwrap() error {
+	return e.err
+}
+
+// ClassifyError returns a short, stable name describing the class of err, which is suitable
+// for aggregating errors. Returns an empty string when err is nil.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrRecordTimeout) {
+		return "RecordTimeout"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "DeadlineExceeded"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "Canceled"
+	}
+	if errors.Is(err, awsHelpers.ErrS3ChecksumMismatch) {
+		return "S3ChecksumMismatch"
+	}
+	if errors.Is(err, awsHelpers.ErrS3ObjectArchived) {
+		return "S3ObjectArchived"
+	}
+	if errors.Is(err, awsHelpers.ErrS3ObjectVerificationFailed) {
+		return "S3ObjectVerificationFailed"
+	}
+	if errors.Is(err, awsHelpers.ErrAssumeRoleFailed) {
+		return "AssumeRoleFailed"
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+
+	// Find the innermost error, which is most likely to identify the root cause
+	for {
+		var next error
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			next = e.Unwrap()
+		case interface{ Unwrap() []error }:
+			if errs := e.Unwrap(); len(errs) > 0 {
+				next = errs[0]
+			}
+		}
+		if next == nil {
+			break
+		}
+		err = next
+	}
+
+	// Sentinel errors created with errors.New are best identified by their message
+	if class := fmt.Sprintf("%T", err); class != "*errors.errorString" {
+		return class
+	}
+	return err.Error()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "..."
+}
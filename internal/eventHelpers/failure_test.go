@@ -0,0 +1,97 @@
+package eventHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/awsHelpers"
+)
+
+var errTestSentinel = errors.New("something went wrong")
+
+func TestNewInvocationError(t *testing.T) {
+	t.Run("nil error", func(t *testing.T) {
+		assert.Nil(t, NewInvocationError([]RecordResult{{Status: RecordStatusSucceeded}}, nil))
+	})
+
+	t.Run("100-record failure is truncated", func(t *testing.T) {
+		records := make([]events.S3EventRecord, 102)
+		for i := range records {
+			records[i].S3.Object.Key = fmt.Sprintf("sources/%d/raw.eml", i)
+		}
+		results, err := ProcessRecords(context.TODO(), records,
+			func(ctx context.Context, i int, record events.S3EventRecord) error {
+				switch {
+				case i < 2:
+					return nil
+				case i%2 == 0:
+					return fmt.Errorf("record %d: %s: %w", i, strings.Repeat("x", 2000), errTestSentinel)
+				default:
+					return fmt.Errorf("record %d: %w", i, context.DeadlineExceeded)
+				}
+			})
+		require.Error(t, err)
+
+		invocationErr := NewInvocationError(results, err)
+		require.Error(t, invocationErr)
+		assert.ErrorIs(t, invocationErr, errTestSentinel, "Original error should remain in the chain")
+		assert.LessOrEqual(t, len(invocationErr.Error()), FailureSummaryMaxBytes)
+
+		var summary FailureSummary
+		require.NoError(t, json.Unmarshal([]byte(invocationErr.Error()), &summary),
+			"Error() should render valid JSON")
+		assert.Equal(t, 102, summary.TotalRecords)
+		assert.Equal(t, 2, summary.Succeeded)
+		assert.Equal(t, 100, summary.Failed)
+		assert.Equal(t, map[string]int{
+			errTestSentinel.Error(): 50,
+			"DeadlineExceeded":      50,
+		}, summary.ErrorClasses)
+		assert.Len(t, summary.Records, FailureSummaryMaxRecords)
+		assert.Equal(t, 100-FailureSummaryMaxRecords, summary.OmittedRecords)
+		for _, r := range summary.Records {
+			assert.Equal(t, RecordStatusFailed, r.Status)
+			assert.NotEmpty(t, r.Key)
+			assert.LessOrEqual(t, len(r.Error), FailureSummaryMaxErrorLength+len("..."))
+		}
+	})
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"nil", nil, ""},
+		{"sentinel", fmt.Errorf("wrapped: %w", errTestSentinel), errTestSentinel.Error()},
+		{"deadline", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "DeadlineExceeded"},
+		{"canceled", context.Canceled, "Canceled"},
+		{"record timeout", fmt.Errorf("%w: %w", ErrRecordTimeout, context.DeadlineExceeded), "RecordTimeout"},
+		{"API error", fmt.Errorf("wrapped: %w", &types.NoSuchKey{}), "NoSuchKey"},
+		{"multi-error", multierror.Append(nil, errTestSentinel), errTestSentinel.Error()},
+		{"typed error", fmt.Errorf("wrapped: %w", &json.SyntaxError{}), "*json.SyntaxError"},
+		{"checksum mismatch", awsHelpers.WrapS3ChecksumError(
+			&smithy.GenericAPIError{Code: "BadDigest"}), "S3ChecksumMismatch"},
+		{"archived object", awsHelpers.WrapS3ObjectArchivedError(
+			&smithy.GenericAPIError{Code: "InvalidObjectState"}), "S3ObjectArchived"},
+		{"unverified object", fmt.Errorf("%w: %w", awsHelpers.ErrS3ObjectVerificationFailed,
+			&smithy.GenericAPIError{Code: "AccessDenied"}), "S3ObjectVerificationFailed"},
+		{"assume role failure", fmt.Errorf("%w: %w", awsHelpers.ErrAssumeRoleFailed,
+			&smithy.GenericAPIError{Code: "AccessDenied"}), "AssumeRoleFailed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ClassifyError(tt.err))
+		})
+	}
+}
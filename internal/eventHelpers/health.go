@@ -0,0 +1,110 @@
+package eventHelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// HealthCheckTimeout is the maximum amount of time allowed for all health checks to complete.
+	HealthCheckTimeout = 5 * time.Second
+
+	HealthCheckStatusOK     = "ok"
+	HealthCheckStatusFailed = "failed"
+)
+
+var ErrHealthCheckFailed = errors.New("health check failed")
+
+// HealthCheck is a single lightweight check of a dependency that is required by a handler.
+type HealthCheck struct {
+	Name string
+	// Required indicates whether a failure of this check should fail the overall health check.
+	Required bool
+	Check    func(ctx context.Context) error
+}
+
+// HealthCheckResult describes the outcome of a single HealthCheck.
+type HealthCheckResult struct {
+	Name      string `json:"name"`
+	Required  bool   `json:"required"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthReport is returned as the Lambda response for health-check invocations.
+type HealthReport struct {
+	Healthy bool                `json:"healthy"`
+	Checks  []HealthCheckResult `json:"checks"`
+}
+
+// Err returns a *HealthCheckError (which wraps ErrHealthCheckFailed) when the report is not
+// healthy, or nil otherwise.
+func (r HealthReport) Err() error {
+	if r.Healthy {
+		return nil
+	}
+	return &HealthCheckError{Report: r}
+}
+
+// HealthCheckError is returned for health-check invocations whose report is not healthy.
+// Like InvocationError, its Error() method renders the report as JSON, so that the outcome of
+// every check reaches the invoker in the Lambda error response.
+type HealthCheckError struct {
+	Report HealthReport
+}
+
+func (e *HealthCheckError) Error() string {
+	b, err := marshalCompact(e.Report)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", ErrHealthCheckFailed, strings.Join(e.Failed(), ", "))
+	}
+	return string(b)
+}
+
+func (e *HealthCheckError) Unwrap() error {
+	return ErrHealthCheckFailed
+}
+
+// Failed returns the name of each failed required check.
+func (e *HealthCheckError) Failed() []string {
+	failed := []string{}
+	for _, result := range e.Report.Checks {
+		if result.Required && result.Status == HealthCheckStatusFailed {
+			failed = append(failed, result.Name)
+		}
+	}
+	return failed
+}
+
+// RunHealthChecks runs each check in order, bounding the total time spent on all checks
+// by timeout, and returns a report of each check's outcome.
+// Whether the report is healthy is given by HealthReport.Err.
+func RunHealthChecks(ctx context.Context, timeout time.Duration, checks []HealthCheck) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	report := HealthReport{Healthy: true, Checks: make([]HealthCheckResult, 0, len(checks))}
+	for _, hc := range checks {
+		start := time.Now()
+		err := hc.Check(ctx)
+		result := HealthCheckResult{
+			Name:      hc.Name,
+			Required:  hc.Required,
+			Status:    HealthCheckStatusOK,
+			LatencyMS: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			result.Status = HealthCheckStatusFailed
+			result.Error = err.Error()
+			if hc.Required {
+				report.Healthy = false
+			}
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
@@ -0,0 +1,74 @@
+package eventHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunHealthChecks(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("unreachable") }
+
+	t.Run("all checks pass", func(t *testing.T) {
+		report := RunHealthChecks(context.TODO(), time.Second, []HealthCheck{
+			{Name: "first", Required: true, Check: ok},
+			{Name: "second", Check: ok},
+		})
+		require.NoError(t, report.Err())
+		assert.True(t, report.Healthy)
+		require.Len(t, report.Checks, 2)
+		for _, c := range report.Checks {
+			assert.Equal(t, HealthCheckStatusOK, c.Status)
+			assert.Empty(t, c.Error)
+		}
+	})
+
+	t.Run("optional check fails", func(t *testing.T) {
+		report := RunHealthChecks(context.TODO(), time.Second, []HealthCheck{
+			{Name: "first", Required: true, Check: ok},
+			{Name: "second", Check: fail},
+		})
+		require.NoError(t, report.Err())
+		assert.True(t, report.Healthy)
+		assert.Equal(t, HealthCheckStatusFailed, report.Checks[1].Status)
+		assert.Equal(t, "unreachable", report.Checks[1].Error)
+	})
+
+	t.Run("required check fails", func(t *testing.T) {
+		report := RunHealthChecks(context.TODO(), time.Second, []HealthCheck{
+			{Name: "first", Required: true, Check: fail},
+			{Name: "second", Check: ok},
+		})
+		err := report.Err()
+		assert.ErrorIs(t, err, ErrHealthCheckFailed)
+		var healthErr *HealthCheckError
+		require.ErrorAs(t, err, &healthErr)
+		assert.Equal(t, []string{"first"}, healthErr.Failed())
+		rendered, marshalErr := json.Marshal(report)
+		require.NoError(t, marshalErr)
+		assert.JSONEq(t, string(rendered), err.Error(), "The error should render the report")
+		assert.False(t, report.Healthy)
+		assert.Equal(t, HealthCheckStatusFailed, report.Checks[0].Status)
+		assert.Equal(t, HealthCheckStatusOK, report.Checks[1].Status)
+	})
+
+	t.Run("checks share a bounded timeout", func(t *testing.T) {
+		slow := func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		start := time.Now()
+		report := RunHealthChecks(context.TODO(), 10*time.Millisecond, []HealthCheck{
+			{Name: "slow", Required: true, Check: slow},
+		})
+		assert.ErrorIs(t, report.Err(), ErrHealthCheckFailed)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Contains(t, report.Checks[0].Error, "deadline exceeded")
+	})
+}
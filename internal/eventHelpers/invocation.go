@@ -0,0 +1,136 @@
+package eventHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// MetricSender sends a metric with the given value and tags, as returned by
+// ddHelpers.NewMetricSender.
+type MetricSender func(metric string, value float64, tags ...string)
+
+// InvocationHandler handles the raw invocation payloads of a Lambda handler that processes
+// S3 event records, which are S3 events (possibly wrapped in an SQS or SNS envelope),
+// administrative requests, or health-check requests (see Unwrap).
+type InvocationHandler struct {
+	// Name of the handler, as given in responses to describe requests.
+	Name string
+	// Feature that must be enabled for the handler to process records.
+	Feature          configHelpers.Feature
+	DisabledFeatures configHelpers.FeatureSet
+	// Env is the handler's environment configuration (a struct or pointer to struct),
+	// which is described (with secrets redacted) in responses to describe requests.
+	Env interface{}
+	// Sources returns the description of the handler's configured sources.
+	Sources    func() interface{}
+	Logger     log.Logger
+	SendMetric MetricSender
+
+	// HealthCheck handles health-check requests.
+	HealthCheck func(ctx context.Context) HealthReport
+	// HandleRecords processes the records of administrative requests.
+	HandleRecords func(ctx context.Context, records []events.S3EventRecord) ([]RecordResult, error)
+	// HandleEvent processes the records of every other invocation.
+	HandleEvent func(ctx context.Context, event events.S3Event) error
+}
+
+// Handle handles a raw invocation payload.
+// For health-check requests, the health report is returned as the invocation response when it
+// is healthy, or as a *HealthCheckError (which fails the invocation) when it is not.
+// For describe requests, the DescribeResponse is returned as the invocation response,
+// even while the handler's feature is disabled.
+// For other administrative requests, the per-record results are returned as the invocation
+// response in an AdminResponse.
+// Records are otherwise skipped without side effects while the handler's feature is disabled.
+func (h InvocationHandler) Handle(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	unwrapped, err := Unwrap(payload)
+	if err != nil {
+		return nil, log.Errorf(h.Logger, "failed to unwrap invocation payload", err)
+	}
+
+	if unwrapped.HealthCheck {
+		report := h.HealthCheck(ctx)
+		if err := report.Err(); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+	if unwrapped.IsDescribe() {
+		log.Info(h.Logger, "Handling admin invocation", "admin_invocation", true,
+			"admin_action", unwrapped.Admin.Action)
+		return NewDescribeResponse(h.Name, h.Env, h.DisabledFeatures, h.Sources()), nil
+	}
+	if h.DisabledFeatures.SkipIfDisabled(h.Logger, h.SendMetric, h.Feature, len(unwrapped.Records)) {
+		return nil, nil
+	}
+
+	LogEventVersions(h.Logger, h.SendMetric, unwrapped)
+
+	if unwrapped.IsAdmin() {
+		logger := log.With(h.Logger, "admin_invocation", true, "admin_action", unwrapped.Admin.Action)
+		log.Info(logger, "Handling admin invocation",
+			"bucket", unwrapped.Admin.Bucket, "key", unwrapped.Admin.Key)
+		results, err := h.HandleRecords(ctx, unwrapped.Records)
+		if err != nil {
+			log.Warn(logger, "Admin invocation completed with failures", "error", err)
+		}
+		return AdminResponse{Action: unwrapped.Admin.Action, Results: results}, nil
+	}
+
+	return nil, h.HandleEvent(ctx, events.S3Event{Records: unwrapped.Records})
+}
+
+// LogEventVersions warns about records of unknown S3 event notification schema versions,
+// which are processed on a best-effort basis rather than rejected, and logs the restored copy
+// details of ObjectRestore:Completed records.
+func LogEventVersions(logger log.Logger, sendMetric MetricSender, payload Payload) {
+	for i, record := range payload.Records {
+		logger := log.With(logger, "event_name", record.EventName, "event_version", record.EventVersion,
+			"bucket", record.S3.Bucket.Name, "key", record.S3.Object.Key)
+		if !IsKnownS3EventVersion(record.EventVersion) {
+			log.Warn(logger, "Processing S3 event record of unknown version on a best-effort basis",
+				"known_versions", strings.Join(KnownS3EventVersions, ","))
+			sendMetric("record.unknown_event_version", 1, "event_version:"+record.EventVersion)
+		}
+		if i < len(payload.Details) && payload.Details[i].GlacierEventData != nil {
+			restore := payload.Details[i].GlacierEventData.RestoreEventData
+			log.Info(logger, "S3 event record describes a restored copy of an archived object",
+				"restoration_expiry_time", restore.LifecycleRestorationExpiryTime,
+				"restore_storage_class", restore.LifecycleRestoreStorageClass)
+		}
+	}
+}
+
+// NotifyFailure posts a notification about the failed invocation with notifier (which may be nil
+// when no webhook is configured). Failure to notify is logged and counted by the
+// notification.failed metric, but never fails the invocation.
+func NotifyFailure(ctx context.Context, logger log.Logger, sendMetric MetricSender, notifier *FailureNotifier, err error) {
+	if notifyErr := notifier.Notify(ctx, err); notifyErr != nil {
+		log.Warn(logger, "Failed to send failure notification", "error", notifyErr)
+		sendMetric("notification.failed", 1)
+	}
+}
+
+// HandleRecords invokes fn for every record concurrently (see ProcessRecords) or, when inOrder
+// is true, sequentially in order of bucket and key (see ProcessRecordsInOrder).
+// When timeout is positive, fn is invoked with a record context (see WithRecordTimeout),
+// so that each record that is not processed within that duration (or before the invocation's
+// deadline, if sooner) fails.
+func HandleRecords(ctx context.Context, records []events.S3EventRecord, inOrder bool, timeout time.Duration,
+	fn RecordHandlerFunc) ([]RecordResult, error) {
+	process := ProcessRecords
+	if inOrder {
+		process = ProcessRecordsInOrder
+	}
+	return process(ctx, records, func(ctx context.Context, i int, record events.S3EventRecord) error {
+		ctx, cancel := WithRecordTimeout(ctx, timeout)
+		defer cancel()
+		return fn(ctx, i, record)
+	})
+}
@@ -0,0 +1,197 @@
+package eventHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/configHelpers"
+)
+
+type sentMetrics []string
+
+func (m *sentMetrics) send(metric string, value float64, tags ...string) {
+	*m = append(*m, metric)
+}
+
+func TestInvocationHandler(t *testing.T) {
+	event, err := os.ReadFile("fixtures/s3_event_v2.1.json")
+	require.NoError(t, err)
+	type testEnvironment struct{ LogLevel string }
+
+	newHandler := func(disabled configHelpers.FeatureSet, metrics *sentMetrics) (*InvocationHandler, *[]string) {
+		calls := &[]string{}
+		return &InvocationHandler{
+			Name:             "TestHandler",
+			Feature:          configHelpers.FeatureReceiveEmail,
+			DisabledFeatures: disabled,
+			Env:              testEnvironment{LogLevel: "INFO"},
+			Sources:          func() interface{} { return []string{"test-source"} },
+			Logger:           log.NewNopLogger(),
+			SendMetric:       metrics.send,
+			HealthCheck: func(ctx context.Context) HealthReport {
+				*calls = append(*calls, "health")
+				return HealthReport{Healthy: false}
+			},
+			HandleRecords: func(ctx context.Context, records []events.S3EventRecord) ([]RecordResult, error) {
+				*calls = append(*calls, "records")
+				return []RecordResult{{Status: RecordStatusFailed}}, errors.New("record failed")
+			},
+			HandleEvent: func(ctx context.Context, event events.S3Event) error {
+				*calls = append(*calls, "event")
+				return nil
+			},
+		}, calls
+	}
+
+	t.Run("health check", func(t *testing.T) {
+		h, calls := newHandler(configHelpers.FeatureSet{}, &sentMetrics{})
+		resp, err := h.Handle(context.TODO(), json.RawMessage(`{"healthcheck": true}`))
+		var healthErr *HealthCheckError
+		require.ErrorAs(t, err, &healthErr, "Unhealthy reports should fail the invocation")
+		assert.Equal(t, HealthReport{Healthy: false}, healthErr.Report)
+		assert.Nil(t, resp)
+		assert.Equal(t, []string{"health"}, *calls)
+	})
+
+	t.Run("healthy health check", func(t *testing.T) {
+		h, calls := newHandler(configHelpers.FeatureSet{}, &sentMetrics{})
+		h.HealthCheck = func(ctx context.Context) HealthReport {
+			*calls = append(*calls, "health")
+			return HealthReport{Healthy: true}
+		}
+		resp, err := h.Handle(context.TODO(), json.RawMessage(`{"healthcheck": true}`))
+		require.NoError(t, err)
+		assert.Equal(t, HealthReport{Healthy: true}, resp)
+		assert.Equal(t, []string{"health"}, *calls)
+	})
+
+	t.Run("describe while feature is disabled", func(t *testing.T) {
+		disabled := configHelpers.FeatureSet{configHelpers.FeatureReceiveEmail: true}
+		h, calls := newHandler(disabled, &sentMetrics{})
+		resp, err := h.Handle(context.TODO(), json.RawMessage(`{"adminAction": "describe"}`))
+		require.NoError(t, err)
+		require.IsType(t, DescribeResponse{}, resp)
+		assert.Equal(t, "TestHandler", resp.(DescribeResponse).Handler)
+		assert.Equal(t, []string{"test-source"}, resp.(DescribeResponse).Sources)
+		assert.Empty(t, *calls)
+	})
+
+	t.Run("admin request returns results", func(t *testing.T) {
+		h, calls := newHandler(configHelpers.FeatureSet{}, &sentMetrics{})
+		resp, err := h.Handle(context.TODO(), json.RawMessage(
+			`{"adminAction": "reprocess", "bucket": "my-bucket", "key": "sources/2023/4/24"}`))
+		require.NoError(t, err, "Record failures should be reported in the response")
+		assert.Equal(t, AdminResponse{
+			Action:  "reprocess",
+			Results: []RecordResult{{Status: RecordStatusFailed}},
+		}, resp)
+		assert.Equal(t, []string{"records"}, *calls)
+	})
+
+	t.Run("event", func(t *testing.T) {
+		h, calls := newHandler(configHelpers.FeatureSet{}, &sentMetrics{})
+		resp, err := h.Handle(context.TODO(), event)
+		require.NoError(t, err)
+		assert.Nil(t, resp)
+		assert.Equal(t, []string{"event"}, *calls)
+	})
+
+	t.Run("event while feature is disabled", func(t *testing.T) {
+		disabled := configHelpers.FeatureSet{configHelpers.FeatureReceiveEmail: true}
+		metrics := &sentMetrics{}
+		h, calls := newHandler(disabled, metrics)
+		resp, err := h.Handle(context.TODO(), event)
+		require.NoError(t, err)
+		assert.Nil(t, resp)
+		assert.Empty(t, *calls, "Records should be skipped without side effects")
+		assert.Equal(t, sentMetrics{configHelpers.SkippedDisabledMetric}, *metrics)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		h, calls := newHandler(configHelpers.FeatureSet{}, &sentMetrics{})
+		_, err := h.Handle(context.TODO(), json.RawMessage(`{"foo": "bar"}`))
+		assert.Error(t, err)
+		assert.Empty(t, *calls)
+	})
+}
+
+func TestLogEventVersions(t *testing.T) {
+	for _, tt := range []struct {
+		fixture  string
+		expected sentMetrics
+	}{
+		{"fixtures/s3_event_v2.1.json", nil},
+		{"fixtures/s3_event_v2.3_unknown.json", sentMetrics{"record.unknown_event_version"}},
+	} {
+		t.Run(tt.fixture, func(t *testing.T) {
+			raw, err := os.ReadFile(tt.fixture)
+			require.NoError(t, err)
+			payload, err := Unwrap(raw)
+			require.NoError(t, err)
+			metrics := sentMetrics(nil)
+			LogEventVersions(log.NewNopLogger(), metrics.send, payload)
+			assert.Equal(t, tt.expected, metrics)
+		})
+	}
+}
+
+func TestNotifyFailure(t *testing.T) {
+	invocationErr := NewInvocationError([]RecordResult{{Status: RecordStatusFailed}}, errors.New("failed"))
+
+	t.Run("no notifier", func(t *testing.T) {
+		metrics := sentMetrics(nil)
+		NotifyFailure(context.TODO(), log.NewNopLogger(), metrics.send, nil, invocationErr)
+		assert.Empty(t, metrics)
+	})
+
+	t.Run("failure to notify is counted", func(t *testing.T) {
+		server := httptest.NewServer(&webhookRecorder{status: http.StatusInternalServerError})
+		defer server.Close()
+		notifier, err := NewFailureNotifier("TestHandler", server.URL, NotificationFormatJSON, "")
+		require.NoError(t, err)
+		metrics := sentMetrics(nil)
+		NotifyFailure(context.TODO(), log.NewNopLogger(), metrics.send, notifier, invocationErr)
+		assert.Equal(t, sentMetrics{"notification.failed"}, metrics)
+	})
+}
+
+func TestHandleRecords(t *testing.T) {
+	records := []events.S3EventRecord{
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "2"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "a"}, Object: events.S3Object{Key: "1"}}},
+	}
+
+	t.Run("in order", func(t *testing.T) {
+		handled := []string{}
+		results, err := HandleRecords(context.TODO(), records, true, 0,
+			func(ctx context.Context, i int, record events.S3EventRecord) error {
+				handled = append(handled, record.S3.Object.Key)
+				return nil
+			})
+		require.NoError(t, err)
+		assert.Len(t, results, 2)
+		assert.Equal(t, []string{"1", "2"}, handled)
+	})
+
+	t.Run("record timeout", func(t *testing.T) {
+		results, err := HandleRecords(context.TODO(), records, false, time.Millisecond,
+			func(ctx context.Context, i int, record events.S3EventRecord) error {
+				<-ctx.Done()
+				return RecordTimeoutError(ctx, ctx.Err())
+			})
+		assert.ErrorIs(t, err, ErrRecordTimeout)
+		for _, result := range results {
+			assert.Equal(t, RecordStatusFailed, result.Status)
+		}
+	})
+}
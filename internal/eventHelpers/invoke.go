@@ -0,0 +1,76 @@
+package eventHelpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// DefaultFunctionInvokeURL is the invocation endpoint of a function that is run locally by
+	// the Lambda Runtime Interface Emulator.
+	DefaultFunctionInvokeURL = "http://localhost:9000/2015-03-31/functions/function/invocations"
+	// functionErrorHeader is set by the Lambda Invoke API when a function returns an error.
+	functionErrorHeader = "X-Amz-Function-Error"
+)
+
+var ErrFunctionFailed = errors.New("function invocation failed")
+
+// functionResponse is the payload returned by an invocation of a function subscribed to an
+// SQS queue, which either reports batch item failures or describes an error.
+type functionResponse struct {
+	events.SQSEventResponse
+	ErrorType    string `json:"errorType"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// InvokeFunctionHandler returns an SQSBatchHandler that delivers each batch to a Lambda function
+// by posting the event to invokeURL, which is the invocation endpoint of the Lambda Invoke API
+// as served by the Lambda Runtime Interface Emulator or `sam local start-lambda`.
+// Batch item failures reported by the function are returned in the response. Returns an error
+// wrapping ErrFunctionFailed when the function returns an error.
+func InvokeFunctionHandler(client HTTPClient, invokeURL string) SQSBatchHandler {
+	return func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return events.SQSEventResponse{}, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, invokeURL, bytes.NewReader(payload))
+		if err != nil {
+			return events.SQSEventResponse{}, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return events.SQSEventResponse{}, fmt.Errorf("error invoking function: %w", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return events.SQSEventResponse{}, fmt.Errorf("error reading function response: %w", err)
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 || resp.Header.Get(functionErrorHeader) != "" {
+			return events.SQSEventResponse{}, fmt.Errorf("%w: status %d: %s",
+				ErrFunctionFailed, resp.StatusCode, bytes.TrimSpace(body))
+		}
+		var result functionResponse
+		// Functions that only return an error respond with null (or nothing) when successful
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &result); err != nil {
+				return events.SQSEventResponse{}, fmt.Errorf("error decoding function response: %w", err)
+			}
+		}
+		if result.ErrorType != "" || result.ErrorMessage != "" {
+			return events.SQSEventResponse{}, fmt.Errorf("%w: %s: %s",
+				ErrFunctionFailed, result.ErrorType, result.ErrorMessage)
+		}
+		return result.SQSEventResponse, nil
+	}
+}
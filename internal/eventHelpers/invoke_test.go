@@ -0,0 +1,73 @@
+package eventHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertFunctionFailed(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+	return assert.ErrorIs(t, err, ErrFunctionFailed, msgAndArgs...)
+}
+
+func TestInvokeFunctionHandler(t *testing.T) {
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "m1", Body: "first"},
+		{MessageId: "m2", Body: "second"},
+	}}
+
+	for _, tt := range []struct {
+		name             string
+		status           int
+		header           string
+		body             string
+		expectedFailures []events.SQSBatchItemFailure
+		assertErr        assert.ErrorAssertionFunc
+	}{
+		{"null response", http.StatusOK, "", "null", nil, assert.NoError},
+		{"empty response", http.StatusOK, "", "", nil, assert.NoError},
+		{"batch item failures", http.StatusOK, "",
+			`{"batchItemFailures": [{"itemIdentifier": "m2"}]}`,
+			[]events.SQSBatchItemFailure{{ItemIdentifier: "m2"}}, assert.NoError},
+		{"error response", http.StatusOK, "",
+			`{"errorType": "errorString", "errorMessage": "download failed"}`, nil, assertFunctionFailed},
+		{"function error header", http.StatusOK, "Unhandled", `"exit status 2"`, nil, assertFunctionFailed},
+		{"unsuccessful status", http.StatusBadGateway, "", "", nil, assertFunctionFailed},
+		{"malformed response", http.StatusOK, "", "{", nil, assert.Error},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var received events.SQSEvent
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				body, _ := io.ReadAll(r.Body)
+				assert.NoError(t, json.Unmarshal(body, &received))
+				if tt.header != "" {
+					w.Header().Set(functionErrorHeader, tt.header)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			handler := InvokeFunctionHandler(server.Client(), server.URL)
+			resp, err := handler(context.TODO(), event)
+			assert.Equal(t, event, received, "function should be invoked with the event")
+			tt.assertErr(t, err)
+			assert.Equal(t, tt.expectedFailures, resp.BatchItemFailures)
+		})
+	}
+
+	t.Run("unreachable endpoint", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		_, err := InvokeFunctionHandler(server.Client(), server.URL)(context.TODO(), event)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrFunctionFailed)
+	})
+}
@@ -0,0 +1,210 @@
+package eventHelpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const (
+	// NotificationFormatJSON posts a FailureNotification as a generic JSON document.
+	NotificationFormatJSON = "json"
+	// NotificationFormatSlack posts a Slack incoming webhook message.
+	NotificationFormatSlack = "slack"
+	// NotificationTimeout is the default maximum duration of a failure notification request.
+	NotificationTimeout = 5 * time.Second
+	// notificationMaxKeys is the maximum number of failed record keys listed in a Slack message.
+	notificationMaxKeys = 10
+)
+
+var ErrUnknownNotificationFormat = errors.New("unknown notification format")
+
+// HTTPClient sends HTTP requests, as implemented by *http.Client.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// FailureNotification describes a failed invocation to a webhook endpoint.
+type FailureNotification struct {
+	Handler        string         `json:"handler"`
+	RequestID      string         `json:"requestId,omitempty"`
+	TotalRecords   int            `json:"totalRecords"`
+	Failed         int            `json:"failed"`
+	ErrorClasses   map[string]int `json:"errorClasses"`
+	FailedKeys     []string       `json:"failedKeys"`
+	OmittedRecords int            `json:"omittedRecords"`
+	LogsURL        string         `json:"logsUrl,omitempty"`
+}
+
+// FailureNotifier posts a notification to a webhook endpoint when an invocation fails to
+// process one or more records. A nil *FailureNotifier sends no notifications.
+type FailureNotifier struct {
+	// Handler names the Lambda handler in notifications, e.g. "ReceiveFFISEmail".
+	Handler string
+	// URL is the webhook endpoint to which notifications are posted.
+	URL string
+	// Format is NotificationFormatJSON or NotificationFormatSlack.
+	Format string
+	// LogsURLTemplate, when not empty, is rendered as a link to the invocation's logs.
+	// The placeholders {function_name}, {log_group}, {log_stream}, and {request_id} are
+	// replaced by the corresponding (query-escaped) values of the invocation.
+	LogsURLTemplate string
+	// Client sends notification requests.
+	Client HTTPClient
+	// Timeout is the maximum duration of each notification request.
+	Timeout time.Duration
+
+	mu                sync.Mutex
+	notifiedRequestID string
+}
+
+// NewFailureNotifier returns a FailureNotifier which posts notifications about failed invocations
+// of the named handler to webhookURL in the given format.
+// Returns nil when webhookURL is empty, i.e. when notifications are disabled.
+func NewFailureNotifier(handler, webhookURL, format, logsURLTemplate string) (*FailureNotifier, error) {
+	if webhookURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(webhookURL); err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != NotificationFormatJSON && format != NotificationFormatSlack {
+		return nil, fmt.Errorf("%w %q: must be one of %s, %s", ErrUnknownNotificationFormat,
+			format, NotificationFormatJSON, NotificationFormatSlack)
+	}
+	return &FailureNotifier{
+		Handler:         handler,
+		URL:             webhookURL,
+		Format:          format,
+		LogsURLTemplate: logsURLTemplate,
+		Client:          &http.Client{},
+		Timeout:         NotificationTimeout,
+	}, nil
+}
+
+// Notify posts a notification describing err when it is an *InvocationError; any other error
+// (including nil) is ignored. At most one notification is posted per Lambda invocation (as
+// identified by the request ID of ctx), so that a storm of failures produces a single message.
+// The returned error describes a failure to post the notification, which callers should log
+// rather than fail the invocation.
+func (n *FailureNotifier) Notify(ctx context.Context, err error) error {
+	var invocationErr *InvocationError
+	if n == nil || !errors.As(err, &invocationErr) {
+		return nil
+	}
+
+	requestID := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+	n.mu.Lock()
+	if requestID != "" && requestID == n.notifiedRequestID {
+		n.mu.Unlock()
+		return nil
+	}
+	n.notifiedRequestID = requestID
+	n.mu.Unlock()
+
+	notification := n.notification(requestID, invocationErr.Summary)
+	var body interface{} = notification
+	if n.Format == NotificationFormatSlack {
+		body = map[string]string{"text": slackText(notification)}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error encoding failure notification: %w", err)
+	}
+
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = NotificationTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("error creating failure notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting failure notification: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failure notification was rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *FailureNotifier) notification(requestID string, summary FailureSummary) FailureNotification {
+	notification := FailureNotification{
+		Handler:        n.Handler,
+		RequestID:      requestID,
+		TotalRecords:   summary.TotalRecords,
+		Failed:         summary.Failed,
+		ErrorClasses:   summary.ErrorClasses,
+		FailedKeys:     []string{},
+		OmittedRecords: summary.OmittedRecords,
+	}
+	for _, record := range summary.Records {
+		notification.FailedKeys = append(notification.FailedKeys, record.Key)
+	}
+	if n.LogsURLTemplate != "" {
+		notification.LogsURL = strings.NewReplacer(
+			"{function_name}", url.QueryEscape(lambdacontext.FunctionName),
+			"{log_group}", url.QueryEscape(lambdacontext.LogGroupName),
+			"{log_stream}", url.QueryEscape(lambdacontext.LogStreamName),
+			"{request_id}", url.QueryEscape(requestID),
+		).Replace(n.LogsURLTemplate)
+	}
+	return notification
+}
+
+// slackText renders a notification as the text of a compact Slack message.
+func slackText(n FailureNotification) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, ":rotating_light: *%s* failed to process %d of %d records", n.Handler, n.Failed, n.TotalRecords)
+
+	classes := make([]string, 0, len(n.ErrorClasses))
+	for class := range n.ErrorClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for i, class := range classes {
+		classes[i] = fmt.Sprintf("%s (%d)", class, n.ErrorClasses[class])
+	}
+	if len(classes) > 0 {
+		fmt.Fprintf(&sb, "\nError classes: %s", strings.Join(classes, ", "))
+	}
+
+	keys := n.FailedKeys
+	omitted := n.OmittedRecords
+	if len(keys) > notificationMaxKeys {
+		omitted += len(keys) - notificationMaxKeys
+		keys = keys[:notificationMaxKeys]
+	}
+	if len(keys) > 0 {
+		fmt.Fprintf(&sb, "\nFailed keys: `%s`", strings.Join(keys, "`, `"))
+		if omitted > 0 {
+			fmt.Fprintf(&sb, " and %d more", omitted)
+		}
+	}
+	if n.LogsURL != "" {
+		fmt.Fprintf(&sb, "\n<%s|View logs>", n.LogsURL)
+	}
+	return sb.String()
+}
@@ -0,0 +1,136 @@
+package eventHelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookRecorder struct {
+	mu     sync.Mutex
+	bodies [][]byte
+	status int
+	delay  time.Duration
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	time.Sleep(w.delay)
+	body, _ := io.ReadAll(r.Body)
+	w.mu.Lock()
+	w.bodies = append(w.bodies, body)
+	w.mu.Unlock()
+	if w.status != 0 {
+		rw.WriteHeader(w.status)
+	}
+}
+
+func TestNewFailureNotifier(t *testing.T) {
+	n, err := NewFailureNotifier("ReceiveFFISEmail", "", NotificationFormatJSON, "")
+	require.NoError(t, err)
+	assert.Nil(t, n, "notifications should be disabled without a webhook URL")
+
+	_, err = NewFailureNotifier("ReceiveFFISEmail", "https://hooks.example.com/abc", "xml", "")
+	assert.ErrorIs(t, err, ErrUnknownNotificationFormat)
+
+	_, err = NewFailureNotifier("ReceiveFFISEmail", "not a url", NotificationFormatJSON, "")
+	assert.Error(t, err)
+
+	n, err = NewFailureNotifier("ReceiveFFISEmail", "https://hooks.example.com/abc", "Slack", "")
+	require.NoError(t, err)
+	assert.Equal(t, NotificationFormatSlack, n.Format)
+}
+
+func TestFailureNotifierNotify(t *testing.T) {
+	invocationErr := NewInvocationError([]RecordResult{
+		{Bucket: "b", Key: "ok", Status: RecordStatusSucceeded},
+		{Bucket: "b", Key: "missing", Status: RecordStatusFailed, Error: "not found", ErrorClass: "NoSuchKey"},
+		{Bucket: "b", Key: "timeout", Status: RecordStatusFailed, Error: "timed out", ErrorClass: "DeadlineExceeded"},
+	}, errors.New("2 errors occurred"))
+	lambdaCtx := func(requestID string) context.Context {
+		return lambdacontext.NewContext(context.TODO(), &lambdacontext.LambdaContext{AwsRequestID: requestID})
+	}
+	setup := func(t *testing.T, format string) (*FailureNotifier, *webhookRecorder) {
+		t.Helper()
+		recorder := &webhookRecorder{}
+		server := httptest.NewServer(recorder)
+		t.Cleanup(server.Close)
+		n, err := NewFailureNotifier("ReceiveFFISEmail", server.URL, format,
+			"https://logs.example.com/?request={request_id}")
+		require.NoError(t, err)
+		return n, recorder
+	}
+
+	t.Run("generic JSON", func(t *testing.T) {
+		n, recorder := setup(t, NotificationFormatJSON)
+		require.NoError(t, n.Notify(lambdaCtx("abc-123"), invocationErr))
+		require.Len(t, recorder.bodies, 1)
+		var notification FailureNotification
+		require.NoError(t, json.Unmarshal(recorder.bodies[0], &notification))
+		assert.Equal(t, FailureNotification{
+			Handler:      "ReceiveFFISEmail",
+			RequestID:    "abc-123",
+			TotalRecords: 3,
+			Failed:       2,
+			ErrorClasses: map[string]int{"NoSuchKey": 1, "DeadlineExceeded": 1},
+			FailedKeys:   []string{"missing", "timeout"},
+			LogsURL:      "https://logs.example.com/?request=abc-123",
+		}, notification)
+	})
+
+	t.Run("Slack", func(t *testing.T) {
+		n, recorder := setup(t, NotificationFormatSlack)
+		require.NoError(t, n.Notify(lambdaCtx("abc-123"), invocationErr))
+		require.Len(t, recorder.bodies, 1)
+		var message map[string]string
+		require.NoError(t, json.Unmarshal(recorder.bodies[0], &message))
+		assert.Equal(t, ":rotating_light: *ReceiveFFISEmail* failed to process 2 of 3 records\n"+
+			"Error classes: DeadlineExceeded (1), NoSuchKey (1)\n"+
+			"Failed keys: `missing`, `timeout`\n"+
+			"<https://logs.example.com/?request=abc-123|View logs>", message["text"])
+	})
+
+	t.Run("at most one notification per invocation", func(t *testing.T) {
+		n, recorder := setup(t, NotificationFormatJSON)
+		require.NoError(t, n.Notify(lambdaCtx("abc-123"), invocationErr))
+		require.NoError(t, n.Notify(lambdaCtx("abc-123"), invocationErr))
+		assert.Len(t, recorder.bodies, 1)
+		require.NoError(t, n.Notify(lambdaCtx("def-456"), invocationErr))
+		assert.Len(t, recorder.bodies, 2)
+	})
+
+	t.Run("other errors are ignored", func(t *testing.T) {
+		n, recorder := setup(t, NotificationFormatJSON)
+		require.NoError(t, n.Notify(lambdaCtx("abc-123"), nil))
+		require.NoError(t, n.Notify(lambdaCtx("abc-123"), errors.New("unwrap failed")))
+		assert.Empty(t, recorder.bodies)
+	})
+
+	t.Run("disabled notifier", func(t *testing.T) {
+		var n *FailureNotifier
+		assert.NoError(t, n.Notify(lambdaCtx("abc-123"), invocationErr))
+	})
+
+	t.Run("rejected notification", func(t *testing.T) {
+		n, recorder := setup(t, NotificationFormatJSON)
+		recorder.status = http.StatusInternalServerError
+		assert.ErrorContains(t, n.Notify(lambdaCtx("abc-123"), invocationErr), "500")
+	})
+
+	t.Run("notification times out", func(t *testing.T) {
+		n, recorder := setup(t, NotificationFormatJSON)
+		recorder.delay = 100 * time.Millisecond
+		n.Timeout = 10 * time.Millisecond
+		err := n.Notify(lambdaCtx("abc-123"), invocationErr)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
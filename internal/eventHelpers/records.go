@@ -0,0 +1,194 @@
+package eventHelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/hashicorp/go-multierror"
+	"github.com/usdigitalresponse/grants-ingest/internal/sentryHelpers"
+)
+
+const (
+	RecordStatusSucceeded = "succeeded"
+	RecordStatusFailed    = "failed"
+)
+
+const (
+	// S3EventObjectRestoreCompleted is the name of events that S3 emits once a temporary copy of
+	// an archived (e.g. Glacier) object has been restored, at which point the object is readable.
+	S3EventObjectRestoreCompleted = "ObjectRestore:Completed"
+
+	s3EventObjectRestorePrefix = "ObjectRestore:"
+)
+
+var (
+	ErrRecordPanicked = errors.New("panic while handling record")
+	// ErrRecordTimeout indicates that a record was not processed within its record timeout
+	// (see WithRecordTimeout).
+	ErrRecordTimeout = errors.New("record processing timed out")
+)
+
+// RecordResult describes the outcome of handling a single S3 event record.
+type RecordResult struct {
+	Bucket     string `json:"bucket"`
+	Key        string `json:"key"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+// AdminResponse is returned as the Lambda response for administrative invocations so that
+// operators can see the outcome of their request immediately.
+type AdminResponse struct {
+	Action  string         `json:"adminAction"`
+	Results []RecordResult `json:"results"`
+}
+
+// FilterProcessableRecords partitions records into those that should be processed and those that
+// should be skipped. ObjectRestore:Completed records are processable (just as though the object
+// had been created) only when processRestoreEvents is true. Records of other restore events,
+// such as ObjectRestore:Post (which is emitted when a restore is initiated), are always skipped
+// since the object cannot yet be read. All other records are processable.
+func FilterProcessableRecords(records []events.S3EventRecord, processRestoreEvents bool) (
+	processable, skipped []events.S3EventRecord,
+) {
+	processable = []events.S3EventRecord{}
+	for _, record := range records {
+		name := strings.TrimPrefix(record.EventName, "s3:")
+		if strings.HasPrefix(name, s3EventObjectRestorePrefix) &&
+			!(processRestoreEvents && name == S3EventObjectRestoreCompleted) {
+			skipped = append(skipped, record)
+			continue
+		}
+		processable = append(processable, record)
+	}
+	return processable, skipped
+}
+
+type recordTimeoutKey struct{}
+
+// recordTimeout identifies a context returned by WithRecordTimeout.
+type recordTimeout struct {
+	parent  context.Context
+	timeout time.Duration
+}
+
+// WithRecordTimeout returns a copy of ctx for processing a single record, which is done once
+// timeout elapses or ctx is done, whichever is sooner. This keeps a record that is stuck
+// (e.g. on an S3 read that hangs) from consuming the rest of the invocation, so long as its
+// processing observes the context. Timeouts that are not positive are disabled.
+// Use RecordTimeoutError to distinguish errors caused by the record timeout.
+func WithRecordTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	recordCtx := context.WithValue(ctx, recordTimeoutKey{}, recordTimeout{ctx, timeout})
+	return context.WithTimeout(recordCtx, timeout)
+}
+
+// RecordTimeoutError returns err wrapped with ErrRecordTimeout when ctx (or its parent) was
+// returned by WithRecordTimeout and the record timeout elapsed before the context from which
+// it was derived was done. Otherwise (including when the invocation's own deadline was sooner
+// than the record timeout), err is returned as-is.
+func RecordTimeoutError(ctx context.Context, err error) error {
+	rt, ok := ctx.Value(recordTimeoutKey{}).(recordTimeout)
+	if err == nil || !ok || errors.Is(err, ErrRecordTimeout) ||
+		!errors.Is(ctx.Err(), context.DeadlineExceeded) || rt.parent.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("%w after %s: %w", ErrRecordTimeout, rt.timeout, err)
+}
+
+// RecordHandlerFunc processes a single S3 event record.
+type RecordHandlerFunc func(ctx context.Context, i int, record events.S3EventRecord) error
+
+// ProcessRecords concurrently invokes fn for every record, returning a result for each
+// record (in the same order as records) along with a multi-error that contains any and all
+// errors returned by fn. A panic in fn is recovered and treated as a failure of its record
+// (wrapping ErrRecordPanicked), so that it cannot prevent other records from being handled.
+// Each record failure is reported to Sentry, when enabled.
+// Returns a nil error when every record was handled successfully.
+func ProcessRecords(ctx context.Context, records []events.S3EventRecord, fn RecordHandlerFunc) ([]RecordResult, error) {
+	results := make([]RecordResult, len(records))
+	wg := multierror.Group{}
+	for i, record := range records {
+		i, record := i, record
+		wg.Go(func() (err error) {
+			results[i], err = processRecord(ctx, i, record, fn)
+			return err
+		})
+	}
+	return results, wg.Wait().ErrorOrNil()
+}
+
+// ProcessRecordsInOrder behaves like ProcessRecords, except that records are handled
+// sequentially in order of their bucket name and object key, rather than concurrently.
+// This trades throughput for a reproducible processing order, which is useful when debugging.
+// As with ProcessRecords, results are returned in the same order as records, and the index
+// passed to fn is the record's index in records.
+func ProcessRecordsInOrder(ctx context.Context, records []events.S3EventRecord, fn RecordHandlerFunc) ([]RecordResult, error) {
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		recordA, recordB := records[order[a]].S3, records[order[b]].S3
+		if recordA.Bucket.Name != recordB.Bucket.Name {
+			return recordA.Bucket.Name < recordB.Bucket.Name
+		}
+		return recordA.Object.Key < recordB.Object.Key
+	})
+
+	results := make([]RecordResult, len(records))
+	errs := &multierror.Error{}
+	for _, i := range order {
+		var err error
+		results[i], err = processRecord(ctx, i, records[i], fn)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return results, errs.ErrorOrNil()
+}
+
+// processRecord invokes fn for a single record, recovering from any panic in fn and
+// reporting failures to Sentry.
+func processRecord(ctx context.Context, i int, record events.S3EventRecord, fn RecordHandlerFunc) (result RecordResult, err error) {
+	panicked := false
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			err = fmt.Errorf("%w: %v", ErrRecordPanicked, r)
+		}
+		result = newRecordResult(record, err)
+		if err != nil {
+			sentryHelpers.CaptureRecordFailure(ctx, sentryHelpers.RecordFailure{
+				Err:            err,
+				Classification: result.ErrorClass,
+				Bucket:         record.S3.Bucket.Name,
+				Key:            record.S3.Object.Key,
+				Panicked:       panicked,
+			})
+		}
+	}()
+	return RecordResult{}, fn(ctx, i, record)
+}
+
+func newRecordResult(record events.S3EventRecord, err error) RecordResult {
+	result := RecordResult{
+		Bucket: record.S3.Bucket.Name,
+		Key:    record.S3.Object.Key,
+		Status: RecordStatusSucceeded,
+	}
+	if err != nil {
+		result.Status = RecordStatusFailed
+		result.Error = err.Error()
+		result.ErrorClass = ClassifyError(err)
+	}
+	return result
+}
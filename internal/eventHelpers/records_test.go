@@ -0,0 +1,165 @@
+package eventHelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessRecords(t *testing.T) {
+	records := []events.S3EventRecord{
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "good"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "bad"}}},
+	}
+	results, err := ProcessRecords(context.TODO(), records,
+		func(ctx context.Context, i int, record events.S3EventRecord) error {
+			if record.S3.Object.Key == "bad" {
+				return errors.New("oh no")
+			}
+			return nil
+		})
+	assert.ErrorContains(t, err, "oh no")
+	require.Len(t, results, 2)
+	assert.Equal(t, RecordResult{Bucket: "b", Key: "good", Status: RecordStatusSucceeded}, results[0])
+	assert.Equal(t, RecordResult{
+		Bucket:     "b",
+		Key:        "bad",
+		Status:     RecordStatusFailed,
+		Error:      "oh no",
+		ErrorClass: "oh no",
+	}, results[1])
+}
+
+func TestProcessRecordsRecoversPanics(t *testing.T) {
+	records := []events.S3EventRecord{
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "good"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "panics"}}},
+	}
+	results, err := ProcessRecords(context.TODO(), records,
+		func(ctx context.Context, i int, record events.S3EventRecord) error {
+			if record.S3.Object.Key == "panics" {
+				var m map[string]int
+				m["boom"]++
+			}
+			return nil
+		})
+	assert.ErrorIs(t, err, ErrRecordPanicked)
+	require.Len(t, results, 2)
+	assert.Equal(t, RecordStatusSucceeded, results[0].Status)
+	assert.Equal(t, RecordStatusFailed, results[1].Status)
+	assert.Contains(t, results[1].Error, "assignment to entry in nil map")
+}
+
+func TestProcessRecordsInOrder(t *testing.T) {
+	records := []events.S3EventRecord{
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "c"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "a"}, Object: events.S3Object{Key: "z"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "a"}}},
+		{S3: events.S3Entity{Bucket: events.S3Bucket{Name: "b"}, Object: events.S3Object{Key: "panics"}}},
+	}
+	var processed []string
+	results, err := ProcessRecordsInOrder(context.TODO(), records,
+		func(ctx context.Context, i int, record events.S3EventRecord) error {
+			processed = append(processed, record.S3.Bucket.Name+"/"+record.S3.Object.Key)
+			assert.Equal(t, records[i], record, "Index should refer to the record's original position")
+			if record.S3.Object.Key == "panics" {
+				panic("boom")
+			}
+			return nil
+		})
+	assert.ErrorIs(t, err, ErrRecordPanicked)
+	assert.Equal(t, []string{"a/z", "b/a", "b/c", "b/panics"}, processed)
+	require.Len(t, results, 4)
+	for i, result := range results {
+		assert.Equal(t, records[i].S3.Object.Key, result.Key, "Results should be in the order of records")
+	}
+	assert.Equal(t, RecordStatusFailed, results[3].Status)
+}
+
+func TestRecordTimeout(t *testing.T) {
+	blockUntilDone := func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("operation error S3: GetObject, %w", ctx.Err())
+	}
+
+	t.Run("elapsed record timeout is classified", func(t *testing.T) {
+		ctx, cancel := WithRecordTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		childCtx, childCancel := context.WithCancel(ctx) // e.g. a span's context
+		defer childCancel()
+		err := RecordTimeoutError(childCtx, blockUntilDone(childCtx))
+		assert.ErrorIs(t, err, ErrRecordTimeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "after 10ms")
+		assert.Equal(t, "RecordTimeout", ClassifyError(err))
+		assert.Equal(t, err, RecordTimeoutError(ctx, err), "error should not be wrapped twice")
+	})
+
+	t.Run("sooner invocation deadline is not a record timeout", func(t *testing.T) {
+		invocationCtx, cancelInvocation := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancelInvocation()
+		ctx, cancel := WithRecordTimeout(invocationCtx, time.Hour)
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		invocationDeadline, _ := invocationCtx.Deadline()
+		assert.Equal(t, invocationDeadline, deadline, "sooner deadline should apply")
+		err := RecordTimeoutError(ctx, blockUntilDone(ctx))
+		assert.NotErrorIs(t, err, ErrRecordTimeout)
+		assert.Equal(t, "DeadlineExceeded", ClassifyError(err))
+	})
+
+	t.Run("errors before the timeout are unchanged", func(t *testing.T) {
+		ctx, cancel := WithRecordTimeout(context.Background(), time.Hour)
+		defer cancel()
+		err := errors.New("oh no")
+		assert.Equal(t, err, RecordTimeoutError(ctx, err))
+		assert.NoError(t, RecordTimeoutError(ctx, nil))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		parent := context.Background()
+		ctx, cancel := WithRecordTimeout(parent, 0)
+		defer cancel()
+		assert.Equal(t, parent, ctx)
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+	})
+}
+
+func TestFilterProcessableRecords(t *testing.T) {
+	records := []events.S3EventRecord{
+		{EventName: "ObjectCreated:Put"},
+		{EventName: "ObjectRestore:Post"},
+		{EventName: S3EventObjectRestoreCompleted},
+		{EventName: "ObjectRestore:Delete"},
+		{EventName: AdminReprocessEventName},
+	}
+	eventNames := func(records []events.S3EventRecord) []string {
+		names := []string{}
+		for _, r := range records {
+			names = append(names, r.EventName)
+		}
+		return names
+	}
+
+	t.Run("restore events disabled", func(t *testing.T) {
+		processable, skipped := FilterProcessableRecords(records, false)
+		assert.Equal(t, []string{"ObjectCreated:Put", AdminReprocessEventName}, eventNames(processable))
+		assert.Equal(t, []string{"ObjectRestore:Post", "ObjectRestore:Completed", "ObjectRestore:Delete"},
+			eventNames(skipped))
+	})
+
+	t.Run("restore events enabled", func(t *testing.T) {
+		processable, skipped := FilterProcessableRecords(records, true)
+		assert.Equal(t, []string{"ObjectCreated:Put", "ObjectRestore:Completed", AdminReprocessEventName},
+			eventNames(processable))
+		assert.Equal(t, []string{"ObjectRestore:Post", "ObjectRestore:Delete"}, eventNames(skipped))
+	})
+}
@@ -0,0 +1,269 @@
+package eventHelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/usdigitalresponse/grants-ingest/internal/log"
+)
+
+// Defaults for unset SQSPoller fields
+const (
+	DefaultSQSPollerBatchSize = 1
+	DefaultSQSPollerWaitTime  = 20
+	// maxSQSPollerBatchSize is the maximum number of messages that SQS returns per receive request.
+	maxSQSPollerBatchSize = 10
+)
+
+// ErrSQSPollerShutdownTimeout is returned by SQSPoller.Run when a batch in progress is not
+// handled within the poller's ShutdownTimeout after the poller is stopped.
+var ErrSQSPollerShutdownTimeout = errors.New("batch in progress was not handled before the shutdown timeout")
+
+// SQSPollerAPI receives and deletes messages from an SQS queue, as implemented by *sqs.Client.
+type SQSPollerAPI interface {
+	ReceiveMessage(ctx context.Context,
+		params *sqs.ReceiveMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context,
+		params *sqs.DeleteMessageInput,
+		optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+// SQSBatchHandler handles a batch of SQS messages in the manner of a Lambda function that is
+// subscribed to an SQS queue: messages identified by the response's batch item failures have
+// failed, and every message in the batch has failed when the returned error is not nil.
+type SQSBatchHandler func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error)
+
+// SQSErrorHandler adapts a handler that reports the outcome of an entire batch as an error
+// (i.e. one that does not report batch item failures) to an SQSBatchHandler.
+func SQSErrorHandler(handler func(ctx context.Context, event events.SQSEvent) error) SQSBatchHandler {
+	return func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		return events.SQSEventResponse{}, handler(ctx, event)
+	}
+}
+
+// SQSPollerStats counts the messages handled by an SQSPoller.
+type SQSPollerStats struct {
+	Received  int
+	Succeeded int
+	Failed    int
+}
+
+// SQSPoller drives an SQS consumer outside of Lambda, which makes it possible to develop and
+// debug consumers against a local queue (e.g. LocalStack or ElasticMQ) or a real one.
+// Like a Lambda event source mapping, it long-polls the queue, invokes the handler with each
+// batch of received messages, and deletes the messages that were handled successfully.
+// Failed messages are left in the queue to be redelivered once their visibility timeout elapses.
+type SQSPoller struct {
+	Client   SQSPollerAPI
+	QueueURL string
+	// QueueARN, when set, is the event source ARN of handled messages.
+	QueueARN string
+	// Region, when set, is the AWS region of handled messages.
+	Region  string
+	Handler SQSBatchHandler
+	// Logger logs the progress of polling, and must not be nil.
+	Logger log.Logger
+
+	// BatchSize is the maximum number of messages in each batch, from 1 to 10.
+	// Defaults to DefaultSQSPollerBatchSize.
+	BatchSize int
+	// WaitTime is the number of seconds that each receive request waits for messages to arrive,
+	// from 0 to 20. Defaults to DefaultSQSPollerWaitTime; use a negative value for no wait.
+	WaitTime int
+	// VisibilityTimeout, when positive, overrides the queue's visibility timeout (in seconds)
+	// for received messages.
+	VisibilityTimeout int
+	// MaxMessages, when positive, stops polling once that many messages have been received.
+	MaxMessages int
+	// Once stops polling after the first receive request, even when no messages were received.
+	Once bool
+	// ShutdownTimeout, when positive, limits how long a batch in progress may continue once the
+	// poller is stopped. When it elapses, the handler's context is cancelled, the batch's messages
+	// are left in the queue for redelivery (and counted as failed), and Run returns
+	// ErrSQSPollerShutdownTimeout without waiting further for the handler.
+	// When zero, a batch in progress is always completed.
+	ShutdownTimeout time.Duration
+	// OnStop, when set, is called before Run returns, once any batch in progress is done or has
+	// been abandoned, e.g. to flush buffered metrics before the process exits.
+	OnStop func()
+}
+
+// Run polls the queue until ctx is done, MaxMessages have been received, or (when Once is set)
+// after a single receive request. When ctx is done while a batch is being handled, the batch is
+// handled and its successful messages are deleted before Run returns (subject to
+// ShutdownTimeout), so that stopping the poller (e.g. with Ctrl-C or SIGTERM) does not lose work
+// in progress; ctx is therefore not passed to the handler. Returns an error when the queue
+// cannot be polled, messages cannot be deleted, or the shutdown timeout elapses.
+func (p *SQSPoller) Run(ctx context.Context) (SQSPollerStats, error) {
+	if p.OnStop != nil {
+		defer p.OnStop()
+	}
+	stats := SQSPollerStats{}
+	batchSize := p.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultSQSPollerBatchSize
+	}
+	if batchSize > maxSQSPollerBatchSize {
+		return stats, fmt.Errorf("batch size must be at most %d", maxSQSPollerBatchSize)
+	}
+	waitTime := p.WaitTime
+	if waitTime == 0 {
+		waitTime = DefaultSQSPollerWaitTime
+	} else if waitTime < 0 {
+		waitTime = 0
+	}
+
+	for ctx.Err() == nil {
+		if p.MaxMessages > 0 {
+			if remaining := p.MaxMessages - stats.Received; remaining <= 0 {
+				break
+			} else if remaining < batchSize {
+				batchSize = remaining
+			}
+		}
+
+		log.Debug(p.Logger, "Polling queue for messages", "queue_url", p.QueueURL)
+		resp, err := p.Client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(p.QueueURL),
+			MaxNumberOfMessages:   int32(batchSize),
+			WaitTimeSeconds:       int32(waitTime),
+			VisibilityTimeout:     int32(p.VisibilityTimeout),
+			AttributeNames:        []sqsTypes.QueueAttributeName{sqsTypes.QueueAttributeNameAll},
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				// The poller was stopped while waiting for messages
+				break
+			}
+			return stats, log.Errorf(p.Logger, "Error receiving messages from queue", err)
+		}
+
+		if len(resp.Messages) > 0 {
+			stats.Received += len(resp.Messages)
+			succeeded, err := p.awaitBatch(ctx, resp.Messages)
+			stats.Succeeded += succeeded
+			stats.Failed += len(resp.Messages) - succeeded
+			if err != nil {
+				return stats, err
+			}
+		}
+		if p.Once {
+			break
+		}
+	}
+	log.Info(p.Logger, "Stopped polling queue", "received", stats.Received,
+		"succeeded", stats.Succeeded, "failed", stats.Failed)
+	return stats, nil
+}
+
+// awaitBatch handles messages as a batch, waiting at most ShutdownTimeout for the batch to
+// complete once ctx is done. Returns the number of successful messages.
+func (p *SQSPoller) awaitBatch(ctx context.Context, messages []sqsTypes.Message) (int, error) {
+	// The handler and deletions are not cancelled by the poller's context, so that a batch
+	// in progress is completed when the poller is stopped.
+	if p.ShutdownTimeout <= 0 {
+		return p.handleBatch(context.Background(), messages)
+	}
+	batchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type batchResult struct {
+		succeeded int
+		err       error
+	}
+	done := make(chan batchResult, 1)
+	go func() {
+		succeeded, err := p.handleBatch(batchCtx, messages)
+		done <- batchResult{succeeded, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.succeeded, result.err
+	case <-ctx.Done():
+	}
+	log.Info(p.Logger, "Waiting for batch in progress to be handled before stopping",
+		"batch_size", len(messages), "timeout", p.ShutdownTimeout)
+	timer := time.NewTimer(p.ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result.succeeded, result.err
+	case <-timer.C:
+		log.Warn(p.Logger, "Abandoned batch in progress after shutdown timeout; messages will be redelivered",
+			"batch_size", len(messages))
+		return 0, ErrSQSPollerShutdownTimeout
+	}
+}
+
+// handleBatch invokes the handler with messages and deletes those that it handled successfully.
+// Returns the number of successful messages.
+func (p *SQSPoller) handleBatch(ctx context.Context, messages []sqsTypes.Message) (int, error) {
+	event := events.SQSEvent{Records: make([]events.SQSMessage, len(messages))}
+	for i, msg := range messages {
+		event.Records[i] = p.sqsEventMessage(msg)
+	}
+
+	logger := log.With(p.Logger, "batch_size", len(messages))
+	log.Debug(logger, "Handling batch of messages")
+	resp, err := p.Handler(ctx, event)
+	if err != nil {
+		log.Warn(logger, "Handler failed batch; messages will be redelivered", "error", err)
+		return 0, nil
+	}
+	failed := make(map[string]bool, len(resp.BatchItemFailures))
+	for _, failure := range resp.BatchItemFailures {
+		failed[failure.ItemIdentifier] = true
+	}
+
+	succeeded := 0
+	for _, msg := range messages {
+		logger := log.With(logger, "message_id", aws.ToString(msg.MessageId))
+		if failed[aws.ToString(msg.MessageId)] {
+			log.Warn(logger, "Handler failed message; message will be redelivered")
+			continue
+		}
+		if _, err := p.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(p.QueueURL),
+			ReceiptHandle: msg.ReceiptHandle,
+		}); err != nil {
+			return succeeded, log.Errorf(logger, "Error deleting handled message from queue", err)
+		}
+		log.Debug(logger, "Deleted handled message from queue")
+		succeeded++
+	}
+	return succeeded, nil
+}
+
+// sqsEventMessage converts msg to its representation in the event payload of a Lambda function.
+func (p *SQSPoller) sqsEventMessage(msg sqsTypes.Message) events.SQSMessage {
+	attributes := make(map[string]events.SQSMessageAttribute, len(msg.MessageAttributes))
+	for name, attr := range msg.MessageAttributes {
+		attributes[name] = events.SQSMessageAttribute{
+			StringValue:      attr.StringValue,
+			BinaryValue:      attr.BinaryValue,
+			StringListValues: attr.StringListValues,
+			BinaryListValues: attr.BinaryListValues,
+			DataType:         aws.ToString(attr.DataType),
+		}
+	}
+	return events.SQSMessage{
+		MessageId:              aws.ToString(msg.MessageId),
+		ReceiptHandle:          aws.ToString(msg.ReceiptHandle),
+		Body:                   aws.ToString(msg.Body),
+		Md5OfBody:              aws.ToString(msg.MD5OfBody),
+		Md5OfMessageAttributes: aws.ToString(msg.MD5OfMessageAttributes),
+		Attributes:             msg.Attributes,
+		MessageAttributes:      attributes,
+		EventSourceARN:         p.QueueARN,
+		EventSource:            "aws:sqs",
+		AWSRegion:              p.Region,
+	}
+}
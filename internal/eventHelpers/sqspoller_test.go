@@ -0,0 +1,290 @@
+package eventHelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQSQueue is an in-memory queue whose received messages remain in the queue (invisible)
+// until they are deleted.
+type fakeSQSQueue struct {
+	mu        sync.Mutex
+	messages  []sqsTypes.Message
+	inFlight  map[string]sqsTypes.Message
+	deleted   []string
+	receives  []*sqs.ReceiveMessageInput
+	receiveFn func(ctx context.Context) error
+}
+
+func newFakeSQSQueue(bodies ...string) *fakeSQSQueue {
+	q := &fakeSQSQueue{inFlight: make(map[string]sqsTypes.Message)}
+	for i, body := range bodies {
+		q.messages = append(q.messages, sqsTypes.Message{
+			MessageId:     aws.String(fmt.Sprintf("msg-%d", i)),
+			ReceiptHandle: aws.String(fmt.Sprintf("receipt-%d", i)),
+			Body:          aws.String(body),
+			Attributes:    map[string]string{"ApproximateReceiveCount": "1"},
+			MessageAttributes: map[string]sqsTypes.MessageAttributeValue{
+				"SourceKey": {DataType: aws.String("String"), StringValue: aws.String("key-" + body)},
+			},
+		})
+	}
+	return q
+}
+
+func (q *fakeSQSQueue) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	q.mu.Lock()
+	q.receives = append(q.receives, params)
+	receiveFn := q.receiveFn
+	q.mu.Unlock()
+	if receiveFn != nil {
+		if err := receiveFn(ctx); err != nil {
+			return nil, err
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := int(params.MaxNumberOfMessages)
+	if n > len(q.messages) {
+		n = len(q.messages)
+	}
+	received := q.messages[:n]
+	q.messages = q.messages[n:]
+	for _, msg := range received {
+		q.inFlight[aws.ToString(msg.ReceiptHandle)] = msg
+	}
+	return &sqs.ReceiveMessageOutput{Messages: received}, nil
+}
+
+func (q *fakeSQSQueue) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msg, ok := q.inFlight[aws.ToString(params.ReceiptHandle)]
+	if !ok {
+		return nil, errors.New("receipt handle is invalid")
+	}
+	delete(q.inFlight, aws.ToString(params.ReceiptHandle))
+	q.deleted = append(q.deleted, aws.ToString(msg.MessageId))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// inFlightIDs returns the IDs of messages that were received but not deleted.
+func (q *fakeSQSQueue) inFlightIDs() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := []string{}
+	for _, msg := range q.inFlight {
+		ids = append(ids, aws.ToString(msg.MessageId))
+	}
+	return ids
+}
+
+func TestSQSPoller(t *testing.T) {
+	newPoller := func(q *fakeSQSQueue, handler SQSBatchHandler) *SQSPoller {
+		return &SQSPoller{
+			Client:   q,
+			QueueURL: "http://localhost:9324/queue/ffis-downloads",
+			QueueARN: "arn:aws:sqs:us-west-2:123456789012:ffis-downloads",
+			Region:   "us-west-2",
+			Handler:  handler,
+			Logger:   log.NewNopLogger(),
+			WaitTime: -1,
+		}
+	}
+
+	t.Run("deletes only successful messages", func(t *testing.T) {
+		q := newFakeSQSQueue("a", "b", "c")
+		var handled []events.SQSMessage
+		poller := newPoller(q, func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+			handled = append(handled, event.Records...)
+			return events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{
+				{ItemIdentifier: "msg-1"},
+			}}, nil
+		})
+		poller.BatchSize = 10
+		poller.Once = true
+
+		stats, err := poller.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{Received: 3, Succeeded: 2, Failed: 1}, stats)
+		assert.Equal(t, []string{"msg-0", "msg-2"}, q.deleted)
+		assert.Equal(t, []string{"msg-1"}, q.inFlightIDs(), "failed message should be left for redelivery")
+
+		require.Len(t, handled, 3)
+		assert.Equal(t, "msg-0", handled[0].MessageId)
+		assert.Equal(t, "receipt-0", handled[0].ReceiptHandle)
+		assert.Equal(t, "a", handled[0].Body)
+		assert.Equal(t, "aws:sqs", handled[0].EventSource)
+		assert.Equal(t, "arn:aws:sqs:us-west-2:123456789012:ffis-downloads", handled[0].EventSourceARN)
+		assert.Equal(t, "us-west-2", handled[0].AWSRegion)
+		assert.Equal(t, "1", handled[0].Attributes["ApproximateReceiveCount"])
+		assert.Equal(t, "key-a", aws.ToString(handled[0].MessageAttributes["SourceKey"].StringValue))
+		assert.Equal(t, "String", handled[0].MessageAttributes["SourceKey"].DataType)
+	})
+
+	t.Run("handler error fails the entire batch", func(t *testing.T) {
+		q := newFakeSQSQueue("a", "b")
+		poller := newPoller(q, SQSErrorHandler(func(ctx context.Context, event events.SQSEvent) error {
+			return errors.New("download failed")
+		}))
+		poller.BatchSize = 2
+		poller.Once = true
+
+		stats, err := poller.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{Received: 2, Failed: 2}, stats)
+		assert.Empty(t, q.deleted)
+		assert.ElementsMatch(t, []string{"msg-0", "msg-1"}, q.inFlightIDs())
+	})
+
+	t.Run("max messages", func(t *testing.T) {
+		q := newFakeSQSQueue("a", "b", "c", "d", "e")
+		poller := newPoller(q, SQSErrorHandler(func(ctx context.Context, event events.SQSEvent) error {
+			return nil
+		}))
+		poller.BatchSize = 2
+		poller.MaxMessages = 3
+
+		stats, err := poller.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{Received: 3, Succeeded: 3}, stats)
+		assert.Equal(t, []string{"msg-0", "msg-1", "msg-2"}, q.deleted)
+		require.Len(t, q.receives, 2)
+		assert.Equal(t, int32(2), q.receives[0].MaxNumberOfMessages)
+		assert.Equal(t, int32(1), q.receives[1].MaxNumberOfMessages, "final batch should not exceed max messages")
+		assert.Len(t, q.messages, 2)
+	})
+
+	t.Run("once polls a single time without messages", func(t *testing.T) {
+		q := newFakeSQSQueue()
+		poller := newPoller(q, SQSErrorHandler(func(ctx context.Context, event events.SQSEvent) error {
+			t.Error("handler should not be invoked without messages")
+			return nil
+		}))
+		poller.Once = true
+
+		stats, err := poller.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{}, stats)
+		require.Len(t, q.receives, 1)
+		assert.Equal(t, int32(DefaultSQSPollerBatchSize), q.receives[0].MaxNumberOfMessages)
+		assert.Equal(t, int32(0), q.receives[0].WaitTimeSeconds)
+	})
+
+	t.Run("stopping mid-batch completes the batch", func(t *testing.T) {
+		q := newFakeSQSQueue("a", "b", "c")
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		poller := newPoller(q, func(handlerCtx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+			stop() // e.g. Ctrl-C while the batch is being handled
+			assert.NoError(t, handlerCtx.Err(), "handler should not be cancelled when the poller is stopped")
+			return events.SQSEventResponse{}, nil
+		})
+		poller.BatchSize = 2
+
+		stats, err := poller.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{Received: 2, Succeeded: 2}, stats)
+		assert.Equal(t, []string{"msg-0", "msg-1"}, q.deleted)
+		assert.Len(t, q.receives, 1, "no further messages should be received after stopping")
+	})
+
+	t.Run("stopping waits for in-flight batch before flushing", func(t *testing.T) {
+		q := newFakeSQSQueue("a", "b", "c")
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		poller := newPoller(q, func(handlerCtx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+			stop() // e.g. SIGTERM while the batch is being handled
+			time.Sleep(20 * time.Millisecond)
+			assert.NoError(t, handlerCtx.Err(), "handler should not be cancelled within the shutdown timeout")
+			return events.SQSEventResponse{}, nil
+		})
+		poller.BatchSize = 2
+		poller.ShutdownTimeout = 5 * time.Second
+		var deletedAtStop []string
+		poller.OnStop = func() { deletedAtStop = append([]string{}, q.deleted...) }
+
+		stats, err := poller.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{Received: 2, Succeeded: 2}, stats)
+		assert.Equal(t, []string{"msg-0", "msg-1"}, deletedAtStop,
+			"in-flight messages should be handled and deleted before metrics are flushed")
+		assert.Len(t, q.receives, 1, "no further messages should be received after stopping")
+	})
+
+	t.Run("shutdown timeout abandons in-flight batch", func(t *testing.T) {
+		q := newFakeSQSQueue("a")
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		handlerDone := make(chan error, 1)
+		poller := newPoller(q, SQSErrorHandler(func(handlerCtx context.Context, event events.SQSEvent) error {
+			stop()
+			<-handlerCtx.Done()
+			handlerDone <- handlerCtx.Err()
+			return handlerCtx.Err()
+		}))
+		poller.ShutdownTimeout = 10 * time.Millisecond
+		stopped := false
+		poller.OnStop = func() { stopped = true }
+
+		stats, err := poller.Run(ctx)
+		assert.ErrorIs(t, err, ErrSQSPollerShutdownTimeout)
+		assert.Equal(t, SQSPollerStats{Received: 1, Failed: 1}, stats)
+		assert.True(t, stopped, "OnStop should be called when the batch is abandoned")
+		select {
+		case err := <-handlerDone:
+			assert.ErrorIs(t, err, context.Canceled, "handler should be cancelled after the shutdown timeout")
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler was not cancelled")
+		}
+		assert.Empty(t, q.deleted)
+		assert.Equal(t, []string{"msg-0"}, q.inFlightIDs(), "abandoned message should be left for redelivery")
+	})
+
+	t.Run("stopping while waiting for messages", func(t *testing.T) {
+		q := newFakeSQSQueue()
+		ctx, stop := context.WithCancel(context.Background())
+		q.receiveFn = func(ctx context.Context) error {
+			stop()
+			<-ctx.Done()
+			return fmt.Errorf("operation error SQS: ReceiveMessage, %w", ctx.Err())
+		}
+		poller := newPoller(q, SQSErrorHandler(func(ctx context.Context, event events.SQSEvent) error {
+			return nil
+		}))
+
+		stats, err := poller.Run(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, SQSPollerStats{}, stats)
+	})
+
+	t.Run("receive error", func(t *testing.T) {
+		q := newFakeSQSQueue()
+		q.receiveFn = func(ctx context.Context) error { return errors.New("queue does not exist") }
+		poller := newPoller(q, SQSErrorHandler(func(ctx context.Context, event events.SQSEvent) error {
+			return nil
+		}))
+
+		_, err := poller.Run(context.Background())
+		assert.ErrorContains(t, err, "queue does not exist")
+	})
+
+	t.Run("invalid batch size", func(t *testing.T) {
+		poller := newPoller(newFakeSQSQueue(), nil)
+		poller.BatchSize = 11
+		_, err := poller.Run(context.Background())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,231 @@
+// Package eventHelpers provides functionality shared by Lambda handlers that are invoked
+// with S3 bucket notifications, whether delivered directly or wrapped in SQS/SNS envelopes,
+// as well as by operators who invoke a handler directly with an administrative or health-check
+// payload.
+package eventHelpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// AdminActionReprocess requests that a single S3 object is processed as though it had
+	// just been created.
+	AdminActionReprocess = "reprocess"
+
+	// AdminReprocessEventName is the event name assigned to records synthesized for
+	// reprocessing requests.
+	AdminReprocessEventName = "ObjectCreated:AdminReprocess"
+
+	// AdminActionDescribe requests a description of the handler's loaded configuration
+	// (see DescribeResponse), without processing any records.
+	AdminActionDescribe = "describe"
+)
+
+// KnownS3EventVersions are the versions of the S3 event notification record schema that are
+// known to be parsed correctly. Versions 2.1 and 2.2 add fields (e.g. glacierEventData) to
+// version 2.0 records, which are otherwise unchanged.
+var KnownS3EventVersions = []string{"2.0", "2.1", "2.2"}
+
+var (
+	ErrUnrecognizedPayload   = errors.New("unrecognized invocation payload")
+	ErrUnknownAdminAction    = errors.New("unknown admin action")
+	ErrMissingAdminParameter = errors.New("missing required admin parameter")
+)
+
+// AdminRequest is a payload used to invoke a handler directly, rather than by way of
+// an event source.
+type AdminRequest struct {
+	Action string `json:"adminAction"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// Payload is the result of unwrapping a raw invocation payload.
+type Payload struct {
+	// HealthCheck is true when the payload requests a health check, in which case no
+	// records should be processed.
+	HealthCheck bool
+	// Admin is non-nil when the payload is a direct administrative invocation.
+	Admin *AdminRequest
+	// Records contains every S3 event record extracted from the payload. For admin
+	// reprocessing requests, this contains a single synthesized record.
+	Records []events.S3EventRecord
+	// Details contains the fields of each of Records (at the same index) that are not
+	// represented by events.S3EventRecord.
+	Details []RecordDetails
+}
+
+// RecordDetails contains the fields of an S3 event record that were added in newer versions
+// of the record schema and are not represented by events.S3EventRecord.
+type RecordDetails struct {
+	// GlacierEventData is provided by ObjectRestore:Completed records (since version 2.1).
+	GlacierEventData *S3GlacierEventData `json:"glacierEventData,omitempty"`
+	// IntelligentTieringEventData is provided by IntelligentTiering records (since version 2.2).
+	IntelligentTieringEventData *S3IntelligentTieringEventData `json:"intelligentTieringEventData,omitempty"`
+}
+
+// S3GlacierEventData describes the temporary copy of a restored archived object.
+type S3GlacierEventData struct {
+	RestoreEventData struct {
+		LifecycleRestorationExpiryTime time.Time `json:"lifecycleRestorationExpiryTime"`
+		LifecycleRestoreStorageClass   string    `json:"lifecycleRestoreStorageClass"`
+	} `json:"restoreEventData"`
+}
+
+// S3IntelligentTieringEventData describes the access tier to which an object was moved.
+type S3IntelligentTieringEventData struct {
+	DestinationAccessTier string `json:"destinationAccessTier"`
+}
+
+// IsKnownS3EventVersion returns true when version is one of KnownS3EventVersions, or is empty
+// (as for records synthesized for admin requests). Records of unknown versions should be
+// processed on a best-effort basis, since newer versions have so far only added fields.
+func IsKnownS3EventVersion(version string) bool {
+	if version == "" {
+		return true
+	}
+	for _, known := range KnownS3EventVersions {
+		if version == known {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin returns true when the payload represents a direct administrative invocation.
+func (p Payload) IsAdmin() bool {
+	return p.Admin != nil
+}
+
+// IsDescribe returns true when the payload requests a description of the handler's configuration.
+func (p Payload) IsDescribe() bool {
+	return p.IsAdmin() && p.Admin.Action == AdminActionDescribe
+}
+
+// envelope contains the union of fields used to distinguish the supported payload types.
+type envelope struct {
+	HealthCheck bool    `json:"healthcheck"`
+	AdminAction *string `json:"adminAction"`
+	Records     []struct {
+		// S3 and SQS records use "eventSource" whereas SNS records use "EventSource"
+		EventSource    string          `json:"eventSource"`
+		SNSEventSource string          `json:"EventSource"`
+		Body           string          `json:"body"`
+		SNS            json.RawMessage `json:"Sns"`
+	} `json:"Records"`
+	// S3 sends a one-time test event (which has no records) when notifications are configured
+	Event string `json:"Event"`
+}
+
+// Unwrap inspects a raw invocation payload and returns its contents.
+// S3 event records are extracted from direct S3 bucket notifications as well as from SQS and SNS
+// events whose messages contain S3 bucket notifications (including SNS-to-SQS subscriptions).
+// Health-check payloads, i.e. {"healthcheck": true}, are identified before any other payload type.
+// Admin payloads are identified by their "adminAction" field and are validated before being
+// returned; a reprocessing request results in a single synthesized S3 event record, whereas
+// a describe request results in no records.
+func Unwrap(raw json.RawMessage) (Payload, error) {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return Payload{}, fmt.Errorf("%w: %w", ErrUnrecognizedPayload, err)
+	}
+
+	if env.HealthCheck {
+		return Payload{HealthCheck: true}, nil
+	}
+
+	if env.AdminAction != nil {
+		var req AdminRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return Payload{}, fmt.Errorf("%w: %w", ErrUnrecognizedPayload, err)
+		}
+		return unwrapAdminRequest(req)
+	}
+
+	if env.Records == nil {
+		if env.Event == "s3:TestEvent" {
+			return Payload{}, nil
+		}
+		return Payload{}, ErrUnrecognizedPayload
+	}
+
+	records, details, err := unwrapRecords(raw, env)
+	return Payload{Records: records, Details: details}, err
+}
+
+func unwrapAdminRequest(req AdminRequest) (Payload, error) {
+	switch req.Action {
+	case AdminActionReprocess:
+		if req.Bucket == "" {
+			return Payload{}, fmt.Errorf("%w: bucket", ErrMissingAdminParameter)
+		}
+		if req.Key == "" {
+			return Payload{}, fmt.Errorf("%w: key", ErrMissingAdminParameter)
+		}
+		return Payload{Admin: &req, Records: []events.S3EventRecord{{
+			EventSource: "aws:s3",
+			EventName:   AdminReprocessEventName,
+			S3: events.S3Entity{
+				Bucket: events.S3Bucket{Name: req.Bucket},
+				Object: events.S3Object{Key: req.Key},
+			},
+		}}, Details: []RecordDetails{{}}}, nil
+	case AdminActionDescribe:
+		return Payload{Admin: &req}, nil
+	default:
+		return Payload{}, fmt.Errorf("%w: %q", ErrUnknownAdminAction, req.Action)
+	}
+}
+
+func unwrapRecords(raw json.RawMessage, env envelope) ([]events.S3EventRecord, []RecordDetails, error) {
+	var s3Event events.S3Event
+	if err := json.Unmarshal(raw, &s3Event); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrUnrecognizedPayload, err)
+	}
+	var s3Details struct {
+		Records []RecordDetails `json:"Records"`
+	}
+	if err := json.Unmarshal(raw, &s3Details); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrUnrecognizedPayload, err)
+	}
+
+	records := []events.S3EventRecord{}
+	details := []RecordDetails{}
+	for i, r := range env.Records {
+		switch {
+		case r.EventSource == "aws:s3":
+			records = append(records, s3Event.Records[i])
+			details = append(details, s3Details.Records[i])
+
+		case r.EventSource == "aws:sqs":
+			inner, err := Unwrap(json.RawMessage(r.Body))
+			if err != nil {
+				return nil, nil, fmt.Errorf("error unwrapping SQS message body: %w", err)
+			}
+			records = append(records, inner.Records...)
+			details = append(details, inner.Details...)
+
+		case r.SNSEventSource == "aws:sns":
+			var sns events.SNSEntity
+			if err := json.Unmarshal(r.SNS, &sns); err != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrUnrecognizedPayload, err)
+			}
+			inner, err := Unwrap(json.RawMessage(sns.Message))
+			if err != nil {
+				return nil, nil, fmt.Errorf("error unwrapping SNS message: %w", err)
+			}
+			records = append(records, inner.Records...)
+			details = append(details, inner.Details...)
+
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported event source for record %d", ErrUnrecognizedPayload, i)
+		}
+	}
+	return records, details, nil
+}
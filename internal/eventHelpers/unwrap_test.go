@@ -0,0 +1,170 @@
+package eventHelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const s3EventJSON = `{"Records": [{
+	"eventVersion": "2.1",
+	"eventSource": "aws:s3",
+	"eventName": "ObjectCreated:Put",
+	"s3": {"bucket": {"name": "source-bucket"}, "object": {"key": "ses/ffis_ingest/new/abc123"}}
+}]}`
+
+func TestUnwrap(t *testing.T) {
+	sqsWrapped, err := json.Marshal(map[string]interface{}{
+		"Records": []map[string]interface{}{{"eventSource": "aws:sqs", "body": s3EventJSON}},
+	})
+	require.NoError(t, err)
+	snsEntity, err := json.Marshal(map[string]interface{}{"Type": "Notification", "Message": s3EventJSON})
+	require.NoError(t, err)
+	snsWrapped := fmt.Sprintf(`{"Records": [{"EventSource": "aws:sns", "Sns": %s}]}`, snsEntity)
+
+	for _, tt := range []struct {
+		name    string
+		payload string
+	}{
+		{"direct S3 event", s3EventJSON},
+		{"SQS-wrapped S3 event", string(sqsWrapped)},
+		{"SNS-wrapped S3 event", snsWrapped},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Unwrap(json.RawMessage(tt.payload))
+			require.NoError(t, err)
+			assert.False(t, p.IsAdmin())
+			require.Len(t, p.Records, 1)
+			assert.Len(t, p.Details, 1)
+			assert.Equal(t, "source-bucket", p.Records[0].S3.Bucket.Name)
+			assert.Equal(t, "ses/ffis_ingest/new/abc123", p.Records[0].S3.Object.Key)
+			assert.Equal(t, "ObjectCreated:Put", p.Records[0].EventName)
+		})
+	}
+
+	t.Run("S3 test event has no records", func(t *testing.T) {
+		p, err := Unwrap(json.RawMessage(`{"Service": "Amazon S3", "Event": "s3:TestEvent"}`))
+		require.NoError(t, err)
+		assert.Empty(t, p.Records)
+	})
+
+	t.Run("health check", func(t *testing.T) {
+		p, err := Unwrap(json.RawMessage(`{"healthcheck": true}`))
+		require.NoError(t, err)
+		assert.True(t, p.HealthCheck)
+		assert.False(t, p.IsAdmin())
+		assert.Empty(t, p.Records)
+	})
+
+	t.Run("unrecognized payload", func(t *testing.T) {
+		_, err := Unwrap(json.RawMessage(`{"foo": "bar"}`))
+		assert.ErrorIs(t, err, ErrUnrecognizedPayload)
+	})
+}
+
+func TestUnwrapEventVersions(t *testing.T) {
+	for _, tt := range []struct {
+		fixture            string
+		expVersion         string
+		expEventName       string
+		expKnown           bool
+		expGlacier         bool
+		expIntelligentTier bool
+	}{
+		{"s3_event_v2.0.json", "2.0", "ObjectCreated:Put", true, false, false},
+		{"s3_event_v2.1.json", "2.1", "ObjectCreated:Put", true, false, false},
+		{"s3_event_v2.1_glacier_restore.json", "2.1", "ObjectRestore:Completed", true, true, false},
+		{"s3_event_v2.2.json", "2.2", "ObjectCreated:Put", true, false, false},
+		{"s3_event_v2.2_intelligent_tiering.json", "2.2", "IntelligentTiering", true, false, true},
+		{"s3_event_v2.3_unknown.json", "2.3", "ObjectCreated:Put", false, false, false},
+	} {
+		t.Run(tt.fixture, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("fixtures", tt.fixture))
+			require.NoError(t, err)
+			sqsWrapped, err := json.Marshal(map[string]interface{}{
+				"Records": []map[string]interface{}{{"eventSource": "aws:sqs", "body": string(raw)}},
+			})
+			require.NoError(t, err)
+
+			for _, payload := range [][]byte{raw, sqsWrapped} {
+				p, err := Unwrap(json.RawMessage(payload))
+				require.NoError(t, err, "records of every version should be parsed")
+				require.Len(t, p.Records, 1)
+				require.Len(t, p.Details, 1)
+				record := p.Records[0]
+				assert.Equal(t, tt.expVersion, record.EventVersion)
+				assert.Equal(t, tt.expEventName, record.EventName)
+				assert.Equal(t, "source-bucket", record.S3.Bucket.Name)
+				assert.Equal(t, "ses/ffis_ingest/new/abc123", record.S3.Object.Key)
+				assert.Equal(t, int64(1024), record.S3.Object.Size)
+				assert.Equal(t, tt.expKnown, IsKnownS3EventVersion(record.EventVersion))
+
+				details := p.Details[0]
+				if tt.expGlacier {
+					require.NotNil(t, details.GlacierEventData)
+					assert.Equal(t, "GLACIER", details.GlacierEventData.RestoreEventData.LifecycleRestoreStorageClass)
+					assert.Equal(t, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+						details.GlacierEventData.RestoreEventData.LifecycleRestorationExpiryTime)
+				} else {
+					assert.Nil(t, details.GlacierEventData)
+				}
+				if tt.expIntelligentTier {
+					require.NotNil(t, details.IntelligentTieringEventData)
+					assert.Equal(t, "ARCHIVE_ACCESS", details.IntelligentTieringEventData.DestinationAccessTier)
+				} else {
+					assert.Nil(t, details.IntelligentTieringEventData)
+				}
+			}
+		})
+	}
+}
+
+func TestIsKnownS3EventVersion(t *testing.T) {
+	for _, v := range KnownS3EventVersions {
+		assert.True(t, IsKnownS3EventVersion(v), v)
+	}
+	assert.True(t, IsKnownS3EventVersion(""), "synthesized records have no version")
+	assert.False(t, IsKnownS3EventVersion("2.3"))
+	assert.False(t, IsKnownS3EventVersion("3.0"))
+}
+
+func TestUnwrapAdminRequest(t *testing.T) {
+	t.Run("valid reprocess request", func(t *testing.T) {
+		p, err := Unwrap(json.RawMessage(
+			`{"adminAction": "reprocess", "bucket": "my-bucket", "key": "sources/2023/4/24"}`))
+		require.NoError(t, err)
+		require.True(t, p.IsAdmin())
+		assert.Equal(t, AdminActionReprocess, p.Admin.Action)
+		require.Len(t, p.Records, 1)
+		assert.Len(t, p.Details, 1)
+		assert.Equal(t, AdminReprocessEventName, p.Records[0].EventName)
+		assert.Equal(t, "my-bucket", p.Records[0].S3.Bucket.Name)
+		assert.Equal(t, "sources/2023/4/24", p.Records[0].S3.Object.Key)
+	})
+	t.Run("missing key", func(t *testing.T) {
+		_, err := Unwrap(json.RawMessage(`{"adminAction": "reprocess", "bucket": "my-bucket"}`))
+		assert.ErrorIs(t, err, ErrMissingAdminParameter)
+		assert.ErrorContains(t, err, "key")
+	})
+	t.Run("missing bucket", func(t *testing.T) {
+		_, err := Unwrap(json.RawMessage(`{"adminAction": "reprocess", "key": "some/key"}`))
+		assert.ErrorIs(t, err, ErrMissingAdminParameter)
+		assert.ErrorContains(t, err, "bucket")
+	})
+	t.Run("unknown action", func(t *testing.T) {
+		_, err := Unwrap(json.RawMessage(`{"adminAction": "obliterate", "bucket": "b", "key": "k"}`))
+		assert.ErrorIs(t, err, ErrUnknownAdminAction)
+	})
+	t.Run("describe request", func(t *testing.T) {
+		p, err := Unwrap(json.RawMessage(`{"adminAction": "describe"}`))
+		require.NoError(t, err)
+		assert.True(t, p.IsDescribe())
+		assert.Empty(t, p.Records)
+	})
+}
@@ -0,0 +1,33 @@
+package jsonHelpers
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// DiffFields returns the sorted names of top-level fields whose values differ
+// between the JSON objects a and b, including fields that are present in only one of them.
+func DiffFields(a, b []byte) ([]string, error) {
+	var aFields, bFields map[string]interface{}
+	if err := json.Unmarshal(a, &aFields); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &bFields); err != nil {
+		return nil, err
+	}
+
+	diffs := []string{}
+	for name, aValue := range aFields {
+		if bValue, exists := bFields[name]; !exists || !reflect.DeepEqual(aValue, bValue) {
+			diffs = append(diffs, name)
+		}
+	}
+	for name := range bFields {
+		if _, exists := aFields[name]; !exists {
+			diffs = append(diffs, name)
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
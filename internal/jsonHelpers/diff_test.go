@@ -0,0 +1,30 @@
+package jsonHelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffFields(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		a, b     string
+		expDiffs []string
+	}{
+		{"identical", `{"a": 1, "b": [1, 2]}`, `{"b": [1, 2], "a": 1}`, []string{}},
+		{"changed value", `{"a": 1, "b": "x"}`, `{"a": 2, "b": "x"}`, []string{"a"}},
+		{"added and removed fields", `{"a": 1, "b": 2}`, `{"b": 2, "c": 3}`, []string{"a", "c"}},
+		{"nested change", `{"a": {"x": true}}`, `{"a": {"x": false}}`, []string{"a"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := DiffFields([]byte(tt.a), []byte(tt.b))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expDiffs, diffs)
+		})
+	}
+
+	_, err := DiffFields([]byte(`not json`), []byte(`{}`))
+	assert.Error(t, err)
+}
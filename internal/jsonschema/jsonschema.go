@@ -0,0 +1,253 @@
+// Package jsonschema validates JSON documents against JSON Schemas.
+//
+// Only the subset of JSON Schema (draft 2020-12) keywords needed by the schemas in this
+// repository is supported: type, properties, required, additionalProperties (as a boolean),
+// items, enum, minimum, minLength, pattern, and the date-time format. Schemas using any other keyword
+// are rejected by Compile so that constraints are never silently ignored.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	// Annotations, which do not affect validation
+	SchemaURI   string `json:"$schema"`
+	ID          string `json:"$id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Type                 typeList           `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	MinLength            *int               `json:"minLength"`
+	Pattern              string             `json:"pattern"`
+	Format               string             `json:"format"`
+
+	pattern *regexp.Regexp
+}
+
+// typeList holds the value of the "type" keyword, which may be a single type name or a list.
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = typeList{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings: %w", err)
+	}
+	*t = multiple
+	return nil
+}
+
+// ValidationError describes the first location at which a document violates a schema.
+type ValidationError struct {
+	// JSON Pointer to the offending value, prefixed by "#" (e.g. "#/assistance_listings/0")
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("schema violation at %s: %s", e.Path, e.Message)
+}
+
+// Compile parses the JSON Schema document b.
+// Returns an error if b uses keywords or formats that are not supported.
+func Compile(b []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	var s Schema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("error parsing JSON schema: %w", err)
+	}
+	if err := s.compile("#"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// MustCompile is like Compile but panics if the schema cannot be compiled.
+func MustCompile(b []byte) *Schema {
+	s, err := Compile(b)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Schema) compile(path string) error {
+	for _, t := range s.Type {
+		switch t {
+		case "object", "array", "string", "integer", "number", "boolean", "null":
+		default:
+			return fmt.Errorf("unsupported type %q in JSON schema at %s", t, path)
+		}
+	}
+	if s.Format != "" && s.Format != "date-time" {
+		return fmt.Errorf("unsupported format %q in JSON schema at %s", s.Format, path)
+	}
+	if s.MinLength != nil && *s.MinLength < 0 {
+		return fmt.Errorf("invalid minLength in JSON schema at %s: must not be negative", path)
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern in JSON schema at %s: %w", path, err)
+		}
+		s.pattern = re
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(path + "/properties/" + escapePointer(name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "/items")
+	}
+	return nil
+}
+
+// Validate checks the JSON document b against the schema.
+// Returns a *ValidationError if the document does not conform to the schema.
+func (s *Schema) Validate(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("error parsing JSON document: %w", err)
+	}
+	return s.validate(v, "#")
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		return &ValidationError{path, fmt.Sprintf("expected %s but got %s",
+			strings.Join(s.Type, " or "), typeOf(v))}
+	}
+	if len(s.Enum) > 0 && !s.enumContains(v) {
+		return &ValidationError{path, "value is not one of the allowed values"}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return &ValidationError{path, fmt.Sprintf("missing required property %q", name)}
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return &ValidationError{path, fmt.Sprintf("unexpected property %q", name)}
+				}
+				continue
+			}
+			if err := prop.validate(v[name], path+"/"+escapePointer(name)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		// Lengths are counted in characters rather than bytes, as required by JSON Schema
+		if s.MinLength != nil && utf8.RuneCountInString(v) < *s.MinLength {
+			return &ValidationError{path, fmt.Sprintf("%q is shorter than the minimum length of %d",
+				v, *s.MinLength)}
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return &ValidationError{path, fmt.Sprintf("%q does not match pattern %q", v, s.Pattern)}
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return &ValidationError{path, fmt.Sprintf("%q is not a valid date-time", v)}
+			}
+		}
+	case json.Number:
+		if s.Minimum != nil {
+			if f, err := v.Float64(); err != nil || f < *s.Minimum {
+				return &ValidationError{path, fmt.Sprintf("%s is less than the minimum of %v", v, *s.Minimum)}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) enumContains(v interface{}) bool {
+	want, _ := json.Marshal(v)
+	for _, allowed := range s.Enum {
+		if b, _ := json.Marshal(allowed); bytes.Equal(b, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t typeList) matches(v interface{}) bool {
+	actual := typeOf(v)
+	for _, expected := range t {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type name of a value decoded with json.Decoder.UseNumber.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// escapePointer escapes a property name for use as a JSON Pointer reference token.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,111 @@
+package jsonschema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "Example",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["id", "tags", "nested"],
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"ratio": {"type": "number"},
+		"label": {"type": "string", "minLength": 2},
+		"name": {"type": ["string", "null"]},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}},
+		"kind": {"enum": ["a", "b", 3]},
+		"created_at": {"type": "string", "format": "date-time"},
+		"nested": {
+			"type": "object",
+			"required": ["ok"],
+			"properties": {"ok": {"type": "boolean"}, "a/b": {"type": "string"}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(testSchema))
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name            string
+		document        string
+		expectedPath    string
+		expectedMessage string
+	}{
+		{"valid", `{"id": 1, "tags": ["x"], "nested": {"ok": true}, "name": null, "ratio": 0.5,
+			"kind": 3, "created_at": "2023-05-11T00:00:00Z"}`, "", ""},
+		{"integer-valued number is an integer", `{"id": 2.0, "tags": [], "nested": {"ok": false}}`, "", ""},
+		{"integer is a number", `{"id": 1, "ratio": 1, "tags": [], "nested": {"ok": false}}`, "", ""},
+		{"not an object", `[]`, "#", "expected object but got array"},
+		{"missing required property", `{"id": 1, "tags": []}`, "#", `missing required property "nested"`},
+		{"unexpected property", `{"id": 1, "tags": [], "nested": {"ok": true}, "extra": 1}`,
+			"#", `unexpected property "extra"`},
+		{"additional nested property is allowed", `{"id": 1, "tags": [], "nested": {"ok": true, "more": 1}}`, "", ""},
+		{"string flipped to array", `{"id": 1, "tags": [], "nested": {"ok": true}, "name": ["x"]}`,
+			"#/name", "expected string or null but got array"},
+		{"fractional integer", `{"id": 1.5, "tags": [], "nested": {"ok": true}}`,
+			"#/id", "expected integer but got number"},
+		{"below minimum", `{"id": 0, "tags": [], "nested": {"ok": true}}`,
+			"#/id", "0 is less than the minimum of 1"},
+		{"min length", `{"id": 1, "tags": [], "nested": {"ok": true}, "label": "x"}`,
+			"#/label", `"x" is shorter than the minimum length of 2`},
+		{"min length counts characters", `{"id": 1, "tags": [], "nested": {"ok": true}, "label": "é"}`,
+			"#/label", `"é" is shorter than the minimum length of 2`},
+		{"min length met", `{"id": 1, "tags": [], "nested": {"ok": true}, "label": "xy"}`, "", ""},
+		{"array item", `{"id": 1, "tags": ["ok", "Not OK"], "nested": {"ok": true}}`,
+			"#/tags/1", `"Not OK" does not match pattern "^[a-z]+$"`},
+		{"enum", `{"id": 1, "tags": [], "nested": {"ok": true}, "kind": "c"}`,
+			"#/kind", "value is not one of the allowed values"},
+		{"date-time", `{"id": 1, "tags": [], "nested": {"ok": true}, "created_at": "5/11/2023"}`,
+			"#/created_at", `"5/11/2023" is not a valid date-time`},
+		{"nested property", `{"id": 1, "tags": [], "nested": {"ok": true, "a/b": 1}}`,
+			"#/nested/a~1b", "expected string but got integer"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tt.document))
+			if tt.expectedPath == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var violation *ValidationError
+			require.ErrorAs(t, err, &violation)
+			assert.Equal(t, tt.expectedPath, violation.Path)
+			assert.Equal(t, tt.expectedMessage, violation.Message)
+		})
+	}
+
+	t.Run("malformed document", func(t *testing.T) {
+		err := schema.Validate([]byte(`{"id": `))
+		assert.Error(t, err)
+		var violation *ValidationError
+		assert.False(t, errors.As(err, &violation))
+	})
+}
+
+func TestCompileRejectsUnsupportedSchemas(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		schema string
+	}{
+		{"unsupported keyword", `{"type": "object", "properties": {"a": {"type": "string", "maxLength": 3}}}`},
+		{"unsupported type", `{"type": "date"}`},
+		{"unsupported format", `{"type": "string", "format": "email"}`},
+		{"negative minLength", `{"type": "string", "minLength": -1}`},
+		{"invalid pattern", `{"type": "string", "pattern": "("}`},
+		{"malformed", `{"type": `},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			assert.Error(t, err)
+		})
+	}
+	assert.Panics(t, func() { MustCompile([]byte(`{"type": "date"}`)) })
+}
@@ -0,0 +1,76 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	AuditDecisionAccept = "accept"
+	AuditDecisionReject = "reject"
+
+	AuditSinkStdout  = "stdout"
+	AuditSinkStderr  = "stderr"
+	AuditSinkDiscard = "discard"
+)
+
+// AuditSink returns the writer to which audit events are emitted for the given sink name,
+// which may be one of: stdout, stderr, discard (not case-sensitive).
+func AuditSink(name string) (io.Writer, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case AuditSinkStdout:
+		return os.Stdout, nil
+	case AuditSinkStderr:
+		return os.Stderr, nil
+	case AuditSinkDiscard:
+		return io.Discard, nil
+	default:
+		return nil, fmt.Errorf("unknown audit log sink %q: must be one of %s, %s, %s",
+			name, AuditSinkStdout, AuditSinkStderr, AuditSinkDiscard)
+	}
+}
+
+// NewNopAuditLogger returns a Logger that discards audit events, which is useful as the
+// default audit logger until ConfigureAuditLogger is called.
+func NewNopAuditLogger() Logger {
+	return log.NewNopLogger()
+}
+
+// ConfigureAuditLogger configures the Logger pointer to emit JSON structured audit events to w,
+// independently of the application logger configured by ConfigureLogger.
+// Accepted decisions are logged with INFO level and rejected decisions with WARN level,
+// so configuring lvl as WARN audits only rejections.
+// Every audit event includes a "ts"-keyed timestamp and an "audit" key with the value
+// "policy_decision", which distinguishes audit events from application logs.
+func ConfigureAuditLogger(l *Logger, lvl string, w io.Writer) {
+	*l = log.With(
+		level.NewFilter(
+			log.NewJSONLogger(w),
+			level.Allow(level.ParseDefault(lvl, level.InfoValue())),
+		),
+		"ts", log.DefaultTimestamp,
+		"audit", "policy_decision",
+	)
+}
+
+// Audit records a policy decision as a structured audit event, where decision is
+// AuditDecisionAccept or AuditDecisionReject, rule identifies the policy that was evaluated
+// (e.g. "sender_allowlist"), and subject is the value that the policy was evaluated against
+// (e.g. an email address). Any kv provide further context for reconstructing the decision.
+// Audit events are discarded when l is nil, i.e. when no audit logger is configured.
+func Audit(l Logger, decision, rule, subject string, kv ...interface{}) {
+	if l == nil {
+		return
+	}
+	lvl := level.Info(l)
+	if decision != AuditDecisionAccept {
+		lvl = level.Warn(l)
+	}
+	logWithMessage(log.With(lvl, "decision", decision, "rule", rule, "subject", subject),
+		"Policy decision", kv...)
+}
@@ -0,0 +1,80 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	decodeEvents := func(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+		t.Helper()
+		events := []map[string]interface{}{}
+		dec := json.NewDecoder(buf)
+		for dec.More() {
+			event := map[string]interface{}{}
+			require.NoError(t, dec.Decode(&event))
+			events = append(events, event)
+		}
+		return events
+	}
+
+	t.Run("accept and reject", func(t *testing.T) {
+		var buf bytes.Buffer
+		var l Logger
+		ConfigureAuditLogger(&l, "INFO", &buf)
+		Audit(l, AuditDecisionAccept, "sender_allowlist", "ffis@ffis.org")
+		Audit(l, AuditDecisionReject, "sender_allowlist", "spoof@example.com", "reason", "unrecognized sender")
+
+		events := decodeEvents(t, &buf)
+		require.Len(t, events, 2)
+		for _, event := range events {
+			assert.Equal(t, "policy_decision", event["audit"])
+			assert.Equal(t, "Policy decision", event["msg"])
+			assert.Equal(t, "sender_allowlist", event["rule"])
+			assert.Contains(t, event, "ts")
+		}
+		assert.Equal(t, "accept", events[0]["decision"])
+		assert.Equal(t, "ffis@ffis.org", events[0]["subject"])
+		assert.Equal(t, "info", events[0]["level"])
+		assert.Equal(t, "reject", events[1]["decision"])
+		assert.Equal(t, "spoof@example.com", events[1]["subject"])
+		assert.Equal(t, "warn", events[1]["level"])
+		assert.Equal(t, "unrecognized sender", events[1]["reason"])
+	})
+
+	t.Run("WARN level audits only rejections", func(t *testing.T) {
+		var buf bytes.Buffer
+		var l Logger
+		ConfigureAuditLogger(&l, "WARN", &buf)
+		Audit(l, AuditDecisionAccept, "allowed_extensions", "https://example.com/file.xlsx")
+		Audit(l, AuditDecisionReject, "allowed_extensions", "https://example.com/file.html")
+
+		events := decodeEvents(t, &buf)
+		require.Len(t, events, 1)
+		assert.Equal(t, "reject", events[0]["decision"])
+	})
+
+	t.Run("nop audit logger", func(t *testing.T) {
+		assert.NotPanics(t, func() { Audit(NewNopAuditLogger(), AuditDecisionAccept, "rule", "subject") })
+	})
+}
+
+func TestAuditSink(t *testing.T) {
+	for name, expected := range map[string]io.Writer{
+		"stdout":  os.Stdout,
+		"STDERR":  os.Stderr,
+		"discard": io.Discard,
+	} {
+		w, err := AuditSink(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, w)
+	}
+	_, err := AuditSink("syslog")
+	assert.ErrorContains(t, err, "syslog")
+}
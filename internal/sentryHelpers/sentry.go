@@ -0,0 +1,96 @@
+// Package sentryHelpers provides optional reporting of handler failures to Sentry.
+// Reporting is enabled by calling Init with a non-empty DSN; otherwise, all functions
+// in this package are no-ops.
+package sentryHelpers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/getsentry/sentry-go"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+)
+
+// FlushTimeout is the maximum amount of time to wait for captured events to be sent.
+const FlushTimeout = 2 * time.Second
+
+var enabled atomic.Bool
+
+// Init enables reporting to the Sentry project identified by dsn. Reporting remains disabled
+// when dsn is empty, or when an error is returned (which should not be treated as fatal).
+// Any given opts may modify the client options before the client is initialized.
+func Init(dsn string, opts ...func(*sentry.ClientOptions)) error {
+	enabled.Store(false)
+	if dsn == "" {
+		return nil
+	}
+
+	clientOpts := sentry.ClientOptions{Dsn: dsn, AttachStacktrace: true}
+	for _, opt := range opts {
+		opt(&clientOpts)
+	}
+	if err := sentry.Init(clientOpts); err != nil {
+		return fmt.Errorf("error initializing Sentry client: %w", err)
+	}
+	enabled.Store(true)
+	return nil
+}
+
+// Enabled returns true when reporting to Sentry is enabled.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// RecordFailure describes the failure to handle a single source object.
+type RecordFailure struct {
+	Err            error
+	Classification string
+	Bucket         string
+	Key            string
+	// Panicked is true when the failure was caused by a recovered panic.
+	Panicked bool
+}
+
+// CaptureRecordFailure reports a record failure to Sentry, along with the trace IDs of
+// any span in ctx and the ID of the Lambda invocation.
+func CaptureRecordFailure(ctx context.Context, f RecordFailure) {
+	if !Enabled() {
+		return
+	}
+
+	hub := sentry.CurrentHub().Clone()
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelError)
+		if f.Panicked {
+			scope.SetLevel(sentry.LevelFatal)
+		}
+		scope.SetTag("error_class", f.Classification)
+		scope.SetTag("panicked", strconv.FormatBool(f.Panicked))
+		scope.SetTag("source_bucket", f.Bucket)
+		scope.SetContext("record", sentry.Context{
+			"bucket": f.Bucket,
+			"key":    f.Key,
+		})
+		if traceID, spanID, ok := tracing.SpanIDsFromContext(ctx); ok {
+			scope.SetTag("trace_id", traceID)
+			scope.SetTag("span_id", spanID)
+		}
+		if lc, ok := lambdacontext.FromContext(ctx); ok {
+			scope.SetTag("aws_request_id", lc.AwsRequestID)
+		}
+		hub.CaptureException(f.Err)
+	})
+}
+
+// Flush waits until any captured events have been sent, or until FlushTimeout elapses.
+// It should be called before each invocation returns, since the Lambda execution environment
+// may be frozen indefinitely once it does.
+func Flush() {
+	if Enabled() {
+		sentry.Flush(FlushTimeout)
+	}
+}
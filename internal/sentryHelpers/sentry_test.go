@@ -0,0 +1,113 @@
+package sentryHelpers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type mockTransport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *mockTransport) Configure(options sentry.ClientOptions) {}
+
+func (t *mockTransport) SendEvent(event *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *mockTransport) Flush(timeout time.Duration) bool {
+	return true
+}
+
+func (t *mockTransport) Close() {}
+
+func (t *mockTransport) Events() []*sentry.Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.events
+}
+
+func setupSentryForTesting(t *testing.T) *mockTransport {
+	t.Helper()
+	transport := &mockTransport{}
+	require.NoError(t, Init("https://public@sentry.example.com/1", func(o *sentry.ClientOptions) {
+		o.Transport = transport
+	}))
+	t.Cleanup(func() { enabled.Store(false) })
+	return transport
+}
+
+func TestInit(t *testing.T) {
+	t.Run("disabled without DSN", func(t *testing.T) {
+		require.NoError(t, Init(""))
+		assert.False(t, Enabled())
+		// Should not panic
+		CaptureRecordFailure(context.Background(), RecordFailure{Err: errors.New("oops")})
+		Flush()
+	})
+
+	t.Run("invalid DSN leaves reporting disabled", func(t *testing.T) {
+		assert.Error(t, Init("not a valid DSN"))
+		assert.False(t, Enabled())
+	})
+
+	t.Run("enabled with DSN", func(t *testing.T) {
+		setupSentryForTesting(t)
+		assert.True(t, Enabled())
+	})
+}
+
+func TestCaptureRecordFailure(t *testing.T) {
+	transport := setupSentryForTesting(t)
+	tracing.SetProvider(tracing.NewOpenTelemetryProvider(sdktrace.NewTracerProvider()))
+	t.Cleanup(func() { tracing.SetProvider(tracing.NewDatadogProvider()) })
+	span, ctx := tracing.StartSpanFromContext(context.Background(), "handle.record")
+	defer span.Finish()
+	traceID, spanID, ok := tracing.SpanIDsFromContext(ctx)
+	require.True(t, ok)
+
+	CaptureRecordFailure(ctx, RecordFailure{
+		Err:            errors.New("no matches found"),
+		Classification: "no matches found",
+		Bucket:         "source-bucket",
+		Key:            "sources/2023/04/24/ffis.org/raw.eml",
+	})
+	CaptureRecordFailure(ctx, RecordFailure{
+		Err:      errors.New("record panicked: oh no"),
+		Bucket:   "source-bucket",
+		Key:      "sources/2023/04/25/ffis.org/raw.eml",
+		Panicked: true,
+	})
+	Flush()
+
+	events := transport.Events()
+	require.Len(t, events, 2)
+
+	event := events[0]
+	assert.Equal(t, sentry.LevelError, event.Level)
+	require.NotEmpty(t, event.Exception)
+	assert.Equal(t, "no matches found", event.Exception[len(event.Exception)-1].Value)
+	assert.Equal(t, "no matches found", event.Tags["error_class"])
+	assert.Equal(t, "false", event.Tags["panicked"])
+	assert.Equal(t, "source-bucket", event.Tags["source_bucket"])
+	assert.Equal(t, traceID, event.Tags["trace_id"])
+	assert.Equal(t, spanID, event.Tags["span_id"])
+	assert.Equal(t, "sources/2023/04/24/ffis.org/raw.eml", event.Contexts["record"]["key"])
+
+	event = events[1]
+	assert.Equal(t, sentry.LevelFatal, event.Level)
+	assert.Equal(t, "true", event.Tags["panicked"])
+	assert.Equal(t, "sources/2023/04/25/ffis.org/raw.eml", event.Contexts["record"]["key"])
+}
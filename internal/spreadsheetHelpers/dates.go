@@ -0,0 +1,143 @@
+package spreadsheetHelpers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateKind describes what a normalized date cell represents.
+type DateKind int
+
+const (
+	// DateKindUnknown indicates that a cell does not provide a date, either because it is blank,
+	// because it holds a placeholder such as "TBD", or because its value was not recognized.
+	DateKindUnknown DateKind = iota
+	// DateKindDate indicates that a cell provides a calendar date.
+	DateKindDate
+	// DateKindRolling indicates that a cell describes an open-ended deadline, e.g. "Rolling".
+	DateKindRolling
+)
+
+func (k DateKind) String() string {
+	switch k {
+	case DateKindDate:
+		return "date"
+	case DateKindRolling:
+		return "rolling"
+	default:
+		return "unknown"
+	}
+}
+
+// maxExcelSerial is the serial number of 9999-12-31, the latest date supported by Excel.
+const maxExcelSerial = 2958465
+
+var ErrUnrecognizedDate = errors.New("unrecognized date value")
+
+// DateLayouts are the textual layouts of date cells that are recognized by NormalizeDateCell,
+// in the order in which they are attempted. Layouts with 4-digit years precede their 2-digit
+// equivalents so that e.g. "6/30/2023" is not misread.
+var DateLayouts = []string{
+	"1/2/2006",
+	"1/2/06",
+	"1-2-2006",
+	"1-2-06",
+	"2006-01-02",
+	"January 2, 2006",
+	"January 2 2006",
+	"Jan 2, 2006",
+	"Jan 2 2006",
+	"Jan. 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"2-Jan-2006",
+	"2-Jan-06",
+}
+
+// dateKeywords maps (lower-cased) non-date values that commonly appear in date cells
+// to the kind of date that they represent.
+var dateKeywords = map[string]DateKind{
+	"rolling":    DateKindRolling,
+	"ongoing":    DateKindRolling,
+	"open":       DateKindRolling,
+	"continuous": DateKindRolling,
+	"tbd":        DateKindUnknown,
+	"tba":        DateKindUnknown,
+	"n/a":        DateKindUnknown,
+	"na":         DateKindUnknown,
+	"varies":     DateKindUnknown,
+}
+
+// NormalizedDate is the result of normalizing a date cell.
+type NormalizedDate struct {
+	Kind DateKind
+	// Date is the date given by the cell (at midnight UTC) when Kind is DateKindDate.
+	Date time.Time
+	// Raw is the original cell value.
+	Raw string
+}
+
+// NormalizeDateCell interprets the value of a spreadsheet cell that is expected to hold a date.
+// Recognized values are Excel serial date numbers (e.g. "45107"), any of DateLayouts
+// (e.g. "6/30/2023" or "June 30, 2023"), and keywords like "Rolling" or "TBD". Blank cells
+// are treated as an unknown date. Any extraLayouts are attempted after DateLayouts.
+//
+// When the value is not recognized, the result has DateKindUnknown and an error wrapping
+// ErrUnrecognizedDate is returned, so that callers may record a validation warning.
+// In all cases, the result preserves the raw cell value.
+func NormalizeDateCell(cell string, extraLayouts ...string) (NormalizedDate, error) {
+	result := NormalizedDate{Kind: DateKindUnknown, Raw: cell}
+	value := strings.Join(strings.Fields(cell), " ")
+	if value == "" {
+		return result, nil
+	}
+
+	if kind, ok := dateKeywords[strings.ToLower(strings.TrimSuffix(value, "."))]; ok {
+		result.Kind = kind
+		return result, nil
+	}
+
+	if serial, err := strconv.ParseFloat(value, 64); err == nil {
+		date, err := excelSerialToDate(serial)
+		if err != nil {
+			return result, fmt.Errorf("%w %q: %s", ErrUnrecognizedDate, cell, err)
+		}
+		result.Kind = DateKindDate
+		result.Date = date
+		return result, nil
+	}
+
+	for _, layouts := range [][]string{DateLayouts, extraLayouts} {
+		for _, layout := range layouts {
+			if date, err := time.Parse(layout, value); err == nil {
+				result.Kind = DateKindDate
+				result.Date = date
+				return result, nil
+			}
+		}
+	}
+	return result, fmt.Errorf("%w %q", ErrUnrecognizedDate, cell)
+}
+
+// excelSerialToDate converts a serial date number in Excel's 1900 date system to a date,
+// discarding any fractional (time of day) part.
+// Excel (for compatibility with Lotus 1-2-3) incorrectly treats 1900 as a leap year, so serial 60
+// is the nonexistent date 1900-02-29 and serials 1-59 are offset by one day from later serials.
+func excelSerialToDate(serial float64) (time.Time, error) {
+	if math.IsNaN(serial) || serial < 1 || serial >= maxExcelSerial+1 {
+		return time.Time{}, fmt.Errorf("serial %v is outside of the supported range", serial)
+	}
+	days := int(math.Floor(serial))
+	if days == 60 {
+		return time.Time{}, fmt.Errorf("serial 60 is the nonexistent date 1900-02-29")
+	}
+	epoch := time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+	if days < 60 {
+		epoch = epoch.AddDate(0, 0, 1)
+	}
+	return epoch.AddDate(0, 0, days), nil
+}
@@ -0,0 +1,108 @@
+package spreadsheetHelpers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDateCell(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	for _, tt := range []struct {
+		name         string
+		cell         string
+		expectedKind DateKind
+		expectedDate time.Time
+		expectedErr  error
+	}{
+		{"blank", "", DateKindUnknown, time.Time{}, nil},
+		{"whitespace", "  \t", DateKindUnknown, time.Time{}, nil},
+
+		{"Excel serial", "45107", DateKindDate, date(2023, time.June, 30), nil},
+		{"Excel serial with time of day", "45107.75", DateKindDate, date(2023, time.June, 30), nil},
+		{"Excel serial with surrounding whitespace", " 45107 ", DateKindDate, date(2023, time.June, 30), nil},
+		{"first Excel serial", "1", DateKindDate, date(1900, time.January, 1), nil},
+		{"Excel serial before leap year quirk", "59", DateKindDate, date(1900, time.February, 28), nil},
+		{"Excel serial after leap year quirk", "61", DateKindDate, date(1900, time.March, 1), nil},
+		{"Excel serial of 2000 leap day", "36585", DateKindDate, date(2000, time.February, 29), nil},
+		{"last Excel serial", "2958465", DateKindDate, date(9999, time.December, 31), nil},
+		{"Excel serial of nonexistent 1900 leap day", "60", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"zero Excel serial", "0", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"negative Excel serial", "-45107", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"Excel serial beyond 9999", "2958466", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"NaN", "NaN", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+
+		{"slashes with 4-digit year", "6/30/2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"slashes with padded 4-digit year", "06/05/2023", DateKindDate, date(2023, time.June, 5), nil},
+		{"slashes with 2-digit year", "6/30/23", DateKindDate, date(2023, time.June, 30), nil},
+		{"dashes with 4-digit year", "6-30-2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"dashes with 2-digit year", "5-11-23", DateKindDate, date(2023, time.May, 11), nil},
+		{"ISO 8601", "2023-06-30", DateKindDate, date(2023, time.June, 30), nil},
+		{"long month name", "June 30, 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"long month name without comma", "June 30 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"long month name with extra whitespace", " June  30,  2023 ", DateKindDate, date(2023, time.June, 30), nil},
+		{"short month name", "Jun 30, 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"short month name without comma", "Jun 30 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"abbreviated month name", "Jun. 30, 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"day before long month name", "30 June 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"day before short month name", "30 Jun 2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"Excel short date", "30-Jun-2023", DateKindDate, date(2023, time.June, 30), nil},
+		{"Excel short date with 2-digit year", "30-Jun-23", DateKindDate, date(2023, time.June, 30), nil},
+		{"nonexistent date", "2/30/2023", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"day before month", "30/6/2023", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+
+		{"Rolling", "Rolling", DateKindRolling, time.Time{}, nil},
+		{"rolling lower case", "rolling", DateKindRolling, time.Time{}, nil},
+		{"ROLLING upper case", "ROLLING", DateKindRolling, time.Time{}, nil},
+		{"Rolling with surrounding whitespace", " Rolling ", DateKindRolling, time.Time{}, nil},
+		{"Ongoing", "Ongoing", DateKindRolling, time.Time{}, nil},
+		{"Open", "Open", DateKindRolling, time.Time{}, nil},
+		{"Continuous", "Continuous", DateKindRolling, time.Time{}, nil},
+		{"TBD", "TBD", DateKindUnknown, time.Time{}, nil},
+		{"TBD with trailing period", "TBD.", DateKindUnknown, time.Time{}, nil},
+		{"TBA", "tba", DateKindUnknown, time.Time{}, nil},
+		{"N/A", "N/A", DateKindUnknown, time.Time{}, nil},
+		{"NA", "NA", DateKindUnknown, time.Time{}, nil},
+		{"Varies", "Varies", DateKindUnknown, time.Time{}, nil},
+
+		{"unrecognized text", "Sometime in the fall", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"date with trailing text", "6/30/2023 (anticipated)", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+		{"date range", "6/1/2023 - 6/30/2023", DateKindUnknown, time.Time{}, ErrUnrecognizedDate},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NormalizeDateCell(tt.cell)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedKind, result.Kind)
+			assert.Equal(t, tt.expectedDate, result.Date)
+			assert.Equal(t, tt.cell, result.Raw, "raw value should be preserved")
+		})
+	}
+}
+
+func TestNormalizeDateCellExtraLayouts(t *testing.T) {
+	_, err := NormalizeDateCell("2023.06.30")
+	assert.ErrorIs(t, err, ErrUnrecognizedDate)
+
+	result, err := NormalizeDateCell("2023.06.30", "2006.01.02")
+	assert.NoError(t, err)
+	assert.Equal(t, DateKindDate, result.Kind)
+	assert.Equal(t, time.Date(2023, time.June, 30, 0, 0, 0, 0, time.UTC), result.Date)
+
+	result, err = NormalizeDateCell("6/30/2023", "2006.01.02")
+	assert.NoError(t, err, "built-in layouts should still be recognized")
+	assert.Equal(t, time.Date(2023, time.June, 30, 0, 0, 0, 0, time.UTC), result.Date)
+}
+
+func TestDateKindString(t *testing.T) {
+	assert.Equal(t, "date", DateKindDate.String())
+	assert.Equal(t, "rolling", DateKindRolling.String())
+	assert.Equal(t, "unknown", DateKindUnknown.String())
+}
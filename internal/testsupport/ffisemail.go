@@ -0,0 +1,304 @@
+// Package testsupport provides helpers for generating realistic test inputs.
+package testsupport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Content-Transfer-Encoding values supported for the text parts of generated emails
+const (
+	EncodingNone            = ""
+	Encoding7Bit            = "7bit"
+	EncodingQuotedPrintable = "quoted-printable"
+	EncodingBase64          = "base64"
+)
+
+// Defaults for unset FFISEmail fields, which match the archived FFIS emails used as test fixtures.
+const (
+	DefaultFFISEmailFrom      = "FFIS <ffis@ffis.org>"
+	DefaultFFISEmailTo        = "Team <team@usdigitalresponse.org>"
+	DefaultFFISEmailMessageID = "<synthetic@ffis.org>"
+	DefaultFFISEmailSignature = "-FFIS"
+	DefaultFFISLinkText       = "Click here to download competitive grant update"
+)
+
+// DefaultFFISEmailDate is the date of generated emails when none is given.
+var DefaultFFISEmailDate = time.Date(2023, 4, 22, 14, 55, 26, 0, time.FixedZone("", -5*60*60))
+
+// base64LineLength is the maximum length of base64-encoded lines, per RFC 2045.
+const base64LineLength = 76
+
+// Link is a hyperlink in the body of a generated email.
+type Link struct {
+	// Text describes the link. Defaults to DefaultFFISLinkText.
+	Text string `json:"text,omitempty"`
+	URL  string `json:"url"`
+}
+
+// Attachment is a file attached to a generated email. Attachments are always base64-encoded
+// in generated emails, and their content is also base64-encoded in JSON.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Content     []byte `json:"content"`
+}
+
+// FFISEmail specifies an email in the style of those sent by FFIS through Mailchimp, i.e. a
+// multipart/alternative message whose text/plain and text/html parts present the same links.
+// The zero value describes an email without links.
+type FFISEmail struct {
+	// From defaults to DefaultFFISEmailFrom.
+	From string `json:"from,omitempty"`
+	// To defaults to DefaultFFISEmailTo.
+	To string `json:"to,omitempty"`
+	// Date defaults to DefaultFFISEmailDate.
+	Date time.Time `json:"date"`
+	// Subject is Q-encoded when it contains non-ASCII characters.
+	Subject string `json:"subject,omitempty"`
+	// MessageID defaults to DefaultFFISEmailMessageID.
+	MessageID string `json:"messageId,omitempty"`
+	// Headers are added to the message header, in order of their names.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Text is an optional paragraph that precedes the links.
+	Text string `json:"text,omitempty"`
+	// Links are presented in order, each as its own paragraph.
+	Links []Link `json:"links,omitempty"`
+	// Signature follows the links. Defaults to DefaultFFISEmailSignature.
+	Signature string `json:"signature,omitempty"`
+	// FooterLinks (e.g. social media and unsubscribe links) follow the signature.
+	FooterLinks []Link `json:"footerLinks,omitempty"`
+
+	// Encoding is the Content-Transfer-Encoding of the text parts (see the Encoding* constants).
+	Encoding string `json:"encoding,omitempty"`
+	// OmitPlainText omits the text/plain part.
+	OmitPlainText bool `json:"omitPlainText,omitempty"`
+	// OmitHTML omits the text/html part.
+	OmitHTML bool `json:"omitHtml,omitempty"`
+	// SinglePart makes the text/plain part the entire message, rather than a part of a
+	// multipart/alternative entity. The HTML part, attachments, and nesting are ignored.
+	SinglePart bool `json:"singlePart,omitempty"`
+	// Attachments are added alongside the text parts in a multipart/mixed entity.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Nesting is the number of additional multipart/mixed entities that enclose the text parts.
+	Nesting int `json:"nesting,omitempty"`
+}
+
+// mimePart is a rendered MIME entity.
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// emailBuilder assigns deterministic boundaries to the multipart entities of an email.
+type emailBuilder struct {
+	boundaries int
+}
+
+// Build returns the RFC 5322 message described by e, with CRLF line endings.
+// The output is deterministic: building the same FFISEmail always returns the same bytes.
+func (e FFISEmail) Build() ([]byte, error) {
+	if e.Encoding != EncodingNone && e.Encoding != Encoding7Bit &&
+		e.Encoding != EncodingQuotedPrintable && e.Encoding != EncodingBase64 {
+		return nil, fmt.Errorf("unsupported Content-Transfer-Encoding %q", e.Encoding)
+	}
+
+	var b emailBuilder
+	var content mimePart
+	if e.SinglePart {
+		content = textPart("text/plain", e.plainText(), e.Encoding)
+	} else {
+		var alternatives []mimePart
+		if !e.OmitPlainText {
+			alternatives = append(alternatives, textPart("text/plain", e.plainText(), e.Encoding))
+		}
+		if !e.OmitHTML {
+			alternatives = append(alternatives, textPart("text/html", e.htmlText(), e.Encoding))
+		}
+		content = b.multipart("alternative", alternatives...)
+		for i := 0; i < e.Nesting; i++ {
+			content = b.multipart("mixed", content)
+		}
+		if len(e.Attachments) > 0 {
+			parts := []mimePart{content}
+			for _, attachment := range e.Attachments {
+				parts = append(parts, attachmentPart(attachment))
+			}
+			content = b.multipart("mixed", parts...)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	writeHeader("MIME-Version", "1.0")
+	date := e.Date
+	if date.IsZero() {
+		date = DefaultFFISEmailDate
+	}
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", valueOr(e.MessageID, DefaultFFISEmailMessageID))
+	writeHeader("Subject", mime.QEncoding.Encode("UTF-8", e.Subject))
+	writeHeader("From", valueOr(e.From, DefaultFFISEmailFrom))
+	writeHeader("To", valueOr(e.To, DefaultFFISEmailTo))
+	for _, name := range sortedKeys(e.Headers) {
+		writeHeader(name, e.Headers[name])
+	}
+	for _, name := range sortedKeys(content.header) {
+		writeHeader(name, content.header.Get(name))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(content.body)
+	return buf.Bytes(), nil
+}
+
+// MustBuild is like Build but panics when the email cannot be built.
+func (e FFISEmail) MustBuild() []byte {
+	b, err := e.Build()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// plainText returns the body of the text/plain part, which presents each link in the style of
+// Mailchimp's plaintext rendering, i.e. its text followed by its URL in angle brackets.
+func (e FFISEmail) plainText() string {
+	var paragraphs []string
+	if e.Text != "" {
+		paragraphs = append(paragraphs, e.Text)
+	}
+	for _, link := range e.Links {
+		paragraphs = append(paragraphs, fmt.Sprintf("%s\r\n<%s>", valueOr(link.Text, DefaultFFISLinkText), link.URL))
+	}
+	paragraphs = append(paragraphs, valueOr(e.Signature, DefaultFFISEmailSignature))
+	if len(e.FooterLinks) > 0 {
+		footer := make([]string, len(e.FooterLinks))
+		for i, link := range e.FooterLinks {
+			footer[i] = fmt.Sprintf("%s <%s>", valueOr(link.Text, DefaultFFISLinkText), link.URL)
+		}
+		paragraphs = append(paragraphs, strings.Join(footer, "\r\n"))
+	}
+	return strings.Join(paragraphs, "\r\n\r\n") + "\r\n"
+}
+
+// htmlText returns the body of the text/html part, in which link URLs are entity-encoded
+// as they are by Mailchimp (e.g. "&amp;" for each "&" in a query string).
+func (e FFISEmail) htmlText() string {
+	var sb strings.Builder
+	sb.WriteString(`<div dir="ltr">`)
+	if e.Text != "" {
+		fmt.Fprintf(&sb, "<p>%s</p>", html.EscapeString(e.Text))
+	}
+	for _, link := range e.Links {
+		fmt.Fprintf(&sb, `<a href="%s">%s</a><br>`,
+			html.EscapeString(link.URL), html.EscapeString(valueOr(link.Text, DefaultFFISLinkText)))
+	}
+	fmt.Fprintf(&sb, `<div class="signature">%s</div>`,
+		html.EscapeString(valueOr(e.Signature, DefaultFFISEmailSignature)))
+	for _, link := range e.FooterLinks {
+		fmt.Fprintf(&sb, `<a href="%s">%s</a><br>`,
+			html.EscapeString(link.URL), html.EscapeString(valueOr(link.Text, DefaultFFISLinkText)))
+	}
+	sb.WriteString("</div>\r\n")
+	return sb.String()
+}
+
+// textPart returns a part of the given text media type whose content is encoded with the
+// given Content-Transfer-Encoding.
+func textPart(mediaType, text, encoding string) mimePart {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "UTF-8"}))
+	if encoding != EncodingNone {
+		header.Set("Content-Transfer-Encoding", encoding)
+	}
+	var body []byte
+	switch encoding {
+	case EncodingQuotedPrintable:
+		var buf bytes.Buffer
+		w := quotedprintable.NewWriter(&buf)
+		w.Write([]byte(text))
+		w.Close()
+		body = buf.Bytes()
+	case EncodingBase64:
+		body = encodeBase64Lines([]byte(text))
+	default:
+		body = []byte(text)
+	}
+	return mimePart{header: header, body: body}
+}
+
+// attachmentPart returns the base64-encoded part for attachment.
+func attachmentPart(attachment Attachment) mimePart {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(attachment.ContentType,
+		map[string]string{"name": attachment.Filename}))
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": attachment.Filename}))
+	header.Set("Content-Transfer-Encoding", EncodingBase64)
+	return mimePart{header: header, body: encodeBase64Lines(attachment.Content)}
+}
+
+// multipart returns a multipart entity of the given subtype that encloses parts.
+func (b *emailBuilder) multipart(subtype string, parts ...mimePart) mimePart {
+	b.boundaries++
+	boundary := fmt.Sprintf("ffis-boundary-%d", b.boundaries)
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.SetBoundary(boundary); err != nil {
+		panic(err)
+	}
+	for _, part := range parts {
+		pw, err := w.CreatePart(part.header)
+		if err != nil {
+			panic(err)
+		}
+		pw.Write(part.body)
+	}
+	w.Close()
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype,
+		map[string]string{"boundary": boundary}))
+	return mimePart{header: header, body: buf.Bytes()}
+}
+
+// encodeBase64Lines returns the base64 encoding of data, wrapped at base64LineLength.
+func encodeBase64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
+
+// valueOr returns value, or fallback when value is empty.
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
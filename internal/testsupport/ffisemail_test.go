@@ -0,0 +1,194 @@
+package testsupport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parsedEmail is the decoded content of an email, as read by net/mail and mime/multipart.
+type parsedEmail struct {
+	header      mail.Header
+	texts       map[string][]string
+	attachments map[string][]byte
+	maxDepth    int
+}
+
+func parseBuiltEmail(t *testing.T, b []byte) parsedEmail {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	require.NoError(t, err)
+	parsed := parsedEmail{header: msg.Header, texts: map[string][]string{}, attachments: map[string][]byte{}}
+	parseEntity(t, &parsed, msg.Header, msg.Body, 0)
+	return parsed
+}
+
+func parseEntity(t *testing.T, parsed *parsedEmail, header map[string][]string, body io.Reader, depth int) {
+	t.Helper()
+	get := func(name string) string {
+		if values := header[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	mediaType, params, err := mime.ParseMediaType(get("Content-Type"))
+	require.NoError(t, err)
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth+1 > parsed.maxDepth {
+			parsed.maxDepth = depth + 1
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return
+			}
+			require.NoError(t, err)
+			parseEntity(t, parsed, p.Header, p, depth+1)
+		}
+	}
+
+	switch get("Content-Transfer-Encoding") {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	if disposition, dispositionParams, err := mime.ParseMediaType(get("Content-Disposition")); err == nil &&
+		disposition == "attachment" {
+		parsed.attachments[dispositionParams["filename"]] = content
+		return
+	}
+	parsed.texts[mediaType] = append(parsed.texts[mediaType], string(content))
+}
+
+func TestFFISEmailRoundTrip(t *testing.T) {
+	links := []Link{
+		{URL: "https://mcusercontent.com/123456/files/file-01.xlsx"},
+		{Text: "Download the tracker", URL: "https://mcusercontent.com/123456/files/" +
+			strings.Repeat("long-path-segment/", 8) + "tracker.xlsx?u=123&id=abc"},
+	}
+	attachment := Attachment{Filename: "update.pdf", ContentType: "application/pdf",
+		Content: bytes.Repeat([]byte("%PDF-1.4\x00\xff"), 20)}
+
+	for _, tt := range []struct {
+		name          string
+		email         FFISEmail
+		expectedTypes []string
+		expectedDepth int
+	}{
+		{"unencoded", FFISEmail{Links: links}, []string{"text/html", "text/plain"}, 1},
+		{"7bit", FFISEmail{Links: links, Encoding: Encoding7Bit}, []string{"text/html", "text/plain"}, 1},
+		{"quoted-printable", FFISEmail{Links: links, Encoding: EncodingQuotedPrintable}, []string{"text/html", "text/plain"}, 1},
+		{"base64", FFISEmail{Links: links, Encoding: EncodingBase64}, []string{"text/html", "text/plain"}, 1},
+		{"single part", FFISEmail{Links: links, Encoding: EncodingBase64, SinglePart: true}, []string{"text/plain"}, 0},
+		{"plaintext only", FFISEmail{Links: links, OmitHTML: true}, []string{"text/plain"}, 1},
+		{"HTML only", FFISEmail{Links: links, OmitPlainText: true}, []string{"text/html"}, 1},
+		{"nested", FFISEmail{Links: links, Nesting: 3}, []string{"text/html", "text/plain"}, 4},
+		{"attachment", FFISEmail{Links: links, Attachments: []Attachment{attachment}}, []string{"text/html", "text/plain"}, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.email.Build()
+			require.NoError(t, err)
+			for _, line := range strings.Split(strings.TrimSuffix(string(b), "\r\n"), "\r\n") {
+				assert.NotContains(t, line, "\n", "lines must end with CRLF")
+				assert.LessOrEqual(t, len(line), 998, "lines must not exceed the RFC 5322 limit")
+			}
+			parsed := parseBuiltEmail(t, b)
+
+			from, err := parsed.header.AddressList("From")
+			require.NoError(t, err)
+			assert.Equal(t, []*mail.Address{{Name: "FFIS", Address: "ffis@ffis.org"}}, from)
+			date, err := parsed.header.Date()
+			require.NoError(t, err)
+			assert.True(t, DefaultFFISEmailDate.Equal(date))
+			assert.Equal(t, tt.expectedDepth, parsed.maxDepth)
+
+			types := []string{}
+			for mediaType := range parsed.texts {
+				types = append(types, mediaType)
+			}
+			assert.ElementsMatch(t, tt.expectedTypes, types)
+			if plaintexts := parsed.texts["text/plain"]; len(plaintexts) > 0 {
+				require.Len(t, plaintexts, 1)
+				assert.Contains(t, plaintexts[0], DefaultFFISLinkText+"\r\n<"+links[0].URL+">")
+				assert.Contains(t, plaintexts[0], "Download the tracker\r\n<"+links[1].URL+">")
+				assert.True(t, strings.HasSuffix(plaintexts[0], DefaultFFISEmailSignature+"\r\n"))
+			}
+			if htmls := parsed.texts["text/html"]; len(htmls) > 0 {
+				require.Len(t, htmls, 1)
+				assert.Contains(t, htmls[0], `<a href="`+links[0].URL+`">`)
+				assert.Contains(t, htmls[0], strings.ReplaceAll(links[1].URL, "&", "&amp;"))
+			}
+			if len(tt.email.Attachments) > 0 {
+				assert.Equal(t, map[string][]byte{"update.pdf": attachment.Content}, parsed.attachments)
+			} else {
+				assert.Empty(t, parsed.attachments)
+			}
+		})
+	}
+}
+
+func TestFFISEmailHeaders(t *testing.T) {
+	b, err := FFISEmail{
+		From:      "Someone <someone@example.org>",
+		To:        "ingest+statea@example.org",
+		Date:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Subject:   "Mise à jour",
+		MessageID: "<abc@example.org>",
+		Headers:   map[string]string{"X-Spam-Status": "No", "Authentication-Results": "spf=pass"},
+	}.Build()
+	require.NoError(t, err)
+	parsed := parseBuiltEmail(t, b)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Mise à jour", subject)
+	assert.Equal(t, "Someone <someone@example.org>", parsed.header.Get("From"))
+	assert.Equal(t, "ingest+statea@example.org", parsed.header.Get("To"))
+	assert.Equal(t, "Tue, 02 Jan 2024 03:04:05 +0000", parsed.header.Get("Date"))
+	assert.Equal(t, "<abc@example.org>", parsed.header.Get("Message-ID"))
+	assert.Equal(t, "No", parsed.header.Get("X-Spam-Status"))
+	assert.Equal(t, "spf=pass", parsed.header.Get("Authentication-Results"))
+}
+
+func TestFFISEmailUnsupportedEncoding(t *testing.T) {
+	_, err := FFISEmail{Encoding: "uuencode"}.Build()
+	assert.Error(t, err)
+	assert.Panics(t, func() { FFISEmail{Encoding: "uuencode"}.MustBuild() })
+}
+
+// TestFFISEmailGolden guards against unintended changes to generated emails, which would
+// invalidate golden files that were built from them.
+func TestFFISEmailGolden(t *testing.T) {
+	email := FFISEmail{
+		Text: "This week's competitive grant update is ready.",
+		Links: []Link{
+			{URL: "https://mcusercontent.com/123456/files/file-01.xlsx"},
+		},
+		FooterLinks: []Link{
+			{Text: "Unsubscribe", URL: "https://ffis.us1.list-manage.com/unsubscribe?u=123456&id=abcdef"},
+		},
+		Encoding:    EncodingQuotedPrintable,
+		Attachments: []Attachment{{Filename: "notes.txt", ContentType: "text/plain", Content: []byte("notes")}},
+	}
+	first := email.MustBuild()
+	assert.Equal(t, first, email.MustBuild(), "output must be deterministic")
+
+	expected, err := os.ReadFile("fixtures/golden.eml")
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(first))
+}
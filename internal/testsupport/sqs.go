@@ -0,0 +1,268 @@
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// SQS operations recorded by RecordingSQS
+const (
+	SQSSendMessage             = "SendMessage"
+	SQSSendMessageBatch        = "SendMessageBatch"
+	SQSChangeMessageVisibility = "ChangeMessageVisibility"
+	SQSGetQueueAttributes      = "GetQueueAttributes"
+)
+
+// SQSCall is a call that was made to a RecordingSQS.
+type SQSCall struct {
+	// Operation is the name of the SQS operation that was called, e.g. SQSSendMessage.
+	Operation string
+	// Input is the input of the call, e.g. *sqs.SendMessageInput for SQSSendMessage.
+	Input interface{}
+	// Err is the error returned by the call, if any.
+	Err error
+}
+
+// SentMessage is a message that was successfully sent to a RecordingSQS, either with
+// SendMessage or as an entry of SendMessageBatch.
+type SentMessage struct {
+	QueueURL string
+	// EntryID is the ID of the batch entry, which is empty for messages sent with SendMessage.
+	EntryID                string
+	Body                   string
+	Attributes             map[string]sqsTypes.MessageAttributeValue
+	MessageGroupID         string
+	MessageDeduplicationID string
+}
+
+// entryFailure fails messages that match a predicate.
+type entryFailure struct {
+	match func(SentMessage) bool
+	err   error
+}
+
+// RecordingSQS is a mock SQS client that records the full input of every call made to it,
+// so that tests can assert on every message sent by a handler rather than only the last one.
+// Calls succeed unless failures are scripted with FailCall or FailEntries.
+// It is safe for concurrent use.
+type RecordingSQS struct {
+	// QueueAttributes are returned by GetQueueAttributes.
+	QueueAttributes map[string]string
+
+	mu          sync.Mutex
+	calls       []SQSCall
+	sent        []SentMessage
+	counts      map[string]int
+	callErrs    map[string]map[int]error
+	entryErrs   []entryFailure
+	nextMessage int
+}
+
+// FailCall causes the nth call (counting from 1) of operation to return err.
+// Messages are not recorded as sent by failed calls.
+func (m *RecordingSQS) FailCall(operation string, n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.callErrs == nil {
+		m.callErrs = make(map[string]map[int]error)
+	}
+	if m.callErrs[operation] == nil {
+		m.callErrs[operation] = make(map[int]error)
+	}
+	m.callErrs[operation][n] = err
+}
+
+// FailEntries causes every subsequent message that matches match to fail with err:
+// SendMessage returns err, and SendMessageBatch reports the matching entries as failed
+// (with err as the failure message) while sending the others.
+func (m *RecordingSQS) FailEntries(match func(SentMessage) bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entryErrs = append(m.entryErrs, entryFailure{match, err})
+}
+
+// FailAll causes every subsequent message to fail with err. See FailEntries.
+func (m *RecordingSQS) FailAll(err error) {
+	m.FailEntries(func(SentMessage) bool { return true }, err)
+}
+
+// ClearFailures removes every failure scripted with FailCall or FailEntries.
+func (m *RecordingSQS) ClearFailures() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callErrs = nil
+	m.entryErrs = nil
+}
+
+// Calls returns the calls made to operation, in order, or every call when operation is empty.
+func (m *RecordingSQS) Calls(operation string) []SQSCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	calls := []SQSCall{}
+	for _, call := range m.calls {
+		if operation == "" || call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CallCount returns the number of calls made to operation, including failed calls.
+func (m *RecordingSQS) CallCount(operation string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[operation]
+}
+
+// Sent returns the messages that were successfully sent, in the order that they were sent.
+func (m *RecordingSQS) Sent() []SentMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentMessage{}, m.sent...)
+}
+
+// AssertSentToQueue asserts that count messages were successfully sent to queueURL,
+// and returns those messages.
+func (m *RecordingSQS) AssertSentToQueue(t assert.TestingT, queueURL string, count int) []SentMessage {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	sent := []SentMessage{}
+	for _, msg := range m.Sent() {
+		if msg.QueueURL == queueURL {
+			sent = append(sent, msg)
+		}
+	}
+	assert.Len(t, sent, count, "Unexpected number of messages sent to %s", queueURL)
+	return sent
+}
+
+// AssertAttribute asserts that msg has a String (or Number) message attribute called name
+// whose value is expected.
+func AssertAttribute(t assert.TestingT, msg SentMessage, name, expected string) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	attr, ok := msg.Attributes[name]
+	if !assert.True(t, ok, "Message has no %q attribute", name) {
+		return false
+	}
+	return assert.Equal(t, expected, aws.ToString(attr.StringValue), "Unexpected value of %q attribute", name)
+}
+
+// record records a call to operation with input, and returns the recorded call along with
+// the error scripted for it with FailCall, if any. The caller must hold m.mu.
+func (m *RecordingSQS) record(operation string, input interface{}) (*SQSCall, error) {
+	if m.counts == nil {
+		m.counts = make(map[string]int)
+	}
+	m.counts[operation]++
+	m.calls = append(m.calls, SQSCall{Operation: operation, Input: input})
+	call := &m.calls[len(m.calls)-1]
+	call.Err = m.callErrs[operation][m.counts[operation]]
+	return call, call.Err
+}
+
+// entryErr returns the error scripted with FailEntries for msg, if any.
+// The caller must hold m.mu.
+func (m *RecordingSQS) entryErr(msg SentMessage) error {
+	for _, failure := range m.entryErrs {
+		if failure.match(msg) {
+			return failure.err
+		}
+	}
+	return nil
+}
+
+// messageID returns a new message ID. The caller must hold m.mu.
+func (m *RecordingSQS) messageID() *string {
+	m.nextMessage++
+	return aws.String(fmt.Sprintf("00000000-0000-0000-0000-%012d", m.nextMessage))
+}
+
+func (m *RecordingSQS) SendMessage(ctx context.Context,
+	params *sqs.SendMessageInput,
+	optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	call, err := m.record(SQSSendMessage, params)
+	if err != nil {
+		return nil, err
+	}
+	msg := SentMessage{
+		QueueURL:               aws.ToString(params.QueueUrl),
+		Body:                   aws.ToString(params.MessageBody),
+		Attributes:             params.MessageAttributes,
+		MessageGroupID:         aws.ToString(params.MessageGroupId),
+		MessageDeduplicationID: aws.ToString(params.MessageDeduplicationId),
+	}
+	if err := m.entryErr(msg); err != nil {
+		call.Err = err
+		return nil, err
+	}
+	m.sent = append(m.sent, msg)
+	return &sqs.SendMessageOutput{MessageId: m.messageID()}, nil
+}
+
+func (m *RecordingSQS) SendMessageBatch(ctx context.Context,
+	params *sqs.SendMessageBatchInput,
+	optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.record(SQSSendMessageBatch, params); err != nil {
+		return nil, err
+	}
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		msg := SentMessage{
+			QueueURL:               aws.ToString(params.QueueUrl),
+			EntryID:                aws.ToString(entry.Id),
+			Body:                   aws.ToString(entry.MessageBody),
+			Attributes:             entry.MessageAttributes,
+			MessageGroupID:         aws.ToString(entry.MessageGroupId),
+			MessageDeduplicationID: aws.ToString(entry.MessageDeduplicationId),
+		}
+		if err := m.entryErr(msg); err != nil {
+			output.Failed = append(output.Failed, sqsTypes.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String("InternalError"),
+				Message: aws.String(err.Error()),
+			})
+			continue
+		}
+		m.sent = append(m.sent, msg)
+		output.Successful = append(output.Successful, sqsTypes.SendMessageBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: m.messageID(),
+		})
+	}
+	return output, nil
+}
+
+func (m *RecordingSQS) ChangeMessageVisibility(ctx context.Context,
+	params *sqs.ChangeMessageVisibilityInput,
+	optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.record(SQSChangeMessageVisibility, params); err != nil {
+		return nil, err
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (m *RecordingSQS) GetQueueAttributes(ctx context.Context,
+	params *sqs.GetQueueAttributesInput,
+	optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.record(SQSGetQueueAttributes, params); err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: m.QueueAttributes}, nil
+}
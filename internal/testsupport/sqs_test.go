@@ -0,0 +1,116 @@
+package testsupport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockTestingT records whether an assertion failed, so that assertion helpers can be tested.
+type mockTestingT struct{ failed bool }
+
+func (t *mockTestingT) Errorf(format string, args ...interface{}) { t.failed = true }
+
+func TestRecordingSQSSendMessage(t *testing.T) {
+	m := &RecordingSQS{}
+	for _, body := range []string{"first", "second"} {
+		_, err := m.SendMessage(context.TODO(), &sqs.SendMessageInput{
+			QueueUrl:    aws.String("https://sqs.example.com/fifo.fifo"),
+			MessageBody: aws.String(body),
+			MessageAttributes: map[string]sqsTypes.MessageAttributeValue{
+				"Route": {DataType: aws.String("String"), StringValue: aws.String("ffis")},
+			},
+			MessageGroupId:         aws.String("group"),
+			MessageDeduplicationId: aws.String(body),
+		})
+		require.NoError(t, err)
+	}
+
+	sent := m.AssertSentToQueue(t, "https://sqs.example.com/fifo.fifo", 2)
+	assert.Equal(t, "first", sent[0].Body)
+	assert.Equal(t, "second", sent[1].Body)
+	assert.Equal(t, "group", sent[1].MessageGroupID)
+	assert.Equal(t, "second", sent[1].MessageDeduplicationID)
+	AssertAttribute(t, sent[0], "Route", "ffis")
+	assert.Equal(t, 2, m.CallCount(SQSSendMessage))
+	calls := m.Calls(SQSSendMessage)
+	require.Len(t, calls, 2)
+	assert.Equal(t, "first", aws.ToString(calls[0].Input.(*sqs.SendMessageInput).MessageBody))
+
+	mt := &mockTestingT{}
+	m.AssertSentToQueue(mt, "https://sqs.example.com/other", 1)
+	assert.True(t, mt.failed, "Assertion should fail for a queue without messages")
+	mt = &mockTestingT{}
+	AssertAttribute(mt, sent[0], "Missing", "")
+	assert.True(t, mt.failed, "Assertion should fail for a missing attribute")
+	mt = &mockTestingT{}
+	AssertAttribute(mt, sent[0], "Route", "other")
+	assert.True(t, mt.failed, "Assertion should fail for an unexpected attribute value")
+}
+
+func TestRecordingSQSFailCall(t *testing.T) {
+	m := &RecordingSQS{}
+	sendErr := errors.New("service unavailable")
+	m.FailCall(SQSSendMessage, 2, sendErr)
+	m.FailCall(SQSChangeMessageVisibility, 1, sendErr)
+
+	for i, expected := range []error{nil, sendErr, nil} {
+		_, err := m.SendMessage(context.TODO(), &sqs.SendMessageInput{
+			QueueUrl: aws.String("https://sqs.example.com/q"), MessageBody: aws.String("body"),
+		})
+		assert.Equal(t, expected, err, "call %d", i+1)
+	}
+	_, err := m.ChangeMessageVisibility(context.TODO(), &sqs.ChangeMessageVisibilityInput{})
+	assert.ErrorIs(t, err, sendErr)
+	_, err = m.ChangeMessageVisibility(context.TODO(), &sqs.ChangeMessageVisibilityInput{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, m.CallCount(SQSSendMessage))
+	m.AssertSentToQueue(t, "https://sqs.example.com/q", 2)
+	calls := m.Calls("")
+	require.Len(t, calls, 5)
+	assert.ErrorIs(t, calls[1].Err, sendErr)
+	assert.ErrorIs(t, calls[3].Err, sendErr)
+	assert.Equal(t, SQSChangeMessageVisibility, calls[3].Operation)
+
+	m.ClearFailures()
+	m.FailAll(sendErr)
+	_, err = m.SendMessage(context.TODO(), &sqs.SendMessageInput{})
+	assert.ErrorIs(t, err, sendErr)
+	assert.ErrorIs(t, m.Calls(SQSSendMessage)[3].Err, sendErr)
+}
+
+func TestRecordingSQSFailEntries(t *testing.T) {
+	m := &RecordingSQS{}
+	m.FailEntries(func(msg SentMessage) bool { return strings.HasPrefix(msg.Body, "bad") },
+		errors.New("rejected"))
+
+	out, err := m.SendMessageBatch(context.TODO(), &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String("https://sqs.example.com/q"),
+		Entries: []sqsTypes.SendMessageBatchRequestEntry{
+			{Id: aws.String("1"), MessageBody: aws.String("good")},
+			{Id: aws.String("2"), MessageBody: aws.String("bad")},
+			{Id: aws.String("3"), MessageBody: aws.String("also good")},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, out.Successful, 2)
+	require.Len(t, out.Failed, 1)
+	assert.Equal(t, "2", aws.ToString(out.Failed[0].Id))
+	assert.Equal(t, "rejected", aws.ToString(out.Failed[0].Message))
+	sent := m.AssertSentToQueue(t, "https://sqs.example.com/q", 2)
+	assert.Equal(t, []string{"1", "3"}, []string{sent[0].EntryID, sent[1].EntryID})
+
+	_, err = m.SendMessage(context.TODO(), &sqs.SendMessageInput{
+		QueueUrl: aws.String("https://sqs.example.com/q"), MessageBody: aws.String("bad again"),
+	})
+	assert.EqualError(t, err, "rejected")
+	m.AssertSentToQueue(t, "https://sqs.example.com/q", 2)
+}
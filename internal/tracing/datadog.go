@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// DatadogProvider starts spans with dd-trace-go. The tracer itself is started and flushed
+// by the Datadog Lambda wrapper.
+type DatadogProvider struct{}
+
+func NewDatadogProvider() DatadogProvider {
+	return DatadogProvider{}
+}
+
+func (DatadogProvider) StartSpanFromContext(ctx context.Context, operationName string) (Span, context.Context) {
+	span, ctx := tracer.StartSpanFromContext(ctx, operationName)
+	return datadogSpan{span}, ctx
+}
+
+func (DatadogProvider) SpanIDsFromContext(ctx context.Context) (string, string, bool) {
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(span.Context().TraceID(), 10),
+		strconv.FormatUint(span.Context().SpanID(), 10), true
+}
+
+func (DatadogProvider) Flush(ctx context.Context) error {
+	return nil
+}
+
+type datadogSpan struct {
+	span ddtrace.Span
+}
+
+func (s datadogSpan) SetTag(key string, value interface{}) {
+	s.span.SetTag(key, value)
+}
+
+func (s datadogSpan) Finish(opts ...FinishOption) {
+	cfg := newFinishConfig(opts)
+	s.span.Finish(tracer.WithError(cfg.Error))
+}
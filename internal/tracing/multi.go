@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+)
+
+// MultiProvider starts each span with every one of its providers, so that traces are sent
+// to each of their destinations.
+type MultiProvider []Provider
+
+// NewMultiProvider creates a provider that starts spans with each of providers.
+// Trace and span IDs are those of the first provider (see SpanIDsFromContext).
+func NewMultiProvider(providers ...Provider) MultiProvider {
+	return MultiProvider(providers)
+}
+
+func (m MultiProvider) StartSpanFromContext(ctx context.Context, operationName string) (Span, context.Context) {
+	spans := make(multiSpan, 0, len(m))
+	for _, p := range m {
+		var span Span
+		span, ctx = p.StartSpanFromContext(ctx, operationName)
+		spans = append(spans, span)
+	}
+	return spans, ctx
+}
+
+func (m MultiProvider) SpanIDsFromContext(ctx context.Context) (string, string, bool) {
+	if len(m) == 0 {
+		return "", "", false
+	}
+	return m[0].SpanIDsFromContext(ctx)
+}
+
+func (m MultiProvider) Flush(ctx context.Context) error {
+	errs := make([]error, 0, len(m))
+	for _, p := range m {
+		errs = append(errs, p.Flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+type multiSpan []Span
+
+func (s multiSpan) SetTag(key string, value interface{}) {
+	for _, span := range s {
+		span.SetTag(key, value)
+	}
+}
+
+func (s multiSpan) Finish(opts ...FinishOption) {
+	for _, span := range s {
+		span.Finish(opts...)
+	}
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/usdigitalresponse/grants-ingest"
+
+// OpenTelemetryProvider starts spans with the OpenTelemetry SDK.
+type OpenTelemetryProvider struct {
+	tp     *sdktrace.TracerProvider
+	tracer trace.Tracer
+}
+
+// NewOpenTelemetryProvider creates a provider that starts spans using tp.
+func NewOpenTelemetryProvider(tp *sdktrace.TracerProvider) *OpenTelemetryProvider {
+	return &OpenTelemetryProvider{tp: tp, tracer: tp.Tracer(instrumentationName)}
+}
+
+// NewOpenTelemetryProviderFromEnv creates a provider that exports spans over OTLP/HTTP.
+// The exporter, resource, and sampler are configured by the standard OTEL_* environment variables
+// (e.g. OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_SERVICE_NAME, and OTEL_TRACES_SAMPLER).
+func NewOpenTelemetryProviderFromEnv(ctx context.Context) (*OpenTelemetryProvider, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP trace exporter: %w", err)
+	}
+	res, err := resource.New(ctx, resource.WithFromEnv(), resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, fmt.Errorf("error creating OpenTelemetry resource: %w", err)
+	}
+	return NewOpenTelemetryProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)), nil
+}
+
+func (p *OpenTelemetryProvider) StartSpanFromContext(ctx context.Context, operationName string) (Span, context.Context) {
+	ctx, span := p.tracer.Start(ctx, operationName)
+	return otelSpan{span}, ctx
+}
+
+func (p *OpenTelemetryProvider) SpanIDsFromContext(ctx context.Context) (string, string, bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", "", false
+	}
+	return sc.TraceID().String(), sc.SpanID().String(), true
+}
+
+func (p *OpenTelemetryProvider) Flush(ctx context.Context) error {
+	return p.tp.ForceFlush(ctx)
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetTag(key string, value interface{}) {
+	var attr attribute.KeyValue
+	switch v := value.(type) {
+	case string:
+		attr = attribute.String(key, v)
+	case bool:
+		attr = attribute.Bool(key, v)
+	case int:
+		attr = attribute.Int(key, v)
+	case int64:
+		attr = attribute.Int64(key, v)
+	case float64:
+		attr = attribute.Float64(key, v)
+	default:
+		attr = attribute.String(key, fmt.Sprint(v))
+	}
+	s.span.SetAttributes(attr)
+}
+
+func (s otelSpan) Finish(opts ...FinishOption) {
+	cfg := newFinishConfig(opts)
+	if cfg.Error != nil {
+		s.span.RecordError(cfg.Error)
+		s.span.SetStatus(codes.Error, cfg.Error.Error())
+	}
+	s.span.End()
+}
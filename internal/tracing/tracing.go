@@ -0,0 +1,139 @@
+// Package tracing provides a thin abstraction over the distributed tracing library used by
+// Lambda handlers, so that traces may be sent to Datadog (the default), exported to any
+// OpenTelemetry-compatible collector, or both.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	ProviderDatadog       = "datadog"
+	ProviderOpenTelemetry = "otel"
+	// ProviderDatadogAndOpenTelemetry sends every span to Datadog and exports it with
+	// OpenTelemetry, e.g. while migrating from one to the other.
+	ProviderDatadogAndOpenTelemetry = "datadog+otel"
+)
+
+var ErrUnknownProvider = errors.New("unknown tracing provider")
+
+// Span is a single unit of traced work.
+type Span interface {
+	// SetTag sets a tag (or attribute) on the span.
+	SetTag(key string, value interface{})
+	// Finish marks the end of the span's work.
+	Finish(opts ...FinishOption)
+}
+
+// Provider starts spans using a particular tracing library.
+type Provider interface {
+	StartSpanFromContext(ctx context.Context, operationName string) (Span, context.Context)
+	// SpanIDsFromContext returns the trace and span IDs of the span found in ctx, if any.
+	SpanIDsFromContext(ctx context.Context) (traceID, spanID string, ok bool)
+	// Flush exports any buffered spans. It should be called before each invocation returns,
+	// since the Lambda execution environment may be frozen indefinitely once it does.
+	Flush(ctx context.Context) error
+}
+
+// FinishConfig holds the options that may be provided when finishing a span.
+type FinishConfig struct {
+	Error error
+}
+
+// FinishOption configures how a span is finished.
+type FinishOption func(*FinishConfig)
+
+// WithError marks the finished span as having failed when err is non-nil.
+func WithError(err error) FinishOption {
+	return func(cfg *FinishConfig) {
+		cfg.Error = err
+	}
+}
+
+// OutcomeTag is the tag set by FinishWithOutcome to record whether a span's work succeeded.
+const (
+	OutcomeTag     = "outcome"
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// FinishWithOutcome sets the OutcomeTag of span according to err and finishes it,
+// marking the span as having failed when err is non-nil.
+func FinishWithOutcome(span Span, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	span.SetTag(OutcomeTag, outcome)
+	span.Finish(WithError(err))
+}
+
+func newFinishConfig(opts []FinishOption) FinishConfig {
+	cfg := FinishConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+var (
+	mu       sync.RWMutex
+	provider Provider = NewDatadogProvider()
+)
+
+// SetProvider replaces the provider used to start spans.
+func SetProvider(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	provider = p
+}
+
+func currentProvider() Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return provider
+}
+
+// Configure sets the provider used to start spans according to name, which is typically
+// given by the TRACING_PROVIDER environment variable.
+func Configure(ctx context.Context, name string) error {
+	switch name {
+	case "", ProviderDatadog:
+		SetProvider(NewDatadogProvider())
+	case ProviderOpenTelemetry:
+		p, err := NewOpenTelemetryProviderFromEnv(ctx)
+		if err != nil {
+			return err
+		}
+		SetProvider(p)
+	case ProviderDatadogAndOpenTelemetry:
+		p, err := NewOpenTelemetryProviderFromEnv(ctx)
+		if err != nil {
+			return err
+		}
+		SetProvider(NewMultiProvider(NewDatadogProvider(), p))
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+	return nil
+}
+
+// StartSpanFromContext starts a new span with the given operation name as a child of any
+// span found in ctx, and returns the span along with a context containing the new span.
+func StartSpanFromContext(ctx context.Context, operationName string) (Span, context.Context) {
+	return currentProvider().StartSpanFromContext(ctx, operationName)
+}
+
+// SpanIDsFromContext returns the trace and span IDs of the span found in ctx.
+// Returns false when ctx does not contain a span.
+func SpanIDsFromContext(ctx context.Context) (traceID, spanID string, ok bool) {
+	return currentProvider().SpanIDsFromContext(ctx)
+}
+
+// Flush exports any spans buffered by the current provider.
+func Flush(ctx context.Context) error {
+	return currentProvider().Flush(ctx)
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOpenTelemetryProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	SetProvider(NewOpenTelemetryProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+	t.Cleanup(func() { SetProvider(NewDatadogProvider()) })
+
+	_, _, ok := SpanIDsFromContext(context.Background())
+	assert.False(t, ok)
+	parent, ctx := StartSpanFromContext(context.Background(), "handle.records")
+	traceID, spanID, ok := SpanIDsFromContext(ctx)
+	require.True(t, ok)
+	parent.SetTag("record_count", 2)
+	okSpan, _ := StartSpanFromContext(ctx, "handle.record")
+	okSpan.Finish(WithError(nil))
+	failedSpan, _ := StartSpanFromContext(ctx, "handle.record")
+	failedSpan.Finish(WithError(errors.New("oh no")))
+	parent.Finish()
+	require.NoError(t, Flush(context.Background()))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "handle.record", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, "handle.record", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "oh no", spans[1].Status().Description)
+	assert.Equal(t, "handle.records", spans[2].Name())
+	assert.Contains(t, spans[2].Attributes(), attribute.Int("record_count", 2))
+	assert.Equal(t, spans[2].SpanContext().TraceID().String(), traceID)
+	assert.Equal(t, spans[2].SpanContext().SpanID().String(), spanID)
+	for _, child := range spans[:2] {
+		assert.Equal(t, spans[2].SpanContext().SpanID(), child.Parent().SpanID())
+	}
+}
+
+func TestFinishWithOutcome(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	SetProvider(NewOpenTelemetryProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+	t.Cleanup(func() { SetProvider(NewDatadogProvider()) })
+
+	okSpan, _ := StartSpanFromContext(context.Background(), "ok")
+	FinishWithOutcome(okSpan, nil)
+	failedSpan, _ := StartSpanFromContext(context.Background(), "failed")
+	FinishWithOutcome(failedSpan, errors.New("oh no"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Contains(t, spans[0].Attributes(), attribute.String(OutcomeTag, OutcomeSuccess))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Contains(t, spans[1].Attributes(), attribute.String(OutcomeTag, OutcomeError))
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() { SetProvider(NewDatadogProvider()) })
+
+	require.NoError(t, Configure(context.Background(), ProviderDatadog))
+	assert.IsType(t, DatadogProvider{}, currentProvider())
+
+	require.NoError(t, Configure(context.Background(), ProviderOpenTelemetry))
+	assert.IsType(t, &OpenTelemetryProvider{}, currentProvider())
+
+	require.NoError(t, Configure(context.Background(), ProviderDatadogAndOpenTelemetry))
+	require.IsType(t, MultiProvider{}, currentProvider())
+	providers := currentProvider().(MultiProvider)
+	require.Len(t, providers, 2)
+	assert.IsType(t, DatadogProvider{}, providers[0])
+	assert.IsType(t, &OpenTelemetryProvider{}, providers[1])
+
+	assert.ErrorIs(t, Configure(context.Background(), "zipkin"), ErrUnknownProvider)
+}
+
+type fakeSpan struct {
+	name string
+	tags map[string]interface{}
+	err  error
+}
+
+func (s *fakeSpan) SetTag(key string, value interface{}) { s.tags[key] = value }
+
+func (s *fakeSpan) Finish(opts ...FinishOption) { s.err = newFinishConfig(opts).Error }
+
+type fakeProvider struct {
+	spans    []*fakeSpan
+	flushErr error
+}
+
+func (p *fakeProvider) StartSpanFromContext(ctx context.Context, operationName string) (Span, context.Context) {
+	span := &fakeSpan{name: operationName, tags: map[string]interface{}{}}
+	p.spans = append(p.spans, span)
+	return span, ctx
+}
+
+func (p *fakeProvider) SpanIDsFromContext(ctx context.Context) (string, string, bool) {
+	return "fake-trace", "fake-span", true
+}
+
+func (p *fakeProvider) Flush(ctx context.Context) error { return p.flushErr }
+
+func TestMultiProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otelProvider := NewOpenTelemetryProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	fake := &fakeProvider{flushErr: errors.New("flush failed")}
+	SetProvider(NewMultiProvider(otelProvider, fake))
+	t.Cleanup(func() { SetProvider(NewDatadogProvider()) })
+
+	parent, ctx := StartSpanFromContext(context.Background(), "handle.records")
+	child, _ := StartSpanFromContext(ctx, "handle.record")
+	FinishWithOutcome(child, errors.New("oh no"))
+	parent.Finish()
+	traceID, spanID, ok := SpanIDsFromContext(ctx)
+	require.True(t, ok)
+	assert.ErrorContains(t, Flush(context.Background()), "flush failed")
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2, "Every span should be started with every provider")
+	assert.Equal(t, "handle.record", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), attribute.String(OutcomeTag, OutcomeError))
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, spans[1].SpanContext().TraceID().String(), traceID,
+		"IDs should be those of the first provider")
+	assert.Equal(t, spans[1].SpanContext().SpanID().String(), spanID)
+
+	require.Len(t, fake.spans, 2)
+	assert.Equal(t, "handle.record", fake.spans[1].name)
+	assert.Equal(t, OutcomeError, fake.spans[1].tags[OutcomeTag])
+	assert.EqualError(t, fake.spans[1].err, "oh no")
+	assert.NoError(t, fake.spans[0].err)
+}
@@ -0,0 +1,43 @@
+package ffis
+
+import (
+	"time"
+
+	grantsgov "github.com/usdigitalresponse/grants-ingest/pkg/grantsSchemas/grants.gov"
+)
+
+// Names of the FFIS-owned attributes of grant opportunity items in DynamoDB.
+// Grant opportunities are keyed by their grants.gov opportunity ID, so FFIS data is merged
+// into the same items as grants.gov data; these attributes are namespaced so that neither
+// source overwrites attributes written by the other.
+const (
+	DynamoDBAttributeBill           = "ffis_bill"
+	DynamoDBAttributeEligibility    = "ffis_eligibility"
+	DynamoDBAttributeDueDate        = "ffis_due_date"
+	DynamoDBAttributeRollingDueDate = "ffis_rolling_due_date"
+	DynamoDBAttributeOppNumber      = "ffis_opportunity_number"
+)
+
+// DynamoDBDueDateLayout is the layout of the DynamoDBAttributeDueDate attribute value.
+const DynamoDBDueDateLayout = "2006-01-02"
+
+// DynamoDBSource identifies FFIS in the set of sources that contributed to a DynamoDB item.
+const DynamoDBSource = "ffis.org"
+
+// DueDateConflicts returns true when the FFIS due date and the grants.gov close date of the same
+// opportunity (as stored in DynamoDB) are both valid dates that fall on different days.
+// Missing or unparseable dates are not considered conflicting.
+func DueDateConflicts(ffisDueDate, grantsGovCloseDate string) bool {
+	if ffisDueDate == "" || grantsGovCloseDate == "" {
+		return false
+	}
+	due, err := time.Parse(DynamoDBDueDateLayout, ffisDueDate)
+	if err != nil {
+		return false
+	}
+	closes, err := time.Parse(grantsgov.TimeLayoutMMDDYYYYType, grantsGovCloseDate)
+	if err != nil {
+		return false
+	}
+	return !due.Equal(closes)
+}
@@ -0,0 +1,26 @@
+package ffis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDueDateConflicts(t *testing.T) {
+	for _, tt := range []struct {
+		name                          string
+		ffisDueDate, grantsGovClosing string
+		expected                      bool
+	}{
+		{"same day", "2023-05-11", "05112023", false},
+		{"different days", "2023-05-11", "05122023", true},
+		{"missing FFIS due date", "", "05112023", false},
+		{"missing grants.gov close date", "2023-05-11", "", false},
+		{"malformed FFIS due date", "5/11/2023", "05112023", false},
+		{"malformed grants.gov close date", "2023-05-11", "2023-05-11", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DueDateConflicts(tt.ffisDueDate, tt.grantsGovClosing))
+		})
+	}
+}
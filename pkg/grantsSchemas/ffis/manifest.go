@@ -0,0 +1,39 @@
+package ffis
+
+import (
+	"fmt"
+	"time"
+)
+
+// SplitManifestKeyPrefix is the prefix of the object keys of FFIS spreadsheet split manifests.
+const SplitManifestKeyPrefix = "manifests/ffis/"
+
+// SplitManifestKey returns the object key of the manifest for the spreadsheet of the given edition
+// (or, when the edition is unknown, the date on which the spreadsheet was received, e.g. 2023-05-15).
+func SplitManifestKey(edition string) string {
+	return fmt.Sprintf("%s%s.json", SplitManifestKeyPrefix, edition)
+}
+
+// Summarizes the outcome of splitting a single FFIS spreadsheet into per-opportunity objects
+type SplitManifest struct {
+	SourceBucket string               `json:"source_bucket"`
+	SourceKey    string               `json:"source_key"`    // eg. sources/2023/05/15/ffis.org/download.xlsx
+	SourceSHA256 string               `json:"source_sha256"` // Hex-encoded SHA-256 digest of the spreadsheet
+	StartedAt    time.Time            `json:"started_at"`
+	CompletedAt  time.Time            `json:"completed_at"`
+	Error        string               `json:"error,omitempty"`    // Set when the spreadsheet could not be parsed
+	Agencies     map[string]int       `json:"agencies,omitempty"` // Parsed opportunities per normalized agency, eg. {"DOT": 3}
+	Written      []SplitManifestEntry `json:"written"`
+	Unchanged    []SplitManifestEntry `json:"unchanged"` // Opportunities identical to their existing objects
+	Failed       []SplitManifestEntry `json:"failed"`
+}
+
+// Describes the outcome for a single opportunity row of a split FFIS spreadsheet
+type SplitManifestEntry struct {
+	Row     int    `json:"row"`                // 1-indexed spreadsheet row number
+	GrantID int64  `json:"grant_id,omitempty"` // eg. 347509
+	Key     string `json:"key,omitempty"`      // eg. 347/347509/ffis.org/v1.json
+	SHA256  string `json:"sha256,omitempty"`   // Hex-encoded SHA-256 digest of the opportunity JSON
+	Reason  string `json:"reason,omitempty"`   // Why the opportunity failed
+	Status  string `json:"status,omitempty"`   // Status with which the opportunity was marked, eg. deleted
+}
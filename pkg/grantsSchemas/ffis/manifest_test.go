@@ -0,0 +1,62 @@
+package ffis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitManifestKey(t *testing.T) {
+	assert.Equal(t, "manifests/ffis/2023-05-15.json", SplitManifestKey("2023-05-15"))
+}
+
+func TestSplitManifestJSON(t *testing.T) {
+	manifest := SplitManifest{
+		SourceBucket: "source-bucket",
+		SourceKey:    "sources/2023/05/15/ffis.org/download.xlsx",
+		SourceSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		StartedAt:    time.Date(2023, 5, 15, 12, 0, 0, 0, time.UTC),
+		CompletedAt:  time.Date(2023, 5, 15, 12, 0, 3, 0, time.UTC),
+		Agencies:     map[string]int{"DOT": 1, "other": 1},
+		Written: []SplitManifestEntry{
+			{Row: 10, GrantID: 123456, Key: "123/123456/ffis.org/v1.json", SHA256: "abc123"},
+		},
+		Unchanged: []SplitManifestEntry{
+			{Row: 13, GrantID: 512512, Key: "512/512512/ffis.org/v1.json", SHA256: "def456"},
+		},
+		Failed: []SplitManifestEntry{
+			{Row: 14, Reason: "missing grant ID"},
+		},
+	}
+	expected := `{
+		"source_bucket": "source-bucket",
+		"source_key": "sources/2023/05/15/ffis.org/download.xlsx",
+		"source_sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"started_at": "2023-05-15T12:00:00Z",
+		"completed_at": "2023-05-15T12:00:03Z",
+		"agencies": {"DOT": 1, "other": 1},
+		"written": [
+			{"row": 10, "grant_id": 123456, "key": "123/123456/ffis.org/v1.json", "sha256": "abc123"}
+		],
+		"unchanged": [
+			{"row": 13, "grant_id": 512512, "key": "512/512512/ffis.org/v1.json", "sha256": "def456"}
+		],
+		"failed": [
+			{"row": 14, "reason": "missing grant ID"}
+		]
+	}`
+
+	t.Run("to json", func(t *testing.T) {
+		b, err := json.Marshal(manifest)
+		require.NoError(t, err)
+		assert.JSONEq(t, expected, string(b))
+	})
+	t.Run("from json", func(t *testing.T) {
+		var decoded SplitManifest
+		require.NoError(t, json.Unmarshal([]byte(expected), &decoded))
+		assert.Equal(t, manifest, decoded)
+	})
+}
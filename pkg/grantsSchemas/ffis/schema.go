@@ -0,0 +1,21 @@
+package ffis
+
+import (
+	_ "embed"
+
+	"github.com/usdigitalresponse/grants-ingest/internal/jsonschema"
+)
+
+// OpportunityJSONSchema is the JSON Schema describing the JSON representation of
+// FFISFundingOpportunity, as written for each opportunity split from an FFIS spreadsheet.
+//
+//go:embed opportunity.schema.json
+var OpportunityJSONSchema []byte
+
+var opportunitySchema = jsonschema.MustCompile(OpportunityJSONSchema)
+
+// ValidateOpportunityJSON checks that b conforms to OpportunityJSONSchema.
+// Returns a *jsonschema.ValidationError identifying the offending path when it does not.
+func ValidateOpportunityJSON(b []byte) error {
+	return opportunitySchema.Validate(b)
+}
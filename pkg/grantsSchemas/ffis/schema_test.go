@@ -0,0 +1,94 @@
+package ffis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usdigitalresponse/grants-ingest/internal/jsonschema"
+)
+
+func TestValidateOpportunityJSON(t *testing.T) {
+	opp := FFISFundingOpportunity{
+		Agency:             "Forest Service",
+		AssistanceListings: []string{"10.720", "10.727"},
+		Bill:               "Inflation Reduction Act",
+		CFDA:               "10.720",
+		DueDate:            time.Date(2023, 5, 11, 0, 0, 0, 0, time.UTC),
+		EstimatedFunding:   25000000,
+		ExpectedAwards:     "N/A",
+		GrantID:            347509,
+		OppNumber:          "USDA-FS-2020-01",
+		OppTitle:           "FY 2020 Community Connect Grant Program",
+	}
+
+	t.Run("valid opportunity", func(t *testing.T) {
+		b, err := json.Marshal(opp)
+		require.NoError(t, err)
+		assert.NoError(t, ValidateOpportunityJSON(b))
+	})
+
+	t.Run("opportunity with unparsed due date", func(t *testing.T) {
+		withUnparsedDueDate := opp
+		withUnparsedDueDate.DueDate = time.Time{}
+		withUnparsedDueDate.DueDateUnparsed = "Sometime in the fall"
+		b, err := json.Marshal(withUnparsedDueDate)
+		require.NoError(t, err)
+		assert.NoError(t, ValidateOpportunityJSON(b))
+	})
+
+	t.Run("opportunity without assistance listings", func(t *testing.T) {
+		withoutListings := opp
+		withoutListings.AssistanceListings = nil
+		b, err := json.Marshal(withoutListings)
+		require.NoError(t, err)
+		assert.NoError(t, ValidateOpportunityJSON(b))
+	})
+
+	for _, tt := range []struct {
+		name         string
+		modify       func(map[string]interface{})
+		expectedPath string
+	}{
+		{"string flipped to array", func(m map[string]interface{}) {
+			m["bill"] = []string{"Inflation Reduction Act"}
+		}, "#/bill"},
+		{"missing property", func(m map[string]interface{}) {
+			delete(m, "grant_id")
+		}, "#"},
+		{"unexpected property", func(m map[string]interface{}) {
+			m["opportunity_state"] = "posted"
+		}, "#"},
+		{"unrecognized status", func(m map[string]interface{}) {
+			m["opportunity_status"] = "posted"
+		}, "#/opportunity_status"},
+		{"malformed assistance listing", func(m map[string]interface{}) {
+			m["assistance_listings"] = []string{"10.720", "10.72"}
+		}, "#/assistance_listings/1"},
+		{"malformed due date", func(m map[string]interface{}) {
+			m["due_date"] = "5/11/2023"
+		}, "#/due_date"},
+		{"empty unparsed due date", func(m map[string]interface{}) {
+			m["due_date_unparsed"] = ""
+		}, "#/due_date_unparsed"},
+		{"eligibility flipped to string", func(m map[string]interface{}) {
+			m["eligibility"].(map[string]interface{})["state"] = "X"
+		}, "#/eligibility/state"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(opp)
+			require.NoError(t, err)
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal(b, &doc))
+			tt.modify(doc)
+			b, err = json.Marshal(doc)
+			require.NoError(t, err)
+
+			var violation *jsonschema.ValidationError
+			require.ErrorAs(t, ValidateOpportunityJSON(b), &violation)
+			assert.Equal(t, tt.expectedPath, violation.Path)
+		})
+	}
+}
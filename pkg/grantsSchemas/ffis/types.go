@@ -4,25 +4,41 @@ import "time"
 
 // message payload for FFIS download operations
 type FFISMessageDownload struct {
-	SourceFileKey string `json:"sourceFileKey"`
-	DownloadURL   string `json:"downloadUrl"`
+	SourceFileKey string            `json:"sourceFileKey"`
+	DownloadURL   string            `json:"downloadUrl"`
+	Attributes    map[string]string `json:"attributes,omitempty"`    // Attributes exceeding the SQS message attribute limit
+	CompanionKeys []string          `json:"companionKeys,omitempty"` // Keys of companion objects (e.g. errata) referenced by the source email
 }
 
 // Represents a funding opportunity sourced from an FFIS spreadsheet
 type FFISFundingOpportunity struct {
-	Agency           string                 `json:"opportunity_agency"` // eg. Forest Service
-	Bill             string                 `json:"bill"`               // eg. Inflation Reduction Act
-	CFDA             string                 `json:"cfda"`               // eg. 11.525
-	DueDate          time.Time              `json:"due_date"`
-	Eligibility      FFISFundingEligibility `json:"eligibility"`
-	EstimatedFunding int64                  `json:"estimated_funding"` // eg. $25,000,000
-	ExpectedAwards   string                 `json:"expected_awards"`   // eg. 10 or N/A
-	GrantID          int64                  `json:"grant_id"`          // eg. 347509
-	Match            bool                   `json:"match"`
-	OppNumber        string                 `json:"opportunity_number"` // eg. USDA-FS-2020-01
-	OppTitle         string                 `json:"opportunity_title"`  // eg. "FY 2020 Community Connect Grant Program"
+	Agency             string                 `json:"opportunity_agency"`  // eg. Forest Service
+	AssistanceListings []string               `json:"assistance_listings"` // eg. ["10.720", "10.727"]
+	Bill               string                 `json:"bill"`                // eg. Inflation Reduction Act
+	CFDA               string                 `json:"cfda"`                // eg. 11.525
+	DueDate            time.Time              `json:"due_date"`
+	Eligibility        FFISFundingEligibility `json:"eligibility"`
+	EstimatedFunding   int64                  `json:"estimated_funding"` // eg. $25,000,000
+	ExpectedAwards     string                 `json:"expected_awards"`   // eg. 10 or N/A
+	GrantID            int64                  `json:"grant_id"`          // eg. 347509
+	Match              bool                   `json:"match"`
+	OppNumber          string                 `json:"opportunity_number"`           // eg. USDA-FS-2020-01
+	OppTitle           string                 `json:"opportunity_title"`            // eg. "FY 2020 Community Connect Grant Program"
+	RollingDueDate     bool                   `json:"rolling_due_date"`             // True when applications are accepted on a rolling basis
+	DueDateUnparsed    string                 `json:"due_date_unparsed,omitempty"`  // Raw due date value that could not be parsed, if any
+	Status             string                 `json:"opportunity_status,omitempty"` // One of the OpportunityStatus constants, if marked
 }
 
+// Statuses with which FFIS spreadsheets mark opportunities that changed since the previous edition
+const (
+	OpportunityStatusNew      = "new"      // Newly posted; upsert
+	OpportunityStatusModified = "modified" // Changed since it was posted; upsert
+	OpportunityStatusDeleted  = "deleted"  // Removed or cancelled; delete
+	// The opportunity's status markers conflict or are not recognized, so neither an upsert
+	// nor a delete can be assumed
+	OpportunityStatusUnknown = "unknown"
+)
+
 // Elegibility for FFIS funding opportunities as presented in FFIS spreadsheets
 type FFISFundingEligibility struct {
 	HigherEducation bool `json:"higher_education"` // Institutions of Higher Education
@@ -5,6 +5,14 @@ import (
 	"time"
 )
 
+// DynamoDBSource identifies grants.gov in the set of sources that contributed to a DynamoDB item.
+const DynamoDBSource = "grants.gov"
+
+// DynamoDBAttributePrimaryCFDANumber is the name of the DynamoDB item attribute that holds the
+// first of an opportunity's CFDA numbers. Since index keys must be scalar, this attribute
+// (rather than the CFDANumbers list) keys the table's CFDA index.
+const DynamoDBAttributePrimaryCFDANumber = "primary_cfda_number"
+
 const TimeLayoutMMDDYYYYType = "01022006"
 
 func (v MMDDYYYYType) Time() (time.Time, error) {
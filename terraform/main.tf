@@ -274,6 +274,44 @@ module "grants_prepared_dynamodb_table" {
   stream_view_type              = "NEW_AND_OLD_IMAGES"
   enable_point_in_time_recovery = true
   enable_encryption             = true
+
+  dynamodb_attributes = [
+    { name = "AgencyCode", type = "S" },
+    { name = "primary_cfda_number", type = "S" },
+  ]
+  global_secondary_index_map = [
+    {
+      name               = "agency_index"
+      hash_key           = "AgencyCode"
+      range_key          = null
+      projection_type    = "ALL"
+      non_key_attributes = []
+      read_capacity      = null
+      write_capacity     = null
+    },
+    {
+      name               = "cfda_index"
+      hash_key           = "primary_cfda_number"
+      range_key          = null
+      projection_type    = "ALL"
+      non_key_attributes = []
+      read_capacity      = null
+      write_capacity     = null
+    },
+  ]
+}
+
+module "subscriptions_dynamodb_table" {
+  source  = "cloudposse/dynamodb/aws"
+  version = "0.34.0"
+  context = module.this.context
+
+  name                          = "subscriptions"
+  hash_key                      = "subscription_id"
+  table_class                   = "STANDARD"
+  billing_mode                  = "PAY_PER_REQUEST"
+  enable_point_in_time_recovery = true
+  enable_encryption             = true
 }
 
 resource "aws_dynamodb_contributor_insights" "grants_prepared_dynamodb_main" {
@@ -534,6 +572,7 @@ module "ReceiveFFISEmail" {
   email_delivery_object_key_prefix = one(aws_ses_receipt_rule.ffis_ingest.s3_action).object_key_prefix
   grants_source_data_bucket_name   = module.grants_source_data_bucket.bucket_id
   allowed_email_senders            = var.ffis_email_allowed_senders
+  sources                          = var.ffis_email_sources
 
   depends_on = [
     module.email_delivery_bucket,
@@ -542,6 +581,31 @@ module "ReceiveFFISEmail" {
   ]
 }
 
+module "FFISDigestWatchdog" {
+  source = "./modules/FFISDigestWatchdog"
+
+  namespace                                    = var.namespace
+  function_name                                = "FFISDigestWatchdog"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  scheduler_group_name           = try(aws_scheduler_schedule_group.default[0].name, "")
+  grants_source_data_bucket_name = module.grants_source_data_bucket.bucket_id
+  eventbridge_scheduler_enabled  = var.eventbridge_scheduler_enabled
+
+  depends_on = [
+    module.grants_source_data_bucket,
+  ]
+}
+
 module "EnqueueFFISDownload" {
   source = "./modules/EnqueueFFISDownload"
 
@@ -588,6 +652,108 @@ module "PersistGrantsGovXMLDB" {
   grants_prepared_dynamodb_table_arn  = module.grants_prepared_dynamodb_table.table_arn
 }
 
+module "QueryOpportunities" {
+  source = "./modules/QueryOpportunities"
+
+  namespace                                    = var.namespace
+  function_name                                = "QueryOpportunities"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  grants_prepared_dynamodb_table_name = module.grants_prepared_dynamodb_table.table_name
+  grants_prepared_dynamodb_table_arn  = module.grants_prepared_dynamodb_table.table_arn
+}
+
+module "ExportOpportunities" {
+  source = "./modules/ExportOpportunities"
+
+  namespace                                    = var.namespace
+  function_name                                = "ExportOpportunities"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  scheduler_group_name                = try(aws_scheduler_schedule_group.default[0].name, "")
+  eventbridge_scheduler_enabled       = var.eventbridge_scheduler_enabled
+  grants_prepared_dynamodb_table_name = module.grants_prepared_dynamodb_table.table_name
+  grants_prepared_dynamodb_table_arn  = module.grants_prepared_dynamodb_table.table_arn
+  export_bucket_name                  = module.grants_prepared_data_bucket.bucket_id
+
+  depends_on = [
+    module.grants_prepared_data_bucket,
+  ]
+}
+
+module "ReportFFISChanges" {
+  source = "./modules/ReportFFISChanges"
+
+  namespace                                    = var.namespace
+  function_name                                = "ReportFFISChanges"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  scheduler_group_name             = try(aws_scheduler_schedule_group.default[0].name, "")
+  eventbridge_scheduler_enabled    = var.eventbridge_scheduler_enabled
+  grants_prepared_data_bucket_name = module.grants_prepared_data_bucket.bucket_id
+  report_email_recipient           = var.ffis_changes_report_email_recipient
+  report_email_sender              = var.ffis_ingest_email_address
+
+  depends_on = [
+    module.grants_prepared_data_bucket,
+  ]
+}
+
+module "ReconcileFFISPipeline" {
+  source = "./modules/ReconcileFFISPipeline"
+
+  namespace                                    = var.namespace
+  function_name                                = "ReconcileFFISPipeline"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  scheduler_group_name             = try(aws_scheduler_schedule_group.default[0].name, "")
+  eventbridge_scheduler_enabled    = var.eventbridge_scheduler_enabled
+  grants_source_data_bucket_name   = module.grants_source_data_bucket.bucket_id
+  grants_prepared_data_bucket_name = module.grants_prepared_data_bucket.bucket_id
+  repair_enabled                   = var.ffis_reconciliation_repair_enabled
+
+  depends_on = [
+    module.grants_source_data_bucket,
+    module.grants_prepared_data_bucket,
+  ]
+}
+
 module "DownloadFFISSpreadsheet" {
   source = "./modules/DownloadFFISSpreadsheet"
 
@@ -710,3 +876,46 @@ module "PublishGrantEvents" {
     module.grants_prepared_dynamodb_table
   ]
 }
+
+module "PublishOpportunityFeed" {
+  source = "./modules/PublishOpportunityFeed"
+
+  namespace                                    = var.namespace
+  function_name                                = "PublishOpportunityFeed"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  feed_bucket_name = module.grants_prepared_data_bucket.bucket_id
+
+  depends_on = [
+    module.grants_prepared_data_bucket,
+  ]
+}
+
+module "NotifySubscribers" {
+  source = "./modules/NotifySubscribers"
+
+  namespace                                    = var.namespace
+  function_name                                = "NotifySubscribers"
+  permissions_boundary_arn                     = local.permissions_boundary_arn
+  lambda_artifact_bucket                       = module.lambda_artifacts_bucket.bucket_id
+  log_retention_in_days                        = var.lambda_default_log_retention_in_days
+  log_level                                    = var.lambda_default_log_level
+  lambda_autobuild                             = var.lambda_binaries_autobuild
+  lambda_binaries_base_path                    = local.lambda_binaries_base_path
+  lambda_arch                                  = var.lambda_arch
+  additional_environment_variables             = local.lambda_environment_variables
+  additional_lambda_execution_policy_documents = local.lambda_execution_policies
+  lambda_layer_arns                            = local.lambda_layer_arns
+
+  subscriptions_dynamodb_table_name = module.subscriptions_dynamodb_table.table_name
+  subscriptions_dynamodb_table_arn  = module.subscriptions_dynamodb_table.table_arn
+}
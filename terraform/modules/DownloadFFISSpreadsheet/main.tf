@@ -34,11 +34,29 @@ module "lambda_execution_policy" {
   iam_source_policy_documents = var.additional_lambda_execution_policy_documents
   iam_policy_statements = {
     AllowS3DownloadWrite = {
-      effect  = "Allow"
-      actions = ["s3:PutObject"]
+      effect = "Allow"
+      actions = [
+        "s3:PutObject",
+        # Required to verify that the promoted download is readable
+        "s3:GetObject",
+      ]
+      resources = [
+        # Path: /sources/YYYY/mm[/dd[/HH]]/ffis.org/download.xlsx, alongside the email's raw.eml
+        "${data.aws_s3_bucket.download_target.arn}/sources/*/ffis.org/download.xlsx"
+      ]
+    }
+    AllowS3TemporaryDownloads = {
+      effect = "Allow"
+      actions = [
+        "s3:PutObject",
+        "s3:GetObject",
+        "s3:DeleteObject",
+        "s3:ListBucket",
+      ]
       resources = [
-        # Path: /sources/YYYY/mm/dd/ffis.org/download.xlsx
-        "${data.aws_s3_bucket.download_target.arn}/sources/*/*/*/ffis.org/download.xlsx"
+        data.aws_s3_bucket.download_target.arn,
+        # Path: /tmp/<uuid>
+        "${data.aws_s3_bucket.download_target.arn}/tmp/*"
       ]
     }
     AllowSQSGet = {
@@ -37,8 +37,9 @@ module "lambda_execution_policy" {
       effect  = "Allow"
       actions = ["s3:GetObject"]
       resources = [
-        # Path: sources/YYYY/mm/dd/ffis.org/raw.eml
-        "${data.aws_s3_bucket.source_data.arn}/sources/*/*/*/ffis.org/raw.eml"
+        # Path: sources/YYYY/mm[/dd[/HH]]/ffis.org/raw.eml, depending on the key date
+        # granularity of ReceiveFFISEmail (a wildcard matches any number of path segments)
+        "${data.aws_s3_bucket.source_data.arn}/sources/*/ffis.org/raw.eml"
       ]
     }
     AllowSQSPublish = {
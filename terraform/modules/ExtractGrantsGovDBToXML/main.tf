@@ -43,6 +43,8 @@ module "lambda_execution_policy" {
       actions = [
         "s3:PutObject",
         "s3:PutObjectTagging",
+        # Required to verify that the moved extract is readable
+        "s3:GetObject",
       ]
       resources = [
         # Path: sources/YYYY/mm/dd/grants.gov/extract.xml
@@ -0,0 +1,110 @@
+terraform {
+  required_version = "1.5.1"
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.4.0"
+    }
+  }
+}
+
+locals {
+  dd_tags = merge(
+    {
+      for item in compact(split(",", try(var.additional_environment_variables.DD_TAGS, ""))) :
+      split(":", trimspace(item))[0] => try(split(":", trimspace(item))[1], "")
+    },
+    var.datadog_custom_tags,
+    { handlername = lower(var.function_name), },
+  )
+}
+
+data "aws_cloudwatch_event_bus" "source" {
+  name = var.event_bus_name
+}
+
+resource "aws_cloudwatch_event_rule" "opportunity_modified" {
+  name           = "${var.namespace}-${var.function_name}-opportunity-modified"
+  description    = "Grant modification events for created or updated opportunities"
+  event_bus_name = data.aws_cloudwatch_event_bus.source.name
+  event_pattern = jsonencode({
+    source      = ["org.usdigitalresponse.grants-ingest"]
+    detail-type = ["GrantModificationEvent"]
+    detail = {
+      type = ["create", "update"]
+    }
+  })
+}
+
+resource "aws_cloudwatch_event_target" "lambda" {
+  rule           = aws_cloudwatch_event_rule.opportunity_modified.name
+  event_bus_name = data.aws_cloudwatch_event_bus.source.name
+  target_id      = module.lambda_function.lambda_function_name
+  arn            = module.lambda_function.lambda_function_arn
+}
+
+module "lambda_execution_policy" {
+  source  = "cloudposse/iam-policy/aws"
+  version = "1.0.1"
+
+  iam_source_policy_documents = var.additional_lambda_execution_policy_documents
+  iam_policy_statements = {
+    AllowDynamoDBReadWriteSubscriptions = {
+      effect    = "Allow"
+      actions   = ["dynamodb:Scan", "dynamodb:UpdateItem"]
+      resources = [var.subscriptions_dynamodb_table_arn]
+    }
+  }
+}
+
+module "lambda_artifact" {
+  source = "../taskfile_lambda_builder"
+
+  autobuild        = var.lambda_autobuild
+  binary_base_path = var.lambda_binaries_base_path
+  function_name    = var.function_name
+  s3_bucket        = var.lambda_artifact_bucket
+}
+
+module "lambda_function" {
+  source  = "terraform-aws-modules/lambda/aws"
+  version = "5.3.0"
+
+  function_name = "${var.namespace}-${var.function_name}"
+  description   = "Notifies webhook subscribers of created or updated grant opportunities"
+
+  role_permissions_boundary         = var.permissions_boundary_arn
+  attach_cloudwatch_logs_policy     = true
+  cloudwatch_logs_retention_in_days = var.log_retention_in_days
+  attach_policy_json                = true
+  policy_json                       = module.lambda_execution_policy.json
+
+  handler       = "bootstrap"
+  runtime       = "provided.al2"
+  architectures = [var.lambda_arch]
+  publish       = true
+  layers        = var.lambda_layer_arns
+
+  create_package = false
+  s3_existing_package = {
+    bucket = var.lambda_artifact_bucket
+    key    = module.lambda_artifact.s3_object_key
+  }
+
+  timeout = 120
+  environment_variables = merge(var.additional_environment_variables, {
+    BREAKER_COOLDOWN            = var.breaker_cooldown
+    BREAKER_FAILURE_THRESHOLD   = var.breaker_failure_threshold
+    DD_TAGS                     = join(",", sort([for k, v in local.dd_tags : "${k}:${v}"]))
+    LOG_LEVEL                   = var.log_level
+    MAX_DELIVERY_ATTEMPTS       = var.max_delivery_attempts
+    SUBSCRIPTIONS_DYNAMODB_NAME = var.subscriptions_dynamodb_table_name
+  })
+
+  allowed_triggers = {
+    OpportunityModified = {
+      principal  = "events.amazonaws.com"
+      source_arn = aws_cloudwatch_event_rule.opportunity_modified.arn
+    }
+  }
+}
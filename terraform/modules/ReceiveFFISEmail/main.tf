@@ -21,6 +21,43 @@ locals {
   allowed_email_senders = join(",", [
     for v in sort(var.allowed_email_senders) : lower(trimspace(v))
   ])
+
+  sources_config = [
+    for s in var.sources : {
+      name               = s.name
+      keyPrefix          = s.key_prefix
+      validSenders       = s.valid_senders
+      destinationSubpath = s.destination_subpath
+      urlPattern         = s.url_pattern
+      destinationBucket  = s.destination_bucket
+      destinationRoleArn = s.destination_role_arn
+    }
+  ]
+
+  # Destinations to which emails are archived using the function's own execution role.
+  # Sources with a destination role are archived by assuming that role instead.
+  archive_destinations = length(var.sources) == 0 ? [
+    { bucket = var.grants_source_data_bucket_name, subpath = "ffis.org" },
+    ] : [
+    for s in var.sources : {
+      bucket  = coalesce(s.destination_bucket, var.grants_source_data_bucket_name)
+      subpath = trim(s.destination_subpath, "/")
+    } if s.destination_role_arn == null
+  ]
+  # The wildcard matches any number of path segments, i.e. dates of every KEY_DATE_GRANULARITY
+  archived_email_arns = {
+    for prefix in ["sources", "quarantine", "failed"] : prefix => distinct([
+      for d in local.archive_destinations : "arn:aws:s3:::${d.bucket}/${prefix}/*/${d.subpath}/raw.eml"
+    ])
+  }
+  # The grants source data bucket is always included, since it is checked by health checks.
+  archive_bucket_arns = distinct(concat(
+    [data.aws_s3_bucket.grants_source_data.arn],
+    [for d in local.archive_destinations : "arn:aws:s3:::${d.bucket}"],
+  ))
+  destination_role_arns = distinct([
+    for s in var.sources : s.destination_role_arn if s.destination_role_arn != null
+  ])
 }
 
 data "aws_s3_bucket" "email_delivery" {
@@ -36,29 +73,85 @@ module "lambda_execution_policy" {
   version = "1.0.1"
 
   iam_source_policy_documents = var.additional_lambda_execution_policy_documents
-  iam_policy_statements = {
-    AllowS3DownloadNewEmails = {
-      effect = "Allow"
-      actions = [
-        "s3:GetObject",
-        "s3:GetObjectTagging",
-      ]
-      resources = [
-        "${data.aws_s3_bucket.email_delivery.arn}/${trim(var.email_delivery_object_key_prefix, "/")}/*",
-      ]
-    }
-    AllowS3UploadVerifiedEmails = {
-      effect = "Allow"
-      actions = [
-        "s3:PutObject",
-        "s3:PutObjectTagging",
-      ]
-      resources = [
-        # Path: sources/YYYY/mm/dd/ffis.org/raw.eml
-        "${data.aws_s3_bucket.grants_source_data.arn}/sources/*/*/*/ffis.org/raw.eml",
-      ]
-    }
-  }
+  iam_policy_statements = merge(
+    {
+      AllowS3DownloadNewEmails = {
+        effect = "Allow"
+        actions = [
+          "s3:GetObject",
+          "s3:GetObjectTagging",
+        ]
+        resources = distinct(concat(
+          ["${data.aws_s3_bucket.email_delivery.arn}/${trim(var.email_delivery_object_key_prefix, "/")}/*"],
+          [for s in var.sources : "${data.aws_s3_bucket.email_delivery.arn}/${s.key_prefix}*"],
+        ))
+      }
+      AllowS3ListGrantsSourceData = {
+        effect = "Allow"
+        # Required by the health check's HeadBucket request. Without this, requests for
+        # attributes of missing objects also fail with AccessDenied rather than NotFound.
+        actions   = ["s3:ListBucket"]
+        resources = local.archive_bucket_arns
+      }
+      AllowS3VerifyObjectLock = {
+        effect    = "Allow"
+        actions   = ["s3:GetBucketObjectLockConfiguration"]
+        resources = local.archive_bucket_arns
+      }
+    },
+    [
+      for statements in [{
+        AllowS3UploadVerifiedEmails = {
+          effect = "Allow"
+          actions = [
+            "s3:PutObject",
+            "s3:PutObjectTagging",
+          ]
+          # Path: sources/YYYY/mm[/dd[/HH]]/<destination subpath>/raw.eml
+          resources = local.archived_email_arns.sources
+        }
+        AllowS3UploadQuarantinedEmails = {
+          effect = "Allow"
+          actions = [
+            "s3:PutObject",
+            "s3:PutObjectTagging",
+          ]
+          # Path: quarantine/YYYY/mm[/dd[/HH]]/<destination subpath>/raw.eml
+          resources = local.archived_email_arns.quarantine
+        }
+        AllowS3UploadFailedEmails = {
+          effect = "Allow"
+          actions = [
+            "s3:PutObject",
+            "s3:PutObjectTagging",
+          ]
+          # Path: failed/YYYY/mm[/dd[/HH]]/<destination subpath>/raw.eml
+          resources = local.archived_email_arns.failed
+        }
+        AllowS3ReadArchivedEmails = {
+          effect = "Allow"
+          # Required to check whether a retried attempt already archived an email
+          actions   = ["s3:GetObject"]
+          resources = flatten(values(local.archived_email_arns))
+        }
+        AllowS3RetainArchivedEmails = {
+          effect = "Allow"
+          # Required when OBJECT_LOCK_RETAIN_DAYS is configured
+          actions   = ["s3:PutObjectRetention"]
+          resources = flatten(values(local.archived_email_arns))
+        }
+      }] : statements if length(local.archive_destinations) > 0
+    ]...,
+    [
+      for statements in [{
+        AllowAssumeDestinationRoles = {
+          effect    = "Allow"
+          actions   = ["sts:AssumeRole"]
+          resources = local.destination_role_arns
+        }
+      }] : statements if length(local.destination_role_arns) > 0
+    ]...,
+  )
 }
 
 module "lambda_artifact" {
@@ -97,12 +190,19 @@ module "lambda_function" {
 
   timeout     = 30 # seconds
   memory_size = 128
-  environment_variables = merge(var.additional_environment_variables, {
-    DD_TAGS                        = join(",", sort([for k, v in local.dd_tags : "${k}:${v}"]))
-    LOG_LEVEL                      = var.log_level
-    ALLOWED_EMAIL_SENDERS          = local.allowed_email_senders
-    GRANTS_SOURCE_DATA_BUCKET_NAME = data.aws_s3_bucket.grants_source_data.id
-  })
+  environment_variables = merge(
+    var.additional_environment_variables,
+    {
+      DD_TAGS                        = join(",", sort([for k, v in local.dd_tags : "${k}:${v}"]))
+      LOG_LEVEL                      = var.log_level
+      ALLOWED_EMAIL_SENDERS          = local.allowed_email_senders
+      GRANTS_SOURCE_DATA_BUCKET_NAME = data.aws_s3_bucket.grants_source_data.id
+    },
+    [
+      for v in [{ SOURCES_CONFIG = jsonencode(local.sources_config) }] : v
+      if length(local.sources_config) > 0
+    ]...,
+  )
 
   allowed_triggers = {
     S3BucketNotification = {
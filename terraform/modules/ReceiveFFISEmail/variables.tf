@@ -101,3 +101,24 @@ variable "allowed_email_senders" {
     error_message = "At least one domain must be specified or all emails will be rejected."
   }
 }
+
+variable "sources" {
+  description = <<-EOT
+    Email sources to configure with the SOURCES_CONFIG environment variable. When empty, every
+    received email is validated against allowed_email_senders and archived under the ffis.org
+    subpath of the grants source data bucket. A source's destination bucket defaults to the
+    grants source data bucket. When a destination role ARN is given, that role is assumed to
+    archive the source's emails, so it must trust this function's execution role and be allowed
+    to read the email delivery bucket and write to its destination.
+  EOT
+  type = list(object({
+    name                 = optional(string)
+    key_prefix           = string
+    valid_senders        = list(string)
+    destination_subpath  = string
+    url_pattern          = optional(string)
+    destination_bucket   = optional(string)
+    destination_role_arn = optional(string)
+  }))
+  default = []
+}
@@ -38,7 +38,7 @@ module "lambda_execution_policy" {
       actions = ["s3:GetObject"]
       resources = [
         # This path is set by the DownloadFFISSpreadsheet module
-        "${data.aws_s3_bucket.source_data.arn}/sources/*/*/*/ffis.org/download.xlsx"
+        "${data.aws_s3_bucket.source_data.arn}/sources/*/ffis.org/download.xlsx"
       ]
     }
     AllowInspectS3PreparedData = {
@@ -59,6 +59,13 @@ module "lambda_execution_policy" {
         "${data.aws_s3_bucket.prepared_data.arn}/*/*/ffis.org/v1.json"
       ]
     }
+    AllowS3UploadSplitManifests = {
+      effect  = "Allow"
+      actions = ["s3:PutObject"]
+      resources = [
+        "${data.aws_s3_bucket.prepared_data.arn}/manifests/ffis/*.json"
+      ]
+    }
   }
 }
 
@@ -214,6 +214,32 @@ variable "ffis_email_allowed_senders" {
   default     = ["ffis.org"]
 }
 
+variable "ffis_email_sources" {
+  type = list(object({
+    name                 = optional(string)
+    key_prefix           = string
+    valid_senders        = list(string)
+    destination_subpath  = string
+    url_pattern          = optional(string)
+    destination_bucket   = optional(string)
+    destination_role_arn = optional(string)
+  }))
+  description = "Email sources for ReceiveFFISEmail to validate and archive. When empty, all received emails are treated as FFIS emails from ffis_email_allowed_senders."
+  default     = []
+}
+
+variable "ffis_changes_report_email_recipient" {
+  type        = string
+  description = "Email address to which weekly reports of changes between FFIS editions are sent. When empty, reports are only saved to S3."
+  default     = ""
+}
+
+variable "ffis_reconciliation_repair_enabled" {
+  type        = bool
+  description = "If true, the FFIS pipeline reconciliation job re-triggers pipeline stages to repair the gaps it finds."
+  default     = false
+}
+
 variable "dynamodb_contributor_insights_enabled" {
   description = "If false, disable DynamoDB contributor insights in CloudWatch. This should only be false in local development."
   type        = bool